@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// aggregateRefreshInterval is how often a request for a model that no listed
+// backend owns may list the models again, to find models added since
+const aggregateRefreshInterval = time.Minute
+
+// AggregateSource is one backend whose models are listed by an
+// AggregateBackend. Its models are listed with Prefix in front of their names.
+type AggregateSource struct {
+	Name    string
+	Prefix  string
+	Backend Backend
+}
+
+// AggregateBackend merges the model lists of several backends into one, and
+// sends each request to the backend that listed its model, with any prefix
+// removed. Requests for models no backend listed go to the wrapped backend.
+//
+// When two backends list the same name, the one listed first keeps it. With
+// prefixDuplicates set, the other is listed as "<source name>/<model>"
+// instead of being left out, unless its source has a prefix already.
+type AggregateBackend struct {
+	Backend
+	sources          []AggregateSource
+	prefixDuplicates bool
+
+	mu     sync.Mutex
+	owners map[string]aggregateOwner // Listed name -> where requests for it go
+	listed time.Time
+}
+
+// aggregateOwner is the backend a listed model belongs to, and its name there
+type aggregateOwner struct {
+	backend Backend
+	model   string
+}
+
+// NewAggregateBackend creates a wrapper around defaultBackend that lists the
+// models of sources, in order.
+func NewAggregateBackend(defaultBackend Backend, sources []AggregateSource, prefixDuplicates bool) *AggregateBackend {
+	return &AggregateBackend{
+		Backend:          defaultBackend,
+		sources:          sources,
+		prefixDuplicates: prefixDuplicates,
+		owners:           make(map[string]aggregateOwner),
+	}
+}
+
+// ListModels returns the models of every source that answers. It fails only
+// if no source answers.
+func (a *AggregateBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	lists := make([]models.ModelsResponse, len(a.sources))
+	errs := make([]error, len(a.sources))
+	var wg sync.WaitGroup
+	for i, source := range a.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lists[i], errs[i] = source.Backend.ListModels(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var merged []models.ModelInfo
+	owners := make(map[string]aggregateOwner)
+	answered := false
+	for i, source := range a.sources {
+		if errs[i] != nil {
+			log.Printf("Model aggregation: listing models of %s failed: %v", source.Name, errs[i])
+			continue
+		}
+		answered = true
+		for _, model := range lists[i].Models {
+			name := source.Prefix + model.Name
+			if _, taken := owners[name]; taken && a.prefixDuplicates && source.Prefix == "" {
+				name = source.Name + "/" + model.Name
+			}
+			if _, taken := owners[name]; taken {
+				continue
+			}
+			owners[name] = aggregateOwner{backend: source.Backend, model: model.Name}
+			model.Name = name
+			model.Model = name
+			merged = append(merged, model)
+		}
+	}
+	if !answered {
+		return models.ModelsResponse{}, errors.Join(errs...)
+	}
+
+	a.mu.Lock()
+	a.owners = owners
+	a.listed = time.Now()
+	a.mu.Unlock()
+	return models.ModelsResponse{Models: merged}, nil
+}
+
+// resolve returns the backend a request for model goes to and the model's
+// name there. Models that were not listed are matched on their source's
+// prefix; if that fails and the list is old, the models are listed again.
+func (a *AggregateBackend) resolve(ctx context.Context, model string) (Backend, string) {
+	a.mu.Lock()
+	owner, ok := a.owners[model]
+	stale := time.Since(a.listed) >= aggregateRefreshInterval
+	a.mu.Unlock()
+	if ok {
+		return owner.backend, owner.model
+	}
+
+	for _, source := range a.sources {
+		if source.Prefix == "" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(model, source.Prefix); ok && name != "" {
+			return source.Backend, name
+		}
+	}
+
+	if stale {
+		if _, err := a.ListModels(ctx); err == nil {
+			a.mu.Lock()
+			owner, ok = a.owners[model]
+			a.mu.Unlock()
+			if ok {
+				return owner.backend, owner.model
+			}
+		}
+	}
+	return a.Backend, model
+}
+
+// Generate sends a generate request to the backend that lists its model.
+// Responses carry the listed name.
+func (a *AggregateBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	listed := req.Model
+	var b Backend
+	b, req.Model = a.resolve(ctx, listed)
+	respChan, metadata, err := b.Generate(ctx, req)
+	if err != nil || req.Model == listed {
+		return respChan, metadata, err
+	}
+	return rewriteStream(ctx, respChan, func(resp *models.GenerateResponse) { resp.Model = listed }), metadata, nil
+}
+
+// Chat sends a chat request to the backend that lists its model. Responses
+// carry the listed name.
+func (a *AggregateBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	listed := req.Model
+	var b Backend
+	b, req.Model = a.resolve(ctx, listed)
+	respChan, metadata, err := b.Chat(ctx, req)
+	if err != nil || req.Model == listed {
+		return respChan, metadata, err
+	}
+	return rewriteStream(ctx, respChan, func(resp *models.ChatResponse) { resp.Model = listed }), metadata, nil
+}
+
+// Embed sends an embeddings request to the backend that lists its model.
+func (a *AggregateBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	listed := req.Model
+	var b Backend
+	b, req.Model = a.resolve(ctx, listed)
+	resp, metadata, err := b.Embed(ctx, req)
+	if err == nil && req.Model != listed {
+		resp.Model = listed
+	}
+	return resp, metadata, err
+}
+
+// ShowModel describes a model using the backend that lists it.
+func (a *AggregateBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	b, name := a.resolve(ctx, model)
+	return b.ShowModel(ctx, name)
+}
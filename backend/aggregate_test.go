@@ -0,0 +1,105 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+// unlistableBackend is a backend whose model list can't be fetched
+type unlistableBackend struct {
+	*MockBackend
+}
+
+func (u unlistableBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	return models.ModelsResponse{}, errors.New("connection refused")
+}
+
+func listedNames(t *testing.T, b Backend) []string {
+	t.Helper()
+	list, err := b.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	var names []string
+	for _, model := range list.Models {
+		if model.Model != model.Name {
+			t.Errorf("model %q has Model %q, want the same name", model.Name, model.Model)
+		}
+		names = append(names, model.Name)
+	}
+	return names
+}
+
+func TestAggregateBackend(t *testing.T) {
+	primary := NewMockBackend(MockOptions{Reply: "primary", Models: []string{"llama3", "qwen3"}})
+	big := NewMockBackend(MockOptions{Reply: "big", Models: []string{"llama3:70b", "qwen3"}})
+	gpu := NewMockBackend(MockOptions{Reply: "gpu", Models: []string{"llama3"}})
+	a := NewAggregateBackend(primary, []AggregateSource{
+		{Name: "primary", Backend: primary},
+		{Name: "big", Backend: big},
+		{Name: "gpu", Prefix: "gpu/", Backend: gpu},
+	}, false)
+
+	if names := listedNames(t, a); !slices.Equal(names, []string{"llama3", "qwen3", "llama3:70b", "gpu/llama3"}) {
+		t.Fatalf("listed models = %v, want the first qwen3 only and gpu's prefixed", names)
+	}
+
+	chat := func(model string) (string, string) {
+		respChan, _, err := a.Chat(context.Background(), models.ChatRequest{Model: model, Messages: []models.Message{{Role: "user", Content: "hi"}}})
+		if err != nil {
+			t.Fatalf("Chat(%q) error = %v", model, err)
+		}
+		var text, gotModel string
+		for resp := range respChan {
+			text += resp.Message.Content
+			gotModel = resp.Model
+		}
+		return text, gotModel
+	}
+	for model, want := range map[string][2]string{
+		"llama3":     {"primary", "llama3"},
+		"qwen3":      {"primary", "qwen3"},
+		"llama3:70b": {"big", "llama3:70b"},
+		"gpu/llama3": {"gpu", "gpu/llama3"},
+		"gpu/new":    {"gpu", "gpu/new"},
+		"unknown":    {"primary", "unknown"},
+	} {
+		if text, gotModel := chat(model); text != want[0] || gotModel != want[1] {
+			t.Errorf("Chat(%q) = %q from model %q, want %q from model %q", model, text, gotModel, want[0], want[1])
+		}
+	}
+}
+
+func TestAggregateBackendPrefixesDuplicates(t *testing.T) {
+	primary := NewMockBackend(MockOptions{Reply: "primary", Models: []string{"llama3"}})
+	big := NewMockBackend(MockOptions{Reply: "big", Models: []string{"llama3"}})
+	a := NewAggregateBackend(primary, []AggregateSource{
+		{Name: "primary", Backend: primary},
+		{Name: "down", Backend: unlistableBackend{primary}},
+		{Name: "big", Backend: big},
+	}, true)
+
+	if names := listedNames(t, a); !slices.Equal(names, []string{"llama3", "big/llama3"}) {
+		t.Fatalf("listed models = %v, want big's duplicate prefixed", names)
+	}
+	respChan, _, err := a.Generate(context.Background(), models.GenerateRequest{Model: "big/llama3", Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var text string
+	for resp := range respChan {
+		text += resp.Response
+	}
+	if text != "big" {
+		t.Fatalf("Generate(big/llama3) = %q, want big's reply", text)
+	}
+
+	none := NewAggregateBackend(primary, []AggregateSource{{Name: "down", Backend: unlistableBackend{primary}}}, true)
+	if _, err := none.ListModels(context.Background()); err == nil {
+		t.Fatal("ListModels() with no source answering error = nil, want an error")
+	}
+}
@@ -1,7 +1,20 @@
 package backend
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"llm_proxy/models"
 )
@@ -11,6 +24,79 @@ type BackendMetadata struct {
 	URL         string // Full URL called on the backend
 	RawRequest  string // Raw JSON sent to backend
 	RawResponse string // Raw response data received from backend
+	CacheHit    bool   // Served from the response cache without calling the backend
+
+	// StatusCode is the HTTP status of the backend response, RequestID its
+	// request ID header and RateLimit its rate limit headers, one
+	// "name: value" per line (see recordResponse). Zero if the backend was
+	// not reached.
+	StatusCode int
+	RequestID  string
+	RateLimit  string
+
+	// TrimmedMessages describes the messages left out to fit the model's
+	// context window (see ContextTrimBackend); empty if none were
+	TrimmedMessages string
+
+	// ShadowKey links the request to the copy of it sent to the shadow
+	// backend (see ShadowBackend); empty if it wasn't copied
+	ShadowKey string
+
+	// RaceWinner is the name of the backend that answered a raced request
+	// first (see RaceBackend); empty if the request wasn't raced
+	RaceWinner string
+
+	// Usage is the token usage the backend reported, set before the final
+	// response is sent; nil if the backend reported none
+	Usage *models.OpenAIUsage
+
+	// StreamErr is set, before the response channel is closed, if reading
+	// the backend response failed part way through (for example a line
+	// longer than the maximum line size). The responses sent are incomplete
+	// and no final Done response follows.
+	StreamErr error
+}
+
+// DefaultMaxLineSize is the longest line, in bytes, read from a streamed
+// backend response unless changed with SetMaxLineSize. Tool call arguments
+// and base64 images can make single SSE events very large.
+const DefaultMaxLineSize = 16 * 1024 * 1024
+
+// newLineScanner returns a scanner over the lines of a streamed backend
+// response that accepts lines up to maxLineSize bytes
+func newLineScanner(body io.Reader, maxLineSize int) *bufio.Scanner {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, min(64*1024, maxLineSize)), maxLineSize)
+	return scanner
+}
+
+// streamReadError records why reading a streamed response stopped early. It
+// returns nil if err is nil or the request was cancelled, as a client that
+// went away is not a backend failure.
+func streamReadError(ctx context.Context, err error, maxLineSize int) error {
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+	if errors.Is(err, bufio.ErrTooLong) {
+		err = fmt.Errorf("backend sent a line longer than %d KiB (raise backend.max_line_size): %w", maxLineSize/1024, err)
+	} else {
+		err = fmt.Errorf("reading backend response: %w", err)
+	}
+	log.Printf("Backend stream error: %v", err)
+	return err
+}
+
+// countedUsage returns the usage for backends that report token counts
+// rather than an OpenAI usage object, or nil if they reported none
+func countedUsage(promptTokens, completionTokens int) *models.OpenAIUsage {
+	if promptTokens == 0 && completionTokens == 0 {
+		return nil
+	}
+	return &models.OpenAIUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
 }
 
 // Backend defines the interface for different LLM backends
@@ -28,4 +114,147 @@ type Backend interface {
 
 	// ShowModel returns Ollama-compatible metadata for one model
 	ShowModel(ctx context.Context, model string) (models.ShowResponse, error)
+
+	// Embed computes one embedding vector per input, in input order
+	Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error)
+}
+
+// requestIDHeaders are the headers backends return their request ID in, in
+// order of preference
+var requestIDHeaders = []string{"X-Request-Id", "Request-Id", "X-Amzn-Requestid", "X-Goog-Request-Id"}
+
+// recordResponse stores the status code and the request ID and rate limit
+// headers of a backend response.
+func (m *BackendMetadata) recordResponse(resp *http.Response) {
+	m.StatusCode = resp.StatusCode
+	m.RequestID = ""
+	for _, name := range requestIDHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			m.RequestID = value
+			break
+		}
+	}
+
+	var lines []string
+	for name, values := range resp.Header {
+		lower := strings.ToLower(name)
+		if lower == "retry-after" || strings.HasPrefix(lower, "x-ratelimit-") || strings.HasPrefix(lower, "ratelimit") || strings.HasPrefix(lower, "anthropic-ratelimit-") {
+			lines = append(lines, lower+": "+strings.Join(values, ", "))
+		}
+	}
+	sort.Strings(lines)
+	m.RateLimit = strings.Join(lines, "\n")
+}
+
+// StatusError is returned when a backend responds with a non-200 status code.
+type StatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // From the Retry-After header; 0 if absent
+}
+
+// RejectedError is the error of a request the proxy refused to send, e.g.
+// by a hook or routing rule. The client gets its status.
+type RejectedError struct {
+	Status  int    `json:"status"` // HTTP status (0 = 400)
+	Message string `json:"error"`
+}
+
+func (e *RejectedError) Error() string {
+	return e.Message
+}
+
+// StatusCode returns the HTTP status the client gets
+func (e *RejectedError) StatusCode() int {
+	if e.Status < 400 || e.Status > 599 {
+		return http.StatusBadRequest
+	}
+	return e.Status
+}
+
+// newStatusError builds a StatusError from a non-200 backend response.
+func newStatusError(resp *http.Response, body string) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Body:       body,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either as seconds or as
+// an HTTP date. It returns 0 if the header is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return max(time.Until(when), 0)
+	}
+	return 0
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status code: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// rewriteStream relays responses from in, applying rewrite to each.
+func rewriteStream[T any](ctx context.Context, in <-chan T, rewrite func(*T)) <-chan T {
+	out := make(chan T, cap(in))
+	go func() {
+		defer close(out)
+		for resp := range in {
+			rewrite(&resp)
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// setExtraHeaders adds the user-configured headers to a backend request. They
+// are applied last so they can override defaults such as authentication.
+func setExtraHeaders(httpReq *http.Request, headers map[string]string) {
+	for name, value := range headers {
+		httpReq.Header.Set(name, value)
+	}
+}
+
+// imageMIMEType detects the type of a base64-encoded image from its first
+// bytes, falling back to JPEG.
+func imageMIMEType(image string) string {
+	// 24 base64 characters decode to 18 bytes, enough for every image
+	// signature http.DetectContentType knows
+	head, _ := base64.StdEncoding.DecodeString(image[:min(len(image), 24)])
+	if mime := http.DetectContentType(head); strings.HasPrefix(mime, "image/") {
+		return mime
+	}
+	return "image/jpeg"
+}
+
+// parseFormat interprets an Ollama format value. "json" asks for any JSON
+// output; an object is a JSON schema the output must match.
+func parseFormat(format json.RawMessage) (jsonMode bool, schema json.RawMessage) {
+	trimmed := bytes.TrimSpace(format)
+	if len(trimmed) == 0 {
+		return false, nil
+	}
+	if trimmed[0] == '{' {
+		return true, trimmed
+	}
+	var s string
+	if err := json.Unmarshal(trimmed, &s); err == nil && s == "json" {
+		return true, nil
+	}
+	return false, nil
 }
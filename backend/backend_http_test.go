@@ -1,11 +1,14 @@
 package backend
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -14,7 +17,7 @@ import (
 
 func TestOpenAIBackendChatTranslatesRequestAndNonStreamingResponse(t *testing.T) {
 	var gotReq models.OpenAIChatRequest
-	b := NewOpenAIBackend("http://backend.test", 10, true, false)
+	b := NewOpenAIBackend("http://backend.test", 10, "", true, false, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		if r.URL.Path != "/v1/chat/completions" {
 			t.Fatalf("path = %q, want /v1/chat/completions", r.URL.Path)
@@ -72,7 +75,7 @@ func TestOpenAIBackendChatTranslatesRequestAndNonStreamingResponse(t *testing.T)
 
 func TestOpenAIBackendChatPreservesRawOpenAIFields(t *testing.T) {
 	var gotReq map[string]json.RawMessage
-	b := NewOpenAIBackend("http://backend.test", 10, true, false)
+	b := NewOpenAIBackend("http://backend.test", 10, "", true, false, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
 			t.Fatalf("Decode() error = %v", err)
@@ -127,7 +130,7 @@ func TestOpenAIBackendChatPreservesRawOpenAIFields(t *testing.T) {
 
 func TestOpenAIBackendChatPreservesMultimodalMessageContent(t *testing.T) {
 	var gotReq map[string]json.RawMessage
-	b := NewOpenAIBackend("http://backend.test", 10, false, false)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
 			t.Fatalf("Decode() error = %v", err)
@@ -174,8 +177,334 @@ func TestOpenAIBackendChatPreservesMultimodalMessageContent(t *testing.T) {
 	}
 }
 
+func TestOpenAIBackendSendsOllamaImagesAsContentParts(t *testing.T) {
+	const png = "iVBORw0KGgoAAAANSUhEUgAAAAE="
+	var gotPaths []string
+	var gotMessages []json.RawMessage
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		gotPaths = append(gotPaths, r.URL.Path)
+		var req struct {
+			Messages json.RawMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		gotMessages = append(gotMessages, req.Messages)
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"a cat"},"finish_reason":"stop"}]}`), nil
+	})
+
+	chatChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "llava",
+		Messages: []models.Message{{Role: "user", Content: "what is this?", Images: []string{png}}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	for range chatChan {
+	}
+
+	genChan, _, err := b.Generate(context.Background(), models.GenerateRequest{Model: "llava", Prompt: "what is this?", Images: []string{png}})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var response string
+	for resp := range genChan {
+		response += resp.Response
+	}
+
+	if !reflect.DeepEqual(gotPaths, []string{"/v1/chat/completions", "/v1/chat/completions"}) {
+		t.Fatalf("paths = %v, want generate with images sent as chat", gotPaths)
+	}
+	want := `[{"role":"user","content":[{"text":"what is this?","type":"text"},{"image_url":{"url":"data:image/png;base64,` + png + `"},"type":"image_url"}]}]`
+	for _, got := range gotMessages {
+		if string(got) != want {
+			t.Fatalf("messages = %s, want %s", got, want)
+		}
+	}
+	if response != "a cat" {
+		t.Fatalf("generate response = %q", response)
+	}
+}
+
+func TestOpenAIBackendTranslatesFormatToResponseFormat(t *testing.T) {
+	var gotFormats []string
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var req map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		gotFormats = append(gotFormats, string(req["response_format"]))
+		if r.URL.Path == "/v1/completions" {
+			return jsonResponse(`{"choices":[{"text":"{}","finish_reason":"stop"}]}`), nil
+		}
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"{}"},"finish_reason":"stop"}]}`), nil
+	})
+
+	var req models.ChatRequest
+	if err := json.Unmarshal([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"format":{"type":"object","properties":{"age":{"type":"integer"}}}}`), &req); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	chatChan, _, err := b.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	for range chatChan {
+	}
+
+	genChan, _, err := b.Generate(context.Background(), models.GenerateRequest{Model: "m", Prompt: "hi", Format: json.RawMessage(`"json"`)})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for range genChan {
+	}
+
+	want := []string{
+		`{"json_schema":{"name":"response","schema":{"type":"object","properties":{"age":{"type":"integer"}}}},"type":"json_schema"}`,
+		`{"type":"json_object"}`,
+	}
+	if !reflect.DeepEqual(gotFormats, want) {
+		t.Fatalf("response_format = %v, want %v", gotFormats, want)
+	}
+}
+
+func TestOpenAIBackendForwardsToolChoice(t *testing.T) {
+	var gotReq map[string]json.RawMessage
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`), nil
+	})
+
+	var req models.ChatRequest
+	if err := json.Unmarshal([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"tool_choice":{"type":"function","function":{"name":"get_weather"}},"parallel_tool_calls":false}`), &req); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	respChan, _, err := b.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	for range respChan {
+	}
+
+	if got := string(gotReq["tool_choice"]); got != `{"function":{"name":"get_weather"},"type":"function"}` {
+		t.Fatalf("tool_choice = %s", got)
+	}
+	if got := string(gotReq["parallel_tool_calls"]); got != "false" {
+		t.Fatalf("parallel_tool_calls = %s, want false", got)
+	}
+}
+
+func TestOpenAIBackendMapsOllamaOptions(t *testing.T) {
+	options := map[string]interface{}{
+		"num_predict":    float64(-1),
+		"temperature":    float64(0),
+		"top_k":          float64(40),
+		"min_p":          0.05,
+		"seed":           float64(7),
+		"stop":           "\n\n",
+		"repeat_penalty": 1.1,
+		"num_ctx":        float64(8192),
+	}
+
+	for _, passUnknown := range []bool{false, true} {
+		var gotReq map[string]json.RawMessage
+		b := NewOpenAIBackend("http://backend.test", 10, "", false, false, passUnknown, nil)
+		b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`), nil
+		})
+
+		respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+			Model:    "m",
+			Messages: []models.Message{{Role: "user", Content: "hi"}},
+			Options:  options,
+		})
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		for range respChan {
+		}
+
+		want := map[string]string{
+			"temperature":    "0",
+			"top_k":          "40",
+			"min_p":          "0.05",
+			"seed":           "7",
+			"stop":           `["\n\n"]`,
+			"repeat_penalty": "1.1",
+		}
+		for name, value := range want {
+			if got := string(gotReq[name]); got != value {
+				t.Errorf("passUnknown=%v: %s = %s, want %s", passUnknown, name, got, value)
+			}
+		}
+		if _, ok := gotReq["max_tokens"]; ok {
+			t.Errorf("passUnknown=%v: max_tokens sent for num_predict -1", passUnknown)
+		}
+		if got, want := string(gotReq["num_ctx"]), map[bool]string{false: "", true: "8192"}[passUnknown]; got != want {
+			t.Errorf("passUnknown=%v: num_ctx = %q, want %q", passUnknown, got, want)
+		}
+	}
+}
+
+func TestOpenAIBackendPassesVLLMParams(t *testing.T) {
+	for _, vllm := range []bool{false, true} {
+		var gotReq map[string]json.RawMessage
+		b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+		b.SetVLLM(vllm)
+		b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			return jsonResponse(`{"choices":[{"text":"ok","finish_reason":"stop"}]}`), nil
+		})
+
+		respChan, _, err := b.Generate(context.Background(), models.GenerateRequest{
+			Model:   "m",
+			Prompt:  "hi",
+			Options: map[string]interface{}{"best_of": float64(3), "guided_regex": "[0-9]+", "mirostat": float64(1)},
+			Extra: map[string]interface{}{
+				"guided_regex": "[a-z]+",
+				"guided_json":  map[string]interface{}{"type": "object"},
+				"unknown":      true,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		for range respChan {
+		}
+
+		want := map[string]string{"best_of": "3", "guided_regex": `"[a-z]+"`, "guided_json": `{"type":"object"}`}
+		for name, value := range want {
+			if !vllm {
+				value = ""
+			}
+			if got := string(gotReq[name]); got != value {
+				t.Errorf("vllm=%v: %s = %s, want %q", vllm, name, got, value)
+			}
+		}
+		for _, name := range []string{"mirostat", "unknown", "extra"} {
+			if _, ok := gotReq[name]; ok {
+				t.Errorf("vllm=%v: %s sent, want it dropped", vllm, name)
+			}
+		}
+	}
+}
+
+func TestOpenAIBackendTextGenSamplers(t *testing.T) {
+	var gotReq map[string]json.RawMessage
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.SetTextGen(true)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`), nil
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "m",
+		Messages: []models.Message{{Role: "user", Content: "hi"}},
+		Options: map[string]interface{}{
+			"mirostat":       float64(2),
+			"tfs_z":          0.95,
+			"typical_p":      0.9,
+			"repeat_penalty": 1.1,
+			"temperature":    0.7,
+			"top_a":          0.2,
+		},
+		Extra: map[string]interface{}{"dry_multiplier": 0.8},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	for range respChan {
+	}
+
+	want := map[string]string{
+		"mirostat_mode":      "2",
+		"tfs":                "0.95",
+		"typical_p":          "0.9",
+		"repetition_penalty": "1.1",
+		"temperature":        "0.7",
+		"top_a":              "0.2",
+		"dry_multiplier":     "0.8",
+	}
+	for name, value := range want {
+		if got := string(gotReq[name]); got != value {
+			t.Errorf("%s = %s, want %s", name, got, value)
+		}
+	}
+	for _, name := range []string{"mirostat", "tfs_z", "repeat_penalty"} {
+		if _, ok := gotReq[name]; ok {
+			t.Errorf("%s sent under its Ollama name", name)
+		}
+	}
+}
+
+func TestOpenAIBackendOpenRouter(t *testing.T) {
+	var gotReq map[string]json.RawMessage
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.SetOpenRouter(OpenRouterOptions{Provider: map[string]interface{}{"order": []string{"groq"}}, Route: "fallback"})
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		gotReq = nil
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12,"cost":0.0042}}`), nil
+	})
+
+	chat := func(req models.ChatRequest) *BackendMetadata {
+		t.Helper()
+		req.Model = "openai/gpt-4o"
+		req.Messages = []models.Message{{Role: "user", Content: "hi"}}
+		respChan, meta, err := b.Chat(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		for range respChan {
+		}
+		return meta
+	}
+
+	meta := chat(models.ChatRequest{})
+	want := map[string]string{"provider": `{"order":["groq"]}`, "route": `"fallback"`, "usage": `{"include":true}`}
+	for name, value := range want {
+		if got := string(gotReq[name]); got != value {
+			t.Errorf("%s = %s, want %s", name, got, value)
+		}
+	}
+	if meta.Usage == nil || meta.Usage.Cost != 0.0042 {
+		t.Fatalf("Usage = %+v, want the reported cost", meta.Usage)
+	}
+
+	chat(models.ChatRequest{Extra: map[string]interface{}{"provider": map[string]interface{}{"sort": "price"}, "models": []interface{}{"a", "b"}}})
+	if got := string(gotReq["provider"]); got != `{"sort":"price"}` {
+		t.Errorf("provider = %s, want the request's own", got)
+	}
+	if got := string(gotReq["models"]); got != `["a","b"]` {
+		t.Errorf("models = %s, want the request's fallback models", got)
+	}
+
+	// Fields an OpenAI client sent are kept over the defaults
+	chat(models.ChatRequest{OpenAIRaw: map[string]json.RawMessage{"route": json.RawMessage(`"none"`)}})
+	if got := string(gotReq["route"]); got != `"none"` {
+		t.Errorf("route = %s, want the client's own", got)
+	}
+	if got := string(gotReq["usage"]); got != `{"include":true}` {
+		t.Errorf("usage = %s, want usage accounting requested", got)
+	}
+}
+
 func TestOpenAIBackendStreamingChatAccumulatesToolCalls(t *testing.T) {
-	b := NewOpenAIBackend("http://backend.test", 10, false, false)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		body := strings.Join([]string{
 			`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call-1","function":{"name":"lookup","arguments":"{\"ci"}}]}}]}`,
@@ -224,7 +553,7 @@ func TestOpenAIBackendStreamingChatAccumulatesToolCalls(t *testing.T) {
 }
 
 func TestOpenAIBackendStreamingChatCapturesUsageAfterFinish(t *testing.T) {
-	b := NewOpenAIBackend("http://backend.test", 10, false, false)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		body := strings.Join([]string{
 			`data: {"choices":[{"delta":{"content":"pong"},"finish_reason":null}]}`,
@@ -264,9 +593,150 @@ func TestOpenAIBackendStreamingChatCapturesUsageAfterFinish(t *testing.T) {
 	}
 }
 
+func TestOpenAIBackendStreamingGenerateRequestsUsage(t *testing.T) {
+	var gotReq map[string]json.RawMessage
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		body := strings.Join([]string{
+			`data: {"choices":[{"text":"once","finish_reason":null}]}`,
+			`data: {"choices":[{"text":" upon","finish_reason":"length"}]}`,
+			`data: {"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+			`data: [DONE]`,
+			"",
+		}, "\n")
+		return textResponse("text/event-stream", body), nil
+	})
+
+	respChan, _, err := b.Generate(context.Background(), models.GenerateRequest{Model: "test-model", Prompt: "tell", Stream: true})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var final models.GenerateResponse
+	for resp := range respChan {
+		final = resp
+	}
+
+	if got := string(gotReq["stream_options"]); got != `{"include_usage":true}` {
+		t.Fatalf("stream_options = %s, want include_usage", got)
+	}
+	if !final.Done || final.DoneReason != "length" || final.PromptEvalCount != 5 || final.EvalCount != 2 {
+		t.Fatalf("final response = %#v, want usage counts", final)
+	}
+}
+
+func TestOpenAIBackendRawStreamingChatRequestsUsage(t *testing.T) {
+	var gotReq map[string]json.RawMessage
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return textResponse("text/event-stream", "data: [DONE]\n"), nil
+	})
+
+	for _, streamOptions := range []string{"", `{"include_usage":false,"continuous_usage_stats":true}`} {
+		raw := map[string]json.RawMessage{"model": json.RawMessage(`"test-model"`)}
+		if streamOptions != "" {
+			raw["stream_options"] = json.RawMessage(streamOptions)
+		}
+		respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+			Model:     "test-model",
+			Messages:  []models.Message{{Role: "user", Content: "ping"}},
+			Stream:    true,
+			OpenAIRaw: raw,
+		})
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		for range respChan {
+		}
+
+		var got map[string]bool
+		if err := json.Unmarshal(gotReq["stream_options"], &got); err != nil {
+			t.Fatalf("stream_options decode error: %v", err)
+		}
+		if !got["include_usage"] || (streamOptions != "" && !got["continuous_usage_stats"]) {
+			t.Fatalf("stream_options = %s for client options %q", gotReq["stream_options"], streamOptions)
+		}
+	}
+}
+
+func TestOpenAIBackendRecordsCachedPromptTokens(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{"usage details", `{"choices":[{"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":5,"prompt_tokens_details":{"cached_tokens":80}}}`},
+		{"llama.cpp timings", `{"choices":[{"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}],"usage":{"prompt_tokens":100,"completion_tokens":5},"timings":{"cache_n":80,"prompt_n":20}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewOpenAIBackend("http://backend.test", 10, "", true, false, false, nil)
+			b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return jsonResponse(tc.body), nil
+			})
+
+			respChan, meta, err := b.Chat(context.Background(), models.ChatRequest{
+				Model:    "test-model",
+				Messages: []models.Message{{Role: "user", Content: "ping"}},
+			})
+			if err != nil {
+				t.Fatalf("Chat() error = %v", err)
+			}
+			for range respChan {
+			}
+			if meta.Usage == nil || meta.Usage.PromptTokens != 100 || meta.Usage.CachedTokens() != 80 {
+				t.Fatalf("metadata usage = %+v", meta.Usage)
+			}
+		})
+	}
+}
+
+func TestOpenAIBackendStreamsReasoningAsThinking(t *testing.T) {
+	var gotReq map[string]json.RawMessage
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		body := strings.Join([]string{
+			`data: {"choices":[{"delta":{"reasoning_content":"let me think"},"finish_reason":null}]}`,
+			`data: {"choices":[{"delta":{"content":"42"},"finish_reason":null}]}`,
+			`data: {"choices":[{"delta":{},"finish_reason":"stop"}]}`,
+			`data: [DONE]`,
+			"",
+		}, "\n")
+		return textResponse("text/event-stream", body), nil
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "test-model",
+		Stream:   true,
+		Think:    false,
+		Messages: []models.Message{{Role: "user", Content: "ping"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var thinking, content string
+	for resp := range respChan {
+		thinking += resp.Message.Thinking
+		content += resp.Message.Content
+	}
+	if thinking != "let me think" || content != "42" {
+		t.Fatalf("thinking = %q, content = %q", thinking, content)
+	}
+	if got := string(gotReq["chat_template_kwargs"]); got != `{"enable_thinking":false}` {
+		t.Fatalf("chat_template_kwargs = %s", got)
+	}
+}
+
 func TestOllamaBackendChatHandlesLargeStreamingLine(t *testing.T) {
 	largeContent := strings.Repeat("x", 70*1024)
-	b := NewOllamaBackend("http://backend.test", 10)
+	b := NewOllamaBackend("http://backend.test", 10, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		data, err := json.Marshal(models.ChatResponse{
 			Model: "test-model",
@@ -308,6 +778,192 @@ func TestOllamaBackendChatHandlesLargeStreamingLine(t *testing.T) {
 	}
 }
 
+func TestOpenAIBackendStreamingChatReportsLineTooLong(t *testing.T) {
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.SetMaxLineSize(1024)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := `data: {"choices":[{"delta":{"content":"partial"}}]}` + "\n\n" +
+			`data: {"choices":[{"delta":{"content":"` + strings.Repeat("x", 2048) + `"}}]}` + "\n\n" +
+			"data: [DONE]\n\n"
+		return textResponse("text/event-stream", body), nil
+	})
+
+	respChan, meta, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "test-model",
+		Messages: []models.Message{{Role: "user", Content: "large"}},
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var content strings.Builder
+	for resp := range respChan {
+		content.WriteString(resp.Message.Content)
+	}
+	if content.String() != "partial" {
+		t.Fatalf("content = %q, want only the chunk before the long line", content.String())
+	}
+	if !errors.Is(meta.StreamErr, bufio.ErrTooLong) {
+		t.Fatalf("StreamErr = %v, want bufio.ErrTooLong", meta.StreamErr)
+	}
+}
+
+func TestOllamaBackendChatReportsMidStreamError(t *testing.T) {
+	b := NewOllamaBackend("http://backend.test", 10, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := `{"model":"test-model","message":{"role":"assistant","content":"partial"}}` + "\n" +
+			`{"error":"model runner has unexpectedly stopped"}` + "\n"
+		return textResponse("application/x-ndjson", body), nil
+	})
+
+	respChan, meta, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "test-model",
+		Messages: []models.Message{{Role: "user", Content: "hi"}},
+		Stream:   true,
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var responses []models.ChatResponse
+	for resp := range respChan {
+		responses = append(responses, resp)
+	}
+	if len(responses) != 1 || responses[0].Done {
+		t.Fatalf("responses = %#v, want only the partial chunk", responses)
+	}
+	if meta.StreamErr == nil || !strings.Contains(meta.StreamErr.Error(), "model runner has unexpectedly stopped") {
+		t.Fatalf("StreamErr = %v, want the backend's error", meta.StreamErr)
+	}
+}
+
+func TestOpenAIBackendEmbedTranslatesRequestAndOrdersVectors(t *testing.T) {
+	var gotReq models.OpenAIEmbeddingRequest
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Fatalf("path = %q, want /v1/embeddings", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return jsonResponse(`{"object":"list","model":"nomic","data":[{"object":"embedding","index":1,"embedding":[2]},{"object":"embedding","index":0,"embedding":[1]}],"usage":{"prompt_tokens":4,"total_tokens":4}}`), nil
+	})
+
+	resp, meta, err := b.Embed(context.Background(), models.EmbedRequest{
+		Model:      "nomic",
+		Input:      models.EmbedInput{"first", "second"},
+		Dimensions: 64,
+	})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if gotReq.Model != "nomic" || len(gotReq.Input) != 2 || gotReq.Dimensions != 64 {
+		t.Fatalf("translated request = %#v", gotReq)
+	}
+	if len(resp.Embeddings) != 2 || resp.Embeddings[0][0] != 1 || resp.Embeddings[1][0] != 2 {
+		t.Fatalf("embeddings = %v, want ordered by index", resp.Embeddings)
+	}
+	if resp.PromptEvalCount != 4 {
+		t.Fatalf("PromptEvalCount = %d, want 4", resp.PromptEvalCount)
+	}
+	if meta.URL != "http://backend.test/v1/embeddings" {
+		t.Fatalf("metadata URL = %q", meta.URL)
+	}
+}
+
+func TestOpenAIBackendSendsAPIKey(t *testing.T) {
+	var paths []string
+	b := NewOpenAIBackend("http://backend.test", 10, "sk-test", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if got := r.Header.Get("Authorization"); got != "Bearer sk-test" {
+			t.Fatalf("%s Authorization = %q, want Bearer sk-test", r.URL.Path, got)
+		}
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/v1/models" {
+			return jsonResponse(`{"data":[{"id":"gpt-test"}]}`), nil
+		}
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`), nil
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "gpt-test",
+		Messages: []models.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	for range respChan {
+	}
+	if _, err := b.ListModels(context.Background()); err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+
+	if len(paths) != 2 || paths[0] != "/v1/chat/completions" || paths[1] != "/v1/models" {
+		t.Fatalf("paths = %v", paths)
+	}
+}
+
+func TestOpenAIBackendRecordsResponseStatusAndHeaders(t *testing.T) {
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp := jsonResponse(`{"error":{"message":"slow down"}}`)
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Header.Set("X-Request-Id", "req-123")
+		resp.Header.Set("Retry-After", "7")
+		resp.Header.Set("X-Ratelimit-Remaining-Requests", "0")
+		resp.Header.Set("X-Ratelimit-Limit-Requests", "60")
+		return resp, nil
+	})
+
+	_, meta, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "gpt-test",
+		Messages: []models.Message{{Role: "user", Content: "hi"}},
+	})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Chat() error = %v, want a 429 StatusError", err)
+	}
+	if meta.StatusCode != http.StatusTooManyRequests || meta.RequestID != "req-123" {
+		t.Fatalf("status = %d, request ID = %q", meta.StatusCode, meta.RequestID)
+	}
+	want := "retry-after: 7\nx-ratelimit-limit-requests: 60\nx-ratelimit-remaining-requests: 0"
+	if meta.RateLimit != want {
+		t.Fatalf("RateLimit = %q, want %q", meta.RateLimit, want)
+	}
+}
+
+func TestBackendsSendExtraHeaders(t *testing.T) {
+	headers := map[string]string{"X-Portkey-Config": "pc-123", "Authorization": "Bearer override"}
+	check := func(r *http.Request) {
+		if got := r.Header.Get("X-Portkey-Config"); got != "pc-123" {
+			t.Fatalf("%s X-Portkey-Config = %q", r.URL.Path, got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer override" {
+			t.Fatalf("%s Authorization = %q, want configured header to win", r.URL.Path, got)
+		}
+	}
+
+	ollama := NewOllamaBackend("http://backend.test", 10, headers)
+	ollama.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		check(r)
+		return jsonResponse(`{"models":[]}`), nil
+	})
+	if _, err := ollama.ListModels(context.Background()); err != nil {
+		t.Fatalf("Ollama ListModels() error = %v", err)
+	}
+
+	openai := NewOpenAIBackend("http://backend.test", 10, "sk-test", false, false, false, headers)
+	openai.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		check(r)
+		return jsonResponse(`{"data":[{"index":0,"embedding":[0.5]}]}`), nil
+	})
+	if _, _, err := openai.Embed(context.Background(), models.EmbedRequest{Model: "m", Input: []string{"x"}}); err != nil {
+		t.Fatalf("OpenAI Embed() error = %v", err)
+	}
+}
+
 type roundTripFunc func(*http.Request) (*http.Response, error)
 
 func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
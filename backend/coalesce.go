@@ -0,0 +1,159 @@
+package backend
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"llm_proxy/models"
+)
+
+// CoalesceBackend wraps another backend and merges the tiny deltas of
+// streamed generate and chat responses into fewer, larger chunks. Text is
+// held back until at least minChars characters are waiting or flushInterval
+// has passed since the first of them arrived, whichever comes first (0 turns
+// either limit off). Chunks carrying more than text, such as tool calls, and
+// the final chunk are never held back. Non-streaming requests are passed
+// through untouched.
+type CoalesceBackend struct {
+	Backend
+	minChars      int
+	flushInterval time.Duration
+}
+
+// NewCoalesceBackend creates a coalescing wrapper around inner.
+func NewCoalesceBackend(inner Backend, minChars int, flushInterval time.Duration) *CoalesceBackend {
+	return &CoalesceBackend{Backend: inner, minChars: minChars, flushInterval: flushInterval}
+}
+
+// Generate coalesces the streamed response.
+func (b *CoalesceBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	respChan, metadata, err := b.Backend.Generate(ctx, req)
+	if err != nil || !req.Stream {
+		return respChan, metadata, err
+	}
+	return coalesceStream(ctx, respChan, b.minChars, b.flushInterval, streamAccess[models.GenerateResponse]{
+		get: func(resp *models.GenerateResponse) StreamText {
+			return StreamText{Content: resp.Response, Thinking: resp.Thinking}
+		},
+		set: func(resp *models.GenerateResponse, text StreamText) {
+			resp.Response, resp.Thinking = text.Content, text.Thinking
+		},
+		done:     func(resp *models.GenerateResponse) bool { return resp.Done },
+		textOnly: func(resp *models.GenerateResponse) bool { return len(resp.Context) == 0 },
+	}), metadata, nil
+}
+
+// Chat coalesces the streamed reply.
+func (b *CoalesceBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	respChan, metadata, err := b.Backend.Chat(ctx, req)
+	if err != nil || !req.Stream {
+		return respChan, metadata, err
+	}
+	return coalesceStream(ctx, respChan, b.minChars, b.flushInterval, streamAccess[models.ChatResponse]{
+		get: func(resp *models.ChatResponse) StreamText {
+			return StreamText{Content: resp.Message.Content, Thinking: resp.Message.Thinking}
+		},
+		set: func(resp *models.ChatResponse, text StreamText) {
+			resp.Message.Content, resp.Message.Thinking = text.Content, text.Thinking
+		},
+		done: func(resp *models.ChatResponse) bool { return resp.Done },
+		textOnly: func(resp *models.ChatResponse) bool {
+			return len(resp.Message.ToolCalls) == 0 && len(resp.Message.Images) == 0
+		},
+	}), metadata, nil
+}
+
+// coalesceStream relays in, merging the text of consecutive chunks until
+// minChars characters are pending or interval has passed since the first of
+// them. Merged text goes out with the latest chunk's other fields, so a
+// final chunk takes the pending text in front of its own. A chunk that isn't
+// text only is sent on its own after what is pending, and thinking that
+// follows content starts a new chunk so that the two are not reordered.
+func coalesceStream[T any](ctx context.Context, in <-chan T, minChars int, interval time.Duration, access streamAccess[T]) <-chan T {
+	out := make(chan T, cap(in))
+	go func() {
+		defer close(out)
+
+		var pending T
+		hasPending := false
+		var timer *time.Timer
+		var expired <-chan time.Time
+		send := func(resp T) bool {
+			select {
+			case out <- resp:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				expired = nil
+			}
+			if !hasPending {
+				return true
+			}
+			hasPending = false
+			return send(pending)
+		}
+		size := func(resp *T) int {
+			text := access.get(resp)
+			return utf8.RuneCountInString(text.Content) + utf8.RuneCountInString(text.Thinking)
+		}
+
+	loop:
+		for {
+			select {
+			case resp, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if !access.textOnly(&resp) {
+					if !flush() || !send(resp) {
+						break loop
+					}
+					continue
+				}
+				started := !hasPending
+				if hasPending {
+					held, next := access.get(&pending), access.get(&resp)
+					if held.Content != "" && next.Thinking != "" {
+						if !flush() {
+							break loop
+						}
+						started = true
+					} else {
+						access.set(&resp, StreamText{Content: held.Content + next.Content, Thinking: held.Thinking + next.Thinking})
+					}
+				}
+				pending, hasPending = resp, true
+				if started && interval > 0 {
+					if timer == nil {
+						timer = time.NewTimer(interval)
+					} else {
+						timer.Reset(interval)
+					}
+					expired = timer.C
+				}
+				if access.done(&resp) || (minChars > 0 && size(&resp) >= minChars) {
+					if !flush() {
+						break loop
+					}
+				}
+			case <-expired:
+				expired = nil
+				if !flush() {
+					break loop
+				}
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		for range in {
+		}
+	}()
+	return out
+}
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// coalesceChunks streams chunks through a CoalesceBackend and returns what
+// comes out
+func coalesceChunks(t *testing.T, minChars int, interval time.Duration, chunks []models.ChatResponse) []models.ChatResponse {
+	t.Helper()
+	inner := &channelBackend{chat: make(chan models.ChatResponse, len(chunks))}
+	for _, chunk := range chunks {
+		inner.chat <- chunk
+	}
+	close(inner.chat)
+
+	respChan, _, err := NewCoalesceBackend(inner, minChars, interval).Chat(context.Background(), models.ChatRequest{Model: "m", Stream: true})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var out []models.ChatResponse
+	for resp := range respChan {
+		out = append(out, resp)
+	}
+	return out
+}
+
+func contentChunk(content string) models.ChatResponse {
+	return models.ChatResponse{Message: models.Message{Role: "assistant", Content: content}}
+}
+
+func TestCoalesceBackendMergesSmallChunks(t *testing.T) {
+	out := coalesceChunks(t, 7, 0, []models.ChatResponse{
+		contentChunk("He"), contentChunk("llo"), contentChunk(","), contentChunk(" wor"), contentChunk("ld"),
+		{Done: true, DoneReason: "stop", EvalCount: 5},
+	})
+
+	var contents []string
+	for _, resp := range out {
+		contents = append(contents, resp.Message.Content)
+	}
+	if len(out) != 2 || contents[0] != "Hello, wor" || contents[1] != "ld" {
+		t.Fatalf("contents = %q, want [\"Hello, wor\" \"ld\"]", contents)
+	}
+	if final := out[1]; !final.Done || final.DoneReason != "stop" || final.EvalCount != 5 {
+		t.Fatalf("final chunk = %#v", final)
+	}
+}
+
+func TestCoalesceBackendKeepsToolCallsAndOrder(t *testing.T) {
+	toolCall := models.ChatResponse{Message: models.Message{Role: "assistant", ToolCalls: []interface{}{map[string]interface{}{"name": "f"}}}}
+	out := coalesceChunks(t, 100, 0, []models.ChatResponse{
+		{Message: models.Message{Role: "assistant", Thinking: "hm"}},
+		contentChunk("a"),
+		{Message: models.Message{Role: "assistant", Thinking: "more"}},
+		toolCall,
+		contentChunk("b"),
+		{Done: true},
+	})
+
+	if len(out) != 4 {
+		t.Fatalf("got %d chunk(s), want 4: %#v", len(out), out)
+	}
+	if out[0].Message.Thinking != "hm" || out[0].Message.Content != "a" {
+		t.Fatalf("first chunk = %#v", out[0].Message)
+	}
+	if out[1].Message.Thinking != "more" || out[1].Message.Content != "" {
+		t.Fatalf("second chunk = %#v, want the later thinking on its own", out[1].Message)
+	}
+	if len(out[2].Message.ToolCalls) != 1 {
+		t.Fatalf("third chunk = %#v, want the tool call", out[2].Message)
+	}
+	if !out[3].Done || out[3].Message.Content != "b" {
+		t.Fatalf("final chunk = %#v", out[3])
+	}
+}
+
+func TestCoalesceBackendFlushesOnInterval(t *testing.T) {
+	inner := &channelBackend{chat: make(chan models.ChatResponse, 2)}
+	inner.chat <- contentChunk("a")
+	inner.chat <- contentChunk("b")
+
+	respChan, _, err := NewCoalesceBackend(inner, 0, 20*time.Millisecond).Chat(context.Background(), models.ChatRequest{Model: "m", Stream: true})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	select {
+	case resp := <-respChan:
+		if resp.Message.Content != "ab" {
+			t.Fatalf("content = %q, want ab", resp.Message.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending text was not flushed on the interval")
+	}
+	close(inner.chat)
+	if _, ok := <-respChan; ok {
+		t.Fatal("got a chunk after the stream ended, want none")
+	}
+}
+
+func TestCoalesceBackendSkipsNonStreaming(t *testing.T) {
+	inner := &channelBackend{chat: make(chan models.ChatResponse, 3)}
+	inner.chat <- contentChunk("a")
+	inner.chat <- contentChunk("b")
+	inner.chat <- models.ChatResponse{Done: true}
+	close(inner.chat)
+
+	respChan, _, err := NewCoalesceBackend(inner, 100, 0).Chat(context.Background(), models.ChatRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	count := 0
+	for range respChan {
+		count++
+	}
+	if count != 3 {
+		t.Fatalf("got %d chunk(s), want the 3 sent", count)
+	}
+}
@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"context"
+	"log"
+	"regexp"
+
+	"llm_proxy/models"
+)
+
+// ContentFilterRule is a regex replacement applied to request and/or
+// response text.
+type ContentFilterRule struct {
+	Name     string
+	Pattern  *regexp.Regexp
+	Replace  string // May refer to capture groups as $1, ${name}
+	Request  bool   // Apply to prompts, system prompts and chat messages
+	Response bool   // Apply to generated text
+}
+
+// ContentFilterBackend wraps another backend and rewrites the text sent to
+// and received from it with regex rules. Streamed responses are filtered
+// chunk by chunk, so a match split across two chunks is not replaced.
+type ContentFilterBackend struct {
+	Backend
+	rules []ContentFilterRule
+}
+
+// NewContentFilterBackend creates a content-filtering wrapper around inner.
+func NewContentFilterBackend(inner Backend, rules []ContentFilterRule) *ContentFilterBackend {
+	return &ContentFilterBackend{Backend: inner, rules: rules}
+}
+
+// Generate filters the prompt and the generated text.
+func (f *ContentFilterBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	req.Prompt = f.filter(req.Prompt, "request")
+	req.System = f.filter(req.System, "request")
+	respChan, metadata, err := f.Backend.Generate(ctx, req)
+	if err != nil || !f.filtersResponses() {
+		return respChan, metadata, err
+	}
+	return rewriteStream(ctx, respChan, func(resp *models.GenerateResponse) {
+		resp.Response = f.filter(resp.Response, "response")
+	}), metadata, nil
+}
+
+// Chat filters the message contents and the reply.
+func (f *ContentFilterBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	messages := make([]models.Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		if filtered := f.filter(msg.Content, "request"); filtered != msg.Content {
+			msg.SetContent(filtered)
+		}
+		messages[i] = msg
+	}
+	req.Messages = messages
+
+	respChan, metadata, err := f.Backend.Chat(ctx, req)
+	if err != nil || !f.filtersResponses() {
+		return respChan, metadata, err
+	}
+	return rewriteStream(ctx, respChan, func(resp *models.ChatResponse) {
+		resp.Message.Content = f.filter(resp.Message.Content, "response")
+	}), metadata, nil
+}
+
+// filter applies the rules for direction ("request" or "response") to text,
+// logging each rule that matches.
+func (f *ContentFilterBackend) filter(text, direction string) string {
+	if text == "" {
+		return text
+	}
+	for _, rule := range f.rules {
+		if (direction == "request" && !rule.Request) || (direction == "response" && !rule.Response) {
+			continue
+		}
+		matches := rule.Pattern.FindAllStringIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		log.Printf("Content filter %q replaced %d match(es) in %s", rule.Name, len(matches), direction)
+		text = rule.Pattern.ReplaceAllString(text, rule.Replace)
+	}
+	return text
+}
+
+func (f *ContentFilterBackend) filtersResponses() bool {
+	for _, rule := range f.rules {
+		if rule.Response {
+			return true
+		}
+	}
+	return false
+}
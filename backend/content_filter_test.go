@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestContentFilterBackendRewritesRequestAndResponse(t *testing.T) {
+	inner := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	var sent string
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		sent = body.Messages[0].Content
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"ask bob@example.com on db1.internal"},"finish_reason":"stop"}]}`), nil
+	})
+	b := NewContentFilterBackend(inner, []ContentFilterRule{
+		{Name: "hosts", Pattern: regexp.MustCompile(`\b(\w+)\.internal\b`), Replace: "[host]", Request: true, Response: true},
+		{Name: "emails", Pattern: regexp.MustCompile(`\S+@\S+`), Replace: "[email]", Response: true},
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "m",
+		Messages: []models.Message{{Role: "user", Content: "is db1.internal up? mail me at me@example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var content string
+	for resp := range respChan {
+		content += resp.Message.Content
+	}
+
+	// The email rule only applies to responses
+	if sent != "is [host] up? mail me at me@example.com" {
+		t.Fatalf("sent content = %q", sent)
+	}
+	if content != "ask [email] on [host]" {
+		t.Fatalf("response content = %q", content)
+	}
+}
@@ -0,0 +1,281 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"llm_proxy/models"
+)
+
+// Context trim modes for ContextTrimPolicy
+const (
+	ContextTrimDrop      = "drop"      // Leave the trimmed messages out
+	ContextTrimSummarize = "summarize" // Replace them with a summary written by the model
+)
+
+// summaryPrompt instructs the model to summarize trimmed messages
+const summaryPrompt = "Summarize the following earlier part of a conversation so that it can continue without it. " +
+	"Keep facts, decisions, names, file paths, code identifiers and open tasks. Reply with the summary only."
+
+// ContextTrimPolicy controls how ContextTrimBackend fits chat requests into
+// a model's context window.
+type ContextTrimPolicy struct {
+	ContextSizes  map[string]int // Model name ("*" = any other model) -> context window in tokens
+	ReserveTokens int            // Tokens left for the response when the request doesn't set num_predict
+	Mode          string         // ContextTrimDrop or ContextTrimSummarize
+	KeepFirst     bool           // Keep the first non-system message as well as the system messages
+	SummaryTokens int            // Longest summary, in tokens
+}
+
+// contextSize returns the context window of model, or 0 if it has none
+func (p ContextTrimPolicy) contextSize(model string) int {
+	if size, ok := p.ContextSizes[model]; ok {
+		return size
+	}
+	return p.ContextSizes["*"]
+}
+
+// ContextTrimBackend wraps another backend and trims the oldest messages of
+// chat requests that wouldn't fit in the model's context window, leaving
+// room for the response. Token counts are estimates (see estimateTokens).
+// Which messages were trimmed is reported in BackendMetadata.TrimmedMessages.
+type ContextTrimBackend struct {
+	Backend
+	policy ContextTrimPolicy
+}
+
+// NewContextTrimBackend creates a context-trimming wrapper around inner.
+func NewContextTrimBackend(inner Backend, policy ContextTrimPolicy) *ContextTrimBackend {
+	return &ContextTrimBackend{Backend: inner, policy: policy}
+}
+
+// Chat trims the messages to fit the model's context window.
+func (t *ContextTrimBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	var trimmed string
+	if size := t.policy.contextSize(req.Model); size > 0 {
+		req.Messages, trimmed = t.trim(ctx, req, size)
+	}
+	respChan, metadata, err := t.Backend.Chat(ctx, req)
+	if metadata != nil {
+		metadata.TrimmedMessages = trimmed
+	}
+	return respChan, metadata, err
+}
+
+// trim returns the messages to send and a description of those trimmed
+// (empty if none were)
+func (t *ContextTrimBackend) trim(ctx context.Context, req models.ChatRequest, contextSize int) ([]models.Message, string) {
+	reserve := t.policy.ReserveTokens
+	if maxTokens, ok := req.Options["num_predict"].(float64); ok && maxTokens > 0 {
+		reserve = int(maxTokens)
+	}
+	limit := contextSize - reserve
+	total := messagesTokens(req.Messages)
+	if limit <= 0 || total <= limit {
+		return req.Messages, ""
+	}
+
+	summarize := t.policy.Mode == ContextTrimSummarize
+	target := limit
+	if summarize {
+		// Leave room for the summary
+		target -= t.policy.SummaryTokens + perMessageTokens
+	}
+	messages, dropped := DropOldMessages(req.Messages, target, messageTokens, t.policy.KeepFirst)
+	if len(dropped) == 0 {
+		log.Printf("Context trim: %s request of about %d tokens doesn't fit in %d, but no messages can be trimmed", req.Model, total, limit)
+		return req.Messages, ""
+	}
+
+	action := "dropped"
+	if summarize {
+		removed := make([]models.Message, 0, len(dropped))
+		for _, i := range dropped {
+			removed = append(removed, req.Messages[i])
+		}
+		summary, err := t.summarize(ctx, req.Model, removed, contextSize)
+		if err != nil {
+			log.Printf("Context trim: summarizing failed, dropping the messages instead: %v", err)
+		} else {
+			messages = insertSummary(messages, summary)
+			action = "summarized"
+		}
+	}
+
+	description := fmt.Sprintf("%s messages %s of %d (about %d tokens, context %d minus %d reserved)",
+		action, messageRanges(dropped), len(req.Messages), total, contextSize, reserve)
+	if remaining := messagesTokens(messages); remaining > limit {
+		description += fmt.Sprintf("; still about %d tokens", remaining)
+	}
+	log.Printf("Context trim: %s: %s", req.Model, description)
+	return messages, description
+}
+
+// summarize asks the model to summarize messages, keeping the request
+// within contextSize
+func (t *ContextTrimBackend) summarize(ctx context.Context, model string, messages []models.Message, contextSize int) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		fmt.Fprintf(&transcript, "%s: %s", msg.Role, msg.Content)
+		if len(msg.ToolCalls) > 0 {
+			if data, err := json.Marshal(msg.ToolCalls); err == nil {
+				fmt.Fprintf(&transcript, "\ntool calls: %s", data)
+			}
+		}
+		transcript.WriteString("\n\n")
+	}
+	text := transcript.String()
+
+	// Keep the most recent part of a transcript too long to summarize
+	budget := contextSize - t.policy.SummaryTokens - estimateTokens(summaryPrompt) - 2*perMessageTokens
+	if budget <= 0 {
+		return "", fmt.Errorf("context of %d tokens is too small to summarize in", contextSize)
+	}
+	if tokens := estimateTokens(text); tokens > budget {
+		runes := []rune(text)
+		text = string(runes[len(runes)-len(runes)*budget/tokens:])
+	}
+
+	respChan, _, err := t.Backend.Chat(ctx, models.ChatRequest{
+		Model: model,
+		Messages: []models.Message{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: text},
+		},
+		Options: map[string]interface{}{"num_predict": float64(t.policy.SummaryTokens)},
+	})
+	if err != nil {
+		return "", err
+	}
+	var summary strings.Builder
+	for resp := range respChan {
+		summary.WriteString(resp.Message.Content)
+	}
+	if strings.TrimSpace(summary.String()) == "" {
+		return "", fmt.Errorf("the model returned an empty summary")
+	}
+	return strings.TrimSpace(summary.String()), nil
+}
+
+// insertSummary adds the summary of trimmed messages to the first system
+// message, or as a new system message at the start if there is none, since
+// many chat templates only accept a system message first.
+func insertSummary(messages []models.Message, summary string) []models.Message {
+	text := "Summary of the earlier conversation:\n" + summary
+	for i, msg := range messages {
+		if msg.Role == "system" {
+			out := append([]models.Message(nil), messages...)
+			out[i].SetContent(msg.Content + "\n\n" + text)
+			return out
+		}
+	}
+	return append([]models.Message{{Role: "system", Content: text}}, messages...)
+}
+
+// DropOldMessages removes the oldest messages until the size of the rest,
+// measured with size, is at most limit. System messages and the last
+// message (with the tool call it answers) are always kept, and so is the
+// first other message if keepFirst is set. Tool results are dropped along
+// with the message that called the tool. It returns the remaining messages,
+// which may still be over the limit, and the indexes of those dropped.
+func DropOldMessages(messages []models.Message, limit int, size func(models.Message) int, keepFirst bool) ([]models.Message, []int) {
+	keep := make([]bool, len(messages))
+	for i := range keep {
+		keep[i] = true
+	}
+
+	// The last message is kept, and if it is a tool result so are the
+	// message that called the tool and the other results
+	tail := len(messages) - 1
+	for tail > 0 && messages[tail].Role == "tool" {
+		tail--
+	}
+
+	// Messages that may be dropped, oldest first
+	var candidates []int
+	firstKept := false
+	for i, msg := range messages[:max(tail, 0)] {
+		if msg.Role == "system" {
+			continue
+		}
+		if keepFirst && !firstKept {
+			firstKept = true
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+
+	total := 0
+	for _, msg := range messages {
+		total += size(msg)
+	}
+	var dropped []int
+	for _, i := range candidates {
+		if total <= limit {
+			break
+		}
+		if !keep[i] {
+			continue
+		}
+		keep[i] = false
+		total -= size(messages[i])
+		dropped = append(dropped, i)
+		// The tool results that follow belong to the dropped message
+		for j := i + 1; j < tail && messages[j].Role == "tool"; j++ {
+			if keep[j] {
+				keep[j] = false
+				total -= size(messages[j])
+				dropped = append(dropped, j)
+			}
+		}
+	}
+
+	trimmed := make([]models.Message, 0, len(messages)-len(dropped))
+	for i, msg := range messages {
+		if keep[i] {
+			trimmed = append(trimmed, msg)
+		}
+	}
+	return trimmed, dropped
+}
+
+// messageTokens estimates the tokens in a message: its content, thinking and
+// tool calls plus the chat template around it. Images are not counted.
+func messageTokens(msg models.Message) int {
+	text := msg.Content + msg.Thinking
+	if len(msg.ToolCalls) > 0 {
+		if data, err := json.Marshal(msg.ToolCalls); err == nil {
+			text += string(data)
+		}
+	}
+	return estimateTokens(text) + perMessageTokens
+}
+
+func messagesTokens(messages []models.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += messageTokens(msg)
+	}
+	return total
+}
+
+// messageRanges formats message indexes as 1-based ranges, e.g. "2-5, 7"
+func messageRanges(indexes []int) string {
+	var parts []string
+	for i := 0; i < len(indexes); {
+		j := i
+		for j+1 < len(indexes) && indexes[j+1] == indexes[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprint(indexes[i]+1))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", indexes[i]+1, indexes[j]+1))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
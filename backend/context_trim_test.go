@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"llm_proxy/models"
+)
+
+func TestDropOldMessages(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	messages := []models.Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "task " + long},
+		{Role: "assistant", ToolCalls: []interface{}{map[string]interface{}{"id": "1"}}},
+		{Role: "tool", Content: long, ToolCallID: "1"},
+		{Role: "assistant", Content: long},
+		{Role: "user", Content: "next"},
+		{Role: "assistant", ToolCalls: []interface{}{map[string]interface{}{"id": "2"}}},
+		{Role: "tool", Content: "result", ToolCallID: "2"},
+	}
+	size := func(msg models.Message) int {
+		n := utf8.RuneCountInString(msg.Content)
+		if len(msg.ToolCalls) > 0 {
+			data, _ := json.Marshal(msg.ToolCalls)
+			n += len(data)
+		}
+		return n
+	}
+	roles := func(messages []models.Message) string {
+		var parts []string
+		for _, msg := range messages {
+			parts = append(parts, msg.Role)
+		}
+		return strings.Join(parts, ",")
+	}
+
+	tests := []struct {
+		name        string
+		limit       int
+		keepFirst   bool
+		wantRoles   string
+		wantDropped int
+	}{
+		{name: "fits", limit: 1000, wantRoles: "system,user,assistant,tool,assistant,user,assistant,tool", wantDropped: 0},
+		// Dropping the tool call drops its result with it
+		{name: "drop oldest", limit: 200, wantRoles: "system,assistant,user,assistant,tool", wantDropped: 3},
+		{name: "keep first", limit: 200, keepFirst: true, wantRoles: "system,user,user,assistant,tool", wantDropped: 3},
+		// The final tool result and its call are never dropped
+		{name: "too small", limit: 10, wantRoles: "system,assistant,tool", wantDropped: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trimmed, dropped := DropOldMessages(messages, tt.limit, size, tt.keepFirst)
+			if got := roles(trimmed); got != tt.wantRoles || len(dropped) != tt.wantDropped {
+				t.Fatalf("DropOldMessages() = %s, %d dropped; want %s, %d dropped", got, len(dropped), tt.wantRoles, tt.wantDropped)
+			}
+		})
+	}
+}
+
+// trimSpyBackend records the chat requests it receives and replies with
+// reply
+type trimSpyBackend struct {
+	channelBackend
+	reply    string
+	requests []models.ChatRequest
+}
+
+func (s *trimSpyBackend) Chat(_ context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	s.requests = append(s.requests, req)
+	ch := make(chan models.ChatResponse, 1)
+	ch <- models.ChatResponse{Message: models.Message{Role: "assistant", Content: s.reply}, Done: true}
+	close(ch)
+	return ch, &BackendMetadata{}, nil
+}
+
+func TestContextTrimBackendDropsOldestMessages(t *testing.T) {
+	spy := &trimSpyBackend{reply: "ok"}
+	b := NewContextTrimBackend(spy, ContextTrimPolicy{
+		ContextSizes:  map[string]int{"small": 100, "*": 10000},
+		ReserveTokens: 20,
+		Mode:          ContextTrimDrop,
+	})
+	messages := []models.Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: strings.Repeat("a", 400)},
+		{Role: "assistant", Content: strings.Repeat("b", 400)},
+		{Role: "user", Content: "latest question"},
+	}
+
+	// A model with a large context is left alone
+	_, meta, err := b.Chat(context.Background(), models.ChatRequest{Model: "large", Messages: messages})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if len(spy.requests[0].Messages) != 4 || meta.TrimmedMessages != "" {
+		t.Fatalf("large model: %d messages sent, trimmed %q", len(spy.requests[0].Messages), meta.TrimmedMessages)
+	}
+
+	// About 220 estimated tokens don't fit in 100 minus the 20 reserved
+	_, meta, err = b.Chat(context.Background(), models.ChatRequest{Model: "small", Messages: messages})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	sent := spy.requests[1].Messages
+	if len(sent) != 2 || sent[0].Role != "system" || sent[1].Content != "latest question" {
+		t.Fatalf("sent messages = %+v", sent)
+	}
+	if !strings.HasPrefix(meta.TrimmedMessages, "dropped messages 2-3 of 4 ") {
+		t.Fatalf("TrimmedMessages = %q", meta.TrimmedMessages)
+	}
+}
+
+func TestContextTrimBackendSummarizesTrimmedMessages(t *testing.T) {
+	spy := &trimSpyBackend{reply: "they discussed a and b"}
+	b := NewContextTrimBackend(spy, ContextTrimPolicy{
+		ContextSizes:  map[string]int{"small": 200},
+		ReserveTokens: 20,
+		Mode:          ContextTrimSummarize,
+		SummaryTokens: 30,
+	})
+
+	_, meta, err := b.Chat(context.Background(), models.ChatRequest{Model: "small", Messages: []models.Message{
+		{Role: "user", Content: strings.Repeat("a", 800)},
+		{Role: "assistant", Content: strings.Repeat("b", 800)},
+		{Role: "user", Content: "latest question"},
+	}})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if len(spy.requests) != 2 {
+		t.Fatalf("got %d backend requests, want the summary request and the chat", len(spy.requests))
+	}
+	// The transcript is too long for the context, so only its end is sent
+	if summaryReq := spy.requests[0]; summaryReq.Options["num_predict"] != float64(30) ||
+		!strings.HasSuffix(summaryReq.Messages[1].Content, "bbbb\n\n") || estimateTokens(summaryReq.Messages[1].Content) > 200 {
+		t.Fatalf("summary request = %+v", summaryReq)
+	}
+	sent := spy.requests[1].Messages
+	if len(sent) != 2 || sent[0].Role != "system" || !strings.HasSuffix(sent[0].Content, "they discussed a and b") || sent[1].Content != "latest question" {
+		t.Fatalf("sent messages = %+v", sent)
+	}
+	if !strings.HasPrefix(meta.TrimmedMessages, "summarized messages 1-2 of 3 ") {
+		t.Fatalf("TrimmedMessages = %q", meta.TrimmedMessages)
+	}
+}
+
+func TestMessageRanges(t *testing.T) {
+	if got := messageRanges([]int{1, 2, 3, 4, 6, 9, 10}); got != "2-5, 7, 10-11" {
+		t.Fatalf("messageRanges() = %q", got)
+	}
+}
@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decompressTransport asks the backend for gzip or deflate compressed
+// responses and decompresses them, so backends always read plain bodies.
+// Go's transport only does this for gzip, and not at all once a request
+// sets its own Accept-Encoding (e.g. through backend.headers).
+type decompressTransport struct {
+	base http.RoundTripper
+}
+
+// newDecompressTransport wraps base, or the default transport if nil
+func newDecompressTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &decompressTransport{base: base}
+}
+
+func (t *decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		// A RoundTripper must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	decompressResponse(resp)
+	return resp, nil
+}
+
+// decompressResponse replaces a gzip or deflate encoded body with one that
+// decodes it. Other encodings are left alone.
+func decompressResponse(resp *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var newReader func(io.Reader) (io.Reader, error)
+	switch encoding {
+	case "gzip", "x-gzip":
+		newReader = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		newReader = newDeflateReader
+	default:
+		return
+	}
+	resp.Body = &decompressedBody{body: resp.Body, encoding: encoding, newReader: newReader}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// newDeflateReader reads a "deflate" body. The HTTP spec means zlib-wrapped
+// data, but some servers send raw deflate, so the zlib header is checked for.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decompressedBody decodes a compressed response body. The decoder is only
+// created on the first read, since reading the gzip header would otherwise
+// wait for a streaming backend's first chunk before the response is returned.
+type decompressedBody struct {
+	body      io.ReadCloser
+	encoding  string
+	newReader func(io.Reader) (io.Reader, error)
+	reader    io.Reader
+	err       error
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		b.reader, b.err = b.newReader(b.body)
+		if b.err != nil {
+			b.err = fmt.Errorf("failed to decompress %s response: %w", b.encoding, b.err)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+func (b *decompressedBody) Close() error {
+	return b.body.Close()
+}
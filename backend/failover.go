@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// FailoverTarget is one backend in a failover chain. Name is only used for
+// logging and the health report.
+type FailoverTarget struct {
+	Name    string
+	Backend Backend
+}
+
+// BackendHealth is the last known health of one backend in a failover chain.
+type BackendHealth struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// FailoverBackend sends each request to the first healthy backend in its
+// chain. If that backend cannot be reached or answers with a 5xx status, it
+// is marked unhealthy and the request is retried on the next one. Unhealthy
+// backends are only tried after all healthy ones have failed, and are marked
+// healthy again by the next successful request or health probe.
+//
+// Failover only happens before a response starts: once a backend has
+// returned a response channel, errors while streaming are passed through.
+type FailoverBackend struct {
+	mu      sync.RWMutex
+	targets []FailoverTarget
+	health  []BackendHealth
+}
+
+// NewFailoverBackend creates a failover chain. The first target is the
+// primary; the rest are fallbacks in priority order.
+func NewFailoverBackend(targets []FailoverTarget) *FailoverBackend {
+	health := make([]BackendHealth, len(targets))
+	for i, target := range targets {
+		health[i] = BackendHealth{Name: target.Name, Healthy: true}
+	}
+	return &FailoverBackend{targets: targets, health: health}
+}
+
+// Generate forwards to the first backend that accepts the request.
+func (f *FailoverBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	var metadata *BackendMetadata
+	respChan, err := failoverCall(ctx, f, func(b Backend) (<-chan models.GenerateResponse, error) {
+		var ch <-chan models.GenerateResponse
+		var err error
+		ch, metadata, err = b.Generate(ctx, req)
+		return ch, err
+	})
+	return respChan, metadata, err
+}
+
+// Chat forwards to the first backend that accepts the request.
+func (f *FailoverBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	var metadata *BackendMetadata
+	respChan, err := failoverCall(ctx, f, func(b Backend) (<-chan models.ChatResponse, error) {
+		var ch <-chan models.ChatResponse
+		var err error
+		ch, metadata, err = b.Chat(ctx, req)
+		return ch, err
+	})
+	return respChan, metadata, err
+}
+
+// ListModels returns the model list of the first backend that answers.
+func (f *FailoverBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	return failoverCall(ctx, f, func(b Backend) (models.ModelsResponse, error) {
+		return b.ListModels(ctx)
+	})
+}
+
+// ShowModel returns model metadata from the first backend that answers.
+func (f *FailoverBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	return failoverCall(ctx, f, func(b Backend) (models.ShowResponse, error) {
+		return b.ShowModel(ctx, model)
+	})
+}
+
+// Embed forwards to the first backend that accepts the request.
+func (f *FailoverBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	var metadata *BackendMetadata
+	resp, err := failoverCall(ctx, f, func(b Backend) (models.EmbedResponse, error) {
+		var resp models.EmbedResponse
+		var err error
+		resp, metadata, err = b.Embed(ctx, req)
+		return resp, err
+	})
+	return resp, metadata, err
+}
+
+// Health returns a snapshot of every backend's health, primary first.
+func (f *FailoverBackend) Health() []BackendHealth {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]BackendHealth(nil), f.health...)
+}
+
+// StartHealthChecks probes every backend immediately and then once per
+// interval until done is closed. A probe is a model list request, which all
+// backend types support and which does not load a model.
+func (f *FailoverBackend) StartHealthChecks(interval time.Duration, timeout time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	f.probeAll(timeout)
+	for {
+		select {
+		case <-ticker.C:
+			f.probeAll(timeout)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (f *FailoverBackend) probeAll(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for i, target := range f.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, err := target.Backend.ListModels(ctx)
+			f.record(i, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// order returns target indexes to try: healthy backends in configured order,
+// then unhealthy ones as a last resort.
+func (f *FailoverBackend) order() []int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	order := make([]int, 0, len(f.targets))
+	for i := range f.targets {
+		if f.health[i].Healthy {
+			order = append(order, i)
+		}
+	}
+	for i := range f.targets {
+		if !f.health[i].Healthy {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// record updates the health of target i after a request or probe. Only
+// errors that would trigger failover mark a backend unhealthy.
+func (f *FailoverBackend) record(i int, err error) {
+	healthy := err == nil || !isFailoverError(err)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := &f.health[i]
+	if h.Healthy != healthy {
+		if healthy {
+			log.Printf("Failover: backend %s is healthy again", h.Name)
+		} else {
+			log.Printf("Failover: backend %s marked unhealthy: %v", h.Name, err)
+		}
+	}
+	h.Healthy = healthy
+	h.LastCheck = time.Now()
+	h.LastError = ""
+	if !healthy {
+		h.LastError = err.Error()
+	}
+}
+
+func failoverCall[T any](ctx context.Context, f *FailoverBackend, call func(Backend) (T, error)) (T, error) {
+	var result T
+	var err error
+	for _, i := range f.order() {
+		result, err = call(f.targets[i].Backend)
+		if ctx.Err() != nil {
+			return result, err
+		}
+		f.record(i, err)
+		if err == nil || !isFailoverError(err) {
+			return result, err
+		}
+		log.Printf("Failover: backend %s failed: %v", f.targets[i].Name, err)
+	}
+	return result, err
+}
+
+// isFailoverError reports whether err means the backend is down rather than
+// that the request itself was bad: a connection failure or a 5xx response.
+func isFailoverError(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return isConnectionError(err)
+}
+
+// isConnectionError reports whether err is a transport-level failure
+// (refused, reset, DNS, timeout) rather than a cancelled request.
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
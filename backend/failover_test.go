@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestFailoverBackendRetriesOnConnectionErrorAnd5xx(t *testing.T) {
+	down := NewOllamaBackend("http://down.test", 10, nil)
+	down.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	broken := NewOllamaBackend("http://broken.test", 10, nil)
+	broken.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusBadGateway,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("bad gateway")),
+		}, nil
+	})
+	var calls int
+	healthy := NewOllamaBackend("http://healthy.test", 10, nil)
+	healthy.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return jsonResponse(`{"model":"llama3","response":"hi","done":true}` + "\n"), nil
+	})
+
+	f := NewFailoverBackend([]FailoverTarget{
+		{Name: "primary", Backend: down},
+		{Name: "secondary", Backend: broken},
+		{Name: "tertiary", Backend: healthy},
+	})
+
+	respChan, meta, err := f.Generate(context.Background(), models.GenerateRequest{Model: "llama3", Prompt: "hello"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var text string
+	for resp := range respChan {
+		text += resp.Response
+	}
+	if text != "hi" || meta.URL != "http://healthy.test/api/generate" {
+		t.Fatalf("text = %q, URL = %q, want response from the healthy backend", text, meta.URL)
+	}
+
+	health := f.Health()
+	if health[0].Healthy || health[1].Healthy || !health[2].Healthy {
+		t.Fatalf("health = %+v, want only tertiary healthy", health)
+	}
+	if !strings.Contains(health[1].LastError, "502") {
+		t.Fatalf("secondary LastError = %q", health[1].LastError)
+	}
+
+	// Unhealthy backends are skipped while a healthy one remains.
+	if _, _, err := f.Generate(context.Background(), models.GenerateRequest{Model: "llama3"}); err != nil {
+		t.Fatalf("second Generate() error = %v", err)
+	}
+	if got := f.order(); got[0] != 2 {
+		t.Fatalf("order() = %v, want healthy backend first", got)
+	}
+	if calls != 2 {
+		t.Fatalf("healthy backend calls = %d, want 2", calls)
+	}
+}
+
+func TestFailoverBackendDoesNotRetryClientErrors(t *testing.T) {
+	var secondaryCalls int
+	primary := NewOllamaBackend("http://primary.test", 10, nil)
+	primary.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"error":"model not found"}`)),
+		}, nil
+	})
+	secondary := NewOllamaBackend("http://secondary.test", 10, nil)
+	secondary.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		secondaryCalls++
+		return jsonResponse(`{"models":[]}`), nil
+	})
+
+	f := NewFailoverBackend([]FailoverTarget{
+		{Name: "primary", Backend: primary},
+		{Name: "secondary", Backend: secondary},
+	})
+
+	_, _, err := f.Embed(context.Background(), models.EmbedRequest{Model: "missing", Input: []string{"x"}})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Embed() error = %v, want 404 StatusError", err)
+	}
+	if secondaryCalls != 0 {
+		t.Fatalf("secondary calls = %d, want 0", secondaryCalls)
+	}
+	if !f.Health()[0].Healthy {
+		t.Fatal("primary marked unhealthy after a 4xx")
+	}
+
+	// A health probe that fails marks the backend down until a later probe
+	// succeeds.
+	primary.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	f.probeAll(time.Second)
+	if health := f.Health(); health[0].Healthy || !health[1].Healthy || health[0].LastCheck.IsZero() {
+		t.Fatalf("health = %+v, want primary down after probe", health)
+	}
+}
@@ -0,0 +1,847 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// GeminiSafetySetting is one Gemini safety filter override, e.g.
+// {Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"}.
+type GeminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+// GeminiBackend implements the Backend interface for the Google Gemini API
+type GeminiBackend struct {
+	endpoint       string
+	apiKey         string
+	safetySettings []GeminiSafetySetting
+	headers        map[string]string
+	client         *http.Client
+	contexts       *generateContexts
+	maxLineSize    int
+}
+
+// NewGeminiBackend creates a new Gemini backend. safetySettings are sent with
+// every request unless the client supplies options.safety_settings. headers
+// are added to every backend request.
+func NewGeminiBackend(endpoint string, timeout int, apiKey string, safetySettings []GeminiSafetySetting, headers map[string]string) *GeminiBackend {
+	return &GeminiBackend{
+		endpoint:       strings.TrimRight(endpoint, "/"),
+		apiKey:         apiKey,
+		safetySettings: safetySettings,
+		headers:        headers,
+		contexts:       newGenerateContexts(),
+		maxLineSize:    DefaultMaxLineSize,
+		client: &http.Client{
+			Timeout:   time.Duration(timeout) * time.Second,
+			Transport: newDecompressTransport(nil),
+		},
+	}
+}
+
+// SetTransport replaces the HTTP transport used for backend requests; nil
+// uses the default transport. Compressed responses are still decompressed.
+func (g *GeminiBackend) SetTransport(transport http.RoundTripper) {
+	g.client.Transport = newDecompressTransport(transport)
+}
+
+// SetMaxLineSize sets the longest line, in bytes, accepted from a streamed
+// response. A longer line ends the stream with an error.
+func (g *GeminiBackend) SetMaxLineSize(size int) {
+	g.maxLineSize = size
+}
+
+// Gemini wire types
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []interface{} `json:"functionDeclarations"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig geminiFunctionCallingConfig `json:"functionCallingConfig"`
+}
+
+type geminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      *float64              `json:"temperature,omitempty"`
+	TopP             *float64              `json:"topP,omitempty"`
+	TopK             *int                  `json:"topK,omitempty"`
+	MaxOutputTokens  int                   `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string              `json:"stopSequences,omitempty"`
+	ResponseMimeType string                `json:"responseMimeType,omitempty"`
+	ResponseSchema   json.RawMessage       `json:"responseJsonSchema,omitempty"`
+	ThinkingConfig   *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type geminiThinkingConfig struct {
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	SafetySettings    interface{}             `json:"safetySettings,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// modelURL builds the URL for a model method, e.g. ":generateContent".
+func (g *GeminiBackend) modelURL(model string, method string) string {
+	return g.endpoint + "/v1beta/models/" + url.PathEscape(strings.TrimPrefix(model, "models/")) + method
+}
+
+// post sends a JSON body to the Gemini API and validates the status code,
+// recording the raw error body on failure.
+func (g *GeminiBackend) post(ctx context.Context, target string, data []byte, metadata *BackendMetadata) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	g.setHeaders(httpReq)
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	metadata.recordResponse(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		metadata.RawResponse = string(body)
+		return nil, newStatusError(resp, string(body))
+	}
+	return resp, nil
+}
+
+func (g *GeminiBackend) setHeaders(httpReq *http.Request) {
+	if g.apiKey != "" {
+		httpReq.Header.Set("x-goog-api-key", g.apiKey)
+	}
+	setExtraHeaders(httpReq, g.headers)
+}
+
+// Generate handles text generation requests by translating to
+// generateContent. The generate context is emulated, since the API is
+// stateless.
+func (g *GeminiBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	req.Prompt = g.contexts.expand(ctx, req.Context, req.Prompt)
+	req.Context = nil
+	respChan, metadata, err := g.generate(ctx, req)
+	if err != nil {
+		return respChan, metadata, err
+	}
+	return g.contexts.track(ctx, req.Prompt, respChan), metadata, nil
+}
+
+func (g *GeminiBackend) generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	respChan := make(chan models.GenerateResponse, 10)
+	metadata := &BackendMetadata{}
+
+	geminiReq := geminiRequest{
+		Contents:         []geminiContent{{Role: "user", Parts: append([]geminiPart{{Text: req.Prompt}}, geminiImageParts(req.Images)...)}},
+		GenerationConfig: geminiGenerationConfigFromOptions(req.Options, req.Format, req.Think),
+		SafetySettings:   g.safetySettingsFor(req.Options),
+	}
+	if req.System != "" {
+		geminiReq.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: req.System}}}
+	}
+
+	data, err := json.Marshal(geminiReq)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	metadata.RawRequest = string(data)
+	metadata.URL = g.methodURL(req.Model, req.Stream)
+
+	resp, err := g.post(ctx, metadata.URL, data, metadata)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, err
+	}
+
+	go func() {
+		defer resp.Body.Close()
+		defer close(respChan)
+
+		startTime := time.Now()
+		final := models.GenerateResponse{Model: req.Model, Done: true, DoneReason: "stop"}
+		err := g.readResponses(ctx, resp.Body, req.Stream, metadata, func(chunk geminiResponse) bool {
+			text, thinking, _ := geminiCandidateParts(chunk)
+			if reason := geminiDoneReason(chunk); reason != "" {
+				final.DoneReason = reason
+			}
+			if chunk.UsageMetadata != nil {
+				final.PromptEvalCount = chunk.UsageMetadata.PromptTokenCount
+				final.EvalCount = chunk.UsageMetadata.CandidatesTokenCount
+			}
+			if text == "" && thinking == "" {
+				return true
+			}
+			select {
+			case respChan <- models.GenerateResponse{Model: req.Model, CreatedAt: time.Now(), Response: text, Thinking: thinking}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if metadata.StreamErr != nil {
+			// A response that broke off ends without a final response
+			return
+		}
+		if err != nil {
+			final.DoneReason = "error"
+		}
+		final.CreatedAt = time.Now()
+		final.TotalDuration = time.Since(startTime).Nanoseconds()
+		final.EvalDuration = final.TotalDuration
+		metadata.Usage = countedUsage(final.PromptEvalCount, final.EvalCount)
+		respChan <- final
+	}()
+
+	return respChan, metadata, nil
+}
+
+// Chat handles chat requests by translating messages and tools to Gemini contents
+func (g *GeminiBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	respChan := make(chan models.ChatResponse, 10)
+	metadata := &BackendMetadata{}
+
+	contents, system := convertMessagesToGemini(req.Messages)
+	geminiReq := geminiRequest{
+		Contents:          contents,
+		SystemInstruction: system,
+		Tools:             convertToolsToGemini(req.Tools),
+		ToolConfig:        convertToolChoiceToGemini(req.ToolChoice),
+		GenerationConfig:  geminiGenerationConfigFromOptions(req.Options, req.Format, req.Think),
+		SafetySettings:    g.safetySettingsFor(req.Options),
+	}
+
+	data, err := json.Marshal(geminiReq)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	metadata.RawRequest = string(data)
+	metadata.URL = g.methodURL(req.Model, req.Stream)
+
+	resp, err := g.post(ctx, metadata.URL, data, metadata)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, err
+	}
+
+	go func() {
+		defer resp.Body.Close()
+		defer close(respChan)
+
+		startTime := time.Now()
+		final := models.ChatResponse{
+			Model:      req.Model,
+			Message:    models.Message{Role: "assistant"},
+			Done:       true,
+			DoneReason: "stop",
+		}
+		err := g.readResponses(ctx, resp.Body, req.Stream, metadata, func(chunk geminiResponse) bool {
+			text, thinking, toolCalls := geminiCandidateParts(chunk)
+			if reason := geminiDoneReason(chunk); reason != "" {
+				final.DoneReason = reason
+			}
+			if chunk.UsageMetadata != nil {
+				final.PromptEvalCount = chunk.UsageMetadata.PromptTokenCount
+				final.EvalCount = chunk.UsageMetadata.CandidatesTokenCount
+				final.Usage = &models.OpenAIUsage{
+					PromptTokens:     chunk.UsageMetadata.PromptTokenCount,
+					CompletionTokens: chunk.UsageMetadata.CandidatesTokenCount,
+					TotalTokens:      chunk.UsageMetadata.TotalTokenCount,
+				}
+			}
+			if text == "" && thinking == "" && len(toolCalls) == 0 {
+				return true
+			}
+			msg := models.ChatResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now(),
+				Message: models.Message{
+					Role:      "assistant",
+					Content:   text,
+					Thinking:  thinking,
+					ToolCalls: toolCalls,
+				},
+			}
+			select {
+			case respChan <- msg:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if metadata.StreamErr != nil {
+			// A response that broke off ends without a final response
+			return
+		}
+		if err != nil {
+			final.DoneReason = "error"
+		}
+		final.CreatedAt = time.Now()
+		final.TotalDuration = time.Since(startTime).Nanoseconds()
+		final.EvalDuration = final.TotalDuration
+		metadata.Usage = countedUsage(final.PromptEvalCount, final.EvalCount)
+		respChan <- final
+	}()
+
+	return respChan, metadata, nil
+}
+
+func (g *GeminiBackend) methodURL(model string, stream bool) string {
+	if stream {
+		return g.modelURL(model, ":streamGenerateContent") + "?alt=sse"
+	}
+	return g.modelURL(model, ":generateContent")
+}
+
+// readResponses decodes either a single generateContent response or an SSE
+// stream of them, calling handle for each. The raw body is stored on
+// metadata. handle returns false to stop early.
+func (g *GeminiBackend) readResponses(ctx context.Context, body io.Reader, stream bool, metadata *BackendMetadata, handle func(geminiResponse) bool) error {
+	if !stream {
+		bodyBytes, err := io.ReadAll(body)
+		metadata.RawResponse = string(bodyBytes)
+		if err != nil {
+			metadata.StreamErr = streamReadError(ctx, err, g.maxLineSize)
+			return err
+		}
+		var chunk geminiResponse
+		if err := json.Unmarshal(bodyBytes, &chunk); err != nil {
+			err = fmt.Errorf("failed to decode response: %w", err)
+			metadata.StreamErr = streamReadError(ctx, err, g.maxLineSize)
+			return err
+		}
+		handle(chunk)
+		return nil
+	}
+
+	var rawResponse strings.Builder
+	defer func() { metadata.RawResponse = rawResponse.String() }()
+
+	scanner := newLineScanner(body, g.maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		rawResponse.WriteString(line)
+		rawResponse.WriteString("\n")
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+			continue
+		}
+		if !handle(chunk) || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	metadata.StreamErr = streamReadError(ctx, scanner.Err(), g.maxLineSize)
+	return scanner.Err()
+}
+
+// safetySettingsFor returns the client-supplied options.safety_settings if
+// present, otherwise the configured defaults.
+func (g *GeminiBackend) safetySettingsFor(options map[string]interface{}) interface{} {
+	if options != nil {
+		if settings, ok := options["safety_settings"]; ok {
+			return settings
+		}
+	}
+	if len(g.safetySettings) == 0 {
+		return nil
+	}
+	return g.safetySettings
+}
+
+// geminiGenerationConfigFromOptions maps Ollama options to generationConfig
+func geminiGenerationConfigFromOptions(options map[string]interface{}, format json.RawMessage, think interface{}) *geminiGenerationConfig {
+	cfg := &geminiGenerationConfig{}
+	if temp, ok := options["temperature"].(float64); ok {
+		cfg.Temperature = &temp
+	}
+	if topP, ok := options["top_p"].(float64); ok {
+		cfg.TopP = &topP
+	}
+	if topK, ok := options["top_k"].(float64); ok {
+		k := int(topK)
+		cfg.TopK = &k
+	}
+	if maxTokens, ok := options["num_predict"].(float64); ok && maxTokens > 0 {
+		cfg.MaxOutputTokens = int(maxTokens)
+	}
+	if stop, ok := options["stop"].([]interface{}); ok {
+		for _, s := range stop {
+			if str, ok := s.(string); ok {
+				cfg.StopSequences = append(cfg.StopSequences, str)
+			}
+		}
+	}
+	if jsonMode, schema := parseFormat(format); jsonMode {
+		cfg.ResponseMimeType = "application/json"
+		cfg.ResponseSchema = schema
+	}
+	cfg.ThinkingConfig = geminiThinkingConfigFor(think)
+	if cfg.Temperature == nil && cfg.TopP == nil && cfg.TopK == nil && cfg.MaxOutputTokens == 0 &&
+		len(cfg.StopSequences) == 0 && cfg.ResponseMimeType == "" && cfg.ThinkingConfig == nil {
+		return nil
+	}
+	return cfg
+}
+
+// geminiThinkingConfigFor translates Ollama's think flag. Enabling thinking
+// (true or a level) asks Gemini to return thought summaries; false sets the
+// thinking budget to zero, which turns thinking off on models that allow it.
+func geminiThinkingConfigFor(think interface{}) *geminiThinkingConfig {
+	switch t := think.(type) {
+	case bool:
+		if !t {
+			budget := 0
+			return &geminiThinkingConfig{ThinkingBudget: &budget}
+		}
+		return &geminiThinkingConfig{IncludeThoughts: true}
+	case string:
+		return &geminiThinkingConfig{IncludeThoughts: true}
+	default:
+		return nil
+	}
+}
+
+// convertMessagesToGemini translates Ollama chat messages to Gemini contents.
+// System messages become the system instruction; assistant tool calls become
+// functionCall parts; tool results become functionResponse parts, named from
+// the matching call (by tool_call_id, else positionally).
+func convertMessagesToGemini(messages []models.Message) ([]geminiContent, *geminiContent) {
+	var contents []geminiContent
+	var systemParts []geminiPart
+	callNames := make(map[string]string)
+	var pendingNames []string
+
+	appendContent := func(role string, parts ...geminiPart) {
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			return
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: parts})
+	}
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			systemParts = append(systemParts, geminiPart{Text: msg.Content})
+
+		case "assistant":
+			pendingNames = nil
+			var parts []geminiPart
+			if msg.Content != "" {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				tcMap, ok := tc.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				fn, _ := tcMap["function"].(map[string]interface{})
+				name, _ := fn["name"].(string)
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{
+					Name: name,
+					Args: toolCallArgsObject(fn["arguments"]),
+				}})
+				if id, ok := tcMap["id"].(string); ok && id != "" {
+					callNames[id] = name
+				}
+				pendingNames = append(pendingNames, name)
+			}
+			if len(parts) > 0 {
+				appendContent("model", parts...)
+			}
+
+		case "tool":
+			name := callNames[msg.ToolCallID]
+			if name == "" && len(pendingNames) > 0 {
+				name = pendingNames[0]
+			}
+			if len(pendingNames) > 0 {
+				pendingNames = pendingNames[1:]
+			}
+			appendContent("user", geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     name,
+				Response: toolResultObject(msg.Content),
+			}})
+
+		default:
+			pendingNames = nil
+			appendContent("user", append([]geminiPart{{Text: msg.Content}}, geminiImageParts(msg.Images)...)...)
+		}
+	}
+
+	if len(systemParts) == 0 {
+		return contents, nil
+	}
+	return contents, &geminiContent{Parts: systemParts}
+}
+
+// toolCallArgsObject normalizes tool call arguments (object or JSON string)
+// to the object form Gemini expects.
+func toolCallArgsObject(args interface{}) map[string]interface{} {
+	switch typed := args.(type) {
+	case map[string]interface{}:
+		return typed
+	case string:
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(typed), &obj); err == nil {
+			return obj
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// toolResultObject wraps a tool result as the object Gemini requires for
+// functionResponse.response, keeping JSON-object results as-is.
+func toolResultObject(content string) map[string]interface{} {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &obj); err == nil && obj != nil {
+		return obj
+	}
+	return map[string]interface{}{"content": content}
+}
+
+// convertToolsToGemini turns OpenAI/Ollama-style function tools into a single
+// Gemini tool holding all function declarations.
+func convertToolsToGemini(tools []interface{}) []geminiTool {
+	var declarations []interface{}
+	for _, tool := range tools {
+		toolMap, ok := tool.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fn, ok := toolMap["function"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		decl := map[string]interface{}{"name": fn["name"]}
+		if desc, ok := fn["description"]; ok {
+			decl["description"] = desc
+		}
+		if params, ok := fn["parameters"]; ok {
+			decl["parameters"] = params
+		}
+		declarations = append(declarations, decl)
+	}
+	if len(declarations) == 0 {
+		return nil
+	}
+	return []geminiTool{{FunctionDeclarations: declarations}}
+}
+
+// convertToolChoiceToGemini maps an OpenAI tool_choice to a Gemini function
+// calling mode: auto to AUTO, none to NONE, and required or a specific
+// function to ANY (restricted to that function).
+func convertToolChoiceToGemini(choice interface{}) *geminiToolConfig {
+	var cfg geminiFunctionCallingConfig
+	switch c := choice.(type) {
+	case string:
+		switch c {
+		case "auto":
+			cfg.Mode = "AUTO"
+		case "none":
+			cfg.Mode = "NONE"
+		case "required":
+			cfg.Mode = "ANY"
+		default:
+			return nil
+		}
+	case map[string]interface{}:
+		fn, _ := c["function"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		if name == "" {
+			return nil
+		}
+		cfg.Mode = "ANY"
+		cfg.AllowedFunctionNames = []string{name}
+	default:
+		return nil
+	}
+	return &geminiToolConfig{FunctionCallingConfig: cfg}
+}
+
+// geminiImageParts converts Ollama base64 images to inline data parts. Data
+// URLs are unpacked; remote URLs can't be sent inline and are skipped.
+func geminiImageParts(images []string) []geminiPart {
+	var parts []geminiPart
+	for _, image := range images {
+		mime := ""
+		if rest, ok := strings.CutPrefix(image, "data:"); ok {
+			header, data, found := strings.Cut(rest, ";base64,")
+			if !found {
+				continue
+			}
+			mime, image = header, data
+		} else if strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+			continue
+		} else {
+			mime = imageMIMEType(image)
+		}
+		parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: mime, Data: image}})
+	}
+	return parts
+}
+
+// geminiCandidateParts splits the first candidate's parts into answer text,
+// thought text, and Ollama-format tool calls.
+func geminiCandidateParts(resp geminiResponse) (text string, thinking string, toolCalls []interface{}) {
+	if len(resp.Candidates) == 0 {
+		return "", "", nil
+	}
+	var textBuilder, thinkingBuilder strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			args := part.FunctionCall.Args
+			if args == nil {
+				args = map[string]interface{}{}
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id": generateToolCallID(),
+				"function": map[string]interface{}{
+					"name":      part.FunctionCall.Name,
+					"arguments": args,
+				},
+			})
+		case part.Thought:
+			thinkingBuilder.WriteString(part.Text)
+		default:
+			textBuilder.WriteString(part.Text)
+		}
+	}
+	return textBuilder.String(), thinkingBuilder.String(), toolCalls
+}
+
+// geminiDoneReason maps a Gemini finishReason (or prompt block) to an Ollama
+// done_reason. It returns "" while the response is still in progress.
+func geminiDoneReason(resp geminiResponse) string {
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		return strings.ToLower(resp.PromptFeedback.BlockReason)
+	}
+	if len(resp.Candidates) == 0 {
+		return ""
+	}
+	switch reason := resp.Candidates[0].FinishReason; reason {
+	case "":
+		return ""
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	default:
+		return strings.ToLower(reason)
+	}
+}
+
+// ListModels returns the models available to the configured API key
+func (g *GeminiBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", g.endpoint+"/v1beta/models?pageSize=1000", nil)
+	if err != nil {
+		return models.ModelsResponse{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	g.setHeaders(httpReq)
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return models.ModelsResponse{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return models.ModelsResponse{}, newStatusError(resp, string(body))
+	}
+
+	var geminiModels struct {
+		Models []struct {
+			Name                       string   `json:"name"`
+			InputTokenLimit            int      `json:"inputTokenLimit"`
+			SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&geminiModels); err != nil {
+		return models.ModelsResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	var modelsResp models.ModelsResponse
+	for _, m := range geminiModels.Models {
+		name := strings.TrimPrefix(m.Name, "models/")
+		info := models.ModelInfo{
+			Name:          name,
+			Model:         name,
+			ContextLength: m.InputTokenLimit,
+			Details: models.ModelDetails{
+				Family:        "gemini",
+				ContextLength: m.InputTokenLimit,
+			},
+		}
+		for _, method := range m.SupportedGenerationMethods {
+			switch method {
+			case "generateContent":
+				info.Capabilities = append(info.Capabilities, "completion", "tools")
+			case "embedContent":
+				info.Capabilities = append(info.Capabilities, "embedding")
+			}
+		}
+		modelsResp.Models = append(modelsResp.Models, info)
+	}
+	return modelsResp, nil
+}
+
+// ShowModel returns metadata for one Gemini model
+func (g *GeminiBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	modelsResp, err := g.ListModels(ctx)
+	if err != nil {
+		return models.ShowResponse{}, err
+	}
+
+	for _, m := range modelsResp.Models {
+		if m.Name != strings.TrimPrefix(model, "models/") {
+			continue
+		}
+		modelInfo := map[string]interface{}{}
+		if m.ContextLength > 0 {
+			modelInfo["context_length"] = m.ContextLength
+		}
+		return models.ShowResponse{
+			Details:      m.Details,
+			ModelInfo:    modelInfo,
+			Capabilities: m.Capabilities,
+		}, nil
+	}
+	return models.ShowResponse{}, fmt.Errorf("model not found: %s", model)
+}
+
+// Embed translates an embeddings request to batchEmbedContents
+func (g *GeminiBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	metadata := &BackendMetadata{}
+
+	modelName := "models/" + strings.TrimPrefix(req.Model, "models/")
+	type embedContentRequest struct {
+		Model                string        `json:"model"`
+		Content              geminiContent `json:"content"`
+		OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+	}
+	batch := struct {
+		Requests []embedContentRequest `json:"requests"`
+	}{}
+	for _, input := range req.Input {
+		batch.Requests = append(batch.Requests, embedContentRequest{
+			Model:                modelName,
+			Content:              geminiContent{Parts: []geminiPart{{Text: input}}},
+			OutputDimensionality: req.Dimensions,
+		})
+	}
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	metadata.RawRequest = string(data)
+	metadata.URL = g.modelURL(req.Model, ":batchEmbedContents")
+
+	resp, err := g.post(ctx, metadata.URL, data, metadata)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to read response: %w", err)
+	}
+	metadata.RawResponse = string(body)
+
+	var geminiResp struct {
+		Embeddings []struct {
+			Values []float64 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.Unmarshal(body, &geminiResp); err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(geminiResp.Embeddings) != len(req.Input) {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("backend returned %d embeddings for %d inputs", len(geminiResp.Embeddings), len(req.Input))
+	}
+
+	embedResp := models.EmbedResponse{Model: req.Model}
+	for _, e := range geminiResp.Embeddings {
+		embedResp.Embeddings = append(embedResp.Embeddings, e.Values)
+	}
+	return embedResp, metadata, nil
+}
@@ -0,0 +1,213 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestGeminiBackendChatConvertsToolsAndFunctionCalls(t *testing.T) {
+	var gotReq map[string]interface{}
+	b := NewGeminiBackend("http://gemini.test/", 10, "test-key", []GeminiSafetySetting{
+		{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"},
+	}, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:generateContent" {
+			t.Fatalf("path = %q", r.URL.Path)
+		}
+		if got := r.Header.Get("x-goog-api-key"); got != "test-key" {
+			t.Fatalf("x-goog-api-key = %q", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return jsonResponse(`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Leeds"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":3,"totalTokenCount":15}}`), nil
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model: "gemini-2.5-flash",
+		Messages: []models.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "weather in york?"},
+			{Role: "assistant", ToolCalls: []interface{}{
+				map[string]interface{}{"id": "call_1", "function": map[string]interface{}{"name": "get_weather", "arguments": `{"city":"York"}`}},
+			}},
+			{Role: "tool", ToolCallID: "call_1", Content: `{"temp":11}`},
+			{Role: "user", Content: "and leeds?"},
+		},
+		Tools: []interface{}{
+			map[string]interface{}{"type": "function", "function": map[string]interface{}{
+				"name":        "get_weather",
+				"description": "Get the weather",
+				"parameters":  map[string]interface{}{"type": "object"},
+			}},
+		},
+		Options: map[string]interface{}{"temperature": float64(0.2), "num_predict": float64(64)},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var responses []models.ChatResponse
+	for resp := range respChan {
+		responses = append(responses, resp)
+	}
+
+	// Request translation
+	if got := gotReq["systemInstruction"]; !reflect.DeepEqual(got, map[string]interface{}{"parts": []interface{}{map[string]interface{}{"text": "be brief"}}}) {
+		t.Fatalf("systemInstruction = %#v", got)
+	}
+	contents := gotReq["contents"].([]interface{})
+	// The tool result and the following user message share one user turn
+	// so roles alternate as Gemini expects.
+	if len(contents) != 3 {
+		t.Fatalf("contents = %#v, want 3 entries", contents)
+	}
+	modelTurn := contents[1].(map[string]interface{})
+	call := modelTurn["parts"].([]interface{})[0].(map[string]interface{})["functionCall"].(map[string]interface{})
+	if modelTurn["role"] != "model" || call["name"] != "get_weather" || call["args"].(map[string]interface{})["city"] != "York" {
+		t.Fatalf("model turn = %#v", modelTurn)
+	}
+	toolTurn := contents[2].(map[string]interface{})
+	fnResp := toolTurn["parts"].([]interface{})[0].(map[string]interface{})["functionResponse"].(map[string]interface{})
+	if toolTurn["role"] != "user" || len(toolTurn["parts"].([]interface{})) != 2 || fnResp["name"] != "get_weather" || fnResp["response"].(map[string]interface{})["temp"] != float64(11) {
+		t.Fatalf("tool turn = %#v", toolTurn)
+	}
+	decls := gotReq["tools"].([]interface{})[0].(map[string]interface{})["functionDeclarations"].([]interface{})
+	if len(decls) != 1 || decls[0].(map[string]interface{})["name"] != "get_weather" {
+		t.Fatalf("functionDeclarations = %#v", decls)
+	}
+	genCfg := gotReq["generationConfig"].(map[string]interface{})
+	if genCfg["temperature"] != 0.2 || genCfg["maxOutputTokens"] != float64(64) {
+		t.Fatalf("generationConfig = %#v", genCfg)
+	}
+	safety := gotReq["safetySettings"].([]interface{})
+	if len(safety) != 1 || safety[0].(map[string]interface{})["threshold"] != "BLOCK_ONLY_HIGH" {
+		t.Fatalf("safetySettings = %#v", safety)
+	}
+
+	// Response translation
+	if len(responses) != 2 {
+		t.Fatalf("responses = %#v, want tool call chunk and final chunk", responses)
+	}
+	toolCalls := responses[0].Message.ToolCalls
+	if len(toolCalls) != 1 {
+		t.Fatalf("tool calls = %#v", toolCalls)
+	}
+	fn := toolCalls[0].(map[string]interface{})["function"].(map[string]interface{})
+	if fn["name"] != "get_weather" || fn["arguments"].(map[string]interface{})["city"] != "Leeds" {
+		t.Fatalf("tool call = %#v", fn)
+	}
+	final := responses[1]
+	if !final.Done || final.DoneReason != "stop" || final.PromptEvalCount != 12 || final.EvalCount != 3 {
+		t.Fatalf("final = %#v", final)
+	}
+}
+
+func TestGeminiBackendStreamingGenerate(t *testing.T) {
+	var gotReq map[string]interface{}
+	b := NewGeminiBackend("http://gemini.test", 10, "", nil, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Fatalf("url = %q", r.URL.String())
+		}
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return textResponse("text/event-stream",
+			"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hel\"}]}}]}\n\n"+
+				"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"lo\"}]},\"finishReason\":\"MAX_TOKENS\"}],\"usageMetadata\":{\"promptTokenCount\":2,\"candidatesTokenCount\":2}}\n\n"), nil
+	})
+
+	respChan, meta, err := b.Generate(context.Background(), models.GenerateRequest{
+		Model:   "gemini-2.5-flash",
+		Prompt:  "say hello",
+		Stream:  true,
+		Options: map[string]interface{}{"safety_settings": []interface{}{map[string]interface{}{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_NONE"}}},
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	var text string
+	var final models.GenerateResponse
+	for resp := range respChan {
+		text += resp.Response
+		if resp.Done {
+			final = resp
+		}
+	}
+	if text != "Hello" {
+		t.Fatalf("text = %q, want Hello", text)
+	}
+	if final.DoneReason != "length" || final.EvalCount != 2 {
+		t.Fatalf("final = %#v", final)
+	}
+	if meta.RawResponse == "" {
+		t.Fatal("RawResponse is empty, want the SSE body")
+	}
+	if _, ok := gotReq["generationConfig"]; ok {
+		t.Fatalf("generationConfig = %#v, want omitted", gotReq["generationConfig"])
+	}
+	safety := gotReq["safetySettings"].([]interface{})
+	if safety[0].(map[string]interface{})["threshold"] != "BLOCK_NONE" {
+		t.Fatalf("safetySettings = %#v, want client override", safety)
+	}
+}
+
+func TestConvertMessagesToGeminiInlinesImages(t *testing.T) {
+	contents, _ := convertMessagesToGemini([]models.Message{
+		{Role: "user", Content: "compare", Images: []string{"iVBORw0KGgoAAAANSUhEUgAAAAE=", "data:image/webp;base64,UklGR", "https://example.com/cat.jpg"}},
+	})
+
+	want := []geminiPart{
+		{Text: "compare"},
+		{InlineData: &geminiBlob{MimeType: "image/png", Data: "iVBORw0KGgoAAAANSUhEUgAAAAE="}},
+		{InlineData: &geminiBlob{MimeType: "image/webp", Data: "UklGR"}},
+	}
+	if len(contents) != 1 || !reflect.DeepEqual(contents[0].Parts, want) {
+		t.Fatalf("contents = %+v", contents)
+	}
+}
+
+func TestGeminiGenerationConfigFormat(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object"}`)
+	cfg := geminiGenerationConfigFromOptions(nil, schema, nil)
+	if cfg == nil || cfg.ResponseMimeType != "application/json" || string(cfg.ResponseSchema) != `{"type":"object"}` {
+		t.Fatalf("schema config = %+v", cfg)
+	}
+
+	cfg = geminiGenerationConfigFromOptions(nil, json.RawMessage(`"json"`), nil)
+	if cfg == nil || cfg.ResponseMimeType != "application/json" || cfg.ResponseSchema != nil {
+		t.Fatalf("json config = %+v", cfg)
+	}
+
+	if cfg := geminiGenerationConfigFromOptions(nil, json.RawMessage(`""`), nil); cfg != nil {
+		t.Fatalf("empty format config = %+v, want nil", cfg)
+	}
+}
+
+func TestConvertToolChoiceToGemini(t *testing.T) {
+	tests := []struct {
+		choice interface{}
+		want   *geminiToolConfig
+	}{
+		{nil, nil},
+		{"auto", &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "AUTO"}}},
+		{"none", &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "NONE"}}},
+		{"required", &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "ANY"}}},
+		{
+			map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "get_weather"}},
+			&geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{"get_weather"}}},
+		},
+	}
+	for _, tt := range tests {
+		if got := convertToolChoiceToGemini(tt.choice); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convertToolChoiceToGemini(%v) = %+v, want %+v", tt.choice, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package backend
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -358,6 +357,7 @@ type gemma4ScanResult struct {
 	trapped             string // suppressed raw text, log-only
 	channelLeakStripped bool   // a reasoning-channel leak was stripped this attempt
 	channelLeakContent  string // content found wrapped inside it, log-only
+	err                 error  // reading the stream failed part way
 }
 
 // scanGemma4ChatStream reads one SSE response body and forwards content to
@@ -368,9 +368,7 @@ type gemma4ScanResult struct {
 // recover. Genuine structured tool_calls deltas are accumulated and sent
 // exactly as in handleStreamingChat, unaffected by content-leak detection.
 func (o *OpenAIBackend) scanGemma4ChatStream(ctx context.Context, body io.Reader, respChan chan<- models.ChatResponse, model string, rawResponse *strings.Builder) gemma4ScanResult {
-	scanner := bufio.NewScanner(body)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	scanner := newLineScanner(body, o.maxLineSize)
 
 	filter := &gemma4ContentFilter{}
 	tokenCount := 0
@@ -447,6 +445,7 @@ func (o *OpenAIBackend) scanGemma4ChatStream(ctx context.Context, body io.Reader
 		if err := json.Unmarshal([]byte(data), &openaiResp); err != nil {
 			continue
 		}
+		openaiResp.Usage = usageWithTimings(openaiResp.Usage, openaiResp.Timings)
 		if openaiResp.Usage != nil {
 			finalUsage = openaiResp.Usage
 		}
@@ -505,6 +504,17 @@ func (o *OpenAIBackend) scanGemma4ChatStream(ctx context.Context, body io.Reader
 		}
 
 		if choice.Delta.Content == "" {
+			if choice.Delta.Thinking != "" {
+				select {
+				case respChan <- models.ChatResponse{
+					Model:     model,
+					CreatedAt: time.Now(),
+					Message:   models.Message{Role: "assistant", Thinking: choice.Delta.Thinking},
+				}:
+				case <-ctx.Done():
+					return finish()
+				}
+			}
 			continue
 		}
 
@@ -537,11 +547,9 @@ func (o *OpenAIBackend) scanGemma4ChatStream(ctx context.Context, body io.Reader
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Scanner error in scanGemma4ChatStream: %v", err)
-	}
-
-	return finish()
+	result := finish()
+	result.err = streamReadError(ctx, scanner.Err(), o.maxLineSize)
+	return result
 }
 
 // scanGemma4ChatResponse reads one non-streaming (single JSON object) chat
@@ -568,6 +576,7 @@ func (o *OpenAIBackend) scanGemma4ChatResponse(body io.Reader, respChan chan<- m
 		log.Printf("Error parsing non-streaming gemma4_fix response: %v", err)
 		return gemma4ScanResult{corrupted: true, doneReason: "stop"}
 	}
+	openaiResp.Usage = usageWithTimings(openaiResp.Usage, openaiResp.Timings)
 
 	if len(openaiResp.Choices) == 0 || openaiResp.Choices[0].Message == nil {
 		return gemma4ScanResult{corrupted: true, doneReason: "stop", usage: openaiResp.Usage}
@@ -666,6 +675,12 @@ func (o *OpenAIBackend) handleStreamingChatGemma4Fix(ctx context.Context, firstB
 		finalTokenCount = result.tokenCount
 		finalUsage = result.usage
 		finalDoneReason = result.doneReason
+		if result.err != nil {
+			// A stream that broke off ends without a final response
+			metadata.RawResponse = rawResponse.String()
+			metadata.StreamErr = result.err
+			return
+		}
 
 		if result.channelLeakStripped {
 			log.Printf("[gemma4_fix] reasoning-channel leak stripped (model=%q, attempt=%d/%d): removed <|channel>thought...<channel|> wrapper, wrapped content=%q",
@@ -679,6 +694,7 @@ func (o *OpenAIBackend) handleStreamingChatGemma4Fix(ctx context.Context, firstB
 
 		if !result.corrupted {
 			metadata.RawResponse = rawResponse.String()
+			metadata.Usage = finalUsage
 			respChan <- gemma4FinalResponse(model, startTime, finalDoneReason, finalTokenCount, finalUsage)
 			return
 		}
@@ -93,7 +93,7 @@ func TestGemma4ContentFilterDoesNotSplitUTF8Runes(t *testing.T) {
 // buffering or retry logic engaged at all.
 func TestOpenAIBackendGemma4FixDisabledPassesThroughUnchanged(t *testing.T) {
 	requestCount := 0
-	b := NewOpenAIBackend("http://backend.test", 10, false, false)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		requestCount++
 		sse := strings.Join([]string{
@@ -133,7 +133,7 @@ func TestOpenAIBackendGemma4FixDisabledPassesThroughUnchanged(t *testing.T) {
 // trigger any retry (this leak is pure filtering, unlike the tool-call leak).
 func TestOpenAIBackendGemma4FixStripsReasoningChannelLeak(t *testing.T) {
 	requestCount := 0
-	b := NewOpenAIBackend("http://backend.test", 10, false, true)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, true, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		requestCount++
 		sse := strings.Join([]string{
@@ -179,7 +179,7 @@ func TestOpenAIBackendGemma4FixStripsReasoningChannelLeak(t *testing.T) {
 // messages, but stream:false - the corruption is specific to vLLM's streaming
 // gemma4 parser) and the client should see only the clean recovered output.
 func TestOpenAIBackendGemma4FixRecoversCleanFailure(t *testing.T) {
-	b := NewOpenAIBackend("http://backend.test", 10, false, true)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, true, false, nil)
 	var requestBodies []models.OpenAIChatRequest
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		bodyBytes, _ := io.ReadAll(r.Body)
@@ -349,7 +349,7 @@ data: [DONE]
 // the trapped bytes and synthesises a proper tool_calls response on the first
 // attempt — no retry request is made.
 func TestOpenAIBackendGemma4FixDetectsRealWorldCleanFailurePayload(t *testing.T) {
-	b := NewOpenAIBackend("http://backend.test", 10, false, true)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, true, false, nil)
 	var requestBodies []models.OpenAIChatRequest
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		bodyBytes, _ := io.ReadAll(r.Body)
@@ -591,7 +591,7 @@ func TestParseGemma4NativeToolCalls(t *testing.T) {
 // accumulated trapped bytes and return it to the client on the first attempt,
 // without any retry request.
 func TestOpenAIBackendGemma4FixParsesRealWorldExecuteCodePayload(t *testing.T) {
-	b := NewOpenAIBackend("http://backend.test", 10, false, true)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, true, false, nil)
 	var requestCount int
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		requestCount++
@@ -666,7 +666,7 @@ func TestOpenAIBackendGemma4FixParsesRealWorldExecuteCodePayload(t *testing.T) {
 // only the corrupted tail and send an internal nudge rather than retrying
 // verbatim (which would risk duplicated/re-explained prose).
 func TestOpenAIBackendGemma4FixNudgesAfterTrailingFailure(t *testing.T) {
-	b := NewOpenAIBackend("http://backend.test", 10, false, true)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, true, false, nil)
 	var requestBodies []models.OpenAIChatRequest
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		bodyBytes, _ := io.ReadAll(r.Body)
@@ -753,7 +753,7 @@ func TestOpenAIBackendGemma4FixNudgesAfterTrailingFailure(t *testing.T) {
 // control-token text.
 func TestOpenAIBackendGemma4FixFailsSafeAfterExhaustingRetries(t *testing.T) {
 	requestCount := 0
-	b := NewOpenAIBackend("http://backend.test", 10, false, true)
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, true, false, nil)
 	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
 		requestCount++
 
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// maxGenerateContexts caps how many emulated generate contexts are kept in
+// memory. The oldest are forgotten first.
+const maxGenerateContexts = 1000
+
+// maxGenerateContextAge is how long an emulated generate context is kept
+const maxGenerateContextAge = 24 * time.Hour
+
+// generateContexts emulates Ollama's generate context for stateless
+// backends. Ollama returns the conversation so far as token IDs in
+// "context", and a client continues the conversation by sending them back.
+// OpenAI-compatible and Gemini backends have nothing equivalent, so instead
+// the text of each finished exchange is kept under a random ID, returned as
+// a one-element context, and prepended to the prompt when the same tenant
+// and client key send it back.
+type generateContexts struct {
+	mu       sync.Mutex
+	contexts map[int]generateContext
+	order    []int // IDs oldest first
+	now      func() time.Time
+}
+
+type generateContext struct {
+	owner string
+	text  string
+	saved time.Time
+}
+
+func newGenerateContexts() *generateContexts {
+	return &generateContexts{contexts: make(map[int]generateContext), now: time.Now}
+}
+
+// generateContextOwner identifies who made the request on ctx, so one
+// client can't continue another's conversation
+func generateContextOwner(ctx context.Context) string {
+	return TenantFromContext(ctx) + "\x00" + ClientKeyFromContext(ctx)
+}
+
+// expand returns prompt with the conversation identified by ctxIDs
+// prepended. Contexts this proxy didn't create (e.g. real Ollama token IDs),
+// expired ones and those of another tenant or client key are ignored.
+func (c *generateContexts) expand(ctx context.Context, ctxIDs []int, prompt string) string {
+	if len(ctxIDs) != 1 {
+		return prompt
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	saved, ok := c.contexts[ctxIDs[0]]
+	if !ok || saved.owner != generateContextOwner(ctx) {
+		return prompt
+	}
+	return saved.text + prompt
+}
+
+// track relays responses from in and, once the final one arrives, stores
+// prompt plus the generated text and sets the final response's context to
+// the new ID.
+func (c *generateContexts) track(ctx context.Context, prompt string, in <-chan models.GenerateResponse) <-chan models.GenerateResponse {
+	owner := generateContextOwner(ctx)
+	out := make(chan models.GenerateResponse, cap(in))
+	go func() {
+		defer close(out)
+		var response strings.Builder
+		for resp := range in {
+			response.WriteString(resp.Response)
+			if resp.Done {
+				resp.Context = []int{c.save(owner, prompt+response.String())}
+			}
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (c *generateContexts) save(owner, text string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	id := newGenerateContextID()
+	for _, taken := c.contexts[id]; taken; _, taken = c.contexts[id] {
+		id = newGenerateContextID()
+	}
+	c.contexts[id] = generateContext{owner: owner, text: text, saved: c.now()}
+	c.order = append(c.order, id)
+	if len(c.order) > maxGenerateContexts {
+		delete(c.contexts, c.order[0])
+		c.order = c.order[1:]
+	}
+	return id
+}
+
+// expire forgets contexts older than maxGenerateContextAge. c.mu must be
+// held.
+func (c *generateContexts) expire() {
+	cutoff := c.now().Add(-maxGenerateContextAge)
+	for len(c.order) > 0 && c.contexts[c.order[0]].saved.Before(cutoff) {
+		delete(c.contexts, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// newGenerateContextID returns a random ID that fits in a JSON number
+// without losing precision, so it can't be guessed from another client's
+func newGenerateContextID() int {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return int(binary.BigEndian.Uint64(b)>>11) + 1
+}
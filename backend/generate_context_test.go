@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestOpenAIBackendEmulatesGenerateContext(t *testing.T) {
+	var gotPrompts []string
+	b := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		gotPrompts = append(gotPrompts, req.Prompt)
+		return jsonResponse(`{"choices":[{"text":" Paris.","finish_reason":"stop"}]}`), nil
+	})
+
+	generate := func(req models.GenerateRequest) []int {
+		respChan, _, err := b.Generate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		var ctxIDs []int
+		for resp := range respChan {
+			if resp.Done {
+				ctxIDs = resp.Context
+			}
+		}
+		return ctxIDs
+	}
+
+	first := generate(models.GenerateRequest{Model: "m", Prompt: "Capital of France?"})
+	if len(first) != 1 {
+		t.Fatalf("context = %v, want one emulated ID", first)
+	}
+	generate(models.GenerateRequest{Model: "m", Prompt: " And Spain?", Context: first})
+	// Token IDs from a real Ollama backend aren't ours and are ignored
+	generate(models.GenerateRequest{Model: "m", Prompt: "Hi", Context: []int{101, 102}})
+
+	want := []string{"Capital of France?", "Capital of France? Paris. And Spain?", "Hi"}
+	if !reflect.DeepEqual(gotPrompts, want) {
+		t.Fatalf("prompts = %q, want %q", gotPrompts, want)
+	}
+}
+
+func TestGenerateContextsForgetOldest(t *testing.T) {
+	c := newGenerateContexts()
+	ctx := context.Background()
+	owner := generateContextOwner(ctx)
+	first := c.save(owner, "oldest")
+	for i := 0; i < maxGenerateContexts; i++ {
+		c.save(owner, "newer")
+	}
+	if got := c.expand(ctx, []int{first}, "prompt"); got != "prompt" {
+		t.Fatalf("expand() = %q, want the oldest context forgotten", got)
+	}
+	if len(c.contexts) != maxGenerateContexts {
+		t.Fatalf("stored %d contexts, want %d", len(c.contexts), maxGenerateContexts)
+	}
+
+	now := time.Now()
+	c.now = func() time.Time { return now.Add(maxGenerateContextAge + time.Minute) }
+	if c.expand(ctx, []int{first}, "prompt"); len(c.contexts) != 0 {
+		t.Fatalf("stored %d contexts after they expired, want 0", len(c.contexts))
+	}
+}
+
+func TestGenerateContextsBelongToTheirOwner(t *testing.T) {
+	c := newGenerateContexts()
+	alice := WithClientKey(context.Background(), "alice-key")
+	id := c.save(generateContextOwner(alice), "secret. ")
+
+	for name, ctx := range map[string]context.Context{
+		"other key":    WithClientKey(context.Background(), "bob-key"),
+		"no key":       context.Background(),
+		"other tenant": WithTenant(alice, "team-b"),
+	} {
+		if got := c.expand(ctx, []int{id}, "prompt"); got != "prompt" {
+			t.Errorf("%s: expand() = %q, want another owner's context ignored", name, got)
+		}
+	}
+	if got := c.expand(alice, []int{id}, "prompt"); got != "secret. prompt" {
+		t.Fatalf("owner expand() = %q", got)
+	}
+	if other := c.save(generateContextOwner(alice), "again"); other == id || other <= 0 {
+		t.Fatalf("second ID = %d, want a new positive ID", other)
+	}
+}
@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// Hook is an external program that can inspect and rewrite generate and chat
+// requests and responses. It is run once per request and stage with a
+// HookInput as JSON on stdin, and answers with a HookOutput as JSON on
+// stdout. No output leaves the request or response as it is.
+type Hook struct {
+	Name     string
+	Command  []string      // Program and its arguments
+	Request  bool          // Run on requests before they are sent
+	Response bool          // Run on complete responses
+	Timeout  time.Duration // Longest a run may take (0 = no limit)
+	FailOpen bool          // Carry on without the hook if it fails, instead of failing the request
+}
+
+// HookInput is what a hook is given on stdin
+type HookInput struct {
+	Stage    string          `json:"stage"`              // "request" or "response"
+	Endpoint string          `json:"endpoint"`           // "generate" or "chat"
+	Request  json.RawMessage `json:"request"`            // Ollama request
+	Response json.RawMessage `json:"response,omitempty"` // Complete Ollama response, at the response stage
+}
+
+// HookOutput is what a hook answers on stdout. Request or Response replace
+// the ones given; Reject fails the request instead.
+type HookOutput struct {
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Reject   *RejectedError  `json:"reject,omitempty"`
+}
+
+// HookBackend wraps another backend and runs hooks on the requests sent to it
+// and the responses it returns. Responses are only run through hooks once
+// they are complete, so a response hook holds the whole reply back and sends
+// it as a single final response.
+type HookBackend struct {
+	Backend
+	hooks []Hook
+}
+
+// NewHookBackend creates a hook-running wrapper around inner. Hooks run in
+// order.
+func NewHookBackend(inner Backend, hooks []Hook) *HookBackend {
+	return &HookBackend{Backend: inner, hooks: hooks}
+}
+
+// Generate runs the hooks on a generate request and its response.
+func (h *HookBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	if err := runRequestHooks(ctx, h.hooks, "generate", &req); err != nil {
+		return closedChan[models.GenerateResponse](), &BackendMetadata{}, err
+	}
+	respChan, metadata, err := h.Backend.Generate(ctx, req)
+	if err != nil || !h.hasResponseHooks() {
+		return respChan, metadata, err
+	}
+
+	responses := drain(ctx, respChan)
+	final, ok := combineGenerateResponses(responses)
+	if !ok || metadata.StreamErr != nil || ctx.Err() != nil {
+		// Only complete responses are run through hooks
+		return replay(responses), metadata, nil
+	}
+	if err := runResponseHooks(ctx, h.hooks, "generate", req, &final); err != nil {
+		return closedChan[models.GenerateResponse](), metadata, err
+	}
+	return replay([]models.GenerateResponse{final}), metadata, nil
+}
+
+// Chat runs the hooks on a chat request and its response.
+func (h *HookBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	// Raw OpenAI parameters aren't part of the request's JSON
+	openAIRaw := req.OpenAIRaw
+	if err := runRequestHooks(ctx, h.hooks, "chat", &req); err != nil {
+		return closedChan[models.ChatResponse](), &BackendMetadata{}, err
+	}
+	req.OpenAIRaw = openAIRaw
+	respChan, metadata, err := h.Backend.Chat(ctx, req)
+	if err != nil || !h.hasResponseHooks() {
+		return respChan, metadata, err
+	}
+
+	responses := drain(ctx, respChan)
+	final, ok := combineChatResponses(responses)
+	if !ok || metadata.StreamErr != nil || ctx.Err() != nil {
+		// Only complete responses are run through hooks
+		return replay(responses), metadata, nil
+	}
+	if err := runResponseHooks(ctx, h.hooks, "chat", req, &final); err != nil {
+		return closedChan[models.ChatResponse](), metadata, err
+	}
+	return replay([]models.ChatResponse{final}), metadata, nil
+}
+
+func (h *HookBackend) hasResponseHooks() bool {
+	for _, hook := range h.hooks {
+		if hook.Response {
+			return true
+		}
+	}
+	return false
+}
+
+// runRequestHooks runs the request hooks on req, replacing it with each
+// hook's rewrite in turn
+func runRequestHooks[T any](ctx context.Context, hooks []Hook, endpoint string, req *T) error {
+	for _, hook := range hooks {
+		if !hook.Request {
+			continue
+		}
+		data, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("hook %s: failed to marshal request: %w", hook.Name, err)
+		}
+		out, err := hook.run(ctx, HookInput{Stage: "request", Endpoint: endpoint, Request: data})
+		if err == nil && out.Request != nil {
+			var rewritten T
+			if err = json.Unmarshal(out.Request, &rewritten); err == nil {
+				*req = rewritten
+			} else {
+				err = fmt.Errorf("invalid request: %w", err)
+			}
+		}
+		if err = hook.result(out, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runResponseHooks runs the response hooks on the complete response resp to
+// req, replacing it with each hook's rewrite in turn
+func runResponseHooks[T any](ctx context.Context, hooks []Hook, endpoint string, req interface{}, resp *T) error {
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request for hooks: %w", err)
+	}
+	for _, hook := range hooks {
+		if !hook.Response {
+			continue
+		}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return fmt.Errorf("hook %s: failed to marshal response: %w", hook.Name, err)
+		}
+		out, err := hook.run(ctx, HookInput{Stage: "response", Endpoint: endpoint, Request: reqData, Response: data})
+		if err == nil && out.Response != nil {
+			// Decode over a copy so fields missing from JSON, such as
+			// the token usage, are kept
+			rewritten := *resp
+			if err = json.Unmarshal(out.Response, &rewritten); err == nil {
+				*resp = rewritten
+			} else {
+				err = fmt.Errorf("invalid response: %w", err)
+			}
+		}
+		if err = hook.result(out, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// result returns the error a run of the hook fails the request with: its
+// rejection, or err unless the hook fails open
+func (h Hook) result(out HookOutput, err error) error {
+	if err != nil {
+		if h.FailOpen {
+			log.Printf("Hook %s failed, ignoring it: %v", h.Name, err)
+			return nil
+		}
+		return fmt.Errorf("hook %s: %w", h.Name, err)
+	}
+	if out.Reject != nil {
+		log.Printf("Hook %s rejected the request: %s", h.Name, out.Reject.Message)
+		return out.Reject
+	}
+	return nil
+}
+
+// run runs the hook's command with input and decodes its output
+func (h Hook) run(ctx context.Context, input HookInput) (HookOutput, error) {
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return HookOutput{}, fmt.Errorf("failed to marshal input: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	message := strings.TrimSpace(stderr.String())
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", h.Timeout)
+		}
+		if message != "" {
+			return HookOutput{}, fmt.Errorf("%w: %s", err, message)
+		}
+		return HookOutput{}, err
+	}
+	if message != "" {
+		log.Printf("Hook %s: %s", h.Name, message)
+	}
+
+	var out HookOutput
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return out, nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return HookOutput{}, fmt.Errorf("invalid output: %w", err)
+	}
+	return out, nil
+}
+
+// combineGenerateResponses joins a complete streamed generate response into
+// its final response. It returns false if the stream ended without one.
+func combineGenerateResponses(responses []models.GenerateResponse) (models.GenerateResponse, bool) {
+	if len(responses) == 0 || !responses[len(responses)-1].Done {
+		return models.GenerateResponse{}, false
+	}
+	var text, thinking strings.Builder
+	for _, resp := range responses {
+		text.WriteString(resp.Response)
+		thinking.WriteString(resp.Thinking)
+	}
+	final := responses[len(responses)-1]
+	final.Response = text.String()
+	final.Thinking = thinking.String()
+	return final, true
+}
+
+// combineChatResponses joins a complete streamed chat response into its
+// final response. It returns false if the stream ended without one.
+func combineChatResponses(responses []models.ChatResponse) (models.ChatResponse, bool) {
+	if len(responses) == 0 || !responses[len(responses)-1].Done {
+		return models.ChatResponse{}, false
+	}
+	var content, thinking strings.Builder
+	message := models.Message{Role: "assistant"}
+	for _, resp := range responses {
+		content.WriteString(resp.Message.Content)
+		thinking.WriteString(resp.Message.Thinking)
+		message.AddToolCalls(resp.Message.ToolCalls)
+	}
+	message.Content = content.String()
+	message.Thinking = thinking.String()
+	final := responses[len(responses)-1]
+	final.Message = message
+	return final, true
+}
+
+// drain collects every response on ch, stopping early if ctx is done
+func drain[T any](ctx context.Context, ch <-chan T) []T {
+	var responses []T
+	for {
+		select {
+		case resp, ok := <-ch:
+			if !ok {
+				return responses
+			}
+			responses = append(responses, resp)
+		case <-ctx.Done():
+			go discard(ch)
+			return responses
+		}
+	}
+}
+
+func discard[T any](ch <-chan T) {
+	for range ch {
+	}
+}
+
+// replay returns a closed channel that delivers responses
+func replay[T any](responses []T) <-chan T {
+	ch := make(chan T, len(responses))
+	for _, resp := range responses {
+		ch <- resp
+	}
+	close(ch)
+	return ch
+}
+
+func closedChan[T any]() <-chan T {
+	ch := make(chan T)
+	close(ch)
+	return ch
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// shellHook returns a hook running script with sh
+func shellHook(script string, request, response bool) Hook {
+	return Hook{Name: "test", Command: []string{"sh", "-c", script}, Request: request, Response: response, Timeout: 5 * time.Second}
+}
+
+func TestHookBackendRewritesAndRejectsRequests(t *testing.T) {
+	rewrite := shellHook(`grep -q '"stage":"request"' && echo '{"request":{"model":"m","prompt":"rewritten prompt"}}'`, true, false)
+	b := NewHookBackend(NewMockBackend(MockOptions{}), []Hook{rewrite})
+	respChan, _, err := b.Generate(context.Background(), models.GenerateRequest{Model: "m", Prompt: "original"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var text string
+	for resp := range respChan {
+		text += resp.Response
+	}
+	if text != "rewritten prompt" {
+		t.Fatalf("response = %q, want the echo of the rewritten prompt", text)
+	}
+
+	reject := shellHook(`cat >/dev/null; echo '{"reject":{"status":403,"error":"not allowed"}}'`, true, false)
+	b = NewHookBackend(NewMockBackend(MockOptions{}), []Hook{reject})
+	_, _, err = b.Chat(context.Background(), models.ChatRequest{Messages: []models.Message{{Role: "user", Content: "hi"}}})
+	var rejection *RejectedError
+	if !errors.As(err, &rejection) || rejection.StatusCode() != 403 || err.Error() != "not allowed" {
+		t.Fatalf("Chat() error = %v, want a 403 rejection", err)
+	}
+}
+
+func TestHookBackendRewritesCompleteResponses(t *testing.T) {
+	hook := shellHook(`grep -q '"content":"one two three"' && echo '{"response":{"model":"m","message":{"role":"assistant","content":"replaced"},"done":true}}'`, false, true)
+	b := NewHookBackend(NewMockBackend(MockOptions{Reply: "one two three"}), []Hook{hook})
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{Model: "m", Messages: []models.Message{{Role: "user", Content: "hi"}}, Stream: true})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var responses []models.ChatResponse
+	for resp := range respChan {
+		responses = append(responses, resp)
+	}
+	if len(responses) != 1 || !responses[0].Done || responses[0].Message.Content != "replaced" {
+		t.Fatalf("responses = %+v, want one final response with the hook's content", responses)
+	}
+	if responses[0].Usage == nil {
+		t.Fatal("Usage = nil, want the backend's usage kept")
+	}
+}
+
+func TestHookBackendFailures(t *testing.T) {
+	failing := shellHook(`echo broken >&2; exit 1`, true, false)
+	b := NewHookBackend(NewMockBackend(MockOptions{}), []Hook{failing})
+	if _, _, err := b.Generate(context.Background(), models.GenerateRequest{Prompt: "hi"}); err == nil {
+		t.Fatal("Generate() error = nil, want the hook's failure")
+	}
+
+	failing.FailOpen = true
+	b = NewHookBackend(NewMockBackend(MockOptions{}), []Hook{failing})
+	respChan, _, err := b.Generate(context.Background(), models.GenerateRequest{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v, want the hook ignored", err)
+	}
+	var text string
+	for resp := range respChan {
+		text += resp.Response
+	}
+	if text != "hi" {
+		t.Fatalf("response = %q, want the unchanged request's echo", text)
+	}
+}
@@ -0,0 +1,547 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// DefaultKoboldMaxLength is the number of tokens generated for requests that
+// don't set num_predict. KoboldCpp has no "no limit" setting, and its own
+// default of 100 tokens cuts most replies short.
+const DefaultKoboldMaxLength = 1024
+
+// KoboldChatTemplate is how chat messages are written into a KoboldCpp
+// prompt, since its native API only completes text.
+type KoboldChatTemplate struct {
+	System    [2]string // Text before and after a system message
+	User      [2]string // Text before and after a user message
+	Assistant [2]string // Text before and after an assistant message
+	Stop      []string  // Stop sequences that end the assistant's turn
+}
+
+// KoboldChatTemplates are the built-in chat templates, by name
+var KoboldChatTemplates = map[string]KoboldChatTemplate{
+	"chatml": {
+		System:    [2]string{"<|im_start|>system\n", "<|im_end|>\n"},
+		User:      [2]string{"<|im_start|>user\n", "<|im_end|>\n"},
+		Assistant: [2]string{"<|im_start|>assistant\n", "<|im_end|>\n"},
+		Stop:      []string{"<|im_end|>", "<|im_start|>"},
+	},
+	"llama3": {
+		System:    [2]string{"<|start_header_id|>system<|end_header_id|>\n\n", "<|eot_id|>"},
+		User:      [2]string{"<|start_header_id|>user<|end_header_id|>\n\n", "<|eot_id|>"},
+		Assistant: [2]string{"<|start_header_id|>assistant<|end_header_id|>\n\n", "<|eot_id|>"},
+		Stop:      []string{"<|eot_id|>", "<|start_header_id|>"},
+	},
+	"mistral": {
+		System:    [2]string{"[INST] ", " [/INST]"},
+		User:      [2]string{"[INST] ", " [/INST]"},
+		Assistant: [2]string{"", "</s>"},
+		Stop:      []string{"[INST]", "</s>"},
+	},
+	"alpaca": {
+		System:    [2]string{"", "\n\n"},
+		User:      [2]string{"### Instruction:\n", "\n\n"},
+		Assistant: [2]string{"### Response:\n", "\n\n"},
+		Stop:      []string{"### Instruction:"},
+	},
+}
+
+// KoboldBackend implements the Backend interface for KoboldCpp's native
+// (KoboldAI) API, so that samplers with no OpenAI equivalent, such as
+// mirostat, tfs and typical_p, reach the backend.
+type KoboldBackend struct {
+	endpoint     string
+	headers      map[string]string
+	chatTemplate KoboldChatTemplate
+	maxLength    int
+	client       *http.Client
+	contexts     *generateContexts
+	maxLineSize  int
+}
+
+// NewKoboldBackend creates a new KoboldCpp backend. Chat messages are
+// written into the prompt with chatTemplate. maxLength is the number of
+// tokens generated for requests that don't set num_predict. headers are
+// added to every backend request.
+func NewKoboldBackend(endpoint string, timeout int, chatTemplate KoboldChatTemplate, maxLength int, headers map[string]string) *KoboldBackend {
+	return &KoboldBackend{
+		endpoint:     strings.TrimRight(endpoint, "/"),
+		headers:      headers,
+		chatTemplate: chatTemplate,
+		maxLength:    maxLength,
+		contexts:     newGenerateContexts(),
+		maxLineSize:  DefaultMaxLineSize,
+		client: &http.Client{
+			Timeout:   time.Duration(timeout) * time.Second,
+			Transport: newDecompressTransport(nil),
+		},
+	}
+}
+
+// SetTransport replaces the HTTP transport used for backend requests; nil
+// uses the default transport. Compressed responses are still decompressed.
+func (k *KoboldBackend) SetTransport(transport http.RoundTripper) {
+	k.client.Transport = newDecompressTransport(transport)
+}
+
+// SetMaxLineSize sets the longest line, in bytes, accepted from a streamed
+// response. A longer line ends the stream with an error.
+func (k *KoboldBackend) SetMaxLineSize(size int) {
+	k.maxLineSize = size
+}
+
+// koboldOptionNames maps Ollama option names to KoboldCpp request fields
+var koboldOptionNames = map[string]string{
+	"num_predict":      "max_length",
+	"num_ctx":          "max_context_length",
+	"temperature":      "temperature",
+	"top_p":            "top_p",
+	"top_k":            "top_k",
+	"min_p":            "min_p",
+	"typical_p":        "typical",
+	"tfs_z":            "tfs",
+	"repeat_penalty":   "rep_pen",
+	"repeat_last_n":    "rep_pen_range",
+	"presence_penalty": "presence_penalty",
+	"mirostat":         "mirostat",
+	"mirostat_tau":     "mirostat_tau",
+	"mirostat_eta":     "mirostat_eta",
+	"seed":             "sampler_seed",
+	"stop":             "stop_sequence",
+}
+
+// koboldIntFields are the KoboldCpp request fields that must be integers
+var koboldIntFields = map[string]bool{
+	"max_length":         true,
+	"max_context_length": true,
+	"top_k":              true,
+	"rep_pen_range":      true,
+	"mirostat":           true,
+	"sampler_seed":       true,
+}
+
+// koboldParams builds a KoboldCpp generate request. Options with no
+// KoboldCpp equivalent are sent under their own name, so KoboldCpp's own
+// samplers (e.g. top_a, dry_multiplier) can be set directly. Fields in extra
+// are sent as they are, and take precedence over options.
+func (k *KoboldBackend) koboldParams(prompt string, options, extra map[string]interface{}, stop []string, images []string) map[string]interface{} {
+	params := map[string]interface{}{
+		"prompt":     prompt,
+		"max_length": k.maxLength,
+	}
+	for name, value := range options {
+		koboldName, known := koboldOptionNames[name]
+		if !known {
+			params[name] = value
+			continue
+		}
+		switch name {
+		case "num_predict":
+			// Ollama uses -1 (and -2) for "no limit"; keep the default
+			if n, ok := value.(float64); !ok || n <= 0 {
+				continue
+			}
+		case "stop":
+			if s, ok := value.(string); ok {
+				value = []string{s}
+			}
+		}
+		if n, ok := value.(float64); ok && koboldIntFields[koboldName] {
+			value = int(n)
+		}
+		params[koboldName] = value
+	}
+	if len(stop) > 0 {
+		var sequences []interface{}
+		switch requested := params["stop_sequence"].(type) {
+		case []interface{}:
+			sequences = requested
+		case []string:
+			for _, s := range requested {
+				sequences = append(sequences, s)
+			}
+		}
+		for _, s := range stop {
+			sequences = append(sequences, s)
+		}
+		params["stop_sequence"] = sequences
+		params["trim_stop"] = true
+	}
+	if len(images) > 0 {
+		params["images"] = images
+	}
+	for name, value := range extra {
+		params[name] = value
+	}
+	return params
+}
+
+// chatPrompt writes messages into a prompt with the chat template, ending
+// with the start of the assistant's reply. Images of all messages are
+// returned for KoboldCpp's images field.
+func (k *KoboldBackend) chatPrompt(messages []models.Message) (string, []string) {
+	var prompt strings.Builder
+	var images []string
+	for _, msg := range messages {
+		var wrap [2]string
+		switch msg.Role {
+		case "system":
+			wrap = k.chatTemplate.System
+		case "assistant":
+			wrap = k.chatTemplate.Assistant
+		default:
+			// Tool results are shown to the model as user messages
+			wrap = k.chatTemplate.User
+		}
+		prompt.WriteString(wrap[0])
+		prompt.WriteString(msg.Content)
+		prompt.WriteString(wrap[1])
+		images = append(images, msg.Images...)
+	}
+	prompt.WriteString(k.chatTemplate.Assistant[0])
+	return prompt.String(), images
+}
+
+// koboldGenkey returns a key identifying one generation, so that it alone is
+// aborted when its client goes away
+func koboldGenkey() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "KCPP" + hex.EncodeToString(b)
+}
+
+// koboldResult is one response of /api/v1/generate, or one event of
+// /api/extra/generate/stream
+type koboldResult struct {
+	Text         string `json:"text"`
+	Token        string `json:"token"`
+	FinishReason string `json:"finish_reason"`
+}
+
+// start sends params to KoboldCpp, streaming with /api/extra/generate/stream
+// or not with /api/v1/generate. It returns the generation's key for abort.
+func (k *KoboldBackend) start(ctx context.Context, params map[string]interface{}, stream bool, metadata *BackendMetadata) (*http.Response, string, error) {
+	genkey := koboldGenkey()
+	params["genkey"] = genkey
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+	metadata.RawRequest = string(data)
+	metadata.URL = k.endpoint + "/api/v1/generate"
+	if stream {
+		metadata.URL = k.endpoint + "/api/extra/generate/stream"
+	}
+
+	resp, err := k.post(ctx, metadata.URL, data, metadata)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, genkey, nil
+}
+
+// readResults decodes either a single /api/v1/generate response or a stream
+// of token events, calling handle for each piece of text, and returns the
+// finish reason. The raw body is stored on metadata. handle returns false
+// to stop early.
+func (k *KoboldBackend) readResults(ctx context.Context, body io.Reader, stream bool, metadata *BackendMetadata, handle func(text string) bool) (string, error) {
+	if !stream {
+		bodyBytes, err := io.ReadAll(body)
+		metadata.RawResponse = string(bodyBytes)
+		if err != nil {
+			metadata.StreamErr = streamReadError(ctx, err, k.maxLineSize)
+			return "", err
+		}
+		var koboldResp struct {
+			Results []koboldResult `json:"results"`
+		}
+		if err := json.Unmarshal(bodyBytes, &koboldResp); err != nil {
+			err = fmt.Errorf("failed to decode response: %w", err)
+			metadata.StreamErr = streamReadError(ctx, err, k.maxLineSize)
+			return "", err
+		}
+		if len(koboldResp.Results) == 0 {
+			return "stop", nil
+		}
+		if text := koboldResp.Results[0].Text; text != "" {
+			handle(text)
+		}
+		return koboldDoneReason(koboldResp.Results[0].FinishReason), nil
+	}
+
+	var rawResponse strings.Builder
+	defer func() { metadata.RawResponse = rawResponse.String() }()
+
+	reason := "stop"
+	scanner := newLineScanner(body, k.maxLineSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		rawResponse.WriteString(line)
+		rawResponse.WriteString("\n")
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event koboldResult
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			continue
+		}
+		if event.FinishReason != "" {
+			reason = koboldDoneReason(event.FinishReason)
+		}
+		if event.Token == "" {
+			continue
+		}
+		if !handle(event.Token) || ctx.Err() != nil {
+			return reason, ctx.Err()
+		}
+	}
+	metadata.StreamErr = streamReadError(ctx, scanner.Err(), k.maxLineSize)
+	return reason, scanner.Err()
+}
+
+// abort stops the generation identified by genkey
+func (k *KoboldBackend) abort(genkey string) {
+	data, _ := json.Marshal(map[string]string{"genkey": genkey})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", k.endpoint+"/api/extra/abort", bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	setExtraHeaders(httpReq, k.headers)
+	if resp, err := k.client.Do(httpReq); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// koboldDoneReason converts a KoboldCpp finish reason to Ollama's
+func koboldDoneReason(reason string) string {
+	if reason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// post sends a JSON body to KoboldCpp and validates the status code,
+// recording the raw error body on failure.
+func (k *KoboldBackend) post(ctx context.Context, target string, data []byte, metadata *BackendMetadata) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	setExtraHeaders(httpReq, k.headers)
+
+	resp, err := k.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	metadata.recordResponse(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		metadata.RawResponse = string(body)
+		return nil, newStatusError(resp, string(body))
+	}
+	return resp, nil
+}
+
+// Generate handles text generation requests. The system prompt is put in
+// front of the prompt unless the request is raw. The generate context is
+// emulated, since the API is stateless.
+func (k *KoboldBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	respChan := make(chan models.GenerateResponse, 10)
+	metadata := &BackendMetadata{}
+
+	prompt := k.contexts.expand(ctx, req.Context, req.Prompt)
+	fullPrompt := prompt
+	if req.System != "" && !req.Raw {
+		fullPrompt = req.System + "\n\n" + prompt
+	}
+	params := k.koboldParams(fullPrompt, req.Options, req.Extra, nil, req.Images)
+
+	resp, genkey, err := k.start(ctx, params, req.Stream, metadata)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, err
+	}
+
+	go func() {
+		defer resp.Body.Close()
+		defer close(respChan)
+		// KoboldCpp keeps generating for a client that went away
+		defer context.AfterFunc(ctx, func() { k.abort(genkey) })()
+
+		startTime := time.Now()
+		reason, err := k.readResults(ctx, resp.Body, req.Stream, metadata, func(text string) bool {
+			select {
+			case respChan <- models.GenerateResponse{Model: req.Model, CreatedAt: time.Now(), Response: text}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if metadata.StreamErr != nil {
+			// A response that broke off ends without a final response
+			return
+		}
+		if err != nil {
+			reason = "error"
+		}
+		final := models.GenerateResponse{Model: req.Model, CreatedAt: time.Now(), Done: true, DoneReason: reason}
+		final.TotalDuration = time.Since(startTime).Nanoseconds()
+		final.EvalDuration = final.TotalDuration
+		respChan <- final
+	}()
+
+	return k.contexts.track(ctx, prompt, respChan), metadata, nil
+}
+
+// Chat handles chat requests by writing the messages into a prompt with the
+// chat template. Tools are not supported by the native API and are ignored.
+func (k *KoboldBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	respChan := make(chan models.ChatResponse, 10)
+	metadata := &BackendMetadata{}
+
+	prompt, images := k.chatPrompt(req.Messages)
+	params := k.koboldParams(prompt, req.Options, req.Extra, k.chatTemplate.Stop, images)
+
+	resp, genkey, err := k.start(ctx, params, req.Stream, metadata)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, err
+	}
+
+	go func() {
+		defer resp.Body.Close()
+		defer close(respChan)
+		// KoboldCpp keeps generating for a client that went away
+		defer context.AfterFunc(ctx, func() { k.abort(genkey) })()
+
+		startTime := time.Now()
+		reason, err := k.readResults(ctx, resp.Body, req.Stream, metadata, func(text string) bool {
+			msg := models.ChatResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now(),
+				Message:   models.Message{Role: "assistant", Content: text},
+			}
+			select {
+			case respChan <- msg:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if metadata.StreamErr != nil {
+			// A response that broke off ends without a final response
+			return
+		}
+		if err != nil {
+			reason = "error"
+		}
+		final := models.ChatResponse{
+			Model:      req.Model,
+			CreatedAt:  time.Now(),
+			Message:    models.Message{Role: "assistant"},
+			Done:       true,
+			DoneReason: reason,
+		}
+		final.TotalDuration = time.Since(startTime).Nanoseconds()
+		final.EvalDuration = final.TotalDuration
+		respChan <- final
+	}()
+
+	return respChan, metadata, nil
+}
+
+// getJSON fetches a KoboldCpp info endpoint into out
+func (k *KoboldBackend) getJSON(ctx context.Context, path string, out interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", k.endpoint+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	setExtraHeaders(httpReq, k.headers)
+
+	resp, err := k.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return newStatusError(resp, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// ListModels returns the one model KoboldCpp has loaded
+func (k *KoboldBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	var model struct {
+		Result string `json:"result"`
+	}
+	if err := k.getJSON(ctx, "/api/v1/model", &model); err != nil {
+		return models.ModelsResponse{}, err
+	}
+	var contextLength struct {
+		Value int `json:"value"`
+	}
+	// Older versions don't report the context size; it is left out then
+	_ = k.getJSON(ctx, "/api/extra/true_max_context_length", &contextLength)
+
+	name := strings.TrimPrefix(model.Result, "koboldcpp/")
+	return models.ModelsResponse{Models: []models.ModelInfo{{
+		Name:          name,
+		Model:         name,
+		ContextLength: contextLength.Value,
+		Capabilities:  []string{"completion"},
+		Details: models.ModelDetails{
+			Format:        "gguf",
+			ContextLength: contextLength.Value,
+		},
+	}}}, nil
+}
+
+// ShowModel returns metadata for the loaded model. KoboldCpp serves one
+// model, so every name is described as that model.
+func (k *KoboldBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	modelsResp, err := k.ListModels(ctx)
+	if err != nil {
+		return models.ShowResponse{}, err
+	}
+	m := modelsResp.Models[0]
+	modelInfo := map[string]interface{}{}
+	if m.ContextLength > 0 {
+		modelInfo["context_length"] = m.ContextLength
+	}
+	return models.ShowResponse{
+		Details:      m.Details,
+		ModelInfo:    modelInfo,
+		Capabilities: m.Capabilities,
+	}, nil
+}
+
+// Embed is not supported by KoboldCpp's native API
+func (k *KoboldBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	return models.EmbedResponse{}, &BackendMetadata{}, &RejectedError{
+		Status:  http.StatusNotImplemented,
+		Message: "embeddings are not supported by the koboldcpp backend",
+	}
+}
@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestKoboldBackendGenerateTranslatesSamplers(t *testing.T) {
+	var gotReq map[string]interface{}
+	b := NewKoboldBackend("http://kobold.test/", 10, KoboldChatTemplates["chatml"], DefaultKoboldMaxLength, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path != "/api/v1/generate" {
+			t.Fatalf("path = %q, want /api/v1/generate", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return jsonResponse(`{"results":[{"text":" world","finish_reason":"length"}]}`), nil
+	})
+
+	respChan, _, err := b.Generate(context.Background(), models.GenerateRequest{
+		Model:  "m",
+		Prompt: "hello",
+		System: "be brief",
+		Options: map[string]interface{}{
+			"mirostat":       float64(2),
+			"mirostat_tau":   float64(5),
+			"tfs_z":          0.95,
+			"typical_p":      0.9,
+			"repeat_penalty": 1.1,
+			"num_predict":    float64(-1),
+			"seed":           float64(42),
+			"stop":           "\n",
+			"top_a":          0.2,
+		},
+		Extra: map[string]interface{}{"top_a": 0.3},
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var responses []models.GenerateResponse
+	for resp := range respChan {
+		responses = append(responses, resp)
+	}
+
+	want := map[string]interface{}{
+		"prompt":        "be brief\n\nhello",
+		"mirostat":      float64(2),
+		"mirostat_tau":  float64(5),
+		"tfs":           0.95,
+		"typical":       0.9,
+		"rep_pen":       1.1,
+		"max_length":    float64(DefaultKoboldMaxLength),
+		"sampler_seed":  float64(42),
+		"stop_sequence": []interface{}{"\n"},
+		"top_a":         0.3,
+	}
+	for name, value := range want {
+		if !reflect.DeepEqual(gotReq[name], value) {
+			t.Errorf("%s = %#v, want %#v", name, gotReq[name], value)
+		}
+	}
+	if key, _ := gotReq["genkey"].(string); !strings.HasPrefix(key, "KCPP") {
+		t.Errorf("genkey = %#v", gotReq["genkey"])
+	}
+
+	if len(responses) != 2 || responses[0].Response != " world" {
+		t.Fatalf("responses = %#v", responses)
+	}
+	final := responses[1]
+	if !final.Done || final.DoneReason != "length" || len(final.Context) != 1 {
+		t.Fatalf("final response = %#v", final)
+	}
+}
+
+func TestKoboldBackendChatStreamsWithTemplate(t *testing.T) {
+	var gotReq map[string]interface{}
+	b := NewKoboldBackend("http://kobold.test", 10, KoboldChatTemplates["chatml"], DefaultKoboldMaxLength, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path != "/api/extra/generate/stream" {
+			t.Fatalf("path = %q, want /api/extra/generate/stream", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return textResponse("text/event-stream",
+			"event: message\ndata: {\"token\": \"Hi\", \"finish_reason\": null}\n\n"+
+				"event: message\ndata: {\"token\": \" there\", \"finish_reason\": null}\n\n"+
+				"event: message\ndata: {\"token\": \"\", \"finish_reason\": \"stop\"}\n\n"), nil
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:  "m",
+		Stream: true,
+		Messages: []models.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "hello", Images: []string{"aW1n"}},
+		},
+		Options: map[string]interface{}{"stop": []interface{}{"User:"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var content strings.Builder
+	var final models.ChatResponse
+	for resp := range respChan {
+		content.WriteString(resp.Message.Content)
+		if resp.Done {
+			final = resp
+		}
+	}
+
+	wantPrompt := "<|im_start|>system\nbe brief<|im_end|>\n<|im_start|>user\nhello<|im_end|>\n<|im_start|>assistant\n"
+	if gotReq["prompt"] != wantPrompt {
+		t.Fatalf("prompt = %q, want %q", gotReq["prompt"], wantPrompt)
+	}
+	wantStop := []interface{}{"User:", "<|im_end|>", "<|im_start|>"}
+	if !reflect.DeepEqual(gotReq["stop_sequence"], wantStop) || gotReq["trim_stop"] != true {
+		t.Fatalf("stop_sequence = %#v, trim_stop = %#v", gotReq["stop_sequence"], gotReq["trim_stop"])
+	}
+	if !reflect.DeepEqual(gotReq["images"], []interface{}{"aW1n"}) {
+		t.Fatalf("images = %#v", gotReq["images"])
+	}
+	if content.String() != "Hi there" || !final.Done || final.DoneReason != "stop" {
+		t.Fatalf("content = %q, final = %#v", content.String(), final)
+	}
+}
+
+func TestKoboldBackendListModels(t *testing.T) {
+	b := NewKoboldBackend("http://kobold.test", 10, KoboldChatTemplates["chatml"], DefaultKoboldMaxLength, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Path {
+		case "/api/v1/model":
+			return jsonResponse(`{"result":"koboldcpp/Mistral-7B-Instruct"}`), nil
+		case "/api/extra/true_max_context_length":
+			return jsonResponse(`{"value":8192}`), nil
+		}
+		t.Fatalf("unexpected path %q", r.URL.Path)
+		return nil, nil
+	})
+
+	list, err := b.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	if len(list.Models) != 1 || list.Models[0].Name != "Mistral-7B-Instruct" || list.Models[0].ContextLength != 8192 {
+		t.Fatalf("ListModels() = %#v", list.Models)
+	}
+}
@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// LoadBalanceReplica is one of the backends requests are balanced across
+type LoadBalanceReplica struct {
+	Name    string
+	Backend Backend
+}
+
+// LoadBalanceBackend spreads generate, chat and embeddings requests across
+// replicas serving the same models, such as several Ollama servers. Requests
+// for a model stick to the replica it was last sent to, which still has it
+// loaded, until the model's keep_alive has passed since its last request.
+// Other models go to the replica with the fewest requests in flight, then
+// the fewest models assigned. Model lists and descriptions go to the wrapped
+// backend.
+type LoadBalanceBackend struct {
+	Backend
+	replicas  []LoadBalanceReplica
+	keepAlive time.Duration // Default keep_alive (< 0 = forever)
+
+	mu          sync.Mutex
+	inFlight    []int
+	assignments map[string]*modelAssignment
+}
+
+// modelAssignment is the replica a model is loaded on
+type modelAssignment struct {
+	replica int
+	active  int       // Requests for the model in flight
+	expires time.Time // When it unloads after the last request (zero = never)
+}
+
+// LoadBalanceStats reports the requests in flight and models assigned on
+// each replica.
+type LoadBalanceStats struct {
+	KeepAlive int                       `json:"keep_alive"` // Seconds (-1 = forever)
+	Replicas  []LoadBalanceReplicaStats `json:"replicas"`
+}
+
+// LoadBalanceReplicaStats is one replica's entry in LoadBalanceStats
+type LoadBalanceReplicaStats struct {
+	Name     string   `json:"name"`
+	InFlight int      `json:"in_flight"`
+	Models   []string `json:"models"`
+}
+
+// NewLoadBalanceBackend creates a wrapper around defaultBackend that balances
+// requests across replicas. keepAlive is how long a model stays loaded
+// after its last request when the request doesn't say (< 0 = forever); it
+// should match the replicas' own keep_alive.
+func NewLoadBalanceBackend(defaultBackend Backend, replicas []LoadBalanceReplica, keepAlive time.Duration) *LoadBalanceBackend {
+	return &LoadBalanceBackend{
+		Backend:     defaultBackend,
+		replicas:    replicas,
+		keepAlive:   keepAlive,
+		inFlight:    make([]int, len(replicas)),
+		assignments: make(map[string]*modelAssignment),
+	}
+}
+
+// live reports whether the model is still loaded on its replica
+func (a *modelAssignment) live(now time.Time) bool {
+	return a.active > 0 || a.expires.IsZero() || now.Before(a.expires)
+}
+
+// acquire picks the replica for a request for model and counts the request
+// as in flight on it
+func (l *LoadBalanceBackend) acquire(model string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	a, ok := l.assignments[model]
+	if !ok || !a.live(now) {
+		assigned := make([]int, len(l.replicas))
+		for name, other := range l.assignments {
+			if !other.live(now) {
+				delete(l.assignments, name)
+				continue
+			}
+			assigned[other.replica]++
+		}
+		best := 0
+		for i := range l.replicas {
+			if l.inFlight[i] < l.inFlight[best] || (l.inFlight[i] == l.inFlight[best] && assigned[i] < assigned[best]) {
+				best = i
+			}
+		}
+		a = &modelAssignment{replica: best}
+		l.assignments[model] = a
+	}
+	a.active++
+	l.inFlight[a.replica]++
+	return a.replica
+}
+
+// release ends a request for model on replica. keepAlive is the request's
+// keep_alive. A failed request forgets the assignment, so the next request
+// for the model may pick another replica.
+func (l *LoadBalanceBackend) release(model string, replica int, keepAlive interface{}, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight[replica]--
+
+	a, ok := l.assignments[model]
+	if !ok || a.replica != replica {
+		return
+	}
+	a.active--
+	if failed && a.active == 0 {
+		delete(l.assignments, model)
+		return
+	}
+	a.expires = time.Time{}
+	if ttl := keepAliveDuration(keepAlive, l.keepAlive); ttl >= 0 {
+		a.expires = time.Now().Add(ttl)
+	}
+}
+
+// keepAliveDuration converts an Ollama keep_alive, a duration string or a
+// number of seconds, to a duration; negative means forever. Missing or
+// invalid values give fallback.
+func keepAliveDuration(keepAlive interface{}, fallback time.Duration) time.Duration {
+	var d time.Duration
+	switch v := keepAlive.(type) {
+	case float64:
+		d = time.Duration(v * float64(time.Second))
+	case int:
+		d = time.Duration(v) * time.Second
+	case string:
+		if seconds, err := strconv.Atoi(v); err == nil {
+			d = time.Duration(seconds) * time.Second
+		} else if parsed, err := time.ParseDuration(v); err == nil {
+			d = parsed
+		} else {
+			return fallback
+		}
+	default:
+		return fallback
+	}
+	if d < 0 {
+		return -1
+	}
+	return d
+}
+
+// Generate sends a generate request to the model's replica
+func (l *LoadBalanceBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	replica := l.acquire(req.Model)
+	respChan, metadata, err := l.replicas[replica].Backend.Generate(ctx, req)
+	if err != nil {
+		l.release(req.Model, replica, req.KeepAlive, true)
+		return respChan, metadata, err
+	}
+	return relayUntilClosed(ctx, respChan, func() { l.release(req.Model, replica, req.KeepAlive, false) }), metadata, nil
+}
+
+// Chat sends a chat request to the model's replica
+func (l *LoadBalanceBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	replica := l.acquire(req.Model)
+	respChan, metadata, err := l.replicas[replica].Backend.Chat(ctx, req)
+	if err != nil {
+		l.release(req.Model, replica, req.KeepAlive, true)
+		return respChan, metadata, err
+	}
+	return relayUntilClosed(ctx, respChan, func() { l.release(req.Model, replica, req.KeepAlive, false) }), metadata, nil
+}
+
+// Embed sends an embeddings request to the model's replica
+func (l *LoadBalanceBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	replica := l.acquire(req.Model)
+	resp, metadata, err := l.replicas[replica].Backend.Embed(ctx, req)
+	l.release(req.Model, replica, req.KeepAlive, err != nil)
+	return resp, metadata, err
+}
+
+// Stats returns the requests in flight and the models assigned on each
+// replica.
+func (l *LoadBalanceBackend) Stats() LoadBalanceStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	stats := LoadBalanceStats{KeepAlive: -1, Replicas: make([]LoadBalanceReplicaStats, len(l.replicas))}
+	if l.keepAlive >= 0 {
+		stats.KeepAlive = int(l.keepAlive / time.Second)
+	}
+	for i, replica := range l.replicas {
+		stats.Replicas[i] = LoadBalanceReplicaStats{Name: replica.Name, InFlight: l.inFlight[i], Models: []string{}}
+	}
+	for model, a := range l.assignments {
+		if a.live(now) {
+			stats.Replicas[a.replica].Models = append(stats.Replicas[a.replica].Models, model)
+		}
+	}
+	for i := range stats.Replicas {
+		slices.Sort(stats.Replicas[i].Models)
+	}
+	return stats
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func generateOn(t *testing.T, l *LoadBalanceBackend, model string, keepAlive interface{}) string {
+	t.Helper()
+	respChan, _, err := l.Generate(context.Background(), models.GenerateRequest{Model: model, Prompt: "hi", KeepAlive: keepAlive})
+	if err != nil {
+		t.Fatalf("Generate(%s) error = %v", model, err)
+	}
+	var response string
+	for resp := range respChan {
+		response += resp.Response
+	}
+	return response
+}
+
+func TestLoadBalanceBackendKeepsModelOnReplica(t *testing.T) {
+	l := NewLoadBalanceBackend(nil, []LoadBalanceReplica{
+		{Name: "primary", Backend: NewMockBackend(MockOptions{Reply: "a"})},
+		{Name: "gpu2", Backend: NewMockBackend(MockOptions{Reply: "b"})},
+	}, 5*time.Minute)
+
+	first := generateOn(t, l, "llama3", nil)
+	for range 3 {
+		if got := generateOn(t, l, "llama3", nil); got != first {
+			t.Fatalf("llama3 went to %q, want it kept on %q", got, first)
+		}
+	}
+	if other := generateOn(t, l, "mistral", nil); other == first {
+		t.Fatalf("mistral went to %q with llama3, want the other replica", other)
+	}
+
+	stats := l.Stats()
+	if stats.KeepAlive != 300 || len(stats.Replicas) != 2 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+	for _, replica := range stats.Replicas {
+		if replica.InFlight != 0 || len(replica.Models) != 1 {
+			t.Fatalf("Stats() replica = %+v", replica)
+		}
+	}
+}
+
+func TestLoadBalanceBackendSpreadsBusyReplicas(t *testing.T) {
+	held := &channelBackend{chat: make(chan models.ChatResponse)}
+	defer close(held.chat)
+	l := NewLoadBalanceBackend(nil, []LoadBalanceReplica{
+		{Name: "primary", Backend: held},
+		{Name: "gpu2", Backend: NewMockBackend(MockOptions{Reply: "b"})},
+	}, 5*time.Minute)
+
+	generateOn(t, l, "llama3", nil)
+	generateOn(t, l, "phi3", nil)
+
+	// A stream that hasn't ended keeps llama3's request in flight on primary
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := l.Chat(ctx, models.ChatRequest{Model: "llama3", Stream: true, Messages: []models.Message{{Role: "user", Content: "hi"}}}); err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if inFlight := l.Stats().Replicas[0].InFlight; inFlight != 1 {
+		t.Fatalf("primary in flight = %d, want 1", inFlight)
+	}
+
+	if got := generateOn(t, l, "mistral", nil); got != "b" {
+		t.Fatalf("mistral went to %q, want the idle replica", got)
+	}
+}
+
+func TestLoadBalanceBackendExpiresAssignments(t *testing.T) {
+	l := NewLoadBalanceBackend(nil, []LoadBalanceReplica{
+		{Name: "primary", Backend: NewMockBackend(MockOptions{Reply: "a"})},
+		{Name: "gpu2", Backend: NewMockBackend(MockOptions{Reply: "b"})},
+	}, 5*time.Minute)
+
+	generateOn(t, l, "llama3", float64(0))
+	generateOn(t, l, "mistral", "-1")
+	if stats := l.Stats(); len(stats.Replicas[0].Models)+len(stats.Replicas[1].Models) != 1 {
+		t.Fatalf("Stats() = %+v, want only mistral assigned", stats)
+	}
+}
+
+func TestLoadBalanceBackendForgetsFailedReplica(t *testing.T) {
+	l := NewLoadBalanceBackend(nil, []LoadBalanceReplica{
+		{Name: "primary", Backend: NewMockBackend(MockOptions{ErrorRate: 1, ErrorStatus: 503})},
+		{Name: "gpu2", Backend: NewMockBackend(MockOptions{Reply: "b"})},
+	}, 5*time.Minute)
+
+	if _, _, err := l.Generate(context.Background(), models.GenerateRequest{Model: "llama3", Prompt: "hi"}); err == nil {
+		t.Fatal("Generate() error = nil, want the failing replica's error")
+	}
+	if stats := l.Stats(); len(stats.Replicas[0].Models) != 0 || stats.Replicas[0].InFlight != 0 {
+		t.Fatalf("Stats() = %+v, want the failed assignment forgotten", stats)
+	}
+}
+
+func TestKeepAliveDuration(t *testing.T) {
+	for _, tc := range []struct {
+		keepAlive interface{}
+		want      time.Duration
+	}{
+		{nil, time.Minute},
+		{"10m", 10 * time.Minute},
+		{"30", 30 * time.Second},
+		{float64(90), 90 * time.Second},
+		{float64(-1), -1},
+		{"-1m", -1},
+		{"soon", time.Minute},
+	} {
+		if got := keepAliveDuration(tc.keepAlive, time.Minute); got != tc.want {
+			t.Errorf("keepAliveDuration(%#v) = %v, want %v", tc.keepAlive, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// MockOptions configures a MockBackend
+type MockOptions struct {
+	Reply           string         // Canned reply; "" echoes the last user message or the prompt
+	Models          []string       // Models listed; empty lists "mock"
+	Delay           time.Duration  // Wait before answering, like a backend loading a model
+	TokensPerSecond float64        // Rate replies are streamed at (0 = all at once)
+	ErrorRate       float64        // Fraction of requests, 0 to 1, that fail with ErrorStatus
+	ErrorStatus     int            // HTTP status of injected errors (0 = 500)
+	Scenarios       []MockScenario // Scripted replies; the first that matches is used
+}
+
+// MockScenario is a scripted answer to requests whose last user message (or
+// prompt) contains Match. An empty Match matches every request.
+type MockScenario struct {
+	Match     string
+	Reply     string         // "" = the default reply
+	ToolCalls []MockToolCall // Returned after the reply, for chat requests
+	Status    int            // Fail with this HTTP status instead of answering (0 = don't)
+	Error     string         // Body of that failure
+	FailAfter int            // Break the stream off after this many tokens (0 = don't)
+}
+
+// MockToolCall is a tool call returned by a MockScenario
+type MockToolCall struct {
+	Name      string
+	Arguments map[string]interface{}
+}
+
+// MockBackend implements the Backend interface without a model: it answers
+// every request with a canned, echoed or scripted reply, streamed word by
+// word. It is meant for testing clients and the proxy itself.
+type MockBackend struct {
+	opts MockOptions
+}
+
+// NewMockBackend creates a new mock backend
+func NewMockBackend(opts MockOptions) *MockBackend {
+	if len(opts.Models) == 0 {
+		opts.Models = []string{"mock"}
+	}
+	if opts.ErrorStatus == 0 {
+		opts.ErrorStatus = http.StatusInternalServerError
+	}
+	return &MockBackend{opts: opts}
+}
+
+// mockURL is recorded as the URL of every mock request
+const mockURL = "mock://"
+
+// Generate answers a generate request
+func (m *MockBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	respChan := make(chan models.GenerateResponse, 10)
+	scenario := m.scenarioFor(req.Prompt)
+	metadata, err := m.begin(ctx, req, scenario)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, err
+	}
+	tokens := mockTokens(m.replyFor(scenario, req.Prompt))
+
+	go func() {
+		defer close(respChan)
+
+		startTime := time.Now()
+		var rawResponse strings.Builder
+		send := func(resp models.GenerateResponse) bool {
+			writeMockLine(&rawResponse, resp)
+			select {
+			case respChan <- resp:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		err := m.stream(ctx, tokens, scenario.FailAfter, func(token string) bool {
+			return send(models.GenerateResponse{Model: req.Model, CreatedAt: time.Now(), Response: token})
+		})
+		if err != nil {
+			// A response that broke off ends without a final response
+			metadata.RawResponse = rawResponse.String()
+			metadata.StreamErr = err
+			return
+		}
+
+		final := models.GenerateResponse{
+			Model:           req.Model,
+			CreatedAt:       time.Now(),
+			Done:            true,
+			DoneReason:      "stop",
+			PromptEvalCount: mockTokenCount(req.System + " " + req.Prompt),
+			EvalCount:       len(tokens),
+		}
+		final.TotalDuration = time.Since(startTime).Nanoseconds()
+		final.EvalDuration = final.TotalDuration
+		metadata.Usage = countedUsage(final.PromptEvalCount, final.EvalCount)
+		writeMockLine(&rawResponse, final)
+		metadata.RawResponse = rawResponse.String()
+		send(final)
+	}()
+
+	return respChan, metadata, nil
+}
+
+// Chat answers a chat request. Tool calls of the matching scenario are sent
+// after the reply.
+func (m *MockBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	respChan := make(chan models.ChatResponse, 10)
+	lastUser := ""
+	var promptText strings.Builder
+	for _, msg := range req.Messages {
+		promptText.WriteString(msg.Content)
+		promptText.WriteString(" ")
+		if msg.Role == "user" {
+			lastUser = msg.Content
+		}
+	}
+	scenario := m.scenarioFor(lastUser)
+	metadata, err := m.begin(ctx, req, scenario)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, err
+	}
+	tokens := mockTokens(m.replyFor(scenario, lastUser))
+
+	go func() {
+		defer close(respChan)
+
+		startTime := time.Now()
+		var rawResponse strings.Builder
+		send := func(resp models.ChatResponse) bool {
+			writeMockLine(&rawResponse, resp)
+			select {
+			case respChan <- resp:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		err := m.stream(ctx, tokens, scenario.FailAfter, func(token string) bool {
+			return send(models.ChatResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now(),
+				Message:   models.Message{Role: "assistant", Content: token},
+			})
+		})
+		if err != nil {
+			// A response that broke off ends without a final response
+			metadata.RawResponse = rawResponse.String()
+			metadata.StreamErr = err
+			return
+		}
+
+		if len(scenario.ToolCalls) > 0 {
+			toolCalls := make([]interface{}, 0, len(scenario.ToolCalls))
+			for _, call := range scenario.ToolCalls {
+				arguments := call.Arguments
+				if arguments == nil {
+					arguments = map[string]interface{}{}
+				}
+				toolCalls = append(toolCalls, map[string]interface{}{
+					"id": generateToolCallID(),
+					"function": map[string]interface{}{
+						"name":      call.Name,
+						"arguments": arguments,
+					},
+				})
+			}
+			if !send(models.ChatResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now(),
+				Message:   models.Message{Role: "assistant", ToolCalls: toolCalls},
+			}) {
+				return
+			}
+		}
+
+		final := models.ChatResponse{
+			Model:           req.Model,
+			CreatedAt:       time.Now(),
+			Message:         models.Message{Role: "assistant"},
+			Done:            true,
+			DoneReason:      "stop",
+			PromptEvalCount: mockTokenCount(promptText.String()),
+			EvalCount:       len(tokens),
+		}
+		final.TotalDuration = time.Since(startTime).Nanoseconds()
+		final.EvalDuration = final.TotalDuration
+		final.Usage = countedUsage(final.PromptEvalCount, final.EvalCount)
+		metadata.Usage = final.Usage
+		writeMockLine(&rawResponse, final)
+		metadata.RawResponse = rawResponse.String()
+		send(final)
+	}()
+
+	return respChan, metadata, nil
+}
+
+// ListModels returns the configured models
+func (m *MockBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	var modelsResp models.ModelsResponse
+	for _, name := range m.opts.Models {
+		sum := sha256.Sum256([]byte(name))
+		modelsResp.Models = append(modelsResp.Models, models.ModelInfo{
+			Name:         name,
+			Model:        name,
+			Digest:       fmt.Sprintf("%x", sum),
+			Details:      mockModelDetails(),
+			Capabilities: []string{"completion", "tools", "embedding"},
+		})
+	}
+	return modelsResp, nil
+}
+
+// ShowModel describes any model, since the mock answers requests for any
+func (m *MockBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	return models.ShowResponse{
+		Details:      mockModelDetails(),
+		ModelInfo:    map[string]interface{}{"general.architecture": "mock"},
+		Capabilities: []string{"completion", "tools", "embedding"},
+	}, nil
+}
+
+// Embed returns a unit vector derived from the hash of each input, so the
+// same input always gets the same embedding. Vectors have req.Dimensions
+// values, or 16.
+func (m *MockBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	scenario := m.scenarioFor(strings.Join(req.Input, "\n"))
+	metadata, err := m.begin(ctx, req, scenario)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, err
+	}
+
+	dimensions := req.Dimensions
+	if dimensions <= 0 {
+		dimensions = 16
+	}
+	resp := models.EmbedResponse{Model: req.Model, Embeddings: make([][]float64, 0, len(req.Input))}
+	for _, input := range req.Input {
+		resp.Embeddings = append(resp.Embeddings, mockEmbedding(input, dimensions))
+		resp.PromptEvalCount += mockTokenCount(input)
+	}
+	if data, err := json.Marshal(resp); err == nil {
+		metadata.RawResponse = string(data)
+	}
+	return resp, metadata, nil
+}
+
+// scenarioFor returns the first scenario matching text, or a zero scenario
+// that gives the default reply
+func (m *MockBackend) scenarioFor(text string) MockScenario {
+	for _, scenario := range m.opts.Scenarios {
+		if strings.Contains(text, scenario.Match) {
+			return scenario
+		}
+	}
+	return MockScenario{}
+}
+
+// replyFor returns the reply to text under scenario
+func (m *MockBackend) replyFor(scenario MockScenario, text string) string {
+	if scenario.Reply != "" {
+		return scenario.Reply
+	}
+	if m.opts.Reply != "" {
+		return m.opts.Reply
+	}
+	return text
+}
+
+// begin records req on new metadata, waits for the configured delay and
+// returns the error the request fails with, if any: the scenario's, or an
+// injected one
+func (m *MockBackend) begin(ctx context.Context, req interface{}, scenario MockScenario) (*BackendMetadata, error) {
+	metadata := &BackendMetadata{URL: mockURL}
+	if data, err := json.Marshal(req); err == nil {
+		metadata.RawRequest = string(data)
+	}
+
+	if !sleepContext(ctx, m.opts.Delay) {
+		return metadata, ctx.Err()
+	}
+
+	status, message := scenario.Status, scenario.Error
+	if status == 0 && m.opts.ErrorRate > 0 && rand.Float64() < m.opts.ErrorRate {
+		status, message = m.opts.ErrorStatus, "injected mock error"
+	}
+	if status == 0 {
+		metadata.StatusCode = http.StatusOK
+		return metadata, nil
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	body, _ := json.Marshal(map[string]string{"error": message})
+	metadata.StatusCode = status
+	metadata.RawResponse = string(body)
+	return metadata, &StatusError{StatusCode: status, Body: string(body)}
+}
+
+// stream calls send with each token at the configured rate. It returns an
+// error if the stream breaks off after failAfter tokens (0 = never), and
+// stops without one if ctx is done.
+func (m *MockBackend) stream(ctx context.Context, tokens []string, failAfter int, send func(string) bool) error {
+	var interval time.Duration
+	if m.opts.TokensPerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / m.opts.TokensPerSecond)
+	}
+	for i, token := range tokens {
+		if failAfter > 0 && i == failAfter {
+			break
+		}
+		if i > 0 && !sleepContext(ctx, interval) {
+			return ctx.Err()
+		}
+		if !send(token) {
+			return ctx.Err()
+		}
+	}
+	if failAfter > 0 {
+		return fmt.Errorf("mock stream broken off after %d tokens", min(failAfter, len(tokens)))
+	}
+	return nil
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// mockTokens splits text into the words it is streamed as, each keeping the
+// whitespace that follows it
+func mockTokens(text string) []string {
+	var tokens []string
+	start := 0
+	for i := 1; i < len(text); i++ {
+		if isMockSpace(text[i-1]) && !isMockSpace(text[i]) {
+			tokens = append(tokens, text[start:i])
+			start = i
+		}
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+	return tokens
+}
+
+func isMockSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r'
+}
+
+// mockTokenCount is the number of tokens counted for text
+func mockTokenCount(text string) int {
+	return len(strings.Fields(text))
+}
+
+// mockEmbedding returns a unit vector of dimensions values derived from the
+// SHA-256 of input
+func mockEmbedding(input string, dimensions int) []float64 {
+	vector := make([]float64, dimensions)
+	var norm float64
+	for i := range vector {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", i, input)))
+		vector[i] = float64(binary.BigEndian.Uint32(sum[:4]))/math.MaxUint32*2 - 1
+		norm += vector[i] * vector[i]
+	}
+	if norm = math.Sqrt(norm); norm > 0 {
+		for i := range vector {
+			vector[i] /= norm
+		}
+	}
+	return vector
+}
+
+func mockModelDetails() models.ModelDetails {
+	return models.ModelDetails{Format: "mock", Family: "mock", Families: []string{"mock"}, ParameterSize: "0"}
+}
+
+// writeMockLine appends resp to a raw response as one line of JSON
+func writeMockLine(raw *strings.Builder, resp interface{}) {
+	line, _ := json.Marshal(resp)
+	raw.Write(line)
+	raw.WriteString("\n")
+}
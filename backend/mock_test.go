@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestMockBackendEchoesLastUserMessage(t *testing.T) {
+	b := NewMockBackend(MockOptions{})
+	respChan, metadata, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "mock",
+		Messages: []models.Message{{Role: "system", Content: "Be brief"}, {Role: "user", Content: "hello there world"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	var content string
+	var chunks int
+	var final models.ChatResponse
+	for resp := range respChan {
+		if resp.Done {
+			final = resp
+			continue
+		}
+		chunks++
+		content += resp.Message.Content
+	}
+	if content != "hello there world" || chunks != 3 {
+		t.Fatalf("content = %q in %d chunks, want the echo in 3", content, chunks)
+	}
+	if !final.Done || final.EvalCount != 3 || final.PromptEvalCount != 5 {
+		t.Fatalf("final = %+v", final)
+	}
+	if metadata.StatusCode != 200 || metadata.Usage == nil || metadata.Usage.TotalTokens != 8 || metadata.RawRequest == "" {
+		t.Fatalf("metadata = %+v", metadata)
+	}
+}
+
+func TestMockBackendScenarios(t *testing.T) {
+	b := NewMockBackend(MockOptions{
+		Reply: "default reply",
+		Scenarios: []MockScenario{
+			{Match: "weather", Reply: "Checking.", ToolCalls: []MockToolCall{{Name: "get_weather", Arguments: map[string]interface{}{"city": "Paris"}}}},
+			{Match: "overload", Status: 503, Error: "busy"},
+			{Match: "flaky", Reply: "one two three four", FailAfter: 2},
+		},
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{Messages: []models.Message{{Role: "user", Content: "what's the weather?"}}})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var toolCalls []interface{}
+	for resp := range respChan {
+		toolCalls = append(toolCalls, resp.Message.ToolCalls...)
+	}
+	if len(toolCalls) != 1 {
+		t.Fatalf("tool calls = %v, want one", toolCalls)
+	}
+	fn := toolCalls[0].(map[string]interface{})["function"].(map[string]interface{})
+	if fn["name"] != "get_weather" || fn["arguments"].(map[string]interface{})["city"] != "Paris" {
+		t.Fatalf("tool call function = %v", fn)
+	}
+
+	_, metadata, err := b.Generate(context.Background(), models.GenerateRequest{Prompt: "overload please"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 503 || statusErr.Body != `{"error":"busy"}` {
+		t.Fatalf("Generate() error = %v, want a 503 status error", err)
+	}
+	if metadata.StatusCode != 503 {
+		t.Fatalf("metadata.StatusCode = %d, want 503", metadata.StatusCode)
+	}
+
+	genChan, metadata, err := b.Generate(context.Background(), models.GenerateRequest{Prompt: "a flaky one"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var text string
+	for resp := range genChan {
+		if resp.Done {
+			t.Fatal("got a final response from a stream that broke off")
+		}
+		text += resp.Response
+	}
+	if text != "one two " || metadata.StreamErr == nil {
+		t.Fatalf("text = %q, StreamErr = %v; want two tokens and an error", text, metadata.StreamErr)
+	}
+
+	genChan, _, err = b.Generate(context.Background(), models.GenerateRequest{Prompt: "anything else"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	text = ""
+	for resp := range genChan {
+		text += resp.Response
+	}
+	if text != "default reply" {
+		t.Fatalf("text = %q, want the default reply", text)
+	}
+}
+
+func TestMockBackendEmbeddingsAreDeterministic(t *testing.T) {
+	b := NewMockBackend(MockOptions{})
+	resp, _, err := b.Embed(context.Background(), models.EmbedRequest{Input: models.EmbedInput{"a", "b", "a"}, Dimensions: 8})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if len(resp.Embeddings) != 3 || len(resp.Embeddings[0]) != 8 {
+		t.Fatalf("embeddings = %v, want 3 of 8 values", resp.Embeddings)
+	}
+	var norm float64
+	for i, v := range resp.Embeddings[0] {
+		norm += v * v
+		if v != resp.Embeddings[2][i] {
+			t.Fatal("the same input got different embeddings")
+		}
+	}
+	if norm < 0.999 || norm > 1.001 {
+		t.Fatalf("squared norm = %g, want 1", norm)
+	}
+	if resp.Embeddings[0][0] == resp.Embeddings[1][0] {
+		t.Fatal("different inputs got the same embedding")
+	}
+}
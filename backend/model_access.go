@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"llm_proxy/models"
+)
+
+// ModelAccessBackend wraps another backend and keeps clients to the models
+// they may use: other models are left out of the model list, and requests
+// for them are rejected with 403.
+type ModelAccessBackend struct {
+	Backend
+	allow []*regexp.Regexp // Models that may be used (none = all)
+	deny  []*regexp.Regexp // Models that may not be used, even if allowed
+}
+
+// NewModelAccessBackend creates a wrapper around inner that allows the
+// models matching a pattern in allow (any model if allow is empty) unless
+// they match one in deny.
+func NewModelAccessBackend(inner Backend, allow, deny []*regexp.Regexp) *ModelAccessBackend {
+	return &ModelAccessBackend{Backend: inner, allow: allow, deny: deny}
+}
+
+// Allowed reports whether clients may use model. A model without a tag is
+// also matched as its ":latest" tag, and the other way round, as Ollama
+// treats them as the same model.
+func (m *ModelAccessBackend) Allowed(model string) bool {
+	names := []string{model}
+	if base, ok := strings.CutSuffix(model, ":latest"); ok {
+		names = append(names, base)
+	} else if !strings.Contains(model, ":") {
+		names = append(names, model+":latest")
+	}
+	return (len(m.allow) == 0 || matchesAny(m.allow, names)) && !matchesAny(m.deny, names)
+}
+
+// matchesAny reports whether any of patterns matches any of names
+func matchesAny(patterns []*regexp.Regexp, names []string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if pattern.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check returns the error of a request for a model clients may not use
+func (m *ModelAccessBackend) check(model string) error {
+	if m.Allowed(model) {
+		return nil
+	}
+	return &RejectedError{Status: http.StatusForbidden, Message: fmt.Sprintf("model %q is not available through this proxy", model)}
+}
+
+// Generate rejects requests for models clients may not use.
+func (m *ModelAccessBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	if err := m.check(req.Model); err != nil {
+		return closedChan[models.GenerateResponse](), &BackendMetadata{}, err
+	}
+	return m.Backend.Generate(ctx, req)
+}
+
+// Chat rejects requests for models clients may not use.
+func (m *ModelAccessBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	if err := m.check(req.Model); err != nil {
+		return closedChan[models.ChatResponse](), &BackendMetadata{}, err
+	}
+	return m.Backend.Chat(ctx, req)
+}
+
+// Embed rejects requests for models clients may not use.
+func (m *ModelAccessBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	if err := m.check(req.Model); err != nil {
+		return models.EmbedResponse{}, &BackendMetadata{}, err
+	}
+	return m.Backend.Embed(ctx, req)
+}
+
+// ShowModel only describes models clients may use.
+func (m *ModelAccessBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	if err := m.check(model); err != nil {
+		return models.ShowResponse{}, err
+	}
+	return m.Backend.ShowModel(ctx, model)
+}
+
+// ListModels leaves out the models clients may not use.
+func (m *ModelAccessBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	resp, err := m.Backend.ListModels(ctx)
+	if err != nil {
+		return resp, err
+	}
+	allowed := make([]models.ModelInfo, 0, len(resp.Models))
+	for _, model := range resp.Models {
+		if m.Allowed(model.Name) {
+			allowed = append(allowed, model)
+		}
+	}
+	resp.Models = allowed
+	return resp, nil
+}
@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestModelAccessBackend(t *testing.T) {
+	inner := NewMockBackend(MockOptions{Models: []string{"llama3:latest", "llama3:70b", "qwen3", "gpt-4o"}})
+	m := NewModelAccessBackend(inner,
+		[]*regexp.Regexp{regexp.MustCompile("^(?:llama3(:.*)?|qwen3)$")},
+		[]*regexp.Regexp{regexp.MustCompile("^(?:.*:70b)$")})
+
+	list, err := m.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	var names []string
+	for _, model := range list.Models {
+		names = append(names, model.Name)
+	}
+	if len(names) != 2 || names[0] != "llama3:latest" || names[1] != "qwen3" {
+		t.Fatalf("listed models = %v, want llama3:latest and qwen3", names)
+	}
+
+	for model, want := range map[string]bool{"llama3": true, "qwen3:latest": true, "llama3:70b": false, "gpt-4o": false} {
+		if got := m.Allowed(model); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", model, got, want)
+		}
+	}
+
+	_, _, err = m.Chat(context.Background(), models.ChatRequest{Model: "gpt-4o", Messages: []models.Message{{Role: "user", Content: "hi"}}})
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || rejected.StatusCode() != http.StatusForbidden {
+		t.Fatalf("Chat(gpt-4o) error = %v, want a 403 rejection", err)
+	}
+	if _, err := m.ShowModel(context.Background(), "llama3:70b"); !errors.As(err, &rejected) {
+		t.Fatalf("ShowModel(llama3:70b) error = %v, want a rejection", err)
+	}
+	respChan, _, err := m.Generate(context.Background(), models.GenerateRequest{Model: "qwen3", Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Generate(qwen3) error = %v", err)
+	}
+	for range respChan {
+	}
+}
@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"context"
+	"sync"
+
+	"llm_proxy/models"
+)
+
+// AliasBackend wraps another backend and rewrites model aliases to the
+// backend's model names. Clients only ever see the alias: responses carry
+// the alias as their model name, and the model list includes each alias
+// whose target the backend serves.
+type AliasBackend struct {
+	Backend
+	mu      sync.RWMutex
+	aliases map[string]string // Alias -> backend model name
+}
+
+// NewAliasBackend creates an aliasing wrapper around inner.
+func NewAliasBackend(inner Backend, aliases map[string]string) *AliasBackend {
+	return &AliasBackend{Backend: inner, aliases: aliases}
+}
+
+// SetAliases replaces the alias table, e.g. after a config reload.
+func (a *AliasBackend) SetAliases(aliases map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.aliases = aliases
+}
+
+// resolve returns the backend model name for an alias.
+func (a *AliasBackend) resolve(model string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	target, ok := a.aliases[model]
+	return target, ok
+}
+
+// Generate sends the request under the backend model name.
+func (a *AliasBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	alias := req.Model
+	target, ok := a.resolve(alias)
+	if !ok {
+		return a.Backend.Generate(ctx, req)
+	}
+	req.Model = target
+	respChan, metadata, err := a.Backend.Generate(ctx, req)
+	if err != nil {
+		return respChan, metadata, err
+	}
+	return rewriteStream(ctx, respChan, func(resp *models.GenerateResponse) { resp.Model = alias }), metadata, nil
+}
+
+// Chat sends the request under the backend model name.
+func (a *AliasBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	alias := req.Model
+	target, ok := a.resolve(alias)
+	if !ok {
+		return a.Backend.Chat(ctx, req)
+	}
+	req.Model = target
+	respChan, metadata, err := a.Backend.Chat(ctx, req)
+	if err != nil {
+		return respChan, metadata, err
+	}
+	return rewriteStream(ctx, respChan, func(resp *models.ChatResponse) { resp.Model = alias }), metadata, nil
+}
+
+// Embed sends the request under the backend model name.
+func (a *AliasBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	alias := req.Model
+	target, ok := a.resolve(alias)
+	if !ok {
+		return a.Backend.Embed(ctx, req)
+	}
+	req.Model = target
+	resp, metadata, err := a.Backend.Embed(ctx, req)
+	if err == nil {
+		resp.Model = alias
+	}
+	return resp, metadata, err
+}
+
+// ShowModel describes an alias's target model.
+func (a *AliasBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	if target, ok := a.resolve(model); ok {
+		model = target
+	}
+	return a.Backend.ShowModel(ctx, model)
+}
+
+// ListModels adds an entry for each alias whose target is in the backend's
+// model list.
+func (a *AliasBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	resp, err := a.Backend.ListModels(ctx)
+	if err != nil {
+		return resp, err
+	}
+	byName := make(map[string]models.ModelInfo, len(resp.Models))
+	for _, model := range resp.Models {
+		byName[model.Name] = model
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for alias, target := range a.aliases {
+		if _, exists := byName[alias]; exists {
+			continue
+		}
+		if info, ok := byName[target]; ok {
+			info.Name = alias
+			info.Model = alias
+			if info.OpenAI != nil {
+				openai := *info.OpenAI
+				openai.ID = alias
+				info.OpenAI = &openai
+			}
+			resp.Models = append(resp.Models, info)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestAliasBackendRewritesModel(t *testing.T) {
+	inner := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	var sentModel string
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodGet {
+			return jsonResponse(`{"data":[{"id":"meta-llama/Meta-Llama-3-8B-Instruct"}]}`), nil
+		}
+		var body struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		sentModel = body.Model
+		return jsonResponse(`{"model":"meta-llama/Meta-Llama-3-8B-Instruct","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`), nil
+	})
+	b := NewAliasBackend(inner, map[string]string{
+		"llama3":  "meta-llama/Meta-Llama-3-8B-Instruct",
+		"missing": "not-served",
+	})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "llama3",
+		Messages: []models.Message{{Role: "user", Content: "hello"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	for resp := range respChan {
+		if resp.Model != "llama3" {
+			t.Fatalf("response model = %q, want llama3", resp.Model)
+		}
+	}
+	if sentModel != "meta-llama/Meta-Llama-3-8B-Instruct" {
+		t.Fatalf("backend model = %q", sentModel)
+	}
+
+	list, err := b.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	var names []string
+	for _, model := range list.Models {
+		names = append(names, model.Name)
+	}
+	if len(names) != 2 || names[1] != "llama3" || list.Models[1].OpenAI.ID != "llama3" {
+		t.Fatalf("models = %v", names)
+	}
+	if list.Models[0].OpenAI.ID != "meta-llama/Meta-Llama-3-8B-Instruct" {
+		t.Fatalf("target model ID changed to %q", list.Models[0].OpenAI.ID)
+	}
+}
@@ -0,0 +1,126 @@
+package backend
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// ModelCacheBackend wraps another backend and serves its model list and
+// model descriptions from memory for a short time, so that clients polling
+// /api/tags or /api/show every few seconds don't reach the backend each
+// time. Errors are not cached.
+type ModelCacheBackend struct {
+	Backend
+	ttl time.Duration
+
+	mu     sync.Mutex
+	list   *modelCacheEntry[models.ModelsResponse]
+	shows  map[string]modelCacheEntry[models.ShowResponse]
+	hits   int64
+	misses int64
+}
+
+// modelCacheEntry is a cached response and when it was stored
+type modelCacheEntry[T any] struct {
+	value  T
+	stored time.Time
+}
+
+// ModelCacheStats reports the model cache's contents and effectiveness since
+// startup.
+type ModelCacheStats struct {
+	TTL          int   `json:"ttl"`
+	ModelList    bool  `json:"model_list"`
+	Descriptions int   `json:"descriptions"`
+	Hits         int64 `json:"hits"`
+	Misses       int64 `json:"misses"`
+}
+
+// NewModelCacheBackend creates a wrapper around inner that caches its model
+// list and model descriptions for ttl.
+func NewModelCacheBackend(inner Backend, ttl time.Duration) *ModelCacheBackend {
+	return &ModelCacheBackend{
+		Backend: inner,
+		ttl:     ttl,
+		shows:   make(map[string]modelCacheEntry[models.ShowResponse]),
+	}
+}
+
+// ListModels returns the cached model list, or fetches and caches it.
+func (c *ModelCacheBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	c.mu.Lock()
+	if c.list != nil && time.Since(c.list.stored) < c.ttl {
+		resp := c.list.value
+		c.hits++
+		c.mu.Unlock()
+		// Callers may append to the list, so each gets its own copy
+		resp.Models = slices.Clone(resp.Models)
+		return resp, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	resp, err := c.Backend.ListModels(ctx)
+	if err != nil {
+		return resp, err
+	}
+	c.mu.Lock()
+	c.list = &modelCacheEntry[models.ModelsResponse]{value: resp, stored: time.Now()}
+	c.mu.Unlock()
+	resp.Models = slices.Clone(resp.Models)
+	return resp, nil
+}
+
+// ShowModel returns the cached description of model, or fetches and caches
+// it.
+func (c *ModelCacheBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	c.mu.Lock()
+	if entry, ok := c.shows[model]; ok && time.Since(entry.stored) < c.ttl {
+		c.hits++
+		c.mu.Unlock()
+		return entry.value, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	resp, err := c.Backend.ShowModel(ctx, model)
+	if err != nil {
+		return resp, err
+	}
+	c.mu.Lock()
+	c.shows[model] = modelCacheEntry[models.ShowResponse]{value: resp, stored: time.Now()}
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// Invalidate empties the cache, so the next model list and descriptions
+// come from the backend. Use it after models are pulled or deleted.
+func (c *ModelCacheBackend) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list = nil
+	c.shows = make(map[string]modelCacheEntry[models.ShowResponse])
+}
+
+// Stats returns the cache's contents and hit counts.
+func (c *ModelCacheBackend) Stats() ModelCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	descriptions := 0
+	for _, entry := range c.shows {
+		if time.Since(entry.stored) < c.ttl {
+			descriptions++
+		}
+	}
+	return ModelCacheStats{
+		TTL:          int(c.ttl / time.Second),
+		ModelList:    c.list != nil && time.Since(c.list.stored) < c.ttl,
+		Descriptions: descriptions,
+		Hits:         c.hits,
+		Misses:       c.misses,
+	}
+}
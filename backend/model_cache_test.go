@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// countingModelsBackend counts the model list and description calls that
+// reach it
+type countingModelsBackend struct {
+	*MockBackend
+	lists int
+	shows int
+}
+
+func (c *countingModelsBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	c.lists++
+	return c.MockBackend.ListModels(ctx)
+}
+
+func (c *countingModelsBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	c.shows++
+	return c.MockBackend.ShowModel(ctx, model)
+}
+
+func TestModelCacheBackend(t *testing.T) {
+	inner := &countingModelsBackend{MockBackend: NewMockBackend(MockOptions{Models: []string{"llama3", "qwen3"}})}
+	c := NewModelCacheBackend(inner, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		list, err := c.ListModels(ctx)
+		if err != nil {
+			t.Fatalf("ListModels() error = %v", err)
+		}
+		if len(list.Models) != 2 {
+			t.Fatalf("ListModels() = %d model(s), want 2", len(list.Models))
+		}
+		// Appending to one caller's list must not change the cached one
+		list.Models = append(list.Models, models.ModelInfo{Name: "extra"})
+		if _, err := c.ShowModel(ctx, "llama3"); err != nil {
+			t.Fatalf("ShowModel() error = %v", err)
+		}
+	}
+	if inner.lists != 1 || inner.shows != 1 {
+		t.Fatalf("backend calls = %d list(s), %d show(s), want 1 of each", inner.lists, inner.shows)
+	}
+	stats := c.Stats()
+	if !stats.ModelList || stats.Descriptions != 1 || stats.Hits != 4 || stats.Misses != 2 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+
+	if _, err := c.ShowModel(ctx, "qwen3"); err != nil {
+		t.Fatalf("ShowModel(qwen3) error = %v", err)
+	}
+	if inner.shows != 2 {
+		t.Fatalf("backend show calls = %d, want each model described once", inner.shows)
+	}
+
+	c.Invalidate()
+	if stats := c.Stats(); stats.ModelList || stats.Descriptions != 0 {
+		t.Fatalf("Stats() after Invalidate = %+v, want an empty cache", stats)
+	}
+	if _, err := c.ListModels(ctx); err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	if inner.lists != 2 {
+		t.Fatalf("backend list calls = %d after Invalidate, want 2", inner.lists)
+	}
+}
+
+func TestModelCacheBackendExpires(t *testing.T) {
+	inner := &countingModelsBackend{MockBackend: NewMockBackend(MockOptions{Models: []string{"llama3"}})}
+	c := NewModelCacheBackend(inner, 20*time.Millisecond)
+	ctx := context.Background()
+
+	if _, err := c.ListModels(ctx); err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.ListModels(ctx); err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	if inner.lists != 2 {
+		t.Fatalf("backend list calls = %d, want the expired list fetched again", inner.lists)
+	}
+}
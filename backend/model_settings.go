@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+
+	"llm_proxy/models"
+)
+
+// DefaultModelSettingsKey is the ModelSettings key that applies to models
+// with no settings of their own
+const DefaultModelSettingsKey = "*"
+
+// ModelSettings describe a model to clients, for backends whose API reports
+// little about their models. Zero fields are left for the backend to fill.
+type ModelSettings struct {
+	ContextLength     int
+	Capabilities      []string // Ollama capabilities, e.g. "completion", "tools", "vision"
+	Family            string
+	ParameterSize     string
+	QuantizationLevel string
+	Parameters        string // Modelfile parameters, one "name value" per line
+}
+
+// modelSettingsFor returns the settings for model from settings, or those
+// under DefaultModelSettingsKey. exact reports whether model has its own.
+func modelSettingsFor(settings map[string]ModelSettings, model string) (s ModelSettings, exact bool) {
+	if s, ok := settings[model]; ok {
+		return s, true
+	}
+	return settings[DefaultModelSettingsKey], false
+}
+
+// applyModelSettings fills in a listed model's details and capabilities from
+// its settings
+func applyModelSettings(info *models.ModelInfo, s ModelSettings) {
+	if s.ContextLength > 0 {
+		info.ContextLength = s.ContextLength
+		info.Details.ContextLength = s.ContextLength
+	}
+	if s.Family != "" {
+		info.Details.Family = s.Family
+		info.Details.Families = []string{s.Family}
+	}
+	if s.ParameterSize != "" {
+		info.Details.ParameterSize = s.ParameterSize
+	}
+	if s.QuantizationLevel != "" {
+		info.Details.QuantizationLevel = s.QuantizationLevel
+	}
+	if len(s.Capabilities) > 0 {
+		info.Capabilities = slices.Clone(s.Capabilities)
+	}
+}
+
+// synthesizeShowResponse builds an Ollama /api/show response for a model
+// the backend can't describe itself, the way Ollama describes a model:
+// model_info has "general.architecture" and "<architecture>.context_length",
+// and parameters num_ctx unless the settings give parameters of their own.
+func synthesizeShowResponse(info models.ModelInfo, parameters string) models.ShowResponse {
+	architecture := info.Details.Family
+	if architecture == "" {
+		architecture = "unknown"
+	}
+	modelInfo := map[string]interface{}{"general.architecture": architecture}
+	if info.ContextLength > 0 {
+		modelInfo["context_length"] = info.ContextLength
+		modelInfo[architecture+".context_length"] = info.ContextLength
+		if parameters == "" {
+			parameters = fmt.Sprintf("num_ctx %d", info.ContextLength)
+		}
+	}
+	capabilities := info.Capabilities
+	if len(capabilities) == 0 {
+		capabilities = []string{"completion"}
+	}
+	return models.ShowResponse{
+		Parameters:   parameters,
+		Details:      info.Details,
+		ModelInfo:    modelInfo,
+		Capabilities: capabilities,
+		ModifiedAt:   info.ModifiedAt,
+	}
+}
@@ -1,12 +1,12 @@
 package backend
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -16,20 +16,38 @@ import (
 
 // OllamaBackend implements the Backend interface for Ollama
 type OllamaBackend struct {
-	endpoint string
-	client   *http.Client
+	endpoint    string
+	headers     map[string]string
+	client      *http.Client
+	maxLineSize int
 }
 
-// NewOllamaBackend creates a new Ollama backend
-func NewOllamaBackend(endpoint string, timeout int) *OllamaBackend {
+// NewOllamaBackend creates a new Ollama backend. headers are added to every
+// backend request.
+func NewOllamaBackend(endpoint string, timeout int, headers map[string]string) *OllamaBackend {
 	return &OllamaBackend{
-		endpoint: endpoint,
+		endpoint:    endpoint,
+		headers:     headers,
+		maxLineSize: DefaultMaxLineSize,
 		client: &http.Client{
-			Timeout: time.Duration(timeout) * time.Second,
+			Timeout:   time.Duration(timeout) * time.Second,
+			Transport: newDecompressTransport(nil),
 		},
 	}
 }
 
+// SetMaxLineSize sets the longest line, in bytes, accepted from a streamed
+// response. A longer line ends the stream with an error.
+func (o *OllamaBackend) SetMaxLineSize(size int) {
+	o.maxLineSize = size
+}
+
+// SetTransport replaces the HTTP transport used for backend requests; nil
+// uses the default transport. Compressed responses are still decompressed.
+func (o *OllamaBackend) SetTransport(transport http.RoundTripper) {
+	o.client.Transport = newDecompressTransport(transport)
+}
+
 // Generate handles text generation requests by forwarding to Ollama
 func (o *OllamaBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
 	respChan := make(chan models.GenerateResponse, 10)
@@ -53,18 +71,21 @@ func (o *OllamaBackend) Generate(ctx context.Context, req models.GenerateRequest
 
 	httpReq.Header.Set("Content-Type", "application/json")
 
+	setExtraHeaders(httpReq, o.headers)
+
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
 		close(respChan)
 		return respChan, metadata, fmt.Errorf("request failed: %w", err)
 	}
+	metadata.recordResponse(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		metadata.RawResponse = string(body)
 		close(respChan)
-		return respChan, metadata, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return respChan, metadata, newStatusError(resp, "")
 	}
 
 	// Handle streaming response
@@ -73,19 +94,26 @@ func (o *OllamaBackend) Generate(ctx context.Context, req models.GenerateRequest
 		defer close(respChan)
 
 		var rawResponse strings.Builder
-		scanner := bufio.NewScanner(resp.Body)
-		buf := make([]byte, 0, 64*1024)
-		scanner.Buffer(buf, 1024*1024)
+		scanner := newLineScanner(resp.Body, o.maxLineSize)
 		for scanner.Scan() {
 			line := scanner.Text()
 			rawResponse.WriteString(line)
 			rawResponse.WriteString("\n")
 
+			if err := ollamaStreamError(scanner.Bytes()); err != nil {
+				metadata.RawResponse = rawResponse.String()
+				metadata.StreamErr = err
+				return
+			}
+
 			var genResp models.GenerateResponse
 			if err := json.Unmarshal(scanner.Bytes(), &genResp); err != nil {
 				// Log error but continue
 				continue
 			}
+			if genResp.Done {
+				metadata.Usage = countedUsage(genResp.PromptEvalCount, genResp.EvalCount)
+			}
 
 			select {
 			case respChan <- genResp:
@@ -100,11 +128,28 @@ func (o *OllamaBackend) Generate(ctx context.Context, req models.GenerateRequest
 			}
 		}
 		metadata.RawResponse = rawResponse.String()
+		metadata.StreamErr = streamReadError(ctx, scanner.Err(), o.maxLineSize)
 	}()
 
 	return respChan, metadata, nil
 }
 
+// ollamaStreamError returns the error from an {"error": "..."} line, which
+// Ollama sends in place of the next chunk when generation fails part way
+// through, or nil for any other line
+func ollamaStreamError(line []byte) error {
+	if !bytes.Contains(line, []byte(`"error"`)) {
+		return nil
+	}
+	var errResp models.ErrorResponse
+	if err := json.Unmarshal(line, &errResp); err != nil || errResp.Error == "" {
+		return nil
+	}
+	err := fmt.Errorf("backend error: %s", errResp.Error)
+	log.Printf("Backend stream error: %v", err)
+	return err
+}
+
 // Chat handles chat completion requests by forwarding to Ollama
 func (o *OllamaBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
 	respChan := make(chan models.ChatResponse, 10)
@@ -128,18 +173,21 @@ func (o *OllamaBackend) Chat(ctx context.Context, req models.ChatRequest) (<-cha
 
 	httpReq.Header.Set("Content-Type", "application/json")
 
+	setExtraHeaders(httpReq, o.headers)
+
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
 		close(respChan)
 		return respChan, metadata, fmt.Errorf("request failed: %w", err)
 	}
+	metadata.recordResponse(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		metadata.RawResponse = string(body)
 		close(respChan)
-		return respChan, metadata, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return respChan, metadata, newStatusError(resp, "")
 	}
 
 	// Handle streaming response
@@ -148,15 +196,19 @@ func (o *OllamaBackend) Chat(ctx context.Context, req models.ChatRequest) (<-cha
 		defer close(respChan)
 
 		var rawResponse strings.Builder
-		scanner := bufio.NewScanner(resp.Body)
-		buf := make([]byte, 0, 64*1024)
-		scanner.Buffer(buf, 1024*1024)
+		scanner := newLineScanner(resp.Body, o.maxLineSize)
 		for scanner.Scan() {
 			// Log raw response from Ollama for debugging
 			rawBytes := scanner.Bytes()
 			rawResponse.WriteString(string(rawBytes))
 			rawResponse.WriteString("\n")
 
+			if err := ollamaStreamError(rawBytes); err != nil {
+				metadata.RawResponse = rawResponse.String()
+				metadata.StreamErr = err
+				return
+			}
+
 			var chatResp models.ChatResponse
 			if err := json.Unmarshal(rawBytes, &chatResp); err != nil {
 				// Log error but continue
@@ -174,6 +226,9 @@ func (o *OllamaBackend) Chat(ctx context.Context, req models.ChatRequest) (<-cha
 			if chatResp.Done && chatResp.LoadDuration == 0 {
 				chatResp.LoadDuration = 1
 			}
+			if chatResp.Done {
+				metadata.Usage = countedUsage(chatResp.PromptEvalCount, chatResp.EvalCount)
+			}
 
 			select {
 			case respChan <- chatResp:
@@ -188,6 +243,7 @@ func (o *OllamaBackend) Chat(ctx context.Context, req models.ChatRequest) (<-cha
 			}
 		}
 		metadata.RawResponse = rawResponse.String()
+		metadata.StreamErr = streamReadError(ctx, scanner.Err(), o.maxLineSize)
 	}()
 
 	return respChan, metadata, nil
@@ -200,6 +256,8 @@ func (o *OllamaBackend) ListModels(ctx context.Context) (models.ModelsResponse,
 		return models.ModelsResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	setExtraHeaders(httpReq, o.headers)
+
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
 		return models.ModelsResponse{}, fmt.Errorf("request failed: %w", err)
@@ -208,7 +266,7 @@ func (o *OllamaBackend) ListModels(ctx context.Context) (models.ModelsResponse,
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return models.ModelsResponse{}, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return models.ModelsResponse{}, newStatusError(resp, string(body))
 	}
 
 	var modelsResp models.ModelsResponse
@@ -243,6 +301,8 @@ func (o *OllamaBackend) ShowModel(ctx context.Context, model string) (models.Sho
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
+	setExtraHeaders(httpReq, o.headers)
+
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
 		return models.ShowResponse{}, fmt.Errorf("request failed: %w", err)
@@ -254,7 +314,7 @@ func (o *OllamaBackend) ShowModel(ctx context.Context, model string) (models.Sho
 		return models.ShowResponse{}, fmt.Errorf("failed to read response: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return models.ShowResponse{}, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return models.ShowResponse{}, newStatusError(resp, string(body))
 	}
 
 	var showResp models.ShowResponse
@@ -263,3 +323,49 @@ func (o *OllamaBackend) ShowModel(ctx context.Context, model string) (models.Sho
 	}
 	return showResp, nil
 }
+
+// Embed forwards an embeddings request to Ollama's /api/embed endpoint
+func (o *OllamaBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	metadata := &BackendMetadata{}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	metadata.RawRequest = string(data)
+	metadata.URL = o.endpoint + "/api/embed"
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", metadata.URL, bytes.NewReader(data))
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	setExtraHeaders(httpReq, o.headers)
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("request failed: %w", err)
+	}
+	metadata.recordResponse(resp)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to read response: %w", err)
+	}
+	metadata.RawResponse = string(body)
+	if resp.StatusCode != http.StatusOK {
+		return models.EmbedResponse{}, metadata, newStatusError(resp, string(body))
+	}
+
+	var embedResp models.EmbedResponse
+	if err := json.Unmarshal(body, &embedResp); err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(embedResp.Embeddings) != len(req.Input) {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("backend returned %d embeddings for %d inputs", len(embedResp.Embeddings), len(req.Input))
+	}
+	return embedResp, metadata, nil
+}
@@ -1,7 +1,6 @@
 package backend
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -9,7 +8,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,23 +20,121 @@ import (
 // OpenAIBackend implements the Backend interface for OpenAI-compatible APIs
 type OpenAIBackend struct {
 	endpoint         string
+	apiKey           string
+	headers          map[string]string
 	client           *http.Client
 	forcePromptCache bool
 	gemma4FixEnabled bool
+	passUnknownOpts  bool
+	contexts         *generateContexts
+	maxLineSize      int
+	modelSettings    map[string]ModelSettings
+	staticModels     bool                   // List only the models with settings, without asking /v1/models
+	passParams       map[string]bool        // Server-specific fields passed through from options and the extra block
+	optionNames      map[string]string      // Server-specific names of Ollama options, used before openAIOptionNames
+	defaultParams    map[string]interface{} // Fields sent with every request that doesn't set them
 }
 
-// NewOpenAIBackend creates a new OpenAI backend
-func NewOpenAIBackend(endpoint string, timeout int, forcePromptCache bool, gemma4FixEnabled bool) *OpenAIBackend {
+// NewOpenAIBackend creates a new OpenAI backend. apiKey is sent as a Bearer
+// token when set; leave it empty for unauthenticated local servers. When
+// passUnknownOptions is set, Ollama options with no OpenAI equivalent are
+// sent as extra top-level request fields instead of being dropped. headers
+// are added to every backend request.
+func NewOpenAIBackend(endpoint string, timeout int, apiKey string, forcePromptCache bool, gemma4FixEnabled bool, passUnknownOptions bool, headers map[string]string) *OpenAIBackend {
 	return &OpenAIBackend{
 		endpoint:         endpoint,
+		apiKey:           apiKey,
+		headers:          headers,
 		forcePromptCache: forcePromptCache,
 		gemma4FixEnabled: gemma4FixEnabled,
+		passUnknownOpts:  passUnknownOptions,
+		contexts:         newGenerateContexts(),
+		maxLineSize:      DefaultMaxLineSize,
 		client: &http.Client{
-			Timeout: time.Duration(timeout) * time.Second,
+			Timeout:   time.Duration(timeout) * time.Second,
+			Transport: newDecompressTransport(nil),
 		},
 	}
 }
 
+// SetTransport replaces the HTTP transport used for backend requests; nil
+// uses the default transport. Compressed responses are still decompressed.
+func (o *OpenAIBackend) SetTransport(transport http.RoundTripper) {
+	o.client.Transport = newDecompressTransport(transport)
+}
+
+// SetMaxLineSize sets the longest line, in bytes, accepted from a streamed
+// response. A longer line ends the stream with an error.
+func (o *OpenAIBackend) SetMaxLineSize(size int) {
+	o.maxLineSize = size
+}
+
+// SetModelSettings sets how models are described in /api/tags and
+// /api/show, by model name (DefaultModelSettingsKey = any other model).
+// Models with settings of their own can be shown even if /v1/models doesn't
+// list them.
+func (o *OpenAIBackend) SetModelSettings(settings map[string]ModelSettings) {
+	o.modelSettings = settings
+}
+
+// SetStaticModels makes the models with settings of their own the model
+// list, for backends whose /v1/models is missing or wrong. Otherwise they
+// are only listed when /v1/models fails.
+func (o *OpenAIBackend) SetStaticModels(static bool) {
+	o.staticModels = static
+}
+
+// SetVLLM passes the parameters only vLLM understands (see vllmParams) from
+// request options and the extra block through to the backend.
+func (o *OpenAIBackend) SetVLLM(enabled bool) {
+	o.passParams = nil
+	if enabled {
+		o.passParams = vllmParams
+	}
+}
+
+// SetTextGen sends Ollama options under text-generation-webui's own sampler
+// names (see textGenOptionNames), and passes its other sampler fields (see
+// textGenParams) through from request options and the extra block, so that
+// samplers such as mirostat, tfs and typical_p reach it.
+func (o *OpenAIBackend) SetTextGen(enabled bool) {
+	o.passParams, o.optionNames = nil, nil
+	if enabled {
+		o.passParams, o.optionNames = textGenParams, textGenOptionNames
+	}
+}
+
+// OpenRouterOptions are the OpenRouter settings sent with every request
+// that doesn't set its own
+type OpenRouterOptions struct {
+	Provider map[string]interface{} // Provider routing preferences (nil = OpenRouter's default)
+	Route    string                 // Model routing, e.g. "fallback" ("" = none)
+}
+
+// SetOpenRouter passes OpenRouter's routing fields (see openRouterParams)
+// through from request options and the extra block, sends the defaults in
+// options with requests that set none, and asks OpenRouter to report the
+// cost of each request in its usage block.
+func (o *OpenAIBackend) SetOpenRouter(options OpenRouterOptions) {
+	o.passParams = openRouterParams
+	o.defaultParams = map[string]interface{}{"usage": map[string]interface{}{"include": true}}
+	if options.Provider != nil {
+		o.defaultParams["provider"] = options.Provider
+	}
+	if options.Route != "" {
+		o.defaultParams["route"] = options.Route
+	}
+}
+
+// setHeaders adds the configured API key as a Bearer token, followed by any
+// extra configured headers
+func (o *OpenAIBackend) setHeaders(httpReq *http.Request) {
+	if o.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	setExtraHeaders(httpReq, o.headers)
+}
+
 // postChatCompletion POSTs an already-marshaled chat completion request body
 // to the backend's /v1/chat/completions endpoint and validates the status
 // code, recording the raw response on failure. Shared by the normal Chat()
@@ -49,49 +148,58 @@ func (o *OpenAIBackend) postChatCompletion(ctx context.Context, data []byte, met
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	o.setHeaders(httpReq)
 
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
+	metadata.recordResponse(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		metadata.RawResponse = string(body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, newStatusError(resp, string(body))
 	}
 
 	return resp, nil
 }
 
-// Generate handles text generation requests by translating to OpenAI format
+// Generate handles text generation requests by translating to OpenAI format.
+// The generate context is emulated, since the backend is stateless.
 func (o *OpenAIBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	req.Prompt = o.contexts.expand(ctx, req.Context, req.Prompt)
+	req.Context = nil
+	respChan, metadata, err := o.generate(ctx, req)
+	if err != nil {
+		return respChan, metadata, err
+	}
+	return o.contexts.track(ctx, req.Prompt, respChan), metadata, nil
+}
+
+func (o *OpenAIBackend) generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	if len(req.Images) > 0 {
+		// /v1/completions has no way to attach images
+		return o.generateViaChat(ctx, req)
+	}
+
 	respChan := make(chan models.GenerateResponse, 10)
 	metadata := &BackendMetadata{}
 
 	// Translate Ollama request to OpenAI completion request
 	openaiReq := models.OpenAICompletionRequest{
-		Model:       req.Model,
-		Prompt:      req.Prompt,
-		Stream:      req.Stream,
-		CachePrompt: o.forcePromptCache,
+		Model:          req.Model,
+		Prompt:         req.Prompt,
+		Stream:         req.Stream,
+		ResponseFormat: openAIResponseFormat(req.Format),
+		CachePrompt:    o.forcePromptCache,
 	}
-
-	// Map Ollama options to OpenAI parameters
-	if req.Options != nil {
-		if temp, ok := req.Options["temperature"].(float64); ok {
-			openaiReq.Temperature = temp
-		}
-		if maxTokens, ok := req.Options["num_predict"].(float64); ok {
-			openaiReq.MaxTokens = int(maxTokens)
-		}
-		if topP, ok := req.Options["top_p"].(float64); ok {
-			openaiReq.TopP = topP
-		}
+	if req.Stream {
+		openaiReq.StreamOptions = &models.OpenAIStreamOptions{IncludeUsage: true}
 	}
 
-	data, err := json.Marshal(openaiReq)
+	data, err := marshalWithOptions(openaiReq, o.requestParams(req.Options, req.Extra))
 	if err != nil {
 		close(respChan)
 		return respChan, metadata, fmt.Errorf("failed to marshal request: %w", err)
@@ -108,19 +216,21 @@ func (o *OpenAIBackend) Generate(ctx context.Context, req models.GenerateRequest
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	o.setHeaders(httpReq)
 
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
 		close(respChan)
 		return respChan, metadata, fmt.Errorf("request failed: %w", err)
 	}
+	metadata.recordResponse(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		metadata.RawResponse = string(body)
 		close(respChan)
-		return respChan, metadata, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return respChan, metadata, newStatusError(resp, string(body))
 	}
 
 	// Handle streaming response
@@ -138,14 +248,61 @@ func (o *OpenAIBackend) Generate(ctx context.Context, req models.GenerateRequest
 	return respChan, metadata, nil
 }
 
+// generateViaChat sends a generate request as a single-turn chat completion,
+// so that images can be passed as content parts, and converts the chat
+// responses back to generate responses.
+func (o *OpenAIBackend) generateViaChat(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	var messages []models.Message
+	if req.System != "" {
+		messages = append(messages, models.Message{Role: "system", Content: req.System})
+	}
+	messages = append(messages, models.Message{Role: "user", Content: req.Prompt, Images: req.Images})
+
+	chatChan, metadata, err := o.Chat(ctx, models.ChatRequest{
+		Model:    req.Model,
+		Messages: messages,
+		Stream:   req.Stream,
+		Options:  req.Options,
+		Format:   req.Format,
+		Think:    req.Think,
+		Extra:    req.Extra,
+	})
+	respChan := make(chan models.GenerateResponse, 10)
+	if err != nil {
+		close(respChan)
+		return respChan, metadata, err
+	}
+
+	go func() {
+		defer close(respChan)
+		for chatResp := range chatChan {
+			respChan <- models.GenerateResponse{
+				Model:              chatResp.Model,
+				CreatedAt:          chatResp.CreatedAt,
+				Response:           chatResp.Message.Content,
+				Thinking:           chatResp.Message.Thinking,
+				Done:               chatResp.Done,
+				DoneReason:         chatResp.DoneReason,
+				TotalDuration:      chatResp.TotalDuration,
+				LoadDuration:       chatResp.LoadDuration,
+				PromptEvalCount:    chatResp.PromptEvalCount,
+				PromptEvalDuration: chatResp.PromptEvalDuration,
+				EvalCount:          chatResp.EvalCount,
+				EvalDuration:       chatResp.EvalDuration,
+			}
+		}
+	}()
+
+	return respChan, metadata, nil
+}
+
 // handleStreamingCompletion processes streaming OpenAI responses and converts to Ollama format
 func (o *OpenAIBackend) handleStreamingCompletion(ctx context.Context, body io.Reader, respChan chan<- models.GenerateResponse, model string, metadata *BackendMetadata) {
-	scanner := bufio.NewScanner(body)
-	// Increase buffer size to handle large SSE events (e.g., verbose responses with timings)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024) // 1MB max per line
+	scanner := newLineScanner(body, o.maxLineSize)
 	startTime := time.Now()
 	tokenCount := 0
+	doneReason := "stop"
+	var usage *models.OpenAIUsage
 	var rawResponse strings.Builder
 
 	for scanner.Scan() {
@@ -159,57 +316,32 @@ func (o *OpenAIBackend) handleStreamingCompletion(ctx context.Context, body io.R
 
 		data := strings.TrimPrefix(line, "data: ")
 		if data == "[DONE]" {
-			// Store raw response before sending final message
-			metadata.RawResponse = rawResponse.String()
-			// Send final response with done=true and performance metrics
-			totalDuration := time.Since(startTime).Nanoseconds()
-			respChan <- models.GenerateResponse{
-				Model:              model,
-				CreatedAt:          time.Now(),
-				Response:           "",
-				Done:               true,
-				DoneReason:         "stop",
-				TotalDuration:      totalDuration + 1,
-				PromptEvalCount:    1,
-				PromptEvalDuration: 1,
-				EvalCount:          tokenCount,
-				EvalDuration:       totalDuration,
-			}
-			return
+			break
 		}
 
 		var openaiResp models.OpenAICompletionResponse
 		if err := json.Unmarshal([]byte(data), &openaiResp); err != nil {
 			continue
 		}
+		openaiResp.Usage = usageWithTimings(openaiResp.Usage, openaiResp.Timings)
+		// With stream_options.include_usage the usage arrives in a final
+		// chunk after the one carrying finish_reason
+		if openaiResp.Usage != nil {
+			usage = openaiResp.Usage
+		}
 
 		if len(openaiResp.Choices) > 0 {
 			choice := openaiResp.Choices[0]
 
-			// Check if this is the final chunk with finish_reason
 			if choice.FinishReason != "" && choice.FinishReason != "null" {
-				// Store raw response before sending final message
-				metadata.RawResponse = rawResponse.String()
-				totalDuration := time.Since(startTime).Nanoseconds()
-				respChan <- models.GenerateResponse{
-					Model:              model,
-					CreatedAt:          time.Now(),
-					Response:           "",
-					Done:               true,
-					DoneReason:         choice.FinishReason,
-					TotalDuration:      totalDuration + 1,
-					PromptEvalCount:    1,
-					PromptEvalDuration: 1,
-					EvalCount:          tokenCount,
-					EvalDuration:       totalDuration,
-				}
-				return
+				doneReason = choice.FinishReason
 			}
 
 			text := choice.Text
-			if text != "" {
-				tokenCount++
+			if text == "" {
+				continue
 			}
+			tokenCount++
 
 			ollamaResp := models.GenerateResponse{
 				Model:     model,
@@ -221,30 +353,37 @@ func (o *OpenAIBackend) handleStreamingCompletion(ctx context.Context, body io.R
 			select {
 			case respChan <- ollamaResp:
 			case <-ctx.Done():
+				metadata.RawResponse = rawResponse.String()
 				return
 			}
 		}
 	}
 
-	// Check for scanner errors
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Scanner error in handleStreamingCompletion: %v\n", err)
+	// A stream that broke off ends without a final response
+	metadata.RawResponse = rawResponse.String()
+	if metadata.StreamErr = streamReadError(ctx, scanner.Err(), o.maxLineSize); metadata.StreamErr != nil {
+		return
 	}
 
-	// Send final done message if not already sent
+	// Send final response with done=true and performance metrics
 	totalDuration := time.Since(startTime).Nanoseconds()
-	metadata.RawResponse = rawResponse.String()
+	metadata.Usage = usage
+	promptTokens, evalTokens := 1, tokenCount
+	if usage != nil {
+		promptTokens, evalTokens = usage.PromptTokens, usage.CompletionTokens
+	}
 	respChan <- models.GenerateResponse{
 		Model:              model,
 		CreatedAt:          time.Now(),
 		Response:           "",
 		Done:               true,
-		DoneReason:         "stop",
+		DoneReason:         doneReason,
 		TotalDuration:      totalDuration + 1,
-		PromptEvalCount:    1,
+		PromptEvalCount:    promptTokens,
 		PromptEvalDuration: 1,
-		EvalCount:          tokenCount,
+		EvalCount:          evalTokens,
 		EvalDuration:       totalDuration,
+		Usage:              usage,
 	}
 }
 
@@ -260,6 +399,8 @@ func (o *OpenAIBackend) handleNonStreamingCompletion(body io.Reader, respChan ch
 	if err := json.Unmarshal(bodyBytes, &openaiResp); err != nil {
 		return
 	}
+	openaiResp.Usage = usageWithTimings(openaiResp.Usage, openaiResp.Timings)
+	metadata.Usage = openaiResp.Usage
 
 	if len(openaiResp.Choices) > 0 {
 		choice := openaiResp.Choices[0]
@@ -286,6 +427,7 @@ func (o *OpenAIBackend) handleNonStreamingCompletion(body io.Reader, respChan ch
 			DoneReason:      doneReason,
 			PromptEvalCount: promptTokens,
 			EvalCount:       evalTokens,
+			Usage:           openaiResp.Usage,
 		}
 	}
 }
@@ -310,6 +452,12 @@ func convertMessagesToOpenAI(messages []models.Message) []models.Message {
 	for i, msg := range messages {
 		converted[i] = msg
 
+		// OpenAI has no images field; images go in the content array
+		if len(msg.Images) > 0 {
+			converted[i].RawContent = openAIImageContent(msg)
+			converted[i].Images = nil
+		}
+
 		switch msg.Role {
 		case "assistant":
 			pendingIDs = nil
@@ -406,6 +554,38 @@ func convertMessagesToOpenAI(messages []models.Message) []models.Message {
 	return converted
 }
 
+// openAIImageContent builds an OpenAI content-parts array holding the
+// message text (or its existing content parts) followed by its images.
+func openAIImageContent(msg models.Message) json.RawMessage {
+	var parts []interface{}
+	if len(msg.RawContent) == 0 || json.Unmarshal(msg.RawContent, &parts) != nil {
+		parts = nil
+		if msg.Content != "" {
+			parts = append(parts, map[string]interface{}{"type": "text", "text": msg.Content})
+		}
+	}
+	for _, image := range msg.Images {
+		parts = append(parts, map[string]interface{}{
+			"type":      "image_url",
+			"image_url": map[string]interface{}{"url": imageDataURL(image)},
+		})
+	}
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// imageDataURL turns an Ollama base64 image into a data URL. Values that are
+// already URLs are kept.
+func imageDataURL(image string) string {
+	if strings.HasPrefix(image, "data:") || strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+		return image
+	}
+	return "data:" + imageMIMEType(image) + ";base64," + image
+}
+
 // Chat handles chat completion requests by translating to OpenAI format
 func (o *OpenAIBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
 	respChan := make(chan models.ChatResponse, 10)
@@ -463,17 +643,40 @@ func (o *OpenAIBackend) buildOpenAIChatRequest(req models.ChatRequest, converted
 			// after forcing stream off (e.g. via stream_override) gets the
 			// request rejected by OpenAI-compatible backends.
 			delete(raw, "stream_options")
+		} else {
+			setRawMessage(raw, "stream_options", streamOptionsWithUsage(raw["stream_options"]))
 		}
 		if len(req.Tools) > 0 {
 			setRawMessage(raw, "tools", req.Tools)
 		} else {
 			delete(raw, "tools")
 		}
-		if req.Options != nil {
-			if maxTokens, ok := req.Options["num_predict"].(float64); ok {
-				setRawMessage(raw, "max_tokens", int(maxTokens))
+		if req.ToolChoice != nil {
+			setRawMessage(raw, "tool_choice", req.ToolChoice)
+		}
+		if req.ParallelToolCalls != nil {
+			setRawMessage(raw, "parallel_tool_calls", *req.ParallelToolCalls)
+		}
+		for name, value := range o.openAIOptions(req.Options, req.Extra) {
+			setRawMessage(raw, name, value)
+		}
+		for name, value := range o.defaultParams {
+			if _, ok := raw[name]; !ok {
+				setRawMessage(raw, name, value)
 			}
 		}
+		if format := openAIResponseFormat(req.Format); format != nil {
+			if _, ok := raw["response_format"]; !ok {
+				setRawMessage(raw, "response_format", format)
+			}
+		}
+		effort, kwargs := openAIThinkParams(req.Think)
+		if _, ok := raw["reasoning_effort"]; !ok && effort != "" {
+			setRawMessage(raw, "reasoning_effort", effort)
+		}
+		if _, ok := raw["chat_template_kwargs"]; !ok && kwargs != nil {
+			setRawMessage(raw, "chat_template_kwargs", kwargs)
+		}
 		if o.forcePromptCache {
 			setRawMessage(raw, "cache_prompt", true)
 		}
@@ -482,27 +685,253 @@ func (o *OpenAIBackend) buildOpenAIChatRequest(req models.ChatRequest, converted
 
 	// Translate Ollama request to OpenAI chat request
 	openaiReq := models.OpenAIChatRequest{
-		Model:       req.Model,
-		Messages:    convertedMessages,
-		Stream:      req.Stream,
-		Tools:       req.Tools,
-		CachePrompt: o.forcePromptCache,
+		Model:             req.Model,
+		Messages:          convertedMessages,
+		Stream:            req.Stream,
+		Tools:             req.Tools,
+		ToolChoice:        req.ToolChoice,
+		ParallelToolCalls: req.ParallelToolCalls,
+		ResponseFormat:    openAIResponseFormat(req.Format),
+		CachePrompt:       o.forcePromptCache,
+	}
+	openaiReq.ReasoningEffort, openaiReq.ChatTemplateKwargs = openAIThinkParams(req.Think)
+	if req.Stream {
+		openaiReq.StreamOptions = &models.OpenAIStreamOptions{IncludeUsage: true}
 	}
 
-	// Map Ollama options to OpenAI parameters
-	if req.Options != nil {
-		if temp, ok := req.Options["temperature"].(float64); ok {
-			openaiReq.Temperature = temp
+	return marshalWithOptions(openaiReq, o.requestParams(req.Options, req.Extra))
+}
+
+// openAIOptionNames maps Ollama option names to OpenAI request fields.
+// top_k, min_p and repeat_penalty are not part of the OpenAI API but are
+// accepted by llama.cpp and most other OpenAI-compatible servers.
+var openAIOptionNames = map[string]string{
+	"num_predict":       "max_tokens",
+	"temperature":       "temperature",
+	"top_p":             "top_p",
+	"top_k":             "top_k",
+	"min_p":             "min_p",
+	"stop":              "stop",
+	"seed":              "seed",
+	"frequency_penalty": "frequency_penalty",
+	"presence_penalty":  "presence_penalty",
+	"repeat_penalty":    "repeat_penalty",
+}
+
+// vllmParams are the vLLM request fields passed through from Ollama options
+// or the extra block when the backend is vLLM
+var vllmParams = map[string]bool{
+	"best_of":         true,
+	"use_beam_search": true,
+	"guided_json":     true,
+	"guided_regex":    true,
+	"guided_choice":   true,
+	"guided_grammar":  true,
+}
+
+// textGenOptionNames maps Ollama options to text-generation-webui's sampler
+// fields where its names differ from OpenAI's or OpenAI has none
+var textGenOptionNames = map[string]string{
+	"repeat_penalty": "repetition_penalty",
+	"repeat_last_n":  "repetition_penalty_range",
+	"typical_p":      "typical_p",
+	"tfs_z":          "tfs",
+	"mirostat":       "mirostat_mode",
+	"mirostat_tau":   "mirostat_tau",
+	"mirostat_eta":   "mirostat_eta",
+	"num_ctx":        "truncation_length",
+}
+
+// textGenParams are text-generation-webui's sampler fields passed through
+// from Ollama options or the extra block when the backend is
+// text-generation-webui
+var textGenParams = map[string]bool{
+	"top_a":                    true,
+	"tfs":                      true,
+	"typical_p":                true,
+	"mirostat_mode":            true,
+	"mirostat_tau":             true,
+	"mirostat_eta":             true,
+	"repetition_penalty":       true,
+	"repetition_penalty_range": true,
+	"epsilon_cutoff":           true,
+	"eta_cutoff":               true,
+	"smoothing_factor":         true,
+	"dry_multiplier":           true,
+	"dry_base":                 true,
+	"dry_allowed_length":       true,
+	"xtc_threshold":            true,
+	"xtc_probability":          true,
+	"truncation_length":        true,
+	"grammar_string":           true,
+}
+
+// openRouterParams are the OpenRouter request fields passed through from
+// Ollama options or the extra block when the backend is OpenRouter
+var openRouterParams = map[string]bool{
+	"provider":   true,
+	"route":      true,
+	"models":     true, // Fallback models
+	"transforms": true,
+}
+
+// openAIOptions translates Ollama options to OpenAI request fields. Options
+// with no OpenAI equivalent are dropped, or passed through under their own
+// name when pass_unknown_options is enabled. Server-specific fields (see
+// SetVLLM, SetTextGen and SetOpenRouter) are always passed through. Fields in extra are
+// passed through the same way, and take precedence over options.
+func (o *OpenAIBackend) openAIOptions(options, extra map[string]interface{}) map[string]interface{} {
+	params := make(map[string]interface{})
+	for name, value := range options {
+		if o.passParams[name] {
+			params[name] = passParamValue(name, value)
+			continue
+		}
+		openAIName, known := o.optionNames[name]
+		if !known {
+			openAIName, known = openAIOptionNames[name]
 		}
-		if maxTokens, ok := req.Options["num_predict"].(float64); ok {
-			openaiReq.MaxTokens = int(maxTokens)
+		if !known {
+			if o.passUnknownOpts {
+				params[name] = value
+			}
+			continue
+		}
+		switch name {
+		case "num_predict":
+			// Ollama uses -1 (and -2) for "no limit"; OpenAI just omits it
+			n, ok := value.(float64)
+			if !ok || n <= 0 {
+				continue
+			}
+			value = int(n)
+		case "seed", "top_k", "repeat_last_n", "mirostat", "num_ctx":
+			if n, ok := value.(float64); ok {
+				value = int(n)
+			}
+		case "stop":
+			if stop, ok := value.(string); ok {
+				value = []string{stop}
+			}
 		}
-		if topP, ok := req.Options["top_p"].(float64); ok {
-			openaiReq.TopP = topP
+		params[openAIName] = value
+	}
+	for name, value := range extra {
+		switch {
+		case o.passParams[name]:
+			params[name] = passParamValue(name, value)
+		case o.passUnknownOpts:
+			params[name] = value
 		}
 	}
+	return params
+}
 
-	return json.Marshal(openaiReq)
+// passParamValue converts a server-specific parameter decoded from JSON to
+// the type the server expects
+func passParamValue(name string, value interface{}) interface{} {
+	if n, ok := value.(float64); ok && intPassParams[name] {
+		return int(n)
+	}
+	return value
+}
+
+// intPassParams are the server-specific parameters that must be integers
+var intPassParams = map[string]bool{
+	"best_of":                  true,
+	"mirostat_mode":            true,
+	"repetition_penalty_range": true,
+	"dry_allowed_length":       true,
+	"truncation_length":        true,
+}
+
+// requestParams returns the top-level fields added to a request: the
+// translated options, plus the default fields the options don't set
+func (o *OpenAIBackend) requestParams(options, extra map[string]interface{}) map[string]interface{} {
+	params := o.openAIOptions(options, extra)
+	for name, value := range o.defaultParams {
+		if _, ok := params[name]; !ok {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// marshalWithOptions marshals an OpenAI request and adds the translated
+// options as top-level fields.
+func marshalWithOptions(req interface{}, params map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(req)
+	if err != nil || len(params) == 0 {
+		return data, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for name, value := range params {
+		setRawMessage(raw, name, value)
+	}
+	return json.Marshal(raw)
+}
+
+// openAIResponseFormat translates an Ollama format value to an OpenAI
+// response_format: "json" becomes json_object and a schema becomes
+// json_schema. It returns nil when no format was requested.
+func openAIResponseFormat(format json.RawMessage) interface{} {
+	jsonMode, schema := parseFormat(format)
+	switch {
+	case schema != nil:
+		return map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": schema,
+			},
+		}
+	case jsonMode:
+		return map[string]interface{}{"type": "json_object"}
+	default:
+		return nil
+	}
+}
+
+// openAIThinkParams translates Ollama's think flag. A level ("low",
+// "medium", "high") becomes reasoning_effort; true/false becomes the
+// enable_thinking chat template argument understood by llama.cpp and vLLM.
+func openAIThinkParams(think interface{}) (effort string, kwargs map[string]interface{}) {
+	switch t := think.(type) {
+	case bool:
+		return "", map[string]interface{}{"enable_thinking": t}
+	case string:
+		return t, nil
+	default:
+		return "", nil
+	}
+}
+
+// streamOptionsWithUsage returns the client's stream_options with
+// include_usage turned on, so the backend reports real token counts in its
+// final chunk. Other stream options the client set are kept.
+func streamOptionsWithUsage(existing json.RawMessage) map[string]interface{} {
+	options := make(map[string]interface{})
+	if len(existing) > 0 {
+		if err := json.Unmarshal(existing, &options); err != nil || options == nil {
+			options = make(map[string]interface{})
+		}
+	}
+	options["include_usage"] = true
+	return options
+}
+
+// usageWithTimings fills in the cached prompt tokens from llama.cpp's
+// timings block when the usage block doesn't report them.
+func usageWithTimings(usage *models.OpenAIUsage, timings *models.LlamaCppTimings) *models.OpenAIUsage {
+	if usage == nil || usage.PromptTokensDetails != nil || timings == nil {
+		return usage
+	}
+	withCache := *usage
+	withCache.PromptTokensDetails = &models.OpenAIPromptTokensDetails{CachedTokens: timings.CacheN}
+	return &withCache
 }
 
 func cloneRawMessageMap(raw map[string]json.RawMessage) map[string]json.RawMessage {
@@ -525,10 +954,7 @@ func setRawMessage(raw map[string]json.RawMessage, key string, value interface{}
 
 // handleStreamingChat processes streaming OpenAI chat responses and converts to Ollama format
 func (o *OpenAIBackend) handleStreamingChat(ctx context.Context, body io.Reader, respChan chan<- models.ChatResponse, model string, metadata *BackendMetadata) {
-	scanner := bufio.NewScanner(body)
-	// Increase buffer size to handle large SSE events (e.g., verbose responses with timings)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024) // 1MB max per line
+	scanner := newLineScanner(body, o.maxLineSize)
 	startTime := time.Now()
 	tokenCount := 0
 	var rawResponse strings.Builder
@@ -599,6 +1025,7 @@ func (o *OpenAIBackend) handleStreamingChat(ctx context.Context, body io.Reader,
 
 			// Store raw response before sending final message
 			metadata.RawResponse = rawResponse.String()
+			metadata.Usage = finalUsage
 			// Send final response with done=true and performance metrics
 			respChan <- finalResponse()
 			return
@@ -608,6 +1035,7 @@ func (o *OpenAIBackend) handleStreamingChat(ctx context.Context, body io.Reader,
 		if err := json.Unmarshal([]byte(data), &openaiResp); err != nil {
 			continue
 		}
+		openaiResp.Usage = usageWithTimings(openaiResp.Usage, openaiResp.Timings)
 		if openaiResp.Usage != nil {
 			finalUsage = openaiResp.Usage
 		}
@@ -669,8 +1097,8 @@ func (o *OpenAIBackend) handleStreamingChat(ctx context.Context, body io.Reader,
 					continue
 				}
 
-				// Handle regular content
-				if choice.Delta.Content != "" {
+				// Handle regular content and reasoning
+				if choice.Delta.Content != "" || choice.Delta.Thinking != "" {
 					tokenCount++
 
 					// Set role to "assistant" if empty
@@ -693,6 +1121,7 @@ func (o *OpenAIBackend) handleStreamingChat(ctx context.Context, body io.Reader,
 					select {
 					case respChan <- ollamaResp:
 					case <-ctx.Done():
+						metadata.RawResponse = rawResponse.String()
 						return
 					}
 				}
@@ -702,13 +1131,14 @@ func (o *OpenAIBackend) handleStreamingChat(ctx context.Context, body io.Reader,
 
 	sendToolCalls()
 
-	// Check for scanner errors
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Scanner error in handleStreamingChat: %v\n", err)
+	// A stream that broke off ends without a final response
+	metadata.RawResponse = rawResponse.String()
+	if metadata.StreamErr = streamReadError(ctx, scanner.Err(), o.maxLineSize); metadata.StreamErr != nil {
+		return
 	}
 
 	// Send final done message if not already sent
-	metadata.RawResponse = rawResponse.String()
+	metadata.Usage = finalUsage
 	respChan <- finalResponse()
 }
 
@@ -773,6 +1203,8 @@ func (o *OpenAIBackend) handleNonStreamingChat(body io.Reader, respChan chan<- m
 	if err := json.Unmarshal(bodyBytes, &openaiResp); err != nil {
 		return
 	}
+	openaiResp.Usage = usageWithTimings(openaiResp.Usage, openaiResp.Timings)
+	metadata.Usage = openaiResp.Usage
 
 	if len(openaiResp.Choices) > 0 && openaiResp.Choices[0].Message != nil {
 		choice := openaiResp.Choices[0]
@@ -812,12 +1244,20 @@ func (o *OpenAIBackend) handleNonStreamingChat(body io.Reader, respChan chan<- m
 	}
 }
 
-// ListModels returns available models from OpenAI-compatible API
+// ListModels returns available models from OpenAI-compatible API. If
+// /v1/models fails or lists nothing, the models with settings of their own
+// are listed instead; if there are none, a failure lists a "default"
+// placeholder.
 func (o *OpenAIBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	if o.staticModels {
+		return o.configuredModels(), nil
+	}
+
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", o.endpoint+"/v1/models", nil)
 	if err != nil {
 		return models.ModelsResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
+	o.setHeaders(httpReq)
 
 	resp, err := o.client.Do(httpReq)
 	if err != nil {
@@ -826,18 +1266,7 @@ func (o *OpenAIBackend) ListModels(ctx context.Context) (models.ModelsResponse,
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		// If /v1/models doesn't work, return a default model
-		return models.ModelsResponse{
-			Models: []models.ModelInfo{
-				{
-					Name:       "default",
-					Model:      "default",
-					ModifiedAt: time.Now(),
-					Size:       0,
-					Digest:     "",
-				},
-			},
-		}, nil
+		return o.fallbackModels(), nil
 	}
 
 	// Try to parse OpenAI models response and convert to Ollama format
@@ -856,18 +1285,7 @@ func (o *OpenAIBackend) ListModels(ctx context.Context) (models.ModelsResponse,
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&openaiModels); err != nil {
-		// Return default model on error
-		return models.ModelsResponse{
-			Models: []models.ModelInfo{
-				{
-					Name:       "default",
-					Model:      "default",
-					ModifiedAt: time.Now(),
-					Size:       0,
-					Digest:     "",
-				},
-			},
-		}, nil
+		return o.fallbackModels(), nil
 	}
 
 	// Convert to Ollama format
@@ -908,14 +1326,56 @@ func (o *OpenAIBackend) ListModels(ctx context.Context) (models.ModelsResponse,
 				Parent:        m.Parent,
 			},
 		})
+		settings, _ := modelSettingsFor(o.modelSettings, m.ID)
+		applyModelSettings(&modelInfos[len(modelInfos)-1], settings)
+	}
+	if configured := o.configuredModels(); len(modelInfos) == 0 && len(configured.Models) > 0 {
+		return configured, nil
 	}
 
 	return models.ModelsResponse{Models: modelInfos}, nil
 }
 
+// configuredModels lists the models with settings of their own, by name
+func (o *OpenAIBackend) configuredModels() models.ModelsResponse {
+	names := slices.Sorted(maps.Keys(o.modelSettings))
+	modelInfos := make([]models.ModelInfo, 0, len(names))
+	for _, name := range names {
+		if name == DefaultModelSettingsKey {
+			continue
+		}
+		info := models.ModelInfo{Name: name, Model: name, ModifiedAt: time.Now()}
+		applyModelSettings(&info, o.modelSettings[name])
+		modelInfos = append(modelInfos, info)
+	}
+	return models.ModelsResponse{Models: modelInfos}
+}
+
+// fallbackModels is the model list when /v1/models doesn't work: the models
+// with settings of their own, or else a "default" placeholder
+func (o *OpenAIBackend) fallbackModels() models.ModelsResponse {
+	if configured := o.configuredModels(); len(configured.Models) > 0 {
+		return configured
+	}
+	return models.ModelsResponse{
+		Models: []models.ModelInfo{
+			{
+				Name:       "default",
+				Model:      "default",
+				ModifiedAt: time.Now(),
+				Size:       0,
+				Digest:     "",
+			},
+		},
+	}
+}
+
+// ShowModel describes a model from /v1/models and its model settings, in
+// the form Ollama uses.
 func (o *OpenAIBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	settings, configured := modelSettingsFor(o.modelSettings, model)
 	modelsResp, err := o.ListModels(ctx)
-	if err != nil {
+	if err != nil && !configured {
 		return models.ShowResponse{}, err
 	}
 
@@ -927,20 +1387,85 @@ func (o *OpenAIBackend) ShowModel(ctx context.Context, model string) (models.Sho
 		}
 	}
 	if selected == nil {
-		return models.ShowResponse{}, fmt.Errorf("model not found: %s", model)
+		if !configured {
+			return models.ShowResponse{}, fmt.Errorf("model not found: %s", model)
+		}
+		// Listed models already have their settings applied
+		selected = &models.ModelInfo{Name: model, Model: model, ModifiedAt: time.Now()}
+		applyModelSettings(selected, settings)
 	}
+	return synthesizeShowResponse(*selected, settings.Parameters), nil
+}
+
+// Embed translates an embeddings request to the OpenAI /v1/embeddings API
+func (o *OpenAIBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	metadata := &BackendMetadata{}
 
-	modelInfo := map[string]interface{}{}
-	if selected.ContextLength > 0 {
-		modelInfo["context_length"] = selected.ContextLength
+	data, err := json.Marshal(models.OpenAIEmbeddingRequest{
+		Model:      req.Model,
+		Input:      req.Input,
+		Dimensions: req.Dimensions,
+	})
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	return models.ShowResponse{
-		Details: models.ModelDetails{
-			ContextLength: selected.ContextLength,
-		},
-		ModelInfo:  modelInfo,
-		ModifiedAt: selected.ModifiedAt,
-	}, nil
+
+	metadata.RawRequest = string(data)
+	metadata.URL = o.endpoint + "/v1/embeddings"
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", metadata.URL, bytes.NewReader(data))
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	o.setHeaders(httpReq)
+
+	resp, err := o.client.Do(httpReq)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("request failed: %w", err)
+	}
+	metadata.recordResponse(resp)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to read response: %w", err)
+	}
+	metadata.RawResponse = string(body)
+	if resp.StatusCode != http.StatusOK {
+		return models.EmbedResponse{}, metadata, newStatusError(resp, string(body))
+	}
+
+	var openaiResp models.OpenAIEmbeddingResponse
+	if err := json.Unmarshal(body, &openaiResp); err != nil {
+		return models.EmbedResponse{}, metadata, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	// Data entries carry their own index; don't rely on response order.
+	embeddings := make([][]float64, len(req.Input))
+	for _, item := range openaiResp.Data {
+		if item.Index < 0 || item.Index >= len(embeddings) {
+			return models.EmbedResponse{}, metadata, fmt.Errorf("backend returned embedding index %d for %d inputs", item.Index, len(req.Input))
+		}
+		embeddings[item.Index] = item.Embedding
+	}
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return models.EmbedResponse{}, metadata, fmt.Errorf("backend returned no embedding for input %d", i)
+		}
+	}
+
+	embedResp := models.EmbedResponse{
+		Model:      req.Model,
+		Embeddings: embeddings,
+	}
+	if openaiResp.Model != "" {
+		embedResp.Model = openaiResp.Model
+	}
+	if openaiResp.Usage != nil {
+		embedResp.PromptEvalCount = openaiResp.Usage.PromptTokens
+	}
+	return embedResp, metadata, nil
 }
 
 func firstNonZero(values ...int) int {
@@ -25,7 +25,7 @@ func TestOpenAIBackendListModelsParsesContextMetadata(t *testing.T) {
 	}))
 	defer server.Close()
 
-	backend := NewOpenAIBackend(server.URL, 5, false, false)
+	backend := NewOpenAIBackend(server.URL, 5, "", false, false, false, nil)
 	resp, err := backend.ListModels(context.Background())
 	if err != nil {
 		t.Fatalf("ListModels() error = %v", err)
@@ -68,7 +68,7 @@ func TestOpenAIBackendShowModelSynthesizesContextLength(t *testing.T) {
 	}))
 	defer server.Close()
 
-	backend := NewOpenAIBackend(server.URL, 5, false, false)
+	backend := NewOpenAIBackend(server.URL, 5, "", false, false, false, nil)
 	resp, err := backend.ShowModel(context.Background(), "gemma4-31b")
 	if err != nil {
 		t.Fatalf("ShowModel() error = %v", err)
@@ -80,3 +80,89 @@ func TestOpenAIBackendShowModelSynthesizesContextLength(t *testing.T) {
 		t.Fatalf("details.context_length = %d, want 65536", resp.Details.ContextLength)
 	}
 }
+
+func TestOpenAIBackendShowModelUsesModelSettings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []map[string]interface{}{
+				{"id": "qwen3-coder", "max_model_len": 65536},
+				{"id": "plain"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	backend := NewOpenAIBackend(server.URL, 5, "", false, false, false, nil)
+	backend.SetModelSettings(map[string]ModelSettings{
+		"qwen3-coder":           {Capabilities: []string{"completion", "tools"}, Family: "qwen3", ParameterSize: "30B"},
+		"unlisted":              {ContextLength: 8192, Parameters: "temperature 0.2"},
+		DefaultModelSettingsKey: {Capabilities: []string{"completion", "vision"}},
+	})
+
+	resp, err := backend.ShowModel(context.Background(), "qwen3-coder")
+	if err != nil {
+		t.Fatalf("ShowModel() error = %v", err)
+	}
+	if resp.ModelInfo["general.architecture"] != "qwen3" || resp.ModelInfo["qwen3.context_length"] != 65536 {
+		t.Fatalf("model_info = %#v, want the qwen3 architecture and its context length", resp.ModelInfo)
+	}
+	if resp.Parameters != "num_ctx 65536" || resp.Details.ParameterSize != "30B" || len(resp.Capabilities) != 2 || resp.Capabilities[1] != "tools" {
+		t.Fatalf("ShowModel(qwen3-coder) = %+v", resp)
+	}
+
+	resp, err = backend.ShowModel(context.Background(), "plain")
+	if err != nil || len(resp.Capabilities) != 2 || resp.Capabilities[1] != "vision" {
+		t.Fatalf("ShowModel(plain) = %+v, %v; want the default capabilities", resp, err)
+	}
+
+	resp, err = backend.ShowModel(context.Background(), "unlisted")
+	if err != nil || resp.ModelInfo["context_length"] != 8192 || resp.Parameters != "temperature 0.2" {
+		t.Fatalf("ShowModel(unlisted) = %+v, %v; want the configured model", resp, err)
+	}
+
+	if _, err := backend.ShowModel(context.Background(), "missing"); err == nil {
+		t.Fatal("ShowModel(missing) error = nil, want model not found")
+	}
+
+	list, err := backend.ListModels(context.Background())
+	if err != nil || list.Models[0].Details.Family != "qwen3" || list.Models[0].Capabilities[1] != "tools" {
+		t.Fatalf("ListModels() = %+v, %v; want the settings applied", list, err)
+	}
+}
+
+func TestOpenAIBackendListsConfiguredModels(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte("<html>not an API</html>"))
+	}))
+	defer server.Close()
+
+	settings := map[string]ModelSettings{
+		"zeta":                  {ContextLength: 8192},
+		"alpha":                 {Capabilities: []string{"completion", "tools"}},
+		DefaultModelSettingsKey: {Family: "llama"},
+	}
+	backend := NewOpenAIBackend(server.URL, 5, "", false, false, false, nil)
+	resp, err := backend.ListModels(context.Background())
+	if err != nil || len(resp.Models) != 1 || resp.Models[0].Name != "default" {
+		t.Fatalf("ListModels() without settings = %+v, %v; want the default placeholder", resp, err)
+	}
+
+	backend.SetModelSettings(settings)
+	resp, err = backend.ListModels(context.Background())
+	if err != nil || len(resp.Models) != 2 || resp.Models[0].Name != "alpha" || resp.Models[1].ContextLength != 8192 {
+		t.Fatalf("ListModels() after junk = %+v, %v; want the configured models", resp, err)
+	}
+
+	backend.SetStaticModels(true)
+	calls = 0
+	resp, err = backend.ListModels(context.Background())
+	if err != nil || len(resp.Models) != 2 || calls != 0 {
+		t.Fatalf("static ListModels() = %+v, %v with %d backend calls; want the configured models only", resp, err, calls)
+	}
+	show, err := backend.ShowModel(context.Background(), "alpha")
+	if err != nil || show.Capabilities[1] != "tools" {
+		t.Fatalf("ShowModel(alpha) = %+v, %v", show, err)
+	}
+}
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"context"
+
+	"llm_proxy/models"
+)
+
+// RaceEntrant is one backend requests are raced on
+type RaceEntrant struct {
+	Name    string
+	Backend Backend
+}
+
+// RaceBackend sends each generate, chat and embeddings request to several
+// backends at once and answers with whichever responds first: the first to
+// stream a chunk, or to answer at all when not streaming. The others are
+// cancelled. It helps when a backend has unpredictable cold starts. The
+// winner's name is reported in BackendMetadata.RaceWinner.
+//
+// Requests for models not in models (when it isn't empty), model lists and
+// model descriptions go to the wrapped backend.
+type RaceBackend struct {
+	Backend
+	entrants []RaceEntrant
+	models   map[string]bool
+}
+
+// NewRaceBackend creates a wrapper around defaultBackend that races requests
+// on entrants. models, if not empty, are the only models raced.
+func NewRaceBackend(defaultBackend Backend, entrants []RaceEntrant, models []string) *RaceBackend {
+	r := &RaceBackend{Backend: defaultBackend, entrants: entrants}
+	if len(models) > 0 {
+		r.models = make(map[string]bool, len(models))
+		for _, model := range models {
+			r.models[model] = true
+		}
+	}
+	return r
+}
+
+func (r *RaceBackend) raced(model string) bool {
+	return r.models == nil || r.models[model]
+}
+
+// raceRunner is one entrant's attempt: its response stream and the first
+// response on it
+type raceRunner[T any] struct {
+	index    int
+	cancel   context.CancelFunc
+	respChan <-chan T
+	first    T
+	ok       bool // A first response arrived
+	metadata *BackendMetadata
+	err      error
+}
+
+// race starts a request on every entrant and returns the stream of the
+// first to send a response, with its first response put back in front. The
+// other attempts are cancelled and their streams drained. If none responds,
+// the first entrant's error is returned.
+func race[T any](ctx context.Context, entrants []RaceEntrant, start func(context.Context, Backend) (<-chan T, *BackendMetadata, error)) (<-chan T, *BackendMetadata, error) {
+	results := make(chan raceRunner[T], len(entrants))
+	cancels := make([]context.CancelFunc, len(entrants))
+	for i, entrant := range entrants {
+		runCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+		go func() {
+			run := raceRunner[T]{index: i, cancel: cancel}
+			run.respChan, run.metadata, run.err = start(runCtx, entrant.Backend)
+			if run.err == nil {
+				run.first, run.ok = <-run.respChan
+			}
+			results <- run
+		}()
+	}
+
+	failures := make([]raceRunner[T], len(entrants))
+	for received := 0; received < len(entrants); received++ {
+		run := <-results
+		if !run.ok {
+			run.cancel()
+			failures[run.index] = run
+			continue
+		}
+
+		// The others lose: cancel them and drain what they already started
+		for i, cancel := range cancels {
+			if i != run.index {
+				cancel()
+			}
+		}
+		go func(remaining int) {
+			for ; remaining > 0; remaining-- {
+				if loser := <-results; loser.err == nil {
+					for range loser.respChan {
+					}
+				}
+			}
+		}(len(entrants) - received - 1)
+
+		if run.metadata == nil {
+			run.metadata = &BackendMetadata{}
+		}
+		run.metadata.RaceWinner = entrants[run.index].Name
+		out := make(chan T, cap(run.respChan)+1)
+		go func() {
+			defer run.cancel()
+			defer close(out)
+			out <- run.first
+			for resp := range run.respChan {
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					for range run.respChan {
+					}
+					return
+				}
+			}
+		}()
+		return out, run.metadata, nil
+	}
+
+	// Nobody responded: answer as the first entrant did
+	first := failures[0]
+	return first.respChan, first.metadata, first.err
+}
+
+// Generate races a generate request
+func (r *RaceBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	if !r.raced(req.Model) {
+		return r.Backend.Generate(ctx, req)
+	}
+	return race(ctx, r.entrants, func(ctx context.Context, b Backend) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+		return b.Generate(ctx, req)
+	})
+}
+
+// Chat races a chat request
+func (r *RaceBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	if !r.raced(req.Model) {
+		return r.Backend.Chat(ctx, req)
+	}
+	return race(ctx, r.entrants, func(ctx context.Context, b Backend) (<-chan models.ChatResponse, *BackendMetadata, error) {
+		return b.Chat(ctx, req)
+	})
+}
+
+// Embed races an embeddings request
+func (r *RaceBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	if !r.raced(req.Model) {
+		return r.Backend.Embed(ctx, req)
+	}
+	respChan, metadata, err := race(ctx, r.entrants, func(ctx context.Context, b Backend) (<-chan models.EmbedResponse, *BackendMetadata, error) {
+		resp, metadata, err := b.Embed(ctx, req)
+		out := make(chan models.EmbedResponse, 1)
+		if err == nil {
+			out <- resp
+		}
+		close(out)
+		return out, metadata, err
+	})
+	if err != nil {
+		return models.EmbedResponse{}, metadata, err
+	}
+	return <-respChan, metadata, nil
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// ctxRecordingBackend remembers the context of the last chat request
+type ctxRecordingBackend struct {
+	*MockBackend
+	ctx chan context.Context
+}
+
+func (c *ctxRecordingBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	c.ctx <- ctx
+	return c.MockBackend.Chat(ctx, req)
+}
+
+func TestRaceBackendFirstResponseWins(t *testing.T) {
+	slow := &ctxRecordingBackend{
+		MockBackend: NewMockBackend(MockOptions{Reply: "slow", Delay: 5 * time.Second}),
+		ctx:         make(chan context.Context, 1),
+	}
+	fast := NewMockBackend(MockOptions{Reply: "fast reply"})
+	r := NewRaceBackend(NewMockBackend(MockOptions{Reply: "default"}), []RaceEntrant{
+		{Name: "primary", Backend: slow},
+		{Name: "gpu2", Backend: fast},
+	}, nil)
+
+	start := time.Now()
+	respChan, metadata, err := r.Chat(context.Background(), models.ChatRequest{
+		Model:    "m",
+		Stream:   true,
+		Messages: []models.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var content string
+	done := false
+	for resp := range respChan {
+		content += resp.Message.Content
+		done = done || resp.Done
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("Chat() took %v, want the fast backend's answer", time.Since(start))
+	}
+	if content != "fast reply" || !done {
+		t.Fatalf("content = %q, done = %v", content, done)
+	}
+	if metadata.RaceWinner != "gpu2" {
+		t.Fatalf("RaceWinner = %q, want gpu2", metadata.RaceWinner)
+	}
+	select {
+	case <-(<-slow.ctx).Done():
+	case <-time.After(time.Second):
+		t.Fatal("the losing backend's request was not cancelled")
+	}
+}
+
+func TestRaceBackendFailureLoses(t *testing.T) {
+	failing := NewMockBackend(MockOptions{ErrorRate: 1, ErrorStatus: 503})
+	slower := NewMockBackend(MockOptions{Reply: "ok", Delay: 20 * time.Millisecond})
+	r := NewRaceBackend(nil, []RaceEntrant{
+		{Name: "primary", Backend: failing},
+		{Name: "gpu2", Backend: slower},
+	}, nil)
+
+	resp, metadata, err := r.Embed(context.Background(), models.EmbedRequest{Model: "m", Input: []string{"a"}})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if len(resp.Embeddings) != 1 || metadata.RaceWinner != "gpu2" {
+		t.Fatalf("Embed() = %d embedding(s) won by %q", len(resp.Embeddings), metadata.RaceWinner)
+	}
+
+	r = NewRaceBackend(nil, []RaceEntrant{
+		{Name: "primary", Backend: failing},
+		{Name: "gpu2", Backend: NewMockBackend(MockOptions{ErrorRate: 1, ErrorStatus: 500})},
+	}, nil)
+	_, _, err = r.Generate(context.Background(), models.GenerateRequest{Model: "m", Prompt: "hi"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 503 {
+		t.Fatalf("Generate() error = %v, want the first backend's 503", err)
+	}
+}
+
+func TestRaceBackendModels(t *testing.T) {
+	r := NewRaceBackend(NewMockBackend(MockOptions{Reply: "default"}), []RaceEntrant{
+		{Name: "primary", Backend: NewMockBackend(MockOptions{Reply: "raced"})},
+		{Name: "gpu2", Backend: NewMockBackend(MockOptions{Reply: "raced"})},
+	}, []string{"slow-start"})
+
+	for model, want := range map[string]string{"slow-start": "raced", "other": "default"} {
+		respChan, metadata, err := r.Generate(context.Background(), models.GenerateRequest{Model: model, Prompt: "hi"})
+		if err != nil {
+			t.Fatalf("Generate(%s) error = %v", model, err)
+		}
+		var response string
+		for resp := range respChan {
+			response += resp.Response
+		}
+		if response != want {
+			t.Fatalf("Generate(%s) = %q, want %q", model, response, want)
+		}
+		if (metadata.RaceWinner != "") != (want == "raced") {
+			t.Fatalf("Generate(%s) RaceWinner = %q", model, metadata.RaceWinner)
+		}
+	}
+}
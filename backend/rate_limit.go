@@ -0,0 +1,242 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// ErrQueueFull is returned when a rate-limited backend already has the
+// maximum number of requests waiting for it.
+var ErrQueueFull = errors.New("backend queue is full")
+
+// ErrQueueTimeout is returned when a request waited longer than the queue
+// timeout for a rate-limited backend.
+var ErrQueueTimeout = errors.New("timed out waiting in the backend queue")
+
+// RateLimitPolicy controls how RateLimitedBackend paces one backend.
+type RateLimitPolicy struct {
+	MaxConcurrent int           // Requests in flight at once (0 = unlimited)
+	MaxQueueDepth int           // Requests allowed to wait (0 = unlimited)
+	MaxRetryAfter time.Duration // Longest total wait for Retry-After before giving up
+	QueueTimeout  time.Duration // Longest wait in the queue for a slot (0 = as long as the client waits)
+}
+
+// RateLimitedBackend wraps one backend and honors its rate limits. When the
+// backend answers 429 with a Retry-After header, the backend is paused for
+// that long: the failed request and every new request wait in the queue and
+// are sent once the pause ends, instead of the 429 reaching the client. It
+// also caps how many requests are in flight to the backend at once; queued
+// requests are sent in the order they arrived.
+type RateLimitedBackend struct {
+	Backend
+	name   string
+	policy RateLimitPolicy
+
+	mu          sync.Mutex
+	active      int
+	queue       []uint64 // Tickets of waiting requests, oldest first
+	nextTicket  uint64
+	pausedUntil time.Time
+	wake        chan struct{} // closed and replaced when a slot frees up or the queue changes
+}
+
+// NewRateLimitedBackend creates a rate-limited wrapper around inner. name is
+// only used for logging.
+func NewRateLimitedBackend(inner Backend, name string, policy RateLimitPolicy) *RateLimitedBackend {
+	return &RateLimitedBackend{
+		Backend: inner,
+		name:    name,
+		policy:  policy,
+		wake:    make(chan struct{}),
+	}
+}
+
+// Generate waits for the backend to be available before forwarding.
+func (b *RateLimitedBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	return rateLimitedStream(ctx, b, func() (<-chan models.GenerateResponse, *BackendMetadata, error) {
+		return b.Backend.Generate(ctx, req)
+	})
+}
+
+// Chat waits for the backend to be available before forwarding.
+func (b *RateLimitedBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	return rateLimitedStream(ctx, b, func() (<-chan models.ChatResponse, *BackendMetadata, error) {
+		return b.Backend.Chat(ctx, req)
+	})
+}
+
+// Embed waits for the backend to be available before forwarding.
+func (b *RateLimitedBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	return rateLimitedCall(ctx, b, func() (models.EmbedResponse, *BackendMetadata, error) {
+		return b.Backend.Embed(ctx, req)
+	})
+}
+
+// QueueDepth returns the number of requests currently waiting.
+func (b *RateLimitedBackend) QueueDepth() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queue)
+}
+
+// acquire waits until the backend is not paused, has a free slot and every
+// request queued before this one has been sent. The returned function must
+// be called exactly once when the request is done.
+func (b *RateLimitedBackend) acquire(ctx context.Context) (func(), error) {
+	b.mu.Lock()
+	var ticket uint64
+	queued := false
+	var deadline <-chan time.Time
+	for {
+		paused := time.Until(b.pausedUntil)
+		full := b.policy.MaxConcurrent > 0 && b.active >= b.policy.MaxConcurrent
+		first := len(b.queue) == 0 || (queued && b.queue[0] == ticket)
+		if paused <= 0 && !full && first {
+			if queued {
+				b.leaveQueue(ticket)
+			}
+			b.active++
+			b.mu.Unlock()
+			var once sync.Once
+			return func() { once.Do(b.release) }, nil
+		}
+		if !queued {
+			if b.policy.MaxQueueDepth > 0 && len(b.queue) >= b.policy.MaxQueueDepth {
+				b.mu.Unlock()
+				return nil, ErrQueueFull
+			}
+			b.nextTicket++
+			ticket = b.nextTicket
+			b.queue = append(b.queue, ticket)
+			queued = true
+			if b.policy.QueueTimeout > 0 {
+				timeout := time.NewTimer(b.policy.QueueTimeout)
+				defer timeout.Stop()
+				deadline = timeout.C
+			}
+		}
+
+		wake := b.wake
+		b.mu.Unlock()
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if paused > 0 {
+			timer = time.NewTimer(paused)
+			timerC = timer.C
+		}
+		var err error
+		select {
+		case <-wake:
+		case <-timerC:
+		case <-deadline:
+			err = ErrQueueTimeout
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		b.mu.Lock()
+		if err != nil {
+			b.leaveQueue(ticket)
+			b.mu.Unlock()
+			return nil, err
+		}
+	}
+}
+
+// leaveQueue removes ticket from the queue and wakes the other waiters, one
+// of which may now be at the front. b.mu must be held.
+func (b *RateLimitedBackend) leaveQueue(ticket uint64) {
+	for i, t := range b.queue {
+		if t == ticket {
+			b.queue = append(b.queue[:i], b.queue[i+1:]...)
+			break
+		}
+	}
+	b.wakeWaiters()
+}
+
+func (b *RateLimitedBackend) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.active--
+	b.wakeWaiters()
+}
+
+// wakeWaiters makes every queued request check again whether it can go.
+// b.mu must be held.
+func (b *RateLimitedBackend) wakeWaiters() {
+	close(b.wake)
+	b.wake = make(chan struct{})
+}
+
+// pause stops sending requests to the backend for d.
+func (b *RateLimitedBackend) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+		log.Printf("Rate limit: backend %s asked to retry after %v; queueing requests", b.name, d)
+	}
+}
+
+// waitOutRateLimit reports whether err is a 429 with a Retry-After that fits
+// in the remaining wait budget for a request that started at start. If so,
+// the backend is paused for that long.
+func (b *RateLimitedBackend) waitOutRateLimit(err error, start time.Time) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || statusErr.RetryAfter <= 0 {
+		return false
+	}
+	if time.Since(start)+statusErr.RetryAfter > b.policy.MaxRetryAfter {
+		return false
+	}
+	b.pause(statusErr.RetryAfter)
+	return true
+}
+
+func rateLimitedCall[T any](ctx context.Context, b *RateLimitedBackend, call func() (T, *BackendMetadata, error)) (T, *BackendMetadata, error) {
+	start := time.Now()
+	for {
+		release, err := b.acquire(ctx)
+		if err != nil {
+			var zero T
+			return zero, &BackendMetadata{}, fmt.Errorf("waiting for backend: %w", err)
+		}
+		result, metadata, err := call()
+		release()
+		if err == nil || ctx.Err() != nil || !b.waitOutRateLimit(err, start) {
+			return result, metadata, err
+		}
+	}
+}
+
+func rateLimitedStream[T any](ctx context.Context, b *RateLimitedBackend, call func() (<-chan T, *BackendMetadata, error)) (<-chan T, *BackendMetadata, error) {
+	start := time.Now()
+	for {
+		release, err := b.acquire(ctx)
+		if err != nil {
+			ch := make(chan T)
+			close(ch)
+			return ch, &BackendMetadata{}, fmt.Errorf("waiting for backend: %w", err)
+		}
+		respChan, metadata, err := call()
+		if err == nil {
+			// Hold the slot until the stream has been fully read
+			return relayUntilClosed(ctx, respChan, release), metadata, nil
+		}
+		release()
+		if ctx.Err() != nil || !b.waitOutRateLimit(err, start) {
+			return respChan, metadata, err
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestRateLimitedBackendWaitsOutRetryAfter(t *testing.T) {
+	var attempts int
+	inner := NewOllamaBackend("http://backend.test", 10, nil)
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": []string{"1"}},
+				Body:       io.NopCloser(strings.NewReader("slow down")),
+			}, nil
+		}
+		return jsonResponse(`{"embeddings":[[1]]}`), nil
+	})
+	b := NewRateLimitedBackend(inner, "test", RateLimitPolicy{MaxRetryAfter: 5 * time.Second})
+
+	start := time.Now()
+	resp, _, err := b.Embed(context.Background(), models.EmbedRequest{Model: "m", Input: []string{"x"}})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if attempts != 2 || len(resp.Embeddings) != 1 {
+		t.Fatalf("attempts = %d, resp = %+v", attempts, resp)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Fatalf("waited %v, want at least the Retry-After of 1s", waited)
+	}
+
+	// A Retry-After longer than the wait budget is returned to the client.
+	attempts = 0
+	b.policy.MaxRetryAfter = 500 * time.Millisecond
+	_, _, err = b.Embed(context.Background(), models.EmbedRequest{Model: "m", Input: []string{"x"}})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || statusErr.RetryAfter != time.Second {
+		t.Fatalf("Embed() error = %v, want 429 with RetryAfter 1s", err)
+	}
+}
+
+func TestRateLimitedBackendLimitsConcurrencyAndQueueDepth(t *testing.T) {
+	b := NewRateLimitedBackend(nil, "test", RateLimitPolicy{MaxConcurrent: 1, MaxQueueDepth: 1})
+
+	release, err := b.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		next, err := b.acquire(context.Background())
+		if err != nil {
+			t.Errorf("queued acquire() error = %v", err)
+		}
+		acquired <- next
+	}()
+	for b.QueueDepth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := b.acquire(context.Background()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("acquire() error = %v, want ErrQueueFull", err)
+	}
+
+	release()
+	next := <-acquired
+	if depth := b.QueueDepth(); depth != 0 {
+		t.Fatalf("QueueDepth() = %d, want 0", depth)
+	}
+	next()
+}
+
+func TestRateLimitedBackendQueueIsFIFO(t *testing.T) {
+	b := NewRateLimitedBackend(nil, "test", RateLimitPolicy{MaxConcurrent: 1})
+
+	release, err := b.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	order := make(chan int, 3)
+	for i := range 3 {
+		go func() {
+			next, err := b.acquire(context.Background())
+			if err != nil {
+				t.Errorf("queued acquire() error = %v", err)
+				return
+			}
+			order <- i
+			next()
+		}()
+		// Queue the requests one at a time so their arrival order is known
+		for b.QueueDepth() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	release()
+	for want := range 3 {
+		if got := <-order; got != want {
+			t.Fatalf("request %d got the slot, want %d", got, want)
+		}
+	}
+}
+
+func TestRateLimitedBackendQueueTimeout(t *testing.T) {
+	b := NewRateLimitedBackend(nil, "test", RateLimitPolicy{MaxConcurrent: 1, QueueTimeout: 50 * time.Millisecond})
+
+	release, err := b.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	defer release()
+
+	if _, err := b.acquire(context.Background()); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("acquire() error = %v, want ErrQueueTimeout", err)
+	}
+	if depth := b.QueueDepth(); depth != 0 {
+		t.Fatalf("QueueDepth() = %d, want 0 after timing out", depth)
+	}
+}
@@ -0,0 +1,282 @@
+package backend
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// ResponseCacheStore stores cached responses by key. The SQLite database
+// implements it for a persistent cache; MemoryResponseCache keeps entries in
+// memory.
+type ResponseCacheStore interface {
+	// GetCachedResponse returns the value stored under key if it was stored
+	// within maxAge
+	GetCachedResponse(key string, maxAge time.Duration) ([]byte, bool, error)
+
+	// StoreCachedResponse stores value under key, evicting the least
+	// recently used entries beyond maxEntries (0 = unlimited)
+	StoreCachedResponse(key string, value []byte, maxEntries int) error
+}
+
+// CachingBackend wraps another backend and serves repeated non-streaming
+// generate and chat requests from a ResponseCacheStore. Requests are keyed on
+// a hash of the whole request (model, prompt or messages, options, tools,
+// format and so on) and of where it is sent: its tenant, the target and
+// model its route resolves to and the backend override header. So only
+// identical requests to the same backend hit. Streaming requests are passed
+// through uncached.
+type CachingBackend struct {
+	Backend
+	store      ResponseCacheStore
+	ttl        time.Duration
+	maxEntries int
+	router     *RouterBackend // nil = no routing
+}
+
+// cachedResponse is what is stored for each request: every response the
+// backend sent, plus the metadata to log on a hit.
+type cachedResponse[T any] struct {
+	Responses   []T    `json:"responses"`
+	URL         string `json:"url"`
+	RawRequest  string `json:"raw_request"`
+	RawResponse string `json:"raw_response"`
+}
+
+// NewCachingBackend creates a response-caching wrapper around inner. Entries
+// expire ttl after they are stored.
+func NewCachingBackend(inner Backend, store ResponseCacheStore, ttl time.Duration, maxEntries int) *CachingBackend {
+	return &CachingBackend{
+		Backend:    inner,
+		store:      store,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+// SetRouter keys responses on where router sends their requests. router must
+// be the one wrapped, directly or not, by c.
+func (c *CachingBackend) SetRouter(router *RouterBackend) {
+	c.router = router
+}
+
+// Generate serves a non-streaming generate request from the cache, or calls
+// the wrapped backend and caches its response.
+func (c *CachingBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	if req.Stream {
+		return c.Backend.Generate(ctx, req)
+	}
+	keyReq := req
+	keyReq.KeepAlive = nil
+	return cacheStream(ctx, c, c.key(ctx, "generate", keyReq, nil, req.Model, req.Prompt), func() (<-chan models.GenerateResponse, *BackendMetadata, error) {
+		return c.Backend.Generate(ctx, req)
+	}, func(resp models.GenerateResponse) bool {
+		return resp.Done && resp.DoneReason != "error"
+	})
+}
+
+// Chat serves a non-streaming chat request from the cache, or calls the
+// wrapped backend and caches its response.
+func (c *CachingBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	if req.Stream {
+		return c.Backend.Chat(ctx, req)
+	}
+	keyReq := req
+	keyReq.KeepAlive = nil
+	return cacheStream(ctx, c, c.key(ctx, "chat", keyReq, req.OpenAIRaw, req.Model, lastUserMessage(req.Messages)), func() (<-chan models.ChatResponse, *BackendMetadata, error) {
+		return c.Backend.Chat(ctx, req)
+	}, func(resp models.ChatResponse) bool {
+		return resp.Done && resp.DoneReason != "error"
+	})
+}
+
+// cacheStream replays the responses cached under key, or calls the backend
+// and relays its responses, caching them if the stream ends successfully.
+// complete reports whether the last response ended the stream normally.
+func cacheStream[T any](ctx context.Context, c *CachingBackend, key string, call func() (<-chan T, *BackendMetadata, error), complete func(T) bool) (<-chan T, *BackendMetadata, error) {
+	if key == "" {
+		return call()
+	}
+	if data, ok, err := c.store.GetCachedResponse(key, c.ttl); err != nil {
+		log.Printf("Response cache lookup failed: %v", err)
+	} else if ok {
+		var cached cachedResponse[T]
+		if err := json.Unmarshal(data, &cached); err != nil {
+			log.Printf("Response cache entry is invalid: %v", err)
+		} else {
+			out := make(chan T, len(cached.Responses))
+			for _, resp := range cached.Responses {
+				out <- resp
+			}
+			close(out)
+			return out, &BackendMetadata{
+				URL:         cached.URL,
+				RawRequest:  cached.RawRequest,
+				RawResponse: cached.RawResponse,
+				CacheHit:    true,
+			}, nil
+		}
+	}
+
+	respChan, metadata, err := call()
+	if err != nil {
+		return respChan, metadata, err
+	}
+
+	out := make(chan T, cap(respChan))
+	go func() {
+		defer close(out)
+		// Each response is relayed once the next arrives, so the last is
+		// only relayed after the entry is stored: a client that has seen the
+		// whole response and repeats the request hits
+		var responses []T
+		for resp := range respChan {
+			if len(responses) > 0 {
+				select {
+				case out <- responses[len(responses)-1]:
+				case <-ctx.Done():
+					for range respChan {
+					}
+					return
+				}
+			}
+			responses = append(responses, resp)
+		}
+		if len(responses) == 0 {
+			return
+		}
+		last := responses[len(responses)-1]
+		if complete(last) && ctx.Err() == nil {
+			storeResponses(c, key, responses, metadata)
+		}
+		select {
+		case out <- last:
+		case <-ctx.Done():
+		}
+	}()
+	return out, metadata, nil
+}
+
+// storeResponses caches the responses to the request keyed on key
+func storeResponses[T any](c *CachingBackend, key string, responses []T, metadata *BackendMetadata) {
+	data, err := json.Marshal(cachedResponse[T]{
+		Responses:   responses,
+		URL:         metadata.URL,
+		RawRequest:  metadata.RawRequest,
+		RawResponse: metadata.RawResponse,
+	})
+	if err != nil {
+		log.Printf("Failed to encode response for cache: %v", err)
+		return
+	}
+	if err := c.store.StoreCachedResponse(key, data, c.maxEntries); err != nil {
+		log.Printf("Response cache store failed: %v", err)
+	}
+}
+
+// key returns the cache key of a request, or "" if it can't be cached
+func (c *CachingBackend) key(ctx context.Context, kind string, req interface{}, raw map[string]json.RawMessage, model, content string) string {
+	scope := responseCacheScope{Tenant: TenantFromContext(ctx)}
+	if c.router != nil {
+		target, routedModel, err := c.router.resolve(ctx, model, content, false)
+		if err != nil {
+			// The router rejects it
+			return ""
+		}
+		scope.Target, scope.Model = target, routedModel
+		if c.router.overrideHeader != "" {
+			scope.Override = RequestInfoFromContext(ctx).Header.Get(c.router.overrideHeader)
+		}
+	}
+	return responseCacheKey(kind, scope, req, raw)
+}
+
+// responseCacheScope is where a request is sent, which can change the
+// response as much as the request itself
+type responseCacheScope struct {
+	Tenant   string `json:"tenant,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Override string `json:"override,omitempty"`
+}
+
+// responseCacheKey hashes everything about a request that can change the
+// response. raw holds the original OpenAI request fields, which can carry
+// parameters the Ollama request has no field for.
+func responseCacheKey(kind string, scope responseCacheScope, req interface{}, raw map[string]json.RawMessage) string {
+	data, err := json.Marshal(struct {
+		Kind    string                     `json:"kind"`
+		Scope   responseCacheScope         `json:"scope"`
+		Request interface{}                `json:"request"`
+		Raw     map[string]json.RawMessage `json:"raw,omitempty"`
+	}{kind, scope, req, raw})
+	if err != nil {
+		// Requests that can't be encoded aren't cached
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryResponseCache is an in-memory ResponseCacheStore. Entries are lost
+// on restart.
+type MemoryResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+}
+
+type memoryCacheEntry struct {
+	key    string
+	value  []byte
+	stored time.Time
+}
+
+// NewMemoryResponseCache creates an empty in-memory response cache.
+func NewMemoryResponseCache() *MemoryResponseCache {
+	return &MemoryResponseCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// GetCachedResponse implements ResponseCacheStore.
+func (c *MemoryResponseCache) GetCachedResponse(key string, maxAge time.Duration) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Since(entry.stored) >= maxAge {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+// StoreCachedResponse implements ResponseCacheStore.
+func (c *MemoryResponseCache) StoreCachedResponse(key string, value []byte, maxEntries int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, value: value, stored: time.Now()})
+	for maxEntries > 0 && c.order.Len() > maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
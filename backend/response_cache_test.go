@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestCachingBackendServesRepeatedChatFromCache(t *testing.T) {
+	var calls int
+	inner := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`), nil
+	})
+	b := NewCachingBackend(inner, NewMemoryResponseCache(), time.Hour, 10)
+
+	chat := func(req models.ChatRequest) (string, *BackendMetadata) {
+		t.Helper()
+		respChan, meta, err := b.Chat(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		var content string
+		for resp := range respChan {
+			content += resp.Message.Content
+		}
+		return content, meta
+	}
+	req := models.ChatRequest{
+		Model:    "test-model",
+		Messages: []models.Message{{Role: "user", Content: "ping"}},
+		Options:  map[string]interface{}{"temperature": 0.0},
+	}
+
+	if content, meta := chat(req); content != "pong" || meta.CacheHit {
+		t.Fatalf("first response = %q, CacheHit = %v", content, meta.CacheHit)
+	}
+	content, meta := chat(req)
+	if content != "pong" || !meta.CacheHit || meta.URL != "http://backend.test/v1/chat/completions" {
+		t.Fatalf("second response = %q, metadata = %+v", content, meta)
+	}
+	if calls != 1 {
+		t.Fatalf("backend calls = %d, want 1", calls)
+	}
+
+	// Different options and streaming requests go to the backend
+	req.Options = map[string]interface{}{"temperature": 0.7}
+	chat(req)
+	req.Stream = true
+	chat(req)
+	chat(req)
+	if calls != 4 {
+		t.Fatalf("backend calls = %d, want 4", calls)
+	}
+}
+
+func TestCachingBackendKeysOnTenantAndRoute(t *testing.T) {
+	router := NewRouterBackend(NewMockBackend(MockOptions{Reply: "a"}), map[string]Backend{
+		"gpu2": NewMockBackend(MockOptions{Reply: "b"}),
+	}, nil)
+	router.SetOverrideHeader("X-Backend", nil)
+	b := NewCachingBackend(router, NewMemoryResponseCache(), time.Hour, 10)
+	b.SetRouter(router)
+
+	chat := func(tenant, override string) (string, bool) {
+		t.Helper()
+		ctx := WithRequestInfo(context.Background(), RequestInfo{Header: http.Header{"X-Backend": {override}}})
+		if tenant != "" {
+			ctx = WithTenant(ctx, tenant)
+		}
+		respChan, meta, err := b.Chat(ctx, models.ChatRequest{Model: "m", Messages: []models.Message{{Role: "user", Content: "ping"}}})
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		var content string
+		for resp := range respChan {
+			content += resp.Message.Content
+		}
+		return content, meta.CacheHit
+	}
+
+	for _, tc := range []struct {
+		tenant, override string
+		want             string
+		hit              bool
+	}{
+		{"", "", "a", false},
+		{"", "", "a", true},
+		{"", "gpu2", "b", false},
+		{"team-a", "", "a", false},
+		{"team-a", "", "a", true},
+		{"", "gpu2", "b", true},
+	} {
+		if content, hit := chat(tc.tenant, tc.override); content != tc.want || hit != tc.hit {
+			t.Fatalf("tenant %q, override %q: response = %q, hit = %v, want %q, %v", tc.tenant, tc.override, content, hit, tc.want, tc.hit)
+		}
+	}
+}
+
+func TestMemoryResponseCacheExpiresAndEvicts(t *testing.T) {
+	c := NewMemoryResponseCache()
+	c.StoreCachedResponse("a", []byte("1"), 2)
+	c.StoreCachedResponse("b", []byte("2"), 2)
+	if _, ok, _ := c.GetCachedResponse("a", time.Hour); !ok {
+		t.Fatal("a missing")
+	}
+	// b is now the least recently used
+	c.StoreCachedResponse("c", []byte("3"), 2)
+	if _, ok, _ := c.GetCachedResponse("b", time.Hour); ok {
+		t.Fatal("b was not evicted")
+	}
+	if value, ok, _ := c.GetCachedResponse("c", time.Hour); !ok || string(value) != "3" {
+		t.Fatalf("c = %q, %v", value, ok)
+	}
+	if _, ok, _ := c.GetCachedResponse("a", 0); ok {
+		t.Fatal("a did not expire")
+	}
+}
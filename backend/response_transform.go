@@ -0,0 +1,427 @@
+package backend
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"llm_proxy/models"
+)
+
+// Response transform types for ResponseTransformStep
+const (
+	TransformStripThink    = "strip_think"    // Remove <think>...</think> blocks
+	TransformRelocateThink = "relocate_think" // Move <think>...</think> blocks into the thinking
+	TransformStripANSI     = "strip_ansi"     // Remove ANSI escape sequences
+	TransformRegex         = "regex"          // Replace regex matches, line by line
+	TransformProfanity     = "profanity"      // Mask swear words
+)
+
+// defaultProfanity is masked by the profanity transform when no words are
+// configured
+var defaultProfanity = []string{
+	"fuck", "fucking", "fucked", "fucker", "motherfucker", "shit", "shitty", "bullshit",
+	"bitch", "bastard", "asshole", "cunt", "dick", "prick", "wanker", "twat",
+}
+
+// ansiRe matches ANSI CSI and OSC escape sequences and the other
+// two-character escapes
+var ansiRe = regexp.MustCompile("\x1b(?:\\[[0-?]*[ -/]*[@-~]|\\][^\x07\x1b]*(?:\x07|\x1b\\\\)|[@-Z\\\\^_])")
+
+// maxANSIHold is the longest unfinished escape sequence held back between
+// chunks; anything longer is not a real sequence and is sent as it is
+const maxANSIHold = 64
+
+// ResponseTransformStep configures one step of a response transformation
+// pipeline
+type ResponseTransformStep struct {
+	Type    string         // One of the Transform* constants
+	Pattern *regexp.Regexp // TransformRegex (set from Words for TransformProfanity)
+	Replace string         // TransformRegex replacement; TransformProfanity mask (empty = asterisks)
+	Words   []string       // TransformProfanity words (empty = a built-in list)
+}
+
+// StreamText is the generated text of one response chunk
+type StreamText struct {
+	Content  string
+	Thinking string
+}
+
+// ResponseTransformer rewrites the text of one streamed response. Feed is
+// given the text of each chunk in turn and returns what can be sent now; a
+// transformer may hold text back, e.g. a tag that is split across chunks,
+// until a later Feed or Flush at the end of the response.
+type ResponseTransformer interface {
+	Feed(text StreamText) StreamText
+	Flush() StreamText
+}
+
+// ResponseTransformBackend wraps another backend and runs the generated text
+// of every response through a pipeline of transformers, in order. Text held
+// back by a transformer is sent at the latest with the final chunk, so a
+// match split across chunks is still transformed.
+type ResponseTransformBackend struct {
+	Backend
+	steps []ResponseTransformStep
+}
+
+// NewResponseTransformBackend creates a response-transforming wrapper around
+// inner.
+func NewResponseTransformBackend(inner Backend, steps []ResponseTransformStep) *ResponseTransformBackend {
+	steps = append([]ResponseTransformStep(nil), steps...)
+	for i, step := range steps {
+		if step.Type == TransformProfanity {
+			steps[i].Pattern = profanityPattern(step.Words)
+		}
+	}
+	return &ResponseTransformBackend{Backend: inner, steps: steps}
+}
+
+// Generate transforms the generated text.
+func (b *ResponseTransformBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	respChan, metadata, err := b.Backend.Generate(ctx, req)
+	if err != nil {
+		return respChan, metadata, err
+	}
+	return transformStream(ctx, respChan, b.pipeline(), streamAccess[models.GenerateResponse]{
+		get: func(resp *models.GenerateResponse) StreamText {
+			return StreamText{Content: resp.Response, Thinking: resp.Thinking}
+		},
+		set: func(resp *models.GenerateResponse, text StreamText) {
+			resp.Response, resp.Thinking = text.Content, text.Thinking
+		},
+		done:     func(resp *models.GenerateResponse) bool { return resp.Done },
+		textOnly: func(*models.GenerateResponse) bool { return true },
+		newChunk: func(text StreamText) models.GenerateResponse {
+			return models.GenerateResponse{Model: req.Model, Response: text.Content, Thinking: text.Thinking}
+		},
+	}), metadata, nil
+}
+
+// Chat transforms the reply.
+func (b *ResponseTransformBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	respChan, metadata, err := b.Backend.Chat(ctx, req)
+	if err != nil {
+		return respChan, metadata, err
+	}
+	return transformStream(ctx, respChan, b.pipeline(), streamAccess[models.ChatResponse]{
+		get: func(resp *models.ChatResponse) StreamText {
+			return StreamText{Content: resp.Message.Content, Thinking: resp.Message.Thinking}
+		},
+		set: func(resp *models.ChatResponse, text StreamText) {
+			resp.Message.Content, resp.Message.Thinking = text.Content, text.Thinking
+		},
+		done:     func(resp *models.ChatResponse) bool { return resp.Done },
+		textOnly: func(resp *models.ChatResponse) bool { return len(resp.Message.ToolCalls) == 0 },
+		newChunk: func(text StreamText) models.ChatResponse {
+			return models.ChatResponse{Model: req.Model, Message: models.Message{Role: "assistant", Content: text.Content, Thinking: text.Thinking}}
+		},
+	}), metadata, nil
+}
+
+// pipeline creates the transformers for one response
+func (b *ResponseTransformBackend) pipeline() transformPipeline {
+	pipeline := make(transformPipeline, 0, len(b.steps))
+	for _, step := range b.steps {
+		if t := newResponseTransformer(step); t != nil {
+			pipeline = append(pipeline, t)
+		}
+	}
+	return pipeline
+}
+
+// streamAccess reads and writes the text of one kind of response chunk
+type streamAccess[T any] struct {
+	get      func(*T) StreamText
+	set      func(*T, StreamText)
+	done     func(*T) bool
+	textOnly func(*T) bool      // The chunk carries nothing but text and can be skipped once emptied
+	newChunk func(StreamText) T // A chunk for text left over when the stream ends without a final chunk
+}
+
+// transformStream runs the text of each chunk of in through pipeline. Chunks
+// whose text is all held back are skipped, and what is still held back at
+// the end goes out with the final chunk.
+func transformStream[T any](ctx context.Context, in <-chan T, pipeline ResponseTransformer, access streamAccess[T]) <-chan T {
+	out := make(chan T, cap(in))
+	go func() {
+		defer close(out)
+		flushed := false
+		for resp := range in {
+			original := access.get(&resp)
+			text := pipeline.Feed(original)
+			if access.done(&resp) {
+				rest := pipeline.Flush()
+				text.Content += rest.Content
+				text.Thinking += rest.Thinking
+				flushed = true
+			} else if text == (StreamText{}) && original != (StreamText{}) && access.textOnly(&resp) {
+				continue
+			}
+			access.set(&resp, text)
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+		if flushed {
+			return
+		}
+		if rest := pipeline.Flush(); rest != (StreamText{}) {
+			select {
+			case out <- access.newChunk(rest):
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
+
+// transformPipeline runs text through transformers in order
+type transformPipeline []ResponseTransformer
+
+func (p transformPipeline) Feed(text StreamText) StreamText {
+	for _, t := range p {
+		text = t.Feed(text)
+	}
+	return text
+}
+
+// Flush flushes each transformer in turn, feeding what an earlier one still
+// held through the later ones first
+func (p transformPipeline) Flush() StreamText {
+	var text StreamText
+	for _, t := range p {
+		text = t.Feed(text)
+		rest := t.Flush()
+		text.Content += rest.Content
+		text.Thinking += rest.Thinking
+	}
+	return text
+}
+
+// newResponseTransformer creates a transformer for one response, or nil for
+// an unknown type
+func newResponseTransformer(step ResponseTransformStep) ResponseTransformer {
+	switch step.Type {
+	case TransformStripThink:
+		return &thinkTags{}
+	case TransformRelocateThink:
+		return &thinkTags{relocate: true}
+	case TransformStripANSI:
+		return &contentTransformer{stage: &ansiStripper{}, thinking: &ansiStripper{}}
+	case TransformRegex:
+		return &contentTransformer{stage: &lineRegexStage{pattern: step.Pattern, replace: step.Replace}}
+	case TransformProfanity:
+		return &contentTransformer{stage: &profanityStage{pattern: step.Pattern, replace: step.Replace}}
+	}
+	return nil
+}
+
+// textStage transforms a single stream of text
+type textStage interface {
+	Feed(text string) string
+	Flush() string
+}
+
+// contentTransformer applies a textStage to the content, and optionally
+// another to the thinking, which otherwise passes through
+type contentTransformer struct {
+	stage    textStage
+	thinking textStage
+}
+
+func (c *contentTransformer) Feed(text StreamText) StreamText {
+	text.Content = c.stage.Feed(text.Content)
+	if c.thinking != nil {
+		text.Thinking = c.thinking.Feed(text.Thinking)
+	}
+	return text
+}
+
+func (c *contentTransformer) Flush() StreamText {
+	text := StreamText{Content: c.stage.Flush()}
+	if c.thinking != nil {
+		text.Thinking = c.thinking.Flush()
+	}
+	return text
+}
+
+// partialSuffix returns the length of the longest end of text that is the
+// start of tag, so it can be held back in case the tag continues in the next
+// chunk
+func partialSuffix(text string, tag string) int {
+	for n := min(len(tag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// thinkTags removes <think>...</think> blocks from the content, as written
+// by DeepSeek-R1 style reasoning models, and the whitespace after them. With
+// relocate set the text inside them goes to the thinking instead of being
+// dropped. An unfinished block at the end of the response is treated the
+// same way. Tags split across chunks are held back until they are complete.
+type thinkTags struct {
+	relocate  bool
+	pending   string
+	inThink   bool
+	trimStart bool // Drop whitespace until the answer or the thinking starts
+}
+
+func (t *thinkTags) Feed(text StreamText) StreamText {
+	content := t.pending + text.Content
+	t.pending = ""
+	var out, thinking strings.Builder
+	for content != "" {
+		if t.trimStart {
+			content = strings.TrimLeft(content, " \t\r\n")
+			if content == "" {
+				break
+			}
+			t.trimStart = false
+		}
+		if t.inThink {
+			end := strings.Index(content, "</think>")
+			if end < 0 {
+				n := partialSuffix(content, "</think>")
+				thinking.WriteString(content[:len(content)-n])
+				t.pending = content[len(content)-n:]
+				break
+			}
+			thinking.WriteString(content[:end])
+			content = content[end+len("</think>"):]
+			t.inThink = false
+			t.trimStart = true
+			continue
+		}
+		start := strings.Index(content, "<think>")
+		if start < 0 {
+			n := partialSuffix(content, "<think>")
+			out.WriteString(content[:len(content)-n])
+			t.pending = content[len(content)-n:]
+			break
+		}
+		out.WriteString(content[:start])
+		content = content[start+len("<think>"):]
+		t.inThink = true
+		t.trimStart = true
+	}
+
+	text.Content = out.String()
+	if t.relocate {
+		text.Thinking += thinking.String()
+	}
+	return text
+}
+
+func (t *thinkTags) Flush() StreamText {
+	rest := t.pending
+	t.pending = ""
+	if !t.inThink {
+		return StreamText{Content: rest}
+	}
+	if t.relocate {
+		return StreamText{Thinking: rest}
+	}
+	return StreamText{}
+}
+
+// ansiStripper removes ANSI escape sequences, holding back one that is split
+// across chunks
+type ansiStripper struct {
+	pending string
+}
+
+func (s *ansiStripper) Feed(text string) string {
+	text = ansiRe.ReplaceAllString(s.pending+text, "")
+	s.pending = ""
+	if i := strings.LastIndexByte(text, '\x1b'); i >= 0 && len(text)-i < maxANSIHold {
+		s.pending = text[i:]
+		return text[:i]
+	}
+	return text
+}
+
+func (s *ansiStripper) Flush() string {
+	rest := s.pending
+	s.pending = ""
+	return rest
+}
+
+// lineRegexStage replaces regex matches one line at a time, holding back
+// each line until it is complete
+type lineRegexStage struct {
+	pattern *regexp.Regexp
+	replace string
+	pending string
+}
+
+func (s *lineRegexStage) Feed(text string) string {
+	text = s.pending + text
+	end := strings.LastIndexByte(text, '\n') + 1
+	s.pending = text[end:]
+	return s.pattern.ReplaceAllString(text[:end], s.replace)
+}
+
+func (s *lineRegexStage) Flush() string {
+	rest := s.pattern.ReplaceAllString(s.pending, s.replace)
+	s.pending = ""
+	return rest
+}
+
+// profanityStage masks whole words, case-insensitively, holding back a word
+// that may continue in the next chunk
+type profanityStage struct {
+	pattern *regexp.Regexp
+	replace string
+	pending string
+}
+
+// profanityPattern matches any of words (or defaultProfanity) as a whole
+// word, ignoring case
+func profanityPattern(words []string) *regexp.Regexp {
+	if len(words) == 0 {
+		words = defaultProfanity
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+func (s *profanityStage) Feed(text string) string {
+	text = s.pending + text
+	end := len(text)
+	for end > 0 && isWordByte(text[end-1]) {
+		end--
+	}
+	s.pending = text[end:]
+	return s.mask(text[:end])
+}
+
+func (s *profanityStage) Flush() string {
+	rest := s.mask(s.pending)
+	s.pending = ""
+	return rest
+}
+
+func (s *profanityStage) mask(text string) string {
+	return s.pattern.ReplaceAllStringFunc(text, func(word string) string {
+		if s.replace != "" {
+			return s.replace
+		}
+		return strings.Repeat("*", len([]rune(word)))
+	})
+}
+
+// isWordByte reports whether b can be part of a word as \b sees it
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+// transformChunks streams chunks of content through a
+// ResponseTransformBackend and returns the content that comes out
+func transformChunks(t *testing.T, steps []ResponseTransformStep, chunks []string) (string, int) {
+	t.Helper()
+	inner := &channelBackend{chat: make(chan models.ChatResponse, len(chunks)+1)}
+	for _, chunk := range chunks {
+		inner.chat <- models.ChatResponse{Message: models.Message{Role: "assistant", Content: chunk}}
+	}
+	inner.chat <- models.ChatResponse{Done: true}
+	close(inner.chat)
+
+	respChan, _, err := NewResponseTransformBackend(inner, steps).Chat(context.Background(), models.ChatRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var content string
+	count := 0
+	for resp := range respChan {
+		content += resp.Message.Content
+		count++
+	}
+	return content, count
+}
+
+// byteChunks splits s into one-byte chunks
+func byteChunks(s string) []string {
+	chunks := make([]string, len(s))
+	for i := range s {
+		chunks[i] = s[i : i+1]
+	}
+	return chunks
+}
+
+func TestResponseTransformBackendStepsHandleSplitChunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []ResponseTransformStep
+		input string
+		want  string
+	}{
+		{
+			name:  "strip_think",
+			steps: []ResponseTransformStep{{Type: TransformStripThink}},
+			input: "<think>\nThe user wants a greeting.\n</think>\n\nHello! <thin>",
+			want:  "Hello! <thin>",
+		},
+		{
+			name:  "strip_ansi",
+			steps: []ResponseTransformStep{{Type: TransformStripANSI}},
+			input: "\x1b[1;31mred\x1b[0m and \x1b]0;title\x07plain",
+			want:  "red and plain",
+		},
+		{
+			name:  "regex",
+			steps: []ResponseTransformStep{{Type: TransformRegex, Pattern: regexp.MustCompile(`internal\.\w+\.corp`), Replace: "[host]"}},
+			input: "see internal.git.corp\nor internal.wiki.corp",
+			want:  "see [host]\nor [host]",
+		},
+		{
+			name:  "profanity",
+			steps: []ResponseTransformStep{{Type: TransformProfanity}},
+			input: "What the fuck, Shitake is not shit!",
+			want:  "What the ****, Shitake is not ****!",
+		},
+		{
+			name: "pipeline order",
+			steps: []ResponseTransformStep{
+				{Type: TransformStripThink},
+				{Type: TransformProfanity, Words: []string{"darn"}, Replace: "[bleep]"},
+			},
+			input: "<think>darn</think>Oh darn",
+			want:  "Oh [bleep]",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, chunks := range [][]string{{tt.input}, byteChunks(tt.input)} {
+			got, _ := transformChunks(t, tt.steps, chunks)
+			if got != tt.want {
+				t.Errorf("%s (%d chunks): content = %q, want %q", tt.name, len(chunks), got, tt.want)
+			}
+		}
+	}
+}
+
+func TestResponseTransformBackendSkipsHeldBackChunks(t *testing.T) {
+	_, count := transformChunks(t, []ResponseTransformStep{{Type: TransformStripThink}}, []string{"<think>", "hmm", "</think>", "Hi"})
+	if count != 2 {
+		t.Fatalf("got %d chunks, want the answer and the final chunk", count)
+	}
+}
+
+func TestResponseTransformBackendRelocatesThinkBlocks(t *testing.T) {
+	input := "<think>\nThe user said hi.\n</think>\n\nHello!"
+	for _, chunks := range [][]string{{input}, byteChunks(input)} {
+		inner := &channelBackend{chat: make(chan models.ChatResponse, len(chunks)+1)}
+		for _, chunk := range chunks {
+			inner.chat <- models.ChatResponse{Message: models.Message{Role: "assistant", Content: chunk}}
+		}
+		inner.chat <- models.ChatResponse{Done: true}
+		close(inner.chat)
+
+		steps := []ResponseTransformStep{{Type: TransformRelocateThink}}
+		respChan, _, err := NewResponseTransformBackend(inner, steps).Chat(context.Background(), models.ChatRequest{Model: "m"})
+		if err != nil {
+			t.Fatalf("Chat() error = %v", err)
+		}
+		var content, thinking string
+		for resp := range respChan {
+			content += resp.Message.Content
+			thinking += resp.Message.Thinking
+		}
+		if content != "Hello!" || thinking != "The user said hi.\n" {
+			t.Fatalf("%d chunks: content = %q, thinking = %q", len(chunks), content, thinking)
+		}
+	}
+
+	// An unfinished block is still thinking
+	inner := &channelBackend{chat: make(chan models.ChatResponse, 2)}
+	inner.chat <- models.ChatResponse{Message: models.Message{Content: "<think>Let me see</thi"}}
+	close(inner.chat)
+	respChan, _, _ := NewResponseTransformBackend(inner, []ResponseTransformStep{{Type: TransformRelocateThink}}).Chat(context.Background(), models.ChatRequest{Model: "m"})
+	var thinking string
+	for resp := range respChan {
+		thinking += resp.Message.Thinking
+	}
+	if thinking != "Let me see</thi" {
+		t.Fatalf("thinking = %q, want the unfinished block", thinking)
+	}
+}
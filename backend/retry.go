@@ -0,0 +1,200 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// RetryPolicy controls how RetryBackend retries transient failures.
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts including the first
+	InitialBackoff time.Duration // Wait before the second attempt
+	MaxBackoff     time.Duration // Upper bound for the doubling backoff
+	RetryOnStatus  []int         // Backend status codes that are retried
+}
+
+// backoff returns the wait before attempt n+1, doubling from
+// InitialBackoff and capped at MaxBackoff.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, p.MaxBackoff)
+}
+
+// RetryBackend wraps another backend and retries requests that fail with a
+// retryable status code or a connection error, waiting with exponential
+// backoff between attempts. Streaming requests whose response channel closes
+// before the first chunk arrives are retried as well; once a chunk has been
+// forwarded the response is passed through unchanged.
+type RetryBackend struct {
+	Backend
+	policy RetryPolicy
+}
+
+// NewRetryBackend creates a retrying wrapper around inner.
+func NewRetryBackend(inner Backend, policy RetryPolicy) *RetryBackend {
+	return &RetryBackend{Backend: inner, policy: policy}
+}
+
+// Generate retries the wrapped backend's Generate until it starts responding.
+func (r *RetryBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	return retryStream(ctx, r, func() (<-chan models.GenerateResponse, *BackendMetadata, error) {
+		return r.Backend.Generate(ctx, req)
+	})
+}
+
+// Chat retries the wrapped backend's Chat until it starts responding.
+func (r *RetryBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	return retryStream(ctx, r, func() (<-chan models.ChatResponse, *BackendMetadata, error) {
+		return r.Backend.Chat(ctx, req)
+	})
+}
+
+// ListModels retries the wrapped backend's ListModels.
+func (r *RetryBackend) ListModels(ctx context.Context) (models.ModelsResponse, error) {
+	resp, _, err := retryCall(ctx, r, func() (models.ModelsResponse, *BackendMetadata, error) {
+		resp, err := r.Backend.ListModels(ctx)
+		return resp, nil, err
+	})
+	return resp, err
+}
+
+// ShowModel retries the wrapped backend's ShowModel.
+func (r *RetryBackend) ShowModel(ctx context.Context, model string) (models.ShowResponse, error) {
+	resp, _, err := retryCall(ctx, r, func() (models.ShowResponse, *BackendMetadata, error) {
+		resp, err := r.Backend.ShowModel(ctx, model)
+		return resp, nil, err
+	})
+	return resp, err
+}
+
+// Embed retries the wrapped backend's Embed.
+func (r *RetryBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	return retryCall(ctx, r, func() (models.EmbedResponse, *BackendMetadata, error) {
+		return r.Backend.Embed(ctx, req)
+	})
+}
+
+// retryable reports whether err is a transient failure worth retrying.
+// Client-side timeouts are not retried: the request already used up its
+// whole time budget once.
+func (r *RetryBackend) retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(r.policy.RetryOnStatus, statusErr.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return isConnectionError(err)
+}
+
+// wait sleeps before the next attempt. It returns false if ctx ends first.
+func (r *RetryBackend) wait(ctx context.Context, attempt int, err error) bool {
+	backoff := r.policy.backoff(attempt)
+	log.Printf("Retry: attempt %d/%d failed: %v; retrying in %v", attempt, r.policy.MaxAttempts, err, backoff)
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func retryCall[T any](ctx context.Context, r *RetryBackend, call func() (T, *BackendMetadata, error)) (T, *BackendMetadata, error) {
+	for attempt := 1; ; attempt++ {
+		result, metadata, err := call()
+		if err == nil || attempt >= r.policy.MaxAttempts || ctx.Err() != nil || !r.retryable(err) {
+			return result, metadata, err
+		}
+		if !r.wait(ctx, attempt, err) {
+			return result, metadata, err
+		}
+	}
+}
+
+// retryStream retries call until the backend starts responding, then
+// returns a channel relaying its responses at once, so the handler can send
+// keep-alives while the first chunk is awaited. A stream that closes before
+// its first chunk is retried inside the relay: nothing has been forwarded
+// yet, so the client doesn't notice. metadata ends up describing the
+// attempt whose responses were relayed.
+func retryStream[T any](ctx context.Context, r *RetryBackend, call func() (<-chan T, *BackendMetadata, error)) (<-chan T, *BackendMetadata, error) {
+	attempt := 0
+	start := func() (<-chan T, *BackendMetadata, error) {
+		for {
+			attempt++
+			respChan, metadata, err := call()
+			if err == nil || attempt >= r.policy.MaxAttempts || ctx.Err() != nil || !r.retryable(err) {
+				return respChan, metadata, err
+			}
+			if !r.wait(ctx, attempt, err) {
+				return respChan, metadata, err
+			}
+		}
+	}
+
+	respChan, metadata, err := start()
+	if err != nil {
+		return respChan, metadata, err
+	}
+
+	out := make(chan T, cap(respChan))
+	go func() {
+		defer close(out)
+		current := metadata
+		defer func() {
+			if current != metadata {
+				*metadata = *current
+			}
+		}()
+
+		first, ok := <-respChan
+		for !ok {
+			streamErr := errors.New("response stream closed before the first chunk")
+			if attempt >= r.policy.MaxAttempts || ctx.Err() != nil || !r.wait(ctx, attempt, streamErr) {
+				return
+			}
+			next, nextMetadata, err := start()
+			if err != nil {
+				if nextMetadata == nil {
+					nextMetadata = &BackendMetadata{}
+				}
+				nextMetadata.StreamErr = err
+				current = nextMetadata
+				return
+			}
+			respChan, current = next, nextMetadata
+			first, ok = <-respChan
+		}
+
+		select {
+		case out <- first:
+		case <-ctx.Done():
+			for range respChan {
+			}
+			return
+		}
+		for item := range respChan {
+			select {
+			case out <- item:
+			case <-ctx.Done():
+				for range respChan {
+				}
+				return
+			}
+		}
+	}()
+	return out, metadata, nil
+}
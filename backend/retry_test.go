@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestRetryPolicyBackoffDoublesUpToMax(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 350 * time.Millisecond}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond}
+	for i, w := range want {
+		if got := policy.backoff(i + 1); got != w {
+			t.Fatalf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestRetryBackendRetriesTransientFailures(t *testing.T) {
+	var attempts int
+	inner := NewOllamaBackend("http://backend.test", 10, nil)
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		switch attempts {
+		case 1:
+			return nil, errors.New("connection reset by peer")
+		case 2:
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader("loading model")),
+			}, nil
+		case 3:
+			// Stream that dies before the first chunk
+			return textResponse("application/x-ndjson", ""), nil
+		}
+		return textResponse("application/x-ndjson", `{"model":"m","message":{"role":"assistant","content":"hi"}}`+"\n"+`{"model":"m","done":true}`+"\n"), nil
+	})
+	b := NewRetryBackend(inner, RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, RetryOnStatus: []int{503}})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{Model: "m", Stream: true})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var content string
+	var done bool
+	for resp := range respChan {
+		content += resp.Message.Content
+		done = done || resp.Done
+	}
+	if attempts != 4 || content != "hi" || !done {
+		t.Fatalf("attempts = %d, content = %q, done = %v", attempts, content, done)
+	}
+}
+
+func TestRetryBackendReturnsStreamBeforeFirstChunk(t *testing.T) {
+	inner := &channelBackend{chat: make(chan models.ChatResponse)}
+	b := NewRetryBackend(inner, RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	// The backend hasn't sent anything yet, so Chat would block if it waited
+	// for the first chunk
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{Model: "m", Stream: true})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	inner.chat <- models.ChatResponse{Message: models.Message{Content: "hi"}, Done: true}
+	close(inner.chat)
+
+	var content string
+	for resp := range respChan {
+		content += resp.Message.Content
+	}
+	if content != "hi" {
+		t.Fatalf("content = %q, want hi", content)
+	}
+}
+
+func TestRetryBackendStopsOnNonRetryableStatusAndMaxAttempts(t *testing.T) {
+	var attempts int
+	status := http.StatusBadRequest
+	inner := NewOllamaBackend("http://backend.test", 10, nil)
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+	b := NewRetryBackend(inner, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, RetryOnStatus: []int{429}})
+
+	if _, _, err := b.Embed(context.Background(), models.EmbedRequest{Model: "m", Input: []string{"x"}}); err == nil {
+		t.Fatal("Embed() error = nil, want 400")
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts = %d after 400, want 1", attempts)
+	}
+
+	attempts = 0
+	status = http.StatusTooManyRequests
+	_, _, err := b.Embed(context.Background(), models.EmbedRequest{Model: "m", Input: []string{"x"}})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Embed() error = %v, want final 429", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("attempts = %d after repeated 429, want 3", attempts)
+	}
+}
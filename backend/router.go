@@ -0,0 +1,215 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"llm_proxy/models"
+)
+
+type requestInfoContextKey struct{}
+
+// RequestInfo is what routing rules and the request log see of the HTTP
+// request a backend call was made for
+type RequestInfo struct {
+	Path     string
+	Header   http.Header
+	ClientIP string // Address the request came from
+	User     string // User the request was made by ("" = unknown)
+	KeyName  string // Name of the API key the client presented ("" = none or unknown)
+}
+
+// WithRequestInfo returns a context carrying info about the client's request
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoContextKey{}, info)
+}
+
+// RequestInfoFromContext returns the request info stored by WithRequestInfo,
+// or an empty RequestInfo for calls not made for a client request.
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoContextKey{}).(RequestInfo)
+	return info
+}
+
+type tenantContextKey struct{}
+
+// WithTenant returns a context carrying the name of the tenant a request was
+// made by
+func WithTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, name)
+}
+
+// TenantFromContext returns the tenant stored by WithTenant, or an empty
+// string for requests made by no tenant.
+func TenantFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(tenantContextKey{}).(string)
+	return name
+}
+
+// Route is one routing rule. A request matches when every condition that is
+// set matches; the rule then sends it to Backend, with the model rewritten
+// to SetModel, or rejects it with the Reject status.
+type Route struct {
+	Name    string
+	Model   *regexp.Regexp            // Matched against the requested model
+	Path    *regexp.Regexp            // Matched against the request path
+	Headers map[string]*regexp.Regexp // Header name -> pattern its value must match
+	Content *regexp.Regexp            // Matched against the last user message, prompt or embedding input
+
+	Backend       string // Target to send the request to ("" = the default backend)
+	SetModel      string // Model to request instead ("" = unchanged)
+	Reject        int    // HTTP status to reject the request with (0 = don't)
+	RejectMessage string
+}
+
+// RouterBackend wraps the default backend and a set of named targets, and
+// sends each request where the first matching route says. Requests that match
+// no route go to their tenant's target (see SetTenantBackends), or else the
+// default backend. Model listings always go to the default backend.
+type RouterBackend struct {
+	Backend
+	targets map[string]Backend
+	routes  []Route
+
+	overrideHeader   string
+	overrideBackends map[string]bool // nil = any target
+
+	tenantBackends map[string]string // Tenant -> target of its requests
+}
+
+// NewRouterBackend creates a router. Every Backend named by routes must be in
+// targets.
+func NewRouterBackend(defaultBackend Backend, targets map[string]Backend, routes []Route) *RouterBackend {
+	return &RouterBackend{Backend: defaultBackend, targets: targets, routes: routes}
+}
+
+// SetOverrideHeader lets clients pick the target of a request by naming it in
+// the header, in place of the one its route picks. Only the targets in
+// backends can be picked, or any if it is empty. Rejections still apply, and
+// requests naming a target that can't be picked are rejected.
+func (r *RouterBackend) SetOverrideHeader(header string, backends []string) {
+	r.overrideHeader = header
+	r.overrideBackends = nil
+	if len(backends) > 0 {
+		r.overrideBackends = make(map[string]bool, len(backends))
+		for _, name := range backends {
+			r.overrideBackends[name] = true
+		}
+	}
+}
+
+// SetTenantBackends sends the requests of each tenant in backends to the
+// target it names, unless a route picks another. Every target named must be
+// in the router's targets.
+func (r *RouterBackend) SetTenantBackends(backends map[string]string) {
+	r.tenantBackends = backends
+}
+
+// Generate routes a generate request on its model and prompt.
+func (r *RouterBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	b, model, err := r.route(ctx, req.Model, req.Prompt)
+	if err != nil {
+		return closedChan[models.GenerateResponse](), &BackendMetadata{}, err
+	}
+	req.Model = model
+	return b.Generate(ctx, req)
+}
+
+// Chat routes a chat request on its model and last user message.
+func (r *RouterBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	b, model, err := r.route(ctx, req.Model, lastUserMessage(req.Messages))
+	if err != nil {
+		return closedChan[models.ChatResponse](), &BackendMetadata{}, err
+	}
+	req.Model = model
+	return b.Chat(ctx, req)
+}
+
+// Embed routes an embeddings request on its model and inputs.
+func (r *RouterBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	b, model, err := r.route(ctx, req.Model, strings.Join(req.Input, "\n"))
+	if err != nil {
+		return models.EmbedResponse{}, &BackendMetadata{}, err
+	}
+	req.Model = model
+	return b.Embed(ctx, req)
+}
+
+// route returns the backend and model for a request, or the rejection of it
+func (r *RouterBackend) route(ctx context.Context, model, content string) (Backend, string, error) {
+	target, model, err := r.resolve(ctx, model, content, true)
+	if err != nil {
+		return nil, "", err
+	}
+	if target == "" {
+		return r.Backend, model, nil
+	}
+	return r.targets[target], model, nil
+}
+
+// resolve returns the name of the target a request goes to ("" = the
+// default backend) and the model it asks for, or the rejection of it.
+// logRejection logs the route that rejected it.
+func (r *RouterBackend) resolve(ctx context.Context, model, content string, logRejection bool) (string, string, error) {
+	info := RequestInfoFromContext(ctx)
+	target := r.tenantBackends[TenantFromContext(ctx)]
+	for _, route := range r.routes {
+		if !route.matches(info, model, content) {
+			continue
+		}
+		if route.Reject != 0 {
+			if logRejection {
+				log.Printf("Route %s rejected a request for %s", route.Name, model)
+			}
+			return "", "", &RejectedError{Status: route.Reject, Message: route.RejectMessage}
+		}
+		if route.Backend != "" {
+			target = route.Backend
+		}
+		if route.SetModel != "" {
+			model = route.SetModel
+		}
+		break
+	}
+
+	if name := info.Header.Get(r.overrideHeader); r.overrideHeader != "" && name != "" {
+		if _, ok := r.targets[name]; !ok || r.overrideBackends != nil && !r.overrideBackends[name] {
+			return "", "", &RejectedError{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown backend in %s header: %q", r.overrideHeader, name)}
+		}
+		target = name
+	}
+	return target, model, nil
+}
+
+// matches reports whether a request matches every condition of the route
+func (route Route) matches(info RequestInfo, model, content string) bool {
+	if route.Model != nil && !route.Model.MatchString(model) {
+		return false
+	}
+	if route.Path != nil && !route.Path.MatchString(info.Path) {
+		return false
+	}
+	for name, pattern := range route.Headers {
+		if !pattern.MatchString(info.Header.Get(name)) {
+			return false
+		}
+	}
+	if route.Content != nil && !route.Content.MatchString(content) {
+		return false
+	}
+	return true
+}
+
+// lastUserMessage returns the content of the last user message
+func lastUserMessage(messages []models.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
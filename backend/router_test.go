@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestRouterBackendRoutesRequests(t *testing.T) {
+	primary := NewMockBackend(MockOptions{Reply: "primary"})
+	big := NewMockBackend(MockOptions{Reply: "big"})
+	r := NewRouterBackend(primary, map[string]Backend{"primary": primary, "big": big}, []Route{
+		{Name: "blocked", Headers: map[string]*regexp.Regexp{"X-Team": regexp.MustCompile("^guests$")}, Reject: 403, RejectMessage: "not for guests"},
+		{Name: "sql", Content: regexp.MustCompile(`(?i)\bsql\b`), Backend: "big", SetModel: "coder"},
+		{Name: "openai", Model: regexp.MustCompile("^gpt-"), Path: regexp.MustCompile("^/v1/"), SetModel: "llama"},
+	})
+
+	chat := func(ctx context.Context, model, content string) (string, string, error) {
+		respChan, _, err := r.Chat(ctx, models.ChatRequest{Model: model, Messages: []models.Message{{Role: "user", Content: content}}})
+		if err != nil {
+			return "", "", err
+		}
+		var text, gotModel string
+		for resp := range respChan {
+			text += resp.Message.Content
+			gotModel = resp.Model
+		}
+		return text, gotModel, nil
+	}
+
+	if text, model, _ := chat(context.Background(), "m", "write some SQL"); text != "big" || model != "coder" {
+		t.Fatalf("content route: got %q from model %q, want the big backend with model coder", text, model)
+	}
+	v1 := WithRequestInfo(context.Background(), RequestInfo{Path: "/v1/chat/completions"})
+	if text, model, _ := chat(v1, "gpt-4o", "hello"); text != "primary" || model != "llama" {
+		t.Fatalf("model route: got %q from model %q, want the primary with model llama", text, model)
+	}
+	if _, model, _ := chat(context.Background(), "gpt-4o", "hello"); model != "gpt-4o" {
+		t.Fatalf("model = %q, want no rewrite outside /v1/", model)
+	}
+
+	guests := WithRequestInfo(context.Background(), RequestInfo{Path: "/api/chat", Header: http.Header{"X-Team": {"guests"}}})
+	_, _, err := chat(guests, "m", "write some SQL")
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || rejected.StatusCode() != 403 {
+		t.Fatalf("error = %v, want a 403 rejection", err)
+	}
+}
+
+func TestRouterBackendOverrideHeader(t *testing.T) {
+	primary := NewMockBackend(MockOptions{Reply: "primary"})
+	big := NewMockBackend(MockOptions{Reply: "big"})
+	small := NewMockBackend(MockOptions{Reply: "small"})
+	r := NewRouterBackend(primary, map[string]Backend{"primary": primary, "big": big, "small": small}, []Route{
+		{Name: "blocked", Model: regexp.MustCompile("^secret$"), Reject: 403, RejectMessage: "no"},
+		{Name: "code", Content: regexp.MustCompile("code"), Backend: "big", SetModel: "coder"},
+	})
+	r.SetOverrideHeader("X-LLM-Proxy-Backend", []string{"primary", "small"})
+
+	chat := func(backendName, model, content string) (string, string, error) {
+		ctx := WithRequestInfo(context.Background(), RequestInfo{Path: "/api/chat", Header: http.Header{"X-Llm-Proxy-Backend": {backendName}}})
+		respChan, _, err := r.Chat(ctx, models.ChatRequest{Model: model, Messages: []models.Message{{Role: "user", Content: content}}})
+		if err != nil {
+			return "", "", err
+		}
+		var text, gotModel string
+		for resp := range respChan {
+			text += resp.Message.Content
+			gotModel = resp.Model
+		}
+		return text, gotModel, nil
+	}
+
+	if text, _, _ := chat("", "m", "hello"); text != "primary" {
+		t.Fatalf("without header got %q, want the default backend", text)
+	}
+	if text, model, _ := chat("small", "m", "write code"); text != "small" || model != "coder" {
+		t.Fatalf("override got %q from model %q, want the small backend with the route's model", text, model)
+	}
+	var rejected *RejectedError
+	for _, name := range []string{"big", "missing"} {
+		if _, _, err := chat(name, "m", "hello"); !errors.As(err, &rejected) || rejected.StatusCode() != 400 {
+			t.Fatalf("override to %q: error = %v, want a 400 rejection", name, err)
+		}
+	}
+	if _, _, err := chat("small", "secret", "hello"); !errors.As(err, &rejected) || rejected.StatusCode() != 403 {
+		t.Fatalf("error = %v, want the route's rejection to still apply", err)
+	}
+}
+
+func TestRouterBackendTenantBackends(t *testing.T) {
+	primary := NewMockBackend(MockOptions{Reply: "primary"})
+	big := NewMockBackend(MockOptions{Reply: "big"})
+	small := NewMockBackend(MockOptions{Reply: "small"})
+	r := NewRouterBackend(primary, map[string]Backend{"primary": primary, "big": big, "small": small}, []Route{
+		{Name: "code", Content: regexp.MustCompile("code"), Backend: "small"},
+	})
+	r.SetTenantBackends(map[string]string{"team-a": "big"})
+
+	generate := func(tenant, prompt string) string {
+		ctx := context.Background()
+		if tenant != "" {
+			ctx = WithTenant(ctx, tenant)
+		}
+		respChan, _, err := r.Generate(ctx, models.GenerateRequest{Model: "m", Prompt: prompt})
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		var text string
+		for resp := range respChan {
+			text += resp.Response
+		}
+		return text
+	}
+
+	if got := generate("team-a", "hello"); got != "big" {
+		t.Fatalf("team-a got %q, want its backend", got)
+	}
+	if got := generate("team-b", "hello"); got != "primary" {
+		t.Fatalf("team-b got %q, want the default backend", got)
+	}
+	if got := generate("team-a", "write code"); got != "small" {
+		t.Fatalf("team-a got %q, want the route's backend", got)
+	}
+}
@@ -0,0 +1,406 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"llm_proxy/models"
+)
+
+type clientKeyContextKey struct{}
+
+// WithClientKey returns a context carrying the API key the client presented,
+// so the scheduler can group requests per key when it has to queue them.
+func WithClientKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, clientKeyContextKey{}, key)
+}
+
+// ClientKeyFromContext returns the client API key stored by WithClientKey, or
+// an empty string for anonymous requests.
+func ClientKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(clientKeyContextKey{}).(string)
+	return key
+}
+
+// Scheduler limits the number of concurrent backend requests. When all slots
+// are busy, waiting requests are dispatched in weighted fair order across
+// client keys (start-time fair queueing): each queued request gets a virtual
+// tag that advances by 1/weight per request from the same key, and the
+// lowest tag is served first. A key sending a large batch therefore only
+// delays its own later requests, not those of other keys.
+type Scheduler struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	defaultWeight int
+	weights       map[string]int
+	maxQueueDepth int           // 0 = unlimited
+	queueTimeout  time.Duration // 0 = as long as the client waits
+	active        int
+	virtualTime   float64
+	lastTag       map[string]float64 // Only keys whose last tag is ahead of virtualTime
+	seq           uint64
+	waiting       []*schedulerWaiter
+	stats         map[string]*schedulerKeyStats
+}
+
+// maxSchedulerKeys caps how many client keys the scheduler keeps metrics
+// for. The key seen longest ago is forgotten first.
+const maxSchedulerKeys = 1000
+
+type schedulerWaiter struct {
+	key      string
+	tag      float64
+	seq      uint64
+	enqueued time.Time
+	ready    chan struct{}
+}
+
+type schedulerKeyStats struct {
+	requests  int64
+	queued    int64
+	totalWait time.Duration
+	maxWait   time.Duration
+	lastSeen  time.Time
+}
+
+// SchedulerStats is a point-in-time snapshot of scheduler state.
+type SchedulerStats struct {
+	MaxConcurrent int                 `json:"max_concurrent_requests"`
+	Active        int                 `json:"active"`
+	QueueDepth    int                 `json:"queue_depth"`
+	Keys          []SchedulerKeyStats `json:"keys"`
+}
+
+// SchedulerKeyStats holds queueing metrics for one client key. Key is masked
+// so the snapshot can be exposed without leaking credentials.
+type SchedulerKeyStats struct {
+	Key         string  `json:"key"`
+	Weight      int     `json:"weight"`
+	Requests    int64   `json:"requests"`
+	Queued      int64   `json:"queued"`
+	Waiting     int     `json:"waiting"`
+	AvgWaitMs   float64 `json:"avg_wait_ms"`
+	MaxWaitMs   float64 `json:"max_wait_ms"`
+	TotalWaitMs float64 `json:"total_wait_ms"`
+}
+
+// NewScheduler creates a scheduler allowing maxConcurrent in-flight requests.
+// Keys without an entry in weights use defaultWeight.
+func NewScheduler(maxConcurrent int, defaultWeight int, weights map[string]int) *Scheduler {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	if defaultWeight <= 0 {
+		defaultWeight = 1
+	}
+	copied := make(map[string]int, len(weights))
+	for k, v := range weights {
+		copied[k] = v
+	}
+	return &Scheduler{
+		maxConcurrent: maxConcurrent,
+		defaultWeight: defaultWeight,
+		weights:       copied,
+		lastTag:       make(map[string]float64),
+		stats:         make(map[string]*schedulerKeyStats),
+	}
+}
+
+// SetQueueLimits turns requests away with ErrQueueFull when maxDepth requests
+// are already waiting, and with ErrQueueTimeout once they have waited for
+// timeout. 0 leaves either unlimited.
+func (s *Scheduler) SetQueueLimits(maxDepth int, timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxQueueDepth = maxDepth
+	s.queueTimeout = timeout
+}
+
+func (s *Scheduler) weight(key string) int {
+	if w, ok := s.weights[key]; ok && w > 0 {
+		return w
+	}
+	return s.defaultWeight
+}
+
+// Acquire blocks until a slot is available for key or ctx is done. The
+// returned release function must be called exactly once when the request
+// no longer occupies the backend; extra calls are ignored.
+func (s *Scheduler) Acquire(ctx context.Context, key string) (func(), error) {
+	s.mu.Lock()
+	if s.active < s.maxConcurrent && len(s.waiting) == 0 {
+		s.active++
+		s.recordLocked(key, 0, false)
+		s.mu.Unlock()
+		return s.releaseFunc(), nil
+	}
+	if s.maxQueueDepth > 0 && len(s.waiting) >= s.maxQueueDepth {
+		s.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	start := s.virtualTime
+	if last, ok := s.lastTag[key]; ok && last > start {
+		start = last
+	}
+	tag := start + 1/float64(s.weight(key))
+	s.lastTag[key] = tag
+	s.seq++
+	w := &schedulerWaiter{
+		key:      key,
+		tag:      tag,
+		seq:      s.seq,
+		enqueued: time.Now(),
+		ready:    make(chan struct{}),
+	}
+	s.waiting = append(s.waiting, w)
+	var deadline <-chan time.Time
+	if s.queueTimeout > 0 {
+		timeout := time.NewTimer(s.queueTimeout)
+		defer timeout.Stop()
+		deadline = timeout.C
+	}
+	s.mu.Unlock()
+
+	var err error
+	select {
+	case <-w.ready:
+		return s.releaseFunc(), nil
+	case <-deadline:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.mu.Lock()
+	if s.removeWaiterLocked(w) {
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.mu.Unlock()
+	// Dispatched concurrently with the cancellation; hand the slot back.
+	s.releaseFunc()()
+	return nil, err
+}
+
+func (s *Scheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.active--
+			s.dispatchLocked()
+		})
+	}
+}
+
+func (s *Scheduler) dispatchLocked() {
+	for s.active < s.maxConcurrent && len(s.waiting) > 0 {
+		next := 0
+		for i, w := range s.waiting[1:] {
+			best := s.waiting[next]
+			if w.tag < best.tag || (w.tag == best.tag && w.seq < best.seq) {
+				next = i + 1
+			}
+		}
+		w := s.waiting[next]
+		s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+		s.virtualTime = w.tag
+		// Tags behind the virtual time no longer change a key's next tag
+		for key, tag := range s.lastTag {
+			if tag <= s.virtualTime {
+				delete(s.lastTag, key)
+			}
+		}
+		s.active++
+		s.recordLocked(w.key, time.Since(w.enqueued), true)
+		close(w.ready)
+	}
+}
+
+func (s *Scheduler) removeWaiterLocked(target *schedulerWaiter) bool {
+	for i, w := range s.waiting {
+		if w == target {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scheduler) recordLocked(key string, wait time.Duration, queued bool) {
+	st, ok := s.stats[key]
+	if !ok {
+		if len(s.stats) >= maxSchedulerKeys {
+			s.forgetOldestKeyLocked()
+		}
+		st = &schedulerKeyStats{}
+		s.stats[key] = st
+	}
+	st.lastSeen = time.Now()
+	st.requests++
+	if queued {
+		st.queued++
+		st.totalWait += wait
+		if wait > st.maxWait {
+			st.maxWait = wait
+		}
+	}
+}
+
+// forgetOldestKeyLocked drops the metrics of the key seen longest ago
+func (s *Scheduler) forgetOldestKeyLocked() {
+	var oldest string
+	var oldestSeen time.Time
+	for key, st := range s.stats {
+		if oldestSeen.IsZero() || st.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = key, st.lastSeen
+		}
+	}
+	delete(s.stats, oldest)
+}
+
+// Stats returns a snapshot of the scheduler's queue depth and per-key wait
+// time metrics.
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	waitingByKey := make(map[string]int)
+	for _, w := range s.waiting {
+		waitingByKey[w.key]++
+	}
+
+	keys := make([]string, 0, len(s.stats))
+	for k := range s.stats {
+		keys = append(keys, k)
+	}
+	for k := range waitingByKey {
+		if _, ok := s.stats[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	out := SchedulerStats{
+		MaxConcurrent: s.maxConcurrent,
+		Active:        s.active,
+		QueueDepth:    len(s.waiting),
+		Keys:          make([]SchedulerKeyStats, 0, len(keys)),
+	}
+	for _, k := range keys {
+		ks := SchedulerKeyStats{
+			Key:     maskClientKey(k),
+			Weight:  s.weight(k),
+			Waiting: waitingByKey[k],
+		}
+		if st, ok := s.stats[k]; ok {
+			ks.Requests = st.requests
+			ks.Queued = st.queued
+			ks.TotalWaitMs = durationMs(st.totalWait)
+			ks.MaxWaitMs = durationMs(st.maxWait)
+			if st.queued > 0 {
+				ks.AvgWaitMs = ks.TotalWaitMs / float64(st.queued)
+			}
+		}
+		out.Keys = append(out.Keys, ks)
+	}
+	return out
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// maskClientKey hides all but the edges of an API key.
+func maskClientKey(key string) string {
+	if key == "" {
+		return "anonymous"
+	}
+	if len(key) <= 8 {
+		return "****"
+	}
+	return fmt.Sprintf("%s…%s", key[:4], key[len(key)-4:])
+}
+
+// ScheduledBackend wraps another backend and routes Chat/Generate calls
+// through a Scheduler. A slot is held until the response channel has been
+// fully drained (or the request context ends), so streaming responses count
+// against the concurrency limit for their whole duration.
+type ScheduledBackend struct {
+	Backend
+	scheduler *Scheduler
+}
+
+// NewScheduledBackend creates a scheduled wrapper around inner.
+func NewScheduledBackend(inner Backend, scheduler *Scheduler) *ScheduledBackend {
+	return &ScheduledBackend{Backend: inner, scheduler: scheduler}
+}
+
+// Generate waits for a scheduler slot before forwarding to the wrapped backend.
+func (b *ScheduledBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	release, err := b.scheduler.Acquire(ctx, ClientKeyFromContext(ctx))
+	if err != nil {
+		ch := make(chan models.GenerateResponse)
+		close(ch)
+		return ch, &BackendMetadata{}, fmt.Errorf("waiting for backend slot: %w", err)
+	}
+	respChan, metadata, err := b.Backend.Generate(ctx, req)
+	if err != nil {
+		release()
+		return respChan, metadata, err
+	}
+	return relayUntilClosed(ctx, respChan, release), metadata, nil
+}
+
+// Chat waits for a scheduler slot before forwarding to the wrapped backend.
+func (b *ScheduledBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	release, err := b.scheduler.Acquire(ctx, ClientKeyFromContext(ctx))
+	if err != nil {
+		ch := make(chan models.ChatResponse)
+		close(ch)
+		return ch, &BackendMetadata{}, fmt.Errorf("waiting for backend slot: %w", err)
+	}
+	respChan, metadata, err := b.Backend.Chat(ctx, req)
+	if err != nil {
+		release()
+		return respChan, metadata, err
+	}
+	return relayUntilClosed(ctx, respChan, release), metadata, nil
+}
+
+// Embed waits for a scheduler slot before forwarding to the wrapped backend.
+func (b *ScheduledBackend) Embed(ctx context.Context, req models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	release, err := b.scheduler.Acquire(ctx, ClientKeyFromContext(ctx))
+	if err != nil {
+		return models.EmbedResponse{}, &BackendMetadata{}, fmt.Errorf("waiting for backend slot: %w", err)
+	}
+	defer release()
+	return b.Backend.Embed(ctx, req)
+}
+
+// relayUntilClosed forwards everything from in to the returned channel and
+// calls done once in is closed, before closing the returned channel so a
+// reader that saw the end finds the slot released. If ctx ends first (the
+// client went away and the handler stopped reading), the rest of in is
+// drained without forwarding so the producing goroutine can finish.
+func relayUntilClosed[T any](ctx context.Context, in <-chan T, done func()) <-chan T {
+	out := make(chan T, cap(in))
+	go func() {
+		defer close(out)
+		defer done()
+		for item := range in {
+			select {
+			case out <- item:
+			case <-ctx.Done():
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,227 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestSchedulerDispatchesQueuedRequestsByWeight(t *testing.T) {
+	s := NewScheduler(1, 1, map[string]int{"interactive": 2})
+
+	hold, err := s.Acquire(context.Background(), "batch")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queued := 0
+	enqueue := func(key string) {
+		queued++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.Acquire(context.Background(), key)
+			if err != nil {
+				t.Errorf("Acquire(%q) error = %v", key, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, key)
+			mu.Unlock()
+			release()
+		}()
+		waitForQueueDepth(t, s, queued)
+	}
+
+	// A batch user queues four requests before the interactive user shows up.
+	for i := 0; i < 4; i++ {
+		enqueue("batch")
+	}
+	for i := 0; i < 2; i++ {
+		enqueue("interactive")
+	}
+
+	hold()
+	wg.Wait()
+
+	// Interactive requests are interleaved ahead of the batch backlog
+	// instead of waiting behind all four batch requests.
+	want := []string{"interactive", "batch", "interactive", "batch", "batch", "batch"}
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+
+	stats := s.Stats()
+	if stats.QueueDepth != 0 || stats.Active != 0 {
+		t.Fatalf("stats = %#v, want empty queue and no active requests", stats)
+	}
+	var batchQueued int64
+	for _, k := range stats.Keys {
+		if k.Key == "batch" {
+			t.Fatalf("stats expose unmasked key: %#v", k)
+		}
+		if k.Weight == 1 {
+			batchQueued = k.Queued
+		}
+	}
+	if batchQueued != 4 {
+		t.Fatalf("batch queued = %d, want 4", batchQueued)
+	}
+}
+
+func TestSchedulerAcquireHonorsContextCancellation(t *testing.T) {
+	s := NewScheduler(1, 1, nil)
+	hold, err := s.Acquire(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer hold()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(ctx, ""); err == nil {
+		t.Fatal("Acquire() error = nil, want context deadline error")
+	}
+	if depth := s.Stats().QueueDepth; depth != 0 {
+		t.Fatalf("QueueDepth = %d after cancellation, want 0", depth)
+	}
+}
+
+func TestSchedulerQueueLimits(t *testing.T) {
+	s := NewScheduler(1, 1, nil)
+	s.SetQueueLimits(1, 20*time.Millisecond)
+	hold, err := s.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer hold()
+
+	waited := make(chan error, 1)
+	go func() {
+		_, err := s.Acquire(context.Background(), "a")
+		waited <- err
+	}()
+	waitForQueueDepth(t, s, 1)
+	if _, err := s.Acquire(context.Background(), "b"); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Acquire() with a full queue error = %v, want ErrQueueFull", err)
+	}
+	if err := <-waited; !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("Acquire() error = %v, want ErrQueueTimeout", err)
+	}
+	if depth := s.Stats().QueueDepth; depth != 0 {
+		t.Fatalf("QueueDepth = %d after the timeout, want 0", depth)
+	}
+}
+
+func TestSchedulerForgetsIdleKeys(t *testing.T) {
+	s := NewScheduler(1, 1, nil)
+	hold, err := s.Acquire(context.Background(), "holder")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		release, err := s.Acquire(context.Background(), "queued")
+		if err != nil {
+			t.Errorf("Acquire() error = %v", err)
+			return
+		}
+		release()
+	}()
+	waitForQueueDepth(t, s, 1)
+	hold()
+	<-done
+
+	s.mu.Lock()
+	tags := len(s.lastTag)
+	s.mu.Unlock()
+	if tags != 0 {
+		t.Fatalf("lastTag holds %d keys once the queue is served, want 0", tags)
+	}
+
+	for i := range maxSchedulerKeys + 10 {
+		release, err := s.Acquire(context.Background(), fmt.Sprintf("key-%d", i))
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		release()
+	}
+	if keys := len(s.Stats().Keys); keys != maxSchedulerKeys {
+		t.Fatalf("Stats() lists %d keys, want %d", keys, maxSchedulerKeys)
+	}
+}
+
+func TestScheduledBackendHoldsSlotUntilStreamDrained(t *testing.T) {
+	inner := &channelBackend{chat: make(chan models.ChatResponse, 2)}
+	s := NewScheduler(1, 1, nil)
+	b := NewScheduledBackend(inner, s)
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{Model: "m"})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if active := s.Stats().Active; active != 1 {
+		t.Fatalf("Active = %d while streaming, want 1", active)
+	}
+
+	inner.chat <- models.ChatResponse{Done: true}
+	close(inner.chat)
+	for range respChan {
+	}
+
+	// The slot is released before the stream's channel is closed
+	if active := s.Stats().Active; active != 0 {
+		t.Fatalf("Active = %d after the stream was drained, want 0", active)
+	}
+}
+
+type channelBackend struct {
+	chat chan models.ChatResponse
+}
+
+func (c *channelBackend) Generate(context.Context, models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	ch := make(chan models.GenerateResponse)
+	close(ch)
+	return ch, &BackendMetadata{}, nil
+}
+
+func (c *channelBackend) Chat(context.Context, models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	return c.chat, &BackendMetadata{}, nil
+}
+
+func (c *channelBackend) ListModels(context.Context) (models.ModelsResponse, error) {
+	return models.ModelsResponse{}, nil
+}
+
+func (c *channelBackend) ShowModel(context.Context, string) (models.ShowResponse, error) {
+	return models.ShowResponse{}, nil
+}
+
+func (c *channelBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *BackendMetadata, error) {
+	return models.EmbedResponse{}, &BackendMetadata{}, nil
+}
+
+func waitForQueueDepth(t *testing.T, s *Scheduler, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().QueueDepth < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth did not reach %d", want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,206 @@
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"maps"
+	mathrand "math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// errShadowIncomplete is the error of a shadow response that ended without
+// a final response
+var errShadowIncomplete = errors.New("response ended before it was complete")
+
+// ShadowResult is the outcome of a request copied to the shadow backend
+type ShadowResult struct {
+	Key      string // Same as the ShadowKey of the client request's metadata
+	Path     string // Path of the client request
+	Endpoint string // "generate" or "chat"
+	Model    string // Model requested from the shadow backend
+	Stream   bool
+	Start    time.Time
+	Duration time.Duration
+
+	// Prompt is the prompt, or the messages one per line as "role: content";
+	// LastMessage the prompt or last user message; Response the reply text
+	Prompt      string
+	LastMessage string
+	Response    string
+	DoneReason  string
+
+	Metadata *BackendMetadata // Never nil
+	Err      error            // Why the shadow request failed, if it did
+}
+
+// ShadowBackend wraps the backend serving clients and sends a copy of each
+// generate and chat request to a second, shadow, backend. The shadow's
+// responses never reach clients: they are passed to a record function, to
+// be logged next to the response clients got. The copy is sent alongside
+// the client's request and isn't cancelled with it.
+type ShadowBackend struct {
+	Backend
+	shadow     Backend
+	model      string
+	sampleRate float64
+	timeout    time.Duration
+	record     func(ShadowResult)
+}
+
+// NewShadowBackend creates a wrapper around inner that copies requests to
+// shadow. model, if not empty, replaces the requested model on the copies.
+// sampleRate is the fraction of requests copied and timeout the longest a
+// copy may take (0 = no limit). record is called with every copy's result,
+// from the goroutine that sent it.
+func NewShadowBackend(inner, shadow Backend, model string, sampleRate float64, timeout time.Duration, record func(ShadowResult)) *ShadowBackend {
+	return &ShadowBackend{
+		Backend:    inner,
+		shadow:     shadow,
+		model:      model,
+		sampleRate: sampleRate,
+		timeout:    timeout,
+		record:     record,
+	}
+}
+
+// Generate sends req to the wrapped backend and a copy of it to the shadow.
+func (s *ShadowBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	if !s.sampled() {
+		return s.Backend.Generate(ctx, req)
+	}
+	key := newShadowKey()
+	shadowReq := req
+	shadowReq.Options = maps.Clone(req.Options)
+	shadowReq.Images = slices.Clone(req.Images)
+	if s.model != "" {
+		shadowReq.Model = s.model
+	}
+	result := ShadowResult{
+		Key:         key,
+		Path:        RequestInfoFromContext(ctx).Path,
+		Endpoint:    "generate",
+		Model:       shadowReq.Model,
+		Stream:      shadowReq.Stream,
+		Prompt:      shadowReq.Prompt,
+		LastMessage: shadowReq.Prompt,
+	}
+	go s.run(ctx, result, func(ctx context.Context, result *ShadowResult) {
+		respChan, metadata, err := s.shadow.Generate(ctx, shadowReq)
+		result.Metadata, result.Err = metadata, err
+		if err != nil {
+			return
+		}
+		final, ok := combineGenerateResponses(drain(ctx, respChan))
+		if ok {
+			result.Response, result.DoneReason = final.Response, final.DoneReason
+		}
+		result.Err = shadowStreamErr(ctx, metadata, ok)
+	})
+
+	respChan, metadata, err := s.Backend.Generate(ctx, req)
+	if metadata != nil {
+		metadata.ShadowKey = key
+	}
+	return respChan, metadata, err
+}
+
+// Chat sends req to the wrapped backend and a copy of it to the shadow.
+func (s *ShadowBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	if !s.sampled() {
+		return s.Backend.Chat(ctx, req)
+	}
+	key := newShadowKey()
+	shadowReq := req
+	shadowReq.Messages = slices.Clone(req.Messages)
+	shadowReq.Options = maps.Clone(req.Options)
+	if s.model != "" {
+		shadowReq.Model = s.model
+	}
+	var prompt strings.Builder
+	for _, msg := range shadowReq.Messages {
+		prompt.WriteString(msg.Role + ": " + msg.Content + "\n")
+	}
+	result := ShadowResult{
+		Key:         key,
+		Path:        RequestInfoFromContext(ctx).Path,
+		Endpoint:    "chat",
+		Model:       shadowReq.Model,
+		Stream:      shadowReq.Stream,
+		Prompt:      prompt.String(),
+		LastMessage: lastUserMessage(shadowReq.Messages),
+	}
+	go s.run(ctx, result, func(ctx context.Context, result *ShadowResult) {
+		respChan, metadata, err := s.shadow.Chat(ctx, shadowReq)
+		result.Metadata, result.Err = metadata, err
+		if err != nil {
+			return
+		}
+		final, ok := combineChatResponses(drain(ctx, respChan))
+		if ok {
+			result.Response, result.DoneReason = final.Message.Content, final.DoneReason
+		}
+		result.Err = shadowStreamErr(ctx, metadata, ok)
+	})
+
+	respChan, metadata, err := s.Backend.Chat(ctx, req)
+	if metadata != nil {
+		metadata.ShadowKey = key
+	}
+	return respChan, metadata, err
+}
+
+// run calls send with a context that outlives ctx, times the call and
+// records its result
+func (s *ShadowBackend) run(ctx context.Context, result ShadowResult, send func(context.Context, *ShadowResult)) {
+	ctx = context.WithoutCancel(ctx)
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	result.Start = time.Now()
+	send(ctx, &result)
+	result.Duration = time.Since(result.Start)
+	if result.Metadata == nil {
+		result.Metadata = &BackendMetadata{}
+	}
+	if result.Err != nil {
+		log.Printf("Shadow %s request for model %s failed: %v", result.Endpoint, result.Model, result.Err)
+	}
+	s.record(result)
+}
+
+// sampled reports whether the next request should be copied
+func (s *ShadowBackend) sampled() bool {
+	return s.sampleRate >= 1 || mathrand.Float64() < s.sampleRate
+}
+
+// shadowStreamErr returns why a shadow response that was read to the end is
+// incomplete, if it is
+func shadowStreamErr(ctx context.Context, metadata *BackendMetadata, complete bool) error {
+	if metadata != nil && metadata.StreamErr != nil {
+		return metadata.StreamErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !complete {
+		return errShadowIncomplete
+	}
+	return nil
+}
+
+// newShadowKey returns a random key linking a request to its shadow copy
+func newShadowKey() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
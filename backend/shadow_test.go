@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestShadowBackendCopiesRequests(t *testing.T) {
+	results := make(chan ShadowResult, 1)
+	s := NewShadowBackend(NewMockBackend(MockOptions{Reply: "production"}), NewMockBackend(MockOptions{Reply: "candidate"}),
+		"new-model", 1, time.Minute, func(result ShadowResult) { results <- result })
+
+	ctx, cancel := context.WithCancel(WithRequestInfo(context.Background(), RequestInfo{Path: "/v1/chat/completions"}))
+	respChan, metadata, err := s.Chat(ctx, models.ChatRequest{Model: "m", Messages: []models.Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var text string
+	for resp := range respChan {
+		text += resp.Message.Content
+	}
+	// The copy outlives the client's request
+	cancel()
+	if text != "production" {
+		t.Fatalf("client got %q, want the wrapped backend's reply", text)
+	}
+
+	result := <-results
+	if result.Err != nil {
+		t.Fatalf("shadow error = %v", result.Err)
+	}
+	if result.Key == "" || result.Key != metadata.ShadowKey {
+		t.Fatalf("shadow key = %q, client metadata key = %q; want the same key", result.Key, metadata.ShadowKey)
+	}
+	if result.Response != "candidate" || result.Model != "new-model" || result.Endpoint != "chat" || result.Path != "/v1/chat/completions" {
+		t.Fatalf("result = %+v", result)
+	}
+	if result.LastMessage != "hi" || result.Prompt != "user: hi\n" {
+		t.Fatalf("prompt = %q, last message = %q", result.Prompt, result.LastMessage)
+	}
+}
+
+func TestShadowBackendRecordsFailures(t *testing.T) {
+	results := make(chan ShadowResult, 1)
+	s := NewShadowBackend(NewMockBackend(MockOptions{}), NewMockBackend(MockOptions{ErrorRate: 1, ErrorStatus: 503}),
+		"", 1, time.Minute, func(result ShadowResult) { results <- result })
+
+	respChan, _, err := s.Generate(context.Background(), models.GenerateRequest{Model: "m", Prompt: "hello"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v, want the shadow's failure kept from the client", err)
+	}
+	for range respChan {
+	}
+
+	result := <-results
+	if result.Err == nil || result.Metadata.StatusCode != 503 {
+		t.Fatalf("result error = %v, status %d; want a 503 failure", result.Err, result.Metadata.StatusCode)
+	}
+	if result.Model != "m" || result.Prompt != "hello" {
+		t.Fatalf("result = %+v, want the client's model and prompt", result)
+	}
+}
+
+func TestShadowBackendSamples(t *testing.T) {
+	s := NewShadowBackend(NewMockBackend(MockOptions{}), NewMockBackend(MockOptions{}), "", 0, 0, func(ShadowResult) {
+		t.Error("request copied with a sample rate of 0")
+	})
+	respChan, metadata, err := s.Generate(context.Background(), models.GenerateRequest{Model: "m", Prompt: "hello"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for range respChan {
+	}
+	if metadata.ShadowKey != "" {
+		t.Fatalf("ShadowKey = %q, want none for a request not copied", metadata.ShadowKey)
+	}
+}
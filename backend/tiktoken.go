@@ -0,0 +1,176 @@
+package backend
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// defaultTiktokenEncoding is used for models tiktoken doesn't know, which
+// covers most open models served through OpenAI-compatible APIs
+const defaultTiktokenEncoding = tiktoken.MODEL_CL100K_BASE
+
+// TiktokenEncodings are the encodings the tiktoken counting method can be
+// configured with
+var TiktokenEncodings = []string{
+	tiktoken.MODEL_O200K_BASE,
+	tiktoken.MODEL_CL100K_BASE,
+	tiktoken.MODEL_P50K_BASE,
+	tiktoken.MODEL_P50K_EDIT,
+	tiktoken.MODEL_R50K_BASE,
+}
+
+// tiktokenEncoding is a loaded encoding, or why it couldn't be loaded
+type tiktokenEncoding struct {
+	enc *tiktoken.Tiktoken
+	err error
+}
+
+var (
+	tiktokenMu        sync.Mutex
+	tiktokenEncodings = make(map[string]*tiktokenEncoding)
+)
+
+// tiktokenDownloadTimeout limits downloading an encoding's BPE ranks
+const tiktokenDownloadTimeout = 30 * time.Second
+
+func init() {
+	tiktoken.SetBpeLoader(tiktokenLoader{})
+}
+
+// SetTiktokenDir makes the tiktoken method read each encoding's BPE ranks
+// from a file in dir named after it, e.g. cl100k_base.tiktoken, instead of
+// downloading them from OpenAI on first use. It must be called before any
+// tokens are counted.
+func SetTiktokenDir(dir string) {
+	tiktoken.SetBpeLoader(tiktokenLoader{dir: dir})
+}
+
+// tiktokenLoader loads BPE rank files from a local directory, or downloads
+// them and keeps them in TIKTOKEN_CACHE_DIR (default: the temp directory)
+type tiktokenLoader struct {
+	dir string // "" = download
+}
+
+// LoadTiktokenBpe loads the file named like the last element of url.
+func (l tiktokenLoader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	name := path.Base(url)
+	var data []byte
+	var err error
+	if l.dir != "" {
+		data, err = os.ReadFile(filepath.Join(l.dir, name))
+	} else {
+		data, err = downloadTiktokenRanks(url)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ranks := make(map[string]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %q in %s", line, name)
+		}
+		tokenBytes, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token %q in %s: %w", token, name, err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(rank))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rank %q in %s: %w", rank, name, err)
+		}
+		ranks[string(tokenBytes)] = n
+	}
+	return ranks, nil
+}
+
+// downloadTiktokenRanks fetches a BPE rank file, or reads the copy cached by
+// an earlier download
+func downloadTiktokenRanks(url string) ([]byte, error) {
+	cacheDir := os.Getenv("TIKTOKEN_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "llm_proxy-tiktoken")
+	}
+	cachePath := filepath.Join(cacheDir, path.Base(url))
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return data, nil
+	}
+
+	client := &http.Client{Timeout: tiktokenDownloadTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	// Write the cache atomically, so a concurrent reader never sees half
+	// a file; failing to cache only means downloading again next time
+	if err := os.MkdirAll(cacheDir, 0o755); err == nil {
+		if tmp, err := os.CreateTemp(cacheDir, path.Base(url)+".*.tmp"); err == nil {
+			_, writeErr := tmp.Write(data)
+			closeErr := tmp.Close()
+			if writeErr != nil || closeErr != nil || os.Rename(tmp.Name(), cachePath) != nil {
+				os.Remove(tmp.Name())
+			}
+		}
+	}
+	return data, nil
+}
+
+// tiktokenEncodingName returns the encoding to count model's tokens with:
+// the one named in a "tiktoken:<encoding>" method, else the one tiktoken
+// uses for the model (ignoring any "provider/" prefix), else cl100k_base.
+func tiktokenEncodingName(model, method string) string {
+	if _, name, ok := strings.Cut(method, ":"); ok {
+		return name
+	}
+	model = model[strings.LastIndex(model, "/")+1:]
+	if name, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return name
+	}
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return name
+		}
+	}
+	return defaultTiktokenEncoding
+}
+
+// countTiktokens counts the tokens in text with the named encoding, loading
+// it on first use. A failed load is remembered, so it is only tried once.
+func countTiktokens(encoding, text string) (int, error) {
+	tiktokenMu.Lock()
+	loaded, ok := tiktokenEncodings[encoding]
+	if !ok {
+		enc, err := tiktoken.GetEncoding(encoding)
+		loaded = &tiktokenEncoding{enc: enc, err: err}
+		tiktokenEncodings[encoding] = loaded
+	}
+	tiktokenMu.Unlock()
+
+	if loaded.err != nil {
+		return 0, fmt.Errorf("failed to load %s: %w", encoding, loaded.err)
+	}
+	return len(loaded.enc.EncodeOrdinary(text)), nil
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestTiktokenRanks writes a tiny cl100k_base rank file: every single
+// byte, plus merges for "he", "ll" and "hell"
+func writeTestTiktokenRanks(t *testing.T) string {
+	t.Helper()
+	var ranks strings.Builder
+	for b := 0; b < 256; b++ {
+		fmt.Fprintf(&ranks, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b)
+	}
+	for i, token := range []string{"he", "ll", "hell"} {
+		fmt.Fprintf(&ranks, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), 256+i)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cl100k_base.tiktoken"), []byte(ranks.String()), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return dir
+}
+
+func TestTokenCountingBackendCountsWithTiktoken(t *testing.T) {
+	SetTiktokenDir(writeTestTiktokenRanks(t))
+	b := NewTokenCountingBackend(nil, "http://backend.test", nil, TokenCountPolicy{
+		Method: TokenCountEstimate,
+		Models: map[string]string{"llama": "tiktoken:cl100k_base"},
+	})
+
+	// "hello" merges to "hell" + "o"; " world" has no merges, so is one
+	// token per byte
+	if got := b.countTokens(t.Context(), "llama", "hello world"); got != 8 {
+		t.Fatalf("countTokens() = %d, want 8", got)
+	}
+	if got := b.countTokens(t.Context(), "other", "hello world"); got != estimateTokens("hello world") {
+		t.Fatalf("countTokens() for a model without tiktoken = %d, want the estimate", got)
+	}
+}
+
+func TestTiktokenEncodingName(t *testing.T) {
+	cases := []struct {
+		model, method, want string
+	}{
+		{"gpt-4o-mini", "tiktoken", "o200k_base"},
+		{"openai/gpt-4o", "tiktoken", "o200k_base"},
+		{"gpt-3.5-turbo", "tiktoken", "cl100k_base"},
+		{"llama-3.1-8b", "tiktoken", "cl100k_base"},
+		{"gpt-4o", "tiktoken:r50k_base", "r50k_base"},
+	}
+	for _, tc := range cases {
+		if got := tiktokenEncodingName(tc.model, tc.method); got != tc.want {
+			t.Errorf("tiktokenEncodingName(%q, %q) = %q, want %q", tc.model, tc.method, got, tc.want)
+		}
+	}
+}
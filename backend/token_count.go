@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"llm_proxy/models"
+)
+
+// Token counting methods for TokenCountPolicy
+const (
+	TokenCountEstimate = "estimate" // Approximate from text length
+	TokenCountTiktoken = "tiktoken" // Count with OpenAI's BPE tokenizer; "tiktoken:<encoding>" picks the encoding
+	TokenCountTokenize = "tokenize" // Ask the backend's llama.cpp /tokenize endpoint
+	TokenCountOff      = "off"      // Leave counts as the backend reported them
+)
+
+// perMessageTokens approximates the chat template tokens (role markers,
+// separators) added around each message.
+const perMessageTokens = 4
+
+// TokenCountPolicy controls how TokenCountingBackend counts tokens.
+type TokenCountPolicy struct {
+	Method string            // Default method
+	Models map[string]string // Per-model method overrides
+}
+
+func (p TokenCountPolicy) method(model string) string {
+	if method, ok := p.Models[model]; ok {
+		return method
+	}
+	return p.Method
+}
+
+// TokenCountingBackend wraps an OpenAI-compatible backend and fills in real
+// prompt and completion token counts when the backend doesn't report usage.
+// Without it the counts on such responses are placeholders, which breaks
+// clients that display token statistics.
+type TokenCountingBackend struct {
+	Backend
+	endpoint string
+	headers  map[string]string
+	policy   TokenCountPolicy
+	client   *http.Client
+}
+
+// NewTokenCountingBackend creates a token-counting wrapper around inner.
+// endpoint and headers are used for the tokenize method.
+func NewTokenCountingBackend(inner Backend, endpoint string, headers map[string]string, policy TokenCountPolicy) *TokenCountingBackend {
+	return &TokenCountingBackend{
+		Backend:  inner,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		headers:  headers,
+		policy:   policy,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetTransport replaces the HTTP transport used for tokenize requests; nil
+// uses the default transport.
+func (b *TokenCountingBackend) SetTransport(transport http.RoundTripper) {
+	b.client.Transport = transport
+}
+
+// Generate counts tokens for the final response if the backend reported none.
+func (b *TokenCountingBackend) Generate(ctx context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *BackendMetadata, error) {
+	respChan, metadata, err := b.Backend.Generate(ctx, req)
+	if err != nil || b.policy.method(req.Model) == TokenCountOff {
+		return respChan, metadata, err
+	}
+
+	out := make(chan models.GenerateResponse, cap(respChan))
+	go func() {
+		defer close(out)
+		var generated strings.Builder
+		for resp := range respChan {
+			generated.WriteString(resp.Thinking)
+			generated.WriteString(resp.Response)
+			if resp.Done && resp.Usage == nil {
+				prompt := b.countTokens(ctx, req.Model, req.System) + b.countTokens(ctx, req.Model, req.Prompt)
+				completion := b.countTokens(ctx, req.Model, generated.String())
+				resp.PromptEvalCount, resp.EvalCount = prompt, completion
+				resp.Usage = &models.OpenAIUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+			}
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				for range respChan {
+				}
+				return
+			}
+		}
+	}()
+	return out, metadata, nil
+}
+
+// Chat counts tokens for the final response if the backend reported none.
+func (b *TokenCountingBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	respChan, metadata, err := b.Backend.Chat(ctx, req)
+	if err != nil || b.policy.method(req.Model) == TokenCountOff {
+		return respChan, metadata, err
+	}
+
+	out := make(chan models.ChatResponse, cap(respChan))
+	go func() {
+		defer close(out)
+		var generated strings.Builder
+		for resp := range respChan {
+			generated.WriteString(resp.Message.Thinking)
+			generated.WriteString(resp.Message.Content)
+			for _, tc := range resp.Message.ToolCalls {
+				if data, err := json.Marshal(tc); err == nil {
+					generated.Write(data)
+				}
+			}
+			if resp.Done && resp.Usage == nil {
+				prompt := b.countMessages(ctx, req.Model, req.Messages)
+				completion := b.countTokens(ctx, req.Model, generated.String())
+				resp.PromptEvalCount, resp.EvalCount = prompt, completion
+				resp.Usage = &models.OpenAIUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+			}
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				for range respChan {
+				}
+				return
+			}
+		}
+	}()
+	return out, metadata, nil
+}
+
+func (b *TokenCountingBackend) countMessages(ctx context.Context, model string, messages []models.Message) int {
+	var text strings.Builder
+	for _, msg := range messages {
+		text.WriteString(msg.Content)
+		text.WriteString("\n")
+	}
+	return b.countTokens(ctx, model, text.String()) + perMessageTokens*len(messages)
+}
+
+// countTokens counts the tokens in text with the method configured for
+// model. Tokenizer failures fall back to the estimate.
+func (b *TokenCountingBackend) countTokens(ctx context.Context, model string, text string) int {
+	if text == "" {
+		return 0
+	}
+	switch method := b.policy.method(model); {
+	case method == TokenCountTokenize:
+		n, err := b.tokenize(ctx, text)
+		if err == nil {
+			return n
+		}
+		log.Printf("Token counting: tokenize failed, estimating instead: %v", err)
+	case method == TokenCountTiktoken || strings.HasPrefix(method, TokenCountTiktoken+":"):
+		n, err := countTiktokens(tiktokenEncodingName(model, method), text)
+		if err == nil {
+			return n
+		}
+		log.Printf("Token counting: tiktoken failed, estimating instead: %v", err)
+	}
+	return estimateTokens(text)
+}
+
+// tokenize counts tokens with llama.cpp's /tokenize endpoint.
+func (b *TokenCountingBackend) tokenize(ctx context.Context, text string) (int, error) {
+	data, err := json.Marshal(map[string]string{"content": text})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+"/tokenize", bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	setExtraHeaders(httpReq, b.headers)
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var tokens struct {
+		Tokens []json.RawMessage `json:"tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return len(tokens.Tokens), nil
+}
+
+// estimateTokens approximates a token count from text length: about four
+// characters per token for ASCII text (the usual rule of thumb for BPE
+// tokenizers on English), and one token per character otherwise, since
+// CJK and other scripts tokenize far less densely.
+func estimateTokens(text string) int {
+	ascii := 0
+	other := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return max(1, (ascii+3)/4+other)
+}
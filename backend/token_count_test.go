@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{"a", 1},
+		{"hello world!", 3},
+		{"日本語", 3},
+	}
+	for _, tc := range cases {
+		if got := estimateTokens(tc.text); got != tc.want {
+			t.Fatalf("estimateTokens(%q) = %d, want %d", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestTokenCountingBackendEstimatesMissingUsage(t *testing.T) {
+	inner := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"hello world!"},"finish_reason":"stop"}]}`), nil
+	})
+	b := NewTokenCountingBackend(inner, "http://backend.test", nil, TokenCountPolicy{Method: TokenCountEstimate})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "m",
+		Messages: []models.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	resp := <-respChan
+
+	// "hi\n" is one token plus the per-message overhead
+	if resp.PromptEvalCount != 1+perMessageTokens || resp.EvalCount != 3 {
+		t.Fatalf("counts = %d/%d, want %d/3", resp.PromptEvalCount, resp.EvalCount, 1+perMessageTokens)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 4+perMessageTokens {
+		t.Fatalf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestTokenCountingBackendKeepsReportedUsage(t *testing.T) {
+	inner := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return jsonResponse(`{"choices":[{"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":7,"total_tokens":19}}`), nil
+	})
+	b := NewTokenCountingBackend(inner, "http://backend.test", nil, TokenCountPolicy{Method: TokenCountEstimate})
+
+	respChan, _, err := b.Chat(context.Background(), models.ChatRequest{
+		Model:    "m",
+		Messages: []models.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	resp := <-respChan
+	if resp.PromptEvalCount != 12 || resp.EvalCount != 7 {
+		t.Fatalf("counts = %d/%d, want 12/7", resp.PromptEvalCount, resp.EvalCount)
+	}
+}
+
+func TestTokenCountingBackendTokenizesPerModel(t *testing.T) {
+	inner := NewOpenAIBackend("http://backend.test", 10, "", false, false, false, nil)
+	inner.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return jsonResponse(`{"choices":[{"text":"once upon a time","finish_reason":"stop"}]}`), nil
+	})
+	b := NewTokenCountingBackend(inner, "http://backend.test/", nil, TokenCountPolicy{
+		Method: TokenCountOff,
+		Models: map[string]string{"llama": TokenCountTokenize},
+	})
+	var tokenized []string
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.String() != "http://backend.test/tokenize" {
+			t.Fatalf("tokenize URL = %s", r.URL)
+		}
+		var body struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		tokenized = append(tokenized, body.Content)
+		ids := make([]string, len(strings.Fields(body.Content)))
+		for i := range ids {
+			ids[i] = "1"
+		}
+		return jsonResponse(`{"tokens":[` + strings.Join(ids, ",") + `]}`), nil
+	})
+
+	respChan, _, err := b.Generate(context.Background(), models.GenerateRequest{Model: "llama", Prompt: "tell a story"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	resp := <-respChan
+	if resp.PromptEvalCount != 3 || resp.EvalCount != 4 {
+		t.Fatalf("counts = %d/%d, want 3/4 (tokenized %q)", resp.PromptEvalCount, resp.EvalCount, tokenized)
+	}
+
+	// Other models fall back to the default method, which is off
+	respChan, _, err = b.Generate(context.Background(), models.GenerateRequest{Model: "other", Prompt: "tell a story"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if resp := <-respChan; resp.Usage != nil || len(tokenized) != 2 {
+		t.Fatalf("usage = %+v, tokenized = %q", resp.Usage, tokenized)
+	}
+}
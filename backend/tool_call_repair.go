@@ -0,0 +1,290 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+
+	"llm_proxy/models"
+)
+
+// toolCallTag opens a Hermes/Qwen-style tool call written as text, e.g.
+// <tool_call>{"name": "read_file", "arguments": {"path": "a.go"}}</tool_call>
+const toolCallTag = "<tool_call>"
+
+// toolCallTagRe matches one tagged tool call. The closing tag is optional
+// since models often stop generating right after the JSON.
+var toolCallTagRe = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*(?:</tool_call>|$)`)
+
+// codeFenceRe matches a reply that is only a fenced code block
+var codeFenceRe = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*\n(.*?)\\s*```$")
+
+// ToolCallRepairBackend wraps another backend and turns tool calls that a
+// model wrote as plain text into structured tool calls. It handles JSON
+// replies such as {"name": ..., "arguments": {...}} (alone, in a list or in a
+// code block) and <tool_call> tags. Only requests that offer tools are
+// repaired, and JSON replies only when they name one of those tools.
+//
+// While streaming, content that may be a tool call is held back until the
+// response ends: everything from a <tool_call> tag, or the whole reply if it
+// starts like JSON. Other content streams through unchanged.
+type ToolCallRepairBackend struct {
+	Backend
+	models map[string]bool
+}
+
+// NewToolCallRepairBackend creates a tool call repairing wrapper around
+// inner for the given models (none = every model).
+func NewToolCallRepairBackend(inner Backend, modelNames []string) *ToolCallRepairBackend {
+	r := &ToolCallRepairBackend{Backend: inner}
+	if len(modelNames) > 0 {
+		r.models = make(map[string]bool, len(modelNames))
+		for _, name := range modelNames {
+			r.models[name] = true
+		}
+	}
+	return r
+}
+
+// Chat repairs text tool calls in the reply.
+func (r *ToolCallRepairBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *BackendMetadata, error) {
+	respChan, metadata, err := r.Backend.Chat(ctx, req)
+	if err != nil || len(req.Tools) == 0 || (r.models != nil && !r.models[req.Model]) {
+		return respChan, metadata, err
+	}
+
+	tools := make(map[string]bool, len(req.Tools))
+	for _, tool := range req.Tools {
+		if toolMap, ok := tool.(map[string]interface{}); ok {
+			if function, ok := toolMap["function"].(map[string]interface{}); ok {
+				if name, ok := function["name"].(string); ok {
+					tools[name] = true
+				}
+			}
+		}
+	}
+
+	out := make(chan models.ChatResponse, cap(respChan))
+	go func() {
+		defer close(out)
+		send := func(resp models.ChatResponse) bool {
+			select {
+			case out <- resp:
+				return true
+			case <-ctx.Done():
+				for range respChan {
+				}
+				return false
+			}
+		}
+
+		repair := toolCallRepair{tools: tools}
+		for resp := range respChan {
+			if len(resp.Message.ToolCalls) > 0 {
+				// The model produced native tool calls, so there is nothing
+				// to repair
+				repair.native = true
+			}
+			if !resp.Done {
+				if repair.native {
+					if held := repair.release(); held != "" && !send(heldChunk(resp, held)) {
+						return
+					}
+				} else {
+					resp.Message.Content = repair.feed(resp.Message.Content)
+					if resp.Message.Content == "" && resp.Message.Thinking == "" && len(resp.Message.ToolCalls) == 0 {
+						continue
+					}
+				}
+				if !send(resp) {
+					return
+				}
+				continue
+			}
+
+			content := repair.release() + resp.Message.Content
+			resp.Message.Content = content
+			if !repair.native {
+				if calls, rest := parseTextToolCalls(content, tools); len(calls) > 0 {
+					log.Printf("Tool call repair: %s wrote %d tool call(s) as text", req.Model, len(calls))
+					resp.Message.Content = rest
+					resp.Message.ToolCalls = calls
+					if resp.Message.Role == "" {
+						resp.Message.Role = "assistant"
+					}
+					if resp.DoneReason == "" || resp.DoneReason == "stop" {
+						resp.DoneReason = "tool_calls"
+					}
+				}
+			}
+			if !send(resp) {
+				return
+			}
+		}
+
+		// The stream ended without a final chunk (e.g. the backend
+		// failed); pass on what was held back
+		if held := repair.release(); held != "" {
+			send(models.ChatResponse{Model: req.Model, Message: models.Message{Role: "assistant", Content: held}})
+		}
+	}()
+	return out, metadata, nil
+}
+
+// heldChunk is a content chunk carrying text that was held back
+func heldChunk(resp models.ChatResponse, held string) models.ChatResponse {
+	return models.ChatResponse{
+		Model:     resp.Model,
+		CreatedAt: resp.CreatedAt,
+		Message:   models.Message{Role: "assistant", Content: held},
+	}
+}
+
+// toolCallRepair decides which streamed content may be part of a text tool
+// call and holds it back
+type toolCallRepair struct {
+	tools   map[string]bool
+	native  bool            // Native tool calls were seen; hold nothing back
+	started bool            // Content other than whitespace has been sent
+	holding bool            // Everything from here on is held back
+	held    strings.Builder // Content not sent yet
+}
+
+// feed takes the next content delta and returns the part that can be sent
+func (t *toolCallRepair) feed(delta string) string {
+	t.held.WriteString(delta)
+	if t.holding {
+		return ""
+	}
+	text := t.held.String()
+	t.held.Reset()
+
+	if !t.started {
+		trimmed := strings.TrimLeft(text, " \t\r\n")
+		if trimmed == "" {
+			t.held.WriteString(text)
+			return ""
+		}
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "`") {
+			t.holding = true
+			t.held.WriteString(text)
+			return ""
+		}
+		t.started = true
+	}
+
+	if i := strings.Index(text, toolCallTag); i >= 0 {
+		t.holding = true
+		t.held.WriteString(text[i:])
+		return text[:i]
+	}
+	// Hold back the end of the text if it could be the start of a tag
+	for n := min(len(toolCallTag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, toolCallTag[:n]) {
+			t.held.WriteString(text[len(text)-n:])
+			return text[:len(text)-n]
+		}
+	}
+	return text
+}
+
+// release returns the content held back and stops holding
+func (t *toolCallRepair) release() string {
+	held := t.held.String()
+	t.held.Reset()
+	t.holding = false
+	t.started = t.started || strings.TrimSpace(held) != ""
+	return held
+}
+
+// parseTextToolCalls finds tool calls written as text in content. It returns
+// the calls in Ollama format and the content left once they are removed, or
+// no calls and content unchanged.
+func parseTextToolCalls(content string, tools map[string]bool) ([]interface{}, string) {
+	if strings.Contains(content, toolCallTag) {
+		var calls []interface{}
+		for _, match := range toolCallTagRe.FindAllStringSubmatch(content, -1) {
+			call, ok := parseToolCallJSON(match[1], nil)
+			if !ok {
+				return nil, content
+			}
+			calls = append(calls, call...)
+		}
+		return calls, strings.TrimSpace(toolCallTagRe.ReplaceAllString(content, ""))
+	}
+
+	text := strings.TrimSpace(content)
+	if match := codeFenceRe.FindStringSubmatch(text); match != nil {
+		text = match[1]
+	}
+	if calls, ok := parseToolCallJSON(text, tools); ok {
+		return calls, ""
+	}
+	return nil, content
+}
+
+// parseToolCallJSON parses a tool call object, or a list of them, such as
+// {"name": "f", "arguments": {...}} or {"function": {"name": "f", ...}}.
+// If tools is set every call must name one of them.
+func parseToolCallJSON(text string, tools map[string]bool) ([]interface{}, bool) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, false
+	}
+	var objects []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		objects = v
+	case map[string]interface{}:
+		if list, ok := v["tool_calls"].([]interface{}); ok {
+			objects = list
+		} else {
+			objects = []interface{}{v}
+		}
+	}
+	if len(objects) == 0 {
+		return nil, false
+	}
+
+	calls := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		call, ok := object.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if function, ok := call["function"].(map[string]interface{}); ok {
+			call = function
+		}
+		name, _ := call["name"].(string)
+		if name == "" || (tools != nil && !tools[name]) {
+			return nil, false
+		}
+		arguments, ok := call["arguments"]
+		if !ok {
+			arguments, ok = call["parameters"]
+		}
+		if !ok || arguments == nil {
+			arguments = map[string]interface{}{}
+		}
+		if s, isString := arguments.(string); isString {
+			var decoded map[string]interface{}
+			if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+				return nil, false
+			}
+			arguments = decoded
+		}
+		if _, isObject := arguments.(map[string]interface{}); !isObject {
+			return nil, false
+		}
+		calls = append(calls, map[string]interface{}{
+			"id": generateToolCallID(),
+			"function": map[string]interface{}{
+				"name":      name,
+				"arguments": arguments,
+			},
+		})
+	}
+	return calls, true
+}
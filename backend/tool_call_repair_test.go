@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+// repairChat streams chunks of content through a ToolCallRepairBackend and
+// returns what comes out
+func repairChat(t *testing.T, chunks []string) []models.ChatResponse {
+	t.Helper()
+	inner := &channelBackend{chat: make(chan models.ChatResponse, len(chunks)+1)}
+	for _, chunk := range chunks {
+		inner.chat <- models.ChatResponse{Message: models.Message{Role: "assistant", Content: chunk}}
+	}
+	inner.chat <- models.ChatResponse{Done: true, DoneReason: "stop"}
+	close(inner.chat)
+
+	r := NewToolCallRepairBackend(inner, nil)
+	respChan, _, err := r.Chat(context.Background(), models.ChatRequest{
+		Model: "qwen",
+		Tools: []interface{}{
+			map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "read_file"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	var out []models.ChatResponse
+	for resp := range respChan {
+		out = append(out, resp)
+	}
+	return out
+}
+
+func TestToolCallRepairBackendRepairsTaggedCalls(t *testing.T) {
+	out := repairChat(t, []string{"Let me look.", "\n<tool", "_call>\n{\"name\": \"read_file\", ", "\"arguments\": {\"path\": \"a.go\"}}\n</tool_call>"})
+
+	var streamed string
+	for _, resp := range out[:len(out)-1] {
+		streamed += resp.Message.Content
+	}
+	if streamed != "Let me look.\n" {
+		t.Fatalf("streamed content = %q, want the text before the tag", streamed)
+	}
+
+	final := out[len(out)-1]
+	if !final.Done || final.DoneReason != "tool_calls" || final.Message.Content != "" || len(final.Message.ToolCalls) != 1 {
+		t.Fatalf("final chunk = %+v, want one tool call", final)
+	}
+	function := final.Message.ToolCalls[0].(map[string]interface{})["function"].(map[string]interface{})
+	if function["name"] != "read_file" || function["arguments"].(map[string]interface{})["path"] != "a.go" {
+		t.Fatalf("tool call function = %+v", function)
+	}
+}
+
+func TestToolCallRepairBackendRepairsJSONReplies(t *testing.T) {
+	out := repairChat(t, []string{"```json\n", `{"name": "read_file", "parameters": "{\"path\": \"b.go\"}"}`, "\n```"})
+	if len(out) != 1 || len(out[0].Message.ToolCalls) != 1 || out[0].Message.Content != "" {
+		t.Fatalf("chunks = %+v, want only a final chunk with the tool call", out)
+	}
+	arguments := out[0].Message.ToolCalls[0].(map[string]interface{})["function"].(map[string]interface{})["arguments"]
+	if arguments.(map[string]interface{})["path"] != "b.go" {
+		t.Fatalf("arguments = %+v", arguments)
+	}
+}
+
+func TestToolCallRepairBackendLeavesOtherRepliesAlone(t *testing.T) {
+	for _, chunks := range [][]string{
+		{"Hello", " there <b>", "</b>"},
+		{`{"answer": 42}`},
+		{`{"name": "delete_everything", "arguments": {}}`},
+		{"Use the ", "<tool_call> tag."},
+	} {
+		out := repairChat(t, chunks)
+		var content, want string
+		for _, resp := range out {
+			content += resp.Message.Content
+			if len(resp.Message.ToolCalls) > 0 {
+				t.Fatalf("%q: got tool calls %+v", chunks, resp.Message.ToolCalls)
+			}
+		}
+		for _, chunk := range chunks {
+			want += chunk
+		}
+		if content != want || out[len(out)-1].DoneReason != "stop" {
+			t.Fatalf("%q: content = %q, done reason %q", chunks, content, out[len(out)-1].DoneReason)
+		}
+	}
+}
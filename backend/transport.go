@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// TransportOptions configure the HTTP connections to a backend.
+type TransportOptions struct {
+	CACert             string // PEM bundle of CAs trusted in addition to the system roots
+	ClientCert         string // PEM client certificate presented for mTLS
+	ClientKey          string // PEM private key for ClientCert
+	InsecureSkipVerify bool   // Accept any backend certificate
+
+	// Proxy is an http://, https://, socks5:// or socks5h:// proxy URL, or
+	// "direct" to ignore the proxy environment variables. When empty,
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used.
+	Proxy string
+
+	ConnectTimeout        time.Duration // Limit on establishing a connection; 0 = Go's default
+	ResponseHeaderTimeout time.Duration // Limit on waiting for response headers after sending a request; 0 = none
+	StreamIdleTimeout     time.Duration // Limit on the gap between reads of a response body; 0 = none
+}
+
+// ErrStreamIdle is returned when reading a response body that has sent
+// nothing for longer than TransportOptions.StreamIdleTimeout
+var ErrStreamIdle = errors.New("backend stopped sending data")
+
+// NewTransport builds the HTTP transport for a backend. It returns nil (use
+// the default transport) when no options are set.
+func NewTransport(opts TransportOptions) (http.RoundTripper, error) {
+	if opts == (TransportOptions{}) {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	}
+	transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	switch opts.Proxy {
+	case "":
+		// Keep the default, which reads the proxy environment variables
+	case "direct":
+		transport.Proxy = nil
+	default:
+		proxyURL, err := ParseProxyURL(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CACert != "" {
+		data, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	if opts.StreamIdleTimeout > 0 {
+		return &idleTimeoutTransport{base: transport, timeout: opts.StreamIdleTimeout}, nil
+	}
+	return transport, nil
+}
+
+// idleTimeoutTransport cancels requests whose response body goes longer
+// than timeout without delivering any data. Unlike http.Client.Timeout this
+// puts no cap on how long a steadily streaming response may take.
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	body := &idleTimeoutBody{ReadCloser: resp.Body, cancel: cancel, timeout: t.timeout}
+	body.timer = time.AfterFunc(t.timeout, body.expire)
+	resp.Body = body
+	return resp, nil
+}
+
+// idleTimeoutBody is a response body whose request is cancelled when its
+// timer expires; every read that returns data restarts the timer
+type idleTimeoutBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func (b *idleTimeoutBody) expire() {
+	b.expired.Store(true)
+	b.cancel()
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.expired.Load() {
+		return n, fmt.Errorf("%w for %s", ErrStreamIdle, b.timeout)
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// ParseProxyURL parses an outbound proxy URL, accepting the schemes
+// http.Transport supports.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q: scheme must be http, https, socks5 or socks5h", raw)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", raw)
+	}
+	return proxyURL, nil
+}
@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"llm_proxy/models"
+)
+
+func TestNewTransportPresentsClientCertificate(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "no client certificate", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"models":[{"name":"m","model":"m"}]}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	writePEM(t, caPath, "CERTIFICATE", server.Certificate().Raw)
+	certPath, keyPath := writeClientCertificate(t, dir)
+
+	b := NewOllamaBackend(server.URL, 10, nil)
+
+	// Without the client certificate the handshake fails
+	transport, err := NewTransport(TransportOptions{CACert: caPath})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	b.SetTransport(transport)
+	if _, err := b.ListModels(context.Background()); err == nil {
+		t.Fatal("ListModels() without client certificate succeeded")
+	}
+
+	transport, err = NewTransport(TransportOptions{CACert: caPath, ClientCert: certPath, ClientKey: keyPath})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	b.SetTransport(transport)
+	resp, err := b.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	if len(resp.Models) != 1 || resp.Models[0].Name != "m" {
+		t.Fatalf("models = %+v", resp.Models)
+	}
+}
+
+func TestNewTransportWithoutOptionsUsesDefault(t *testing.T) {
+	transport, err := NewTransport(TransportOptions{})
+	if err != nil || transport != nil {
+		t.Fatalf("NewTransport() = %v, %v; want nil, nil", transport, err)
+	}
+}
+
+func TestNewTransportUsesConfiguredProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte(`{"models":[]}`))
+	}))
+	defer proxy.Close()
+
+	transport, err := NewTransport(TransportOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	b := NewOllamaBackend("http://backend.invalid:11434", 10, nil)
+	b.SetTransport(transport)
+	if _, err := b.ListModels(context.Background()); err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	if proxied != "http://backend.invalid:11434/api/tags" {
+		t.Fatalf("proxied URL = %q", proxied)
+	}
+
+	if _, err := NewTransport(TransportOptions{Proxy: "ftp://proxy:21"}); err == nil {
+		t.Fatal("NewTransport() accepted an ftp proxy")
+	}
+}
+
+func writeClientCertificate(t *testing.T, dir string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "llm_proxy test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+	certPath = filepath.Join(dir, "client.pem")
+	keyPath = filepath.Join(dir, "client-key.pem")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func TestNewTransportCancelsIdleStreams(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	transport, err := NewTransport(TransportOptions{StreamIdleTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if string(data) != "first\n" {
+		t.Errorf("body = %q, want the data sent before the stall", data)
+	}
+	if !errors.Is(err, ErrStreamIdle) {
+		t.Fatalf("ReadAll() error = %v, want ErrStreamIdle", err)
+	}
+}
+
+func TestBackendDecompressesResponses(t *testing.T) {
+	const body = `{"embeddings":[[1,2]]}`
+	compress := func(newWriter func(io.Writer) io.WriteCloser) string {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		io.WriteString(w, body)
+		w.Close()
+		return buf.String()
+	}
+
+	tests := []struct {
+		encoding string
+		data     string
+	}{
+		{"gzip", compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })},
+		{"deflate", compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"deflate", compress(func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		})},
+		{"", body},
+	}
+	for _, tt := range tests {
+		b := NewOllamaBackend("http://backend.test", 10, nil)
+		b.SetTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate" {
+				t.Errorf("Accept-Encoding = %q, want gzip, deflate", got)
+			}
+			resp := jsonResponse(tt.data)
+			if tt.encoding != "" {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+			return resp, nil
+		}))
+
+		resp, metadata, err := b.Embed(context.Background(), models.EmbedRequest{Model: "m", Input: []string{"x"}})
+		if err != nil {
+			t.Fatalf("%q: Embed() error = %v", tt.encoding, err)
+		}
+		if len(resp.Embeddings) != 1 || metadata.RawResponse != body {
+			t.Errorf("%q: Embed() = %+v, raw response %q", tt.encoding, resp, metadata.RawResponse)
+		}
+	}
+}
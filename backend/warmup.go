@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"llm_proxy/models"
+)
+
+// WarmUpResult is the outcome of warming up one model
+type WarmUpResult struct {
+	Model    string
+	Duration time.Duration
+	Err      error
+}
+
+// WarmUp loads each of modelNames on b, one at a time so that they don't
+// compete for memory, and returns how long each took. Ollama backends get a
+// generate request with an empty prompt, which only loads the model; other
+// backends generate a single token. keepAlive, if not nil, is sent as the
+// request's keep_alive. Each model gets up to timeout.
+func WarmUp(ctx context.Context, b Backend, backendType string, modelNames []string, keepAlive interface{}, timeout time.Duration) []WarmUpResult {
+	results := make([]WarmUpResult, 0, len(modelNames))
+	for _, model := range modelNames {
+		req := models.GenerateRequest{Model: model, KeepAlive: keepAlive}
+		if backendType != "ollama" {
+			req.Prompt = "Hi"
+			req.Options = map[string]interface{}{"num_predict": float64(1)}
+		}
+
+		start := time.Now()
+		err := warmUpModel(ctx, b, req, timeout)
+		results = append(results, WarmUpResult{Model: model, Duration: time.Since(start), Err: err})
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return results
+}
+
+// warmUpModel sends req and waits for the whole response
+func warmUpModel(ctx context.Context, b Backend, req models.GenerateRequest, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	respChan, metadata, err := b.Generate(ctx, req)
+	if err != nil {
+		return err
+	}
+	for range respChan {
+	}
+	if metadata != nil && metadata.StreamErr != nil {
+		return metadata.StreamErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,38 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWarmUpLoadsEachModel(t *testing.T) {
+	var requests []map[string]interface{}
+	b := NewOllamaBackend("http://backend.test", 10, nil)
+	b.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		data, _ := io.ReadAll(r.Body)
+		var req map[string]interface{}
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		requests = append(requests, req)
+		if req["model"] == "missing" {
+			return textResponse("application/json", `{"error":"model not found"}`), nil
+		}
+		return textResponse("application/x-ndjson", `{"model":"`+req["model"].(string)+`","done":true,"done_reason":"load"}`+"\n"), nil
+	})
+
+	results := WarmUp(context.Background(), b, "ollama", []string{"llama", "missing"}, "30m", time.Second)
+	if len(results) != 2 || results[0].Model != "llama" || results[0].Err != nil {
+		t.Fatalf("results = %+v", results)
+	}
+	if results[1].Err == nil {
+		t.Fatal("results[1].Err = nil, want the backend's error")
+	}
+	if len(requests) != 2 || requests[0]["prompt"] != "" || requests[0]["keep_alive"] != "30m" || requests[0]["options"] != nil {
+		t.Fatalf("requests = %v, want an empty prompt with keep_alive", requests)
+	}
+}
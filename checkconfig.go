@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"llm_proxy/config"
+	"llm_proxy/database"
+)
+
+// defaultConfig is the annotated example configuration
+//
+//go:embed config.toml.example
+var defaultConfig string
+
+// probeTimeout limits each reachability check of check-config
+const probeTimeout = 5 * time.Second
+
+// runPrintDefaultConfig writes the annotated example configuration, as a
+// starting point for config.toml
+func runPrintDefaultConfig(args []string) {
+	flags := flag.NewFlagSet("print-default-config", flag.ExitOnError)
+	flags.Parse(args)
+	fmt.Print(defaultConfig)
+}
+
+// runCheckConfig loads and validates a configuration, checks that the files
+// and servers it names can be used, and prints it with the defaults applied.
+// It exits with status 1 if there are problems.
+func runCheckConfig(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configPath := flags.String("config", "config.toml", "Path to configuration file")
+	offline := flags.Bool("offline", false, "Don't check that backends and remote log sources are reachable")
+	showSecrets := flags.Bool("show-secrets", false, "Print API keys, header values and other secrets instead of masking them")
+	quiet := flags.Bool("q", false, "Only report problems, don't print the effective configuration")
+	flags.Parse(args)
+	log.SetFlags(0)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("%s: %v", *configPath, err)
+	}
+
+	problems, warnings := checkConfig(cfg, !*offline)
+
+	if !*quiet {
+		if !*showSecrets {
+			maskSecrets(cfg)
+		}
+		enc := toml.NewEncoder(os.Stdout)
+		enc.Indent = ""
+		if err := enc.Encode(cfg); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		fmt.Println()
+	}
+
+	for _, warning := range warnings {
+		log.Printf("Warning: %s", warning)
+	}
+	for _, problem := range problems {
+		log.Printf("Problem: %s", problem)
+	}
+	if len(problems) > 0 {
+		log.Printf("%s: %d problem(s) found", *configPath, len(problems))
+		os.Exit(1)
+	}
+	log.Printf("%s: OK", *configPath)
+}
+
+// checkConfig returns the problems that would stop the proxy from starting
+// or working with cfg, and warnings about things that may be intended.
+// Backends and remote log sources are only contacted if probe is set.
+func checkConfig(cfg *config.Config, probe bool) (problems, warnings []string) {
+	if cfg.Server.TLSCert != "" {
+		if _, err := serverTLSConfig(cfg.Server); err != nil {
+			problems = append(problems, fmt.Sprintf("server.tls_cert: %v", err))
+		}
+	}
+	listeners := []string{net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))}
+	if cfg.Server.AdminPort != 0 {
+		listeners = append(listeners, net.JoinHostPort(cfg.Server.AdminHost, strconv.Itoa(cfg.Server.AdminPort)))
+	}
+	for _, addr := range listeners {
+		if l, err := net.Listen("tcp", addr); err != nil {
+			warnings = append(warnings, fmt.Sprintf("can't listen on %s (is the proxy already running?): %v", addr, err))
+		} else {
+			l.Close()
+		}
+	}
+
+	if cfg.Database.Path != database.MemoryPath {
+		if info, err := os.Stat(filepath.Dir(cfg.Database.Path)); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("database.path: directory %s does not exist", filepath.Dir(cfg.Database.Path)))
+		}
+	}
+	for _, src := range cfg.Federation.Sources {
+		if src.Type == "sqlite" {
+			if _, err := os.Stat(src.Path); err != nil {
+				problems = append(problems, fmt.Sprintf("federation source %s: %v", src.Name, err))
+			}
+		}
+	}
+
+	for _, hook := range cfg.Hooks {
+		if _, err := exec.LookPath(hook.Command[0]); err != nil {
+			problems = append(problems, fmt.Sprintf("hook %s: %v", hook.Name, err))
+		}
+	}
+
+	type target struct {
+		name, backendType, endpoint string
+		timeout                     int
+		headers                     map[string]string
+		tls                         config.BackendTLSConfig
+		proxy                       string
+	}
+	targets := []target{{"backend", cfg.Backend.Type, cfg.Backend.Endpoint, cfg.Backend.Timeout, cfg.Backend.Headers, cfg.Backend.TLS, cfg.Backend.Proxy}}
+	for i, fb := range cfg.Failover.Backends {
+		targets = append(targets, target{fmt.Sprintf("failover.backends[%d]", i), fb.Type, fb.Endpoint, fb.Timeout, fb.Headers, fb.TLS, fb.Proxy})
+	}
+	if cfg.Shadow.Enabled {
+		targets = append(targets, target{"shadow", cfg.Shadow.Type, cfg.Shadow.Endpoint, cfg.Shadow.Timeout, cfg.Shadow.Headers, cfg.Shadow.TLS, cfg.Shadow.Proxy})
+	}
+	for _, t := range targets {
+		b, err := newBackend(cfg, t.backendType, t.endpoint, t.timeout, t.headers, t.tls, t.proxy)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", t.name, err))
+			continue
+		}
+		if !probe {
+			continue
+		}
+		// Listing models is cheap and doesn't load one
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		_, err = b.ListModels(ctx)
+		cancel()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s is not reachable: %v", t.name, t.endpoint, err))
+		}
+	}
+
+	if probe {
+		client := &http.Client{Timeout: probeTimeout}
+		for _, src := range cfg.Federation.Sources {
+			if src.Type != "remote" {
+				continue
+			}
+			resp, err := client.Get(src.URL + "/api/logs?limit=1")
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("federation source %s: %s is not reachable: %v", src.Name, src.URL, err))
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				problems = append(problems, fmt.Sprintf("federation source %s: %s/api/logs returned %s", src.Name, src.URL, resp.Status))
+			}
+		}
+	}
+	return problems, warnings
+}
+
+// maskedSecret replaces secrets in printed configurations
+const maskedSecret = "********"
+
+// maskSecrets replaces the API keys (including those keying the scheduler
+// weights), encryption key, header values and credentials in URLs of cfg so
+// that it can be printed
+func maskSecrets(cfg *config.Config) {
+	for _, secret := range []*string{&cfg.BackendOpenAI.APIKey, &cfg.BackendOpenRouter.APIKey, &cfg.BackendGemini.APIKey, &cfg.Database.EncryptionKey, &cfg.Notifications.WebhookURL} {
+		if *secret != "" {
+			*secret = maskedSecret
+		}
+	}
+	maskHeaders(cfg.Backend.Headers)
+	cfg.Backend.Proxy = redactProxyURL(cfg.Backend.Proxy)
+	for i := range cfg.Failover.Backends {
+		maskHeaders(cfg.Failover.Backends[i].Headers)
+		cfg.Failover.Backends[i].Proxy = redactProxyURL(cfg.Failover.Backends[i].Proxy)
+	}
+	maskHeaders(cfg.Shadow.Headers)
+	for i := range cfg.Tenants {
+		for j := range cfg.Tenants[i].APIKeys {
+			cfg.Tenants[i].APIKeys[j] = maskedSecret
+		}
+	}
+	for _, keys := range cfg.Users.APIKeys {
+		for j := range keys {
+			keys[j] = maskedSecret
+		}
+	}
+	for i := range cfg.Server.AdminAPIKeys {
+		cfg.Server.AdminAPIKeys[i] = maskedSecret
+	}
+	// The weights are keyed on API keys, numbered so that each stays listed
+	if len(cfg.Scheduler.Weights) > 0 {
+		weights := make(map[string]int, len(cfg.Scheduler.Weights))
+		for i, key := range slices.Sorted(maps.Keys(cfg.Scheduler.Weights)) {
+			weights[fmt.Sprintf("%s%d", maskedSecret, i+1)] = cfg.Scheduler.Weights[key]
+		}
+		cfg.Scheduler.Weights = weights
+	}
+	cfg.Shadow.Proxy = redactProxyURL(cfg.Shadow.Proxy)
+}
+
+// maskHeaders replaces every header value, since headers often carry keys
+func maskHeaders(headers map[string]string) {
+	for name := range headers {
+		headers[name] = maskedSecret
+	}
+}
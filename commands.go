@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"llm_proxy/config"
+	"llm_proxy/database"
+	"llm_proxy/handlers"
+)
+
+// command is a subcommand of the llm_proxy binary
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commands lists the subcommands in the order usage shows them. Running
+// llm_proxy without one, or with only flags, serves.
+var commands = []command{
+	{"serve", "Run the proxy server (default)", runServe},
+	{"export", "Write logged requests as JSON Lines or JSON", runExport},
+	{"search", "Full-text search the prompts and responses of logged requests", runSearch},
+	{"stats", "Summarise logged requests per model and endpoint", runStats},
+	{"cleanup", "Delete all but the newest logged requests", runCleanup},
+	{"check-config", "Validate a configuration and print it with the defaults applied", runCheckConfig},
+	{"print-default-config", "Print an annotated starter configuration", runPrintDefaultConfig},
+}
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(args)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+// usage lists the subcommands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: llm_proxy [command] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-21s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"llm_proxy <command> -h\" for the flags of a command.\n")
+}
+
+// openDatabase opens the request log database configured in cfg, able to
+// read content encrypted with its key
+func openDatabase(cfg *config.Config) (*database.DB, error) {
+	db, err := database.Open(cfg.Database.Path, database.Options{
+		JournalMode:  cfg.Database.JournalMode,
+		Synchronous:  cfg.Database.Synchronous,
+		BusyTimeout:  cfg.Database.BusyTimeout,
+		MaxOpenConns: cfg.Database.MaxOpenConns,
+		MaxIdleConns: cfg.Database.MaxIdleConns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Database.EncryptionKey != "" {
+		key, _ := base64.StdEncoding.DecodeString(cfg.Database.EncryptionKey) // Checked by config.Load
+		if err := db.SetEncryptionKey(key); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// logCommand holds the flags every command that works on the request log
+// takes
+type logCommand struct {
+	flags      *flag.FlagSet
+	configPath *string
+	dbPath     *string
+}
+
+// newLogCommand starts the flags of a request log command. argsUsage
+// describes its positional arguments, if any.
+func newLogCommand(name, argsUsage string) *logCommand {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: llm_proxy %s [flags]%s\n\nFlags:\n", name, argsUsage)
+		flags.PrintDefaults()
+	}
+	// Commands print results, not log lines
+	log.SetFlags(0)
+	return &logCommand{
+		flags:      flags,
+		configPath: flags.String("config", "config.toml", "Path to configuration file"),
+		dbPath:     flags.String("db", "", "Database to use instead of database.path from the configuration"),
+	}
+}
+
+// open loads the configuration and opens the existing request log
+// database. It exits on failure.
+func (c *logCommand) open() (*config.Config, *database.DB) {
+	cfg, err := config.Load(*c.configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *c.dbPath != "" {
+		cfg.Database.Path = *c.dbPath
+	}
+	if cfg.Database.Path == database.MemoryPath {
+		log.Fatalf("The database is in memory (%s); there is no request log to read", database.MemoryPath)
+	}
+	// Don't leave an empty database behind for a mistyped path
+	if _, err := os.Stat(cfg.Database.Path); err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	db, err := openDatabase(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	return cfg, db
+}
+
+// parseSince parses a command's -since or -until value: a duration before
+// now ("24h", "90m", or "7d" for days), a date or an RFC 3339 time
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (must be a duration such as 24h or 7d, a date or an RFC 3339 time)", value)
+}
+
+// exportPageSize is the number of entries export reads at a time
+const exportPageSize = 500
+
+// runExport writes logged requests, oldest first, in the form /api/logs
+// returns them
+func runExport(args []string) {
+	cmd := newLogCommand("export", "")
+	since := cmd.flags.String("since", "", "Only requests from this long ago (24h, 7d), date or RFC 3339 time on")
+	until := cmd.flags.String("until", "", "Only requests before this duration ago, date or RFC 3339 time")
+	model := cmd.flags.String("model", "", "Only requests for this model")
+	endpoint := cmd.flags.String("endpoint", "", "Only requests to this endpoint")
+	errorsOnly := cmd.flags.Bool("errors", false, "Only failed requests")
+	format := cmd.flags.String("format", "jsonl", "Output format: \"jsonl\" (one request per line) or \"json\" (an array)")
+	bodies := cmd.flags.Bool("bodies", true, "Include the prompt, response and raw request and response bodies")
+	limit := cmd.flags.Int("limit", 0, "Most requests to write (0 = all)")
+	output := cmd.flags.String("o", "", "File to write to (default standard output)")
+	cmd.flags.Parse(args)
+
+	if *format != "jsonl" && *format != "json" {
+		log.Fatalf("Invalid -format %q (must be \"jsonl\" or \"json\")", *format)
+	}
+	filter := database.LogFilter{Model: *model, Endpoint: *endpoint, ErrorsOnly: *errorsOnly, Order: "asc"}
+	now := time.Now()
+	if *since != "" {
+		t, err := parseSince(*since, now)
+		if err != nil {
+			log.Fatalf("Invalid -since: %v", err)
+		}
+		filter.Since = &t
+	}
+	if *until != "" {
+		t, err := parseSince(*until, now)
+		if err != nil {
+			log.Fatalf("Invalid -until: %v", err)
+		}
+		filter.Until = &t
+	}
+
+	_, db := cmd.open()
+	defer db.Close()
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	written, err := exportEntries(w, db, filter, *format, *bodies, *limit)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatalf("Export failed after %d request(s): %v", written, err)
+	}
+	if *output != "" {
+		log.Printf("Exported %d request(s) to %s", written, *output)
+	}
+}
+
+// exportEntries writes the entries matching filter to w a page at a time
+// and returns how many it wrote
+func exportEntries(w io.Writer, db *database.DB, filter database.LogFilter, format string, bodies bool, limit int) (int, error) {
+	enc := json.NewEncoder(w)
+	written := 0
+	if format == "json" {
+		if _, err := io.WriteString(w, "[\n"); err != nil {
+			return 0, err
+		}
+	}
+	for filter.Offset = 0; limit <= 0 || written < limit; filter.Offset += exportPageSize {
+		filter.Limit = exportPageSize
+		entries, err := db.GetEntries(filter)
+		if err != nil {
+			return written, err
+		}
+		for _, entry := range entries {
+			if limit > 0 && written == limit {
+				break
+			}
+			if format == "json" && written > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return written, err
+				}
+			}
+			if err := enc.Encode(handlers.LogEntryJSON(entry, bodies)); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if len(entries) < exportPageSize {
+			break
+		}
+	}
+	if format == "json" {
+		if _, err := io.WriteString(w, "]\n"); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// runSearch prints the logged requests whose prompt, response or last
+// message match a full-text query, newest first
+func runSearch(args []string) {
+	cmd := newLogCommand("search", " <query>")
+	limit := cmd.flags.Int("limit", 20, "Most requests to show")
+	asJSON := cmd.flags.Bool("json", false, "Print matching requests as JSON Lines, with their bodies")
+	cmd.flags.Parse(args)
+
+	query := strings.Join(cmd.flags.Args(), " ")
+	if strings.TrimSpace(query) == "" {
+		cmd.flags.Usage()
+		os.Exit(2)
+	}
+
+	_, db := cmd.open()
+	defer db.Close()
+
+	entries, err := db.SearchEntries(query, *limit, 0)
+	if err != nil {
+		log.Fatalf("Search failed: %v", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			if err := enc.Encode(handlers.LogEntryJSON(entry, true)); err != nil {
+				log.Fatalf("Failed to write results: %v", err)
+			}
+		}
+		return
+	}
+	total, err := db.CountSearchResults(query)
+	if err != nil {
+		log.Fatalf("Search failed: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tMODEL\tENDPOINT\tSTATUS\tLAST MESSAGE")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\n", entry.ID, entry.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			entry.Model, entry.Endpoint, entry.StatusCode, oneLine(entry.LastMessage, 60))
+	}
+	tw.Flush()
+	fmt.Printf("\n%d of %d matching request(s)\n", len(entries), total)
+}
+
+// oneLine returns text on a single line, cut to at most limit characters
+func oneLine(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > limit {
+		return string(runes[:limit-3]) + "..."
+	}
+	return text
+}
+
+// runStats prints request, error, latency, token and cost totals overall
+// and per model and endpoint
+func runStats(args []string) {
+	cmd := newLogCommand("stats", "")
+	since := cmd.flags.String("since", "24h", "Summarise requests from this long ago (24h, 7d), date or RFC 3339 time on")
+	cmd.flags.Parse(args)
+
+	now := time.Now()
+	start, err := parseSince(*since, now)
+	if err != nil {
+		log.Fatalf("Invalid -since: %v", err)
+	}
+
+	_, db := cmd.open()
+	defer db.Close()
+
+	stats, err := db.GetRequestStats(start, now, now.Sub(start)+time.Second, "")
+	if err != nil {
+		log.Fatalf("Failed to get stats: %v", err)
+	}
+
+	fmt.Printf("Requests since %s\n\n", start.Format("2006-01-02 15:04:05"))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	totals := stats.Totals
+	totals.Name = "all"
+	printStatsTable(tw, "TOTAL", []database.GroupStats{totals})
+	printStatsTable(tw, "MODEL", stats.Models)
+	printStatsTable(tw, "ENDPOINT", stats.Endpoints)
+	tw.Flush()
+}
+
+// printStatsTable writes a heading and a row per group to tw, or nothing if
+// there are no groups
+func printStatsTable(tw *tabwriter.Writer, heading string, groups []database.GroupStats) {
+	if len(groups) == 0 {
+		return
+	}
+	fmt.Fprintf(tw, "%s\tREQUESTS\tERRORS\tERROR %%\tAVG MS\tP95 MS\tPROMPT TOK\tCOMPL TOK\tCOST\t\n", heading)
+	for _, g := range groups {
+		name := g.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%d\t%d\t%d\t%d\t%.4f\t\n", name, g.Requests, g.Errors, g.ErrorRate(),
+			g.AvgLatencyMs, g.P95LatencyMs, g.PromptTokens, g.CompletionTokens, g.Cost)
+	}
+	fmt.Fprintln(tw, "\t\t\t\t\t\t\t\t\t")
+}
+
+// runCleanup deletes all but the newest logged requests, like the cleanup
+// task the server runs
+func runCleanup(args []string) {
+	cmd := newLogCommand("cleanup", "")
+	maxRequests := cmd.flags.Int("max", 0, "Requests to keep (default database.max_requests from the configuration)")
+	cmd.flags.Parse(args)
+
+	cfg, db := cmd.open()
+	defer db.Close()
+
+	keep := cfg.Database.MaxRequests
+	if *maxRequests > 0 {
+		keep = *maxRequests
+	}
+	if keep <= 0 {
+		log.Fatalf("Nothing to clean up: database.max_requests is 0 (unlimited); pass -max to set how many requests to keep")
+	}
+	deleted, err := db.CleanupOldRequests(keep)
+	if err != nil {
+		log.Fatalf("Cleanup failed: %v", err)
+	}
+	fmt.Printf("Deleted %d request(s), keeping the newest %d\n", deleted, keep)
+	if err := db.Audit(database.AuditEntry{Actor: cliActor(), Action: "cleanup", Detail: fmt.Sprintf("%d request(s), keeping the newest %d", deleted, keep)}); err != nil {
+		log.Printf("Failed to record cleanup in the audit log: %v", err)
+	}
+}
+
+// cliActor names who ran a command for the audit log
+func cliActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "cli (" + u.Username + ")"
+	}
+	return "cli"
+}
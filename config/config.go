@@ -1,7 +1,17 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -11,11 +21,49 @@ type Config struct {
 	Server              ServerConfig              `toml:"server"`
 	Backend             BackendConfig             `toml:"backend"`
 	BackendOpenAI       BackendOpenAIConfig       `toml:"backend_openai"`
+	BackendOpenRouter   BackendOpenRouterConfig   `toml:"backend_openrouter"`
+	BackendGemini       BackendGeminiConfig       `toml:"backend_gemini"`
+	BackendKoboldCpp    BackendKoboldCppConfig    `toml:"backend_koboldcpp"`
+	BackendMock         BackendMockConfig         `toml:"backend_mock"`
 	Database            DatabaseConfig            `toml:"database"`
 	RequestSanitization RequestSanitizationConfig `toml:"request_sanitization"`
+	TokenBudget         TokenBudgetConfig         `toml:"token_budget"`
+	ContextTrim         ContextTrimConfig         `toml:"context_trim"`
+	Tools               ToolsConfig               `toml:"tools"`
+	ToolCallRepair      ToolCallRepairConfig      `toml:"tool_call_repair"`
 	ChatTextInjection   ChatTextInjectionConfig   `toml:"chat_text_injection"`
 	StreamOverride      StreamOverrideConfig      `toml:"stream_override"`
+	StreamCoalesce      StreamCoalesceConfig      `toml:"stream_coalesce"`
 	Gemma4Fix           Gemma4FixConfig           `toml:"gemma_4_fix"`
+	Scheduler           SchedulerConfig           `toml:"scheduler"`
+	Federation          FederationConfig          `toml:"federation"`
+	EmbeddingCache      EmbeddingCacheConfig      `toml:"embedding_cache"`
+	Failover            FailoverConfig            `toml:"failover"`
+	Routes              []RouteConfig             `toml:"routes"`
+	BackendOverride     BackendOverrideConfig     `toml:"backend_override"`
+	ModelAggregation    ModelAggregationConfig    `toml:"model_aggregation"`
+	LoadBalance         LoadBalanceConfig         `toml:"load_balance"`
+	Race                RaceConfig                `toml:"race"`
+	Tenants             []TenantConfig            `toml:"tenants"`
+	Users               UsersConfig               `toml:"users"`
+	ModelAccess         ModelAccessConfig         `toml:"model_access"`
+	Shadow              ShadowConfig              `toml:"shadow"`
+	Retry               RetryConfig               `toml:"retry"`
+	RateLimit           RateLimitConfig           `toml:"rate_limit"`
+	TokenCounting       TokenCountingConfig       `toml:"token_counting"`
+	ResponseCache       ResponseCacheConfig       `toml:"response_cache"`
+	ModelCache          ModelCacheConfig          `toml:"model_cache"`
+	ModelAliases        map[string]string         `toml:"model_aliases"` // Client model name -> backend model name
+	ContentFilters      []ContentFilterConfig     `toml:"content_filters"`
+	ResponseTransforms  []ResponseTransformConfig `toml:"response_transforms"`
+	Hooks               []HookConfig              `toml:"hooks"`
+	PIIRedaction        PIIRedactionConfig        `toml:"pii_redaction"`
+	Pricing             map[string]PriceConfig    `toml:"pricing"` // Model name ("*" = any other model) -> token prices
+	Notifications       NotificationsConfig       `toml:"notifications"`
+	Warmup              WarmupConfig              `toml:"warmup"`
+
+	// live holds the latest reloaded configuration (see Current)
+	live *atomic.Pointer[Config]
 }
 
 // ServerConfig holds the server settings
@@ -23,18 +71,103 @@ type ServerConfig struct {
 	Host            string `toml:"host"`
 	Port            int    `toml:"port"`
 	EnableCORS      bool   `toml:"enable_cors"`
+	GzipResponses   bool   `toml:"gzip_responses"` // Compress non-streamed responses for clients that accept gzip
 	LogMessages     bool   `toml:"log_messages"`
 	LogRawRequests  bool   `toml:"log_raw_requests"`
 	LogRawResponses bool   `toml:"log_raw_responses"`
 	Verbose         bool   `toml:"verbose"`
+	OllamaVersion   string `toml:"ollama_version"`  // Reported by /api/version; empty = ask an Ollama backend, else a built-in default
+	TLSCert         string `toml:"tls_cert"`        // PEM certificate file; serves HTTPS when set with tls_key
+	TLSKey          string `toml:"tls_key"`         // PEM private key file
+	TLSSelfSigned   bool   `toml:"tls_self_signed"` // Serve HTTPS with a generated certificate (for development)
+
+	// ShutdownTimeout is how long, in seconds, a shutdown waits for
+	// in-flight requests and streams to finish (-1 = stop immediately)
+	ShutdownTimeout int `toml:"shutdown_timeout"`
+
+	// KeepAliveInterval is how long, in seconds, a streaming response may
+	// wait for the backend before a keep-alive is sent to the client (0 =
+	// never)
+	KeepAliveInterval int `toml:"keep_alive_interval"`
+
+	// FlushMode is when streamed responses are flushed to the client:
+	// "chunk" after every chunk, or "timed" at most every FlushIntervalMs
+	// milliseconds, sending the chunks written in between together
+	FlushMode       string `toml:"flush_mode"`
+	FlushIntervalMs int    `toml:"flush_interval_ms"`
+
+	// Nagle batches small writes to clients with Nagle's algorithm instead
+	// of setting TCP_NODELAY on their connections
+	Nagle bool `toml:"nagle"`
+
+	// ConfigWatchInterval is how often, in seconds, the config file is
+	// checked for changes to reload (-1 = only reload on SIGHUP)
+	ConfigWatchInterval int `toml:"config_watch_interval"`
+
+	// MaxRequestBodySize is the largest request body accepted, in MiB
+	// (-1 = no limit)
+	MaxRequestBodySize int `toml:"max_request_body_size"`
+
+	// CORS restricts which browser origins may call the proxy when
+	// EnableCORS is set
+	CORS CORSConfig `toml:"cors"`
+
+	// Access restricts which client addresses may use the proxy
+	Access AccessConfig `toml:"access"`
+
+	// AdminHost and AdminPort give the web UI and admin APIs a listener of
+	// their own (AdminPort 0 = serve them on the API port)
+	AdminHost string `toml:"admin_host"`
+	AdminPort int    `toml:"admin_port"`
+
+	// AdminAPIKeys identify administrators to the web UI and admin APIs
+	// when they share the API port with tenants
+	AdminAPIKeys []string `toml:"admin_api_keys"`
+}
+
+// AccessConfig holds the client IP allow and deny lists. Each entry is a CIDR
+// range ("192.168.1.0/24") or a single address.
+type AccessConfig struct {
+	Allow     []string `toml:"allow"`      // Only these clients may connect (empty = any not denied)
+	Deny      []string `toml:"deny"`       // These clients are always refused
+	LogDenied bool     `toml:"log_denied"` // Record refused requests in the database
+}
+
+// CORSConfig holds the cross-origin settings sent to browsers
+type CORSConfig struct {
+	AllowedOrigins   []string `toml:"allowed_origins"`   // Origins such as "https://app.example.com"; "*" allows any, "https://*.example.com" any subdomain
+	AllowedMethods   []string `toml:"allowed_methods"`   // Methods allowed in cross-origin requests
+	AllowedHeaders   []string `toml:"allowed_headers"`   // Request headers allowed in cross-origin requests
+	AllowCredentials bool     `toml:"allow_credentials"` // Let browsers send cookies and HTTP auth
+	MaxAge           int      `toml:"max_age"`           // Seconds browsers may cache a preflight response (-1 = don't say)
 }
 
 // BackendConfig holds the backend service settings
 type BackendConfig struct {
-	Type          string   `toml:"type"` // "openai" or "ollama"
-	Endpoint      string   `toml:"endpoint"`
-	Timeout       int      `toml:"timeout"`        // in seconds
-	ToolBlacklist []string `toml:"tool_blacklist"` // List of tool names to filter out
+	Type          string            `toml:"type"` // "openai", "vllm", "textgen", "openrouter", "ollama", "koboldcpp", "gemini" or "mock"
+	Endpoint      string            `toml:"endpoint"`
+	Timeout       int               `toml:"timeout"`        // Cap on a whole request, in seconds (0 = none)
+	ToolBlacklist []string          `toml:"tool_blacklist"` // List of tool names to filter out
+	Headers       map[string]string `toml:"headers"`        // Extra HTTP headers sent with every backend request
+	Proxy         string            `toml:"proxy"`          // Outbound proxy URL (http, https, socks5) or "direct"; empty = HTTP_PROXY/HTTPS_PROXY env
+	MaxLineSize   int               `toml:"max_line_size"`  // Longest line read from a streamed response, in KiB
+	TLS           BackendTLSConfig  `toml:"tls"`
+
+	// Timeouts in seconds for the stages of a backend request (-1 = none):
+	// connecting, waiting for the response headers (which covers model
+	// loading and, for non-streaming requests, the whole generation) and the
+	// longest gap between chunks of a streamed response
+	ConnectTimeout        int `toml:"connect_timeout"`
+	ResponseHeaderTimeout int `toml:"response_header_timeout"`
+	StreamIdleTimeout     int `toml:"stream_idle_timeout"`
+}
+
+// BackendTLSConfig holds TLS settings for connections to a backend
+type BackendTLSConfig struct {
+	CACert             string `toml:"ca_cert"`              // PEM CA bundle trusted in addition to the system roots
+	ClientCert         string `toml:"client_cert"`          // PEM client certificate for mTLS
+	ClientKey          string `toml:"client_key"`           // PEM private key for client_cert
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"` // Don't verify the backend's certificate
 }
 
 // DatabaseConfig holds the database settings
@@ -42,11 +175,116 @@ type DatabaseConfig struct {
 	Path            string `toml:"path"`
 	MaxRequests     int    `toml:"max_requests"`     // Maximum number of requests to keep (0 = unlimited)
 	CleanupInterval int    `toml:"cleanup_interval"` // Cleanup interval in minutes (0 = disabled)
+	WriteQueueSize  int    `toml:"write_queue_size"` // Log entries queued for the background writer (-1 = write synchronously)
+	WriteBatchSize  int    `toml:"write_batch_size"` // Maximum log entries written per transaction
+	JournalMode     string `toml:"journal_mode"`     // SQLite journal mode: "wal", "delete", "truncate", "persist", "memory" or "off"
+	Synchronous     string `toml:"synchronous"`      // SQLite synchronous setting: "off", "normal", "full" or "extra"
+	BusyTimeout     int    `toml:"busy_timeout"`     // Milliseconds to wait for a locked database
+	MaxOpenConns    int    `toml:"max_open_conns"`   // Maximum open database connections
+	MaxIdleConns    int    `toml:"max_idle_conns"`   // Maximum idle database connections
+
+	// MaxPayloadSize truncates each stored raw request and response body
+	// to this many KB (0 = no limit); PayloadSpillDir, if set, keeps the
+	// full bodies that were truncated as files
+	MaxPayloadSize  int    `toml:"max_payload_size"`
+	PayloadSpillDir string `toml:"payload_spill_dir"`
+
+	CompressPayloads bool `toml:"compress_payloads"` // Store raw request and response bodies gzip-compressed
+
+	// EncryptionKey encrypts the logged prompts, responses and raw bodies
+	// with AES-GCM (base64 of a 16, 24 or 32 byte key; "" = plain text). It
+	// can be read from EncryptionKeyFile or EncryptionKeyEnv instead.
+	EncryptionKey     string `toml:"encryption_key"`
+	EncryptionKeyFile string `toml:"encryption_key_file"`
+	EncryptionKeyEnv  string `toml:"encryption_key_env"`
 }
 
 // BackendOpenAIConfig holds OpenAI-specific backend settings
 type BackendOpenAIConfig struct {
-	ForcePromptCache bool `toml:"force_prompt_cache"` // Force prompt caching on all requests
+	ForcePromptCache bool   `toml:"force_prompt_cache"` // Force prompt caching on all requests
+	APIKey           string `toml:"api_key"`            // Sent as "Authorization: Bearer <key>"
+	APIKeyFile       string `toml:"api_key_file"`       // File containing the API key
+	APIKeyEnv        string `toml:"api_key_env"`        // Environment variable containing the API key
+
+	// PassUnknownOptions sends Ollama options with no OpenAI equivalent
+	// (e.g. num_ctx, mirostat) as extra top-level request fields
+	PassUnknownOptions bool `toml:"pass_unknown_options"`
+
+	// Models describes models to clients in /api/tags and /api/show, which
+	// the OpenAI API reports little about
+	Models map[string]OpenAIModelConfig `toml:"models"` // Model name ("*" = any other model) -> description
+
+	// StaticModels lists only the models described in Models, without
+	// asking the backend's /v1/models
+	StaticModels bool `toml:"static_models"`
+}
+
+// OpenAIModelConfig describes a model of an OpenAI-compatible backend the
+// way Ollama describes its models
+type OpenAIModelConfig struct {
+	ContextLength     int      `toml:"context_length"`     // Tokens (0 = as reported by /v1/models)
+	Capabilities      []string `toml:"capabilities"`       // "completion", "tools", "insert", "vision", "embedding" or "thinking" (default ["completion"])
+	Family            string   `toml:"family"`             // e.g. "qwen3"; also the model_info architecture
+	ParameterSize     string   `toml:"parameter_size"`     // e.g. "30B"
+	QuantizationLevel string   `toml:"quantization_level"` // e.g. "Q4_K_M"
+	Parameters        string   `toml:"parameters"`         // Modelfile parameters, one "name value" per line (default "num_ctx <context_length>")
+}
+
+// BackendOpenRouterConfig holds OpenRouter-specific backend settings. Other
+// settings of OpenAI-compatible backends are taken from [backend_openai].
+type BackendOpenRouterConfig struct {
+	APIKey   string                 `toml:"api_key"`  // Sent as "Authorization: Bearer <key>"
+	Referer  string                 `toml:"referer"`  // Sent as HTTP-Referer, the app's URL for OpenRouter's rankings
+	Title    string                 `toml:"title"`    // Sent as X-Title, the app's name for OpenRouter's rankings
+	Provider map[string]interface{} `toml:"provider"` // Provider routing preferences for requests that set none, e.g. order, allow_fallbacks
+	Route    string                 `toml:"route"`    // Model routing for requests that set none, e.g. "fallback"
+}
+
+// BackendGeminiConfig holds Gemini-specific backend settings
+type BackendGeminiConfig struct {
+	APIKey         string                      `toml:"api_key"`
+	SafetySettings []GeminiSafetySettingConfig `toml:"safety_settings"` // Sent with every request unless the client supplies options.safety_settings
+}
+
+// BackendKoboldCppConfig holds settings for KoboldCpp's native API
+type BackendKoboldCppConfig struct {
+	ChatTemplate string `toml:"chat_template"` // How chat messages are written into the prompt: "chatml", "llama3", "mistral" or "alpaca"
+	MaxLength    int    `toml:"max_length"`    // Tokens generated for requests that don't set num_predict
+}
+
+// GeminiSafetySettingConfig is one Gemini safety filter override
+type GeminiSafetySettingConfig struct {
+	Category  string `toml:"category"`  // e.g. "HARM_CATEGORY_HARASSMENT"
+	Threshold string `toml:"threshold"` // e.g. "BLOCK_ONLY_HIGH"
+}
+
+// BackendMockConfig holds settings for the mock backend, which answers
+// without a model
+type BackendMockConfig struct {
+	Reply           string   `toml:"reply"`             // Canned reply ("" = echo the last user message or prompt)
+	Models          []string `toml:"models"`            // Models listed (default ["mock"])
+	DelayMs         int      `toml:"delay_ms"`          // Milliseconds to wait before answering
+	TokensPerSecond float64  `toml:"tokens_per_second"` // Rate replies are streamed at (0 = all at once)
+	ErrorRate       float64  `toml:"error_rate"`        // Fraction of requests, 0 to 1, failed with error_status
+	ErrorStatus     int      `toml:"error_status"`      // HTTP status of injected errors
+
+	Scenarios []MockScenarioConfig `toml:"scenarios"` // Scripted answers; the first that matches is used
+}
+
+// MockScenarioConfig is a scripted answer of the mock backend
+type MockScenarioConfig struct {
+	Match     string               `toml:"match"`      // Substring of the last user message or prompt ("" = any)
+	Reply     string               `toml:"reply"`      // "" = the default reply
+	ToolCalls []MockToolCallConfig `toml:"tool_calls"` // Returned after the reply to chat requests
+	Status    int                  `toml:"status"`     // Fail with this HTTP status instead (0 = don't)
+	Error     string               `toml:"error"`      // Error message of that failure
+	FailAfter int                  `toml:"fail_after"` // Break the stream off after this many tokens (0 = don't)
+}
+
+// MockToolCallConfig is a tool call returned by a mock scenario
+type MockToolCallConfig struct {
+	Name      string                 `toml:"name"`
+	Arguments map[string]interface{} `toml:"arguments"`
 }
 
 // RequestSanitizationConfig holds settings for removing problematic incoming request parameters.
@@ -55,11 +293,68 @@ type RequestSanitizationConfig struct {
 	MaxTokensLimit  int    `toml:"max_tokens_limit"`  // Used when max_tokens_policy is "drop_above"
 }
 
+// TokenBudgetConfig caps how much a single request may send and ask for
+type TokenBudgetConfig struct {
+	MaxTokens       int    `toml:"max_tokens"`        // Largest max_tokens / num_predict; larger or missing values are lowered to this (0 = no cap)
+	MaxMessageChars int    `toml:"max_message_chars"` // Largest total characters in a request's messages or prompt (0 = no limit)
+	Action          string `toml:"action"`            // For messages over max_message_chars: "reject" or "truncate"
+	Strategy        string `toml:"strategy"`          // How "truncate" drops messages: "drop_oldest" or "keep_first"
+}
+
+// ContextTrimConfig holds settings for trimming old chat messages that
+// don't fit in a model's context window
+type ContextTrimConfig struct {
+	Enabled       bool           `toml:"enabled"`
+	ContextSizes  map[string]int `toml:"context_sizes"`  // Model name ("*" = any other model) -> context window in tokens
+	ReserveTokens int            `toml:"reserve_tokens"` // Tokens left for the response when the request doesn't set a max
+	Mode          string         `toml:"mode"`           // "drop" or "summarize" the trimmed messages
+	Strategy      string         `toml:"strategy"`       // "drop_oldest" or "keep_first" (also keep the first non-system message)
+	SummaryTokens int            `toml:"summary_tokens"` // Longest summary in "summarize" mode
+}
+
+// ToolsConfig controls which tools in chat requests are forwarded to the
+// backend. backend.tool_blacklist is applied as well, whatever the mode.
+type ToolsConfig struct {
+	Mode   string                      `toml:"mode"`   // "blacklist", "whitelist", or "strip" (remove all tools)
+	Tools  []string                    `toml:"tools"`  // Tool names the mode removes ("blacklist") or keeps ("whitelist")
+	Models map[string]ToolPolicyConfig `toml:"models"` // Client model name -> policy used instead of mode and tools
+
+	Rewrites []ToolRewriteConfig `toml:"rewrites"` // Description changes applied, in order, to the tools left
+}
+
+// ToolRewriteConfig changes the descriptions of a tool before it is
+// forwarded, e.g. to add usage hints a model needs
+type ToolRewriteConfig struct {
+	Name              string            `toml:"name"`               // Tool function name ("*" = every tool)
+	Description       string            `toml:"description"`        // Replaces the tool's description
+	AppendDescription string            `toml:"append_description"` // Added to the end of the description
+	Parameters        map[string]string `toml:"parameters"`         // Parameter name -> description that replaces its own
+	AppendParameters  map[string]string `toml:"append_parameters"`  // Parameter name -> text added to its description
+}
+
+// ToolPolicyConfig is the tool policy for one model
+type ToolPolicyConfig struct {
+	Mode  string   `toml:"mode"`  // As tools.mode; defaults to tools.mode
+	Tools []string `toml:"tools"` // As tools.tools
+}
+
+// ToolCallRepairConfig controls turning tool calls that models write as
+// plain text into structured tool calls
+type ToolCallRepairConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Models  []string `toml:"models"` // Backend model names to repair (empty = all)
+}
+
 // ChatTextInjectionConfig holds the chat text injection settings
 type ChatTextInjectionConfig struct {
 	Enabled bool   `toml:"enabled"` // Enable text injection
 	Text    string `toml:"text"`    // Text to inject
 	Mode    string `toml:"mode"`    // "first", "last", or "system" - which message to inject into
+
+	// SystemPrompt is a system message template applied before the text
+	// injection. Text and SystemPrompt may both use {date}, {time} and {model}.
+	SystemPrompt     string `toml:"system_prompt"`
+	SystemPromptMode string `toml:"system_prompt_mode"` // "prepend", "append", "replace", or "default" (only when the client sent none)
 }
 
 // StreamOverrideConfig holds settings for forcing the streaming behavior of
@@ -68,6 +363,14 @@ type StreamOverrideConfig struct {
 	Mode string `toml:"mode"` // "passthrough", "always", or "never"
 }
 
+// StreamCoalesceConfig controls merging the tiny deltas of streamed responses
+// into fewer, larger chunks
+type StreamCoalesceConfig struct {
+	Enabled         bool `toml:"enabled"`
+	MinChars        int  `toml:"min_chars"`         // Send once this many characters are waiting (-1 = only on the interval)
+	FlushIntervalMs int  `toml:"flush_interval_ms"` // Send what is waiting this long after its first delta arrived (-1 = only on size)
+}
+
 // Gemma4FixConfig controls the self-contained mitigation for known Gemma 4 +
 // vLLM streaming corruption bugs (leaked tool-call control tokens and leaked
 // reasoning-channel tokens in the content field). Only relevant when
@@ -76,6 +379,280 @@ type Gemma4FixConfig struct {
 	Enabled bool `toml:"enabled"`
 }
 
+// SchedulerConfig limits concurrent backend requests and controls how queued
+// requests are shared between client API keys.
+type SchedulerConfig struct {
+	MaxConcurrentRequests int            `toml:"max_concurrent_requests"` // 0 = unlimited (scheduler disabled)
+	DefaultWeight         int            `toml:"default_weight"`          // Weight for keys not listed in weights
+	Weights               map[string]int `toml:"weights"`                 // API key -> relative share of queued slots
+	MaxQueueDepth         int            `toml:"max_queue_depth"`         // Requests allowed to wait (0 = unlimited)
+	QueueTimeout          int            `toml:"queue_timeout"`           // Longest wait in seconds for a slot before a 503 is returned (0 = no limit)
+}
+
+// FederationConfig lists additional read-only log sources that are merged
+// into the web UI's logs index.
+type FederationConfig struct {
+	Sources []FederationSourceConfig `toml:"sources"`
+}
+
+// FederationSourceConfig describes one read-only log source: either another
+// proxy's SQLite file or another proxy's /api/logs endpoint.
+type FederationSourceConfig struct {
+	Name    string `toml:"name"`    // Label shown in the logs index
+	Type    string `toml:"type"`    // "sqlite" or "remote"
+	Path    string `toml:"path"`    // SQLite file path (type = "sqlite")
+	URL     string `toml:"url"`     // Base URL of the other proxy (type = "remote")
+	Timeout int    `toml:"timeout"` // Remote request timeout in seconds
+}
+
+// EmbeddingCacheConfig controls caching of embedding vectors in the database
+type EmbeddingCacheConfig struct {
+	Enabled    bool `toml:"enabled"`
+	MaxEntries int  `toml:"max_entries"` // Least recently used vectors beyond this are evicted
+}
+
+// FailoverConfig lists fallback backends that are tried in order when the
+// primary backend is unreachable or returns a 5xx error.
+type FailoverConfig struct {
+	HealthCheckInterval int                     `toml:"health_check_interval"` // Seconds between health probes (-1 = disabled)
+	Backends            []FailoverBackendConfig `toml:"backends"`
+}
+
+// FailoverBackendConfig describes one fallback backend. Type-specific
+// settings (api keys, safety settings) are shared with the primary backend.
+type FailoverBackendConfig struct {
+	Name     string            `toml:"name"` // Used by routes to pick this backend
+	Type     string            `toml:"type"` // "openai", "vllm", "textgen", "openrouter", "ollama", "koboldcpp", "gemini" or "mock"
+	Endpoint string            `toml:"endpoint"`
+	Timeout  int               `toml:"timeout"` // in seconds
+	Headers  map[string]string `toml:"headers"` // Extra HTTP headers; replaces backend.headers for this fallback
+	Proxy    string            `toml:"proxy"`   // Outbound proxy; defaults to backend.proxy
+	TLS      BackendTLSConfig  `toml:"tls"`     // Replaces backend.tls for this fallback when any field is set
+}
+
+// RouteConfig is a routing rule. The first rule whose conditions (Go
+// regular expressions; empty ones match anything) all match a request picks
+// its backend, model or rejection.
+type RouteConfig struct {
+	Name    string            `toml:"name"`    // Shown in the log
+	Model   string            `toml:"model"`   // Matched against the requested model
+	Path    string            `toml:"path"`    // Matched against the request path
+	Headers map[string]string `toml:"headers"` // Header name -> pattern its value must match
+	Content string            `toml:"content"` // Matched against the last user message, prompt or embedding input
+
+	Backend       string `toml:"backend"`        // "primary" or the name of a failover backend ("" = usual failover chain)
+	SetModel      string `toml:"set_model"`      // Model to request instead
+	Reject        int    `toml:"reject"`         // HTTP status to reject the request with
+	RejectMessage string `toml:"reject_message"` // Error message of the rejection
+}
+
+// RetryConfig controls retries of transient backend failures with
+// exponential backoff.
+type RetryConfig struct {
+	MaxAttempts      int   `toml:"max_attempts"`       // Total attempts per request including the first (1 = no retries)
+	InitialBackoffMs int   `toml:"initial_backoff_ms"` // Wait before the first retry; doubles for each further retry
+	MaxBackoffMs     int   `toml:"max_backoff_ms"`     // Upper bound for the wait between attempts
+	RetryOnStatus    []int `toml:"retry_on_status"`    // Backend status codes to retry; connection errors are always retried
+}
+
+// RateLimitConfig controls per-backend request queueing: honoring 429
+// Retry-After responses and capping concurrent requests to each backend.
+type RateLimitConfig struct {
+	Enabled       bool `toml:"enabled"`
+	MaxConcurrent int  `toml:"max_concurrent"`  // Requests in flight per backend (0 = unlimited)
+	MaxQueueDepth int  `toml:"max_queue_depth"` // Requests allowed to wait per backend (0 = unlimited)
+	MaxRetryAfter int  `toml:"max_retry_after"` // Longest total Retry-After wait in seconds before the 429 is returned
+	QueueTimeout  int  `toml:"queue_timeout"`   // Longest wait in seconds for a slot before a 503 is returned (0 = no limit)
+}
+
+// TokenCountingConfig controls how token counts are computed for OpenAI
+// backends that don't report usage.
+type TokenCountingConfig struct {
+	Method      string            `toml:"method"`       // "estimate", "tiktoken", "tiktoken:<encoding>", "tokenize" (llama.cpp /tokenize) or "off"
+	Models      map[string]string `toml:"models"`       // Per-model method overrides
+	TiktokenDir string            `toml:"tiktoken_dir"` // Directory holding <encoding>.tiktoken files ("" = download them)
+}
+
+// ResponseCacheConfig controls caching of non-streaming generate and chat
+// responses.
+type ResponseCacheConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	Storage    string `toml:"storage"`     // "memory" or "sqlite" (the log database)
+	TTL        int    `toml:"ttl"`         // Seconds a cached response is served for
+	MaxEntries int    `toml:"max_entries"` // Least recently used responses beyond this are evicted
+}
+
+// ModelCacheConfig controls caching of model lists (/api/tags, /v1/models)
+// and model descriptions (/api/show), which some clients poll every few
+// seconds
+type ModelCacheConfig struct {
+	Enabled bool `toml:"enabled"`
+	TTL     int  `toml:"ttl"` // Seconds a cached list or description is served for
+}
+
+// ContentFilterConfig is one regex replace rule applied to prompts and/or
+// generated text
+type ContentFilterConfig struct {
+	Name      string `toml:"name"`      // Shown in the log when the rule fires
+	Pattern   string `toml:"pattern"`   // Go regular expression
+	Replace   string `toml:"replace"`   // Replacement text; $1 or ${name} insert capture groups
+	Direction string `toml:"direction"` // "request", "response", or "both"
+}
+
+// ResponseTransformConfig is one step of the pipeline that rewrites
+// generated text before it is sent to the client. Steps run in order.
+type ResponseTransformConfig struct {
+	Type    string   `toml:"type"`    // "strip_think", "relocate_think", "strip_ansi", "regex", or "profanity"
+	Pattern string   `toml:"pattern"` // "regex": Go regular expression, matched within a line
+	Replace string   `toml:"replace"` // "regex": replacement text; "profanity": replaces each word (default: asterisks)
+	Words   []string `toml:"words"`   // "profanity": words to mask (default: a built-in list)
+}
+
+// HookConfig is an external program run on generate and chat requests
+// and/or responses, which can rewrite or reject them
+type HookConfig struct {
+	Name    string   `toml:"name"`     // Shown in the log
+	Command []string `toml:"command"`  // Program and its arguments
+	Stage   string   `toml:"stage"`    // "request", "response", or "both"
+	Timeout int      `toml:"timeout"`  // Seconds a run may take (-1 = no limit)
+	OnError string   `toml:"on_error"` // "fail" the request or "ignore" the hook when it fails
+}
+
+// PIIRedactionConfig controls masking of personal data in the request log.
+// Requests are still forwarded to the backend unmodified.
+type PIIRedactionConfig struct {
+	Enabled  bool               `toml:"enabled"`
+	Types    []string           `toml:"types"`    // Built-in detectors: "email", "credit_card", "phone" (default: all)
+	Patterns []PIIPatternConfig `toml:"patterns"` // Extra patterns to mask
+}
+
+// PIIPatternConfig is a custom regex masked as "[REDACTED:<name>]"
+type PIIPatternConfig struct {
+	Name    string `toml:"name"`
+	Pattern string `toml:"pattern"`
+}
+
+// PriceConfig is a model's price per million tokens, used to estimate the
+// cost of each logged request
+type PriceConfig struct {
+	Prompt       float64  `toml:"prompt"`
+	CachedPrompt *float64 `toml:"cached_prompt"` // Prompt tokens served from the backend's prompt cache (default: prompt)
+	Completion   float64  `toml:"completion"`
+}
+
+// NotificationsConfig holds the settings for alerts posted to a webhook
+type NotificationsConfig struct {
+	WebhookURL       string  `toml:"webhook_url"`        // Slack-compatible incoming webhook URL (empty = no notifications)
+	PublicURL        string  `toml:"public_url"`         // Base URL of the web UI for links to request details (default: the web UI listener)
+	OnBackendFailure bool    `toml:"on_backend_failure"` // Notify for every request that fails with a 5xx status
+	ErrorRate        float64 `toml:"error_rate"`         // Notify when this percentage of the requests in the window fail (0 = off)
+	LatencyMs        int     `toml:"latency_ms"`         // Notify when the average latency in the window goes over this (0 = off)
+	Cost             float64 `toml:"cost"`               // Notify when the estimated cost of the requests in the window goes over this (0 = off)
+	Window           int     `toml:"window"`             // Seconds of recent requests the thresholds are measured over
+	MinRequests      int     `toml:"min_requests"`       // Requests needed in the window before error_rate and latency_ms are checked
+	Timeout          int     `toml:"timeout"`            // Seconds to wait for the webhook
+}
+
+// WarmupConfig lists models loaded on the backend when the proxy starts, so
+// that the first real request doesn't wait for the model to load
+type WarmupConfig struct {
+	Models    []string `toml:"models"`     // Client model names (aliases are resolved); empty = no warm-up
+	KeepAlive string   `toml:"keep_alive"` // Ollama keep_alive sent with the warm-up request: a duration ("30m") or seconds (-1 = forever); "" = backend default
+	Timeout   int      `toml:"timeout"`    // Seconds to wait for each model to load
+}
+
+// BackendOverrideConfig lets clients send a request to a specific backend by
+// naming it in a header. It takes precedence over the backend routes pick,
+// but not over their rejections.
+type BackendOverrideConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	Header   string   `toml:"header"`   // Header naming the backend: "primary" or the name of a failover backend
+	Backends []string `toml:"backends"` // Backends that can be picked (empty = all)
+}
+
+// ModelAggregationConfig merges the model lists of several backends into one,
+// so clients see every model they can use through the proxy. Requests for a
+// listed model go to the backend that listed it.
+type ModelAggregationConfig struct {
+	Enabled    bool              `toml:"enabled"`
+	Backends   []string          `toml:"backends"`    // "primary" or names of failover backends, in order (default: all)
+	Prefixes   map[string]string `toml:"prefixes"`    // Backend name -> prefix of its model names, e.g. "gpu2/"
+	OnConflict string            `toml:"on_conflict"` // "first" (the backend listed first keeps the name) or "prefix" (others get "<backend>/")
+}
+
+// LoadBalanceConfig spreads requests across replicas serving the same
+// models, keeping each model on the replica that has it loaded
+type LoadBalanceConfig struct {
+	Enabled   bool     `toml:"enabled"`
+	Backends  []string `toml:"backends"`   // "primary" or names of failover backends; at least two
+	KeepAlive string   `toml:"keep_alive"` // How long a model stays on its replica after its last request, as the replicas' keep_alive: a duration ("5m") or seconds (-1 = forever)
+}
+
+// RaceConfig sends each request to several backends at once and answers
+// with whichever responds first, cancelling the others
+type RaceConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	Backends []string `toml:"backends"` // "primary" or names of failover backends; at least two
+	Models   []string `toml:"models"`   // Models raced (empty = all)
+}
+
+// TenantConfig describes a tenant: a group of clients, recognized by the API
+// keys they present or the path prefix they send requests under, with
+// settings of its own.
+type TenantConfig struct {
+	Name              string                   `toml:"name"`
+	APIKeys           []string                 `toml:"api_keys"`
+	PathPrefix        string                   `toml:"path_prefix"`         // e.g. "/team-a"
+	Backend           string                   `toml:"backend"`             // "primary" or the name of a failover backend ("" = usual failover chain)
+	RequestsPerMinute int                      `toml:"requests_per_minute"` // 0 = no limit
+	ChatTextInjection *ChatTextInjectionConfig `toml:"chat_text_injection"` // Replaces [chat_text_injection] for the tenant's requests
+}
+
+// Tenant returns the tenant with the given name, or nil if there is none
+func (c *Config) Tenant(name string) *TenantConfig {
+	if name == "" {
+		return nil
+	}
+	for i := range c.Tenants {
+		if c.Tenants[i].Name == name {
+			return &c.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// UsersConfig says how the user who made a request is worked out, for the
+// request log. The user is the one whose API key the client presents, or
+// else is taken from a header the client sends.
+type UsersConfig struct {
+	Header  string              `toml:"header"`   // Header naming the user ("X-User" by default)
+	APIKeys map[string][]string `toml:"api_keys"` // User name -> the API keys their clients present
+}
+
+// ModelAccessConfig limits the backend models clients can see and use.
+// Patterns are regular expressions that must match the whole model name.
+type ModelAccessConfig struct {
+	Allow []string `toml:"allow"` // Models clients may use (empty = all)
+	Deny  []string `toml:"deny"`  // Models clients may not use, even if allowed
+}
+
+// ShadowConfig describes a backend that is sent a copy of every generate and
+// chat request. Its responses are only logged, linked to the entry of the
+// request they copy, so that a new model can be compared with the one in use.
+// Type-specific settings (api keys, safety settings) are shared with the
+// primary backend.
+type ShadowConfig struct {
+	Enabled    bool              `toml:"enabled"`
+	Type       string            `toml:"type"` // "openai", "vllm", "textgen", "openrouter", "ollama", "koboldcpp", "gemini" or "mock"
+	Endpoint   string            `toml:"endpoint"`
+	Model      string            `toml:"model"`       // Model to request instead of the client's ("" = same model)
+	SampleRate float64           `toml:"sample_rate"` // Fraction of requests, up to 1, that are copied (0 = all)
+	Timeout    int               `toml:"timeout"`     // in seconds
+	Headers    map[string]string `toml:"headers"`     // Extra HTTP headers; replaces backend.headers for the shadow
+	Proxy      string            `toml:"proxy"`       // Outbound proxy; defaults to backend.proxy
+	TLS        BackendTLSConfig  `toml:"tls"`         // Replaces backend.tls for the shadow when any field is set
+}
+
 // Load reads and parses the configuration file
 func Load(path string) (*Config, error) {
 	var config Config
@@ -90,14 +667,210 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("unknown keys in config file: %v", metadata.Undecoded())
 	}
 
+	// Environment variables override the file
+	if err := applyEnvOverrides(&config, os.Environ()); err != nil {
+		return nil, err
+	}
+
 	// Validate backend type
-	if config.Backend.Type != "openai" && config.Backend.Type != "ollama" {
-		return nil, fmt.Errorf("invalid backend type: %s (must be 'openai' or 'ollama')", config.Backend.Type)
+	if !validBackendType(config.Backend.Type) {
+		return nil, fmt.Errorf("invalid backend type: %s (must be %s)", config.Backend.Type, backendTypes)
+	}
+	for name := range config.Backend.Headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid backend.headers key: %q (must be a valid HTTP header name)", name)
+		}
+	}
+	keySources := 0
+	for _, source := range []string{config.BackendOpenAI.APIKey, config.BackendOpenAI.APIKeyFile, config.BackendOpenAI.APIKeyEnv} {
+		if source != "" {
+			keySources++
+		}
+	}
+	if keySources > 1 {
+		return nil, fmt.Errorf("invalid backend_openai: only one of api_key, api_key_file, and api_key_env may be set")
+	}
+	for name, model := range config.BackendOpenAI.Models {
+		if model.ContextLength < 0 {
+			return nil, fmt.Errorf("invalid backend_openai.models.%s.context_length: %d (must be 0 or greater)", name, model.ContextLength)
+		}
+		for _, capability := range model.Capabilities {
+			if !validModelCapabilities[capability] {
+				return nil, fmt.Errorf("invalid backend_openai.models.%s.capabilities: %q (must be 'completion', 'tools', 'insert', 'vision', 'embedding', or 'thinking')", name, capability)
+			}
+		}
+	}
+	if config.BackendOpenAI.StaticModels {
+		listed := 0
+		for name := range config.BackendOpenAI.Models {
+			if name != "*" {
+				listed++
+			}
+		}
+		if listed == 0 {
+			return nil, fmt.Errorf("invalid backend_openai.static_models: no models to list (describe them in [backend_openai.models.\"<name>\"])")
+		}
+	}
+	for i, setting := range config.BackendGemini.SafetySettings {
+		if setting.Category == "" || setting.Threshold == "" {
+			return nil, fmt.Errorf("invalid backend_gemini.safety_settings[%d]: category and threshold are required", i)
+		}
+	}
+	switch config.BackendKoboldCpp.ChatTemplate {
+	case "", "chatml", "llama3", "mistral", "alpaca":
+	default:
+		return nil, fmt.Errorf("invalid backend_koboldcpp.chat_template: %q (must be \"chatml\", \"llama3\", \"mistral\" or \"alpaca\")", config.BackendKoboldCpp.ChatTemplate)
+	}
+	if config.BackendKoboldCpp.MaxLength < 0 {
+		return nil, fmt.Errorf("invalid backend_koboldcpp.max_length: %d (must be >= 0)", config.BackendKoboldCpp.MaxLength)
+	}
+	if config.BackendMock.DelayMs < 0 {
+		return nil, fmt.Errorf("invalid backend_mock.delay_ms: %d (must be >= 0)", config.BackendMock.DelayMs)
+	}
+	if config.BackendMock.TokensPerSecond < 0 {
+		return nil, fmt.Errorf("invalid backend_mock.tokens_per_second: %g (must be >= 0)", config.BackendMock.TokensPerSecond)
+	}
+	if config.BackendMock.ErrorRate < 0 || config.BackendMock.ErrorRate > 1 {
+		return nil, fmt.Errorf("invalid backend_mock.error_rate: %g (must be between 0 and 1)", config.BackendMock.ErrorRate)
+	}
+	if config.BackendMock.ErrorStatus != 0 && (config.BackendMock.ErrorStatus < 400 || config.BackendMock.ErrorStatus > 599) {
+		return nil, fmt.Errorf("invalid backend_mock.error_status: %d (must be between 400 and 599)", config.BackendMock.ErrorStatus)
+	}
+	for i, scenario := range config.BackendMock.Scenarios {
+		if scenario.Status != 0 && (scenario.Status < 400 || scenario.Status > 599) {
+			return nil, fmt.Errorf("invalid backend_mock.scenarios[%d].status: %d (must be between 400 and 599)", i, scenario.Status)
+		}
+		if scenario.FailAfter < 0 {
+			return nil, fmt.Errorf("invalid backend_mock.scenarios[%d].fail_after: %d (must be >= 0)", i, scenario.FailAfter)
+		}
+		for j, call := range scenario.ToolCalls {
+			if call.Name == "" {
+				return nil, fmt.Errorf("invalid backend_mock.scenarios[%d].tool_calls[%d]: name is required", i, j)
+			}
+		}
+	}
+
+	switch config.Database.JournalMode {
+	case "", "wal", "delete", "truncate", "persist", "memory", "off":
+	default:
+		return nil, fmt.Errorf("invalid database.journal_mode: %q (must be \"wal\", \"delete\", \"truncate\", \"persist\", \"memory\" or \"off\")", config.Database.JournalMode)
+	}
+	switch config.Database.Synchronous {
+	case "", "off", "normal", "full", "extra":
+	default:
+		return nil, fmt.Errorf("invalid database.synchronous: %q (must be \"off\", \"normal\", \"full\" or \"extra\")", config.Database.Synchronous)
+	}
+	if config.Database.BusyTimeout < 0 || config.Database.MaxOpenConns < 0 || config.Database.MaxIdleConns < 0 {
+		return nil, fmt.Errorf("invalid database settings: busy_timeout, max_open_conns and max_idle_conns must be 0 or greater")
+	}
+	if config.Database.WriteQueueSize < -1 {
+		return nil, fmt.Errorf("invalid database.write_queue_size: %d (must be -1 or greater)", config.Database.WriteQueueSize)
+	}
+	if config.Database.WriteBatchSize < 0 {
+		return nil, fmt.Errorf("invalid database.write_batch_size: %d (must be 0 or greater)", config.Database.WriteBatchSize)
+	}
+	if config.Database.MaxPayloadSize < 0 {
+		return nil, fmt.Errorf("invalid database.max_payload_size: %d (must be 0 or greater)", config.Database.MaxPayloadSize)
+	}
+	encryptionKeySources := 0
+	for _, source := range []string{config.Database.EncryptionKey, config.Database.EncryptionKeyFile, config.Database.EncryptionKeyEnv} {
+		if source != "" {
+			encryptionKeySources++
+		}
+	}
+	if encryptionKeySources > 1 {
+		return nil, fmt.Errorf("invalid database: only one of encryption_key, encryption_key_file, and encryption_key_env may be set")
+	}
+	// Spilled bodies are plain files for reading outside the proxy, so they
+	// can't be encrypted
+	if encryptionKeySources > 0 && config.Database.PayloadSpillDir != "" {
+		return nil, fmt.Errorf("invalid database.payload_spill_dir: can't be used with an encryption key, which spilled bodies would bypass")
+	}
+	if config.Server.ShutdownTimeout < -1 {
+		return nil, fmt.Errorf("invalid server.shutdown_timeout: %d (must be -1 or greater)", config.Server.ShutdownTimeout)
+	}
+	if config.Server.KeepAliveInterval < 0 {
+		return nil, fmt.Errorf("invalid server.keep_alive_interval: %d (must be 0 or greater)", config.Server.KeepAliveInterval)
+	}
+	if config.Server.FlushMode != "" && config.Server.FlushMode != "chunk" && config.Server.FlushMode != "timed" {
+		return nil, fmt.Errorf("invalid server.flush_mode: %q (must be \"chunk\" or \"timed\")", config.Server.FlushMode)
+	}
+	if config.Server.FlushIntervalMs < 0 {
+		return nil, fmt.Errorf("invalid server.flush_interval_ms: %d (must be 0 or greater)", config.Server.FlushIntervalMs)
+	}
+	if config.Backend.Timeout < 0 {
+		return nil, fmt.Errorf("invalid backend.timeout: %d (must be 0 or greater)", config.Backend.Timeout)
+	}
+	for _, timeout := range []struct {
+		key   string
+		value int
+	}{
+		{"connect_timeout", config.Backend.ConnectTimeout},
+		{"response_header_timeout", config.Backend.ResponseHeaderTimeout},
+		{"stream_idle_timeout", config.Backend.StreamIdleTimeout},
+	} {
+		if timeout.value < -1 {
+			return nil, fmt.Errorf("invalid backend.%s: %d (must be -1 or greater)", timeout.key, timeout.value)
+		}
+	}
+	if config.Backend.MaxLineSize < 0 {
+		return nil, fmt.Errorf("invalid backend.max_line_size: %d (must be 0 or greater)", config.Backend.MaxLineSize)
+	}
+	if config.Server.ConfigWatchInterval < -1 {
+		return nil, fmt.Errorf("invalid server.config_watch_interval: %d (must be -1 or greater)", config.Server.ConfigWatchInterval)
+	}
+	for _, origin := range config.Server.CORS.AllowedOrigins {
+		if err := validateCORSOrigin(origin); err != nil {
+			return nil, err
+		}
+	}
+	// Credentials with "*" (also the default when no origins are listed)
+	// would let any website call the proxy as the browser's user
+	if config.Server.CORS.AllowCredentials && (len(config.Server.CORS.AllowedOrigins) == 0 || slices.Contains(config.Server.CORS.AllowedOrigins, "*")) {
+		return nil, fmt.Errorf("invalid server.cors.allow_credentials: can't be used with any origin (list the allowed_origins instead of \"*\")")
+	}
+	for _, list := range []struct {
+		key   string
+		rules []string
+	}{{"allow", config.Server.Access.Allow}, {"deny", config.Server.Access.Deny}} {
+		for _, rule := range list.rules {
+			if !validAccessRule(rule) {
+				return nil, fmt.Errorf("invalid server.access.%s: %q (must be a CIDR range or IP address)", list.key, rule)
+			}
+		}
+	}
+	if config.Server.CORS.MaxAge < -1 {
+		return nil, fmt.Errorf("invalid server.cors.max_age: %d (must be -1 or greater)", config.Server.CORS.MaxAge)
+	}
+	if config.Server.Port < 0 || config.Server.Port > 65535 {
+		return nil, fmt.Errorf("invalid server.port: %d (must be between 0 and 65535)", config.Server.Port)
+	}
+	if config.Server.AdminPort < 0 || config.Server.AdminPort > 65535 {
+		return nil, fmt.Errorf("invalid server.admin_port: %d (must be between 0 and 65535)", config.Server.AdminPort)
+	}
+	if config.Server.MaxRequestBodySize < -1 {
+		return nil, fmt.Errorf("invalid server.max_request_body_size: %d (must be -1 or greater)", config.Server.MaxRequestBodySize)
+	}
+
+	// Validate backend TLS and proxy
+	if err := validateBackendTLS("backend.tls", config.Backend.TLS); err != nil {
+		return nil, err
+	}
+	if err := validateProxy("backend.proxy", config.Backend.Proxy); err != nil {
+		return nil, err
+	}
+
+	// Validate TLS
+	if (config.Server.TLSCert == "") != (config.Server.TLSKey == "") {
+		return nil, fmt.Errorf("invalid server TLS settings: tls_cert and tls_key must be set together")
+	}
+	if config.Server.TLSSelfSigned && config.Server.TLSCert != "" {
+		return nil, fmt.Errorf("invalid server.tls_self_signed: cannot be combined with tls_cert")
 	}
 
 	// Validate chat text injection mode
-	if config.ChatTextInjection.Mode != "" && config.ChatTextInjection.Mode != "first" && config.ChatTextInjection.Mode != "last" && config.ChatTextInjection.Mode != "system" {
-		return nil, fmt.Errorf("invalid chat_text_injection.mode: %s (must be 'first', 'last', or 'system')", config.ChatTextInjection.Mode)
+	if err := validateChatTextInjection("chat_text_injection", config.ChatTextInjection); err != nil {
+		return nil, err
 	}
 	if config.RequestSanitization.MaxTokensPolicy != "" &&
 		config.RequestSanitization.MaxTokensPolicy != "preserve" &&
@@ -109,6 +882,76 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("invalid request_sanitization.max_tokens_limit: %d (must be 0 or greater)", config.RequestSanitization.MaxTokensLimit)
 	}
 
+	// Validate token budget
+	if config.TokenBudget.MaxTokens < 0 {
+		return nil, fmt.Errorf("invalid token_budget.max_tokens: %d (must be 0 or greater)", config.TokenBudget.MaxTokens)
+	}
+	if config.TokenBudget.MaxMessageChars < 0 {
+		return nil, fmt.Errorf("invalid token_budget.max_message_chars: %d (must be 0 or greater)", config.TokenBudget.MaxMessageChars)
+	}
+	switch config.TokenBudget.Action {
+	case "", "reject", "truncate":
+	default:
+		return nil, fmt.Errorf("invalid token_budget.action: %s (must be 'reject' or 'truncate')", config.TokenBudget.Action)
+	}
+	switch config.TokenBudget.Strategy {
+	case "", "drop_oldest", "keep_first":
+	default:
+		return nil, fmt.Errorf("invalid token_budget.strategy: %s (must be 'drop_oldest' or 'keep_first')", config.TokenBudget.Strategy)
+	}
+
+	// Validate context trim
+	for model, size := range config.ContextTrim.ContextSizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid context_trim.context_sizes.%q: %d (must be greater than 0)", model, size)
+		}
+	}
+	if config.ContextTrim.ReserveTokens < 0 {
+		return nil, fmt.Errorf("invalid context_trim.reserve_tokens: %d (must be 0 or greater)", config.ContextTrim.ReserveTokens)
+	}
+	if config.ContextTrim.SummaryTokens < 0 {
+		return nil, fmt.Errorf("invalid context_trim.summary_tokens: %d (must be 0 or greater)", config.ContextTrim.SummaryTokens)
+	}
+	switch config.ContextTrim.Mode {
+	case "", "drop", "summarize":
+	default:
+		return nil, fmt.Errorf("invalid context_trim.mode: %s (must be 'drop' or 'summarize')", config.ContextTrim.Mode)
+	}
+	switch config.ContextTrim.Strategy {
+	case "", "drop_oldest", "keep_first":
+	default:
+		return nil, fmt.Errorf("invalid context_trim.strategy: %s (must be 'drop_oldest' or 'keep_first')", config.ContextTrim.Strategy)
+	}
+
+	// Validate tool policies
+	if !validToolMode(config.Tools.Mode) {
+		return nil, fmt.Errorf("invalid tools.mode: %s (must be 'blacklist', 'whitelist', or 'strip')", config.Tools.Mode)
+	}
+	for model, policy := range config.Tools.Models {
+		if !validToolMode(policy.Mode) {
+			return nil, fmt.Errorf("invalid tools.models.%q.mode: %s (must be 'blacklist', 'whitelist', or 'strip')", model, policy.Mode)
+		}
+	}
+	for i, rewrite := range config.Tools.Rewrites {
+		if rewrite.Name == "" {
+			return nil, fmt.Errorf("invalid tools.rewrites[%d]: name is required", i)
+		}
+		if rewrite.Description == "" && rewrite.AppendDescription == "" && len(rewrite.Parameters) == 0 && len(rewrite.AppendParameters) == 0 {
+			return nil, fmt.Errorf("invalid tools.rewrites[%d]: set description, append_description, parameters or append_parameters", i)
+		}
+	}
+
+	// Validate stream coalescing
+	if config.StreamCoalesce.MinChars < -1 {
+		return nil, fmt.Errorf("invalid stream_coalesce.min_chars: %d (must be -1 or more)", config.StreamCoalesce.MinChars)
+	}
+	if config.StreamCoalesce.FlushIntervalMs < -1 {
+		return nil, fmt.Errorf("invalid stream_coalesce.flush_interval_ms: %d (must be -1 or more)", config.StreamCoalesce.FlushIntervalMs)
+	}
+	if config.StreamCoalesce.MinChars == -1 && config.StreamCoalesce.FlushIntervalMs == -1 {
+		return nil, fmt.Errorf("invalid stream_coalesce: min_chars and flush_interval_ms are both -1 (at least one must be set)")
+	}
+
 	// Validate stream override mode
 	if config.StreamOverride.Mode != "" &&
 		config.StreamOverride.Mode != "passthrough" &&
@@ -117,6 +960,498 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("invalid stream_override.mode: %s (must be 'passthrough', 'always', or 'never')", config.StreamOverride.Mode)
 	}
 
+	// Validate scheduler
+	if config.Scheduler.MaxConcurrentRequests < 0 {
+		return nil, fmt.Errorf("invalid scheduler.max_concurrent_requests: %d (must be 0 or greater)", config.Scheduler.MaxConcurrentRequests)
+	}
+	if config.Scheduler.DefaultWeight < 0 {
+		return nil, fmt.Errorf("invalid scheduler.default_weight: %d (must be 0 or greater)", config.Scheduler.DefaultWeight)
+	}
+	if config.Scheduler.MaxQueueDepth < 0 {
+		return nil, fmt.Errorf("invalid scheduler.max_queue_depth: %d (must be 0 or greater)", config.Scheduler.MaxQueueDepth)
+	}
+	if config.Scheduler.QueueTimeout < 0 {
+		return nil, fmt.Errorf("invalid scheduler.queue_timeout: %d (must be 0 or greater)", config.Scheduler.QueueTimeout)
+	}
+	for key, weight := range config.Scheduler.Weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("invalid scheduler.weights entry for key %q: %d (must be 1 or greater)", maskKey(key), weight)
+		}
+	}
+
+	// Validate federation sources
+	sourceNames := make(map[string]bool)
+	for i, src := range config.Federation.Sources {
+		if src.Name == "" {
+			return nil, fmt.Errorf("invalid federation.sources[%d]: name is required", i)
+		}
+		if sourceNames[src.Name] {
+			return nil, fmt.Errorf("invalid federation.sources[%d]: duplicate name %q", i, src.Name)
+		}
+		sourceNames[src.Name] = true
+		switch src.Type {
+		case "sqlite":
+			if src.Path == "" {
+				return nil, fmt.Errorf("invalid federation.sources[%d]: path is required for type 'sqlite'", i)
+			}
+		case "remote":
+			if src.URL == "" {
+				return nil, fmt.Errorf("invalid federation.sources[%d]: url is required for type 'remote'", i)
+			}
+		default:
+			return nil, fmt.Errorf("invalid federation.sources[%d].type: %s (must be 'sqlite' or 'remote')", i, src.Type)
+		}
+		if src.Timeout < 0 {
+			return nil, fmt.Errorf("invalid federation.sources[%d].timeout: %d (must be 0 or greater)", i, src.Timeout)
+		}
+	}
+
+	if config.EmbeddingCache.MaxEntries < 0 {
+		return nil, fmt.Errorf("invalid embedding_cache.max_entries: %d (must be 0 or greater)", config.EmbeddingCache.MaxEntries)
+	}
+
+	// Validate failover backends
+	if config.Failover.HealthCheckInterval < -1 {
+		return nil, fmt.Errorf("invalid failover.health_check_interval: %d (must be -1 or greater)", config.Failover.HealthCheckInterval)
+	}
+	for i, fb := range config.Failover.Backends {
+		if !validBackendType(fb.Type) {
+			return nil, fmt.Errorf("invalid failover.backends[%d].type: %s (must be %s)", i, fb.Type, backendTypes)
+		}
+		if fb.Endpoint == "" && defaultEndpoints[fb.Type] == "" {
+			return nil, fmt.Errorf("invalid failover.backends[%d]: endpoint is required for type '%s'", i, fb.Type)
+		}
+		if fb.Timeout < 0 {
+			return nil, fmt.Errorf("invalid failover.backends[%d].timeout: %d (must be 0 or greater)", i, fb.Timeout)
+		}
+		for name := range fb.Headers {
+			if !validHeaderName(name) {
+				return nil, fmt.Errorf("invalid failover.backends[%d].headers key: %q (must be a valid HTTP header name)", i, name)
+			}
+		}
+		if err := validateBackendTLS(fmt.Sprintf("failover.backends[%d].tls", i), fb.TLS); err != nil {
+			return nil, err
+		}
+		if err := validateProxy(fmt.Sprintf("failover.backends[%d].proxy", i), fb.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate routes
+	backendNames := map[string]bool{"primary": true}
+	for i, fb := range config.Failover.Backends {
+		if fb.Name == "" {
+			continue
+		}
+		if backendNames[fb.Name] {
+			return nil, fmt.Errorf("invalid failover.backends[%d].name: %q (already used)", i, fb.Name)
+		}
+		backendNames[fb.Name] = true
+	}
+	for i, route := range config.Routes {
+		for key, pattern := range map[string]string{"model": route.Model, "path": route.Path, "content": route.Content} {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("invalid routes[%d].%s: %v", i, key, err)
+			}
+		}
+		for name, pattern := range route.Headers {
+			if !validHeaderName(name) {
+				return nil, fmt.Errorf("invalid routes[%d].headers key: %q (must be a valid HTTP header name)", i, name)
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("invalid routes[%d].headers.%s: %v", i, name, err)
+			}
+		}
+		if route.Backend != "" && !backendNames[route.Backend] {
+			return nil, fmt.Errorf("invalid routes[%d].backend: %q (must be \"primary\" or the name of a failover backend)", i, route.Backend)
+		}
+		if route.Reject != 0 && (route.Reject < 400 || route.Reject > 599) {
+			return nil, fmt.Errorf("invalid routes[%d].reject: %d (must be between 400 and 599)", i, route.Reject)
+		}
+		if route.Reject != 0 && (route.Backend != "" || route.SetModel != "") {
+			return nil, fmt.Errorf("invalid routes[%d]: reject can't be combined with backend or set_model", i)
+		}
+		if route.Reject == 0 && route.Backend == "" && route.SetModel == "" {
+			return nil, fmt.Errorf("invalid routes[%d]: one of backend, set_model and reject is required", i)
+		}
+	}
+	if config.BackendOverride.Header != "" && !validHeaderName(config.BackendOverride.Header) {
+		return nil, fmt.Errorf("invalid backend_override.header: %q (must be a valid HTTP header name)", config.BackendOverride.Header)
+	}
+	for _, name := range config.BackendOverride.Backends {
+		if !backendNames[name] {
+			return nil, fmt.Errorf("invalid backend_override.backends entry: %q (must be \"primary\" or the name of a failover backend)", name)
+		}
+	}
+
+	// Validate model aggregation
+	for _, name := range config.ModelAggregation.Backends {
+		if !backendNames[name] {
+			return nil, fmt.Errorf("invalid model_aggregation.backends entry: %q (must be \"primary\" or the name of a failover backend)", name)
+		}
+	}
+	aggregatePrefixes := map[string]bool{}
+	for name, prefix := range config.ModelAggregation.Prefixes {
+		if !backendNames[name] {
+			return nil, fmt.Errorf("invalid model_aggregation.prefixes key: %q (must be \"primary\" or the name of a failover backend)", name)
+		}
+		if prefix == "" || aggregatePrefixes[prefix] {
+			return nil, fmt.Errorf("invalid model_aggregation.prefixes.%s: %q (must be non-empty and unique)", name, prefix)
+		}
+		aggregatePrefixes[prefix] = true
+	}
+	if config.ModelAggregation.OnConflict != "" && config.ModelAggregation.OnConflict != "first" && config.ModelAggregation.OnConflict != "prefix" {
+		return nil, fmt.Errorf("invalid model_aggregation.on_conflict: %q (must be \"first\" or \"prefix\")", config.ModelAggregation.OnConflict)
+	}
+
+	// Validate load balancing
+	balancedBackends := map[string]bool{}
+	for _, name := range config.LoadBalance.Backends {
+		if !backendNames[name] {
+			return nil, fmt.Errorf("invalid load_balance.backends entry: %q (must be \"primary\" or the name of a failover backend)", name)
+		}
+		if balancedBackends[name] {
+			return nil, fmt.Errorf("invalid load_balance.backends: duplicate entry %q", name)
+		}
+		balancedBackends[name] = true
+	}
+	if config.LoadBalance.Enabled && len(config.LoadBalance.Backends) < 2 {
+		return nil, fmt.Errorf("invalid load_balance.backends: %d backend(s) (must list at least two)", len(config.LoadBalance.Backends))
+	}
+	if keepAlive := config.LoadBalance.KeepAlive; keepAlive != "" {
+		if _, err := strconv.Atoi(keepAlive); err != nil {
+			if _, err := time.ParseDuration(keepAlive); err != nil {
+				return nil, fmt.Errorf("invalid load_balance.keep_alive: %q (must be a duration such as \"5m\" or a number of seconds)", keepAlive)
+			}
+		}
+	}
+
+	// Validate race
+	raceBackends := map[string]bool{}
+	for _, name := range config.Race.Backends {
+		if !backendNames[name] {
+			return nil, fmt.Errorf("invalid race.backends entry: %q (must be \"primary\" or the name of a failover backend)", name)
+		}
+		if raceBackends[name] {
+			return nil, fmt.Errorf("invalid race.backends: duplicate entry %q", name)
+		}
+		raceBackends[name] = true
+	}
+	if config.Race.Enabled && len(config.Race.Backends) < 2 {
+		return nil, fmt.Errorf("invalid race.backends: %d backend(s) (must list at least two)", len(config.Race.Backends))
+	}
+
+	// Validate tenants
+	tenantNames := map[string]bool{}
+	tenantKeys := map[string]bool{}
+	tenantPrefixes := map[string]bool{}
+	for i, tenant := range config.Tenants {
+		if tenant.Name == "" {
+			return nil, fmt.Errorf("invalid tenants[%d]: name is required", i)
+		}
+		if tenantNames[tenant.Name] {
+			return nil, fmt.Errorf("invalid tenants[%d].name: %q (already used)", i, tenant.Name)
+		}
+		tenantNames[tenant.Name] = true
+		if len(tenant.APIKeys) == 0 && tenant.PathPrefix == "" {
+			return nil, fmt.Errorf("invalid tenants[%d]: api_keys or path_prefix is required", i)
+		}
+		for _, key := range tenant.APIKeys {
+			if key == "" || tenantKeys[key] {
+				return nil, fmt.Errorf("invalid tenants[%d].api_keys: keys must be non-empty and belong to one tenant", i)
+			}
+			tenantKeys[key] = true
+		}
+		if tenant.PathPrefix != "" {
+			if !strings.HasPrefix(tenant.PathPrefix, "/") || strings.HasSuffix(tenant.PathPrefix, "/") {
+				return nil, fmt.Errorf("invalid tenants[%d].path_prefix: %q (must start with / and not end with one)", i, tenant.PathPrefix)
+			}
+			if tenantPrefixes[tenant.PathPrefix] {
+				return nil, fmt.Errorf("invalid tenants[%d].path_prefix: %q (already used)", i, tenant.PathPrefix)
+			}
+			tenantPrefixes[tenant.PathPrefix] = true
+		}
+		if tenant.Backend != "" && !backendNames[tenant.Backend] {
+			return nil, fmt.Errorf("invalid tenants[%d].backend: %q (must be \"primary\" or the name of a failover backend)", i, tenant.Backend)
+		}
+		if tenant.RequestsPerMinute < 0 {
+			return nil, fmt.Errorf("invalid tenants[%d].requests_per_minute: %d (must be 0 or greater)", i, tenant.RequestsPerMinute)
+		}
+		if tenant.ChatTextInjection != nil {
+			if err := validateChatTextInjection(fmt.Sprintf("tenants[%d].chat_text_injection", i), *tenant.ChatTextInjection); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range config.Server.AdminAPIKeys {
+		if key == "" || tenantKeys[key] {
+			return nil, fmt.Errorf("invalid server.admin_api_keys: keys must be non-empty and not a tenant's")
+		}
+	}
+
+	// Validate users
+	if config.Users.Header != "" && !validHeaderName(config.Users.Header) {
+		return nil, fmt.Errorf("invalid users.header: %q (must be a valid HTTP header name)", config.Users.Header)
+	}
+	userKeys := map[string]bool{}
+	for name, keys := range config.Users.APIKeys {
+		for _, key := range keys {
+			if key == "" || userKeys[key] {
+				return nil, fmt.Errorf("invalid users.api_keys.%s: keys must be non-empty and belong to one user", name)
+			}
+			userKeys[key] = true
+		}
+	}
+
+	// Validate model access lists
+	for key, patterns := range map[string][]string{"allow": config.ModelAccess.Allow, "deny": config.ModelAccess.Deny} {
+		for _, pattern := range patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("invalid model_access.%s: %q: %v", key, pattern, err)
+			}
+		}
+	}
+
+	// Validate shadow backend
+	if config.Shadow.Enabled {
+		shadow := config.Shadow
+		if !validBackendType(shadow.Type) {
+			return nil, fmt.Errorf("invalid shadow.type: %s (must be %s)", shadow.Type, backendTypes)
+		}
+		if shadow.Endpoint == "" && defaultEndpoints[shadow.Type] == "" {
+			return nil, fmt.Errorf("invalid shadow: endpoint is required for type '%s'", shadow.Type)
+		}
+		if shadow.SampleRate < 0 || shadow.SampleRate > 1 {
+			return nil, fmt.Errorf("invalid shadow.sample_rate: %g (must be between 0 and 1)", shadow.SampleRate)
+		}
+		if shadow.Timeout < 0 {
+			return nil, fmt.Errorf("invalid shadow.timeout: %d (must be 0 or greater)", shadow.Timeout)
+		}
+		for name := range shadow.Headers {
+			if !validHeaderName(name) {
+				return nil, fmt.Errorf("invalid shadow.headers key: %q (must be a valid HTTP header name)", name)
+			}
+		}
+		if err := validateProxy("shadow.proxy", shadow.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate retry policy
+	if config.Retry.MaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid retry.max_attempts: %d (must be 1 or greater)", config.Retry.MaxAttempts)
+	}
+	if config.Retry.InitialBackoffMs < 0 {
+		return nil, fmt.Errorf("invalid retry.initial_backoff_ms: %d (must be 0 or greater)", config.Retry.InitialBackoffMs)
+	}
+	if config.Retry.MaxBackoffMs < 0 {
+		return nil, fmt.Errorf("invalid retry.max_backoff_ms: %d (must be 0 or greater)", config.Retry.MaxBackoffMs)
+	}
+	for _, code := range config.Retry.RetryOnStatus {
+		if code < 400 || code > 599 {
+			return nil, fmt.Errorf("invalid retry.retry_on_status entry: %d (must be a 4xx or 5xx status code)", code)
+		}
+	}
+
+	// Validate rate limiting
+	if config.RateLimit.MaxConcurrent < 0 {
+		return nil, fmt.Errorf("invalid rate_limit.max_concurrent: %d (must be 0 or greater)", config.RateLimit.MaxConcurrent)
+	}
+	if config.RateLimit.MaxQueueDepth < 0 {
+		return nil, fmt.Errorf("invalid rate_limit.max_queue_depth: %d (must be 0 or greater)", config.RateLimit.MaxQueueDepth)
+	}
+	if config.RateLimit.MaxRetryAfter < 0 {
+		return nil, fmt.Errorf("invalid rate_limit.max_retry_after: %d (must be 0 or greater)", config.RateLimit.MaxRetryAfter)
+	}
+	if config.RateLimit.QueueTimeout < 0 {
+		return nil, fmt.Errorf("invalid rate_limit.queue_timeout: %d (must be 0 or greater)", config.RateLimit.QueueTimeout)
+	}
+
+	// Validate token counting
+	if !validTokenCountMethod(config.TokenCounting.Method) && config.TokenCounting.Method != "" {
+		return nil, fmt.Errorf("invalid token_counting.method: %q (must be %s)", config.TokenCounting.Method, tokenCountMethods)
+	}
+	for model, method := range config.TokenCounting.Models {
+		if !validTokenCountMethod(method) {
+			return nil, fmt.Errorf("invalid token_counting.models entry for %q: %q (must be %s)", model, method, tokenCountMethods)
+		}
+	}
+
+	// Validate response cache
+	if config.ResponseCache.Storage != "" && config.ResponseCache.Storage != "memory" && config.ResponseCache.Storage != "sqlite" {
+		return nil, fmt.Errorf("invalid response_cache.storage: %q (must be \"memory\" or \"sqlite\")", config.ResponseCache.Storage)
+	}
+	if config.ResponseCache.TTL < 0 {
+		return nil, fmt.Errorf("invalid response_cache.ttl: %d (must be 0 or greater)", config.ResponseCache.TTL)
+	}
+	if config.ResponseCache.MaxEntries < 0 {
+		return nil, fmt.Errorf("invalid response_cache.max_entries: %d (must be 0 or greater)", config.ResponseCache.MaxEntries)
+	}
+
+	// Validate model cache
+	if config.ModelCache.TTL < 0 {
+		return nil, fmt.Errorf("invalid model_cache.ttl: %d (must be 0 or greater)", config.ModelCache.TTL)
+	}
+
+	// Validate model aliases
+	for alias, target := range config.ModelAliases {
+		if target == "" {
+			return nil, fmt.Errorf("invalid model_aliases entry for %q: empty model name", alias)
+		}
+		if _, chained := config.ModelAliases[target]; chained {
+			return nil, fmt.Errorf("invalid model_aliases entry for %q: %q is itself an alias (must be a backend model name)", alias, target)
+		}
+	}
+
+	// Validate content filters
+	for i, filter := range config.ContentFilters {
+		if filter.Pattern == "" {
+			return nil, fmt.Errorf("invalid content_filters[%d]: pattern is required", i)
+		}
+		if _, err := regexp.Compile(filter.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid content_filters[%d].pattern: %v", i, err)
+		}
+		if filter.Direction != "" && filter.Direction != "request" && filter.Direction != "response" && filter.Direction != "both" {
+			return nil, fmt.Errorf("invalid content_filters[%d].direction: %q (must be \"request\", \"response\" or \"both\")", i, filter.Direction)
+		}
+	}
+
+	// Validate response transforms
+	for i, transform := range config.ResponseTransforms {
+		switch transform.Type {
+		case "strip_think", "relocate_think", "strip_ansi", "profanity":
+		case "regex":
+			if transform.Pattern == "" {
+				return nil, fmt.Errorf("invalid response_transforms[%d]: pattern is required for type 'regex'", i)
+			}
+			if _, err := regexp.Compile(transform.Pattern); err != nil {
+				return nil, fmt.Errorf("invalid response_transforms[%d].pattern: %v", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid response_transforms[%d].type: %q (must be \"strip_think\", \"relocate_think\", \"strip_ansi\", \"regex\" or \"profanity\")", i, transform.Type)
+		}
+	}
+
+	// Validate hooks
+	for i, hook := range config.Hooks {
+		if len(hook.Command) == 0 || hook.Command[0] == "" {
+			return nil, fmt.Errorf("invalid hooks[%d]: command is required", i)
+		}
+		if hook.Stage != "" && hook.Stage != "request" && hook.Stage != "response" && hook.Stage != "both" {
+			return nil, fmt.Errorf("invalid hooks[%d].stage: %q (must be \"request\", \"response\" or \"both\")", i, hook.Stage)
+		}
+		if hook.Timeout < -1 {
+			return nil, fmt.Errorf("invalid hooks[%d].timeout: %d (must be -1 or greater)", i, hook.Timeout)
+		}
+		if hook.OnError != "" && hook.OnError != "fail" && hook.OnError != "ignore" {
+			return nil, fmt.Errorf("invalid hooks[%d].on_error: %q (must be \"fail\" or \"ignore\")", i, hook.OnError)
+		}
+	}
+
+	// Validate PII redaction
+	for _, piiType := range config.PIIRedaction.Types {
+		if piiType != "email" && piiType != "credit_card" && piiType != "phone" {
+			return nil, fmt.Errorf("invalid pii_redaction.types entry: %q (must be \"email\", \"credit_card\" or \"phone\")", piiType)
+		}
+	}
+	for i, pattern := range config.PIIRedaction.Patterns {
+		if pattern.Name == "" || pattern.Pattern == "" {
+			return nil, fmt.Errorf("invalid pii_redaction.patterns[%d]: name and pattern are required", i)
+		}
+		if _, err := regexp.Compile(pattern.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pii_redaction.patterns[%d].pattern: %v", i, err)
+		}
+	}
+
+	// Validate pricing
+	for model, price := range config.Pricing {
+		if price.Prompt < 0 || price.Completion < 0 || (price.CachedPrompt != nil && *price.CachedPrompt < 0) {
+			return nil, fmt.Errorf("invalid pricing.%q: prices must be 0 or greater", model)
+		}
+	}
+
+	// Validate notifications
+	for _, setting := range []struct {
+		key   string
+		value string
+	}{
+		{"webhook_url", config.Notifications.WebhookURL},
+		{"public_url", config.Notifications.PublicURL},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		if u, err := url.Parse(setting.value); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid notifications.%s: %q (must be an http or https URL)", setting.key, setting.value)
+		}
+	}
+	if config.Notifications.ErrorRate < 0 || config.Notifications.ErrorRate > 100 {
+		return nil, fmt.Errorf("invalid notifications.error_rate: %g (must be between 0 and 100)", config.Notifications.ErrorRate)
+	}
+	if config.Notifications.Cost < 0 {
+		return nil, fmt.Errorf("invalid notifications.cost: %g (must be 0 or greater)", config.Notifications.Cost)
+	}
+	for _, setting := range []struct {
+		key   string
+		value int
+	}{
+		{"latency_ms", config.Notifications.LatencyMs},
+		{"window", config.Notifications.Window},
+		{"min_requests", config.Notifications.MinRequests},
+		{"timeout", config.Notifications.Timeout},
+	} {
+		if setting.value < 0 {
+			return nil, fmt.Errorf("invalid notifications.%s: %d (must be 0 or greater)", setting.key, setting.value)
+		}
+	}
+
+	if keepAlive := config.Warmup.KeepAlive; keepAlive != "" {
+		if _, err := strconv.Atoi(keepAlive); err != nil {
+			if _, err := time.ParseDuration(keepAlive); err != nil {
+				return nil, fmt.Errorf("invalid warmup.keep_alive: %q (must be a duration such as \"30m\" or a number of seconds)", keepAlive)
+			}
+		}
+	}
+	if config.Warmup.Timeout < 0 {
+		return nil, fmt.Errorf("invalid warmup.timeout: %d (must be 0 or greater)", config.Warmup.Timeout)
+	}
+
+	// Resolve the OpenAI API key from a file or environment variable
+	if config.BackendOpenAI.APIKeyFile != "" {
+		data, err := os.ReadFile(config.BackendOpenAI.APIKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backend_openai.api_key_file: %w", err)
+		}
+		config.BackendOpenAI.APIKey = strings.TrimSpace(string(data))
+	}
+	if config.BackendOpenAI.APIKeyEnv != "" {
+		config.BackendOpenAI.APIKey = os.Getenv(config.BackendOpenAI.APIKeyEnv)
+		if config.BackendOpenAI.APIKey == "" {
+			return nil, fmt.Errorf("invalid backend_openai.api_key_env: environment variable %s is not set", config.BackendOpenAI.APIKeyEnv)
+		}
+	}
+
+	// Resolve the database encryption key the same way
+	if config.Database.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(config.Database.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read database.encryption_key_file: %w", err)
+		}
+		config.Database.EncryptionKey = strings.TrimSpace(string(data))
+	}
+	if config.Database.EncryptionKeyEnv != "" {
+		config.Database.EncryptionKey = os.Getenv(config.Database.EncryptionKeyEnv)
+		if config.Database.EncryptionKey == "" {
+			return nil, fmt.Errorf("invalid database.encryption_key_env: environment variable %s is not set", config.Database.EncryptionKeyEnv)
+		}
+	}
+	if config.Database.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(config.Database.EncryptionKey)
+		if err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+			return nil, fmt.Errorf("invalid database encryption key: must be the base64 of 16, 24 or 32 bytes")
+		}
+	}
+
 	// Set defaults
 	if config.Server.Host == "" {
 		config.Server.Host = "0.0.0.0"
@@ -124,8 +1459,65 @@ func Load(path string) (*Config, error) {
 	if config.Server.Port == 0 {
 		config.Server.Port = 11434
 	}
-	if config.Backend.Timeout == 0 {
-		config.Backend.Timeout = 300
+	if config.Server.AdminHost == "" {
+		config.Server.AdminHost = "127.0.0.1"
+	}
+	if config.Server.ShutdownTimeout == 0 {
+		config.Server.ShutdownTimeout = 30
+	}
+	if config.Server.FlushMode == "" {
+		config.Server.FlushMode = "chunk"
+	}
+	if config.Server.FlushIntervalMs == 0 {
+		config.Server.FlushIntervalMs = 50
+	}
+	if config.Server.MaxRequestBodySize == 0 {
+		config.Server.MaxRequestBodySize = 32
+	}
+	if config.Server.ConfigWatchInterval == 0 {
+		config.Server.ConfigWatchInterval = 2
+	}
+	if config.Server.AdminPort == config.Server.Port && config.Server.AdminHost == config.Server.Host {
+		return nil, fmt.Errorf("invalid server.admin_port: %d (must differ from server.port, or be 0 to serve the web UI on it)", config.Server.AdminPort)
+	}
+	if len(config.Server.CORS.AllowedOrigins) == 0 {
+		config.Server.CORS.AllowedOrigins = []string{"*"}
+	}
+	if len(config.Server.CORS.AllowedMethods) == 0 {
+		config.Server.CORS.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	}
+	if len(config.Server.CORS.AllowedHeaders) == 0 {
+		config.Server.CORS.AllowedHeaders = []string{"Content-Type", "Authorization", "X-Requested-With"}
+	}
+	if config.Server.CORS.MaxAge == 0 {
+		config.Server.CORS.MaxAge = 3600
+	}
+	if config.Backend.Endpoint == "" {
+		config.Backend.Endpoint = defaultEndpoints[config.Backend.Type]
+	}
+	if config.BackendKoboldCpp.ChatTemplate == "" {
+		config.BackendKoboldCpp.ChatTemplate = "chatml"
+	}
+	if config.BackendKoboldCpp.MaxLength == 0 {
+		config.BackendKoboldCpp.MaxLength = 1024
+	}
+	if len(config.BackendMock.Models) == 0 {
+		config.BackendMock.Models = []string{"mock"}
+	}
+	if config.BackendMock.ErrorStatus == 0 {
+		config.BackendMock.ErrorStatus = 500
+	}
+	if config.Backend.ConnectTimeout == 0 {
+		config.Backend.ConnectTimeout = 30
+	}
+	if config.Backend.ResponseHeaderTimeout == 0 {
+		config.Backend.ResponseHeaderTimeout = 300
+	}
+	if config.Backend.StreamIdleTimeout == 0 {
+		config.Backend.StreamIdleTimeout = 300
+	}
+	if config.Backend.MaxLineSize == 0 {
+		config.Backend.MaxLineSize = 16 * 1024
 	}
 	if config.Database.Path == "" {
 		config.Database.Path = "./llm_proxy.db"
@@ -136,15 +1528,356 @@ func Load(path string) (*Config, error) {
 	if config.Database.CleanupInterval == 0 {
 		config.Database.CleanupInterval = 5
 	}
+	if config.Database.WriteQueueSize == 0 {
+		config.Database.WriteQueueSize = 1000
+	}
+	if config.Database.WriteBatchSize == 0 {
+		config.Database.WriteBatchSize = 100
+	}
+	if config.Database.JournalMode == "" {
+		config.Database.JournalMode = "wal"
+	}
+	if config.Database.Synchronous == "" {
+		config.Database.Synchronous = "normal"
+	}
+	if config.Database.BusyTimeout == 0 {
+		config.Database.BusyTimeout = 5000
+	}
+	if config.Database.MaxOpenConns == 0 {
+		config.Database.MaxOpenConns = 8
+	}
+	if config.Database.MaxIdleConns == 0 {
+		config.Database.MaxIdleConns = 2
+	}
+	if config.StreamCoalesce.MinChars == 0 {
+		config.StreamCoalesce.MinChars = 32
+	}
+	if config.StreamCoalesce.FlushIntervalMs == 0 {
+		config.StreamCoalesce.FlushIntervalMs = 50
+	}
 	if config.ChatTextInjection.Mode == "" {
 		config.ChatTextInjection.Mode = "last"
 	}
+	if config.ChatTextInjection.SystemPromptMode == "" {
+		config.ChatTextInjection.SystemPromptMode = "prepend"
+	}
+	for _, tenant := range config.Tenants {
+		if injection := tenant.ChatTextInjection; injection != nil {
+			if injection.Mode == "" {
+				injection.Mode = "last"
+			}
+			if injection.SystemPromptMode == "" {
+				injection.SystemPromptMode = "prepend"
+			}
+		}
+	}
 	if config.RequestSanitization.MaxTokensPolicy == "" {
 		config.RequestSanitization.MaxTokensPolicy = "preserve"
 	}
 	if config.StreamOverride.Mode == "" {
 		config.StreamOverride.Mode = "passthrough"
 	}
+	if config.TokenBudget.Action == "" {
+		config.TokenBudget.Action = "reject"
+	}
+	if config.TokenBudget.Strategy == "" {
+		config.TokenBudget.Strategy = "drop_oldest"
+	}
+	if config.ContextTrim.ReserveTokens == 0 {
+		config.ContextTrim.ReserveTokens = 1024
+	}
+	if config.ContextTrim.Mode == "" {
+		config.ContextTrim.Mode = "drop"
+	}
+	if config.ContextTrim.Strategy == "" {
+		config.ContextTrim.Strategy = "drop_oldest"
+	}
+	if config.ContextTrim.SummaryTokens == 0 {
+		config.ContextTrim.SummaryTokens = 512
+	}
+	if config.Tools.Mode == "" {
+		config.Tools.Mode = "blacklist"
+	}
+	for model, policy := range config.Tools.Models {
+		if policy.Mode == "" {
+			policy.Mode = config.Tools.Mode
+			config.Tools.Models[model] = policy
+		}
+	}
+	if config.Scheduler.DefaultWeight == 0 {
+		config.Scheduler.DefaultWeight = 1
+	}
+	if config.EmbeddingCache.MaxEntries == 0 {
+		config.EmbeddingCache.MaxEntries = 10000
+	}
+	for i := range config.Federation.Sources {
+		if config.Federation.Sources[i].Timeout == 0 {
+			config.Federation.Sources[i].Timeout = 10
+		}
+	}
+	if config.Failover.HealthCheckInterval == 0 {
+		config.Failover.HealthCheckInterval = 30
+	}
+	for i := range config.Failover.Backends {
+		if config.Failover.Backends[i].Endpoint == "" {
+			config.Failover.Backends[i].Endpoint = defaultEndpoints[config.Failover.Backends[i].Type]
+		}
+		if config.Failover.Backends[i].Timeout == 0 {
+			config.Failover.Backends[i].Timeout = config.Backend.Timeout
+		}
+		if config.Failover.Backends[i].Headers == nil {
+			config.Failover.Backends[i].Headers = config.Backend.Headers
+		}
+		if config.Failover.Backends[i].Proxy == "" {
+			config.Failover.Backends[i].Proxy = config.Backend.Proxy
+		}
+		if config.Failover.Backends[i].TLS == (BackendTLSConfig{}) {
+			config.Failover.Backends[i].TLS = config.Backend.TLS
+		}
+	}
+	if config.BackendOverride.Header == "" {
+		config.BackendOverride.Header = "X-LLM-Proxy-Backend"
+	}
+	if config.Users.Header == "" {
+		config.Users.Header = "X-User"
+	}
+	if config.Shadow.Endpoint == "" {
+		config.Shadow.Endpoint = defaultEndpoints[config.Shadow.Type]
+	}
+	if config.Shadow.SampleRate == 0 {
+		config.Shadow.SampleRate = 1
+	}
+	if config.Shadow.Timeout == 0 {
+		config.Shadow.Timeout = config.Backend.Timeout
+	}
+	if config.Shadow.Headers == nil {
+		config.Shadow.Headers = config.Backend.Headers
+	}
+	if config.Shadow.Proxy == "" {
+		config.Shadow.Proxy = config.Backend.Proxy
+	}
+	if config.Shadow.TLS == (BackendTLSConfig{}) {
+		config.Shadow.TLS = config.Backend.TLS
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry.MaxAttempts = 1
+	}
+	if config.Retry.InitialBackoffMs == 0 {
+		config.Retry.InitialBackoffMs = 500
+	}
+	if config.Retry.MaxBackoffMs == 0 {
+		config.Retry.MaxBackoffMs = 10000
+	}
+	if config.Retry.RetryOnStatus == nil {
+		config.Retry.RetryOnStatus = []int{429, 502, 503, 504}
+	}
+	if config.RateLimit.MaxRetryAfter == 0 {
+		config.RateLimit.MaxRetryAfter = 60
+	}
+	if config.TokenCounting.Method == "" {
+		config.TokenCounting.Method = "estimate"
+	}
+	for i := range config.ContentFilters {
+		if config.ContentFilters[i].Name == "" {
+			config.ContentFilters[i].Name = fmt.Sprintf("content_filters[%d]", i)
+		}
+		if config.ContentFilters[i].Direction == "" {
+			config.ContentFilters[i].Direction = "both"
+		}
+	}
+	for i := range config.Routes {
+		if config.Routes[i].Name == "" {
+			config.Routes[i].Name = fmt.Sprintf("routes[%d]", i)
+		}
+		if config.Routes[i].Reject != 0 && config.Routes[i].RejectMessage == "" {
+			config.Routes[i].RejectMessage = "request rejected by route " + config.Routes[i].Name
+		}
+	}
+	for i := range config.Hooks {
+		if config.Hooks[i].Name == "" {
+			config.Hooks[i].Name = fmt.Sprintf("hooks[%d]", i)
+		}
+		if config.Hooks[i].Stage == "" {
+			config.Hooks[i].Stage = "request"
+		}
+		if config.Hooks[i].Timeout == 0 {
+			config.Hooks[i].Timeout = 10
+		}
+		if config.Hooks[i].OnError == "" {
+			config.Hooks[i].OnError = "fail"
+		}
+	}
+	if config.PIIRedaction.Types == nil {
+		config.PIIRedaction.Types = []string{"email", "credit_card", "phone"}
+	}
+	if config.ResponseCache.Storage == "" {
+		config.ResponseCache.Storage = "memory"
+	}
+	if config.ResponseCache.TTL == 0 {
+		config.ResponseCache.TTL = 3600
+	}
+	if config.ResponseCache.MaxEntries == 0 {
+		config.ResponseCache.MaxEntries = 1000
+	}
+	if config.ModelAggregation.Backends == nil {
+		config.ModelAggregation.Backends = []string{"primary"}
+		for _, fb := range config.Failover.Backends {
+			if fb.Name != "" {
+				config.ModelAggregation.Backends = append(config.ModelAggregation.Backends, fb.Name)
+			}
+		}
+	}
+	if config.LoadBalance.KeepAlive == "" {
+		config.LoadBalance.KeepAlive = "5m"
+	}
+	if config.ModelAggregation.OnConflict == "" {
+		config.ModelAggregation.OnConflict = "first"
+	}
+	if config.ModelCache.TTL == 0 {
+		config.ModelCache.TTL = 10
+	}
+	if config.Notifications.Window == 0 {
+		config.Notifications.Window = 300
+	}
+	if config.Notifications.MinRequests == 0 {
+		config.Notifications.MinRequests = 10
+	}
+	if config.Notifications.Timeout == 0 {
+		config.Notifications.Timeout = 10
+	}
+	if config.Warmup.Timeout == 0 {
+		config.Warmup.Timeout = 300
+	}
 
+	config.live = new(atomic.Pointer[Config])
+	config.live.Store(&config)
 	return &config, nil
 }
+
+// backendTypes lists the valid backend types for error messages
+const backendTypes = "'openai', 'vllm', 'textgen', 'openrouter', 'ollama', 'koboldcpp', 'gemini', or 'mock'"
+
+// defaultEndpoints are the endpoints of backend types that have a usual one
+var defaultEndpoints = map[string]string{
+	"openrouter": "https://openrouter.ai/api",
+	"gemini":     "https://generativelanguage.googleapis.com",
+	"mock":       "mock://",
+}
+
+func validBackendType(backendType string) bool {
+	switch backendType {
+	case "openai", "vllm", "textgen", "openrouter", "ollama", "koboldcpp", "gemini", "mock":
+		return true
+	}
+	return false
+}
+
+// tokenCountMethods describes the valid token_counting methods for errors
+const tokenCountMethods = `"estimate", "tiktoken", "tiktoken:<encoding>" (o200k_base, cl100k_base, p50k_base, p50k_edit or r50k_base), "tokenize" or "off"`
+
+func validTokenCountMethod(method string) bool {
+	switch method {
+	case "estimate", "tiktoken", "tokenize", "off",
+		"tiktoken:o200k_base", "tiktoken:cl100k_base", "tiktoken:p50k_base", "tiktoken:p50k_edit", "tiktoken:r50k_base":
+		return true
+	}
+	return false
+}
+
+func validToolMode(mode string) bool {
+	return mode == "" || mode == "blacklist" || mode == "whitelist" || mode == "strip"
+}
+
+// maskKey hides all but the edges of a secret so it can appear in errors.
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "…" + key[len(key)-4:]
+}
+
+// validHeaderName reports whether name is a non-empty HTTP header token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateBackendTLS checks that a client certificate and key are set
+// together.
+func validateBackendTLS(section string, tls BackendTLSConfig) error {
+	if (tls.ClientCert == "") != (tls.ClientKey == "") {
+		return fmt.Errorf("invalid %s: client_cert and client_key must be set together", section)
+	}
+	return nil
+}
+
+// validModelCapabilities are the model capabilities Ollama reports
+var validModelCapabilities = map[string]bool{
+	"completion": true,
+	"tools":      true,
+	"insert":     true,
+	"vision":     true,
+	"embedding":  true,
+	"thinking":   true,
+}
+
+// validateCORSOrigin checks an allowed origin: "*", or a scheme and host
+// (optionally starting with "*." for any subdomain) with no path
+func validateCORSOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(origin, "*.", "wildcard.", 1))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return fmt.Errorf("invalid server.cors.allowed_origins: %q (must be \"*\" or an origin such as \"https://app.example.com\")", origin)
+	}
+	return nil
+}
+
+// validAccessRule reports whether rule is a CIDR range or an IP address
+func validAccessRule(rule string) bool {
+	rule = strings.TrimSpace(rule)
+	if strings.Contains(rule, "/") {
+		_, err := netip.ParsePrefix(rule)
+		return err == nil
+	}
+	_, err := netip.ParseAddr(rule)
+	return err == nil
+}
+
+// validateChatTextInjection checks the modes of a chat text injection section
+func validateChatTextInjection(section string, injection ChatTextInjectionConfig) error {
+	if injection.Mode != "" && injection.Mode != "first" && injection.Mode != "last" && injection.Mode != "system" {
+		return fmt.Errorf("invalid %s.mode: %s (must be 'first', 'last', or 'system')", section, injection.Mode)
+	}
+	switch injection.SystemPromptMode {
+	case "", "prepend", "append", "replace", "default":
+	default:
+		return fmt.Errorf("invalid %s.system_prompt_mode: %s (must be 'prepend', 'append', 'replace', or 'default')", section, injection.SystemPromptMode)
+	}
+	return nil
+}
+
+// validateProxy checks an outbound proxy setting: empty, "direct", or a URL
+// with a scheme http.Transport supports.
+func validateProxy(key string, proxy string) error {
+	if proxy == "" || proxy == "direct" {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid %s: %q (must be a proxy URL or \"direct\")", key, proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return nil
+	}
+	return fmt.Errorf("invalid %s: %q (scheme must be http, https, socks5 or socks5h)", key, proxy)
+}
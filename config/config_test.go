@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -136,6 +138,26 @@ endpoint = "http://localhost:8008"
 	}
 }
 
+func TestLoadStreamCoalesce(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[stream_coalesce]\nenabled = true\nflush_interval_ms = -1\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.StreamCoalesce.Enabled || cfg.StreamCoalesce.MinChars != 32 || cfg.StreamCoalesce.FlushIntervalMs != -1 {
+		t.Fatalf("StreamCoalesce = %+v", cfg.StreamCoalesce)
+	}
+	for _, coalesce := range []string{
+		"[stream_coalesce]\nmin_chars = -2\n",
+		"[stream_coalesce]\nflush_interval_ms = -5\n",
+		"[stream_coalesce]\nmin_chars = -1\nflush_interval_ms = -1\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+coalesce)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid stream_coalesce error", coalesce)
+		}
+	}
+}
+
 func TestLoadRejectsInvalidStreamOverrideMode(t *testing.T) {
 	path := writeTestConfig(t, `
 [backend]
@@ -172,8 +194,11 @@ endpoint = "http://localhost:11434"
 	if cfg.Server.Port != 11434 {
 		t.Fatalf("Server.Port = %d, want 11434", cfg.Server.Port)
 	}
-	if cfg.Backend.Timeout != 300 {
-		t.Fatalf("Backend.Timeout = %d, want 300", cfg.Backend.Timeout)
+	if cfg.Backend.Timeout != 0 {
+		t.Fatalf("Backend.Timeout = %d, want 0 (no cap)", cfg.Backend.Timeout)
+	}
+	if cfg.Backend.ConnectTimeout != 30 || cfg.Backend.ResponseHeaderTimeout != 300 || cfg.Backend.StreamIdleTimeout != 300 {
+		t.Fatalf("backend timeouts = (%d, %d, %d), want (30, 300, 300)", cfg.Backend.ConnectTimeout, cfg.Backend.ResponseHeaderTimeout, cfg.Backend.StreamIdleTimeout)
 	}
 	if cfg.Database.Path != "./llm_proxy.db" {
 		t.Fatalf("Database.Path = %q, want ./llm_proxy.db", cfg.Database.Path)
@@ -221,12 +246,1513 @@ endpoint = "http://localhost:8008"
 	}
 }
 
-func writeTestConfig(t *testing.T, content string) string {
-	t.Helper()
+func TestLoadSchedulerConfig(t *testing.T) {
+	path := writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "http://localhost:8008"
 
-	path := filepath.Join(t.TempDir(), "config.toml")
-	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+[scheduler]
+max_concurrent_requests = 2
+default_weight = 2
+max_queue_depth = 50
+queue_timeout = 30
+
+[scheduler.weights]
+"sk-interactive" = 5
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Scheduler.MaxConcurrentRequests != 2 || cfg.Scheduler.DefaultWeight != 2 || cfg.Scheduler.MaxQueueDepth != 50 || cfg.Scheduler.QueueTimeout != 30 {
+		t.Fatalf("Scheduler = %#v, want max 2, default weight 2 and queue limits 50 and 30", cfg.Scheduler)
+	}
+	if cfg.Scheduler.Weights["sk-interactive"] != 5 {
+		t.Fatalf("Scheduler.Weights = %#v, want sk-interactive=5", cfg.Scheduler.Weights)
+	}
+}
+
+func TestLoadDefaultsSchedulerDisabled(t *testing.T) {
+	path := writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "http://localhost:8008"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Scheduler.MaxConcurrentRequests != 0 {
+		t.Fatalf("Scheduler.MaxConcurrentRequests = %d, want 0", cfg.Scheduler.MaxConcurrentRequests)
+	}
+	if cfg.Scheduler.DefaultWeight != 1 {
+		t.Fatalf("Scheduler.DefaultWeight = %d, want 1", cfg.Scheduler.DefaultWeight)
+	}
+}
+
+func TestLoadRejectsInvalidSchedulerWeight(t *testing.T) {
+	path := writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "http://localhost:8008"
+
+[scheduler.weights]
+"sk-batch-user-key" = 0
+`)
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Load() error = nil, want error")
+	}
+	if !strings.Contains(err.Error(), "scheduler.weights") {
+		t.Fatalf("Load() error = %v, want scheduler.weights error", err)
+	}
+	if strings.Contains(err.Error(), "sk-batch-user-key") {
+		t.Fatalf("Load() error = %v, leaks the API key", err)
+	}
+}
+
+func TestLoadFederationSources(t *testing.T) {
+	path := writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "http://localhost:8008"
+
+[[federation.sources]]
+name = "gpu-box"
+type = "sqlite"
+path = "/srv/gpu-box/llm_proxy.db"
+
+[[federation.sources]]
+name = "laptop"
+type = "remote"
+url = "http://laptop:11434"
+timeout = 3
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.Federation.Sources) != 2 {
+		t.Fatalf("Federation.Sources = %d entries, want 2", len(cfg.Federation.Sources))
+	}
+	sqlite := cfg.Federation.Sources[0]
+	if sqlite.Name != "gpu-box" || sqlite.Type != "sqlite" || sqlite.Path != "/srv/gpu-box/llm_proxy.db" {
+		t.Fatalf("Federation.Sources[0] = %+v", sqlite)
+	}
+	if sqlite.Timeout != 10 {
+		t.Fatalf("Federation.Sources[0].Timeout = %d, want default 10", sqlite.Timeout)
+	}
+	remote := cfg.Federation.Sources[1]
+	if remote.Name != "laptop" || remote.Type != "remote" || remote.URL != "http://laptop:11434" || remote.Timeout != 3 {
+		t.Fatalf("Federation.Sources[1] = %+v", remote)
+	}
+}
+
+func TestLoadRejectsInvalidFederationSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources string
+		want    string
+	}{
+		{
+			name: "unknown type",
+			sources: `
+[[federation.sources]]
+name = "other"
+type = "postgres"
+`,
+			want: "federation.sources[0].type",
+		},
+		{
+			name: "missing path",
+			sources: `
+[[federation.sources]]
+name = "other"
+type = "sqlite"
+`,
+			want: "path is required",
+		},
+		{
+			name: "duplicate name",
+			sources: `
+[[federation.sources]]
+name = "other"
+type = "remote"
+url = "http://a"
+
+[[federation.sources]]
+name = "other"
+type = "remote"
+url = "http://b"
+`,
+			want: "duplicate name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "http://localhost:8008"
+`+tt.sources)
+
+			_, err := Load(path)
+			if err == nil {
+				t.Fatal("Load() error = nil, want error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmbeddingCacheDefaults(t *testing.T) {
+	path := writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "http://localhost:8008"
+
+[embedding_cache]
+enabled = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.EmbeddingCache.Enabled {
+		t.Fatal("EmbeddingCache.Enabled = false, want true")
+	}
+	if cfg.EmbeddingCache.MaxEntries != 10000 {
+		t.Fatalf("EmbeddingCache.MaxEntries = %d, want default 10000", cfg.EmbeddingCache.MaxEntries)
+	}
+}
+
+func TestLoadGeminiBackend(t *testing.T) {
+	path := writeTestConfig(t, `
+[backend]
+type = "gemini"
+
+[backend_gemini]
+api_key = "test-key"
+
+[[backend_gemini.safety_settings]]
+category = "HARM_CATEGORY_HARASSMENT"
+threshold = "BLOCK_ONLY_HIGH"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Backend.Endpoint != "https://generativelanguage.googleapis.com" {
+		t.Fatalf("Backend.Endpoint = %q, want Gemini default", cfg.Backend.Endpoint)
+	}
+	if cfg.BackendGemini.APIKey != "test-key" {
+		t.Fatalf("BackendGemini.APIKey = %q", cfg.BackendGemini.APIKey)
+	}
+	if len(cfg.BackendGemini.SafetySettings) != 1 || cfg.BackendGemini.SafetySettings[0].Threshold != "BLOCK_ONLY_HIGH" {
+		t.Fatalf("BackendGemini.SafetySettings = %+v", cfg.BackendGemini.SafetySettings)
+	}
+}
+
+func TestLoadFailoverBackends(t *testing.T) {
+	path := writeTestConfig(t, `
+[backend]
+type = "ollama"
+endpoint = "http://primary:11434"
+timeout = 120
+
+[[failover.backends]]
+type = "openai"
+endpoint = "http://fallback:8000"
+
+[[failover.backends]]
+type = "gemini"
+timeout = 30
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Failover.HealthCheckInterval != 30 {
+		t.Fatalf("Failover.HealthCheckInterval = %d, want default 30", cfg.Failover.HealthCheckInterval)
+	}
+	if len(cfg.Failover.Backends) != 2 {
+		t.Fatalf("Failover.Backends = %+v", cfg.Failover.Backends)
+	}
+	if cfg.Failover.Backends[0].Timeout != 120 {
+		t.Fatalf("Failover.Backends[0].Timeout = %d, want primary timeout", cfg.Failover.Backends[0].Timeout)
+	}
+	if cfg.Failover.Backends[1].Endpoint != "https://generativelanguage.googleapis.com" || cfg.Failover.Backends[1].Timeout != 30 {
+		t.Fatalf("Failover.Backends[1] = %+v", cfg.Failover.Backends[1])
+	}
+
+	path = writeTestConfig(t, `
+[backend]
+type = "ollama"
+
+[[failover.backends]]
+type = "ollama"
+`)
+	if _, err := Load(path); err == nil {
+		t.Fatal("Load() error = nil, want missing endpoint error")
+	}
+}
+
+func TestLoadVLLMBackend(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"vllm\"\nendpoint = \"http://vllm:8000\"\n\n[[failover.backends]]\ntype = \"vllm\"\nendpoint = \"http://vllm2:8000\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Backend.Type != "vllm" || cfg.Failover.Backends[0].Type != "vllm" {
+		t.Fatalf("backend types = %q, %q, want vllm", cfg.Backend.Type, cfg.Failover.Backends[0].Type)
+	}
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"vllm\"\n\n[[failover.backends]]\ntype = \"vllm\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want missing endpoint error")
+	}
+}
+
+func TestLoadKoboldCppBackend(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"koboldcpp\"\nendpoint = \"http://kobold:5001\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.BackendKoboldCpp.ChatTemplate != "chatml" || cfg.BackendKoboldCpp.MaxLength != 1024 {
+		t.Fatalf("BackendKoboldCpp = %+v, want the defaults", cfg.BackendKoboldCpp)
+	}
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"koboldcpp\"\nendpoint = \"http://kobold:5001\"\n\n[backend_koboldcpp]\nchat_template = \"vicuna\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want unknown chat_template error")
+	}
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"textgen\"\nendpoint = \"http://textgen:5000\"\n")); err != nil {
+		t.Fatalf("Load(textgen) error = %v", err)
+	}
+}
+
+func TestLoadOpenRouterBackend(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openrouter"
+
+[backend_openrouter]
+api_key = "sk-or-test"
+title = "My App"
+route = "fallback"
+
+[backend_openrouter.provider]
+order = ["anthropic", "openai"]
+allow_fallbacks = false
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Backend.Endpoint != "https://openrouter.ai/api" {
+		t.Fatalf("Backend.Endpoint = %q, want the OpenRouter API", cfg.Backend.Endpoint)
+	}
+	if cfg.BackendOpenRouter.Provider["allow_fallbacks"] != false || cfg.BackendOpenRouter.Route != "fallback" {
+		t.Fatalf("BackendOpenRouter = %+v", cfg.BackendOpenRouter)
+	}
+}
+
+func TestLoadOpenAIAPIKeySources(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "openai.key")
+	if err := os.WriteFile(keyPath, []byte("sk-from-file\n"), 0600); err != nil {
 		t.Fatalf("os.WriteFile() error = %v", err)
 	}
-	return path
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[backend_openai]
+api_key_file = "`+keyPath+`"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.BackendOpenAI.APIKey != "sk-from-file" {
+		t.Fatalf("APIKey = %q, want key from file", cfg.BackendOpenAI.APIKey)
+	}
+
+	t.Setenv("LLM_PROXY_TEST_OPENAI_KEY", "sk-from-env")
+	cfg, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[backend_openai]
+api_key_env = "LLM_PROXY_TEST_OPENAI_KEY"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.BackendOpenAI.APIKey != "sk-from-env" {
+		t.Fatalf("APIKey = %q, want key from env", cfg.BackendOpenAI.APIKey)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[backend_openai]
+api_key = "sk-inline"
+api_key_env = "LLM_PROXY_TEST_OPENAI_KEY"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want error for multiple key sources")
+	}
+}
+
+func TestLoadDatabaseEncryptionKey(t *testing.T) {
+	t.Setenv("LLM_PROXY_TEST_LOG_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "ollama"
+
+[database]
+encryption_key_env = "LLM_PROXY_TEST_LOG_KEY"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Database.EncryptionKey != "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=" {
+		t.Fatalf("EncryptionKey = %q, want key from env", cfg.Database.EncryptionKey)
+	}
+
+	for _, body := range []string{
+		`encryption_key = "c2hvcnQ="`,
+		`encryption_key = "not base64!"`,
+		"encryption_key = \"MDEyMzQ1Njc4OWFiY2RlZg==\"\nencryption_key_env = \"LLM_PROXY_TEST_LOG_KEY\"",
+		"encryption_key = \"MDEyMzQ1Njc4OWFiY2RlZg==\"\nmax_payload_size = 64\npayload_spill_dir = \"/tmp/spill\"",
+	} {
+		if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"ollama\"\n\n[database]\n"+body+"\n")); err == nil {
+			t.Fatalf("Load() error = nil for %q", body)
+		}
+	}
+}
+
+func TestLoadBackendHeaders(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "http://gateway:8787"
+
+[backend.headers]
+X-Portkey-Config = "pc-123"
+api-key = "azure-key"
+
+[[failover.backends]]
+type = "ollama"
+endpoint = "http://fallback:11434"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Backend.Headers["X-Portkey-Config"] != "pc-123" || cfg.Backend.Headers["api-key"] != "azure-key" {
+		t.Fatalf("Backend.Headers = %v", cfg.Backend.Headers)
+	}
+	if cfg.Failover.Backends[0].Headers["api-key"] != "azure-key" {
+		t.Fatalf("Failover.Backends[0].Headers = %v, want backend.headers", cfg.Failover.Backends[0].Headers)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[backend.headers]
+"Bad Header" = "x"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid header name error")
+	}
+}
+
+func TestLoadRetryDefaults(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "ollama"
+
+[retry]
+max_attempts = 3
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Retry.MaxAttempts != 3 || cfg.Retry.InitialBackoffMs != 500 || cfg.Retry.MaxBackoffMs != 10000 {
+		t.Fatalf("Retry = %+v", cfg.Retry)
+	}
+	if !reflect.DeepEqual(cfg.Retry.RetryOnStatus, []int{429, 502, 503, 504}) {
+		t.Fatalf("Retry.RetryOnStatus = %v", cfg.Retry.RetryOnStatus)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "ollama"
+
+[retry]
+retry_on_status = [200]
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid status code error")
+	}
+}
+
+func TestLoadRateLimitConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[rate_limit]
+enabled = true
+max_concurrent = 2
+max_queue_depth = 50
+queue_timeout = 30
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.RateLimit.Enabled || cfg.RateLimit.MaxConcurrent != 2 || cfg.RateLimit.MaxQueueDepth != 50 || cfg.RateLimit.MaxRetryAfter != 60 || cfg.RateLimit.QueueTimeout != 30 {
+		t.Fatalf("RateLimit = %+v", cfg.RateLimit)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[rate_limit]
+max_queue_depth = -1
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid max_queue_depth error")
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[rate_limit]
+queue_timeout = -1
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid queue_timeout error")
+	}
+}
+
+func TestLoadTokenCountingConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.TokenCounting.Method != "estimate" {
+		t.Fatalf("TokenCounting.Method = %q, want estimate", cfg.TokenCounting.Method)
+	}
+
+	cfg, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[token_counting]
+method = "off"
+
+[token_counting.models]
+"llama" = "tokenize"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.TokenCounting.Method != "off" || cfg.TokenCounting.Models["llama"] != "tokenize" {
+		t.Fatalf("TokenCounting = %+v", cfg.TokenCounting)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[token_counting.models]
+"llama" = "tiktoken:llama3"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid method error")
+	}
+
+	cfg, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[token_counting]
+method = "tiktoken"
+tiktoken_dir = "/srv/tiktoken"
+
+[token_counting.models]
+"qwen" = "tiktoken:o200k_base"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.TokenCounting.Method != "tiktoken" || cfg.TokenCounting.Models["qwen"] != "tiktoken:o200k_base" || cfg.TokenCounting.TiktokenDir != "/srv/tiktoken" {
+		t.Fatalf("TokenCounting = %+v", cfg.TokenCounting)
+	}
+}
+
+func TestLoadResponseCacheConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[response_cache]
+enabled = true
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.ResponseCache.Enabled || cfg.ResponseCache.Storage != "memory" || cfg.ResponseCache.TTL != 3600 || cfg.ResponseCache.MaxEntries != 1000 {
+		t.Fatalf("ResponseCache = %+v", cfg.ResponseCache)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[response_cache]
+storage = "redis"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid storage error")
+	}
+}
+
+func TestLoadModelCacheConfig(t *testing.T) {
+	base := "[backend]\ntype = \"openai\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[model_cache]\nenabled = true\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.ModelCache.Enabled || cfg.ModelCache.TTL != 10 {
+		t.Fatalf("ModelCache = %+v, want enabled with the default ttl", cfg.ModelCache)
+	}
+	if _, err := Load(writeTestConfig(t, base+"[model_cache]\nttl = -1\n")); err == nil {
+		t.Fatal("Load() with a negative ttl error = nil, want an error")
+	}
+}
+
+func TestLoadModelAliases(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[model_aliases]
+llama3 = "meta-llama/Meta-Llama-3-8B-Instruct"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ModelAliases["llama3"] != "meta-llama/Meta-Llama-3-8B-Instruct" {
+		t.Fatalf("ModelAliases = %v", cfg.ModelAliases)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[model_aliases]
+a = "b"
+b = "c"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want chained alias error")
+	}
+}
+
+func TestLoadContentFilters(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[[content_filters]]
+pattern = '[\w.+-]+@[\w-]+\.[\w.]+'
+replace = "[email]"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if filter := cfg.ContentFilters[0]; filter.Name != "content_filters[0]" || filter.Direction != "both" {
+		t.Fatalf("ContentFilters = %+v", cfg.ContentFilters)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[[content_filters]]
+pattern = "(unclosed"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid pattern error")
+	}
+}
+
+func TestLoadPIIRedactionConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[pii_redaction]
+enabled = true
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.PIIRedaction.Types) != 3 {
+		t.Fatalf("PIIRedaction.Types = %v, want all built-in types", cfg.PIIRedaction.Types)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[pii_redaction]
+types = ["ssn"]
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid type error")
+	}
+}
+
+func TestLoadRejectsIncompleteTLSConfig(t *testing.T) {
+	_, err := Load(writeTestConfig(t, `
+[server]
+tls_cert = "server.crt"
+
+[backend]
+type = "openai"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want tls_key error")
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[server]
+tls_cert = "server.crt"
+tls_key = "server.key"
+tls_self_signed = true
+
+[backend]
+type = "openai"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want tls_self_signed conflict error")
+	}
+}
+
+func TestLoadBackendTLSConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+endpoint = "https://vllm.internal"
+
+[backend.tls]
+ca_cert = "ca.pem"
+client_cert = "client.pem"
+client_key = "client-key.pem"
+
+[[failover.backends]]
+type = "ollama"
+endpoint = "http://backup:11434"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Failover.Backends[0].TLS != cfg.Backend.TLS {
+		t.Fatalf("failover TLS = %+v, want backend.tls %+v", cfg.Failover.Backends[0].TLS, cfg.Backend.TLS)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[backend.tls]
+client_cert = "client.pem"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want client_key error")
+	}
+}
+
+func TestLoadBackendProxy(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+proxy = "socks5://egress:1080"
+
+[[failover.backends]]
+type = "ollama"
+endpoint = "http://backup:11434"
+
+[[failover.backends]]
+type = "ollama"
+endpoint = "http://local:11434"
+proxy = "direct"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Failover.Backends[0].Proxy != "socks5://egress:1080" || cfg.Failover.Backends[1].Proxy != "direct" {
+		t.Fatalf("failover proxies = %q, %q", cfg.Failover.Backends[0].Proxy, cfg.Failover.Backends[1].Proxy)
+	}
+
+	_, err = Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+proxy = "egress:1080"
+`))
+	if err == nil {
+		t.Fatal("Load() error = nil, want invalid proxy error")
+	}
+}
+
+func TestReloadAppliesTunableSettings(t *testing.T) {
+	path := writeTestConfig(t, `
+[server]
+port = 11434
+
+[backend]
+type = "openai"
+
+[chat_text_injection]
+enabled = true
+text = "/nothink"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`
+[server]
+port = 8080
+verbose = true
+
+[backend]
+type = "openai"
+tool_blacklist = ["web_search"]
+
+[chat_text_injection]
+enabled = true
+text = "/think"
+
+[model_aliases]
+llama3 = "meta-llama/Meta-Llama-3-8B-Instruct"
+`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	restartRequired, err := cfg.Reload(path)
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	current := cfg.Current()
+	if current.ChatTextInjection.Text != "/think" || !current.Server.Verbose || len(current.Backend.ToolBlacklist) != 1 || len(current.ModelAliases) != 1 {
+		t.Fatalf("tunable settings were not reloaded: %+v", current)
+	}
+	if current.Server.Port != 11434 {
+		t.Fatalf("Server.Port = %d, want the running value 11434", current.Server.Port)
+	}
+	if len(restartRequired) != 1 || restartRequired[0] != "server" {
+		t.Fatalf("restartRequired = %v, want [server]", restartRequired)
+	}
+
+	// An invalid file keeps the current settings
+	if err := os.WriteFile(path, []byte("[backend]\ntype = \"bogus\"\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := cfg.Reload(path); err == nil {
+		t.Fatal("Reload() error = nil, want invalid backend type error")
+	}
+	if cfg.Current() != current {
+		t.Fatal("failed reload replaced the configuration")
+	}
+}
+
+func TestLoadAppliesEnvironmentOverrides(t *testing.T) {
+	t.Setenv("LLM_PROXY_SERVER_PORT", "8080")
+	t.Setenv("LLM_PROXY_BACKEND_ENDPOINT", "http://vllm:8000")
+	t.Setenv("LLM_PROXY_BACKEND_TOOL_BLACKLIST", "web_search, execute_code")
+	t.Setenv("LLM_PROXY_BACKEND_TLS_INSECURE_SKIP_VERIFY", "true")
+	t.Setenv("LLM_PROXY_RETRY_RETRY_ON_STATUS", "502,503")
+	t.Setenv("LLM_PROXY_SHADOW_SAMPLE_RATE", "0.25")
+
+	cfg, err := Load(writeTestConfig(t, `
+[server]
+port = 11434
+
+[backend]
+type = "openai"
+endpoint = "http://localhost:8008"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.Port != 8080 || cfg.Backend.Endpoint != "http://vllm:8000" || !cfg.Backend.TLS.InsecureSkipVerify {
+		t.Fatalf("overrides not applied: server = %+v, backend = %+v", cfg.Server, cfg.Backend)
+	}
+	if !reflect.DeepEqual(cfg.Backend.ToolBlacklist, []string{"web_search", "execute_code"}) {
+		t.Fatalf("ToolBlacklist = %q", cfg.Backend.ToolBlacklist)
+	}
+	if !reflect.DeepEqual(cfg.Retry.RetryOnStatus, []int{502, 503}) {
+		t.Fatalf("RetryOnStatus = %v", cfg.Retry.RetryOnStatus)
+	}
+	if cfg.Shadow.SampleRate != 0.25 {
+		t.Fatalf("Shadow.SampleRate = %g, want 0.25", cfg.Shadow.SampleRate)
+	}
+
+	t.Setenv("LLM_PROXY_SHADOW_SAMPLE_RATE", "a quarter")
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid number error")
+	}
+	t.Setenv("LLM_PROXY_SHADOW_SAMPLE_RATE", "0.25")
+
+	t.Setenv("LLM_PROXY_SERVER_PORT", "eighty")
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid integer error")
+	}
+}
+
+func TestEnvOverridesSetOptionalValues(t *testing.T) {
+	var settings struct {
+		Rate  *float64 `toml:"rate"`
+		Limit *int     `toml:"limit"`
+		Unset *bool    `toml:"unset"`
+	}
+	fields := make(map[string]reflect.Value)
+	collectEnvFields(reflect.ValueOf(&settings).Elem(), envPrefix, fields)
+
+	if err := setEnvValue(fields["LLM_PROXY_RATE"], "0.5"); err != nil {
+		t.Fatalf("setEnvValue(rate) error = %v", err)
+	}
+	if err := setEnvValue(fields["LLM_PROXY_LIMIT"], "3"); err != nil {
+		t.Fatalf("setEnvValue(limit) error = %v", err)
+	}
+	if settings.Rate == nil || *settings.Rate != 0.5 || settings.Limit == nil || *settings.Limit != 3 || settings.Unset != nil {
+		t.Fatalf("settings = %+v", settings)
+	}
+	if err := setEnvValue(fields["LLM_PROXY_LIMIT"], "three"); err == nil {
+		t.Fatal("setEnvValue(limit) error = nil, want invalid integer error")
+	}
+}
+
+func TestLoadShutdownTimeout(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.ShutdownTimeout != 30 {
+		t.Fatalf("ShutdownTimeout = %d, want 30", cfg.Server.ShutdownTimeout)
+	}
+
+	if _, err := Load(writeTestConfig(t, "[server]\nshutdown_timeout = -2\n\n[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid shutdown_timeout error")
+	}
+}
+
+func TestLoadKeepAliveInterval(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[server]\nkeep_alive_interval = 15\n\n[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.KeepAliveInterval != 15 {
+		t.Fatalf("KeepAliveInterval = %d, want 15", cfg.Server.KeepAliveInterval)
+	}
+
+	if _, err := Load(writeTestConfig(t, "[server]\nkeep_alive_interval = -1\n\n[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid keep_alive_interval error")
+	}
+}
+
+func TestLoadFlushMode(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.FlushMode != "chunk" || cfg.Server.FlushIntervalMs != 50 || cfg.Server.Nagle {
+		t.Fatalf("Server = %+v, want flush defaults", cfg.Server)
+	}
+
+	for _, server := range []string{
+		"[server]\nflush_mode = \"sometimes\"\n",
+		"[server]\nflush_mode = \"timed\"\nflush_interval_ms = -1\n",
+	} {
+		if _, err := Load(writeTestConfig(t, server+"\n[backend]\ntype = \"openai\"\n")); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid flush error", server)
+		}
+	}
+}
+
+func TestLoadCORSConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cors := cfg.Server.CORS; len(cors.AllowedOrigins) != 1 || cors.AllowedOrigins[0] != "*" || len(cors.AllowedMethods) != 5 || cors.MaxAge != 3600 || cors.AllowCredentials {
+		t.Fatalf("CORS defaults = %+v", cors)
+	}
+
+	cfg, err = Load(writeTestConfig(t, `
+[server]
+enable_cors = true
+
+[server.cors]
+allowed_origins = ["https://app.example.com", "https://*.example.org"]
+allowed_methods = ["POST"]
+allow_credentials = true
+max_age = -1
+
+[backend]
+type = "openai"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cors := cfg.Server.CORS; len(cors.AllowedOrigins) != 2 || len(cors.AllowedMethods) != 1 || len(cors.AllowedHeaders) != 3 || !cors.AllowCredentials || cors.MaxAge != -1 {
+		t.Fatalf("CORS = %+v", cors)
+	}
+
+	for _, origin := range []string{"app.example.com", "https://app.example.com/path", "ftp://example.com"} {
+		_, err := Load(writeTestConfig(t, fmt.Sprintf("[server.cors]\nallowed_origins = [%q]\n\n[backend]\ntype = \"openai\"\n", origin)))
+		if err == nil {
+			t.Errorf("Load() with origin %q error = nil, want invalid allowed_origins error", origin)
+		}
+	}
+
+	for _, origins := range []string{"", "allowed_origins = [\"*\"]\n", "allowed_origins = [\"https://app.example.com\", \"*\"]\n"} {
+		_, err := Load(writeTestConfig(t, "[server.cors]\n"+origins+"allow_credentials = true\n\n[backend]\ntype = \"openai\"\n"))
+		if err == nil {
+			t.Errorf("Load() with credentials and %q error = nil, want invalid allow_credentials error", origins)
+		}
+	}
+}
+
+func TestLoadAccessConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[server.access]
+allow = ["192.168.1.0/24", "127.0.0.1", "::1"]
+deny = ["192.168.1.13"]
+log_denied = true
+
+[backend]
+type = "openai"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if access := cfg.Server.Access; len(access.Allow) != 3 || len(access.Deny) != 1 || !access.LogDenied {
+		t.Fatalf("Access = %+v", access)
+	}
+
+	_, err = Load(writeTestConfig(t, "[server.access]\ndeny = [\"192.168.1\"]\n\n[backend]\ntype = \"openai\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "server.access.deny") {
+		t.Fatalf("Load() error = %v, want invalid server.access.deny error", err)
+	}
+}
+
+func TestLoadAdminListener(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[server]\nadmin_port = 8080\n\n[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.AdminHost != "127.0.0.1" || cfg.Server.AdminPort != 8080 {
+		t.Fatalf("admin listener = %s:%d, want 127.0.0.1:8080", cfg.Server.AdminHost, cfg.Server.AdminPort)
+	}
+
+	if _, err := Load(writeTestConfig(t, "[server]\nhost = \"127.0.0.1\"\nadmin_port = 11434\n\n[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want error for admin_port equal to port")
+	}
+	if _, err := Load(writeTestConfig(t, "[server]\nadmin_port = 70000\n\n[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid admin_port error")
+	}
+	if _, err := Load(writeTestConfig(t, "[server]\nport = 70000\n\n[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid port error")
+	}
+	if _, err := Load(writeTestConfig(t, "[server]\nadmin_api_keys = [\"k1\"]\n\n[backend]\ntype = \"openai\"\n\n[[tenants]]\nname = \"a\"\napi_keys = [\"k1\"]\n")); err == nil {
+		t.Fatal("Load() error = nil, want error for an admin key that is a tenant's")
+	}
+}
+
+func TestLoadTokenBudgetConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[token_budget]\nmax_message_chars = 24000\n\n[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if b := cfg.TokenBudget; b.MaxMessageChars != 24000 || b.Action != "reject" || b.Strategy != "drop_oldest" {
+		t.Fatalf("TokenBudget = %+v, want defaults action reject, strategy drop_oldest", b)
+	}
+
+	for _, setting := range []string{`action = "trim"`, `strategy = "newest"`, `max_tokens = -1`} {
+		_, err := Load(writeTestConfig(t, "[token_budget]\n"+setting+"\n\n[backend]\ntype = \"openai\"\n"))
+		if err == nil || !strings.Contains(err.Error(), "token_budget.") {
+			t.Fatalf("Load(%s) error = %v, want invalid token_budget error", setting, err)
+		}
+	}
+}
+
+func TestLoadContextTrimConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[context_trim]\nenabled = true\n\n[context_trim.context_sizes]\n\"llama3\" = 8192\n\"*\" = 32768\n\n[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	c := cfg.ContextTrim
+	if !c.Enabled || c.ContextSizes["llama3"] != 8192 || c.ContextSizes["*"] != 32768 {
+		t.Fatalf("ContextTrim = %+v", c)
+	}
+	if c.ReserveTokens != 1024 || c.Mode != "drop" || c.Strategy != "drop_oldest" || c.SummaryTokens != 512 {
+		t.Fatalf("ContextTrim = %+v, want defaults reserve 1024, mode drop, strategy drop_oldest, summary 512", c)
+	}
+
+	for _, setting := range []string{`mode = "shrink"`, `strategy = "newest"`, `reserve_tokens = -1`, "[context_trim.context_sizes]\nllama3 = 0"} {
+		_, err := Load(writeTestConfig(t, "[context_trim]\n"+setting+"\n\n[backend]\ntype = \"openai\"\n"))
+		if err == nil || !strings.Contains(err.Error(), "context_trim.") {
+			t.Fatalf("Load(%s) error = %v, want invalid context_trim error", setting, err)
+		}
+	}
+}
+
+func TestLoadToolsConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[tools]
+mode = "whitelist"
+tools = ["read_file"]
+
+[tools.models."qwen2:0.5b"]
+mode = "strip"
+
+[tools.models.coder]
+tools = ["shell"]
+
+[[tools.rewrites]]
+name = "shell"
+append_description = "Use bash syntax."
+
+[tools.rewrites.parameters]
+command = "The command line."
+
+[backend]
+type = "openai"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Tools.Mode != "whitelist" || cfg.Tools.Models["qwen2:0.5b"].Mode != "strip" || cfg.Tools.Models["coder"].Mode != "whitelist" {
+		t.Fatalf("Tools = %+v, want model modes strip and whitelist (inherited)", cfg.Tools)
+	}
+	if r := cfg.Tools.Rewrites; len(r) != 1 || r[0].AppendDescription != "Use bash syntax." || r[0].Parameters["command"] != "The command line." {
+		t.Fatalf("Rewrites = %+v", r)
+	}
+
+	for _, setting := range []string{
+		`mode = "allow"`,
+		"[tools.models.m]\nmode = \"none\"",
+		"[[tools.rewrites]]\ndescription = \"no name\"",
+		"[[tools.rewrites]]\nname = \"shell\"",
+	} {
+		_, err := Load(writeTestConfig(t, "[tools]\n"+setting+"\n\n[backend]\ntype = \"openai\"\n"))
+		if err == nil || !strings.Contains(err.Error(), "tools.") {
+			t.Fatalf("Load(%s) error = %v, want invalid tools error", setting, err)
+		}
+	}
+}
+
+func TestLoadResponseTransformsConfig(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[[response_transforms]]
+type = "relocate_think"
+
+[[response_transforms]]
+type = "regex"
+pattern = 'foo\d+'
+replace = "bar"
+
+[backend]
+type = "openai"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.ResponseTransforms; len(got) != 2 || got[0].Type != "relocate_think" || got[1].Pattern != `foo\d+` {
+		t.Fatalf("ResponseTransforms = %+v", got)
+	}
+
+	for _, step := range []string{`type = "uppercase"`, `type = "regex"`, "type = \"regex\"\npattern = '('"} {
+		_, err := Load(writeTestConfig(t, "[[response_transforms]]\n"+step+"\n\n[backend]\ntype = \"openai\"\n"))
+		if err == nil || !strings.Contains(err.Error(), "response_transforms[0]") {
+			t.Fatalf("Load(%s) error = %v, want invalid response_transforms error", step, err)
+		}
+	}
+}
+
+func TestLoadNotificationsConfig(t *testing.T) {
+	t.Setenv("LLM_PROXY_NOTIFICATIONS_COST", "2.5")
+
+	cfg, err := Load(writeTestConfig(t, `
+[notifications]
+webhook_url = "https://hooks.slack.com/services/T000/B000/XXXX"
+on_backend_failure = true
+error_rate = 20
+
+[backend]
+type = "openai"
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	n := cfg.Notifications
+	if !n.OnBackendFailure || n.ErrorRate != 20 || n.Cost != 2.5 || n.LatencyMs != 0 {
+		t.Fatalf("Notifications = %+v", n)
+	}
+	if n.Window != 300 || n.MinRequests != 10 || n.Timeout != 10 {
+		t.Fatalf("defaults = window %d, min_requests %d, timeout %d, want 300, 10, 10", n.Window, n.MinRequests, n.Timeout)
+	}
+
+	for _, tc := range []struct {
+		setting string
+		key     string
+	}{
+		{`webhook_url = "hooks.slack.com/services/x"`, "notifications.webhook_url"},
+		{`public_url = "ftp://proxy"`, "notifications.public_url"},
+		{`error_rate = 150`, "notifications.error_rate"},
+		{`latency_ms = -1`, "notifications.latency_ms"},
+	} {
+		_, err := Load(writeTestConfig(t, "[notifications]\n"+tc.setting+"\n\n[backend]\ntype = \"openai\"\n"))
+		if err == nil || !strings.Contains(err.Error(), tc.key) {
+			t.Fatalf("Load(%s) error = %v, want invalid %s error", tc.setting, err, tc.key)
+		}
+	}
+}
+
+func TestLoadMaxRequestBodySize(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.MaxRequestBodySize != 32 {
+		t.Fatalf("MaxRequestBodySize = %d, want default 32", cfg.Server.MaxRequestBodySize)
+	}
+
+	cfg, err = Load(writeTestConfig(t, "[server]\nmax_request_body_size = -1\n\n[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Server.MaxRequestBodySize != -1 {
+		t.Fatalf("MaxRequestBodySize = %d, want -1", cfg.Server.MaxRequestBodySize)
+	}
+
+	if _, err := Load(writeTestConfig(t, "[server]\nmax_request_body_size = -2\n\n[backend]\ntype = \"openai\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid max_request_body_size error")
+	}
+}
+
+func TestLoadMaxLineSize(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Backend.MaxLineSize != 16*1024 {
+		t.Fatalf("MaxLineSize = %d, want default 16384", cfg.Backend.MaxLineSize)
+	}
+
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\nmax_line_size = -1\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid max_line_size error")
+	}
+}
+
+func TestLoadPricing(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, `
+[backend]
+type = "openai"
+
+[pricing."gpt-4o"]
+prompt = 2.5
+cached_prompt = 1.25
+completion = 10
+
+[pricing."*"]
+prompt = 1
+completion = 2
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if price := cfg.Pricing["gpt-4o"]; price.Prompt != 2.5 || price.CachedPrompt == nil || *price.CachedPrompt != 1.25 || price.Completion != 10 {
+		t.Fatalf("gpt-4o price = %+v", price)
+	}
+	if price := cfg.Pricing["*"]; price.Prompt != 1 || price.CachedPrompt != nil {
+		t.Fatalf("default price = %+v", price)
+	}
+
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"openai\"\n\n[pricing.x]\nprompt = -1\n")); err == nil {
+		t.Fatal("Load() error = nil, want negative price error")
+	}
+}
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestLoadWarmup(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"ollama\"\n\n[warmup]\nmodels = [\"llama\"]\nkeep_alive = \"-1\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Warmup.Timeout != 300 || cfg.Warmup.KeepAlive != "-1" {
+		t.Fatalf("Warmup = %+v, want default timeout", cfg.Warmup)
+	}
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"ollama\"\n\n[warmup]\nkeep_alive = \"forever\"\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid keep_alive error")
+	}
+}
+
+func TestLoadBackendMock(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"mock\"\n\n[backend_mock]\nerror_rate = 0.5\n\n[[backend_mock.scenarios]]\nmatch = \"weather\"\ntool_calls = [{ name = \"get_weather\", arguments = { city = \"Paris\" } }]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Backend.Endpoint != "mock://" || cfg.BackendMock.ErrorStatus != 500 || len(cfg.BackendMock.Models) != 1 {
+		t.Fatalf("Backend = %+v, BackendMock = %+v; want defaults", cfg.Backend, cfg.BackendMock)
+	}
+	if calls := cfg.BackendMock.Scenarios[0].ToolCalls; len(calls) != 1 || calls[0].Arguments["city"] != "Paris" {
+		t.Fatalf("tool calls = %+v", calls)
+	}
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"mock\"\n\n[backend_mock]\nerror_rate = 2.0\n")); err == nil {
+		t.Fatal("Load() error = nil, want invalid error_rate error")
+	}
+}
+
+func TestLoadHooks(t *testing.T) {
+	cfg, err := Load(writeTestConfig(t, "[backend]\ntype = \"ollama\"\n\n[[hooks]]\ncommand = [\"/usr/local/bin/hook\", \"-v\"]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if hook := cfg.Hooks[0]; hook.Name != "hooks[0]" || hook.Stage != "request" || hook.Timeout != 10 || hook.OnError != "fail" {
+		t.Fatalf("Hooks[0] = %+v, want defaults", hook)
+	}
+	if _, err := Load(writeTestConfig(t, "[backend]\ntype = \"ollama\"\n\n[[hooks]]\ncommand = []\n")); err == nil {
+		t.Fatal("Load() error = nil, want missing command error")
+	}
+}
+
+func TestLoadRoutes(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n[[failover.backends]]\nname = \"big\"\ntype = \"ollama\"\nendpoint = \"http://b\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[[routes]]\nmodel = '^code'\nbackend = \"big\"\n\n[[routes]]\nheaders = { X-Team = \"guests\" }\nreject = 403\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Routes[0].Name != "routes[0]" || cfg.Routes[1].RejectMessage != "request rejected by route routes[1]" {
+		t.Fatalf("Routes = %+v, want defaults", cfg.Routes)
+	}
+	for _, route := range []string{
+		"[[routes]]\nbackend = \"missing\"\n",
+		"[[routes]]\nmodel = \"(\"\nset_model = \"x\"\n",
+		"[[routes]]\nmodel = \"x\"\n",
+		"[[routes]]\nreject = 403\nbackend = \"big\"\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+route)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid route error", route)
+		}
+	}
+}
+
+func TestLoadModelAggregation(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n[[failover.backends]]\nname = \"big\"\ntype = \"ollama\"\nendpoint = \"http://b\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[model_aggregation]\nenabled = true\n\n[model_aggregation.prefixes]\nbig = \"big/\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	agg := cfg.ModelAggregation
+	if len(agg.Backends) != 2 || agg.Backends[0] != "primary" || agg.Backends[1] != "big" || agg.OnConflict != "first" || agg.Prefixes["big"] != "big/" {
+		t.Fatalf("ModelAggregation = %+v, want every backend and the defaults", agg)
+	}
+	for _, aggregation := range []string{
+		"[model_aggregation]\nbackends = [\"missing\"]\n",
+		"[model_aggregation]\non_conflict = \"merge\"\n",
+		"[model_aggregation.prefixes]\nmissing = \"m/\"\n",
+		"[model_aggregation.prefixes]\nprimary = \"x/\"\nbig = \"x/\"\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+aggregation)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid model_aggregation error", aggregation)
+		}
+	}
+}
+
+func TestLoadRace(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n[[failover.backends]]\nname = \"big\"\ntype = \"ollama\"\nendpoint = \"http://b\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[race]\nenabled = true\nbackends = [\"primary\", \"big\"]\nmodels = [\"llama3\"]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.Race.Enabled || len(cfg.Race.Backends) != 2 || len(cfg.Race.Models) != 1 {
+		t.Fatalf("Race = %+v", cfg.Race)
+	}
+	for _, race := range []string{
+		"[race]\nenabled = true\nbackends = [\"primary\"]\n",
+		"[race]\nenabled = true\nbackends = [\"primary\", \"missing\"]\n",
+		"[race]\nenabled = true\nbackends = [\"big\", \"big\"]\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+race)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid race error", race)
+		}
+	}
+}
+
+func TestLoadLoadBalance(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n[[failover.backends]]\nname = \"gpu2\"\ntype = \"ollama\"\nendpoint = \"http://b\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[load_balance]\nenabled = true\nbackends = [\"primary\", \"gpu2\"]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.LoadBalance.Enabled || len(cfg.LoadBalance.Backends) != 2 || cfg.LoadBalance.KeepAlive != "5m" {
+		t.Fatalf("LoadBalance = %+v", cfg.LoadBalance)
+	}
+	for _, balance := range []string{
+		"[load_balance]\nenabled = true\nbackends = [\"primary\"]\n",
+		"[load_balance]\nenabled = true\nbackends = [\"primary\", \"missing\"]\n",
+		"[load_balance]\nenabled = true\nbackends = [\"gpu2\", \"gpu2\"]\n",
+		"[load_balance]\nenabled = true\nbackends = [\"primary\", \"gpu2\"]\nkeep_alive = \"soon\"\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+balance)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid load_balance error", balance)
+		}
+	}
+}
+
+func TestLoadShadow(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\ntimeout = 60\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[shadow]\nenabled = true\ntype = \"mock\"\nmodel = \"candidate\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Shadow.Endpoint != "mock://" || cfg.Shadow.SampleRate != 1 || cfg.Shadow.Timeout != 60 {
+		t.Fatalf("Shadow = %+v, want defaults", cfg.Shadow)
+	}
+	for _, shadow := range []string{
+		"[shadow]\nenabled = true\ntype = \"ollama\"\n",
+		"[shadow]\nenabled = true\ntype = \"other\"\nendpoint = \"http://b\"\n",
+		"[shadow]\nenabled = true\ntype = \"mock\"\nsample_rate = 1.5\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+shadow)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid shadow error", shadow)
+		}
+	}
+}
+
+func TestLoadBackendOverride(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n[[failover.backends]]\nname = \"big\"\ntype = \"ollama\"\nendpoint = \"http://b\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[backend_override]\nenabled = true\nbackends = [\"primary\", \"big\"]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.BackendOverride.Header != "X-LLM-Proxy-Backend" {
+		t.Fatalf("Header = %q, want the default", cfg.BackendOverride.Header)
+	}
+	for _, override := range []string{
+		"[backend_override]\nbackends = [\"missing\"]\n",
+		"[backend_override]\nheader = \"Bad Header\"\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+override)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid backend_override error", override)
+		}
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n[[failover.backends]]\nname = \"big\"\ntype = \"ollama\"\nendpoint = \"http://b\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[[tenants]]\nname = \"team-a\"\napi_keys = [\"key-a\"]\nbackend = \"big\"\n\n[tenants.chat_text_injection]\nenabled = true\ntext = \"Be brief.\"\n\n[[tenants]]\nname = \"team-b\"\npath_prefix = \"/team-b\"\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if injection := cfg.Tenant("team-a").ChatTextInjection; injection == nil || injection.Mode != "last" || injection.SystemPromptMode != "prepend" {
+		t.Fatalf("team-a chat_text_injection = %+v, want defaults", injection)
+	}
+	if cfg.Tenant("team-b").ChatTextInjection != nil || cfg.Tenant("missing") != nil || cfg.Tenant("") != nil {
+		t.Fatal("Tenant() found a tenant or injection that isn't configured")
+	}
+	for _, tenant := range []string{
+		"[[tenants]]\napi_keys = [\"k\"]\n",
+		"[[tenants]]\nname = \"a\"\n",
+		"[[tenants]]\nname = \"a\"\napi_keys = [\"k\"]\n\n[[tenants]]\nname = \"b\"\napi_keys = [\"k\"]\n",
+		"[[tenants]]\nname = \"a\"\npath_prefix = \"team-a/\"\n",
+		"[[tenants]]\nname = \"a\"\napi_keys = [\"k\"]\nbackend = \"missing\"\n",
+		"[[tenants]]\nname = \"a\"\napi_keys = [\"k\"]\n\n[tenants.chat_text_injection]\nmode = \"middle\"\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+tenant)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid tenant error", tenant)
+		}
+	}
+}
+
+func TestLoadOpenAIModels(t *testing.T) {
+	base := "[backend]\ntype = \"openai\"\nendpoint = \"http://a\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[backend_openai.models.\"qwen3-coder\"]\ncontext_length = 65536\ncapabilities = [\"completion\", \"tools\"]\n\n[backend_openai.models.\"*\"]\ncapabilities = [\"completion\"]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if model := cfg.BackendOpenAI.Models["qwen3-coder"]; model.ContextLength != 65536 || len(model.Capabilities) != 2 {
+		t.Fatalf("models = %+v", cfg.BackendOpenAI.Models)
+	}
+	for _, models := range []string{
+		"[backend_openai.models.a]\ncontext_length = -1\n",
+		"[backend_openai.models.a]\ncapabilities = [\"flying\"]\n",
+		"[backend_openai]\nstatic_models = true\n\n[backend_openai.models.\"*\"]\ncontext_length = 4096\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+models)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid model error", models)
+		}
+	}
+}
+
+func TestLoadModelAccess(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[model_access]\nallow = [\"llama3.*\"]\ndeny = [\".*:70b\"]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.ModelAccess.Allow) != 1 || len(cfg.ModelAccess.Deny) != 1 {
+		t.Fatalf("model_access = %+v", cfg.ModelAccess)
+	}
+	if _, err := Load(writeTestConfig(t, base+"[model_access]\ndeny = [\"(\"]\n")); err == nil {
+		t.Fatal("Load() with an invalid pattern error = nil, want an error")
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	base := "[backend]\ntype = \"ollama\"\nendpoint = \"http://a\"\n\n"
+	cfg, err := Load(writeTestConfig(t, base+"[users.api_keys]\nalice = [\"key-a\", \"key-a2\"]\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Users.Header != "X-User" || len(cfg.Users.APIKeys["alice"]) != 2 {
+		t.Fatalf("users = %+v, want the default header and alice's keys", cfg.Users)
+	}
+	for _, users := range []string{
+		"[users]\nheader = \"X User\"\n",
+		"[users.api_keys]\nalice = [\"k\"]\nbob = [\"k\"]\n",
+		"[users.api_keys]\nalice = [\"\"]\n",
+	} {
+		if _, err := Load(writeTestConfig(t, base+users)); err == nil {
+			t.Fatalf("Load(%q) error = nil, want an invalid users error", users)
+		}
+	}
 }
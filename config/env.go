@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the name of every environment variable override.
+const envPrefix = "LLM_PROXY_"
+
+// applyEnvOverrides sets config values from environment variables named
+// LLM_PROXY_<SECTION>_<KEY> (e.g. LLM_PROXY_SERVER_PORT or
+// LLM_PROXY_BACKEND_TLS_CA_CERT). environ is in os.Environ() form. Strings,
+// numbers, booleans, optional values of those and lists (comma-separated)
+// can be overridden; tables such as backend.headers and arrays of tables
+// cannot. Variables with the
+// prefix that match no key are ignored, since they may hold values such as
+// an API key read through backend_openai.api_key_env.
+func applyEnvOverrides(config *Config, environ []string) error {
+	fields := make(map[string]reflect.Value)
+	collectEnvFields(reflect.ValueOf(config).Elem(), envPrefix, fields)
+
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(field, value); err != nil {
+			return fmt.Errorf("invalid environment variable %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// collectEnvFields records every overridable field of v under its variable
+// name.
+func collectEnvFields(v reflect.Value, prefix string, fields map[string]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("toml")
+		if !field.IsExported() || key == "" {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		switch kind := field.Type.Kind(); {
+		case kind == reflect.Struct:
+			collectEnvFields(v.Field(i), name+"_", fields)
+		case (kind == reflect.Slice || kind == reflect.Pointer) && isEnvScalar(field.Type.Elem().Kind()):
+			fields[name] = v.Field(i)
+		case isEnvScalar(kind):
+			fields[name] = v.Field(i)
+		}
+	}
+}
+
+func isEnvScalar(kind reflect.Kind) bool {
+	return kind == reflect.String || kind == reflect.Int || kind == reflect.Float64 || kind == reflect.Bool
+}
+
+// setEnvValue parses value into field. Lists are comma-separated.
+func setEnvValue(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Pointer {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setEnvScalar(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	if field.Kind() != reflect.Slice {
+		return setEnvScalar(field, value)
+	}
+	var parts []string
+	if strings.TrimSpace(value) != "" {
+		parts = strings.Split(value, ",")
+	}
+	list := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+	for i, part := range parts {
+		if err := setEnvScalar(list.Index(i), strings.TrimSpace(part)); err != nil {
+			return err
+		}
+	}
+	field.Set(list)
+	return nil
+}
+
+func setEnvScalar(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Current returns the latest configuration. For a configuration returned by
+// Load this is the most recent successful Reload (or c itself); a Config
+// built any other way always returns itself.
+func (c *Config) Current() *Config {
+	if c.live == nil {
+		return c
+	}
+	return c.live.Load()
+}
+
+// Reload reads path again and applies the settings that can change while the
+// proxy is running: the server logging flags, keep-alive interval and request
+// body size limit, chat text injection, tool blacklist and tool policies,
+// request sanitization, token budget, stream override and model aliases. It
+// returns the names of other sections that changed in the file; those keep
+// their running values until a restart. On error the current configuration
+// is kept.
+func (c *Config) Reload(path string) (restartRequired []string, err error) {
+	loaded, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *c.Current()
+	next.Server.LogMessages = loaded.Server.LogMessages
+	next.Server.LogRawRequests = loaded.Server.LogRawRequests
+	next.Server.LogRawResponses = loaded.Server.LogRawResponses
+	next.Server.Verbose = loaded.Server.Verbose
+	next.Server.KeepAliveInterval = loaded.Server.KeepAliveInterval
+	next.Server.MaxRequestBodySize = loaded.Server.MaxRequestBodySize
+	next.Backend.ToolBlacklist = loaded.Backend.ToolBlacklist
+	next.Tools = loaded.Tools
+	next.ChatTextInjection = loaded.ChatTextInjection
+	next.RequestSanitization = loaded.RequestSanitization
+	next.TokenBudget = loaded.TokenBudget
+	next.StreamOverride = loaded.StreamOverride
+	next.ModelAliases = loaded.ModelAliases
+
+	// Whatever still differs only takes effect on restart
+	loaded.live = next.live
+	nextValue := reflect.ValueOf(next)
+	loadedValue := reflect.ValueOf(*loaded)
+	for i := 0; i < nextValue.NumField(); i++ {
+		field := nextValue.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(nextValue.Field(i).Interface(), loadedValue.Field(i).Interface()) {
+			restartRequired = append(restartRequired, field.Tag.Get("toml"))
+		}
+	}
+
+	if c.live == nil {
+		c.live = new(atomic.Pointer[Config])
+		next.live = c.live
+	}
+	c.live.Store(&next)
+	return restartRequired, nil
+}
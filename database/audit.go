@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// AuditEntry records an administrative action: a deletion from the log, a
+// replayed request, a config reload or a model management request
+type AuditEntry struct {
+	ID        int64
+	Timestamp time.Time
+	Actor     string // Who: the user, else the client IP, or e.g. "signal" for actions not made over HTTP
+	Action    string // What, e.g. "delete", "retry", "config_reload"
+	Detail    string // What it applied to and how it went
+}
+
+// Audit stores entry, setting its timestamp to now if it has none. Audit
+// entries are written straight away rather than queued like requests, so
+// that an action is never left unrecorded.
+func (db *DB) Audit(entry AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if _, err := db.conn.Exec(`
+		INSERT INTO audit (timestamp, actor, action, detail)
+		VALUES (?, ?, ?, ?)
+	`, entry.Timestamp, entry.Actor, entry.Action, entry.Detail); err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEntries returns audit entries, newest first
+func (db *DB) GetAuditEntries(limit, offset int) ([]AuditEntry, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, timestamp, actor, action, detail
+		FROM audit
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Actor, &entry.Action, &entry.Detail); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return entries, nil
+}
+
+// CountAuditEntries returns the number of audit entries
+func (db *DB) CountAuditEntries() (int64, error) {
+	var count int64
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM audit").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+	return count, nil
+}
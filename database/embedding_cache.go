@@ -0,0 +1,139 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GetCachedEmbeddings looks up cached vectors for the given input hashes and
+// returns the ones found, keyed by hash. Hits are counted and their
+// last-used time refreshed so trimming evicts the least recently used rows.
+func (db *DB) GetCachedEmbeddings(model string, dimensions int, hashes []string) (map[string][]float64, error) {
+	found := make(map[string][]float64)
+	if len(hashes) == 0 {
+		return found, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(hashes)), ",")
+	args := make([]interface{}, 0, len(hashes)+2)
+	args = append(args, model, dimensions)
+	for _, hash := range hashes {
+		args = append(args, hash)
+	}
+
+	rows, err := db.conn.Query(fmt.Sprintf(`
+		SELECT input_hash, embedding
+		FROM embedding_cache
+		WHERE model = ? AND dimensions = ? AND input_hash IN (%s)
+	`, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query embedding cache: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash, raw string
+		if err := rows.Scan(&hash, &raw); err != nil {
+			return nil, fmt.Errorf("failed to scan embedding cache row: %w", err)
+		}
+		var vector []float64
+		if err := json.Unmarshal([]byte(raw), &vector); err != nil {
+			return nil, fmt.Errorf("failed to decode cached embedding: %w", err)
+		}
+		found[hash] = vector
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate embedding cache rows: %w", err)
+	}
+
+	if len(found) > 0 {
+		hitArgs := make([]interface{}, 0, len(found)+3)
+		hitArgs = append(hitArgs, time.Now(), model, dimensions)
+		for hash := range found {
+			hitArgs = append(hitArgs, hash)
+		}
+		hitPlaceholders := strings.TrimSuffix(strings.Repeat("?,", len(found)), ",")
+		if _, err := db.conn.Exec(fmt.Sprintf(`
+			UPDATE embedding_cache
+			SET hits = hits + 1, last_used_at = ?
+			WHERE model = ? AND dimensions = ? AND input_hash IN (%s)
+		`, hitPlaceholders), hitArgs...); err != nil {
+			return nil, fmt.Errorf("failed to update embedding cache hits: %w", err)
+		}
+	}
+
+	return found, nil
+}
+
+// StoreEmbeddings saves computed vectors keyed by input hash, replacing any
+// existing rows for the same model, dimensions, and hash.
+func (db *DB) StoreEmbeddings(model string, dimensions int, vectors map[string][]float64) error {
+	if len(vectors) == 0 {
+		return nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO embedding_cache (model, dimensions, input_hash, embedding, created_at, last_used_at, hits)
+		VALUES (?, ?, ?, ?, ?, ?, 0)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare embedding insert: %w", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for hash, vector := range vectors {
+		raw, err := json.Marshal(vector)
+		if err != nil {
+			return fmt.Errorf("failed to encode embedding: %w", err)
+		}
+		if _, err := stmt.Exec(model, dimensions, hash, string(raw), now, now); err != nil {
+			return fmt.Errorf("failed to insert embedding: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit embeddings: %w", err)
+	}
+	return nil
+}
+
+// CountCachedEmbeddings returns the number of cached vectors.
+func (db *DB) CountCachedEmbeddings() (int64, error) {
+	var count int64
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM embedding_cache").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count cached embeddings: %w", err)
+	}
+	return count, nil
+}
+
+// TrimEmbeddingCache deletes the least recently used vectors so that at most
+// maxEntries remain. It returns the number of rows removed.
+func (db *DB) TrimEmbeddingCache(maxEntries int) (int64, error) {
+	result, err := db.conn.Exec(`
+		DELETE FROM embedding_cache
+		WHERE rowid NOT IN (
+			SELECT rowid
+			FROM embedding_cache
+			ORDER BY last_used_at DESC
+			LIMIT ?
+		)
+	`, maxEntries)
+	if err != nil {
+		return 0, fmt.Errorf("failed to trim embedding cache: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
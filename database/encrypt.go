@@ -0,0 +1,98 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedPrefix starts every value stored encrypted, followed by the
+// base64 of the nonce and the AES-GCM sealed content
+const encryptedPrefix = "enc:v1:"
+
+// SetEncryptionKey encrypts the prompt, response, last message, error,
+// frontend headers and raw request and response bodies of every entry logged
+// from now on, and every response stored in the response cache, with AES-GCM
+// under key (16, 24 or 32 bytes), and decrypts them again when they are
+// read. Entries logged before stay readable as they are.
+func (db *DB) SetEncryptionKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to set up encryption: %w", err)
+	}
+	db.cipher = aead
+	return nil
+}
+
+// sealValue returns the value stored for a logged column: value itself, or
+// its encryption if a key is set. Empty values are stored as they are, so
+// filters on empty columns keep working.
+func (db *DB) sealValue(value any) any {
+	if db.cipher == nil {
+		return value
+	}
+	var plain []byte
+	switch v := value.(type) {
+	case string:
+		plain = []byte(v)
+	case []byte:
+		plain = v
+	default:
+		return value
+	}
+	if len(plain) == 0 {
+		return value
+	}
+	nonce := make([]byte, db.cipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		// Never fall back to storing the content in plain text
+		return fmt.Sprintf("[failed to encrypt: %v]", err)
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(db.cipher.Seal(nonce, nonce, plain, nil))
+}
+
+// openEntry restores the columns of an entry read from the database that
+// were stored encrypted or compressed
+func (db *DB) openEntry(entry *LogEntry) {
+	for _, value := range []*string{&entry.Prompt, &entry.Response, &entry.LastMessage, &entry.Error, &entry.FrontendHeaders, &entry.FrontendRequest, &entry.FrontendResponse, &entry.BackendRequest, &entry.BackendResponse} {
+		*value = db.openValue(*value)
+	}
+	decompressPayloads(entry)
+}
+
+// openValue returns stored decrypted, or a note saying why it can't be
+func (db *DB) openValue(stored string) string {
+	plain, err := db.unseal(stored)
+	if err != nil {
+		return "[" + err.Error() + "]"
+	}
+	return plain
+}
+
+// unseal returns stored decrypted, or an error if it can't be
+func (db *DB) unseal(stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+	if db.cipher == nil {
+		return "", errors.New("encrypted: no encryption key configured")
+	}
+	data, err := base64.StdEncoding.DecodeString(stored[len(encryptedPrefix):])
+	if err != nil || len(data) < db.cipher.NonceSize() {
+		return "", errors.New("failed to decrypt: malformed value")
+	}
+	nonceSize := db.cipher.NonceSize()
+	plain, err := db.cipher.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt: wrong key or corrupted value")
+	}
+	return string(plain), nil
+}
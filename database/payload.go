@@ -0,0 +1,148 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// payloadLimit caps the size of the raw request and response bodies stored
+// with each entry
+type payloadLimit struct {
+	maxSize  int    // Bytes kept of each raw body
+	spillDir string // Where bodies over maxSize are written in full; "" = discard the rest
+}
+
+// SetPayloadLimit truncates the raw frontend and backend request and
+// response bodies of every entry logged from now on to maxSize bytes. If
+// spillDir is set, bodies over the limit are written there in full first,
+// named by the SHA-256 of their content, and the stored text says where.
+// maxSize 0 stores bodies whole.
+func (db *DB) SetPayloadLimit(maxSize int, spillDir string) error {
+	if spillDir != "" {
+		if err := os.MkdirAll(spillDir, 0o700); err != nil {
+			return fmt.Errorf("failed to create payload spill directory: %w", err)
+		}
+	}
+	if maxSize <= 0 {
+		db.payloadLimit = nil
+		return nil
+	}
+	db.payloadLimit = &payloadLimit{maxSize: maxSize, spillDir: spillDir}
+	return nil
+}
+
+// recordPayloadSizes sets the size columns of entry from its raw bodies
+func recordPayloadSizes(entry *LogEntry) {
+	entry.FrontendRequestSize = len(entry.FrontendRequest)
+	entry.FrontendResponseSize = len(entry.FrontendResponse)
+	entry.BackendRequestSize = len(entry.BackendRequest)
+	entry.BackendResponseSize = len(entry.BackendResponse)
+}
+
+// FrontendRequestTruncated reports whether the frontend request body was
+// cut short by the payload limit, so the logged body isn't the one sent.
+// The stored text, with its truncation note, can be longer than the body
+// was, so any difference from the recorded size counts. Entries logged
+// before sizes were recorded have size 0.
+func (e *LogEntry) FrontendRequestTruncated() bool {
+	return e.FrontendRequestSize > 0 && e.FrontendRequestSize != len(e.FrontendRequest)
+}
+
+// apply truncates the raw bodies of entry that are over the limit
+func (l *payloadLimit) apply(entry *LogEntry) {
+	for _, body := range []*string{&entry.FrontendRequest, &entry.FrontendResponse, &entry.BackendRequest, &entry.BackendResponse} {
+		*body = l.truncate(*body)
+	}
+}
+
+// truncate returns body cut to the limit, at a character boundary, with a
+// note of its full size and where it was spilled to
+func (l *payloadLimit) truncate(body string) string {
+	if len(body) <= l.maxSize {
+		return body
+	}
+	cut := l.maxSize
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+
+	where := ""
+	if l.spillDir != "" {
+		sum := sha256.Sum256([]byte(body))
+		path := filepath.Join(l.spillDir, hex.EncodeToString(sum[:16])+".txt")
+		// The same body may already have been spilled by an earlier entry
+		if _, err := os.Stat(path); err == nil {
+			where = "; full payload in " + path
+		} else if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			log.Printf("Failed to spill a %d byte payload: %v", len(body), err)
+		} else {
+			where = "; full payload in " + path
+		}
+	}
+	return fmt.Sprintf("%s\n[truncated: %d of %d bytes stored%s]", body[:cut], cut, len(body), where)
+}
+
+// minCompressSize is the smallest raw body worth compressing
+const minCompressSize = 512
+
+// gzipMagic starts every gzip stream. Raw bodies are JSON or SSE text, which
+// never start with it, so stored bodies that do are compressed.
+const gzipMagic = "\x1f\x8b"
+
+// SetCompressPayloads gzip-compresses the raw frontend and backend request
+// and response bodies of every entry logged from now on. They are stored as
+// blobs and decompressed again when entries are read, so entries logged
+// before and after can be mixed.
+func (db *DB) SetCompressPayloads(compress bool) {
+	db.compressPayloads = compress
+}
+
+// payloadValue returns the value stored for a raw body: the body itself, or
+// its gzip-compressed bytes if compression is on and saves space
+func (db *DB) payloadValue(body string) any {
+	if !db.compressPayloads || len(body) < minCompressSize {
+		return body
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(body)); err != nil {
+		return body
+	}
+	if err := zw.Close(); err != nil || buf.Len() >= len(body) {
+		return body
+	}
+	return buf.Bytes()
+}
+
+// decompressPayloads restores the raw bodies of an entry read from the
+// database that were stored compressed
+func decompressPayloads(entry *LogEntry) {
+	for _, body := range []*string{&entry.FrontendRequest, &entry.FrontendResponse, &entry.BackendRequest, &entry.BackendResponse} {
+		*body = decompressPayload(*body)
+	}
+}
+
+// decompressPayload returns stored uncompressed
+func decompressPayload(stored string) string {
+	if !strings.HasPrefix(stored, gzipMagic) {
+		return stored
+	}
+	zr, err := gzip.NewReader(strings.NewReader(stored))
+	if err != nil {
+		return fmt.Sprintf("[failed to decompress payload: %v]", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Sprintf("[failed to decompress payload: %v]", err)
+	}
+	return string(data)
+}
@@ -0,0 +1,37 @@
+package database
+
+// ModelPrice is the price of a model's tokens, per million tokens
+type ModelPrice struct {
+	Prompt       float64
+	CachedPrompt float64 // Prompt tokens served from the backend's prompt cache
+	Completion   float64
+}
+
+// DefaultPriceKey is the price table entry used for models without their own
+const DefaultPriceKey = "*"
+
+// Pricing estimates the cost of requests from a per-model price table
+type Pricing struct {
+	prices map[string]ModelPrice
+}
+
+// NewPricing creates a Pricing from prices, keyed by model name. The
+// DefaultPriceKey entry, if any, prices every other model.
+func NewPricing(prices map[string]ModelPrice) *Pricing {
+	return &Pricing{prices: prices}
+}
+
+// Cost returns the estimated cost of a request to model, or 0 if the model
+// has no price.
+func (p *Pricing) Cost(model string, promptTokens, cachedTokens, completionTokens int) float64 {
+	price, ok := p.prices[model]
+	if !ok {
+		if price, ok = p.prices[DefaultPriceKey]; !ok {
+			return 0
+		}
+	}
+	cachedTokens = min(cachedTokens, promptTokens)
+	return (float64(promptTokens-cachedTokens)*price.Prompt +
+		float64(cachedTokens)*price.CachedPrompt +
+		float64(completionTokens)*price.Completion) / 1_000_000
+}
@@ -7,21 +7,28 @@ import (
 	"time"
 )
 
-const logEntryColumns = "id, timestamp, endpoint, method, model, prompt, response, status_code, latency_ms, stream, backend_type, error, frontend_url, backend_url, frontend_request, frontend_response, backend_request, backend_response, last_message"
+const logEntryColumns = "id, timestamp, endpoint, method, model, prompt, response, status_code, latency_ms, stream, backend_type, error, frontend_url, backend_url, frontend_request, frontend_response, backend_request, backend_response, last_message, cache_hit, prompt_tokens, cached_tokens, completion_tokens, cost, partial, trimmed_messages, ttft_ms, generation_ms, tokens_per_second, backend_status, backend_request_id, backend_rate_limit, finish_reason, frontend_request_size, frontend_response_size, backend_request_size, backend_response_size, shadow_key, shadow, tenant, client_ip, user_agent, user, race_winner, frontend_headers"
 
 // LogFilter contains filters for querying request logs.
 type LogFilter struct {
-	Model       string
-	Endpoint    string
-	BackendType string
-	Query       string
-	Order       string
-	Status      *int
-	ErrorsOnly  bool
-	Since       *time.Time
-	Until       *time.Time
-	Limit       int
-	Offset      int
+	Model         string
+	Endpoint      string
+	BackendType   string
+	Tenant        string
+	User          string
+	ClientIP      string
+	Query         string
+	Search        string // Full-text search, see SearchEntries
+	SortBy        string // "timestamp" (default), "latency", "model" or "status"
+	Order         string
+	Status        *int
+	BackendStatus *int // HTTP status of the backend response
+	ErrorsOnly    bool
+	Stream        *bool
+	Since         *time.Time
+	Until         *time.Time
+	Limit         int
+	Offset        int
 }
 
 // GetRecentEntries returns the most recent log entries with pagination
@@ -39,7 +46,7 @@ func (db *DB) GetRecentEntries(limit, offset int) ([]LogEntry, error) {
 	}
 	defer rows.Close()
 
-	return scanLogEntries(rows)
+	return db.scanLogEntries(rows)
 }
 
 // GetEntryByID returns a single log entry by ID
@@ -71,6 +78,32 @@ func (db *DB) GetEntryByID(id int64) (*LogEntry, error) {
 		&entry.BackendRequest,
 		&entry.BackendResponse,
 		&entry.LastMessage,
+		&entry.CacheHit,
+		&entry.PromptTokens,
+		&entry.CachedTokens,
+		&entry.CompletionTokens,
+		&entry.Cost,
+		&entry.Partial,
+		&entry.TrimmedMessages,
+		&entry.TTFTMs,
+		&entry.GenerationMs,
+		&entry.TokensPerSecond,
+		&entry.BackendStatus,
+		&entry.BackendRequestID,
+		&entry.BackendRateLimit,
+		&entry.FinishReason,
+		&entry.FrontendRequestSize,
+		&entry.FrontendResponseSize,
+		&entry.BackendRequestSize,
+		&entry.BackendResponseSize,
+		&entry.ShadowKey,
+		&entry.Shadow,
+		&entry.Tenant,
+		&entry.ClientIP,
+		&entry.UserAgent,
+		&entry.User,
+		&entry.RaceWinner,
+		&entry.FrontendHeaders,
 	)
 
 	if err == sql.ErrNoRows {
@@ -79,10 +112,37 @@ func (db *DB) GetEntryByID(id int64) (*LogEntry, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to query entry: %w", err)
 	}
+	db.openEntry(&entry)
 
 	return &entry, nil
 }
 
+// GetShadowPartnerID returns the ID of the entry linked to entry by its
+// shadow key: the shadow copy of a request, or the request a shadow copy was
+// made of. It returns 0 if there is none, e.g. because it hasn't finished yet.
+func (db *DB) GetShadowPartnerID(entry *LogEntry) (int64, error) {
+	if entry.ShadowKey == "" {
+		return 0, nil
+	}
+	var id int64
+	err := db.conn.QueryRow("SELECT id FROM request WHERE shadow_key = ? AND id != ? ORDER BY id LIMIT 1", entry.ShadowKey, entry.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query shadow entry: %w", err)
+	}
+	return id, nil
+}
+
+// logSortColumns maps LogFilter.SortBy values to request columns.
+var logSortColumns = map[string]string{
+	"timestamp": "timestamp",
+	"latency":   "latency_ms",
+	"model":     "model",
+	"status":    "status_code",
+}
+
 // GetEntries returns filtered log entries.
 func (db *DB) GetEntries(filter LogFilter) ([]LogEntry, error) {
 	where, args := buildLogWhere(filter)
@@ -90,6 +150,10 @@ func (db *DB) GetEntries(filter LogFilter) ([]LogEntry, error) {
 	if strings.EqualFold(filter.Order, "asc") {
 		order = "ASC"
 	}
+	column, ok := logSortColumns[filter.SortBy]
+	if !ok {
+		column = "timestamp"
+	}
 	limit := filter.Limit
 	if limit <= 0 {
 		limit = 50
@@ -103,9 +167,9 @@ func (db *DB) GetEntries(filter LogFilter) ([]LogEntry, error) {
 		SELECT %s
 		FROM request
 		%s
-		ORDER BY timestamp %s, id %s
+		ORDER BY %s %s, id %s
 		LIMIT ? OFFSET ?
-	`, logEntryColumns, where, order, order)
+	`, logEntryColumns, where, column, order, order)
 	args = append(args, limit, offset)
 
 	rows, err := db.conn.Query(query, args...)
@@ -114,7 +178,7 @@ func (db *DB) GetEntries(filter LogFilter) ([]LogEntry, error) {
 	}
 	defer rows.Close()
 
-	return scanLogEntries(rows)
+	return db.scanLogEntries(rows)
 }
 
 // CountEntries returns the number of log entries matching the filter.
@@ -129,6 +193,65 @@ func (db *DB) CountEntries(filter LogFilter) (int64, error) {
 	return count, nil
 }
 
+// failedCondition matches requests that failed: they ended with an error or
+// an HTTP error status
+const failedCondition = "(COALESCE(error, '') != '' OR status_code >= 400)"
+
+// FailedRequest summarizes a request that failed, for the errors view. It
+// leaves out the prompt, response and raw bodies.
+type FailedRequest struct {
+	ID            int64
+	Timestamp     time.Time
+	Endpoint      string
+	Method        string
+	Model         string
+	BackendType   string
+	StatusCode    int
+	BackendStatus int // 0 if the backend was not reached
+	LatencyMs     int64
+	Stream        bool
+	Partial       bool
+	Error         string
+	Retryable     bool // The frontend request body was logged in full, so it can be sent again
+}
+
+// GetFailedRequests returns the most recent failed requests, newest first. A
+// non-empty tenant limits it to that tenant's requests.
+func (db *DB) GetFailedRequests(limit, offset int, tenant string) ([]FailedRequest, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, timestamp, endpoint, method, COALESCE(model, ''), COALESCE(backend_type, ''), status_code,
+			backend_status, latency_ms, stream, partial, COALESCE(error, ''), COALESCE(frontend_request, ''), frontend_request_size
+		FROM request
+		WHERE `+failedCondition+` AND (? = '' OR tenant = ?)
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, tenant, tenant, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failed requests: %w", err)
+	}
+	defer rows.Close()
+
+	var failed []FailedRequest
+	for rows.Next() {
+		var f FailedRequest
+		var request LogEntry
+		if err := rows.Scan(&f.ID, &f.Timestamp, &f.Endpoint, &f.Method, &f.Model, &f.BackendType, &f.StatusCode,
+			&f.BackendStatus, &f.LatencyMs, &f.Stream, &f.Partial, &f.Error, &request.FrontendRequest, &request.FrontendRequestSize); err != nil {
+			return nil, fmt.Errorf("failed to scan failed request: %w", err)
+		}
+		// The body may be stored encrypted or compressed, so it is opened to
+		// tell whether it was truncated
+		f.Error = db.openValue(f.Error)
+		request.FrontendRequest = decompressPayload(db.openValue(request.FrontendRequest))
+		f.Retryable = request.FrontendRequest != "" && !request.FrontendRequestTruncated()
+		failed = append(failed, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return failed, nil
+}
+
 // GetTotalCount returns the total number of log entries
 func (db *DB) GetTotalCount() (int64, error) {
 	var count int64
@@ -154,12 +277,32 @@ func buildLogWhere(filter LogFilter) (string, []interface{}) {
 		clauses = append(clauses, "backend_type = ?")
 		args = append(args, filter.BackendType)
 	}
+	if filter.Tenant != "" {
+		clauses = append(clauses, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.User != "" {
+		clauses = append(clauses, "user = ?")
+		args = append(args, filter.User)
+	}
+	if filter.ClientIP != "" {
+		clauses = append(clauses, "client_ip = ?")
+		args = append(args, filter.ClientIP)
+	}
 	if filter.Status != nil {
 		clauses = append(clauses, "status_code = ?")
 		args = append(args, *filter.Status)
 	}
+	if filter.BackendStatus != nil {
+		clauses = append(clauses, "backend_status = ?")
+		args = append(args, *filter.BackendStatus)
+	}
 	if filter.ErrorsOnly {
-		clauses = append(clauses, "(COALESCE(error, '') != '' OR status_code >= 400)")
+		clauses = append(clauses, failedCondition)
+	}
+	if filter.Stream != nil {
+		clauses = append(clauses, "stream = ?")
+		args = append(args, *filter.Stream)
 	}
 	if filter.Since != nil {
 		clauses = append(clauses, "timestamp >= ?")
@@ -174,13 +317,17 @@ func buildLogWhere(filter LogFilter) (string, []interface{}) {
 		q := "%" + strings.ToLower(filter.Query) + "%"
 		args = append(args, q, q, q)
 	}
+	if match := ftsQuery(filter.Search); match != "" {
+		clauses = append(clauses, "id IN (SELECT rowid FROM request_fts WHERE request_fts MATCH ?)")
+		args = append(args, match)
+	}
 	if len(clauses) == 0 {
 		return "", args
 	}
 	return "WHERE " + strings.Join(clauses, " AND "), args
 }
 
-func scanLogEntries(rows *sql.Rows) ([]LogEntry, error) {
+func (db *DB) scanLogEntries(rows *sql.Rows) ([]LogEntry, error) {
 	var entries []LogEntry
 	for rows.Next() {
 		var entry LogEntry
@@ -204,10 +351,37 @@ func scanLogEntries(rows *sql.Rows) ([]LogEntry, error) {
 			&entry.BackendRequest,
 			&entry.BackendResponse,
 			&entry.LastMessage,
+			&entry.CacheHit,
+			&entry.PromptTokens,
+			&entry.CachedTokens,
+			&entry.CompletionTokens,
+			&entry.Cost,
+			&entry.Partial,
+			&entry.TrimmedMessages,
+			&entry.TTFTMs,
+			&entry.GenerationMs,
+			&entry.TokensPerSecond,
+			&entry.BackendStatus,
+			&entry.BackendRequestID,
+			&entry.BackendRateLimit,
+			&entry.FinishReason,
+			&entry.FrontendRequestSize,
+			&entry.FrontendResponseSize,
+			&entry.BackendRequestSize,
+			&entry.BackendResponseSize,
+			&entry.ShadowKey,
+			&entry.Shadow,
+			&entry.Tenant,
+			&entry.ClientIP,
+			&entry.UserAgent,
+			&entry.User,
+			&entry.RaceWinner,
+			&entry.FrontendHeaders,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan entry: %w", err)
 		}
+		db.openEntry(&entry)
 		entries = append(entries, entry)
 	}
 	if err := rows.Err(); err != nil {
@@ -216,18 +390,64 @@ func scanLogEntries(rows *sql.Rows) ([]LogEntry, error) {
 	return entries, nil
 }
 
-// GetNextEntryID returns the ID of the next entry (chronologically newer, higher ID)
-func (db *DB) GetNextEntryID(currentID int64) (*int64, error) {
+// LogFilterOptions lists the values present in the request log that the
+// logs page can filter on.
+type LogFilterOptions struct {
+	Endpoints    []string
+	Models       []string
+	BackendTypes []string
+	Tenants      []string
+	Users        []string
+}
+
+// GetLogFilterOptions returns the distinct endpoints, models and backend
+// types in the request log, sorted. A non-empty tenant limits them to that
+// tenant's requests.
+func (db *DB) GetLogFilterOptions(tenant string) (LogFilterOptions, error) {
+	var options LogFilterOptions
+	for _, column := range []struct {
+		name   string
+		values *[]string
+	}{
+		{"endpoint", &options.Endpoints},
+		{"model", &options.Models},
+		{"backend_type", &options.BackendTypes},
+		{"tenant", &options.Tenants},
+		{"user", &options.Users},
+	} {
+		rows, err := db.conn.Query(fmt.Sprintf("SELECT DISTINCT %[1]s FROM request WHERE COALESCE(%[1]s, '') != '' AND (? = '' OR tenant = ?) ORDER BY %[1]s", column.name), tenant, tenant)
+		if err != nil {
+			return LogFilterOptions{}, fmt.Errorf("failed to query %s values: %w", column.name, err)
+		}
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				rows.Close()
+				return LogFilterOptions{}, fmt.Errorf("failed to scan %s value: %w", column.name, err)
+			}
+			*column.values = append(*column.values, value)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return LogFilterOptions{}, fmt.Errorf("error iterating %s values: %w", column.name, err)
+		}
+	}
+	return options, nil
+}
+
+// GetNextEntryID returns the ID of the next entry (chronologically newer,
+// higher ID), of the given tenant if not empty
+func (db *DB) GetNextEntryID(currentID int64, tenant string) (*int64, error) {
 	query := `
 		SELECT id
 		FROM request
-		WHERE id > ?
+		WHERE id > ? AND (? = '' OR tenant = ?)
 		ORDER BY id ASC
 		LIMIT 1
 	`
 
 	var nextID int64
-	err := db.conn.QueryRow(query, currentID).Scan(&nextID)
+	err := db.conn.QueryRow(query, currentID, tenant, tenant).Scan(&nextID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -238,18 +458,19 @@ func (db *DB) GetNextEntryID(currentID int64) (*int64, error) {
 	return &nextID, nil
 }
 
-// GetPreviousEntryID returns the ID of the previous entry (chronologically older, lower ID)
-func (db *DB) GetPreviousEntryID(currentID int64) (*int64, error) {
+// GetPreviousEntryID returns the ID of the previous entry (chronologically
+// older, lower ID), of the given tenant if not empty
+func (db *DB) GetPreviousEntryID(currentID int64, tenant string) (*int64, error) {
 	query := `
 		SELECT id
 		FROM request
-		WHERE id < ?
+		WHERE id < ? AND (? = '' OR tenant = ?)
 		ORDER BY id DESC
 		LIMIT 1
 	`
 
 	var prevID int64
-	err := db.conn.QueryRow(query, currentID).Scan(&prevID)
+	err := db.conn.QueryRow(query, currentID, tenant, tenant).Scan(&prevID)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -260,6 +481,83 @@ func (db *DB) GetPreviousEntryID(currentID int64) (*int64, error) {
 	return &prevID, nil
 }
 
+// ModelActivity records when a model last served a successful request
+type ModelActivity struct {
+	Model    string
+	LastUsed time.Time
+}
+
+// GetRecentModelActivity returns each model that served a successful request
+// at or after since, most recently used first
+func (db *DB) GetRecentModelActivity(since time.Time) ([]ModelActivity, error) {
+	rows, err := db.conn.Query(`
+		SELECT model, timestamp
+		FROM request
+		WHERE timestamp >= ? AND COALESCE(model, '') != '' AND status_code < 400
+		ORDER BY timestamp DESC
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query model activity: %w", err)
+	}
+	defer rows.Close()
+
+	var activity []ModelActivity
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var item ModelActivity
+		if err := rows.Scan(&item.Model, &item.LastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan model activity: %w", err)
+		}
+		if seen[item.Model] {
+			continue
+		}
+		seen[item.Model] = true
+		activity = append(activity, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return activity, nil
+}
+
+// PromptCacheStats sums the prompt tokens a model's backend reported and how
+// many of them were served from the backend's prompt cache
+type PromptCacheStats struct {
+	Model        string
+	Requests     int64
+	PromptTokens int64
+	CachedTokens int64
+}
+
+// GetPromptCacheStats returns prompt cache totals per model, over requests
+// whose backend reported prompt token counts
+func (db *DB) GetPromptCacheStats() ([]PromptCacheStats, error) {
+	rows, err := db.conn.Query(`
+		SELECT COALESCE(model, ''), COUNT(*), SUM(prompt_tokens), SUM(cached_tokens)
+		FROM request
+		WHERE prompt_tokens > 0
+		GROUP BY model
+		ORDER BY model
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prompt cache stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []PromptCacheStats
+	for rows.Next() {
+		var item PromptCacheStats
+		if err := rows.Scan(&item.Model, &item.Requests, &item.PromptTokens, &item.CachedTokens); err != nil {
+			return nil, fmt.Errorf("failed to scan prompt cache stats: %w", err)
+		}
+		stats = append(stats, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return stats, nil
+}
+
 // CleanupOldRequests removes the oldest requests, keeping only the most recent maxRequests
 // Returns the number of deleted rows
 func (db *DB) CleanupOldRequests(maxRequests int) (int64, error) {
@@ -299,3 +597,32 @@ func (db *DB) CleanupOldRequests(maxRequests int) (int64, error) {
 
 	return rowsAffected, nil
 }
+
+// DeleteEntry removes one request. It reports whether the request existed.
+func (db *DB) DeleteEntry(id int64) (bool, error) {
+	result, err := db.conn.Exec("DELETE FROM request WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete entry: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// DeleteEntries removes every request matching filter (all of them if the
+// filter is empty); Limit, Offset and the sort order are ignored. Returns the
+// number of deleted rows.
+func (db *DB) DeleteEntries(filter LogFilter) (int64, error) {
+	where, args := buildLogWhere(filter)
+	result, err := db.conn.Exec("DELETE FROM request "+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete entries: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
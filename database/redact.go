@@ -0,0 +1,125 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedactPattern is a user-defined pattern masked by a Redactor.
+type RedactPattern struct {
+	Name    string
+	Pattern string
+}
+
+// Redactor masks personal data in log entries before they are stored.
+// Matches are replaced with "[REDACTED:<name>]", which keeps raw JSON
+// fields valid.
+type Redactor struct {
+	patterns []redactPattern
+}
+
+type redactPattern struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(string) bool // Filters out false positives; nil = every match
+}
+
+// builtinRedactPatterns are the detectors selected by name in NewRedactor.
+var builtinRedactPatterns = map[string]redactPattern{
+	"email": {
+		name: "email",
+		re:   regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	"phone": {
+		name: "phone",
+		// International numbers with a leading +, or North American style
+		// numbers with separators. Bare digit runs are left alone because
+		// raw JSON is full of them.
+		re: regexp.MustCompile(`\+\d{1,3}(?:[ .-]?\d{2,4}){2,4}\b|(?:\(\d{3}\)\s?|\b\d{3}[ .-])\d{3}[ .-]\d{4}\b`),
+	},
+	"credit_card": {
+		name: "credit_card",
+		// 15 and 16 digit card numbers, optionally grouped with spaces or
+		// dashes. Shorter lengths collide with nanosecond durations in raw
+		// Ollama responses.
+		re:    regexp.MustCompile(`\b[3-6]\d(?:[ -]?\d){13,14}\b`),
+		valid: luhnValid,
+	},
+}
+
+// PIITypes lists the built-in detectors. Card numbers are matched before
+// phone numbers so a grouped card number isn't partly masked as a phone.
+var PIITypes = []string{"email", "credit_card", "phone"}
+
+// NewRedactor creates a redactor for the named built-in detectors (see
+// PIITypes) plus the custom patterns, applied in the order given.
+func NewRedactor(types []string, custom []RedactPattern) (*Redactor, error) {
+	r := &Redactor{}
+	for _, name := range types {
+		pattern, ok := builtinRedactPatterns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown PII type: %s", name)
+		}
+		r.patterns = append(r.patterns, pattern)
+	}
+	for _, c := range custom {
+		re, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", c.Name, err)
+		}
+		r.patterns = append(r.patterns, redactPattern{name: c.Name, re: re})
+	}
+	return r, nil
+}
+
+// Redact masks every match in text.
+func (r *Redactor) Redact(text string) string {
+	for _, p := range r.patterns {
+		text = p.re.ReplaceAllStringFunc(text, func(match string) string {
+			if p.valid != nil && !p.valid(match) {
+				return match
+			}
+			return "[REDACTED:" + p.name + "]"
+		})
+	}
+	return text
+}
+
+// RedactEntry masks the prompt, response, error and raw request/response
+// fields of entry.
+func (r *Redactor) RedactEntry(entry *LogEntry) {
+	for _, field := range []*string{
+		&entry.Prompt,
+		&entry.Response,
+		&entry.Error,
+		&entry.LastMessage,
+		&entry.FrontendRequest,
+		&entry.FrontendResponse,
+		&entry.BackendRequest,
+		&entry.BackendResponse,
+	} {
+		*field = r.Redact(*field)
+	}
+}
+
+// luhnValid reports whether the digits in number pass the Luhn checksum.
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
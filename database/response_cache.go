@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetCachedResponse returns the cached response stored under key if it was
+// stored within maxAge. A hit is counted and its last-used time refreshed so
+// trimming evicts the least recently used rows.
+func (db *DB) GetCachedResponse(key string, maxAge time.Duration) ([]byte, bool, error) {
+	var response string
+	err := db.conn.QueryRow(`
+		SELECT response
+		FROM response_cache
+		WHERE cache_key = ? AND created_at > ?
+	`, key, time.Now().Add(-maxAge)).Scan(&response)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query response cache: %w", err)
+	}
+	// A response encrypted with another key can't be read, so it counts as
+	// a miss
+	plain, err := db.unseal(response)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	if _, err := db.conn.Exec(`
+		UPDATE response_cache
+		SET hits = hits + 1, last_used_at = ?
+		WHERE cache_key = ?
+	`, time.Now(), key); err != nil {
+		return nil, false, fmt.Errorf("failed to update response cache hits: %w", err)
+	}
+	return []byte(plain), true, nil
+}
+
+// StoreCachedResponse saves a response under key, replacing any existing
+// row, then deletes the least recently used rows so that at most maxEntries
+// remain (0 = unlimited). The response is encrypted like logged content if
+// an encryption key is set.
+func (db *DB) StoreCachedResponse(key string, response []byte, maxEntries int) error {
+	now := time.Now()
+	if _, err := db.conn.Exec(`
+		INSERT OR REPLACE INTO response_cache (cache_key, response, created_at, last_used_at, hits)
+		VALUES (?, ?, ?, ?, 0)
+	`, key, db.sealValue(string(response)), now, now); err != nil {
+		return fmt.Errorf("failed to insert cached response: %w", err)
+	}
+
+	if maxEntries <= 0 {
+		return nil
+	}
+	if _, err := db.conn.Exec(`
+		DELETE FROM response_cache
+		WHERE rowid NOT IN (
+			SELECT rowid
+			FROM response_cache
+			ORDER BY last_used_at DESC
+			LIMIT ?
+		)
+	`, maxEntries); err != nil {
+		return fmt.Errorf("failed to trim response cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// initSearchIndex creates the full-text index over the prompt, response and
+// last message of each request. Triggers keep it in step with the request
+// table; a database from an older version is indexed when the index is first
+// created, and reindexed when its triggers predate leaving encrypted values
+// out.
+func (db *DB) initSearchIndex() error {
+	var exists int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'request_fts'").Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+	outdated, err := db.dropOutdatedSearchTriggers()
+	if err != nil {
+		return err
+	}
+
+	schema := fmt.Sprintf(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS request_fts USING fts5(
+		prompt, response, last_message,
+		content='request', content_rowid='id'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS request_fts_insert AFTER INSERT ON request BEGIN
+		INSERT INTO request_fts(rowid, prompt, response, last_message)
+		VALUES (new.id, %[1]s, %[2]s, %[3]s);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS request_fts_delete AFTER DELETE ON request BEGIN
+		INSERT INTO request_fts(request_fts, rowid, prompt, response, last_message)
+		VALUES ('delete', old.id, %[4]s, %[5]s, %[6]s);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS request_fts_update AFTER UPDATE ON request BEGIN
+		INSERT INTO request_fts(request_fts, rowid, prompt, response, last_message)
+		VALUES ('delete', old.id, %[4]s, %[5]s, %[6]s);
+		INSERT INTO request_fts(rowid, prompt, response, last_message)
+		VALUES (new.id, %[1]s, %[2]s, %[3]s);
+	END;
+	`,
+		searchValue("new.prompt"), searchValue("new.response"), searchValue("new.last_message"),
+		searchValue("old.prompt"), searchValue("old.response"), searchValue("old.last_message"))
+	if _, err := db.conn.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	if exists == 0 || outdated {
+		// Built from the same values as the triggers, rather than with
+		// 'rebuild', which would index encrypted values as stored
+		if _, err := db.conn.Exec("INSERT INTO request_fts(request_fts) VALUES ('delete-all')"); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+		rebuild := fmt.Sprintf("INSERT INTO request_fts(rowid, prompt, response, last_message) SELECT id, %s, %s, %s FROM request",
+			searchValue("prompt"), searchValue("response"), searchValue("last_message"))
+		if _, err := db.conn.Exec(rebuild); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// searchValue is the SQL for the text of column to index: the column, or
+// nothing if it holds a value sealed with the encryption key, whose
+// ciphertext would only fill the index with noise
+func searchValue(column string) string {
+	return fmt.Sprintf("CASE WHEN substr(%[1]s, 1, %[2]d) = '%[3]s' THEN '' ELSE %[1]s END", column, len(encryptedPrefix), encryptedPrefix)
+}
+
+// dropOutdatedSearchTriggers drops search index triggers created before
+// encrypted values were left out of the index, and reports whether there
+// were any
+func (db *DB) dropOutdatedSearchTriggers() (bool, error) {
+	rows, err := db.conn.Query("SELECT name FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'request_fts_%' AND sql NOT LIKE ?", "%"+encryptedPrefix+"%")
+	if err != nil {
+		return false, fmt.Errorf("failed to check search index triggers: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("failed to check search index triggers: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to check search index triggers: %w", err)
+	}
+
+	for _, name := range names {
+		if _, err := db.conn.Exec("DROP TRIGGER " + name); err != nil {
+			return false, fmt.Errorf("failed to update search index: %w", err)
+		}
+	}
+	return len(names) > 0, nil
+}
+
+// SearchEntries returns the entries whose prompt, response or last message
+// contain every word of query, newest first. Words are matched as whole
+// tokens (case-insensitive); quotes group words into a phrase.
+func (db *DB) SearchEntries(query string, limit, offset int) ([]LogEntry, error) {
+	if ftsQuery(query) == "" {
+		return nil, nil
+	}
+	return db.GetEntries(LogFilter{Search: query, Limit: limit, Offset: offset})
+}
+
+// CountSearchResults returns the number of entries SearchEntries can return
+// for query.
+func (db *DB) CountSearchResults(query string) (int64, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return 0, nil
+	}
+
+	var count int64
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM request_fts WHERE request_fts MATCH ?", match).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+	return count, nil
+}
+
+// ftsQuery turns free text into an FTS5 query that matches every word or
+// quoted phrase, so that punctuation in the search box can't cause a syntax
+// error.
+func ftsQuery(text string) string {
+	var terms []string
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			// Inside quotes: keep as a phrase
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				terms = append(terms, `"`+phrase+`"`)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			terms = append(terms, `"`+word+`"`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
@@ -1,8 +1,13 @@
 package database
 
 import (
+	"cmp"
+	"context"
+	"crypto/cipher"
 	"database/sql"
 	"fmt"
+	"net/url"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -10,7 +15,25 @@ import (
 
 // DB wraps the SQLite database connection
 type DB struct {
-	conn *sql.DB
+	conn     *sql.DB
+	redactor *Redactor // Masks PII in log entries before they are stored; nil = disabled
+	pricing  *Pricing  // Estimates the cost of log entries; nil = disabled
+
+	payloadLimit     *payloadLimit // Caps stored raw bodies (see SetPayloadLimit); nil = no limit
+	compressPayloads bool          // Store raw bodies gzip-compressed (see SetCompressPayloads)
+	cipher           cipher.AEAD   // Encrypts logged content (see SetEncryptionKey); nil = plain text
+
+	// Background writer (see StartWriter); queue is nil when Log writes
+	// synchronously
+	mu         sync.RWMutex
+	queue      chan LogEntry
+	writerDone chan struct{}
+	closed     bool
+
+	// Live subscribers (see Subscribe)
+	subsMu     sync.Mutex
+	subs       map[chan LogEntry]struct{}
+	subsClosed bool
 }
 
 // LogEntry represents a logged request/response
@@ -27,21 +50,104 @@ type LogEntry struct {
 	Stream           bool
 	BackendType      string
 	Error            string
-	FrontendURL      string // Frontend URL that received the request
-	BackendURL       string // Backend URL that was called
-	FrontendRequest  string // Raw frontend request JSON
-	FrontendResponse string // Raw frontend response JSON
-	BackendRequest   string // Raw backend request JSON
-	BackendResponse  string // Raw backend response data
-	LastMessage      string // Last message in the prompt (user input or tool result)
+	FrontendURL      string  // Frontend URL that received the request
+	BackendURL       string  // Backend URL that was called
+	FrontendRequest  string  // Raw frontend request JSON
+	FrontendResponse string  // Raw frontend response JSON
+	BackendRequest   string  // Raw backend request JSON
+	BackendResponse  string  // Raw backend response data
+	LastMessage      string  // Last message in the prompt (user input or tool result)
+	CacheHit         bool    // Response was served from the response cache
+	PromptTokens     int     // Prompt tokens reported by the backend (0 = not reported)
+	CachedTokens     int     // Prompt tokens the backend served from its prompt cache
+	CompletionTokens int     // Completion tokens reported by the backend (0 = not reported)
+	Cost             float64 // Estimated cost from the price table (0 = no price for the model)
+	Partial          bool    // The response was cut short (client disconnected or backend failed); Response holds what arrived
+	TrimmedMessages  string  // Messages left out to fit the model's context window, e.g. "dropped messages 2-5 of 12 (...)"
+	TTFTMs           int64   // Time to the first generated token (0 = none arrived)
+	GenerationMs     int64   // Time from the first generated token to the end of the response
+	TokensPerSecond  float64 // Completion tokens per second of generation (0 = unknown)
+	BackendStatus    int     // HTTP status of the backend response (0 = backend not reached)
+	BackendRequestID string  // Request ID header of the backend response
+	BackendRateLimit string  // Rate limit headers of the backend response, one "name: value" per line
+	FinishReason     string  // Why generation stopped, e.g. "stop", "length" or "tool_calls"
+
+	// Sizes in bytes of the raw bodies before any truncation (see
+	// SetPayloadLimit)
+	FrontendRequestSize  int
+	FrontendResponseSize int
+	BackendRequestSize   int
+	BackendResponseSize  int
+
+	// ShadowKey links a request to the copy of it sent to the shadow
+	// backend: both entries have the same key. Shadow marks the copy.
+	ShadowKey string
+	Shadow    bool
+
+	RaceWinner string // Backend that answered a raced request first ("" = not raced)
+
+	Tenant string // Tenant the request was made by ("" = none)
+
+	// Who made the request: the client's IP address and User-Agent header,
+	// and the user it named (see config.UsersConfig)
+	ClientIP  string
+	UserAgent string
+	User      string
+
+	// FrontendHeaders holds selected headers of the client's request as a
+	// JSON object of name -> value, with API keys replaced by their names
+	FrontendHeaders string
+}
+
+// Options tune the SQLite connection. Zero values use the defaults noted on
+// each field.
+type Options struct {
+	JournalMode  string // journal_mode pragma (default "wal")
+	Synchronous  string // synchronous pragma (default "normal")
+	BusyTimeout  int    // Milliseconds to wait for a lock before failing (default 5000)
+	MaxOpenConns int    // Maximum open connections (default 8; always 1 for MemoryPath)
+	MaxIdleConns int    // Maximum idle connections kept in the pool (default 2; always 1 for MemoryPath)
+}
+
+// dsn returns the data source name for path, applying the pragmas to every
+// connection in the pool. Transactions take the write lock when they begin so
+// that busy_timeout applies to them.
+func (o Options) dsn(path string) string {
+	query := url.Values{}
+	query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cmp.Or(o.BusyTimeout, 5000)))
+	query.Add("_pragma", "journal_mode("+cmp.Or(o.JournalMode, "wal")+")")
+	query.Add("_pragma", "synchronous("+cmp.Or(o.Synchronous, "normal")+")")
+	query.Set("_txlock", "immediate")
+	return path + "?" + query.Encode()
 }
 
-// New creates a new database connection and initializes the schema
+// MemoryPath is the database path that keeps the log in memory only. It is
+// lost when the proxy exits.
+const MemoryPath = ":memory:"
+
+// New creates a new database connection with the default Options and
+// initializes the schema
 func New(path string) (*DB, error) {
-	conn, err := sql.Open("sqlite", path)
+	return Open(path, Options{})
+}
+
+// Open creates a new database connection and initializes the schema
+func Open(path string, opts Options) (*DB, error) {
+	conn, err := sql.Open("sqlite", opts.dsn(path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	if path == MemoryPath {
+		// Every connection to ":memory:" opens its own empty database, so
+		// the pool keeps exactly one open for as long as it exists
+		conn.SetMaxOpenConns(1)
+		conn.SetMaxIdleConns(1)
+		conn.SetConnMaxLifetime(0)
+		conn.SetConnMaxIdleTime(0)
+	} else {
+		conn.SetMaxOpenConns(cmp.Or(opts.MaxOpenConns, 8))
+		conn.SetMaxIdleConns(cmp.Or(opts.MaxIdleConns, 2))
+	}
 
 	db := &DB{conn: conn}
 	if err := db.initSchema(); err != nil {
@@ -52,6 +158,21 @@ func New(path string) (*DB, error) {
 	return db, nil
 }
 
+// OpenReadOnly opens an existing log database without creating or migrating
+// its schema and without allowing writes. It is used to read logs written by
+// another proxy instance.
+func OpenReadOnly(path string) (*DB, error) {
+	conn, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return &DB{conn: conn}, nil
+}
+
 // initSchema creates the required tables if they don't exist
 func (db *DB) initSchema() error {
 	schema := `
@@ -81,49 +202,271 @@ func (db *DB) initSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_endpoint ON request(endpoint);
 	CREATE INDEX IF NOT EXISTS idx_model ON request(model);
 	CREATE INDEX IF NOT EXISTS idx_status_code ON request(status_code);
+
+	CREATE TABLE IF NOT EXISTS embedding_cache (
+		model TEXT NOT NULL,
+		dimensions INTEGER NOT NULL DEFAULT 0,
+		input_hash TEXT NOT NULL,
+		embedding TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME NOT NULL,
+		hits INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (model, dimensions, input_hash)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_embedding_cache_last_used ON embedding_cache(last_used_at);
+
+	CREATE TABLE IF NOT EXISTS response_cache (
+		cache_key TEXT PRIMARY KEY,
+		response TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME NOT NULL,
+		hits INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_response_cache_last_used ON response_cache(last_used_at);
+
+	CREATE TABLE IF NOT EXISTS audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		detail TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_timestamp ON audit(timestamp);
 	`
 
-	_, err := db.conn.Exec(schema)
-	return err
+	if _, err := db.conn.Exec(schema); err != nil {
+		return err
+	}
+	if err := db.addRequestColumns(); err != nil {
+		return err
+	}
+	if _, err := db.conn.Exec("CREATE INDEX IF NOT EXISTS idx_shadow_key ON request(shadow_key) WHERE shadow_key != ''"); err != nil {
+		return err
+	}
+	if _, err := db.conn.Exec("CREATE INDEX IF NOT EXISTS idx_tenant ON request(tenant, timestamp)"); err != nil {
+		return err
+	}
+	return db.initSearchIndex()
+}
+
+// requestColumns lists columns added to the request table after it was
+// first created, so databases from older versions pick them up on startup.
+var requestColumns = []struct {
+	name       string
+	definition string
+}{
+	{"cache_hit", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"prompt_tokens", "INTEGER NOT NULL DEFAULT 0"},
+	{"cached_tokens", "INTEGER NOT NULL DEFAULT 0"},
+	{"completion_tokens", "INTEGER NOT NULL DEFAULT 0"},
+	{"cost", "REAL NOT NULL DEFAULT 0"},
+	{"partial", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"trimmed_messages", "TEXT NOT NULL DEFAULT ''"},
+	{"ttft_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"generation_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"tokens_per_second", "REAL NOT NULL DEFAULT 0"},
+	{"backend_status", "INTEGER NOT NULL DEFAULT 0"},
+	{"backend_request_id", "TEXT NOT NULL DEFAULT ''"},
+	{"backend_rate_limit", "TEXT NOT NULL DEFAULT ''"},
+	{"finish_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"frontend_request_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"frontend_response_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"backend_request_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"backend_response_size", "INTEGER NOT NULL DEFAULT 0"},
+	{"shadow_key", "TEXT NOT NULL DEFAULT ''"},
+	{"shadow", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"tenant", "TEXT NOT NULL DEFAULT ''"},
+	{"client_ip", "TEXT NOT NULL DEFAULT ''"},
+	{"user_agent", "TEXT NOT NULL DEFAULT ''"},
+	{"user", "TEXT NOT NULL DEFAULT ''"},
+	{"race_winner", "TEXT NOT NULL DEFAULT ''"},
+	{"frontend_headers", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addRequestColumns adds any of requestColumns the request table lacks
+func (db *DB) addRequestColumns() error {
+	rows, err := db.conn.Query("PRAGMA table_info(request)")
+	if err != nil {
+		return fmt.Errorf("failed to read request columns: %w", err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan request column: %w", err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate request columns: %w", err)
+	}
+
+	for _, col := range requestColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE request ADD COLUMN %s %s", col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add request column %s: %w", col.name, err)
+		}
+	}
+	return nil
 }
 
-// Log inserts a log entry into the database
+// Log inserts a log entry into the database. Once StartWriter has been
+// called the entry is queued instead and errors are reported by the writer.
 func (db *DB) Log(entry LogEntry) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.queue != nil && !db.closed {
+		db.queue <- entry
+		return nil
+	}
+	if err := db.insertEntry(db.conn, &entry); err != nil {
+		return err
+	}
+	db.publish(entry)
+	return nil
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertEntry redacts, prices and truncates entry, inserts it using conn and
+// sets its ID.
+func (db *DB) insertEntry(conn execer, entry *LogEntry) error {
+	if db.redactor != nil {
+		db.redactor.RedactEntry(entry)
+	}
+	recordPayloadSizes(entry)
+	if db.payloadLimit != nil {
+		db.payloadLimit.apply(entry)
+	}
+	if db.pricing != nil && entry.Cost == 0 {
+		entry.Cost = db.pricing.Cost(entry.Model, entry.PromptTokens, entry.CachedTokens, entry.CompletionTokens)
+	}
+
 	query := `
-		INSERT INTO request (timestamp, endpoint, method, model, prompt, response, status_code, latency_ms, stream, backend_type, error, frontend_url, backend_url, frontend_request, frontend_response, backend_request, backend_response, last_message)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO request (timestamp, endpoint, method, model, prompt, response, status_code, latency_ms, stream, backend_type, error, frontend_url, backend_url, frontend_request, frontend_response, backend_request, backend_response, last_message, cache_hit, prompt_tokens, cached_tokens, completion_tokens, cost, partial, trimmed_messages,
+		ttft_ms, generation_ms, tokens_per_second, backend_status, backend_request_id, backend_rate_limit, finish_reason,
+		frontend_request_size, frontend_response_size, backend_request_size, backend_response_size,
+		shadow_key, shadow, tenant, client_ip, user_agent, user, race_winner, frontend_headers)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.conn.Exec(
+	result, err := conn.Exec(
 		query,
 		entry.Timestamp,
 		entry.Endpoint,
 		entry.Method,
 		entry.Model,
-		entry.Prompt,
-		entry.Response,
+		db.sealValue(entry.Prompt),
+		db.sealValue(entry.Response),
 		entry.StatusCode,
 		entry.LatencyMs,
 		entry.Stream,
 		entry.BackendType,
-		entry.Error,
+		db.sealValue(entry.Error),
 		entry.FrontendURL,
 		entry.BackendURL,
-		entry.FrontendRequest,
-		entry.FrontendResponse,
-		entry.BackendRequest,
-		entry.BackendResponse,
-		entry.LastMessage,
+		db.sealValue(db.payloadValue(entry.FrontendRequest)),
+		db.sealValue(db.payloadValue(entry.FrontendResponse)),
+		db.sealValue(db.payloadValue(entry.BackendRequest)),
+		db.sealValue(db.payloadValue(entry.BackendResponse)),
+		db.sealValue(entry.LastMessage),
+		entry.CacheHit,
+		entry.PromptTokens,
+		entry.CachedTokens,
+		entry.CompletionTokens,
+		entry.Cost,
+		entry.Partial,
+		entry.TrimmedMessages,
+		entry.TTFTMs,
+		entry.GenerationMs,
+		entry.TokensPerSecond,
+		entry.BackendStatus,
+		entry.BackendRequestID,
+		entry.BackendRateLimit,
+		entry.FinishReason,
+		entry.FrontendRequestSize,
+		entry.FrontendResponseSize,
+		entry.BackendRequestSize,
+		entry.BackendResponseSize,
+		entry.ShadowKey,
+		entry.Shadow,
+		entry.Tenant,
+		entry.ClientIP,
+		entry.UserAgent,
+		entry.User,
+		entry.RaceWinner,
+		db.sealValue(entry.FrontendHeaders),
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to insert log entry: %w", err)
 	}
+	if entry.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to get log entry ID: %w", err)
+	}
 
 	return nil
 }
 
-// Close closes the database connection
+// SetRedactor masks PII in every entry logged from now on.
+func (db *DB) SetRedactor(r *Redactor) {
+	db.redactor = r
+}
+
+// SetPricing estimates the cost of every entry logged from now on.
+func (db *DB) SetPricing(p *Pricing) {
+	db.pricing = p
+}
+
+// CheckWritable returns an error if log entries can't currently be written,
+// e.g. because the file is read-only or another process holds the write
+// lock. It takes the write lock and makes a change that is rolled back.
+func (db *DB) CheckWritable(ctx context.Context) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read database: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	return nil
+}
+
+// Close writes any queued log entries and closes the database connection
 func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.queue != nil && !db.closed {
+		close(db.queue)
+	}
+	db.closed = true
+	db.mu.Unlock()
+
+	if db.writerDone != nil {
+		<-db.writerDone
+	}
+	db.CloseSubscriptions()
 	return db.conn.Close()
 }
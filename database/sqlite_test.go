@@ -1,7 +1,12 @@
 package database
 
 import (
+	"database/sql"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -123,7 +128,7 @@ func TestEntryNavigationUsesAdjacentIDs(t *testing.T) {
 		}
 	}
 
-	next, err := db.GetNextEntryID(2)
+	next, err := db.GetNextEntryID(2, "")
 	if err != nil {
 		t.Fatalf("GetNextEntryID() error = %v", err)
 	}
@@ -131,7 +136,7 @@ func TestEntryNavigationUsesAdjacentIDs(t *testing.T) {
 		t.Fatalf("next = %v, want 3", next)
 	}
 
-	prev, err := db.GetPreviousEntryID(2)
+	prev, err := db.GetPreviousEntryID(2, "")
 	if err != nil {
 		t.Fatalf("GetPreviousEntryID() error = %v", err)
 	}
@@ -139,7 +144,7 @@ func TestEntryNavigationUsesAdjacentIDs(t *testing.T) {
 		t.Fatalf("prev = %v, want 1", prev)
 	}
 
-	noNext, err := db.GetNextEntryID(3)
+	noNext, err := db.GetNextEntryID(3, "")
 	if err != nil {
 		t.Fatalf("GetNextEntryID(last) error = %v", err)
 	}
@@ -147,3 +152,805 @@ func TestEntryNavigationUsesAdjacentIDs(t *testing.T) {
 		t.Fatalf("next after last = %v, want nil", *noNext)
 	}
 }
+
+func TestGetShadowPartnerID(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	for _, entry := range []LogEntry{
+		{Endpoint: "/api/chat", ShadowKey: "abc"},
+		{Endpoint: "/api/chat"},
+		{Endpoint: "/api/chat", ShadowKey: "abc", Shadow: true},
+	} {
+		entry.Timestamp = time.Now()
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	for id, want := range map[int64]int64{1: 3, 2: 0, 3: 1} {
+		entry, err := db.GetEntryByID(id)
+		if err != nil || entry == nil {
+			t.Fatalf("GetEntryByID(%d) = %v, %v", id, entry, err)
+		}
+		got, err := db.GetShadowPartnerID(entry)
+		if err != nil {
+			t.Fatalf("GetShadowPartnerID(%d) error = %v", id, err)
+		}
+		if got != want {
+			t.Fatalf("GetShadowPartnerID(%d) = %d, want %d", id, got, want)
+		}
+	}
+	if entry, _ := db.GetEntryByID(3); !entry.Shadow || entry.ShadowKey != "abc" {
+		t.Fatalf("shadow entry = %+v, want Shadow with key abc", entry)
+	}
+}
+
+func TestEmbeddingCacheStoreLookupAndTrim(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	if err := db.StoreEmbeddings("nomic", 0, map[string][]float64{
+		"hash-a": {0.5, -1},
+		"hash-b": {2},
+	}); err != nil {
+		t.Fatalf("StoreEmbeddings() error = %v", err)
+	}
+
+	found, err := db.GetCachedEmbeddings("nomic", 0, []string{"hash-a", "hash-missing"})
+	if err != nil {
+		t.Fatalf("GetCachedEmbeddings() error = %v", err)
+	}
+	if len(found) != 1 || len(found["hash-a"]) != 2 || found["hash-a"][1] != -1 {
+		t.Fatalf("found = %v, want only hash-a", found)
+	}
+
+	otherDims, err := db.GetCachedEmbeddings("nomic", 256, []string{"hash-a"})
+	if err != nil {
+		t.Fatalf("GetCachedEmbeddings(dimensions) error = %v", err)
+	}
+	if len(otherDims) != 0 {
+		t.Fatalf("found with other dimensions = %v, want none", otherDims)
+	}
+
+	// hash-a was just used, so trimming to one entry keeps it
+	deleted, err := db.TrimEmbeddingCache(1)
+	if err != nil {
+		t.Fatalf("TrimEmbeddingCache() error = %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("deleted = %d, want 1", deleted)
+	}
+	count, err := db.CountCachedEmbeddings()
+	if err != nil {
+		t.Fatalf("CountCachedEmbeddings() error = %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("count = %d, want 1", count)
+	}
+	found, err = db.GetCachedEmbeddings("nomic", 0, []string{"hash-a", "hash-b"})
+	if err != nil {
+		t.Fatalf("GetCachedEmbeddings() error = %v", err)
+	}
+	if _, ok := found["hash-a"]; !ok || len(found) != 1 {
+		t.Fatalf("after trim found = %v, want hash-a only", found)
+	}
+}
+
+func TestResponseCacheStoreLookupAndTrim(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	if err := db.StoreCachedResponse("key-a", []byte(`{"a":1}`), 0); err != nil {
+		t.Fatalf("StoreCachedResponse() error = %v", err)
+	}
+	value, ok, err := db.GetCachedResponse("key-a", time.Hour)
+	if err != nil || !ok || string(value) != `{"a":1}` {
+		t.Fatalf("GetCachedResponse() = %q, %v, %v", value, ok, err)
+	}
+	if _, ok, _ := db.GetCachedResponse("key-a", 0); ok {
+		t.Fatal("GetCachedResponse() hit an expired entry")
+	}
+
+	// key-b is the most recently used, so trimming to one entry keeps it
+	if err := db.StoreCachedResponse("key-b", []byte(`{"b":2}`), 1); err != nil {
+		t.Fatalf("StoreCachedResponse() error = %v", err)
+	}
+	if _, ok, _ := db.GetCachedResponse("key-a", time.Hour); ok {
+		t.Fatal("key-a was not trimmed")
+	}
+	if _, ok, _ := db.GetCachedResponse("key-b", time.Hour); !ok {
+		t.Fatal("key-b missing after trim")
+	}
+}
+
+func TestNewAddsMissingRequestColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llm_proxy.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	// The request table as created before cache_hit existed
+	if _, err := old.Exec(`CREATE TABLE request (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp DATETIME NOT NULL, endpoint TEXT NOT NULL, method TEXT NOT NULL,
+		model TEXT, prompt TEXT, response TEXT, status_code INTEGER, latency_ms INTEGER, stream BOOLEAN, backend_type TEXT,
+		error TEXT, frontend_url TEXT, backend_url TEXT, frontend_request TEXT, frontend_response TEXT, backend_request TEXT,
+		backend_response TEXT, last_message TEXT NOT NULL DEFAULT 'unknown')`); err != nil {
+		t.Fatalf("create old table error = %v", err)
+	}
+	if _, err := old.Exec(`INSERT INTO request (timestamp, endpoint, method, model, prompt, response, status_code, latency_ms, stream, backend_type, error, frontend_url, backend_url, frontend_request, frontend_response, backend_request, backend_response)
+		VALUES (?, '/api/chat', 'POST', 'm', '', '', 200, 1, 0, 'ollama', '', '', '', '', '', '', '')`, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("insert old row error = %v", err)
+	}
+	old.Close()
+
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", Method: "POST", CacheHit: true}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	entries, err := db.GetRecentEntries(10, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if len(entries) != 2 || !entries[0].CacheHit || entries[1].CacheHit {
+		t.Fatalf("entries = %+v, want new entry flagged as a cache hit", entries)
+	}
+}
+
+func TestGetPromptCacheStats(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	for _, entry := range []LogEntry{
+		{Model: "a", PromptTokens: 100, CachedTokens: 80},
+		{Model: "a", PromptTokens: 50, CachedTokens: 0},
+		{Model: "b", PromptTokens: 10, CachedTokens: 10},
+		{Model: "b"}, // Backend reported no usage
+	} {
+		entry.Timestamp = time.Now()
+		entry.Endpoint = "/api/chat"
+		entry.Method = "POST"
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	stats, err := db.GetPromptCacheStats()
+	if err != nil {
+		t.Fatalf("GetPromptCacheStats() error = %v", err)
+	}
+	want := []PromptCacheStats{
+		{Model: "a", Requests: 2, PromptTokens: 150, CachedTokens: 80},
+		{Model: "b", Requests: 1, PromptTokens: 10, CachedTokens: 10},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestLogRedactsPII(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	redactor, err := NewRedactor(PIITypes, []RedactPattern{{Name: "account", Pattern: `ACCT-\d+`}})
+	if err != nil {
+		t.Fatalf("NewRedactor() error = %v", err)
+	}
+	db.SetRedactor(redactor)
+
+	if err := db.Log(LogEntry{
+		Timestamp:       time.Now(),
+		Prompt:          "mail bob@example.com or call (555) 123-4567 about ACCT-42",
+		Response:        "card 4111 1111 1111 1111, not 4111 1111 1111 1112",
+		BackendResponse: `{"total_duration":5043120000123,"phone":"+44 20 7946 0958"}`,
+	}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	entries, err := db.GetRecentEntries(1, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	got := entries[0]
+	if want := "mail [REDACTED:email] or call [REDACTED:phone] about [REDACTED:account]"; got.Prompt != want {
+		t.Fatalf("Prompt = %q, want %q", got.Prompt, want)
+	}
+	if want := "card [REDACTED:credit_card], not 4111 1111 1111 1112"; got.Response != want {
+		t.Fatalf("Response = %q, want %q", got.Response, want)
+	}
+	if want := `{"total_duration":5043120000123,"phone":"[REDACTED:phone]"}`; got.BackendResponse != want {
+		t.Fatalf("BackendResponse = %q, want %q", got.BackendResponse, want)
+	}
+}
+
+func TestWriterFlushesOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llm_proxy.db")
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	db.StartWriter(4, 3)
+
+	for i := 0; i < 25; i++ {
+		if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", Model: "test-model"}); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	db, err = New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	entries, err := db.GetRecentEntries(100, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if len(entries) != 25 {
+		t.Fatalf("len(entries) = %d, want 25", len(entries))
+	}
+}
+
+func TestWriterLogsRestOfBatchWhenOneEntryFails(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	if _, err := db.conn.Exec(`CREATE TRIGGER reject_bad BEFORE INSERT ON request WHEN new.model = 'bad'
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`); err != nil {
+		t.Fatalf("creating trigger: %v", err)
+	}
+
+	entries := []LogEntry{
+		{Timestamp: time.Now(), Endpoint: "/api/chat", Model: "good", Prompt: "first"},
+		{Timestamp: time.Now(), Endpoint: "/api/chat", Model: "bad"},
+		{Timestamp: time.Now(), Endpoint: "/api/chat", Model: "good", Prompt: "third"},
+	}
+	if err := db.insertBatch(entries); err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Fatalf("insertBatch() error = %v, want 1 of 3 not logged", err)
+	}
+
+	stored, err := db.GetRecentEntries(10, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if len(stored) != 2 || stored[0].Prompt != "third" || stored[1].Prompt != "first" {
+		t.Fatalf("stored = %+v, want the two good entries", stored)
+	}
+}
+
+func TestOpenAppliesPragmas(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "llm_proxy.db"), Options{Synchronous: "full", BusyTimeout: 1234})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	for pragma, want := range map[string]string{"journal_mode": "wal", "synchronous": "2", "busy_timeout": "1234"} {
+		var got string
+		if err := db.conn.QueryRow("PRAGMA " + pragma).Scan(&got); err != nil {
+			t.Fatalf("PRAGMA %s error = %v", pragma, err)
+		}
+		if got != want {
+			t.Fatalf("PRAGMA %s = %q, want %q", pragma, got, want)
+		}
+	}
+}
+
+func TestOpenMemoryDatabaseSharesOneConnection(t *testing.T) {
+	db, err := Open(MemoryPath, Options{MaxOpenConns: 8})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	// Concurrent writes and reads must all see the same database
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", Prompt: "hello memory"}); err != nil {
+				t.Errorf("Log() error = %v", err)
+			}
+			if _, err := db.GetRecentEntries(5, 0); err != nil {
+				t.Errorf("GetRecentEntries() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count, err := db.GetTotalCount(); err != nil || count != 20 {
+		t.Fatalf("GetTotalCount() = %d, %v; want 20", count, err)
+	}
+	if deleted, err := db.CleanupOldRequests(5); err != nil || deleted != 15 {
+		t.Fatalf("CleanupOldRequests() = %d, %v; want 15 deleted", deleted, err)
+	}
+	if results, err := db.SearchEntries("memory", 10, 0); err != nil || len(results) != 5 {
+		t.Fatalf("SearchEntries() = %d results, %v; want 5", len(results), err)
+	}
+}
+
+func TestSearchEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llm_proxy.db")
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for i, text := range []string{"why is the sky blue", "the sea is blue too", "grass is green"} {
+		if err := db.Log(LogEntry{
+			Timestamp:   time.Date(2026, 6, 19, 12, i, 0, 0, time.UTC),
+			Endpoint:    "/api/chat",
+			Method:      "POST",
+			Prompt:      text,
+			LastMessage: text,
+		}); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	entries, err := db.SearchEntries("BLUE", 10, 0)
+	if err != nil {
+		t.Fatalf("SearchEntries() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Prompt != "the sea is blue too" {
+		t.Fatalf("SearchEntries(BLUE) = %+v, want the two blue entries, newest first", entries)
+	}
+	if entries, _ := db.SearchEntries(`"sky blue" why`, 10, 0); len(entries) != 1 {
+		t.Fatalf("phrase search returned %d entries, want 1", len(entries))
+	}
+	if count, err := db.CountSearchResults(`blue (`); err != nil || count != 2 {
+		t.Fatalf("CountSearchResults() = %d, %v, want 2, nil", count, err)
+	}
+
+	// Cleaned up entries leave the index
+	if _, err := db.CleanupOldRequests(1); err != nil {
+		t.Fatalf("CleanupOldRequests() error = %v", err)
+	}
+	if count, _ := db.CountSearchResults("blue"); count != 0 {
+		t.Fatalf("CountSearchResults() after cleanup = %d, want 0", count)
+	}
+
+	// A database from before the index existed is indexed on open
+	if _, err := db.conn.Exec("DROP TABLE request_fts; DROP TRIGGER request_fts_insert"); err != nil {
+		t.Fatalf("dropping index: %v", err)
+	}
+	db.Close()
+	db, err = New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	if count, _ := db.CountSearchResults("green"); count != 1 {
+		t.Fatalf("CountSearchResults() after rebuild = %d, want 1", count)
+	}
+}
+
+func TestSearchIndexLeavesOutEncryptedValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llm_proxy.db")
+	db, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := db.SetEncryptionKey([]byte("0123456789abcdef0123456789abcdef")); err != nil {
+		t.Fatalf("SetEncryptionKey() error = %v", err)
+	}
+	if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", Prompt: "sealed prompt"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	db.cipher = nil
+	if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", Prompt: "plain prompt"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	if count, _ := db.CountSearchResults("v1"); count != 0 {
+		t.Fatalf("ciphertext indexed: CountSearchResults(v1) = %d", count)
+	}
+	if count, _ := db.CountSearchResults("plain"); count != 1 {
+		t.Fatalf("CountSearchResults(plain) = %d, want 1", count)
+	}
+
+	// A database indexed by the old triggers is reindexed on open
+	if _, err := db.conn.Exec(`DROP TRIGGER request_fts_insert;
+		CREATE TRIGGER request_fts_insert AFTER INSERT ON request BEGIN
+			INSERT INTO request_fts(rowid, prompt, response, last_message)
+			VALUES (new.id, new.prompt, new.response, new.last_message);
+		END;
+		INSERT INTO request_fts(request_fts) VALUES ('rebuild')`); err != nil {
+		t.Fatalf("restoring old trigger: %v", err)
+	}
+	if count, _ := db.CountSearchResults("v1"); count != 1 {
+		t.Fatalf("old index: CountSearchResults(v1) = %d, want 1", count)
+	}
+	db.Close()
+	db, err = New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	if count, _ := db.CountSearchResults("v1"); count != 0 {
+		t.Fatalf("after reindex: CountSearchResults(v1) = %d, want 0", count)
+	}
+
+	// Deleting encrypted entries keeps the index consistent
+	if _, err := db.CleanupOldRequests(0); err != nil {
+		t.Fatalf("CleanupOldRequests() error = %v", err)
+	}
+	if _, err := db.conn.Exec("INSERT INTO request_fts(request_fts, rank) VALUES ('integrity-check', 0)"); err != nil {
+		t.Fatalf("integrity check: %v", err)
+	}
+	if count, _ := db.CountSearchResults("plain"); count != 0 {
+		t.Fatalf("after cleanup: CountSearchResults(plain) = %d, want 0", count)
+	}
+}
+
+func TestGetRequestStats(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	since := time.Date(2026, 6, 19, 0, 0, 0, 0, time.UTC)
+	for _, entry := range []LogEntry{
+		{Timestamp: since.Add(-time.Minute), Endpoint: "/api/chat", Model: "a", LatencyMs: 9999},
+		{Timestamp: since.Add(10 * time.Minute), Endpoint: "/api/chat", Model: "a", StatusCode: 200, LatencyMs: 100, PromptTokens: 10},
+		{Timestamp: since.Add(20 * time.Minute), Endpoint: "/api/chat", Model: "a", StatusCode: 200, LatencyMs: 300, PromptTokens: 20},
+		{Timestamp: since.Add(90 * time.Minute), Endpoint: "/v1/chat/completions", Model: "a", StatusCode: 500, LatencyMs: 200},
+		{Timestamp: since.Add(150 * time.Minute), Endpoint: "/api/generate", Model: "b", StatusCode: 200, LatencyMs: 50, Error: "stream interrupted"},
+	} {
+		entry.Method = "POST"
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	stats, err := db.GetRequestStats(since, since.Add(150*time.Minute), time.Hour, "")
+	if err != nil {
+		t.Fatalf("GetRequestStats() error = %v", err)
+	}
+
+	want := GroupStats{Requests: 4, Errors: 2, AvgLatencyMs: 162, P95LatencyMs: 300, PromptTokens: 30}
+	if stats.Totals != want {
+		t.Fatalf("Totals = %+v, want %+v", stats.Totals, want)
+	}
+	var perHour []int64
+	for _, bucket := range stats.Buckets {
+		perHour = append(perHour, bucket.Requests)
+	}
+	if !reflect.DeepEqual(perHour, []int64{2, 1, 1}) {
+		t.Fatalf("requests per hour = %v, want [2 1 1]", perHour)
+	}
+	if len(stats.Models) != 2 || stats.Models[0].Name != "a" || stats.Models[0].Requests != 3 || stats.Models[0].P95LatencyMs != 300 {
+		t.Fatalf("Models = %+v", stats.Models)
+	}
+	if len(stats.Endpoints) != 3 || stats.Endpoints[0].Name != "/api/chat" || stats.Endpoints[0].ErrorRate() != 0 {
+		t.Fatalf("Endpoints = %+v", stats.Endpoints)
+	}
+}
+
+func TestLogEstimatesCost(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	db.SetPricing(NewPricing(map[string]ModelPrice{
+		"gpt-4o":        {Prompt: 2.5, CachedPrompt: 1.25, Completion: 10},
+		DefaultPriceKey: {Prompt: 1, CachedPrompt: 1, Completion: 1},
+	}))
+
+	for _, entry := range []LogEntry{
+		{Model: "gpt-4o", PromptTokens: 1_000_000, CachedTokens: 400_000, CompletionTokens: 100_000},
+		{Model: "local", PromptTokens: 500_000, CompletionTokens: 500_000},
+	} {
+		entry.Timestamp = time.Now()
+		entry.Endpoint = "/v1/chat/completions"
+		entry.Method = "POST"
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	entries, err := db.GetEntries(LogFilter{Order: "asc"})
+	if err != nil {
+		t.Fatalf("GetEntries() error = %v", err)
+	}
+	// 0.6M * 2.5 + 0.4M * 1.25 + 0.1M * 10 = 1.5 + 0.5 + 1
+	if entries[0].Cost != 3 || entries[0].CompletionTokens != 100_000 {
+		t.Fatalf("gpt-4o cost, completion tokens = %v, %d; want 3, 100000", entries[0].Cost, entries[0].CompletionTokens)
+	}
+	if entries[1].Cost != 1 {
+		t.Fatalf("default priced cost = %v, want 1", entries[1].Cost)
+	}
+}
+
+func TestSubscribeReceivesStoredEntries(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	redactor, err := NewRedactor([]string{"email"}, nil)
+	if err != nil {
+		t.Fatalf("NewRedactor() error = %v", err)
+	}
+	db.SetRedactor(redactor)
+	db.StartWriter(10, 5)
+
+	entries, unsubscribe := db.Subscribe(10)
+	defer unsubscribe()
+	for _, prompt := range []string{"first", "mail bob@example.com"} {
+		if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", Prompt: prompt}); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	for i, want := range []string{"first", "mail [REDACTED:email]"} {
+		select {
+		case entry := <-entries:
+			if entry.ID != int64(i+1) || entry.Prompt != want {
+				t.Fatalf("entry %d = #%d %q, want #%d %q", i, entry.ID, entry.Prompt, i+1, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for entry %d", i)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, ok := <-entries; ok {
+		t.Fatal("subscription still open after Close()")
+	}
+}
+
+func TestDeleteEntries(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	for _, entry := range []LogEntry{
+		{Model: "a", Prompt: "my secret password"},
+		{Model: "a", Prompt: "nothing to see"},
+		{Model: "b", Prompt: "another secret"},
+		{Model: "b", Prompt: "hello"},
+	} {
+		entry.Timestamp = time.Now()
+		entry.Endpoint = "/api/chat"
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	if deleted, err := db.DeleteEntry(4); err != nil || !deleted {
+		t.Fatalf("DeleteEntry(4) = %v, %v; want true", deleted, err)
+	}
+	if deleted, err := db.DeleteEntry(4); err != nil || deleted {
+		t.Fatalf("second DeleteEntry(4) = %v, %v; want false", deleted, err)
+	}
+
+	count, err := db.DeleteEntries(LogFilter{Search: "secret"})
+	if err != nil || count != 2 {
+		t.Fatalf("DeleteEntries() = %d, %v; want 2", count, err)
+	}
+	if results, err := db.CountSearchResults("secret"); err != nil || results != 0 {
+		t.Fatalf("CountSearchResults() = %d, %v; want 0", results, err)
+	}
+	entries, err := db.GetEntries(LogFilter{})
+	if err != nil {
+		t.Fatalf("GetEntries() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != 2 {
+		t.Fatalf("remaining entries = %+v, want only #2", entries)
+	}
+}
+
+func TestAuditEntries(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	start := time.Date(2024, 10, 17, 12, 0, 0, 0, time.UTC)
+	for i, action := range []string{"delete", "retry", "config_reload"} {
+		entry := AuditEntry{Timestamp: start.Add(time.Duration(i) * time.Minute), Actor: "alice", Action: action, Detail: "request #1"}
+		if err := db.Audit(entry); err != nil {
+			t.Fatalf("Audit() error = %v", err)
+		}
+	}
+	if err := db.Audit(AuditEntry{Actor: "SIGHUP", Action: "config_reload"}); err != nil {
+		t.Fatalf("Audit() error = %v", err)
+	}
+
+	if count, err := db.CountAuditEntries(); err != nil || count != 4 {
+		t.Fatalf("CountAuditEntries() = %d, %v; want 4", count, err)
+	}
+	entries, err := db.GetAuditEntries(2, 1)
+	if err != nil {
+		t.Fatalf("GetAuditEntries() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != "config_reload" || entries[0].Actor != "alice" || entries[1].Action != "retry" {
+		t.Fatalf("GetAuditEntries(2, 1) = %+v, want the newest entries after the first, newest first", entries)
+	}
+	if !entries[1].Timestamp.Equal(start.Add(time.Minute)) {
+		t.Fatalf("timestamp = %v, want %v", entries[1].Timestamp, start.Add(time.Minute))
+	}
+}
+
+func TestLogTruncatesLargePayloads(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	spillDir := filepath.Join(t.TempDir(), "spill")
+	if err := db.SetPayloadLimit(10, spillDir); err != nil {
+		t.Fatalf("SetPayloadLimit() error = %v", err)
+	}
+
+	large := `{"data":"ééééééééé"}`
+	if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", FrontendRequest: "small", BackendResponse: large}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	got, err := db.GetEntryByID(1)
+	if err != nil {
+		t.Fatalf("GetEntryByID() error = %v", err)
+	}
+
+	if got.FrontendRequest != "small" || got.FrontendRequestSize != 5 || got.BackendResponseSize != len(large) {
+		t.Fatalf("sizes = %d, %d; frontend request %q", got.FrontendRequestSize, got.BackendResponseSize, got.FrontendRequest)
+	}
+	prefix := `{"data":"` + "\n[truncated: 9 of 29 bytes stored; full payload in "
+	if !strings.HasPrefix(got.BackendResponse, prefix) {
+		t.Fatalf("BackendResponse = %q, want it cut at a character boundary", got.BackendResponse)
+	}
+	path := strings.TrimSuffix(strings.TrimPrefix(got.BackendResponse, prefix), "]")
+	if spilled, err := os.ReadFile(path); err != nil || string(spilled) != large {
+		t.Fatalf("spilled payload = %q, %v", spilled, err)
+	}
+}
+
+func TestResponseCacheEncryptsResponses(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+	if err := db.SetEncryptionKey([]byte("0123456789abcdef")); err != nil {
+		t.Fatalf("SetEncryptionKey() error = %v", err)
+	}
+
+	if err := db.StoreCachedResponse("k", []byte(`{"secret":true}`), 0); err != nil {
+		t.Fatalf("StoreCachedResponse() error = %v", err)
+	}
+	var stored string
+	if err := db.conn.QueryRow("SELECT response FROM response_cache WHERE cache_key = 'k'").Scan(&stored); err != nil {
+		t.Fatalf("QueryRow() error = %v", err)
+	}
+	if !strings.HasPrefix(stored, encryptedPrefix) || strings.Contains(stored, "secret") {
+		t.Fatalf("stored response %q is not encrypted", stored)
+	}
+	if got, ok, err := db.GetCachedResponse("k", time.Hour); err != nil || !ok || string(got) != `{"secret":true}` {
+		t.Fatalf("GetCachedResponse() = %q, %v, %v", got, ok, err)
+	}
+
+	// Under another key the response can't be read, so it is a miss
+	if err := db.SetEncryptionKey([]byte("fedcba9876543210")); err != nil {
+		t.Fatalf("SetEncryptionKey() error = %v", err)
+	}
+	if _, ok, err := db.GetCachedResponse("k", time.Hour); err != nil || ok {
+		t.Fatalf("GetCachedResponse() with another key = %v, %v; want a miss", ok, err)
+	}
+}
+
+func TestLogCompressesPayloads(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	large := `{"messages":[` + strings.Repeat(`{"role":"user","content":"hello"},`, 100) + `{}]}`
+	if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", FrontendRequest: large, BackendRequest: "small"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	db.SetCompressPayloads(true)
+	if err := db.Log(LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", FrontendRequest: large, BackendRequest: "small"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	var plain, compressed int
+	if err := db.conn.QueryRow("SELECT length(frontend_request) FROM request WHERE id = 1").Scan(&plain); err != nil {
+		t.Fatalf("QueryRow() error = %v", err)
+	}
+	if err := db.conn.QueryRow("SELECT length(frontend_request) FROM request WHERE id = 2").Scan(&compressed); err != nil {
+		t.Fatalf("QueryRow() error = %v", err)
+	}
+	if compressed >= plain/4 {
+		t.Fatalf("stored sizes = %d plain, %d compressed; want compressed much smaller", plain, compressed)
+	}
+
+	entries, err := db.GetEntries(LogFilter{})
+	if err != nil {
+		t.Fatalf("GetEntries() error = %v", err)
+	}
+	for _, entry := range entries {
+		if entry.FrontendRequest != large || entry.BackendRequest != "small" {
+			t.Fatalf("entry #%d bodies = %q, %q", entry.ID, entry.FrontendRequest, entry.BackendRequest)
+		}
+	}
+}
+
+func TestLogEncryptsContent(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	if err := db.SetEncryptionKey([]byte("0123456789abcdef0123456789abcdef")); err != nil {
+		t.Fatalf("SetEncryptionKey() error = %v", err)
+	}
+	db.SetCompressPayloads(true)
+	large := `{"messages":[` + strings.Repeat(`{"role":"user","content":"secret"},`, 100) + `{}]}`
+	entry := LogEntry{Timestamp: time.Now(), Endpoint: "/api/chat", Prompt: "secret prompt", Response: "secret response", LastMessage: "secret", FrontendRequest: large, BackendRequest: "small",
+		Error: "backend rejected secret", FrontendHeaders: `{"X-Secret":["secret"]}`}
+	if err := db.Log(entry); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	var prompt, request, response, errorText, headers string
+	if err := db.conn.QueryRow("SELECT prompt, frontend_request, COALESCE(backend_response, ''), error, frontend_headers FROM request WHERE id = 1").Scan(&prompt, &request, &response, &errorText, &headers); err != nil {
+		t.Fatalf("QueryRow() error = %v", err)
+	}
+	for name, stored := range map[string]string{"prompt": prompt, "request": request, "error": errorText, "headers": headers} {
+		if !strings.HasPrefix(stored, encryptedPrefix) || strings.Contains(stored, "secret") {
+			t.Fatalf("stored %s %q is not encrypted", name, stored)
+		}
+	}
+	if response != "" {
+		t.Fatalf("empty backend_response stored as %q", response)
+	}
+
+	got, err := db.GetEntryByID(1)
+	if err != nil || got == nil {
+		t.Fatalf("GetEntryByID() = %v, %v", got, err)
+	}
+	if got.Prompt != entry.Prompt || got.Response != entry.Response || got.LastMessage != entry.LastMessage || got.FrontendRequest != large || got.BackendRequest != "small" ||
+		got.Error != entry.Error || got.FrontendHeaders != entry.FrontendHeaders {
+		t.Fatalf("decrypted entry = %+v", got)
+	}
+
+	// Without the key the content stays unreadable
+	db.cipher = nil
+	if got, _ = db.GetEntryByID(1); got.Prompt == entry.Prompt || !strings.HasPrefix(got.Prompt, "[encrypted") {
+		t.Fatalf("Prompt without key = %q", got.Prompt)
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RequestStats aggregates the requests logged in a period
+type RequestStats struct {
+	Since      time.Time
+	BucketSize time.Duration
+	Buckets    []TimeBucket // Requests per BucketSize, oldest first
+	Totals     GroupStats   // All requests; Name is empty
+	Models     []GroupStats // Per model, most requests first
+	Endpoints  []GroupStats // Per endpoint, most requests first
+	Usage      []DailyUsage // Per day and model, newest day first
+}
+
+// DailyUsage totals the tokens and estimated cost of a model's requests on
+// one (local) day
+type DailyUsage struct {
+	Day              time.Time // Midnight at the start of the day
+	Model            string
+	Requests         int64
+	PromptTokens     int64
+	CompletionTokens int64
+	Cost             float64
+}
+
+// TimeBucket counts the requests that started in [Start, Start+size)
+type TimeBucket struct {
+	Start    time.Time
+	Requests int64
+	Errors   int64
+}
+
+// GroupStats summarises a group of requests
+type GroupStats struct {
+	Name             string
+	Requests         int64
+	Errors           int64 // Requests with an error or an HTTP status of 400 or above
+	AvgLatencyMs     int64
+	P95LatencyMs     int64
+	PromptTokens     int64
+	CompletionTokens int64
+	Cost             float64 // Estimated, see Pricing
+}
+
+// ErrorRate returns the percentage of requests that failed
+func (g GroupStats) ErrorRate() float64 {
+	if g.Requests == 0 {
+		return 0
+	}
+	return float64(g.Errors) * 100 / float64(g.Requests)
+}
+
+// groupAccumulator collects the requests of one GroupStats
+type groupAccumulator struct {
+	stats     GroupStats
+	latencies []int64
+}
+
+func (a *groupAccumulator) add(latencyMs int64, failed bool, promptTokens, completionTokens int64, cost float64) {
+	a.stats.Requests++
+	if failed {
+		a.stats.Errors++
+	}
+	a.stats.PromptTokens += promptTokens
+	a.stats.CompletionTokens += completionTokens
+	a.stats.Cost += cost
+	a.latencies = append(a.latencies, latencyMs)
+}
+
+func (a *groupAccumulator) result() GroupStats {
+	stats := a.stats
+	if len(a.latencies) == 0 {
+		return stats
+	}
+	var sum int64
+	for _, latency := range a.latencies {
+		sum += latency
+	}
+	stats.AvgLatencyMs = sum / int64(len(a.latencies))
+
+	// Nearest-rank percentile
+	slices.Sort(a.latencies)
+	rank := (len(a.latencies)*95 + 99) / 100
+	stats.P95LatencyMs = a.latencies[rank-1]
+	return stats
+}
+
+// GetRequestStats aggregates the requests logged from since until now,
+// counting them in buckets of bucketSize aligned to since. A non-empty tenant
+// limits it to that tenant's requests.
+func (db *DB) GetRequestStats(since, now time.Time, bucketSize time.Duration, tenant string) (*RequestStats, error) {
+	// Timestamps are stored as Go time strings, which SQLite's date functions
+	// can't parse, so rows are grouped here rather than in SQL
+	rows, err := db.conn.Query(`
+		SELECT timestamp, endpoint, COALESCE(model, ''), COALESCE(status_code, 0), COALESCE(latency_ms, 0), COALESCE(error, ''), prompt_tokens, completion_tokens, cost
+		FROM request
+		WHERE timestamp >= ? AND (? = '' OR tenant = ?)
+	`, since, tenant, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request stats: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := make([]TimeBucket, int(now.Sub(since)/bucketSize)+1)
+	for i := range buckets {
+		buckets[i].Start = since.Add(time.Duration(i) * bucketSize)
+	}
+	var totals groupAccumulator
+	models := make(map[string]*groupAccumulator)
+	endpoints := make(map[string]*groupAccumulator)
+	usage := make(map[[2]string]*DailyUsage) // Keyed by date and model
+
+	for rows.Next() {
+		var (
+			timestamp        time.Time
+			endpoint         string
+			model            string
+			statusCode       int
+			latencyMs        int64
+			errorText        string
+			promptTokens     int64
+			completionTokens int64
+			cost             float64
+		)
+		if err := rows.Scan(&timestamp, &endpoint, &model, &statusCode, &latencyMs, &errorText, &promptTokens, &completionTokens, &cost); err != nil {
+			return nil, fmt.Errorf("failed to scan request stats: %w", err)
+		}
+		failed := errorText != "" || statusCode >= 400
+
+		if i := int(timestamp.Sub(since) / bucketSize); i >= 0 && i < len(buckets) {
+			buckets[i].Requests++
+			if failed {
+				buckets[i].Errors++
+			}
+		}
+		totals.add(latencyMs, failed, promptTokens, completionTokens, cost)
+		groupFor(models, model).add(latencyMs, failed, promptTokens, completionTokens, cost)
+		groupFor(endpoints, endpoint).add(latencyMs, failed, promptTokens, completionTokens, cost)
+
+		local := timestamp.In(since.Location())
+		key := [2]string{local.Format(time.DateOnly), model}
+		day := usage[key]
+		if day == nil {
+			midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, since.Location())
+			day = &DailyUsage{Day: midnight, Model: model}
+			usage[key] = day
+		}
+		day.Requests++
+		day.PromptTokens += promptTokens
+		day.CompletionTokens += completionTokens
+		day.Cost += cost
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return &RequestStats{
+		Since:      since,
+		Totals:     totals.result(),
+		Buckets:    buckets,
+		BucketSize: bucketSize,
+		Models:     groupResults(models),
+		Endpoints:  groupResults(endpoints),
+		Usage:      dailyUsageResults(usage),
+	}, nil
+}
+
+// groupFor returns the accumulator for name, creating it if needed
+func groupFor(groups map[string]*groupAccumulator, name string) *groupAccumulator {
+	group := groups[name]
+	if group == nil {
+		group = &groupAccumulator{stats: GroupStats{Name: name}}
+		groups[name] = group
+	}
+	return group
+}
+
+// groupResults returns the stats of each group, most requests first
+func groupResults(groups map[string]*groupAccumulator) []GroupStats {
+	results := make([]GroupStats, 0, len(groups))
+	for _, group := range groups {
+		results = append(results, group.result())
+	}
+	slices.SortFunc(results, func(a, b GroupStats) int {
+		if a.Requests != b.Requests {
+			return cmp.Compare(b.Requests, a.Requests)
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return results
+}
+
+// dailyUsageResults returns the daily usage, newest day first and then by
+// model
+func dailyUsageResults(usage map[[2]string]*DailyUsage) []DailyUsage {
+	results := make([]DailyUsage, 0, len(usage))
+	for _, day := range usage {
+		results = append(results, *day)
+	}
+	slices.SortFunc(results, func(a, b DailyUsage) int {
+		if !a.Day.Equal(b.Day) {
+			return b.Day.Compare(a.Day)
+		}
+		return cmp.Compare(a.Model, b.Model)
+	})
+	return results
+}
@@ -0,0 +1,56 @@
+package database
+
+// Subscribe returns a channel that receives each entry as it is stored, with
+// its ID set and redaction applied, and a function that ends the
+// subscription. Entries are dropped rather than holding up logging when the
+// channel's buffer is full. The channel is closed when the subscription ends
+// or CloseSubscriptions is called.
+func (db *DB) Subscribe(buffer int) (<-chan LogEntry, func()) {
+	ch := make(chan LogEntry, buffer)
+
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	if db.subsClosed {
+		close(ch)
+		return ch, func() {}
+	}
+	if db.subs == nil {
+		db.subs = make(map[chan LogEntry]struct{})
+	}
+	db.subs[ch] = struct{}{}
+
+	return ch, func() {
+		db.subsMu.Lock()
+		defer db.subsMu.Unlock()
+		if _, ok := db.subs[ch]; ok {
+			delete(db.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// CloseSubscriptions ends every subscription and refuses new ones, so that
+// long-lived streams don't hold up shutdown.
+func (db *DB) CloseSubscriptions() {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	for ch := range db.subs {
+		close(ch)
+	}
+	db.subs = nil
+	db.subsClosed = true
+}
+
+// publish sends stored entries to the subscribers
+func (db *DB) publish(entries ...LogEntry) {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	for ch := range db.subs {
+		for _, entry := range entries {
+			select {
+			case ch <- entry:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"slices"
+)
+
+// StartWriter moves log writes off the request path. Log queues entries for a
+// background goroutine, which stores them in batches of up to batchSize, one
+// transaction per batch. Log only blocks once queueSize entries are waiting.
+// Close writes whatever is still queued. Call it once, before the first Log.
+func (db *DB) StartWriter(queueSize, batchSize int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.queue = make(chan LogEntry, queueSize)
+	db.writerDone = make(chan struct{})
+	go db.runWriter(db.queue, max(batchSize, 1), db.writerDone)
+}
+
+// QueueDepth returns the number of log entries waiting for the background
+// writer and how many fit in its queue (both 0 without StartWriter)
+func (db *DB) QueueDepth() (depth, capacity int) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.queue), cap(db.queue)
+}
+
+// runWriter stores queued entries until queue is closed, then closes done.
+func (db *DB) runWriter(queue <-chan LogEntry, batchSize int, done chan<- struct{}) {
+	defer close(done)
+
+	batch := make([]LogEntry, 0, batchSize)
+	for entry := range queue {
+		batch = append(batch[:0], entry)
+		// Take whatever else is already waiting, up to a full batch
+	fill:
+		for len(batch) < batchSize {
+			select {
+			case next, ok := <-queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		if err := db.insertBatch(batch); err != nil {
+			log.Printf("Error logging requests: %v", err)
+		}
+	}
+}
+
+// insertBatch inserts entries in a single transaction. If that fails, they
+// are inserted one at a time instead, so one entry that can't be stored
+// doesn't lose the others.
+func (db *DB) insertBatch(entries []LogEntry) error {
+	// insertEntry redacts and truncates in place, so a retry starts from
+	// the entries as queued
+	queued := slices.Clone(entries)
+	err := db.insertTx(entries)
+	if err == nil {
+		return nil
+	}
+	if len(entries) == 1 {
+		return fmt.Errorf("1 of 1 request(s) not logged: %w", err)
+	}
+
+	log.Printf("Failed to log a batch of %d request(s), logging them one at a time: %v", len(entries), err)
+	failed := 0
+	for i := range queued {
+		if insertErr := db.insertEntry(db.conn, &queued[i]); insertErr != nil {
+			failed++
+			err = insertErr
+			continue
+		}
+		db.publish(queued[i])
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d request(s) not logged: %w", failed, len(entries), err)
+	}
+	return nil
+}
+
+// insertTx inserts entries in a single transaction.
+func (db *DB) insertTx(entries []LogEntry) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for i := range entries {
+		if err := db.insertEntry(tx, &entries[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit log entries: %w", err)
+	}
+	db.publish(entries...)
+	return nil
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// requestActor names who made the request on ctx for the audit log: the
+// user, else the client IP
+func requestActor(ctx context.Context) string {
+	info := backend.RequestInfoFromContext(ctx)
+	switch {
+	case info.User != "" && info.ClientIP != "":
+		return info.User + " (" + info.ClientIP + ")"
+	case info.User != "":
+		return info.User
+	case info.ClientIP != "":
+		return info.ClientIP
+	}
+	return "unknown"
+}
+
+// audit records an administrative action made by the request on ctx
+func audit(ctx context.Context, db *database.DB, action, detail string) {
+	entry := database.AuditEntry{Actor: requestActor(ctx), Action: action, Detail: detail}
+	if err := db.Audit(entry); err != nil {
+		log.Printf("Failed to record %s in the audit log: %v", action, err)
+	}
+}
+
+// AuditHandler serves the audit log page, which lists administrative
+// actions, newest first
+func (h *WebHandler) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	page := 1
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	total, err := h.db.CountAuditEntries()
+	if err != nil {
+		log.Printf("Error counting audit entries: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	entries, err := h.db.GetAuditEntries(pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Printf("Error getting audit entries: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	data := struct {
+		Entries     []database.AuditEntry
+		CurrentPage int
+		TotalPages  int
+		TotalCount  int64
+		HasPrev     bool
+		HasNext     bool
+		PrevPage    int
+		NextPage    int
+	}{
+		Entries:     entries,
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		TotalCount:  total,
+		HasPrev:     page > 1,
+		HasNext:     page < totalPages,
+		PrevPage:    page - 1,
+		NextPage:    page + 1,
+	}
+
+	renderTemplate(w, "audit.html", data)
+}
@@ -3,60 +3,77 @@ package handlers
 import (
 	"log"
 	"strings"
+	"time"
 
 	"llm_proxy/config"
 	"llm_proxy/models"
 )
 
 func applyChatFeatures(req *models.ChatRequest, cfg *config.Config) {
+	if cfg.ChatTextInjection.Enabled && cfg.ChatTextInjection.SystemPrompt != "" {
+		applySystemPrompt(req, cfg)
+	}
 	if cfg.ChatTextInjection.Enabled && cfg.ChatTextInjection.Text != "" {
 		applyChatTextInjection(req, cfg)
 	}
-	if len(cfg.Backend.ToolBlacklist) > 0 {
-		filterChatTools(req, cfg)
+	if len(req.Tools) > 0 {
+		transformChatTools(req, cfg)
 	}
 }
 
-func filterChatTools(req *models.ChatRequest, cfg *config.Config) {
-	if len(req.Tools) == 0 {
-		return
-	}
+// expandPromptTemplate fills in the {date}, {time} and {model} variables.
+func expandPromptTemplate(template, model string, now time.Time) string {
+	return strings.NewReplacer(
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("15:04"),
+		"{model}", model,
+	).Replace(template)
+}
 
-	blacklist := make(map[string]bool)
-	for _, toolName := range cfg.Backend.ToolBlacklist {
-		blacklist[toolName] = true
-	}
+func applySystemPrompt(req *models.ChatRequest, cfg *config.Config) {
+	prompt := expandPromptTemplate(cfg.ChatTextInjection.SystemPrompt, req.Model, time.Now())
+	mode := cfg.ChatTextInjection.SystemPromptMode
 
-	var filteredTools []interface{}
-	for _, tool := range req.Tools {
-		toolMap, ok := tool.(map[string]interface{})
-		if !ok {
-			filteredTools = append(filteredTools, tool)
-			continue
+	systemIndex := -1
+	for i, msg := range req.Messages {
+		if msg.Role == "system" {
+			systemIndex = i
+			break
 		}
+	}
 
-		var toolName string
-		if funcField, ok := toolMap["function"].(map[string]interface{}); ok {
-			if name, ok := funcField["name"].(string); ok {
-				toolName = name
-			}
+	if systemIndex == -1 {
+		if cfg.Server.Verbose {
+			log.Printf("[VERBOSE] Adding system prompt: %q", prompt)
 		}
-
-		if toolName == "" || !blacklist[toolName] {
-			filteredTools = append(filteredTools, tool)
-			continue
+		systemMsg := models.Message{
+			Role:    "system",
+			Content: prompt,
 		}
+		req.Messages = append([]models.Message{systemMsg}, req.Messages...)
+		return
+	}
 
-		if cfg.Server.Verbose {
-			log.Printf("[VERBOSE] Filtering out blacklisted tool: %s", toolName)
-		}
+	existing := req.Messages[systemIndex].Content
+	if mode == "default" || strings.Contains(existing, prompt) {
+		return
 	}
 
-	req.Tools = filteredTools
+	if cfg.Server.Verbose {
+		log.Printf("[VERBOSE] Applying system prompt (%s): %q", mode, prompt)
+	}
+	switch mode {
+	case "replace":
+		req.Messages[systemIndex].SetContent(prompt)
+	case "append":
+		req.Messages[systemIndex].SetContent(existing + "\n\n" + prompt)
+	default:
+		req.Messages[systemIndex].SetContent(prompt + "\n\n" + existing)
+	}
 }
 
 func applyChatTextInjection(req *models.ChatRequest, cfg *config.Config) {
-	injectionText := cfg.ChatTextInjection.Text
+	injectionText := expandPromptTemplate(cfg.ChatTextInjection.Text, req.Model, time.Now())
 	mode := cfg.ChatTextInjection.Mode
 
 	if mode == "system" {
@@ -60,6 +60,10 @@ func (s *spyChatBackend) ShowModel(context.Context, string) (models.ShowResponse
 	return models.ShowResponse{}, nil
 }
 
+func (s *spyChatBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 func TestChatFeatureParity(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -198,6 +202,93 @@ func TestChatToolBlacklistParity(t *testing.T) {
 	}
 }
 
+func TestChatToolPolicies(t *testing.T) {
+	tools := []interface{}{
+		map[string]interface{}{"function": map[string]interface{}{"name": "read_file"}},
+		map[string]interface{}{"function": map[string]interface{}{"name": "web_search"}},
+		map[string]interface{}{"function": map[string]interface{}{"name": "shell"}},
+	}
+	cfg := &config.Config{
+		Backend: config.BackendConfig{ToolBlacklist: []string{"shell"}},
+		Tools: config.ToolsConfig{
+			Mode:  "whitelist",
+			Tools: []string{"read_file", "shell"},
+			Models: map[string]config.ToolPolicyConfig{
+				"tiny":   {Mode: "strip"},
+				"search": {Mode: "blacklist", Tools: []string{"read_file"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		model      string
+		toolChoice interface{}
+		want       []string
+		wantChoice bool
+	}{
+		{"llama3", "auto", []string{"read_file"}, true},
+		{"search", map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "read_file"}}, []string{"web_search"}, false},
+		{"tiny", "required", nil, false},
+	}
+	for _, tt := range tests {
+		parallel := true
+		req := models.ChatRequest{
+			Model:             tt.model,
+			Messages:          []models.Message{{Role: "user", Content: "hi"}},
+			Tools:             tools,
+			ToolChoice:        tt.toolChoice,
+			ParallelToolCalls: &parallel,
+		}
+		applyChatFeatures(&req, cfg)
+
+		var got []string
+		for _, tool := range req.Tools {
+			got = append(got, toolName(tool))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: tools = %v, want %v", tt.model, got, tt.want)
+		}
+		if (req.ToolChoice != nil) != tt.wantChoice {
+			t.Errorf("%s: tool_choice = %v, want kept = %v", tt.model, req.ToolChoice, tt.wantChoice)
+		}
+		if len(req.Tools) == 0 && req.ParallelToolCalls != nil {
+			t.Errorf("%s: parallel_tool_calls kept without tools", tt.model)
+		}
+	}
+}
+
+func TestChatSystemPromptModes(t *testing.T) {
+	now := time.Now()
+	prompt := "Today is " + now.Format("2006-01-02") + ". You are m."
+	tests := []struct {
+		mode     string
+		messages []models.Message
+		want     string
+	}{
+		{"prepend", []models.Message{{Role: "user", Content: "hi"}}, prompt},
+		{"prepend", []models.Message{{Role: "system", Content: "be brief"}}, prompt + "\n\nbe brief"},
+		{"append", []models.Message{{Role: "system", Content: "be brief"}}, "be brief\n\n" + prompt},
+		{"replace", []models.Message{{Role: "system", Content: "be brief"}}, prompt},
+		{"default", []models.Message{{Role: "system", Content: "be brief"}}, "be brief"},
+		{"default", []models.Message{{Role: "user", Content: "hi"}}, prompt},
+	}
+
+	for _, tt := range tests {
+		cfg := &config.Config{
+			ChatTextInjection: config.ChatTextInjectionConfig{
+				Enabled:          true,
+				SystemPrompt:     "Today is {date}. You are {model}.",
+				SystemPromptMode: tt.mode,
+			},
+		}
+		req := models.ChatRequest{Model: "m", Messages: cloneMessages(tt.messages)}
+		applyChatFeatures(&req, cfg)
+		if req.Messages[0].Role != "system" || req.Messages[0].Content != tt.want {
+			t.Fatalf("%s: first message = (%q, %q), want system %q", tt.mode, req.Messages[0].Role, req.Messages[0].Content, tt.want)
+		}
+	}
+}
+
 func newChatFeatureTestDB(t *testing.T, mode string) (*database.DB, *config.Config) {
 	t.Helper()
 
@@ -324,6 +415,84 @@ func assertOriginalLog(t *testing.T, db *database.DB, wantLast string, injected
 	}
 }
 
+func TestChatLogsResponseCacheHits(t *testing.T) {
+	db := newEmbedTestDB(t)
+	cached := backend.NewCachingBackend(&spyChatBackend{}, backend.NewMemoryResponseCache(), time.Hour, 10)
+	handler := NewChatHandler(cached, db, embedTestConfig())
+
+	for range 2 {
+		body := string(postEmbed(t, handler, "/api/chat", `{"model":"m","stream":false,"messages":[{"role":"user","content":"hi"}]}`))
+		if !strings.Contains(body, `"content":"ok"`) {
+			t.Fatalf("body = %s", body)
+		}
+	}
+
+	entries, err := db.GetRecentEntries(2, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if len(entries) != 2 || !entries[0].CacheHit || entries[1].CacheHit {
+		t.Fatalf("CacheHit = %v, %v, want only the second request flagged", entries[0].CacheHit, entries[1].CacheHit)
+	}
+	if entries[0].BackendRequest != `{"backend_request":true}` {
+		t.Fatalf("cached BackendRequest = %q", entries[0].BackendRequest)
+	}
+}
+
+// toolCallChunksBackend streams one tool call per chunk, as Ollama does
+type toolCallChunksBackend struct {
+	spyChatBackend
+}
+
+func (b *toolCallChunksBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *backend.BackendMetadata, error) {
+	ch := make(chan models.ChatResponse, 3)
+	for _, name := range []string{"get_weather", "get_time"} {
+		ch <- models.ChatResponse{Model: req.Model, Message: models.Message{
+			Role:      "assistant",
+			ToolCalls: []interface{}{map[string]interface{}{"function": map[string]interface{}{"name": name, "arguments": map[string]interface{}{}}}},
+		}}
+	}
+	ch <- models.ChatResponse{Model: req.Model, Done: true, DoneReason: "stop"}
+	close(ch)
+	return ch, &backend.BackendMetadata{}, nil
+}
+
+func TestChatCombinesToolCallsAcrossChunks(t *testing.T) {
+	db, cfg := newChatFeatureTestDB(t, "")
+	for _, api := range []string{"ollama", "openai"} {
+		var handler http.Handler = NewChatHandler(&toolCallChunksBackend{}, db, cfg)
+		path := "/api/chat"
+		if api == "openai" {
+			handler = NewOpenAIChatCompletionsHandler(&toolCallChunksBackend{}, db, cfg)
+			path = "/v1/chat/completions"
+		}
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model":"m","stream":false,"messages":[{"role":"user","content":"hi"}]}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var resp struct {
+			Message struct {
+				ToolCalls []interface{} `json:"tool_calls"`
+			} `json:"message"`
+			Choices []struct {
+				Message struct {
+					ToolCalls []interface{} `json:"tool_calls"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: decode response: %v\n%s", api, err, rec.Body.String())
+		}
+		calls := resp.Message.ToolCalls
+		if api == "openai" && len(resp.Choices) == 1 {
+			calls = resp.Choices[0].Message.ToolCalls
+		}
+		if len(calls) != 2 || toolName(calls[0]) != "get_weather" || toolName(calls[1]) != "get_time" {
+			t.Fatalf("%s: tool calls = %v, want both chunks' calls", api, calls)
+		}
+	}
+}
+
 func toolName(tool interface{}) string {
 	toolMap, ok := tool.(map[string]interface{})
 	if !ok {
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DeleteHandler removes logged requests from the local database. It takes a
+// POST with either an "id" form field, to delete one request, or "all=1" and
+// the logs page filter parameters in the URL, to delete every request
+// matching them. It then redirects to the "redirect" form field (a local
+// path, default /logs).
+func (h *WebHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Forms posted from other sites can't delete anything
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	if r.PostForm.Get("all") == "1" {
+		filter, applied := logsPageFilter(r.URL.Query())
+		if len(applied) == 0 {
+			http.Error(w, "Refusing to delete every request; apply a filter first", http.StatusBadRequest)
+			return
+		}
+		deleted, err := h.db.DeleteEntries(filter)
+		if err != nil {
+			log.Printf("Error deleting entries: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Deleted %d logged request(s) matching %s", deleted, applied.Encode())
+		audit(r.Context(), h.db, "delete", fmt.Sprintf("%d request(s) matching %s", deleted, applied.Encode()))
+	} else {
+		id, err := strconv.ParseInt(r.PostForm.Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid ID parameter", http.StatusBadRequest)
+			return
+		}
+		deleted, err := h.db.DeleteEntry(id)
+		if err != nil {
+			log.Printf("Error deleting entry: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("Deleted logged request #%d", id)
+		audit(r.Context(), h.db, "delete", fmt.Sprintf("request #%d", id))
+	}
+
+	http.Redirect(w, r, localRedirect(r.PostForm.Get("redirect"), "/logs"), http.StatusSeeOther)
+}
+
+// sameOrigin reports whether a browser request came from a page served by
+// this proxy. Requests without an Origin header (not sent by browsers for
+// same-origin form posts in older versions, nor by scripts) are allowed.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// localRedirect returns target if it is a path on this server, otherwise
+// fallback
+func localRedirect(target, fallback string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return fallback
+	}
+	return target
+}
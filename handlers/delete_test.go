@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDeleteHandler(t *testing.T) {
+	db := newLogsAPITestDB(t)
+	handler := NewWebHandler(db, nil)
+
+	post := func(target string, form url.Values, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.DeleteHandler(rec, req)
+		return rec
+	}
+
+	if rec := post("/logs/delete", url.Values{"id": {"1"}}, "https://evil.example"); rec.Code != http.StatusForbidden {
+		t.Fatalf("cross-origin status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := post("/logs/delete", url.Values{"all": {"1"}}, ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unfiltered delete all status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := post("/logs/delete", url.Values{"id": {"1"}, "redirect": {"/logs?page=2"}}, "http://example.com")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/logs?page=2" {
+		t.Fatalf("delete status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := post("/logs/delete", url.Values{"id": {"1"}}, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = post("/logs/delete?model=other-model", url.Values{"all": {"1"}, "redirect": {"//evil.example"}}, "")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/logs" {
+		t.Fatalf("delete all status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if count, err := db.GetTotalCount(); err != nil || count != 0 {
+		t.Fatalf("GetTotalCount() = %d, %v; want 0", count, err)
+	}
+
+	audited, err := db.GetAuditEntries(10, 0)
+	if err != nil {
+		t.Fatalf("GetAuditEntries() error = %v", err)
+	}
+	if len(audited) != 2 || audited[0].Action != "delete" || audited[0].Detail != "1 request(s) matching model=other-model" || audited[1].Detail != "request #1" {
+		t.Fatalf("audit entries = %+v, want the two deletions", audited)
+	}
+}
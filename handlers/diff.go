@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"llm_proxy/database"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// maxDiffCells bounds the table used to diff the lines that differ between
+// the two texts. Larger changes are shown as a whole block removed and added.
+const maxDiffCells = 4_000_000
+
+// diffRow is one row of a side-by-side diff. Kind is "same", "changed",
+// "removed", "added" or "skip" (a run of Skipped unchanged lines).
+type diffRow struct {
+	Kind     string
+	LeftNum  int // 1-based line number, 0 = no line on this side
+	Left     string
+	RightNum int
+	Right    string
+	Skipped  int
+}
+
+// diffSection is a field of the two entries compared on the diff page
+type diffSection struct {
+	Title     string
+	Rows      []diffRow
+	Identical bool
+}
+
+// diffOp is one step of a line diff: a line kept, removed from the left or
+// added on the right
+type diffOp struct {
+	Kind  byte // '=', '-' or '+'
+	Left  int  // Index into the left lines (for '=' and '-')
+	Right int  // Index into the right lines (for '=' and '+')
+}
+
+// DiffHandler serves a side-by-side comparison of two logged requests, named
+// by the "a" and "b" query parameters (with optional "source_a" and
+// "source_b" for federated entries)
+func (h *WebHandler) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	left, leftSource, ok := h.entryFromParams(w, r, "a", "source_a")
+	if !ok {
+		return
+	}
+	right, rightSource, ok := h.entryFromParams(w, r, "b", "source_b")
+	if !ok {
+		return
+	}
+
+	data := struct {
+		Left        *database.LogEntry
+		LeftSource  string
+		Right       *database.LogEntry
+		RightSource string
+		Sections    []diffSection
+	}{
+		Left:        left,
+		LeftSource:  leftSource,
+		Right:       right,
+		RightSource: rightSource,
+		Sections:    diffEntries(left, right),
+	}
+
+	renderTemplate(w, "diff.html", data)
+}
+
+// diffEntries compares the requests and responses of two entries. JSON is
+// pretty-printed with sorted keys first so that the diff follows its
+// structure.
+func diffEntries(left, right *database.LogEntry) []diffSection {
+	fields := []struct {
+		title       string
+		left, right string
+	}{
+		{"Frontend Request", left.FrontendRequest, right.FrontendRequest},
+		{"Backend Request", left.BackendRequest, right.BackendRequest},
+		{"Response", left.Response, right.Response},
+		{"Frontend Response", left.FrontendResponse, right.FrontendResponse},
+		{"Backend Response", left.BackendResponse, right.BackendResponse},
+	}
+
+	sections := make([]diffSection, 0, len(fields))
+	for _, field := range fields {
+		a, b := diffText(field.left), diffText(field.right)
+		sections = append(sections, diffSection{
+			Title:     field.title,
+			Rows:      diffRows(a, b),
+			Identical: a == b,
+		})
+	}
+	return sections
+}
+
+// diffText formats a logged body for diffing
+func diffText(s string) string {
+	if pretty := prettyJSON(s); pretty != "" {
+		return pretty
+	}
+	return s
+}
+
+// diffRows lays out the line diff of a and b side by side, pairing removed
+// lines with the added lines that replace them and folding long unchanged
+// runs
+func diffRows(a, b string) []diffRow {
+	left, right := splitLines(a), splitLines(b)
+	ops := diffLines(left, right)
+
+	var rows []diffRow
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == '=' {
+			rows = append(rows, diffRow{
+				Kind:     "same",
+				LeftNum:  ops[i].Left + 1,
+				Left:     left[ops[i].Left],
+				RightNum: ops[i].Right + 1,
+				Right:    right[ops[i].Right],
+			})
+			i++
+			continue
+		}
+
+		var removed, added []diffOp
+		for ; i < len(ops) && ops[i].Kind != '='; i++ {
+			if ops[i].Kind == '-' {
+				removed = append(removed, ops[i])
+			} else {
+				added = append(added, ops[i])
+			}
+		}
+		for j := 0; j < max(len(removed), len(added)); j++ {
+			row := diffRow{Kind: "changed"}
+			if j < len(removed) {
+				row.LeftNum = removed[j].Left + 1
+				row.Left = left[removed[j].Left]
+			} else {
+				row.Kind = "added"
+			}
+			if j < len(added) {
+				row.RightNum = added[j].Right + 1
+				row.Right = right[added[j].Right]
+			} else {
+				row.Kind = "removed"
+			}
+			rows = append(rows, row)
+		}
+	}
+	return foldUnchanged(rows)
+}
+
+// foldUnchanged replaces unchanged rows further than diffContext from a
+// change with "skip" rows
+func foldUnchanged(rows []diffRow) []diffRow {
+	keep := make([]bool, len(rows))
+	for i, row := range rows {
+		if row.Kind == "same" {
+			continue
+		}
+		for j := max(i-diffContext, 0); j <= min(i+diffContext, len(rows)-1); j++ {
+			keep[j] = true
+		}
+	}
+
+	var folded []diffRow
+	for i := 0; i < len(rows); {
+		if keep[i] {
+			folded = append(folded, rows[i])
+			i++
+			continue
+		}
+		start := i
+		for i < len(rows) && !keep[i] {
+			i++
+		}
+		folded = append(folded, diffRow{Kind: "skip", Skipped: i - start})
+	}
+	return folded
+}
+
+// splitLines splits s into lines; an empty string has none
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the steps that turn a into b, from the longest common
+// subsequence of their lines. The common prefix and suffix are matched
+// directly, which keeps the table small for the usual case of two turns of
+// the same conversation.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, max(len(a), len(b)))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{'=', i, i})
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(midA), len(midB)
+	if n*m > maxDiffCells {
+		for i := range n {
+			ops = append(ops, diffOp{'-', prefix + i, 0})
+		}
+		for j := range m {
+			ops = append(ops, diffOp{'+', 0, prefix + j})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// midA[i:] and midB[j:]
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && midA[i] == midB[j]:
+				ops = append(ops, diffOp{'=', prefix + i, prefix + j})
+				i++
+				j++
+			case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', prefix + i, 0})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', 0, prefix + j})
+				j++
+			}
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		ops = append(ops, diffOp{'=', len(a) - k, len(b) - k})
+	}
+	return ops
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffRows(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\n"
+	b := "one\ntwo\nthree\nfour\nfive\nsix\nseven\nEIGHT\nnine\nten\n"
+
+	want := []diffRow{
+		{Kind: "skip", Skipped: 4},
+		{Kind: "same", LeftNum: 5, Left: "five", RightNum: 5, Right: "five"},
+		{Kind: "same", LeftNum: 6, Left: "six", RightNum: 6, Right: "six"},
+		{Kind: "same", LeftNum: 7, Left: "seven", RightNum: 7, Right: "seven"},
+		{Kind: "changed", LeftNum: 8, Left: "eight", RightNum: 8, Right: "EIGHT"},
+		{Kind: "same", LeftNum: 9, Left: "nine", RightNum: 9, Right: "nine"},
+		{Kind: "added", RightNum: 10, Right: "ten"},
+	}
+	if got := diffRows(a, b); !reflect.DeepEqual(got, want) {
+		t.Fatalf("diffRows() = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestDiffHandler(t *testing.T) {
+	handler := NewWebHandler(newLogsAPITestDB(t), nil)
+
+	rec := httptest.NewRecorder()
+	handler.DiffHandler(rec, httptest.NewRequest(http.MethodGet, "/logs/diff?a=1&b=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<td class="left">  &#34;backend&#34;: true</td>`,
+		`<td class="right">  &#34;backend&#34;: false</td>`,
+		`<td class="differs">other-model</td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("diff page missing %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.DiffHandler(rec, httptest.NewRequest(http.MethodGet, "/logs/diff?a=1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status without b = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/config"
+	"llm_proxy/database"
+	"llm_proxy/models"
+)
+
+// EmbeddingCache serves repeated embedding inputs from the database instead
+// of the backend. Vectors are keyed by model, requested dimensions, and a
+// SHA-256 hash of the input text.
+type EmbeddingCache struct {
+	db         *database.DB
+	enabled    bool
+	maxEntries int
+
+	mu     sync.Mutex
+	hits   int64
+	misses int64
+}
+
+// EmbeddingCacheStats reports cache effectiveness since startup.
+type EmbeddingCacheStats struct {
+	Enabled    bool    `json:"enabled"`
+	Entries    int64   `json:"entries"`
+	MaxEntries int     `json:"max_entries"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+// NewEmbeddingCache creates an embedding cache. When disabled, every input is
+// sent to the backend but requests are still counted as misses.
+func NewEmbeddingCache(db *database.DB, enabled bool, maxEntries int) *EmbeddingCache {
+	return &EmbeddingCache{
+		db:         db,
+		enabled:    enabled,
+		maxEntries: maxEntries,
+	}
+}
+
+// Embed returns one vector per input, computing only the inputs that are not
+// already cached. cached is the number of inputs served from the cache.
+func (c *EmbeddingCache) Embed(ctx context.Context, b backend.Backend, req models.EmbedRequest) (resp models.EmbedResponse, metadata *backend.BackendMetadata, cached int, err error) {
+	if !c.enabled {
+		resp, metadata, err = b.Embed(ctx, req)
+		if err == nil {
+			c.record(0, len(req.Input))
+		}
+		return resp, metadata, 0, err
+	}
+
+	hashes := make([]string, len(req.Input))
+	for i, input := range req.Input {
+		hashes[i] = embeddingInputHash(input)
+	}
+
+	found, err := c.db.GetCachedEmbeddings(req.Model, req.Dimensions, hashes)
+	if err != nil {
+		log.Printf("Embedding cache lookup failed: %v", err)
+		found = map[string][]float64{}
+	}
+
+	// Send each distinct uncached input to the backend once
+	var missing []string
+	missingHashes := make(map[string]bool)
+	for i, hash := range hashes {
+		if _, ok := found[hash]; ok || missingHashes[hash] {
+			continue
+		}
+		missingHashes[hash] = true
+		missing = append(missing, req.Input[i])
+	}
+
+	resp = models.EmbedResponse{Model: req.Model}
+	metadata = &backend.BackendMetadata{}
+	if len(missing) > 0 {
+		backendReq := req
+		backendReq.Input = missing
+		var backendResp models.EmbedResponse
+		backendResp, metadata, err = b.Embed(ctx, backendReq)
+		if err != nil {
+			return models.EmbedResponse{}, metadata, 0, err
+		}
+		if len(backendResp.Embeddings) != len(missing) {
+			return models.EmbedResponse{}, metadata, 0, fmt.Errorf("backend returned %d embeddings for %d inputs", len(backendResp.Embeddings), len(missing))
+		}
+		if backendResp.Model != "" {
+			resp.Model = backendResp.Model
+		}
+		resp.TotalDuration = backendResp.TotalDuration
+		resp.LoadDuration = backendResp.LoadDuration
+		resp.PromptEvalCount = backendResp.PromptEvalCount
+
+		computed := make(map[string][]float64, len(missing))
+		for i, input := range missing {
+			computed[embeddingInputHash(input)] = backendResp.Embeddings[i]
+		}
+		if err := c.db.StoreEmbeddings(req.Model, req.Dimensions, computed); err != nil {
+			log.Printf("Embedding cache store failed: %v", err)
+		} else if c.maxEntries > 0 {
+			if _, err := c.db.TrimEmbeddingCache(c.maxEntries); err != nil {
+				log.Printf("Embedding cache trim failed: %v", err)
+			}
+		}
+		for hash, vector := range computed {
+			found[hash] = vector
+		}
+	}
+
+	resp.Embeddings = make([][]float64, len(hashes))
+	for i, hash := range hashes {
+		resp.Embeddings[i] = found[hash]
+		if !missingHashes[hash] {
+			cached++
+		}
+	}
+
+	c.record(cached, len(hashes)-cached)
+	return resp, metadata, cached, nil
+}
+
+func embeddingInputHash(input string) string {
+	sum := sha256.Sum256([]byte(input))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *EmbeddingCache) record(hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits += int64(hits)
+	c.misses += int64(misses)
+}
+
+// Stats returns hit/miss counters and the current number of cached vectors.
+func (c *EmbeddingCache) Stats() EmbeddingCacheStats {
+	c.mu.Lock()
+	stats := EmbeddingCacheStats{
+		Enabled:    c.enabled,
+		MaxEntries: c.maxEntries,
+		Hits:       c.hits,
+		Misses:     c.misses,
+	}
+	c.mu.Unlock()
+
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	if entries, err := c.db.CountCachedEmbeddings(); err != nil {
+		log.Printf("Failed to count cached embeddings: %v", err)
+	} else {
+		stats.Entries = entries
+	}
+	return stats
+}
+
+// ServeHTTP serves the cache statistics as JSON on /api/embedding_cache
+func (c *EmbeddingCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Stats()); err != nil {
+		log.Printf("Failed to encode embedding cache stats: %v", err)
+	}
+}
+
+// EmbedHandler handles /api/embed requests
+type EmbedHandler struct {
+	backend backend.Backend
+	db      *database.DB
+	config  *config.Config
+	cache   *EmbeddingCache
+}
+
+// NewEmbedHandler creates a new embed handler
+func NewEmbedHandler(backend backend.Backend, db *database.DB, config *config.Config, cache *EmbeddingCache) *EmbedHandler {
+	return &EmbedHandler{
+		backend: backend,
+		db:      db,
+		config:  config,
+		cache:   cache,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *EmbedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startTime := time.Now()
+
+	bodyBytes, status, err := readRequestBody(r)
+	if err != nil {
+		log.Printf("Embed request: %v", err)
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/api/embed", startTime, models.EmbedRequest{}, 0, status, err.Error(), "", "", nil)
+		writeOllamaError(w, status, err.Error())
+		return
+	}
+
+	var req models.EmbedRequest
+	if err := decodeRequestBody(bodyBytes, &req); err != nil {
+		errMsg := fmt.Sprintf("invalid request body: %v", err)
+		log.Printf("Embed request: invalid request body: %v", err)
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/api/embed", startTime, models.EmbedRequest{}, 0, http.StatusBadRequest, errMsg, string(bodyBytes), "", nil)
+		writeOllamaError(w, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	if len(req.Input) == 0 {
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/api/embed", startTime, req, 0, http.StatusBadRequest, "input is required", string(bodyBytes), "", nil)
+		http.Error(w, "input is required", http.StatusBadRequest)
+		return
+	}
+
+	resp, backendMeta, cached, err := h.cache.Embed(r.Context(), h.backend, req)
+	if err != nil {
+		log.Printf("Backend error: %v", err)
+		status := backendErrorStatus(err)
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/api/embed", startTime, req, 0, status, err.Error(), string(bodyBytes), "", backendMeta)
+		writeOllamaError(w, status, err.Error())
+		return
+	}
+
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respJSON)
+
+	logEmbedRequest(r.Context(), h.db, h.config.Current(), "/api/embed", startTime, req, cached, http.StatusOK, "", string(bodyBytes), string(respJSON), backendMeta)
+}
+
+// OpenAIEmbeddingsHandler handles /v1/embeddings requests
+type OpenAIEmbeddingsHandler struct {
+	backend backend.Backend
+	db      *database.DB
+	config  *config.Config
+	cache   *EmbeddingCache
+}
+
+// NewOpenAIEmbeddingsHandler creates a new OpenAI embeddings handler
+func NewOpenAIEmbeddingsHandler(backend backend.Backend, db *database.DB, config *config.Config, cache *EmbeddingCache) *OpenAIEmbeddingsHandler {
+	return &OpenAIEmbeddingsHandler{
+		backend: backend,
+		db:      db,
+		config:  config,
+		cache:   cache,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *OpenAIEmbeddingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startTime := time.Now()
+
+	bodyBytes, status, err := readRequestBody(r)
+	if err != nil {
+		log.Printf("OpenAI embeddings request: %v", err)
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/v1/embeddings", startTime, models.EmbedRequest{}, 0, status, err.Error(), "", "", nil)
+		writeOpenAIError(w, status, err.Error())
+		return
+	}
+
+	var openaiReq models.OpenAIEmbeddingRequest
+	if err := decodeRequestBody(bodyBytes, &openaiReq); err != nil {
+		errMsg := fmt.Sprintf("invalid request body: %v", err)
+		log.Printf("OpenAI embeddings request: invalid request body: %v", err)
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/v1/embeddings", startTime, models.EmbedRequest{}, 0, http.StatusBadRequest, errMsg, string(bodyBytes), "", nil)
+		writeOpenAIError(w, http.StatusBadRequest, errMsg)
+		return
+	}
+	if openaiReq.EncodingFormat != "" && openaiReq.EncodingFormat != "float" {
+		errMsg := fmt.Sprintf("unsupported encoding_format: %s (only 'float' is supported)", openaiReq.EncodingFormat)
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/v1/embeddings", startTime, models.EmbedRequest{}, 0, http.StatusBadRequest, errMsg, string(bodyBytes), "", nil)
+		http.Error(w, errMsg, http.StatusBadRequest)
+		return
+	}
+
+	req := models.EmbedRequest{
+		Model:      openaiReq.Model,
+		Input:      openaiReq.Input,
+		Dimensions: openaiReq.Dimensions,
+	}
+	if len(req.Input) == 0 {
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/v1/embeddings", startTime, req, 0, http.StatusBadRequest, "input is required", string(bodyBytes), "", nil)
+		http.Error(w, "input is required", http.StatusBadRequest)
+		return
+	}
+
+	resp, backendMeta, cached, err := h.cache.Embed(r.Context(), h.backend, req)
+	if err != nil {
+		log.Printf("Backend error: %v", err)
+		status := backendErrorStatus(err)
+		logEmbedRequest(r.Context(), h.db, h.config.Current(), "/v1/embeddings", startTime, req, 0, status, err.Error(), string(bodyBytes), "", backendMeta)
+		writeOpenAIError(w, status, err.Error())
+		return
+	}
+
+	openaiResp := models.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]models.OpenAIEmbedding, 0, len(resp.Embeddings)),
+		Model:  resp.Model,
+		Usage: &models.OpenAIUsage{
+			PromptTokens: resp.PromptEvalCount,
+			TotalTokens:  resp.PromptEvalCount,
+		},
+	}
+	for i, embedding := range resp.Embeddings {
+		openaiResp.Data = append(openaiResp.Data, models.OpenAIEmbedding{
+			Object:    "embedding",
+			Embedding: embedding,
+			Index:     i,
+		})
+	}
+
+	respJSON, err := json.Marshal(openaiResp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respJSON)
+
+	logEmbedRequest(r.Context(), h.db, h.config.Current(), "/v1/embeddings", startTime, req, cached, http.StatusOK, "", string(bodyBytes), string(respJSON), backendMeta)
+}
+
+// logEmbedRequest logs an embeddings request to the database. The response
+// column records how many vectors were returned and how many came from the
+// cache rather than the vectors themselves.
+func logEmbedRequest(ctx context.Context, db *database.DB, cfg *config.Config, endpoint string, startTime time.Time, req models.EmbedRequest, cached int, statusCode int, errMsg string, frontendReq string, frontendResp string, backendMeta *backend.BackendMetadata) {
+	if backendMeta == nil {
+		backendMeta = &backend.BackendMetadata{}
+	}
+
+	lastMessage := "unknown"
+	if len(req.Input) > 0 {
+		lastMessage = req.Input[len(req.Input)-1]
+	}
+
+	response := ""
+	if statusCode == http.StatusOK {
+		response = fmt.Sprintf("%d embedding(s), %d served from cache", len(req.Input), cached)
+	}
+
+	entry := database.LogEntry{
+		Timestamp:        startTime,
+		Endpoint:         endpoint,
+		Method:           "POST",
+		Model:            req.Model,
+		Prompt:           strings.Join(req.Input, "\n"),
+		Response:         response,
+		StatusCode:       statusCode,
+		LatencyMs:        time.Since(startTime).Milliseconds(),
+		BackendType:      cfg.Backend.Type,
+		Error:            errMsg,
+		FrontendURL:      fmt.Sprintf("http://%s:%d%s", cfg.Server.Host, cfg.Server.Port, endpoint),
+		BackendURL:       backendMeta.URL,
+		FrontendRequest:  frontendReq,
+		FrontendResponse: frontendResp,
+		BackendRequest:   backendMeta.RawRequest,
+		BackendResponse:  backendMeta.RawResponse,
+		LastMessage:      lastMessage,
+		BackendStatus:    backendMeta.StatusCode,
+		BackendRequestID: backendMeta.RequestID,
+		BackendRateLimit: backendMeta.RateLimit,
+		RaceWinner:       backendMeta.RaceWinner,
+	}
+	attributeEntry(ctx, &entry)
+
+	if err := db.Log(entry); err != nil {
+		log.Printf("Failed to log request: %v", err)
+	}
+}
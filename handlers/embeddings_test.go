@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"llm_proxy/backend"
+	"llm_proxy/config"
+	"llm_proxy/database"
+	"llm_proxy/models"
+)
+
+// embedSpyBackend returns a vector of [len(input)] for each input and records
+// which inputs actually reached the backend.
+type embedSpyBackend struct {
+	spyChatBackend
+	calls [][]string
+}
+
+func (s *embedSpyBackend) Embed(_ context.Context, req models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	s.calls = append(s.calls, append([]string(nil), req.Input...))
+	resp := models.EmbedResponse{Model: req.Model, PromptEvalCount: len(req.Input)}
+	for _, input := range req.Input {
+		resp.Embeddings = append(resp.Embeddings, []float64{float64(len(input))})
+	}
+	return resp, &backend.BackendMetadata{URL: "http://backend/api/embed"}, nil
+}
+
+func TestEmbedHandlerServesRepeatsFromCache(t *testing.T) {
+	db := newEmbedTestDB(t)
+	spy := &embedSpyBackend{}
+	cache := NewEmbeddingCache(db, true, 100)
+	handler := NewEmbedHandler(spy, db, embedTestConfig(), cache)
+
+	first := postEmbed(t, handler, "/api/embed", `{"model":"nomic","input":["a","bb"]}`)
+	second := postEmbed(t, handler, "/api/embed", `{"model":"nomic","input":["bb","ccc","ccc"]}`)
+
+	wantCalls := [][]string{{"a", "bb"}, {"ccc"}}
+	if !reflect.DeepEqual(spy.calls, wantCalls) {
+		t.Fatalf("backend calls = %v, want %v", spy.calls, wantCalls)
+	}
+
+	var firstResp, secondResp models.EmbedResponse
+	if err := json.Unmarshal(first, &firstResp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if err := json.Unmarshal(second, &secondResp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(firstResp.Embeddings, [][]float64{{1}, {2}}) {
+		t.Fatalf("first embeddings = %v", firstResp.Embeddings)
+	}
+	if !reflect.DeepEqual(secondResp.Embeddings, [][]float64{{2}, {3}, {3}}) {
+		t.Fatalf("second embeddings = %v", secondResp.Embeddings)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 4 || stats.Entries != 3 {
+		t.Fatalf("stats = %+v, want 1 hit, 4 misses, 3 entries", stats)
+	}
+
+	entries, err := db.GetRecentEntries(1, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if entries[0].Endpoint != "/api/embed" || entries[0].Response != "3 embedding(s), 1 served from cache" {
+		t.Fatalf("logged entry = %+v", entries[0])
+	}
+}
+
+func TestEmbeddingCacheKeysByModel(t *testing.T) {
+	db := newEmbedTestDB(t)
+	spy := &embedSpyBackend{}
+	handler := NewEmbedHandler(spy, db, embedTestConfig(), NewEmbeddingCache(db, true, 100))
+
+	postEmbed(t, handler, "/api/embed", `{"model":"nomic","input":"same text"}`)
+	postEmbed(t, handler, "/api/embed", `{"model":"bge","input":"same text"}`)
+
+	if len(spy.calls) != 2 {
+		t.Fatalf("backend calls = %v, want one per model", spy.calls)
+	}
+}
+
+func TestEmbeddingCacheDisabledAlwaysCallsBackend(t *testing.T) {
+	db := newEmbedTestDB(t)
+	spy := &embedSpyBackend{}
+	cache := NewEmbeddingCache(db, false, 100)
+	handler := NewEmbedHandler(spy, db, embedTestConfig(), cache)
+
+	postEmbed(t, handler, "/api/embed", `{"model":"nomic","input":"a"}`)
+	postEmbed(t, handler, "/api/embed", `{"model":"nomic","input":"a"}`)
+
+	if len(spy.calls) != 2 {
+		t.Fatalf("backend calls = %v, want 2", spy.calls)
+	}
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Entries != 0 {
+		t.Fatalf("stats = %+v, want no hits or entries", stats)
+	}
+}
+
+func TestOpenAIEmbeddingsHandlerUsesCache(t *testing.T) {
+	db := newEmbedTestDB(t)
+	spy := &embedSpyBackend{}
+	handler := NewOpenAIEmbeddingsHandler(spy, db, embedTestConfig(), NewEmbeddingCache(db, true, 100))
+
+	postEmbed(t, handler, "/v1/embeddings", `{"model":"nomic","input":"hello"}`)
+	body := postEmbed(t, handler, "/v1/embeddings", `{"model":"nomic","input":["hello"]}`)
+
+	if len(spy.calls) != 1 {
+		t.Fatalf("backend calls = %v, want 1", spy.calls)
+	}
+	var resp models.OpenAIEmbeddingResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if resp.Object != "list" || len(resp.Data) != 1 || resp.Data[0].Object != "embedding" || resp.Data[0].Embedding[0] != 5 {
+		t.Fatalf("response = %+v", resp)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 0 {
+		t.Fatalf("usage = %+v, want zero prompt tokens for a cache hit", resp.Usage)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"model":"nomic","input":"x","encoding_format":"base64"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("base64 status = %d, want 400", rec.Code)
+	}
+}
+
+func postEmbed(t *testing.T, handler http.Handler, path string, body string) []byte {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	return rec.Body.Bytes()
+}
+
+func embedTestConfig() *config.Config {
+	return &config.Config{
+		Server: config.ServerConfig{
+			Host: "127.0.0.1",
+			Port: 11434,
+		},
+		Backend: config.BackendConfig{
+			Type: "ollama",
+		},
+	}
+}
+
+func newEmbedTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// SetRetryHandler sets the handler that /logs/retry sends logged requests to
+// again, normally the proxy's API mux. Retrying is off until it is set.
+func (h *WebHandler) SetRetryHandler(handler http.Handler) {
+	h.retry = handler
+}
+
+// ErrorsHandler serves the errors page, which lists only failed requests
+// with their error, the frontend and backend status, and a retry button
+func (h *WebHandler) ErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	page := 1
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	tenant := backend.TenantFromContext(r.Context())
+	total, err := h.db.CountEntries(database.LogFilter{ErrorsOnly: true, Tenant: tenant})
+	if err != nil {
+		log.Printf("Error counting failed requests: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	failed, err := h.db.GetFailedRequests(pageSize, (page-1)*pageSize, tenant)
+	if err != nil {
+		log.Printf("Error getting failed requests: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	data := struct {
+		Entries     []database.FailedRequest
+		CanRetry    bool
+		CurrentPage int
+		TotalPages  int
+		TotalCount  int64
+		HasPrev     bool
+		HasNext     bool
+		PrevPage    int
+		NextPage    int
+	}{
+		Entries:     failed,
+		CanRetry:    h.retry != nil && tenant == "",
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		TotalCount:  total,
+		HasPrev:     page > 1,
+		HasNext:     page < totalPages,
+		PrevPage:    page - 1,
+		NextPage:    page + 1,
+	}
+
+	renderTemplate(w, "errors.html", data)
+}
+
+// RetryHandler sends a logged request to the proxy again. It takes a POST
+// with the "id" of a local entry, replays its frontend request body on the
+// same endpoint, which logs it as a new request, and then redirects to the
+// "redirect" form field (a local path, default the request log filtered to
+// the request's endpoint and model).
+func (h *WebHandler) RetryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Forms posted from other sites can't send requests
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	if h.retry == nil {
+		http.Error(w, "Retrying requests is not available", http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PostForm.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID parameter", http.StatusBadRequest)
+		return
+	}
+	entry, err := h.db.GetEntryByID(id)
+	if err != nil {
+		log.Printf("Error getting entry: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// Only API requests logged in full can be retried, not the web UI or
+	// bodies cut short by the payload limit
+	if entry.FrontendRequest == "" || entry.FrontendRequestTruncated() || !(strings.HasPrefix(entry.Endpoint, "/api/") || strings.HasPrefix(entry.Endpoint, "/v1/")) {
+		http.Error(w, "This request cannot be retried", http.StatusBadRequest)
+		return
+	}
+
+	method := entry.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	retryReq, err := http.NewRequestWithContext(r.Context(), method, entry.Endpoint, strings.NewReader(entry.FrontendRequest))
+	if err != nil {
+		http.Error(w, "Invalid logged request", http.StatusBadRequest)
+		return
+	}
+	retryReq.Header.Set("Content-Type", "application/json")
+	resp := &retryResponse{header: make(http.Header), status: http.StatusOK}
+	h.retry.ServeHTTP(resp, retryReq)
+	log.Printf("Retried logged request #%d (%s %s): status %d", id, method, entry.Endpoint, resp.status)
+	audit(r.Context(), h.db, "retry", fmt.Sprintf("request #%d (%s %s): status %d", id, method, entry.Endpoint, resp.status))
+
+	fallback := url.Values{}
+	fallback.Set("endpoint", entry.Endpoint)
+	if entry.Model != "" {
+		fallback.Set("model", entry.Model)
+	}
+	http.Redirect(w, r, localRedirect(r.PostForm.Get("redirect"), "/logs?"+fallback.Encode()), http.StatusSeeOther)
+}
+
+// retryResponse discards the response to a retried request, which is logged
+// like any other, keeping only its status code
+type retryResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (r *retryResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *retryResponse) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+}
+
+func (r *retryResponse) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return len(p), nil
+}
+
+// Flush lets streaming handlers stream the retried response
+func (r *retryResponse) Flush() {}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/database"
+)
+
+func TestErrorsHandlerListsOnlyFailedRequests(t *testing.T) {
+	db := newLogsAPITestDB(t)
+	handler := NewWebHandler(db, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ErrorsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs/errors", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "backend failed") || !strings.Contains(body, "#2") || strings.Contains(body, "#1<") {
+		t.Fatalf("errors page does not list only request #2:\n%s", body)
+	}
+	if strings.Contains(body, `action="/logs/retry"`) {
+		t.Fatal("retry button shown without a retry handler")
+	}
+}
+
+func TestRetryHandlerReplaysFrontendRequest(t *testing.T) {
+	db := newLogsAPITestDB(t)
+	handler := NewWebHandler(db, nil)
+
+	var gotPath, gotBody string
+	handler.SetRetryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.Path, string(data)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/logs/retry", strings.NewReader(url.Values{"id": {"2"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.RetryHandler(rec, req)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/logs?endpoint=%2Fapi%2Fchat&model=other-model" {
+		t.Fatalf("status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if gotPath != "/api/chat" || gotBody != `{"bad":true}` {
+		t.Fatalf("replayed %s %q", gotPath, gotBody)
+	}
+}
+
+func TestRetryHandlerRefusesTruncatedRequest(t *testing.T) {
+	db := newEmbedTestDB(t)
+	if err := db.SetPayloadLimit(20, ""); err != nil {
+		t.Fatalf("SetPayloadLimit() error = %v", err)
+	}
+	// Just over the limit, so the stored text with its truncation note is
+	// longer than the body was
+	if err := db.Log(database.LogEntry{
+		Timestamp:       time.Now(),
+		Endpoint:        "/api/chat",
+		Method:          http.MethodPost,
+		StatusCode:      http.StatusBadGateway,
+		Error:           "backend failed",
+		FrontendRequest: `{"model":"m","x":"12345"}`,
+	}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	failed, err := db.GetFailedRequests(10, 0, "")
+	if err != nil {
+		t.Fatalf("GetFailedRequests() error = %v", err)
+	}
+	if len(failed) != 1 || failed[0].Retryable {
+		t.Fatalf("failed = %+v, want one request that is not retryable", failed)
+	}
+
+	handler := NewWebHandler(db, nil)
+	handler.SetRetryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("truncated request was replayed")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/logs/retry", strings.NewReader(url.Values{"id": {"1"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.RetryHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
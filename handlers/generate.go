@@ -1,9 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -41,37 +41,44 @@ func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
 	// Read raw body bytes first for logging
-	bodyBytes, err := io.ReadAll(r.Body)
+	bodyBytes, status, err := readRequestBody(r)
 	if err != nil {
-		log.Printf("Generate request: failed to read request body: %v", err)
-		h.logInvalidRequest(startTime, "", fmt.Sprintf("failed to read request body: %v", err))
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		log.Printf("Generate request: %v", err)
+		h.logInvalidRequest(r.Context(), startTime, "", status, err.Error())
+		writeOllamaError(w, status, err.Error())
 		return
 	}
 
 	// Parse into struct
 	var req models.GenerateRequest
-	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+	if err := decodeRequestBody(bodyBytes, &req); err != nil {
+		errMsg := fmt.Sprintf("invalid request body: %v", err)
 		log.Printf("Generate request: invalid request body: %v\nBody: %s", err, string(bodyBytes))
-		h.logInvalidRequest(startTime, string(bodyBytes), fmt.Sprintf("invalid request body: %v", err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, errMsg)
+		writeOllamaError(w, http.StatusBadRequest, errMsg)
 		return
 	}
 
 	// Log raw request if enabled
-	if h.config.Server.LogRawRequests {
+	if h.config.Current().Server.LogRawRequests {
 		reqJSON, err := json.MarshalIndent(req, "", "  ")
 		if err == nil {
 			log.Printf("=== Raw Generate Request ===\n%s\n============================", string(reqJSON))
 		}
 	}
 
-	applyGenerateRequestSanitization(&req, h.config)
+	applyGenerateRequestSanitization(&req, h.config.Current())
+	if err := applyGenerateTokenBudget(&req, h.config.Current()); err != nil {
+		log.Printf("Generate request: %v", err)
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, err.Error())
+		writeOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	clientWantsStream := req.Stream
-	req.Stream = resolveStream(clientWantsStream, h.config)
+	req.Stream = resolveStream(clientWantsStream, h.config.Current())
 
 	// Log request messages if enabled
-	if h.config.Server.LogMessages {
+	if h.config.Current().Server.LogMessages {
 		log.Printf("=== Generate Request ===")
 		log.Printf("Model: %s", req.Model)
 		log.Printf("Prompt: %s", req.Prompt)
@@ -82,20 +89,35 @@ func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	frontendReqJSON := bodyBytes
 
 	// Call backend
-	respChan, backendMeta, err := h.backend.Generate(r.Context(), req)
+	// Cancelled when the client disconnects, or when writing to it fails,
+	// so that the backend stops generating
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Record exactly what is sent to the client for the log
+	capture := captureResponse(w)
+	w = capture
+
+	respChan, backendMeta, err := h.backend.Generate(ctx, req)
 	if err != nil {
 		log.Printf("Backend error: %v", err)
-		h.logRequest(startTime, req, clientWantsStream, "", http.StatusInternalServerError, err.Error(), string(frontendReqJSON), "", backendMeta.RawRequest, backendMeta.RawResponse, backendMeta.URL)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := backendErrorStatus(err)
+		writeOllamaError(w, status, err.Error())
+		h.logRequest(r.Context(), startTime, req, clientWantsStream, "", status, err.Error(), string(frontendReqJSON), capture.String(), backendMeta, "", generationTiming{})
 		return
 	}
 
-	// Set headers for streaming
-	w.Header().Set("Content-Type", "application/json")
+	// Set headers for streaming. Streamed chunks are NDJSON unless the
+	// client asked for Server-Sent Events; a single response is plain JSON.
+	format := ndjsonStream
+	if clientWantsStream {
+		format = negotiateStreamFormat(r)
+	}
+	w.Header().Set("Content-Type", format.contentType())
 	w.Header().Set("Transfer-Encoding", "chunked")
 
 	// Log when streaming starts if enabled
-	if h.config.Server.LogMessages {
+	if h.config.Current().Server.LogMessages {
 		log.Printf("=== Streaming Generate Response ===")
 	}
 
@@ -103,10 +125,19 @@ func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var fullResponse strings.Builder
 	var responses []models.GenerateResponse
 	var combined models.GenerateResponse
-	encoder := json.NewEncoder(w)
+	var timing generationTiming
 
-	for resp := range respChan {
+	var keepAlive time.Duration
+	if clientWantsStream {
+		keepAlive = keepAliveInterval(h.config.Current())
+	}
+	for {
+		resp, ok := nextChunk(ctx, respChan, keepAlive, format.keepAlive(w))
+		if !ok {
+			break
+		}
 		fullResponse.WriteString(resp.Response)
+		timing.observe(resp.Response != "" || resp.Thinking != "", resp.EvalDuration)
 
 		// Always store responses for database logging
 		responses = append(responses, resp)
@@ -117,6 +148,7 @@ func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		combined.Model = resp.Model
 		combined.CreatedAt = resp.CreatedAt
 		combined.Response += resp.Response
+		combined.Thinking += resp.Thinking
 		if len(resp.Context) > 0 {
 			combined.Context = resp.Context
 		}
@@ -134,13 +166,11 @@ func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// Only forward chunks as they arrive if the client actually asked
 		// to stream; otherwise wait and send the aggregated response once.
 		if clientWantsStream {
-			if err := encoder.Encode(resp); err != nil {
+			if err := format.writeChunk(w, resp); err != nil {
 				log.Printf("Error encoding response: %v", err)
+				cancel()
 				break
 			}
-			if f, ok := w.(http.Flusher); ok {
-				f.Flush()
-			}
 		}
 
 		if resp.Done {
@@ -148,53 +178,46 @@ func (h *GenerateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if !clientWantsStream {
-		if err := encoder.Encode(combined); err != nil {
+	// If the backend failed part way through, end with an error instead of
+	// finishing as if the response were complete. A client that went away
+	// gets nothing more.
+	statusCode, errMsg := responseStatus(ctx, backendMeta)
+	if statusCode == statusClientClosedRequest {
+		discardRest(respChan)
+	} else if errMsg != "" {
+		if !clientWantsStream {
+			w.WriteHeader(statusCode)
+		}
+		if err := format.writeChunk(w, models.ErrorResponse{Error: errMsg}); err != nil {
 			log.Printf("Error encoding response: %v", err)
 		}
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
+	} else if !clientWantsStream {
+		if err := format.writeChunk(w, combined); err != nil {
+			log.Printf("Error encoding response: %v", err)
 		}
 	}
 
 	// Log complete response messages if enabled
-	if h.config.Server.LogMessages {
+	if h.config.Current().Server.LogMessages {
 		log.Printf("=== Generate Response Complete ===")
 		log.Printf("Full Response: %s", fullResponse.String())
 		log.Printf("==================================")
 	}
 
 	// Log raw responses if enabled
-	if h.config.Server.LogRawResponses && len(responses) > 0 {
+	if h.config.Current().Server.LogRawResponses && len(responses) > 0 {
 		respJSON, err := json.MarshalIndent(responses, "", "  ")
 		if err == nil {
 			log.Printf("=== Raw Generate Responses ===\n%s\n==============================", string(respJSON))
 		}
 	}
 
-	// Capture frontend response as newline-delimited JSON (matching what was
-	// actually written to the client: per-chunk if streamed, one object otherwise)
-	var frontendRespBuilder strings.Builder
-	if clientWantsStream {
-		for i, resp := range responses {
-			respJSON, err := json.Marshal(resp)
-			if err == nil {
-				frontendRespBuilder.Write(respJSON)
-				if i < len(responses)-1 {
-					frontendRespBuilder.WriteString("\n")
-				}
-			}
-		}
-	} else if respJSON, err := json.Marshal(combined); err == nil {
-		frontendRespBuilder.Write(respJSON)
-	}
-
 	// Log the request/response
-	h.logRequest(startTime, req, clientWantsStream, fullResponse.String(), http.StatusOK, "", string(frontendReqJSON), frontendRespBuilder.String(), backendMeta.RawRequest, backendMeta.RawResponse, backendMeta.URL)
+	h.logRequest(r.Context(), startTime, req, clientWantsStream, fullResponse.String(), statusCode, errMsg, string(frontendReqJSON), capture.String(), backendMeta, combined.DoneReason, timing)
 }
 
 // logRequest logs the request and response to the database
-func (h *GenerateHandler) logRequest(startTime time.Time, req models.GenerateRequest, stream bool, response string, statusCode int, errMsg string, frontendReq string, frontendResp string, backendReq string, backendResp string, backendURL string) {
+func (h *GenerateHandler) logRequest(ctx context.Context, startTime time.Time, req models.GenerateRequest, stream bool, response string, statusCode int, errMsg string, frontendReq string, frontendResp string, backendMeta *backend.BackendMetadata, finishReason string, timing generationTiming) {
 	latency := time.Since(startTime).Milliseconds()
 
 	// For generate endpoint, the prompt is the last message
@@ -213,16 +236,28 @@ func (h *GenerateHandler) logRequest(startTime time.Time, req models.GenerateReq
 		StatusCode:       statusCode,
 		LatencyMs:        latency,
 		Stream:           stream,
-		BackendType:      h.config.Backend.Type,
+		BackendType:      h.config.Current().Backend.Type,
 		Error:            errMsg,
-		FrontendURL:      fmt.Sprintf("http://%s:%d/api/generate", h.config.Server.Host, h.config.Server.Port),
-		BackendURL:       backendURL,
+		FrontendURL:      fmt.Sprintf("http://%s:%d/api/generate", h.config.Current().Server.Host, h.config.Current().Server.Port),
+		BackendURL:       backendMeta.URL,
 		FrontendRequest:  frontendReq,
 		FrontendResponse: frontendResp,
-		BackendRequest:   backendReq,
-		BackendResponse:  backendResp,
+		BackendRequest:   backendMeta.RawRequest,
+		BackendResponse:  backendMeta.RawResponse,
 		LastMessage:      lastMessage,
+		CacheHit:         backendMeta.CacheHit,
+		Partial:          cutShort(errMsg, backendMeta),
+		BackendStatus:    backendMeta.StatusCode,
+		BackendRequestID: backendMeta.RequestID,
+		BackendRateLimit: backendMeta.RateLimit,
+		FinishReason:     finishReason,
+		ShadowKey:        backendMeta.ShadowKey,
+		RaceWinner:       backendMeta.RaceWinner,
 	}
+	entry.PromptTokens, entry.CachedTokens, entry.CompletionTokens = reportedTokens(backendMeta)
+	entry.Cost = reportedCost(backendMeta)
+	timing.apply(&entry)
+	attributeEntry(ctx, &entry)
 
 	if err := h.db.Log(entry); err != nil {
 		log.Printf("Failed to log request: %v", err)
@@ -232,18 +267,19 @@ func (h *GenerateHandler) logRequest(startTime time.Time, req models.GenerateReq
 // logInvalidRequest persists a request that was rejected before it could be parsed
 // into a GenerateRequest (unreadable body or malformed JSON), so it's still visible
 // in the request log instead of vanishing silently.
-func (h *GenerateHandler) logInvalidRequest(startTime time.Time, frontendReq string, errMsg string) {
+func (h *GenerateHandler) logInvalidRequest(ctx context.Context, startTime time.Time, frontendReq string, statusCode int, errMsg string) {
 	entry := database.LogEntry{
 		Timestamp:       startTime,
 		Endpoint:        "/api/generate",
 		Method:          "POST",
-		StatusCode:      http.StatusBadRequest,
+		StatusCode:      statusCode,
 		LatencyMs:       time.Since(startTime).Milliseconds(),
-		BackendType:     h.config.Backend.Type,
+		BackendType:     h.config.Current().Backend.Type,
 		Error:           errMsg,
-		FrontendURL:     fmt.Sprintf("http://%s:%d/api/generate", h.config.Server.Host, h.config.Server.Port),
+		FrontendURL:     fmt.Sprintf("http://%s:%d/api/generate", h.config.Current().Server.Host, h.config.Current().Server.Port),
 		FrontendRequest: frontendReq,
 	}
+	attributeEntry(ctx, &entry)
 
 	if err := h.db.Log(entry); err != nil {
 		log.Printf("Failed to log invalid request: %v", err)
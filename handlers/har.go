@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"llm_proxy/database"
+)
+
+// harLog is the root of a HAR 1.2 file
+// (http://www.softwareishard.com/blog/har-12-spec/)
+type harLog struct {
+	Log harContent `json:"log"`
+}
+
+type harContent struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harBody        `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harBody struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// harTimings splits an entry's time into waiting for the first byte of the
+// response and receiving the rest; the proxy doesn't time the phases before
+// that, which HAR marks as -1
+type harTimings struct {
+	Blocked int64 `json:"blocked"`
+	DNS     int64 `json:"dns"`
+	Connect int64 `json:"connect"`
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+// HARHandler serves a request log entry as a HAR file, for importing into
+// browser devtools and other HTTP analysis tools. The file holds two
+// entries: the client's request to the proxy and the proxy's request to the
+// backend.
+func (h *WebHandler) HARHandler(w http.ResponseWriter, r *http.Request) {
+	entry, source, ok := h.entryFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := json.MarshalIndent(buildHAR(entry), "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to encode HAR", http.StatusInternalServerError)
+		return
+	}
+	filename := fmt.Sprintf("request-%d.har", entry.ID)
+	if source != "" {
+		filename = fmt.Sprintf("request-%s-%d.har", source, entry.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(data)
+}
+
+// buildHAR packages the frontend and backend exchanges of entry. The backend
+// exchange is left out if the backend wasn't called. The proxy records one
+// set of timings per request, so both exchanges share them.
+func buildHAR(e *database.LogEntry) harLog {
+	started := e.Timestamp.UTC().Format(time.RFC3339Nano)
+	timings := harEntryTimings(e)
+
+	frontend := harEntry{
+		StartedDateTime: started,
+		Time:            e.LatencyMs,
+		Request:         harBuildRequest(e.Method, e.FrontendURL, frontendHARHeaders(e.FrontendHeaders), e.FrontendRequest, e.FrontendRequestSize),
+		Response:        harBuildResponse(e.StatusCode, nil, e.FrontendResponse, e.FrontendResponseSize),
+		Timings:         timings,
+		Comment:         "Client request to the proxy",
+	}
+	if e.Error != "" {
+		frontend.Response.Comment = "Error: " + e.Error
+	}
+	har := harLog{Log: harContent{
+		Version: "1.2",
+		Creator: harCreator{Name: "llm_proxy", Version: harCreatorVersion()},
+		Entries: []harEntry{frontend},
+	}}
+
+	if e.BackendURL == "" || (e.BackendStatus == 0 && e.BackendRequest == "") {
+		return har
+	}
+	requestHeaders := []harNameValue{}
+	if e.BackendRequest != "" {
+		requestHeaders = append(requestHeaders, harNameValue{Name: "Content-Type", Value: "application/json"})
+	}
+	har.Log.Entries = append(har.Log.Entries, harEntry{
+		StartedDateTime: started,
+		Time:            e.LatencyMs,
+		Request:         harBuildRequest("POST", e.BackendURL, requestHeaders, e.BackendRequest, e.BackendRequestSize),
+		Response:        harBuildResponse(e.BackendStatus, backendHARHeaders(e), e.BackendResponse, e.BackendResponseSize),
+		Timings:         timings,
+		Comment:         "Proxy request to the " + e.BackendType + " backend",
+	})
+	return har
+}
+
+// harEntryTimings splits the request's latency at the first generated token,
+// when there was one
+func harEntryTimings(e *database.LogEntry) harTimings {
+	wait := e.LatencyMs
+	if e.TTFTMs > 0 && e.TTFTMs <= e.LatencyMs {
+		wait = e.TTFTMs
+	}
+	return harTimings{Blocked: -1, DNS: -1, Connect: -1, Send: 0, Wait: wait, Receive: e.LatencyMs - wait}
+}
+
+func harBuildRequest(method, rawURL string, headers []harNameValue, body string, size int) harRequest {
+	if method == "" {
+		method = "POST"
+	}
+	req := harRequest{
+		Method:      method,
+		URL:         rawURL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     headers,
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    max(size, len(body)),
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		query := u.Query()
+		for _, name := range slices.Sorted(maps.Keys(query)) {
+			for _, value := range query[name] {
+				req.QueryString = append(req.QueryString, harNameValue{Name: name, Value: value})
+			}
+		}
+	}
+	if body != "" {
+		req.PostData = &harPostData{MimeType: harHeader(headers, "Content-Type", "application/json"), Text: body, Comment: truncatedComment(body, size)}
+	}
+	return req
+}
+
+func harBuildResponse(status int, headers []harNameValue, body string, size int) harResponse {
+	mimeType := harBodyMimeType(body)
+	if headers == nil {
+		headers = []harNameValue{}
+	}
+	if body != "" {
+		headers = append(headers, harNameValue{Name: "Content-Type", Value: mimeType})
+	}
+	return harResponse{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     headers,
+		Content:     harBody{Size: max(size, len(body)), MimeType: mimeType, Text: body, Comment: truncatedComment(body, size)},
+		HeadersSize: -1,
+		BodySize:    max(size, len(body)),
+	}
+}
+
+// frontendHARHeaders lists the client's logged request headers, sorted by
+// name
+func frontendHARHeaders(headersJSON string) []harNameValue {
+	headers := []harNameValue{}
+	for _, line := range strings.Split(headerLines(headersJSON), "\n") {
+		if name, value, ok := strings.Cut(line, ": "); ok {
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// backendHARHeaders lists the backend response headers the proxy logs: its
+// request ID, recorded without the header it came from, and its rate limit
+// headers
+func backendHARHeaders(e *database.LogEntry) []harNameValue {
+	headers := []harNameValue{}
+	if e.BackendRequestID != "" {
+		headers = append(headers, harNameValue{Name: "x-request-id", Value: e.BackendRequestID})
+	}
+	for _, line := range strings.Split(e.BackendRateLimit, "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			headers = append(headers, harNameValue{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+		}
+	}
+	return headers
+}
+
+// harBodyMimeType guesses a logged body's content type from its framing
+func harBodyMimeType(body string) string {
+	trimmed := strings.TrimSpace(body)
+	switch {
+	case strings.HasPrefix(trimmed, "data:"), strings.HasPrefix(trimmed, ":"), strings.HasPrefix(trimmed, "event:"):
+		return "text/event-stream"
+	case strings.HasPrefix(trimmed, "{") && strings.Contains(trimmed, "}\n{"):
+		return "application/x-ndjson"
+	case strings.HasPrefix(trimmed, "{"), strings.HasPrefix(trimmed, "["):
+		return "application/json"
+	}
+	return "text/plain"
+}
+
+// harHeader returns the value of the named header, or fallback
+func harHeader(headers []harNameValue, name, fallback string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return fallback
+}
+
+// truncatedComment notes a body that was cut short by the payload limit
+func truncatedComment(body string, size int) string {
+	if size > len(body) {
+		return fmt.Sprintf("Truncated: %d of %d bytes logged", len(body), size)
+	}
+	return ""
+}
+
+// harCreatorVersion is the proxy's module version, if the binary records one
+func harCreatorVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
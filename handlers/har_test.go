@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/database"
+)
+
+func TestBuildHAR(t *testing.T) {
+	entry := &database.LogEntry{
+		ID:                  7,
+		Timestamp:           time.Date(2026, 6, 26, 12, 0, 0, 0, time.UTC),
+		Method:              "POST",
+		StatusCode:          200,
+		LatencyMs:           900,
+		TTFTMs:              250,
+		BackendType:         "openai",
+		FrontendURL:         "http://localhost:11434/api/chat?debug=1",
+		BackendURL:          "http://vllm:8000/v1/chat/completions",
+		FrontendHeaders:     `{"User-Agent":"ollama-python/0.4","Content-Type":"application/json"}`,
+		FrontendRequest:     `{"model":"m","messages":[]}`,
+		FrontendResponse:    "{\"done\":false}\n{\"done\":true}\n",
+		BackendRequest:      `{"model":"m","stream":true}`,
+		BackendResponse:     "data: {}\n\ndata: [DONE]\n\n",
+		BackendResponseSize: 5000,
+		BackendStatus:       200,
+		BackendRequestID:    "req-1",
+		BackendRateLimit:    "x-ratelimit-remaining-requests: 99",
+	}
+
+	har := buildHAR(entry).Log
+	if har.Version != "1.2" || len(har.Entries) != 2 {
+		t.Fatalf("HAR version %q with %d entries, want 1.2 with 2", har.Version, len(har.Entries))
+	}
+
+	frontend := har.Entries[0]
+	if frontend.StartedDateTime != "2026-06-26T12:00:00Z" || frontend.Time != 900 {
+		t.Errorf("frontend started %s, time %d", frontend.StartedDateTime, frontend.Time)
+	}
+	if frontend.Timings.Wait != 250 || frontend.Timings.Receive != 650 {
+		t.Errorf("frontend timings = %+v, want wait 250, receive 650", frontend.Timings)
+	}
+	if got := frontend.Request.Headers; len(got) != 2 || got[0].Name != "Content-Type" || got[1].Value != "ollama-python/0.4" {
+		t.Errorf("frontend request headers = %v", got)
+	}
+	if got := frontend.Request.QueryString; len(got) != 1 || got[0].Name != "debug" {
+		t.Errorf("frontend query string = %v", got)
+	}
+	if frontend.Request.PostData == nil || frontend.Request.PostData.Text != entry.FrontendRequest {
+		t.Errorf("frontend post data = %+v", frontend.Request.PostData)
+	}
+	if frontend.Response.Content.MimeType != "application/x-ndjson" || frontend.Response.Content.Text != entry.FrontendResponse {
+		t.Errorf("frontend response content = %+v", frontend.Response.Content)
+	}
+
+	backend := har.Entries[1]
+	if backend.Request.URL != entry.BackendURL || backend.Request.PostData.Text != entry.BackendRequest {
+		t.Errorf("backend request = %+v", backend.Request)
+	}
+	if backend.Response.Content.MimeType != "text/event-stream" {
+		t.Errorf("backend response mime type = %q, want text/event-stream", backend.Response.Content.MimeType)
+	}
+	if backend.Response.Content.Size != 5000 || !strings.Contains(backend.Response.Content.Comment, "Truncated") {
+		t.Errorf("backend response content size %d, comment %q; want the untruncated size noted", backend.Response.Content.Size, backend.Response.Content.Comment)
+	}
+	if harHeader(backend.Response.Headers, "x-ratelimit-remaining-requests", "") != "99" || harHeader(backend.Response.Headers, "x-request-id", "") != "req-1" {
+		t.Errorf("backend response headers = %v", backend.Response.Headers)
+	}
+
+	entry.BackendURL, entry.BackendStatus, entry.BackendRequest = "", 0, ""
+	if got := len(buildHAR(entry).Log.Entries); got != 1 {
+		t.Errorf("HAR of a request that never reached the backend has %d entries, want 1", got)
+	}
+}
+
+func TestHARHandler(t *testing.T) {
+	handler := NewWebHandler(newLogsAPITestDB(t), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/logs/har?id=1", nil)
+	rec := httptest.NewRecorder()
+	handler.HARHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, `filename="request-1.har"`) {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	var har harLog
+	if err := json.Unmarshal(rec.Body.Bytes(), &har); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	if len(har.Log.Entries) == 0 || har.Log.Entries[0].Request.PostData.Text != `{"ok":true}` {
+		t.Errorf("HAR entries = %+v", har.Log.Entries)
+	}
+}
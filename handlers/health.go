@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// HealthHandler serves /health. Without failover it answers a plain "OK";
+// with failover it reports the health of every backend in the chain. The
+// status code is always 200 while the proxy is up, so container health
+// checks do not flap when a backend goes down.
+type HealthHandler struct {
+	failover *backend.FailoverBackend
+}
+
+// healthResponse is the JSON body returned when failover is configured
+type healthResponse struct {
+	Status   string                  `json:"status"` // "ok", "degraded", or "down"
+	Backends []backend.BackendHealth `json:"backends"`
+}
+
+// NewHealthHandler creates a new health handler. failover may be nil.
+func NewHealthHandler(failover *backend.FailoverBackend) *HealthHandler {
+	return &HealthHandler{failover: failover}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.failover == nil {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "OK")
+		return
+	}
+
+	resp := healthResponse{Backends: h.failover.Health()}
+	healthy := 0
+	for _, b := range resp.Backends {
+		if b.Healthy {
+			healthy++
+		}
+	}
+	switch {
+	case healthy == len(resp.Backends):
+		resp.Status = "ok"
+	case healthy > 0:
+		resp.Status = "degraded"
+	default:
+		resp.Status = "down"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to encode health status: %v", err)
+	}
+}
+
+// LivenessHandler serves /healthz: 200 with the proxy's uptime for as long
+// as it can answer at all. It checks nothing else, so that an orchestrator
+// doesn't restart the proxy because a backend is down.
+type LivenessHandler struct {
+	started time.Time
+}
+
+// NewLivenessHandler creates a liveness handler for a proxy started at
+// started.
+func NewLivenessHandler(started time.Time) *LivenessHandler {
+	return &LivenessHandler{started: started}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *LivenessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeProbeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "ok",
+		"uptime_seconds": int64(time.Since(h.started).Seconds()),
+	})
+}
+
+// backendProbeInterval is how long a backend probe result is reused, so that
+// frequent readiness probes don't each send a request to the backend
+const backendProbeInterval = 5 * time.Second
+
+// backendProbeTimeout limits each backend probe
+const backendProbeTimeout = 5 * time.Second
+
+// ReadinessHandler serves /readyz. It answers 200 when the proxy can serve
+// requests: the backend (any backend of a failover chain) answers a model
+// list request, the database can be written and the log write queue isn't
+// full. Otherwise it answers 503. Either way the body reports each check.
+type ReadinessHandler struct {
+	backend backend.Backend
+	db      *database.DB
+	started time.Time
+
+	mu       sync.Mutex
+	probedAt time.Time      // When probe was taken
+	probe    readinessCheck // Last backend probe
+}
+
+// readinessResponse is the JSON body of /readyz
+type readinessResponse struct {
+	Status        string         `json:"status"` // "ready" or "not ready"
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Backend       readinessCheck `json:"backend"`
+	Database      readinessCheck `json:"database"`
+	Queue         readinessQueue `json:"queue"`
+}
+
+// readinessCheck is the result of one readiness check
+type readinessCheck struct {
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// readinessQueue reports the log write queue (see database.DB.StartWriter);
+// both counts are 0 when entries are written synchronously
+type readinessQueue struct {
+	OK       bool `json:"ok"`
+	Depth    int  `json:"depth"`
+	Capacity int  `json:"capacity"`
+}
+
+// NewReadinessHandler creates a readiness handler that probes b and db for a
+// proxy started at started.
+func NewReadinessHandler(b backend.Backend, db *database.DB, started time.Time) *ReadinessHandler {
+	return &ReadinessHandler{backend: b, db: db, started: started}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *ReadinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := readinessResponse{
+		UptimeSeconds: int64(time.Since(h.started).Seconds()),
+		Backend:       h.backendCheck(r.Context()),
+		Database:      timeCheck(func() error { return h.db.CheckWritable(r.Context()) }),
+	}
+	resp.Queue.Depth, resp.Queue.Capacity = h.db.QueueDepth()
+	resp.Queue.OK = resp.Queue.Capacity == 0 || resp.Queue.Depth < resp.Queue.Capacity
+
+	status := http.StatusOK
+	resp.Status = "ready"
+	if !resp.Backend.OK || !resp.Database.OK || !resp.Queue.OK {
+		status = http.StatusServiceUnavailable
+		resp.Status = "not ready"
+	}
+	writeProbeJSON(w, status, resp)
+}
+
+// backendCheck returns the last backend probe, probing again if it is older
+// than backendProbeInterval
+func (h *ReadinessHandler) backendCheck(ctx context.Context) readinessCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.probedAt.IsZero() && time.Since(h.probedAt) < backendProbeInterval {
+		return h.probe
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, backendProbeTimeout)
+	defer cancel()
+	// Listing models is cheap and doesn't load one
+	h.probe = timeCheck(func() error {
+		_, err := h.backend.ListModels(ctx)
+		return err
+	})
+	h.probedAt = time.Now()
+	return h.probe
+}
+
+// timeCheck runs check and records how long it took
+func timeCheck(check func() error) readinessCheck {
+	start := time.Now()
+	err := check()
+	result := readinessCheck{OK: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// writeProbeJSON writes a health probe response, which must never be cached
+func writeProbeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.Printf("Failed to encode health status: %v", err)
+	}
+}
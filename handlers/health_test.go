@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/models"
+)
+
+func TestHealthHandlerReportsFailoverBackends(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHealthHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "OK" {
+		t.Fatalf("without failover: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	failover := backend.NewFailoverBackend([]backend.FailoverTarget{
+		{Name: "primary", Backend: &spyChatBackend{}},
+		{Name: "fallback", Backend: &spyChatBackend{}},
+	})
+	rec = httptest.NewRecorder()
+	NewHealthHandler(failover).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	var resp healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if resp.Status != "ok" || len(resp.Backends) != 2 || resp.Backends[1].Name != "fallback" || !resp.Backends[1].Healthy {
+		t.Fatalf("response = %+v", resp)
+	}
+}
+
+// unreachableBackend fails every model list request
+type unreachableBackend struct {
+	*spyChatBackend
+}
+
+func (unreachableBackend) ListModels(context.Context) (models.ModelsResponse, error) {
+	return models.ModelsResponse{}, errors.New("connection refused")
+}
+
+func TestReadinessHandlerChecksBackendAndDatabase(t *testing.T) {
+	db := newLogsAPITestDB(t)
+	started := time.Now().Add(-time.Minute)
+
+	rec := httptest.NewRecorder()
+	NewReadinessHandler(&spyChatBackend{}, db, started).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var resp readinessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if rec.Code != http.StatusOK || resp.Status != "ready" || !resp.Backend.OK || !resp.Database.OK || !resp.Queue.OK || resp.UptimeSeconds < 60 {
+		t.Fatalf("status = %d, response = %+v", rec.Code, resp)
+	}
+
+	rec = httptest.NewRecorder()
+	NewReadinessHandler(unreachableBackend{}, db, started).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	resp = readinessResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || resp.Status != "not ready" || resp.Backend.OK || resp.Backend.Error != "connection refused" || !resp.Database.OK {
+		t.Fatalf("status = %d, response = %+v", rec.Code, resp)
+	}
+
+	rec = httptest.NewRecorder()
+	NewLivenessHandler(started).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("liveness status = %d, want 200", rec.Code)
+	}
+}
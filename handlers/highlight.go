@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"html"
+	"strings"
+)
+
+// codeLanguage describes enough of a language's lexical syntax to colour
+// comments, strings, numbers and keywords
+type codeLanguage struct {
+	lineComments    []string
+	blockComment    [2]string // Opening and closing delimiters; empty = none
+	quotes          string    // Characters that start a string
+	multilineQuotes string    // Quotes whose strings may span lines
+	tripleQuotes    bool      // """ and ''' strings (Python)
+	keywords        map[string]bool
+	caseInsensitive bool // Keywords match in any case (SQL)
+}
+
+func keywordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+var (
+	cLikeComments = []string{"//"}
+	cBlockComment = [2]string{"/*", "*/"}
+	hashComments  = []string{"#"}
+
+	goLanguage = &codeLanguage{
+		lineComments: cLikeComments, blockComment: cBlockComment, quotes: "\"'`", multilineQuotes: "`",
+		keywords: keywordSet(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var
+			true false nil iota any error string bool byte rune int int8 int16 int32 int64
+			uint uint8 uint16 uint32 uint64 uintptr float32 float64 complex64 complex128
+			append cap close copy delete len make new panic print println recover min max clear`),
+	}
+	pythonLanguage = &codeLanguage{
+		lineComments: hashComments, quotes: `"'`, tripleQuotes: true,
+		keywords: keywordSet(`False None True and as assert async await break class continue def del elif
+			else except finally for from global if import in is lambda nonlocal not or pass raise
+			return try while with yield match case self print len range int str float list dict set tuple bool`),
+	}
+	javaScriptLanguage = &codeLanguage{
+		lineComments: cLikeComments, blockComment: cBlockComment, quotes: "\"'`", multilineQuotes: "`",
+		keywords: keywordSet(`async await break case catch class const continue debugger default delete do
+			else export extends finally for from function if import in instanceof let new of return
+			static super switch this throw try typeof var void while with yield true false null undefined
+			interface type enum implements private protected public readonly abstract declare namespace
+			keyof as any unknown never number string boolean object`),
+	}
+	rustLanguage = &codeLanguage{
+		lineComments: cLikeComments, blockComment: cBlockComment, quotes: `"`,
+		keywords: keywordSet(`as async await break const continue crate dyn else enum extern false fn for if
+			impl in let loop match mod move mut pub ref return self Self static struct super trait true
+			type unsafe use where while Some None Ok Err Option Result Box Vec String i8 i16 i32 i64 i128
+			isize u8 u16 u32 u64 u128 usize f32 f64 bool char str`),
+	}
+	cLikeLanguage = &codeLanguage{
+		lineComments: cLikeComments, blockComment: cBlockComment, quotes: `"'`,
+		keywords: keywordSet(`abstract auto bool boolean break byte case catch char class const continue
+			default delete do double else enum extends extern false final finally float for friend
+			goto if implements import include define inline int interface long namespace new null
+			nullptr package private protected public register return short signed sizeof static
+			struct super switch synchronized template this throw throws true try typedef typename
+			union unsigned using var virtual void volatile while fun val when object override
+			let guard func import internal var string`),
+	}
+	shellLanguage = &codeLanguage{
+		lineComments: hashComments, quotes: `"'`, multilineQuotes: `"'`,
+		keywords: keywordSet(`if then else elif fi case esac for while until do done in function return
+			exit export local readonly set unset source echo cd sudo`),
+	}
+	sqlLanguage = &codeLanguage{
+		lineComments: []string{"--"}, blockComment: cBlockComment, quotes: `'"`, caseInsensitive: true,
+		keywords: keywordSet(`select from where and or not insert into values update set delete create
+			table index view drop alter add column primary key foreign references join left right
+			inner outer full on group by order asc desc having limit offset as distinct union all
+			null is in like between case when then else end exists default integer text real blob
+			varchar int count sum avg min max begin commit rollback transaction pragma with`),
+	}
+	dataLanguage = &codeLanguage{
+		lineComments: hashComments, quotes: `"'`,
+		keywords: keywordSet(`true false null yes no`),
+	}
+	jsonLanguage = &codeLanguage{
+		quotes:   `"`,
+		keywords: keywordSet(`true false null`),
+	}
+)
+
+// codeLanguages maps the names used on code fences to their syntax
+var codeLanguages = map[string]*codeLanguage{
+	"go": goLanguage, "golang": goLanguage,
+	"python": pythonLanguage, "py": pythonLanguage, "python3": pythonLanguage,
+	"javascript": javaScriptLanguage, "js": javaScriptLanguage, "jsx": javaScriptLanguage,
+	"typescript": javaScriptLanguage, "ts": javaScriptLanguage, "tsx": javaScriptLanguage,
+	"rust": rustLanguage, "rs": rustLanguage,
+	"c": cLikeLanguage, "h": cLikeLanguage, "cpp": cLikeLanguage, "c++": cLikeLanguage, "hpp": cLikeLanguage,
+	"cs": cLikeLanguage, "csharp": cLikeLanguage, "java": cLikeLanguage, "kotlin": cLikeLanguage,
+	"kt": cLikeLanguage, "swift": cLikeLanguage, "scala": cLikeLanguage, "dart": cLikeLanguage,
+	"sh": shellLanguage, "bash": shellLanguage, "shell": shellLanguage, "zsh": shellLanguage, "console": shellLanguage,
+	"sql": sqlLanguage, "sqlite": sqlLanguage, "postgresql": sqlLanguage, "mysql": sqlLanguage,
+	"yaml": dataLanguage, "yml": dataLanguage, "toml": dataLanguage, "ini": dataLanguage,
+	"json": jsonLanguage, "jsonc": jsonLanguage,
+}
+
+// highlightCode returns code as escaped HTML, with comments, strings, numbers
+// and keywords wrapped in "tok-" classed spans if lang is known
+func highlightCode(code, lang string) string {
+	language := codeLanguages[lang]
+	if language == nil {
+		return html.EscapeString(code)
+	}
+
+	var b strings.Builder
+	token := func(class, text string) {
+		b.WriteString(`<span class="tok-` + class + `">` + html.EscapeString(text) + `</span>`)
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+
+		if end := language.commentEnd(rest); end > 0 {
+			token("comment", rest[:end])
+			i += end
+			continue
+		}
+
+		c := rest[0]
+		if strings.IndexByte(language.quotes, c) >= 0 {
+			end := language.stringEnd(rest)
+			token("string", rest[:end])
+			i += end
+			continue
+		}
+
+		if c >= '0' && c <= '9' && (i == 0 || !isWordByte(code[i-1])) {
+			end := 1
+			for end < len(rest) && (isWordByte(rest[end]) || rest[end] == '.' && end+1 < len(rest) && rest[end+1] >= '0' && rest[end+1] <= '9') {
+				end++
+			}
+			token("number", rest[:end])
+			i += end
+			continue
+		}
+
+		if isWordByte(c) {
+			end := 1
+			for end < len(rest) && isWordByte(rest[end]) {
+				end++
+			}
+			word := rest[:end]
+			if language.isKeyword(word) && (i == 0 || code[i-1] != '.') {
+				token("keyword", word)
+			} else {
+				b.WriteString(html.EscapeString(word))
+			}
+			i += end
+			continue
+		}
+
+		b.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+	return b.String()
+}
+
+// commentEnd returns the length of the comment at the start of s, or 0
+func (l *codeLanguage) commentEnd(s string) int {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(s, prefix) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	if open := l.blockComment[0]; open != "" && strings.HasPrefix(s, open) {
+		if end := strings.Index(s[len(open):], l.blockComment[1]); end >= 0 {
+			return len(open) + end + len(l.blockComment[1])
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// stringEnd returns the length of the string literal at the start of s. An
+// unterminated string ends at the end of the line.
+func (l *codeLanguage) stringEnd(s string) int {
+	quote := s[0]
+	if l.tripleQuotes && len(s) >= 3 && s[1] == quote && s[2] == quote {
+		if end := strings.Index(s[3:], s[:3]); end >= 0 {
+			return 3 + end + 3
+		}
+		return len(s)
+	}
+	multiline := strings.IndexByte(l.multilineQuotes, quote) >= 0
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote != '`':
+			i++
+		case s[i] == quote:
+			return i + 1
+		case s[i] == '\n' && !multiline:
+			return i
+		}
+	}
+	return len(s)
+}
+
+func (l *codeLanguage) isKeyword(word string) bool {
+	if l.caseInsensitive {
+		word = strings.ToLower(word)
+	}
+	return l.keywords[word]
+}
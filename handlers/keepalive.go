@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"llm_proxy/config"
+)
+
+// keepAliveInterval returns how long a stream may sit idle before a
+// keep-alive is sent (0 = never)
+func keepAliveInterval(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.Server.KeepAliveInterval) * time.Second
+}
+
+// nextChunk receives the next chunk from ch. It returns false once ch is
+// closed or ctx is done, e.g. because the client disconnected. While it
+// waits, ping is called each time interval passes, so that clients and
+// intermediaries don't drop a stream during a long backend stall (loading a
+// model, processing a long prompt). A zero interval waits without pinging.
+func nextChunk[T any](ctx context.Context, ch <-chan T, interval time.Duration, ping func()) (T, bool) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case chunk, ok := <-ch:
+			return chunk, ok
+		case <-ctx.Done():
+			var zero T
+			return zero, false
+		case <-tick:
+			ping()
+		}
+	}
+}
+
+// ndjsonKeepAlive pings an NDJSON stream with a single space. JSON allows
+// leading whitespace, so line-based clients parse the next chunk as usual.
+func ndjsonKeepAlive(w http.ResponseWriter) func() {
+	return func() {
+		writeKeepAlive(w, " ")
+	}
+}
+
+// sseKeepAlive pings a Server-Sent Events stream with a comment line, which
+// SSE clients ignore
+func sseKeepAlive(w http.ResponseWriter) func() {
+	return func() {
+		writeKeepAlive(w, ": keep-alive\n\n")
+	}
+}
+
+func writeKeepAlive(w http.ResponseWriter, ping string) {
+	io.WriteString(w, ping)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
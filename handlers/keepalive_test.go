@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextChunkPingsWhileIdle(t *testing.T) {
+	ch := make(chan string)
+	go func() {
+		time.Sleep(60 * time.Millisecond)
+		ch <- "chunk"
+		close(ch)
+	}()
+
+	rec := httptest.NewRecorder()
+	chunk, ok := nextChunk(context.Background(), ch, 10*time.Millisecond, sseKeepAlive(rec))
+	if !ok || chunk != "chunk" {
+		t.Fatalf("nextChunk = %q, %v; want chunk, true", chunk, ok)
+	}
+	if body := rec.Body.String(); !strings.HasPrefix(body, ": keep-alive\n\n") {
+		t.Errorf("expected keep-alive comments while idle, got %q", body)
+	}
+
+	if _, ok := nextChunk(context.Background(), ch, 0, func() { t.Error("ping with zero interval") }); ok {
+		t.Error("expected closed channel")
+	}
+}
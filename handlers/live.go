@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"llm_proxy/backend"
+)
+
+// liveEvent is the summary of a log entry sent to the live tail page
+type liveEvent struct {
+	ID          int64     `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Endpoint    string    `json:"endpoint"`
+	Model       string    `json:"model"`
+	BackendType string    `json:"backend_type"`
+	StatusCode  int       `json:"status_code"`
+	LatencyMs   int64     `json:"latency_ms"`
+	Stream      bool      `json:"stream"`
+	CacheHit    bool      `json:"cache_hit"`
+	Partial     bool      `json:"partial"`
+	Error       string    `json:"error,omitempty"`
+	Preview     string    `json:"preview"`
+}
+
+// liveBuffer is the number of entries a live tail client can fall behind by
+// before entries are dropped
+const liveBuffer = 64
+
+// LiveHandler serves the live tail page, which shows requests as they are
+// logged
+func (h *WebHandler) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, "live.html", nil)
+}
+
+// LiveEventsHandler streams each request logged from now on as a
+// Server-Sent Event whose data is a JSON liveEvent. A tenant only gets its
+// own requests.
+func (h *WebHandler) LiveEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	tenant := backend.TenantFromContext(r.Context())
+	entries, unsubscribe := h.db.Subscribe(liveBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// A comment gets the headers to the browser straight away
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-entries:
+			if !ok {
+				return
+			}
+			if tenant != "" && entry.Tenant != tenant {
+				continue
+			}
+			listEntry := makeLogListEntry(entry)
+			data, err := json.Marshal(liveEvent{
+				ID:          entry.ID,
+				Timestamp:   entry.Timestamp,
+				Endpoint:    entry.Endpoint,
+				Model:       entry.Model,
+				BackendType: entry.BackendType,
+				StatusCode:  entry.StatusCode,
+				LatencyMs:   entry.LatencyMs,
+				Stream:      entry.Stream,
+				CacheHit:    entry.CacheHit,
+				Partial:     entry.Partial,
+				Error:       entry.Error,
+				Preview:     truncateString(listEntry.Preview, 80),
+			})
+			if err != nil {
+				log.Printf("Error encoding live event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", entry.ID, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
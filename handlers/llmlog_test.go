@@ -80,3 +80,19 @@ func TestFormatLLMLog_SampleRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestThinkingFromResponse(t *testing.T) {
+	tests := map[string]string{
+		"ollama stream":    `{"message":{"role":"assistant","content":"","thinking":"let me "}}` + "\n" + `{"message":{"role":"assistant","content":"42","thinking":"think"},"done":true}` + "\n",
+		"ollama generate":  `{"response":"42","thinking":"let me think","done":true}`,
+		"openai stream":    "data: {\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":\"let me think\"}}]}\n\ndata: [DONE]\n\n",
+		"ollama keepalive": " " + `{"message":{"role":"assistant","content":"","thinking":"let me think"},"done":true}` + "\n",
+		"sse keepalive":    ": keep-alive\n\ndata: {\"message\":{\"role\":\"assistant\",\"content\":\"\",\"thinking\":\"let me think\"}}\n\n",
+		"openai json":      `{"choices":[{"index":0,"message":{"role":"assistant","content":"42","reasoning_content":"let me think"}}]}`,
+	}
+	for name, raw := range tests {
+		if got := thinkingFromResponse(raw); got != "let me think" {
+			t.Errorf("%s: thinkingFromResponse() = %q", name, got)
+		}
+	}
+}
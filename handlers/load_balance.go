@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"llm_proxy/backend"
+)
+
+// LoadBalanceHandler serves /api/load_balance: the requests in flight and
+// the models assigned on each load-balanced replica.
+type LoadBalanceHandler struct {
+	balancer *backend.LoadBalanceBackend
+}
+
+// NewLoadBalanceHandler creates a new load balance handler
+func NewLoadBalanceHandler(balancer *backend.LoadBalanceBackend) *LoadBalanceHandler {
+	return &LoadBalanceHandler{balancer: balancer}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *LoadBalanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.balancer.Stats()); err != nil {
+		log.Printf("Failed to encode load balance stats: %v", err)
+	}
+}
@@ -14,10 +14,12 @@ import (
 	"strings"
 
 	"llm_proxy/database"
+	"llm_proxy/models"
 )
 
 type logListEntry struct {
 	database.LogEntry
+	Source       string // Federated source name; empty for the local database
 	Preview      string
 	PreviewParts []renderedLogPart
 }
@@ -60,6 +62,51 @@ func promptDisplayForEntry(entry *database.LogEntry) string {
 	return entry.Prompt
 }
 
+// thinkingFromResponse collects the reasoning text from a logged frontend
+// response: Ollama JSON or NDJSON (message.thinking / thinking) or OpenAI
+// JSON or SSE (reasoning_content). SSE may start with a keep-alive comment.
+func thinkingFromResponse(raw string) string {
+	var values []string
+	if trimmed := strings.TrimSpace(raw); strings.HasPrefix(trimmed, "data:") || strings.HasPrefix(trimmed, ":") {
+		for _, line := range strings.Split(raw, "\n") {
+			data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+			if data = strings.TrimSpace(data); ok && data != "[DONE]" {
+				values = append(values, data)
+			}
+		}
+	} else {
+		values = []string{raw}
+	}
+
+	var sb strings.Builder
+	for _, value := range values {
+		dec := json.NewDecoder(strings.NewReader(value))
+		for {
+			var chunk struct {
+				Message  *models.Message           `json:"message"`
+				Thinking string                    `json:"thinking"`
+				Choices  []models.OpenAIChatChoice `json:"choices"`
+			}
+			if err := dec.Decode(&chunk); err != nil {
+				break
+			}
+			if chunk.Message != nil {
+				sb.WriteString(chunk.Message.Thinking)
+			}
+			sb.WriteString(chunk.Thinking)
+			for _, choice := range chunk.Choices {
+				if choice.Delta != nil {
+					sb.WriteString(choice.Delta.Thinking)
+				}
+				if choice.Message != nil {
+					sb.WriteString(choice.Message.Thinking)
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
 func renderedMessagesFromRaw(rawJSON string) []renderedLogMessage {
 	msgs := parseMessages(rawJSON)
 	out := make([]renderedLogMessage, 0, len(msgs))
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"llm_proxy/database"
+)
+
+// LogSource is a read-only source of request log entries from another proxy
+// instance, merged into the web UI's log list.
+type LogSource interface {
+	Name() string
+	CountEntries() (int64, error)
+	// RecentEntries returns up to limit entries, newest first.
+	RecentEntries(limit int) ([]database.LogEntry, error)
+	// EntryByID returns nil, nil if the entry does not exist.
+	EntryByID(id int64) (*database.LogEntry, error)
+}
+
+// dbLogSource reads entries from another proxy's SQLite file.
+type dbLogSource struct {
+	name string
+	db   *database.DB
+}
+
+// NewDBLogSource creates a log source backed by a (read-only) database.
+func NewDBLogSource(name string, db *database.DB) LogSource {
+	return &dbLogSource{name: name, db: db}
+}
+
+func (s *dbLogSource) Name() string { return s.name }
+
+func (s *dbLogSource) CountEntries() (int64, error) {
+	return s.db.GetTotalCount()
+}
+
+func (s *dbLogSource) RecentEntries(limit int) ([]database.LogEntry, error) {
+	return s.db.GetRecentEntries(limit, 0)
+}
+
+func (s *dbLogSource) EntryByID(id int64) (*database.LogEntry, error) {
+	return s.db.GetEntryByID(id)
+}
+
+// remoteLogSource reads entries from another proxy's JSON logs API.
+type remoteLogSource struct {
+	name    string
+	baseURL string
+	client  *http.Client
+}
+
+// remoteLogsPageLimit is the maximum page size accepted by /api/logs, so
+// more entries are fetched a page at a time.
+const remoteLogsPageLimit = 1000
+
+// NewRemoteLogSource creates a log source that queries another proxy's
+// /api/logs endpoint at baseURL.
+func NewRemoteLogSource(name string, baseURL string, timeout int) LogSource {
+	return &remoteLogSource{
+		name:    name,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client: &http.Client{
+			Timeout: time.Duration(timeout) * time.Second,
+		},
+	}
+}
+
+func (s *remoteLogSource) Name() string { return s.name }
+
+func (s *remoteLogSource) CountEntries() (int64, error) {
+	var list logsAPIListResponse
+	if err := s.getJSON("/api/logs?limit=1", &list); err != nil {
+		return 0, err
+	}
+	return list.Total, nil
+}
+
+func (s *remoteLogSource) RecentEntries(limit int) ([]database.LogEntry, error) {
+	entries := make([]database.LogEntry, 0, min(limit, remoteLogsPageLimit))
+	// Entries logged while paging push older ones onto the next page, so
+	// skip any already seen
+	seen := make(map[int64]bool)
+	for offset := 0; len(entries) < limit; {
+		pageSize := min(limit-len(entries), remoteLogsPageLimit)
+		var list logsAPIListResponse
+		if err := s.getJSON(fmt.Sprintf("/api/logs?limit=%d&offset=%d&order=desc", pageSize, offset), &list); err != nil {
+			return nil, err
+		}
+		for _, e := range list.Entries {
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				entries = append(entries, apiEntryToLogEntry(e))
+			}
+		}
+		if len(list.Entries) < pageSize {
+			break
+		}
+		offset += pageSize
+	}
+	return entries, nil
+}
+
+func (s *remoteLogSource) EntryByID(id int64) (*database.LogEntry, error) {
+	var apiEntry logsAPILogEntry
+	err := s.getJSON(fmt.Sprintf("/api/logs/%d", id), &apiEntry)
+	if err == errRemoteNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := apiEntryToLogEntry(apiEntry)
+	return &entry, nil
+}
+
+var errRemoteNotFound = fmt.Errorf("not found")
+
+func (s *remoteLogSource) getJSON(path string, out interface{}) error {
+	resp, err := s.client.Get(s.baseURL + path)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errRemoteNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// frontendHeadersJSON re-encodes the entry's frontend headers as stored in
+// the database
+func (e logsAPILogEntry) frontendHeadersJSON() string {
+	if len(e.FrontendHeaders) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(e.FrontendHeaders)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func apiEntryToLogEntry(e logsAPILogEntry) database.LogEntry {
+	return database.LogEntry{
+		ID:               e.ID,
+		Timestamp:        e.Timestamp,
+		Endpoint:         e.Endpoint,
+		Method:           e.Method,
+		Model:            e.Model,
+		Prompt:           e.Prompt,
+		Response:         e.Response,
+		StatusCode:       e.StatusCode,
+		LatencyMs:        e.LatencyMs,
+		Stream:           e.Stream,
+		BackendType:      e.BackendType,
+		Error:            e.Error,
+		FrontendURL:      e.FrontendURL,
+		BackendURL:       e.BackendURL,
+		FrontendRequest:  e.FrontendRequest,
+		FrontendResponse: e.FrontendResponse,
+		BackendRequest:   e.BackendRequest,
+		BackendResponse:  e.BackendResponse,
+		LastMessage:      e.LastMessage,
+		CacheHit:         e.CacheHit,
+		PromptTokens:     e.PromptTokens,
+		CachedTokens:     e.CachedTokens,
+		CompletionTokens: e.CompletionTokens,
+		Cost:             e.Cost,
+		Partial:          e.Partial,
+		TrimmedMessages:  e.TrimmedMessages,
+		TTFTMs:           e.TTFTMs,
+		GenerationMs:     e.GenerationMs,
+		TokensPerSecond:  e.TokensPerSecond,
+		BackendStatus:    e.BackendStatus,
+		BackendRequestID: e.BackendRequestID,
+		BackendRateLimit: e.BackendRateLimit,
+		FinishReason:     e.FinishReason,
+		ShadowKey:        e.ShadowKey,
+		Shadow:           e.Shadow,
+		Tenant:           e.Tenant,
+		ClientIP:         e.ClientIP,
+		UserAgent:        e.UserAgent,
+		User:             e.User,
+		RaceWinner:       e.RaceWinner,
+		FrontendHeaders:  e.frontendHeadersJSON(),
+
+		FrontendRequestSize:  e.FrontendRequestSize,
+		FrontendResponseSize: e.FrontendResponseSize,
+		BackendRequestSize:   e.BackendRequestSize,
+		BackendResponseSize:  e.BackendResponseSize,
+	}
+}
+
+// findLogSource returns the federated source with the given name, or nil.
+func (h *WebHandler) findLogSource(name string) LogSource {
+	for _, src := range h.sources {
+		if src.Name() == name {
+			return src
+		}
+	}
+	return nil
+}
+
+// mergedLogPage returns one page of entries merged from the local database
+// and all federated sources, newest first. Sources that fail are skipped and
+// reported by name so the page can still render.
+func (h *WebHandler) mergedLogPage(limit, offset int) ([]logListEntry, int64, []string, error) {
+	total, err := h.db.GetTotalCount()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	local, err := h.db.GetRecentEntries(offset+limit, 0)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	merged := make([]logListEntry, 0, len(local))
+	for _, entry := range local {
+		merged = append(merged, makeLogListEntry(entry))
+	}
+
+	var unavailable []string
+	for _, src := range h.sources {
+		count, err := src.CountEntries()
+		if err != nil {
+			log.Printf("Log source %s unavailable: %v", src.Name(), err)
+			unavailable = append(unavailable, src.Name())
+			continue
+		}
+		entries, err := src.RecentEntries(offset + limit)
+		if err != nil {
+			log.Printf("Log source %s unavailable: %v", src.Name(), err)
+			unavailable = append(unavailable, src.Name())
+			continue
+		}
+		total += count
+		for _, entry := range entries {
+			item := makeLogListEntry(entry)
+			item.Source = src.Name()
+			merged = append(merged, item)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Timestamp.After(merged[j].Timestamp)
+	})
+
+	if offset >= len(merged) {
+		return nil, total, unavailable, nil
+	}
+	end := offset + limit
+	if end > len(merged) {
+		end = len(merged)
+	}
+	return merged[offset:end], total, unavailable, nil
+}
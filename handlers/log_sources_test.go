@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/database"
+)
+
+func TestMergedLogPageCombinesSources(t *testing.T) {
+	local := newLogsAPITestDB(t)
+
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	other, err := database.New(otherPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	newest := time.Date(2026, 6, 26, 12, 0, 30, 0, time.UTC)
+	if err := other.Log(database.LogEntry{
+		Timestamp:   newest,
+		Endpoint:    "/api/generate",
+		Method:      "POST",
+		Model:       "remote-model",
+		StatusCode:  200,
+		LastMessage: "from the other box",
+	}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	_ = other.Close()
+
+	readOnly, err := database.OpenReadOnly(otherPath)
+	if err != nil {
+		t.Fatalf("OpenReadOnly() error = %v", err)
+	}
+	t.Cleanup(func() { _ = readOnly.Close() })
+
+	server := httptest.NewServer(NewLogsAPIHandler(readOnly))
+	t.Cleanup(server.Close)
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(down.Close)
+
+	handler := NewWebHandler(local, nil,
+		NewDBLogSource("file", readOnly),
+		NewRemoteLogSource("remote", server.URL, 5),
+		NewRemoteLogSource("down", down.URL, 5),
+	)
+
+	entries, total, unavailable, err := handler.mergedLogPage(10, 0)
+	if err != nil {
+		t.Fatalf("mergedLogPage() error = %v", err)
+	}
+	if total != 4 {
+		t.Fatalf("total = %d, want 4", total)
+	}
+	if len(unavailable) != 1 || unavailable[0] != "down" {
+		t.Fatalf("unavailable = %v, want [down]", unavailable)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("entries = %d, want 4", len(entries))
+	}
+	// Local entries are at 12:00 and 12:01; both copies of the federated
+	// entry sit between them, in source order.
+	wantSources := []string{"", "file", "remote", ""}
+	for i, want := range wantSources {
+		if entries[i].Source != want {
+			t.Fatalf("entries[%d].Source = %q, want %q", i, entries[i].Source, want)
+		}
+	}
+	if entries[2].Model != "remote-model" || entries[2].Preview != "from the other box" {
+		t.Fatalf("remote entry = %+v", entries[2].LogEntry)
+	}
+
+	page, _, _, err := handler.mergedLogPage(2, 2)
+	if err != nil {
+		t.Fatalf("mergedLogPage() error = %v", err)
+	}
+	if len(page) != 2 || page[1].Model != "gemma4-31b" {
+		t.Fatalf("second page = %+v", page)
+	}
+}
+
+func TestWebHandlerDetailsFromRemoteSource(t *testing.T) {
+	local := newLogsAPITestDB(t)
+	remoteDB := newLogsAPITestDB(t)
+	server := httptest.NewServer(NewLogsAPIHandler(remoteDB))
+	t.Cleanup(server.Close)
+
+	handler := NewWebHandler(local, nil, NewRemoteLogSource("remote", server.URL, 5))
+
+	req := httptest.NewRequest(http.MethodGet, "/logs/download?id=2&source=remote", nil)
+	rec := httptest.NewRecorder()
+	handler.DownloadHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), "request-remote-2.md") {
+		t.Fatalf("Content-Disposition = %q", rec.Header().Get("Content-Disposition"))
+	}
+	if !strings.Contains(rec.Body.String(), "```\nfailed\n```") {
+		t.Fatalf("download missing remote request body: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/logs/details?id=1&source=missing", nil)
+	rec = httptest.NewRecorder()
+	handler.DetailsHandler(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown source status = %d, want 404", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/logs?page=1", nil)
+	rec = httptest.NewRecorder()
+	handler.IndexHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("index status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "/logs/details?id=2&source=remote") {
+		t.Fatalf("index missing federated details link")
+	}
+}
+
+func TestMergedLogPageReachesPastRemotePageLimit(t *testing.T) {
+	const remoteTotal = 2500
+	newest := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if limit > remoteLogsPageLimit {
+			http.Error(w, "limit too large", http.StatusBadRequest)
+			return
+		}
+		list := logsAPIListResponse{Total: remoteTotal, Limit: limit, Offset: offset, Entries: []logsAPILogEntry{}}
+		for i := offset; i < min(offset+limit, remoteTotal); i++ {
+			list.Entries = append(list.Entries, logsAPILogEntry{ID: int64(remoteTotal - i), Timestamp: newest.Add(-time.Duration(i) * time.Second)})
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(remote.Close)
+
+	handler := NewWebHandler(newLogsAPITestDB(t), nil, NewRemoteLogSource("remote", remote.URL, 5))
+	page, total, unavailable, err := handler.mergedLogPage(50, 2400)
+	if err != nil || len(unavailable) > 0 {
+		t.Fatalf("mergedLogPage() error = %v, unavailable = %v", err, unavailable)
+	}
+	if total != remoteTotal+2 {
+		t.Fatalf("total = %d, want %d", total, remoteTotal+2)
+	}
+	// The remote entries are all newer than the two local ones
+	if len(page) != 50 || page[0].ID != remoteTotal-2400 || page[0].Source != "remote" || page[49].ID != 51 {
+		t.Fatalf("page = %d entries from #%d to #%d", len(page), page[0].ID, page[len(page)-1].ID)
+	}
+}
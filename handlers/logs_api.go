@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"llm_proxy/backend"
 	"llm_proxy/database"
 )
 
@@ -40,10 +41,42 @@ type logsAPILogEntry struct {
 	FrontendURL      string    `json:"frontend_url"`
 	BackendURL       string    `json:"backend_url"`
 	LastMessage      string    `json:"last_message"`
-	FrontendRequest  string    `json:"frontend_request,omitempty"`
-	FrontendResponse string    `json:"frontend_response,omitempty"`
-	BackendRequest   string    `json:"backend_request,omitempty"`
-	BackendResponse  string    `json:"backend_response,omitempty"`
+	CacheHit         bool      `json:"cache_hit"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CachedTokens     int       `json:"cached_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+	Partial          bool      `json:"partial"`
+	TrimmedMessages  string    `json:"trimmed_messages,omitempty"`
+	TTFTMs           int64     `json:"ttft_ms"`
+	GenerationMs     int64     `json:"generation_ms"`
+	TokensPerSecond  float64   `json:"tokens_per_second"`
+	BackendStatus    int       `json:"backend_status"`
+	BackendRequestID string    `json:"backend_request_id,omitempty"`
+	BackendRateLimit string    `json:"backend_rate_limit,omitempty"`
+	FinishReason     string    `json:"finish_reason,omitempty"`
+	ShadowKey        string    `json:"shadow_key,omitempty"`
+	Shadow           bool      `json:"shadow,omitempty"`
+	Tenant           string    `json:"tenant,omitempty"`
+	ClientIP         string    `json:"client_ip,omitempty"`
+	UserAgent        string    `json:"user_agent,omitempty"`
+	User             string    `json:"user,omitempty"`
+	RaceWinner       string    `json:"race_winner,omitempty"`
+
+	FrontendHeaders map[string]string `json:"frontend_headers,omitempty"`
+
+	Prompt           string `json:"prompt,omitempty"`
+	Response         string `json:"response,omitempty"`
+	FrontendRequest  string `json:"frontend_request,omitempty"`
+	FrontendResponse string `json:"frontend_response,omitempty"`
+	BackendRequest   string `json:"backend_request,omitempty"`
+	BackendResponse  string `json:"backend_response,omitempty"`
+
+	// Raw body sizes before truncation
+	FrontendRequestSize  int `json:"frontend_request_size"`
+	FrontendResponseSize int `json:"frontend_response_size"`
+	BackendRequestSize   int `json:"backend_request_size"`
+	BackendResponseSize  int `json:"backend_response_size"`
 }
 
 func (h *LogsAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -58,12 +91,12 @@ func (h *LogsAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			writeLogsAPIError(w, http.StatusNotFound, "not found")
 			return
 		}
-		h.serveEntryByID(w, idText)
+		h.serveEntryByID(w, r, idText)
 		return
 	}
 
 	if idText := r.URL.Query().Get("id"); idText != "" {
-		h.serveEntryByID(w, idText)
+		h.serveEntryByID(w, r, idText)
 		return
 	}
 
@@ -76,6 +109,10 @@ func (h *LogsAPIHandler) serveList(w http.ResponseWriter, r *http.Request) {
 		writeLogsAPIError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	// Tenants only see their own requests
+	if tenant := backend.TenantFromContext(r.Context()); tenant != "" {
+		filter.Tenant = tenant
+	}
 
 	total, err := h.db.CountEntries(filter)
 	if err != nil {
@@ -100,7 +137,7 @@ func (h *LogsAPIHandler) serveList(w http.ResponseWriter, r *http.Request) {
 	writeLogsAPIJSON(w, http.StatusOK, resp)
 }
 
-func (h *LogsAPIHandler) serveEntryByID(w http.ResponseWriter, idText string) {
+func (h *LogsAPIHandler) serveEntryByID(w http.ResponseWriter, r *http.Request, idText string) {
 	id, err := strconv.ParseInt(idText, 10, 64)
 	if err != nil || id <= 0 {
 		writeLogsAPIError(w, http.StatusBadRequest, "invalid id")
@@ -112,7 +149,7 @@ func (h *LogsAPIHandler) serveEntryByID(w http.ResponseWriter, idText string) {
 		writeLogsAPIError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if entry == nil {
+	if tenant := backend.TenantFromContext(r.Context()); entry == nil || tenant != "" && entry.Tenant != tenant {
 		writeLogsAPIError(w, http.StatusNotFound, "not found")
 		return
 	}
@@ -165,6 +202,14 @@ func parseLogsFilter(r *http.Request) (database.LogFilter, bool, error) {
 		}
 		status = &parsed
 	}
+	var backendStatus *int
+	if raw := q.Get("backend_status"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return database.LogFilter{}, false, err
+		}
+		backendStatus = &parsed
+	}
 
 	var since *time.Time
 	if raw := q.Get("since"); raw != "" {
@@ -202,17 +247,21 @@ func parseLogsFilter(r *http.Request) (database.LogFilter, bool, error) {
 	}
 
 	return database.LogFilter{
-		Model:       q.Get("model"),
-		Endpoint:    q.Get("endpoint"),
-		BackendType: q.Get("backend_type"),
-		Query:       q.Get("q"),
-		Order:       order,
-		Status:      status,
-		ErrorsOnly:  errorsOnly,
-		Since:       since,
-		Until:       until,
-		Limit:       limit,
-		Offset:      offset,
+		Model:         q.Get("model"),
+		Endpoint:      q.Get("endpoint"),
+		BackendType:   q.Get("backend_type"),
+		Tenant:        q.Get("tenant"),
+		User:          q.Get("user"),
+		ClientIP:      q.Get("client_ip"),
+		Query:         q.Get("q"),
+		Order:         order,
+		Status:        status,
+		BackendStatus: backendStatus,
+		ErrorsOnly:    errorsOnly,
+		Since:         since,
+		Until:         until,
+		Limit:         limit,
+		Offset:        offset,
 	}, includeBodies, nil
 }
 
@@ -224,23 +273,60 @@ func (e *parseParamError) Error() string {
 	return e.msg
 }
 
+// LogEntryJSON returns entry in the JSON form /api/logs serves it in, with
+// the prompt, response and raw bodies if includeBodies is set
+func LogEntryJSON(entry database.LogEntry, includeBodies bool) interface{} {
+	return logEntryToAPI(entry, includeBodies)
+}
+
 func logEntryToAPI(entry database.LogEntry, includeBodies bool) logsAPILogEntry {
 	apiEntry := logsAPILogEntry{
-		ID:          entry.ID,
-		Timestamp:   entry.Timestamp.UTC(),
-		Endpoint:    entry.Endpoint,
-		Method:      entry.Method,
-		Model:       entry.Model,
-		StatusCode:  entry.StatusCode,
-		LatencyMs:   entry.LatencyMs,
-		Stream:      entry.Stream,
-		BackendType: entry.BackendType,
-		Error:       entry.Error,
-		FrontendURL: entry.FrontendURL,
-		BackendURL:  entry.BackendURL,
-		LastMessage: entry.LastMessage,
+		ID:               entry.ID,
+		Timestamp:        entry.Timestamp.UTC(),
+		Endpoint:         entry.Endpoint,
+		Method:           entry.Method,
+		Model:            entry.Model,
+		StatusCode:       entry.StatusCode,
+		LatencyMs:        entry.LatencyMs,
+		Stream:           entry.Stream,
+		BackendType:      entry.BackendType,
+		Error:            entry.Error,
+		FrontendURL:      entry.FrontendURL,
+		BackendURL:       entry.BackendURL,
+		LastMessage:      entry.LastMessage,
+		CacheHit:         entry.CacheHit,
+		PromptTokens:     entry.PromptTokens,
+		CachedTokens:     entry.CachedTokens,
+		CompletionTokens: entry.CompletionTokens,
+		Cost:             entry.Cost,
+		Partial:          entry.Partial,
+		TrimmedMessages:  entry.TrimmedMessages,
+		TTFTMs:           entry.TTFTMs,
+		GenerationMs:     entry.GenerationMs,
+		TokensPerSecond:  entry.TokensPerSecond,
+		BackendStatus:    entry.BackendStatus,
+		BackendRequestID: entry.BackendRequestID,
+		BackendRateLimit: entry.BackendRateLimit,
+		FinishReason:     entry.FinishReason,
+		ShadowKey:        entry.ShadowKey,
+		Shadow:           entry.Shadow,
+		Tenant:           entry.Tenant,
+		ClientIP:         entry.ClientIP,
+		UserAgent:        entry.UserAgent,
+		User:             entry.User,
+		RaceWinner:       entry.RaceWinner,
+
+		FrontendRequestSize:  entry.FrontendRequestSize,
+		FrontendResponseSize: entry.FrontendResponseSize,
+		BackendRequestSize:   entry.BackendRequestSize,
+		BackendResponseSize:  entry.BackendResponseSize,
+	}
+	if entry.FrontendHeaders != "" {
+		json.Unmarshal([]byte(entry.FrontendHeaders), &apiEntry.FrontendHeaders)
 	}
 	if includeBodies {
+		apiEntry.Prompt = entry.Prompt
+		apiEntry.Response = entry.Response
 		apiEntry.FrontendRequest = entry.FrontendRequest
 		apiEntry.FrontendResponse = entry.FrontendResponse
 		apiEntry.BackendRequest = entry.BackendRequest
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"llm_proxy/backend"
 	"llm_proxy/database"
 )
 
@@ -56,6 +57,16 @@ func TestLogsAPIListBodyToggleAndFilters(t *testing.T) {
 	if list.Entries[0]["frontend_request"] != `{"bad":true}` {
 		t.Fatalf("frontend_request = %#v, want body", list.Entries[0]["frontend_request"])
 	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/logs?backend_status=429", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode backend status list: %v", err)
+	}
+	if list.Total != 1 || list.Entries[0]["backend_request_id"] != "req-429" {
+		t.Fatalf("backend_status=429 list = %s", rec.Body.String())
+	}
 }
 
 func TestLogsAPIEntryByID(t *testing.T) {
@@ -95,6 +106,94 @@ func TestLogsAPIBadParams(t *testing.T) {
 	}
 }
 
+func TestLogsAPIShowsTenantsOnlyTheirEntries(t *testing.T) {
+	db := newLogsAPITestDB(t)
+	handler := NewLogsAPIHandler(db)
+
+	get := func(tenant, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if tenant != "" {
+			req = req.WithContext(backend.WithTenant(req.Context(), tenant))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var list struct {
+		Total   int64                    `json:"total"`
+		Entries []map[string]interface{} `json:"entries"`
+	}
+	rec := get("team-a", "/api/logs?tenant=team-b")
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if list.Total != 1 || list.Entries[0]["tenant"] != "team-a" {
+		t.Fatalf("tenant list = %s, want only the team-a entry", rec.Body.String())
+	}
+	if rec := get("team-a", "/api/logs/1"); rec.Code != http.StatusNotFound {
+		t.Fatalf("other entry status = %d, want 404", rec.Code)
+	}
+	if rec := get("team-a", "/api/logs/2"); rec.Code != http.StatusOK {
+		t.Fatalf("own entry status = %d, want 200", rec.Code)
+	}
+
+	// Without a tenant, tenant is an ordinary filter
+	rec = get("", "/api/logs?tenant=team-a")
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if list.Total != 1 {
+		t.Fatalf("tenant=team-a total = %d, want 1", list.Total)
+	}
+}
+
+func TestLogsAPIRefusesAnonymousRequestsAlongsideTenants(t *testing.T) {
+	// Tenants are configured and share the listener, so a request of no
+	// tenant must present an admin key
+	handler := NewAdminAccess([]string{"admin-key"}, true).Scoped(NewLogsAPIHandler(newLogsAPITestDB(t)))
+
+	get := func(tenant, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/logs", nil)
+		ctx := backend.WithClientKey(req.Context(), key)
+		if tenant != "" {
+			ctx = backend.WithTenant(ctx, tenant)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	if rec := get("", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("anonymous status = %d, want 403", rec.Code)
+	}
+	if rec := get("", "unknown-key"); rec.Code != http.StatusForbidden {
+		t.Fatalf("unknown key status = %d, want 403", rec.Code)
+	}
+
+	var list struct {
+		Total int64 `json:"total"`
+	}
+	for _, tc := range []struct {
+		tenant, key string
+		total       int64
+	}{
+		{"", "admin-key", 2},
+		{"team-a", "team-a-key", 1},
+	} {
+		rec := get(tc.tenant, tc.key)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q status = %d, want 200", tc.key, rec.Code)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatalf("decode list: %v", err)
+		}
+		if list.Total != tc.total {
+			t.Fatalf("%q total = %d, want %d", tc.key, list.Total, tc.total)
+		}
+	}
+}
+
 func newLogsAPITestDB(t *testing.T) *database.DB {
 	t.Helper()
 	db, err := database.New(filepath.Join(t.TempDir(), "llm_proxy.db"))
@@ -130,12 +229,15 @@ func newLogsAPITestDB(t *testing.T) *database.DB {
 			StatusCode:       500,
 			LatencyMs:        25,
 			BackendType:      "ollama",
+			BackendStatus:    429,
+			BackendRequestID: "req-429",
 			Error:            "backend failed",
 			FrontendRequest:  `{"bad":true}`,
 			FrontendResponse: `{"error":true}`,
 			BackendRequest:   `{"backend":false}`,
 			BackendResponse:  `failed`,
 			LastMessage:      "bad",
+			Tenant:           "team-a",
 		},
 	}
 	for _, entry := range entries {
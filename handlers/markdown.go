@@ -0,0 +1,576 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// renderMarkdown renders the Markdown that models usually answer in:
+// headings, paragraphs, fenced code blocks (highlighted by highlightCode),
+// lists, block quotes, rules, GitHub-style tables and inline code, emphasis,
+// strikethrough and links. Raw HTML is escaped rather than passed through,
+// links are limited to http, https and mailto, and images are shown as links
+// so that a logged response can't load anything by itself. Single newlines
+// are kept as line breaks, as in chat UIs.
+func renderMarkdown(src string) template.HTML {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+	var b strings.Builder
+	renderMarkdownBlocks(&b, strings.Split(src, "\n"), false)
+	return template.HTML(b.String())
+}
+
+var (
+	mdHeading     = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdRule        = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdFence       = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`]*)$")
+	mdBullet      = regexp.MustCompile(`^( {0,3})([-*+])( +|$)`)
+	mdOrdered     = regexp.MustCompile(`^( {0,3})(\d{1,9})([.)])( +|$)`)
+	mdQuote       = regexp.MustCompile(`^ {0,3}> ?`)
+	mdTableDelim  = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	mdTaskItem    = regexp.MustCompile(`^\[([ xX])\] `)
+	mdBareURL     = regexp.MustCompile(`^https?://[^\s<>"]*[^\s<>".,;:!?)'\]*_~]`)
+	mdLinkTarget  = regexp.MustCompile(`^\(\s*<?([^\s()<>]*(?:\([^\s()<>]*\)[^\s()<>]*)*)>?(?:\s+"[^"]*")?\s*\)`)
+	mdSafeLinkURL = regexp.MustCompile(`(?i)^(?:https?:|mailto:)`)
+)
+
+// renderMarkdownBlocks renders lines as block elements. In a tight list item
+// paragraphs aren't wrapped in <p>.
+func renderMarkdownBlocks(b *strings.Builder, lines []string, tight bool) {
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		text := renderMarkdownInline(strings.TrimSpace(strings.Join(paragraph, "\n")))
+		if tight {
+			b.WriteString(text)
+			b.WriteString("\n")
+		} else {
+			b.WriteString("<p>" + text + "</p>\n")
+		}
+		paragraph = nil
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+			i++
+
+		case mdFence.MatchString(line):
+			flush()
+			i = renderMarkdownFence(b, lines, i)
+
+		case mdHeading.MatchString(line):
+			flush()
+			m := mdHeading.FindStringSubmatch(line)
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", len(m[1]), renderMarkdownInline(m[2]), len(m[1]))
+			i++
+
+		case mdRule.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+			i++
+
+		case mdQuote.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && mdQuote.MatchString(lines[i]); i++ {
+				quoted = append(quoted, mdQuote.ReplaceAllString(lines[i], ""))
+			}
+			b.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(b, quoted, false)
+			b.WriteString("</blockquote>\n")
+
+		case mdBullet.MatchString(line) || mdOrdered.MatchString(line):
+			flush()
+			i = renderMarkdownList(b, lines, i)
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && mdTableDelim.MatchString(lines[i+1]):
+			flush()
+			i = renderMarkdownTable(b, lines, i)
+
+		default:
+			paragraph = append(paragraph, line)
+			i++
+		}
+	}
+	flush()
+}
+
+// renderMarkdownFence renders the fenced code block starting at lines[start]
+// and returns the index of the line after it. An unclosed fence runs to the
+// end.
+func renderMarkdownFence(b *strings.Builder, lines []string, start int) int {
+	m := mdFence.FindStringSubmatch(lines[start])
+	indent, fence := len(m[1]), m[2]
+	lang := strings.ToLower(strings.Fields(m[3] + " ")[0])
+
+	var code []string
+	i := start + 1
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence[:3]) && strings.Trim(trimmed, fence[:1]) == "" && len(trimmed) >= len(fence) {
+			i++
+			break
+		}
+		// Remove up to the fence's own indentation
+		line := lines[i]
+		for n := 0; n < indent && strings.HasPrefix(line, " "); n++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+
+	b.WriteString(`<div class="markdown-code">`)
+	if lang != "" {
+		b.WriteString(`<div class="markdown-code-lang">` + html.EscapeString(lang) + `</div>`)
+	}
+	b.WriteString(`<pre class="code-block"><code>`)
+	b.WriteString(highlightCode(strings.Join(code, "\n"), lang))
+	b.WriteString("</code></pre></div>\n")
+	return i
+}
+
+// renderMarkdownList renders the list starting at lines[start] and returns the
+// index of the line after it. Lines indented past the marker belong to the
+// item, so lists can nest and hold code blocks.
+func renderMarkdownList(b *strings.Builder, lines []string, start int) int {
+	ordered := !mdBullet.MatchString(lines[start])
+	marker := func(line string) (contentIndent int, ok bool) {
+		if ordered {
+			m := mdOrdered.FindStringSubmatch(line)
+			if m == nil {
+				return 0, false
+			}
+			return len(m[1]) + len(m[2]) + len(m[3]) + max(len(m[4]), 1), true
+		}
+		m := mdBullet.FindStringSubmatch(line)
+		if m == nil || mdRule.MatchString(line) {
+			return 0, false
+		}
+		return len(m[1]) + len(m[2]) + max(len(m[3]), 1), true
+	}
+
+	var items [][]string
+	tight := true
+	i := start
+	for i < len(lines) {
+		contentIndent, ok := marker(lines[i])
+		if !ok {
+			break
+		}
+		item := []string{strings.TrimLeft(lines[i][min(contentIndent, len(lines[i])):], " ")}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line continues the item only if indented content follows
+				next := i + 1
+				for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+					next++
+				}
+				if next < len(lines) && leadingSpaces(lines[next]) >= contentIndent {
+					item = append(item, "")
+					tight = false
+					i++
+					continue
+				}
+				if next < len(lines) {
+					if _, ok := marker(lines[next]); ok {
+						tight = false
+					}
+				}
+				break
+			}
+			if leadingSpaces(line) >= contentIndent {
+				item = append(item, line[contentIndent:])
+			} else if _, ok := marker(line); ok || mdFence.MatchString(line) || mdHeading.MatchString(line) || mdQuote.MatchString(line) {
+				break
+			} else {
+				// Lazy continuation of the item's paragraph
+				item = append(item, strings.TrimSpace(line))
+			}
+			i++
+		}
+		items = append(items, item)
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			if next := i + 1; next < len(lines) {
+				if _, ok := marker(lines[next]); !ok {
+					break
+				}
+			}
+			i++
+		}
+	}
+
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+		if n, _ := strconv.Atoi(mdOrdered.FindStringSubmatch(lines[start])[2]); n != 1 {
+			fmt.Fprintf(b, "<ol start=\"%d\">\n", n)
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+	for _, item := range items {
+		b.WriteString("<li>")
+		if m := mdTaskItem.FindStringSubmatch(item[0]); m != nil {
+			checked := ""
+			if m[1] != " " {
+				checked = " checked"
+			}
+			b.WriteString(`<input type="checkbox" disabled` + checked + `> `)
+			item[0] = item[0][len(m[0]):]
+		}
+		renderMarkdownBlocks(b, item, tight)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// renderMarkdownTable renders the table whose header is lines[start] and
+// returns the index of the line after it
+func renderMarkdownTable(b *strings.Builder, lines []string, start int) int {
+	header := splitTableRow(lines[start])
+	var aligns []string
+	for _, cell := range splitTableRow(lines[start+1]) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, "center")
+		case strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, "right")
+		case strings.HasPrefix(cell, ":"):
+			aligns = append(aligns, "left")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+	writeRow := func(cells []string, tag string) {
+		b.WriteString("<tr>")
+		for j := range header {
+			cell := ""
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			if j < len(aligns) && aligns[j] != "" {
+				fmt.Fprintf(b, `<%s style="text-align: %s">`, tag, aligns[j])
+			} else {
+				b.WriteString("<" + tag + ">")
+			}
+			b.WriteString(renderMarkdownInline(cell) + "</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+
+	b.WriteString("<div class=\"markdown-table\"><table>\n<thead>\n")
+	writeRow(header, "th")
+	b.WriteString("</thead>\n<tbody>\n")
+	i := start + 2
+	for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|"); i++ {
+		writeRow(splitTableRow(lines[i]), "td")
+	}
+	b.WriteString("</tbody>\n</table></div>\n")
+	return i
+}
+
+// splitTableRow splits a table row into its trimmed cells. Pipes escaped
+// with a backslash or inside code spans don't split cells.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	inCode := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case c == '`':
+			inCode = !inCode
+			cell.WriteByte(c)
+		case c == '|' && !inCode:
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(c)
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func leadingSpaces(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " "))
+}
+
+// inlineScan remembers what renderMarkdownInline has found out about its
+// text, so that a delimiter with no closing partner is only searched for
+// once rather than from each of its occurrences, which takes quadratic time
+// on long responses full of stray asterisks or brackets
+type inlineScan struct {
+	s         string
+	brackets  []int          // Index of the ] matching the [ at each index (-1 = none); built on first use
+	noCloser  map[string]int // Emphasis delimiter -> index from which it has no closer
+	noCodeEnd map[int]int    // Backtick run length -> index from which no run of that length follows
+}
+
+// renderMarkdownInline renders the inline elements of a block's text
+func renderMarkdownInline(s string) string {
+	var b strings.Builder
+	scan := &inlineScan{s: s, noCloser: make(map[string]int), noCodeEnd: make(map[int]int)}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!|~<>\"'", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '\n':
+			b.WriteString("<br>\n")
+			i++
+			continue
+
+		case c == '`':
+			n := countRun(s[i:], '`')
+			if end := scan.codeSpanEnd(i+n, n); end >= 0 {
+				code := strings.ReplaceAll(s[i+n:end], "\n", " ")
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+					code = code[1 : len(code)-1]
+				}
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end + n
+				continue
+			}
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '!' && strings.HasPrefix(s[i:], "!["):
+			if text, url, next, ok := scan.link(i + 1); ok {
+				// Images are linked rather than loaded
+				label := "🖼 " + text
+				if mdSafeLinkURL.MatchString(url) {
+					b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="noopener noreferrer" target="_blank">` + html.EscapeString(label) + `</a>`)
+				} else {
+					b.WriteString(html.EscapeString(label))
+				}
+				i = next
+				continue
+			}
+
+		case c == '[':
+			if text, url, next, ok := scan.link(i); ok {
+				if mdSafeLinkURL.MatchString(url) {
+					b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="noopener noreferrer" target="_blank">` + renderMarkdownInline(text) + `</a>`)
+				} else {
+					b.WriteString(renderMarkdownInline(text))
+				}
+				i = next
+				continue
+			}
+
+		case c == '<':
+			if end := strings.IndexByte(s[i:], '>'); end > 0 {
+				if url := s[i+1 : i+end]; mdBareURL.MatchString(url) && !strings.ContainsAny(url, " \n") {
+					b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="noopener noreferrer" target="_blank">` + html.EscapeString(url) + `</a>`)
+					i += end + 1
+					continue
+				}
+			}
+
+		case c == 'h' && (i == 0 || !isWordByte(s[i-1])):
+			if url := mdBareURL.FindString(s[i:]); url != "" {
+				b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="noopener noreferrer" target="_blank">` + html.EscapeString(url) + `</a>`)
+				i += len(url)
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if tag, inner, next, ok := scan.emphasis(i); ok {
+				b.WriteString("<" + tag + ">" + renderMarkdownInline(inner) + "</" + tag + ">")
+				i = next
+				continue
+			}
+		}
+
+		// Copy a whole UTF-8 sequence, escaped
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+	return b.String()
+}
+
+// link parses [text](url) at s[start]
+func (p *inlineScan) link(start int) (text, url string, next int, ok bool) {
+	if p.brackets == nil {
+		p.matchBrackets()
+	}
+	i := p.brackets[start]
+	if i < 0 {
+		return "", "", 0, false
+	}
+	m := mdLinkTarget.FindStringSubmatch(p.s[i+1:])
+	if m == nil {
+		return "", "", 0, false
+	}
+	return p.s[start+1 : i], m[1], i + 1 + len(m[0]), true
+}
+
+// matchBrackets pairs up the square brackets of s in one pass. Brackets
+// don't pair across a blank line, and escaped ones don't pair at all.
+func (p *inlineScan) matchBrackets() {
+	s := p.s
+	p.brackets = make([]int, len(s))
+	var open []int
+	for i := 0; i < len(s); i++ {
+		p.brackets[i] = -1
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				p.brackets[i] = -1
+			}
+		case '[':
+			open = append(open, i)
+		case ']':
+			if len(open) > 0 {
+				p.brackets[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		case '\n':
+			if i+1 < len(s) && s[i+1] == '\n' {
+				open = open[:0]
+			}
+		}
+	}
+}
+
+// emphasis parses **strong**, __strong__, *em*, _em_ or ~~strikethrough~~
+// at s[start]. Underscores only count at word boundaries, so snake_case
+// names are left alone.
+func (p *inlineScan) emphasis(start int) (tag, inner string, next int, ok bool) {
+	s := p.s
+	c := s[start]
+	n := min(countRun(s[start:], c), 2)
+	if c == '~' && n < 2 {
+		return "", "", 0, false
+	}
+	open := start + n
+	if open >= len(s) || unicode.IsSpace(rune(s[open])) {
+		return "", "", 0, false
+	}
+	if c == '_' && start > 0 && isWordByte(s[start-1]) {
+		return "", "", 0, false
+	}
+
+	delim := strings.Repeat(string(c), n)
+	if from, ok := p.noCloser[delim]; ok && open >= from {
+		return "", "", 0, false
+	}
+	for i := open + 1; i < len(s); i++ {
+		switch {
+		case s[i] == '`':
+			// Skip code spans
+			run := countRun(s[i:], '`')
+			if end := p.codeSpanEnd(i+run, run); end >= 0 {
+				i = end + run - 1
+			} else {
+				i += run - 1
+			}
+			continue
+		case s[i] == '\\':
+			i++
+			continue
+		case s[i] != c || !strings.HasPrefix(s[i:], delim):
+			continue
+		}
+
+		run := countRun(s[i:], c)
+		if n == 1 && run >= 2 {
+			// Skip a nested strong delimiter
+			i += run - 1
+			continue
+		}
+		if n == 2 && run > 2 {
+			// Close on the last two of ***, leaving the first for an inner em
+			i += run - 2
+		}
+		if unicode.IsSpace(rune(s[i-1])) {
+			continue
+		}
+		if c == '_' && i+n < len(s) && isWordByte(s[i+n]) {
+			continue
+		}
+		switch {
+		case c == '~':
+			tag = "del"
+		case n == 2:
+			tag = "strong"
+		default:
+			tag = "em"
+		}
+		return tag, s[open:i], i + n, true
+	}
+	p.noCloser[delim] = open
+	return "", "", 0, false
+}
+
+// codeSpanEnd is findCodeSpanEnd on s, remembering runs that don't close
+func (p *inlineScan) codeSpanEnd(from, n int) int {
+	if unclosed, ok := p.noCodeEnd[n]; ok && from >= unclosed {
+		return -1
+	}
+	end := findCodeSpanEnd(p.s, from, n)
+	if end < 0 {
+		p.noCodeEnd[n] = from
+	}
+	return end
+}
+
+// findCodeSpanEnd returns the index of the closing run of n backticks at or
+// after from, or -1
+func findCodeSpanEnd(s string, from, n int) int {
+	for i := from; i < len(s); {
+		j := strings.IndexByte(s[i:], '`')
+		if j < 0 {
+			return -1
+		}
+		i += j
+		run := countRun(s[i:], '`')
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
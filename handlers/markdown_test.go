@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "inline",
+			src:  "Some **bold**, *em*, ~~gone~~ and `a_b` in snake_case_name\nnext",
+			want: "<p>Some <strong>bold</strong>, <em>em</em>, <del>gone</del> and <code>a_b</code> in snake_case_name<br>\nnext</p>\n",
+		},
+		{
+			name: "html is escaped",
+			src:  "<img src=x onerror=alert(1)>",
+			want: "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n",
+		},
+		{
+			name: "unsafe links and images",
+			src:  "[click](javascript:alert(1)) ![pic](https://example.com/a.png)",
+			want: `<p>click <a href="https://example.com/a.png" rel="noopener noreferrer" target="_blank">🖼 pic</a></p>` + "\n",
+		},
+		{
+			name: "heading and nested list",
+			src:  "## Steps\n- one\n- two\n  1. sub",
+			want: "<h2>Steps</h2>\n<ul>\n<li>one\n</li>\n<li>two\n<ol>\n<li>sub\n</li>\n</ol>\n</li>\n</ul>\n",
+		},
+		{
+			name: "highlighted code block",
+			src:  "```python\nx = 'hi'  # greet\nreturn None\n```",
+			want: `<div class="markdown-code"><div class="markdown-code-lang">python</div><pre class="code-block"><code>x = <span class="tok-string">&#39;hi&#39;</span>  <span class="tok-comment"># greet</span>` + "\n" +
+				`<span class="tok-keyword">return</span> <span class="tok-keyword">None</span></code></pre></div>` + "\n",
+		},
+		{
+			name: "unknown language is only escaped",
+			src:  "~~~brainfuck\n<+>\n~~~",
+			want: `<div class="markdown-code"><div class="markdown-code-lang">brainfuck</div><pre class="code-block"><code>&lt;+&gt;</code></pre></div>` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(renderMarkdown(tt.src)); got != tt.want {
+				t.Fatalf("renderMarkdown() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownTable(t *testing.T) {
+	got := string(renderMarkdown("| Name | Count |\n|------|------:|\n| `a\\|b` | 2 |\n\nafter"))
+	for _, want := range []string{
+		`<tr><th>Name</th><th style="text-align: right">Count</th></tr>`,
+		`<tr><td><code>a|b</code></td><td style="text-align: right">2</td></tr>`,
+		"</table></div>\n<p>after</p>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("table missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderMarkdownUnmatchedDelimitersTakeLinearTime(t *testing.T) {
+	// Each unmatched delimiter used to be searched for to the end of the
+	// paragraph, so these took seconds each
+	for _, unit := range []string{"*a ", "_a ", "**a ", "~~a ", "[a ", "[a] ", "![a ", "`a `` "} {
+		src := strings.Repeat(unit, 20000)
+		start := time.Now()
+		got := string(renderMarkdown(src))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("renderMarkdown(%q x 20000) took %v", unit, elapsed)
+		}
+		if strings.Contains(got, "<em>") || strings.Contains(got, "<a ") {
+			t.Errorf("renderMarkdown(%q x 20000) matched delimiters that have no partner", unit)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// ModelCacheHandler serves /api/model_cache: GET returns the model cache's
+// stats and DELETE empties it, so that newly pulled or deleted models show
+// up straight away.
+type ModelCacheHandler struct {
+	cache *backend.ModelCacheBackend
+	db    *database.DB
+}
+
+// NewModelCacheHandler creates a new model cache handler
+func NewModelCacheHandler(cache *backend.ModelCacheBackend, db *database.DB) *ModelCacheHandler {
+	return &ModelCacheHandler{cache: cache, db: db}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *ModelCacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		h.cache.Invalidate()
+		audit(r.Context(), h.db, "model_cache_clear", "")
+		log.Printf("Model cache cleared")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.cache.Stats()); err != nil {
+		log.Printf("Failed to encode model cache stats: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"llm_proxy/backend"
+)
+
+func TestModelCacheHandler(t *testing.T) {
+	db := newLogsAPITestDB(t)
+	cache := backend.NewModelCacheBackend(backend.NewMockBackend(backend.MockOptions{Models: []string{"llama3"}}), time.Minute)
+	if _, err := cache.ListModels(context.Background()); err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	handler := NewModelCacheHandler(cache, db)
+
+	serve := func(method string) (int, backend.ModelCacheStats) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/api/model_cache", nil))
+		var stats backend.ModelCacheStats
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+				t.Fatalf("invalid stats JSON %q: %v", rec.Body.String(), err)
+			}
+		}
+		return rec.Code, stats
+	}
+
+	if code, stats := serve(http.MethodGet); code != http.StatusOK || !stats.ModelList || stats.TTL != 60 {
+		t.Fatalf("GET = %d, %+v; want the cached model list", code, stats)
+	}
+	if code, stats := serve(http.MethodDelete); code != http.StatusOK || stats.ModelList {
+		t.Fatalf("DELETE = %d, %+v; want an empty cache", code, stats)
+	}
+	if code, _ := serve(http.MethodPost); code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+
+	audited, err := db.GetAuditEntries(10, 0)
+	if err != nil {
+		t.Fatalf("GetAuditEntries() error = %v", err)
+	}
+	if len(audited) != 1 || audited[0].Action != "model_cache_clear" {
+		t.Fatalf("audit entries = %+v, want the cache clear", audited)
+	}
+}
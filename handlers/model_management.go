@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm_proxy/config"
+	"llm_proxy/database"
+)
+
+// ModelManagementHandler passes Ollama model management requests
+// (/api/pull, /api/delete, /api/copy) through to an Ollama backend. Other
+// backend types have no equivalent, so the request is rejected.
+type ModelManagementHandler struct {
+	path   string
+	method string
+	db     *database.DB
+	config *config.Config
+	client *http.Client
+}
+
+// modelManagementRequest holds the fields of the pull, delete and copy
+// requests used for logging.
+type modelManagementRequest struct {
+	Model       string `json:"model"`
+	Name        string `json:"name"`        // Older clients send name instead of model
+	Source      string `json:"source"`      // copy
+	Destination string `json:"destination"` // copy
+	Stream      *bool  `json:"stream"`
+}
+
+// NewModelManagementHandler creates a handler that proxies path to the
+// backend. method is the only HTTP method accepted.
+func NewModelManagementHandler(path string, method string, db *database.DB, config *config.Config) *ModelManagementHandler {
+	return &ModelManagementHandler{
+		path:   path,
+		method: method,
+		db:     db,
+		config: config,
+		// No timeout: pulls can take a long time, and the client's
+		// context cancels the request if it goes away.
+		client: &http.Client{},
+	}
+}
+
+// SetTransport replaces the HTTP transport used for backend requests, so
+// they use the backend's TLS and proxy settings; nil uses the default
+// transport.
+func (h *ModelManagementHandler) SetTransport(transport http.RoundTripper) {
+	h.client.Transport = transport
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *ModelManagementHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != h.method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startTime := time.Now()
+
+	bodyBytes, status, err := readRequestBody(r)
+	if err != nil {
+		writeOllamaError(w, status, err.Error())
+		return
+	}
+
+	var req modelManagementRequest
+	if len(bodyBytes) > 0 {
+		if err := decodeRequestBody(bodyBytes, &req); err != nil {
+			errMsg := fmt.Sprintf("invalid request body: %v", err)
+			h.logRequest(r.Context(), startTime, req, string(bodyBytes), "", http.StatusBadRequest, errMsg, "")
+			writeOllamaError(w, http.StatusBadRequest, errMsg)
+			return
+		}
+	}
+
+	if h.config.Current().Backend.Type != "ollama" {
+		errMsg := fmt.Sprintf("%s is only supported with an ollama backend", h.path)
+		h.logRequest(r.Context(), startTime, req, string(bodyBytes), "", http.StatusNotImplemented, errMsg, "")
+		writeOllamaError(w, http.StatusNotImplemented, errMsg)
+		return
+	}
+
+	backendURL := strings.TrimRight(h.config.Current().Backend.Endpoint, "/") + h.path
+	httpReq, err := http.NewRequestWithContext(r.Context(), h.method, backendURL, bytes.NewReader(bodyBytes))
+	if err != nil {
+		h.logRequest(r.Context(), startTime, req, string(bodyBytes), "", http.StatusInternalServerError, err.Error(), backendURL)
+		writeOllamaError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for name, value := range h.config.Current().Backend.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		log.Printf("Backend error: %v", err)
+		h.logRequest(r.Context(), startTime, req, string(bodyBytes), "", http.StatusBadGateway, err.Error(), backendURL)
+		writeOllamaError(w, http.StatusBadGateway, "request failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// Copy in whatever pieces the backend sends so pull progress reaches the
+	// client as it happens
+	var response bytes.Buffer
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 4096)
+	var copyErr error
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			response.Write(buf[:n])
+			if _, err := w.Write(buf[:n]); err != nil {
+				copyErr = err
+				break
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			copyErr = err
+			break
+		}
+	}
+
+	errMsg := ""
+	if copyErr != nil {
+		errMsg = copyErr.Error()
+	} else if resp.StatusCode >= 400 {
+		errMsg = strings.TrimSpace(response.String())
+	}
+	h.logRequest(r.Context(), startTime, req, string(bodyBytes), response.String(), resp.StatusCode, errMsg, backendURL)
+}
+
+func (h *ModelManagementHandler) logRequest(ctx context.Context, startTime time.Time, req modelManagementRequest, frontendReq string, response string, statusCode int, errMsg string, backendURL string) {
+	model := req.Model
+	if model == "" {
+		model = req.Name
+	}
+	if model == "" {
+		model = req.Source
+	}
+	// Pulls stream progress unless the client opts out
+	stream := h.path == "/api/pull" && (req.Stream == nil || *req.Stream)
+
+	entry := database.LogEntry{
+		Timestamp:        startTime,
+		Endpoint:         h.path,
+		Method:           h.method,
+		Model:            model,
+		Response:         response,
+		StatusCode:       statusCode,
+		LatencyMs:        time.Since(startTime).Milliseconds(),
+		Stream:           stream,
+		BackendType:      h.config.Current().Backend.Type,
+		Error:            errMsg,
+		FrontendURL:      fmt.Sprintf("http://%s:%d%s", h.config.Current().Server.Host, h.config.Current().Server.Port, h.path),
+		BackendURL:       backendURL,
+		FrontendRequest:  frontendReq,
+		FrontendResponse: response,
+		BackendRequest:   frontendReq,
+		BackendResponse:  response,
+	}
+	attributeEntry(ctx, &entry)
+
+	if err := h.db.Log(entry); err != nil {
+		log.Printf("Failed to log %s request: %v", h.path, err)
+	}
+	// Requests that reached the backend change its models
+	if backendURL != "" {
+		detail := fmt.Sprintf("%s: status %d", model, statusCode)
+		if req.Destination != "" {
+			detail = fmt.Sprintf("%s to %s: status %d", req.Source, req.Destination, statusCode)
+		}
+		audit(ctx, h.db, "model_"+strings.TrimPrefix(h.path, "/api/"), detail)
+	}
+}
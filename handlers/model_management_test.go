@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"llm_proxy/backend"
+)
+
+func TestModelManagementHandlerProxiesPull(t *testing.T) {
+	var gotBody string
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/pull" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	defer ollama.Close()
+
+	db := newEmbedTestDB(t)
+	cfg := embedTestConfig()
+	cfg.Backend.Endpoint = ollama.URL
+	handler := NewModelManagementHandler("/api/pull", http.MethodPost, db, cfg)
+
+	body := string(postEmbed(t, handler, "/api/pull", `{"model":"llama3:8b"}`))
+	if gotBody != `{"model":"llama3:8b"}` {
+		t.Fatalf("backend body = %s", gotBody)
+	}
+	if body != "{\"status\":\"pulling manifest\"}\n{\"status\":\"success\"}\n" {
+		t.Fatalf("body = %q", body)
+	}
+
+	entries, err := db.GetRecentEntries(1, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if entries[0].Endpoint != "/api/pull" || entries[0].Model != "llama3:8b" || !entries[0].Stream || entries[0].StatusCode != http.StatusOK {
+		t.Fatalf("logged entry = %+v", entries[0])
+	}
+}
+
+func TestModelManagementHandlerRequiresOllama(t *testing.T) {
+	db := newEmbedTestDB(t)
+	cfg := embedTestConfig()
+	cfg.Backend.Type = "openai"
+	handler := NewModelManagementHandler("/api/delete", http.MethodDelete, db, cfg)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/delete", strings.NewReader(`{"model":"m"}`)))
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("status = %d, want 501", rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" || !strings.Contains(rec.Body.String(), `"error":"/api/delete is only supported with an ollama backend"`) {
+		t.Fatalf("501 response = %q, want an Ollama JSON error", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/delete", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", rec.Code)
+	}
+
+	entries, err := db.GetRecentEntries(1, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Endpoint != "/api/delete" || entries[0].Method != http.MethodDelete || entries[0].StatusCode != http.StatusNotImplemented {
+		t.Fatalf("logged entries = %+v", entries)
+	}
+}
+
+func TestModelManagementHandlerUsesBackendTLS(t *testing.T) {
+	ollama := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer ollama.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ollama.Certificate().Raw})
+	if err := os.WriteFile(caPath, ca, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg := embedTestConfig()
+	cfg.Backend.Endpoint = ollama.URL
+	handler := NewModelManagementHandler("/api/copy", http.MethodPost, newEmbedTestDB(t), cfg)
+
+	// The default transport doesn't trust the backend's certificate
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/copy", strings.NewReader(`{"source":"a","destination":"b"}`)))
+	if rec.Code != http.StatusBadGateway || !strings.HasPrefix(rec.Body.String(), `{"error":"request failed: `) {
+		t.Fatalf("without CA: status = %d, body = %s; want a 502 Ollama JSON error", rec.Code, rec.Body.String())
+	}
+
+	transport, err := backend.NewTransport(backend.TransportOptions{CACert: caPath})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	handler.SetTransport(transport)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/copy", strings.NewReader(`{"source":"a","destination":"b"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status with CA = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestModelManagementHandlerUsesBackendProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer proxy.Close()
+
+	cfg := embedTestConfig()
+	cfg.Backend.Endpoint = "http://ollama.invalid:11434"
+	handler := NewModelManagementHandler("/api/delete", http.MethodDelete, newEmbedTestDB(t), cfg)
+	transport, err := backend.NewTransport(backend.TransportOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	handler.SetTransport(transport)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/delete", strings.NewReader(`{"model":"m"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if proxied != "http://ollama.invalid:11434/api/delete" {
+		t.Fatalf("proxied URL = %q", proxied)
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -65,8 +66,13 @@ func (h *ShowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Model string `json:"model"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	bodyBytes, status, err := readRequestBody(r)
+	if err != nil {
+		writeOllamaError(w, status, err.Error())
+		return
+	}
+	if err := decodeRequestBody(bodyBytes, &req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
 		return
 	}
 
@@ -75,18 +81,19 @@ func (h *ShowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		modelName = req.Name
 	}
 	if modelName == "" {
-		http.Error(w, "Missing model", http.StatusBadRequest)
+		writeOllamaError(w, http.StatusBadRequest, "model is required")
 		return
 	}
 
 	response, err := h.backend.ShowModel(r.Context(), modelName)
 	if err != nil {
 		log.Printf("Failed to show model: %v", err)
-		status := http.StatusInternalServerError
+		// Errors are sent as Ollama sends them, which clients check for
 		if strings.Contains(err.Error(), "status code: 404") || strings.Contains(err.Error(), "model not found") {
-			status = http.StatusNotFound
+			writeOllamaError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", modelName))
+			return
 		}
-		http.Error(w, err.Error(), status)
+		writeOllamaError(w, backendErrorStatus(err), err.Error())
 		return
 	}
 
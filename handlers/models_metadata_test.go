@@ -53,6 +53,10 @@ func (b *modelMetadataBackend) ShowModel(_ context.Context, model string) (model
 	}, nil
 }
 
+func (b *modelMetadataBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 func TestModelsHandlerPreservesOllamaMetadata(t *testing.T) {
 	handler := NewModelsHandler(&modelMetadataBackend{})
 	req := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
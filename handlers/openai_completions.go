@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/config"
+	"llm_proxy/database"
+	"llm_proxy/models"
+)
+
+// OpenAICompletionsHandler handles OpenAI-compatible /v1/completions requests
+// by translating them to a Generate call on the configured backend.
+type OpenAICompletionsHandler struct {
+	backend backend.Backend
+	db      *database.DB
+	config  *config.Config
+}
+
+// NewOpenAICompletionsHandler creates a new OpenAI-compatible completions handler.
+func NewOpenAICompletionsHandler(backend backend.Backend, db *database.DB, config *config.Config) *OpenAICompletionsHandler {
+	return &OpenAICompletionsHandler{
+		backend: backend,
+		db:      db,
+		config:  config,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *OpenAICompletionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startTime := time.Now()
+
+	bodyBytes, status, err := readRequestBody(r)
+	if err != nil {
+		log.Printf("OpenAI completion request: %v", err)
+		h.logInvalidRequest(r.Context(), startTime, "", status, err.Error())
+		writeOpenAIError(w, status, err.Error())
+		return
+	}
+
+	var req models.OpenAICompletionRequest
+	if err := decodeRequestBody(bodyBytes, &req); err != nil {
+		errMsg := fmt.Sprintf("invalid request body: %v", err)
+		log.Printf("OpenAI completion request: invalid request body: %v\nBody: %s", err, string(bodyBytes))
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, errMsg)
+		writeOpenAIError(w, http.StatusBadRequest, errMsg)
+		return
+	}
+	prompt, err := completionPrompt(req.Prompt)
+	if err != nil {
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, err.Error())
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if h.config.Current().Server.LogRawRequests {
+		reqJSON, err := json.MarshalIndent(req, "", "  ")
+		if err == nil {
+			log.Printf("=== Raw OpenAI Completion Request ===\n%s\n=====================================", string(reqJSON))
+		}
+	}
+
+	if shouldDropMaxTokens(req.MaxTokens, h.config.Current()) {
+		if h.config.Current().Server.Verbose {
+			log.Printf("Dropping max_tokens: %d", req.MaxTokens)
+		}
+		req.MaxTokens = 0
+	}
+	clientWantsStream := req.Stream
+
+	genReq := models.GenerateRequest{
+		Model:   req.Model,
+		Prompt:  prompt,
+		Stream:  resolveStream(clientWantsStream, h.config.Current()),
+		Options: completionOptions(req),
+	}
+	if err := applyGenerateTokenBudget(&genReq, h.config.Current()); err != nil {
+		log.Printf("OpenAI completion request: %v", err)
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, err.Error())
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if h.config.Current().Server.LogMessages {
+		log.Printf("=== OpenAI Completion Request ===")
+		log.Printf("Model: %s", genReq.Model)
+		log.Printf("Prompt: %s", genReq.Prompt)
+		log.Printf("=================================")
+	}
+
+	// Record exactly what is sent to the client for the log
+	capture := captureResponse(w)
+	w = capture
+
+	respChan, backendMeta, err := h.backend.Generate(r.Context(), genReq)
+	if err != nil {
+		log.Printf("Backend error: %v", err)
+		status := backendErrorStatus(err)
+		writeOpenAIError(w, status, err.Error())
+		h.logRequest(r.Context(), startTime, genReq, clientWantsStream, "", status, err.Error(), string(bodyBytes), capture.String(), backendMeta, "", generationTiming{})
+		return
+	}
+
+	id := fmt.Sprintf("cmpl-%d", startTime.UnixNano())
+	created := time.Now().Unix()
+	var fullResponse strings.Builder
+	finishReason := "stop"
+	var usage *models.OpenAIUsage
+	var timing generationTiming
+
+	if clientWantsStream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+	}
+	flusher, _ := w.(http.Flusher)
+
+	var keepAlive time.Duration
+	if clientWantsStream {
+		keepAlive = keepAliveInterval(h.config.Current())
+	}
+	for {
+		resp, ok := nextChunk(r.Context(), respChan, keepAlive, sseKeepAlive(w))
+		if !ok {
+			break
+		}
+		fullResponse.WriteString(resp.Response)
+		timing.observe(resp.Response != "" || resp.Thinking != "", resp.EvalDuration)
+		if clientWantsStream && resp.Response != "" {
+			chunk := models.OpenAICompletionResponse{
+				ID:      id,
+				Object:  "text_completion",
+				Created: created,
+				Model:   req.Model,
+				Choices: []models.OpenAICompletionChoice{{Text: resp.Response, Index: 0}},
+			}
+			if data, err := json.Marshal(chunk); err == nil {
+				writeSSE(w, string(data))
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+		if resp.Done {
+			if resp.DoneReason != "" {
+				finishReason = resp.DoneReason
+			}
+			if resp.PromptEvalCount > 0 || resp.EvalCount > 0 {
+				usage = &models.OpenAIUsage{
+					PromptTokens:     resp.PromptEvalCount,
+					CompletionTokens: resp.EvalCount,
+					TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+				}
+			}
+			break
+		}
+	}
+
+	// If the backend failed part way through, end with an error instead of
+	// a finish_reason. A client that went away gets nothing more.
+	statusCode, errMsg := responseStatus(r.Context(), backendMeta)
+	switch {
+	case statusCode == statusClientClosedRequest:
+		discardRest(respChan)
+	case errMsg != "" && clientWantsStream:
+		writeOpenAIStreamError(w, errMsg)
+	case errMsg != "":
+		writeOpenAIError(w, statusCode, errMsg)
+	case clientWantsStream:
+		final := models.OpenAICompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []models.OpenAICompletionChoice{{Text: "", Index: 0, FinishReason: finishReason}},
+			Usage:   usage,
+		}
+		if data, err := json.Marshal(final); err == nil {
+			writeSSE(w, string(data))
+		}
+		writeSSE(w, "[DONE]")
+		if flusher != nil {
+			flusher.Flush()
+		}
+	default:
+		response := models.OpenAICompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   req.Model,
+			Choices: []models.OpenAICompletionChoice{{Text: fullResponse.String(), Index: 0, FinishReason: finishReason}},
+			Usage:   usage,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Printf("Failed to encode OpenAI completion response: %v", err)
+			return
+		}
+	}
+
+	if h.config.Current().Server.LogMessages {
+		log.Printf("=== OpenAI Completion Response Complete ===")
+		log.Printf("Full Response: %s", fullResponse.String())
+		log.Printf("===========================================")
+	}
+	if h.config.Current().Server.LogRawResponses {
+		log.Printf("=== Raw OpenAI Completion Response ===\n%s\n======================================", capture.String())
+	}
+
+	if errMsg != "" {
+		finishReason = ""
+	}
+	h.logRequest(r.Context(), startTime, genReq, clientWantsStream, fullResponse.String(), statusCode, errMsg, string(bodyBytes), capture.String(), backendMeta, finishReason, timing)
+}
+
+// completionPrompt extracts the prompt from an OpenAI completion request,
+// which may be a string or an array holding a single string.
+func completionPrompt(prompt interface{}) (string, error) {
+	switch p := prompt.(type) {
+	case string:
+		return p, nil
+	case []interface{}:
+		if len(p) == 1 {
+			if s, ok := p[0].(string); ok {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("prompt must be a string or an array with a single string")
+	case nil:
+		return "", fmt.Errorf("prompt is required")
+	default:
+		return "", fmt.Errorf("prompt must be a string or an array with a single string")
+	}
+}
+
+// completionOptions maps OpenAI sampling parameters to Ollama options.
+func completionOptions(req models.OpenAICompletionRequest) map[string]interface{} {
+	options := map[string]interface{}{}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = float64(req.MaxTokens)
+	}
+	if req.Temperature != 0 {
+		options["temperature"] = req.Temperature
+	}
+	if req.TopP != 0 {
+		options["top_p"] = req.TopP
+	}
+	if req.FrequencyPenalty != 0 {
+		options["frequency_penalty"] = req.FrequencyPenalty
+	}
+	if req.PresencePenalty != 0 {
+		options["presence_penalty"] = req.PresencePenalty
+	}
+	switch stop := req.Stop.(type) {
+	case string:
+		options["stop"] = []interface{}{stop}
+	case []interface{}:
+		options["stop"] = stop
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+func (h *OpenAICompletionsHandler) logRequest(ctx context.Context, startTime time.Time, req models.GenerateRequest, stream bool, response string, statusCode int, errMsg string, frontendReq string, frontendResp string, backendMeta *backend.BackendMetadata, finishReason string, timing generationTiming) {
+	lastMessage := req.Prompt
+	if lastMessage == "" {
+		lastMessage = "unknown"
+	}
+
+	entry := database.LogEntry{
+		Timestamp:        startTime,
+		Endpoint:         "/v1/completions",
+		Method:           "POST",
+		Model:            req.Model,
+		Prompt:           req.Prompt,
+		Response:         response,
+		StatusCode:       statusCode,
+		LatencyMs:        time.Since(startTime).Milliseconds(),
+		Stream:           stream,
+		BackendType:      h.config.Current().Backend.Type,
+		Error:            errMsg,
+		FrontendURL:      fmt.Sprintf("http://%s:%d/v1/completions", h.config.Current().Server.Host, h.config.Current().Server.Port),
+		BackendURL:       backendMeta.URL,
+		FrontendRequest:  frontendReq,
+		FrontendResponse: frontendResp,
+		BackendRequest:   backendMeta.RawRequest,
+		BackendResponse:  backendMeta.RawResponse,
+		LastMessage:      lastMessage,
+		CacheHit:         backendMeta.CacheHit,
+		Partial:          cutShort(errMsg, backendMeta),
+		BackendStatus:    backendMeta.StatusCode,
+		BackendRequestID: backendMeta.RequestID,
+		BackendRateLimit: backendMeta.RateLimit,
+		FinishReason:     finishReason,
+		ShadowKey:        backendMeta.ShadowKey,
+		RaceWinner:       backendMeta.RaceWinner,
+	}
+	entry.PromptTokens, entry.CachedTokens, entry.CompletionTokens = reportedTokens(backendMeta)
+	entry.Cost = reportedCost(backendMeta)
+	timing.apply(&entry)
+	attributeEntry(ctx, &entry)
+
+	if err := h.db.Log(entry); err != nil {
+		log.Printf("Failed to log OpenAI completion request: %v", err)
+	}
+}
+
+// logInvalidRequest persists a request that was rejected before it reached
+// the backend, so it's still visible in the request log.
+func (h *OpenAICompletionsHandler) logInvalidRequest(ctx context.Context, startTime time.Time, frontendReq string, statusCode int, errMsg string) {
+	entry := database.LogEntry{
+		Timestamp:       startTime,
+		Endpoint:        "/v1/completions",
+		Method:          "POST",
+		StatusCode:      statusCode,
+		LatencyMs:       time.Since(startTime).Milliseconds(),
+		BackendType:     h.config.Current().Backend.Type,
+		Error:           errMsg,
+		FrontendURL:     fmt.Sprintf("http://%s:%d/v1/completions", h.config.Current().Server.Host, h.config.Current().Server.Port),
+		FrontendRequest: frontendReq,
+	}
+	attributeEntry(ctx, &entry)
+
+	if err := h.db.Log(entry); err != nil {
+		log.Printf("Failed to log invalid OpenAI completion request: %v", err)
+	}
+}
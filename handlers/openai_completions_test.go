@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"llm_proxy/backend"
+	"llm_proxy/models"
+)
+
+// spyGenerateBackend records the generate request and replies in two chunks.
+type spyGenerateBackend struct {
+	spyChatBackend
+	lastGenerate models.GenerateRequest
+}
+
+func (s *spyGenerateBackend) Generate(_ context.Context, req models.GenerateRequest) (<-chan models.GenerateResponse, *backend.BackendMetadata, error) {
+	s.lastGenerate = req
+	ch := make(chan models.GenerateResponse, 2)
+	ch <- models.GenerateResponse{Model: req.Model, Response: "Once upon"}
+	ch <- models.GenerateResponse{Model: req.Model, Response: " a time", Done: true, DoneReason: "length", PromptEvalCount: 4, EvalCount: 3}
+	close(ch)
+	return ch, &backend.BackendMetadata{URL: "http://backend/v1/completions"}, nil
+}
+
+func TestOpenAICompletionsHandlerTranslatesToGenerate(t *testing.T) {
+	db := newEmbedTestDB(t)
+	spy := &spyGenerateBackend{}
+	handler := NewOpenAICompletionsHandler(spy, db, embedTestConfig())
+
+	body := postEmbed(t, handler, "/v1/completions", `{"model":"m","prompt":["Tell a story"],"max_tokens":16,"temperature":0.5,"stop":"\n"}`)
+
+	if spy.lastGenerate.Prompt != "Tell a story" {
+		t.Fatalf("prompt = %q", spy.lastGenerate.Prompt)
+	}
+	wantOptions := map[string]interface{}{"num_predict": float64(16), "temperature": 0.5, "stop": []interface{}{"\n"}}
+	if !reflect.DeepEqual(spy.lastGenerate.Options, wantOptions) {
+		t.Fatalf("options = %#v, want %#v", spy.lastGenerate.Options, wantOptions)
+	}
+
+	var resp models.OpenAICompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if resp.Object != "text_completion" || len(resp.Choices) != 1 || resp.Choices[0].Text != "Once upon a time" || resp.Choices[0].FinishReason != "length" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 7 {
+		t.Fatalf("usage = %+v, want 7 total tokens", resp.Usage)
+	}
+
+	entries, err := db.GetRecentEntries(1, 0)
+	if err != nil {
+		t.Fatalf("GetRecentEntries() error = %v", err)
+	}
+	if entries[0].Endpoint != "/v1/completions" || entries[0].Response != "Once upon a time" {
+		t.Fatalf("logged entry = %+v", entries[0])
+	}
+}
+
+func TestOpenAICompletionsHandlerStreams(t *testing.T) {
+	handler := NewOpenAICompletionsHandler(&spyGenerateBackend{}, newEmbedTestDB(t), embedTestConfig())
+
+	body := string(postEmbed(t, handler, "/v1/completions", `{"model":"m","prompt":"Tell a story","stream":true}`))
+
+	if !strings.Contains(body, `"text":"Once upon"`) || !strings.Contains(body, `"finish_reason":"length"`) || !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Fatalf("stream body = %s", body)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(`{"model":"m","prompt":["a","b"]}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("batch prompt status = %d, want 400", rec.Code)
+	}
+}
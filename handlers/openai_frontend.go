@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -40,39 +41,41 @@ func (h *OpenAIChatCompletionsHandler) ServeHTTP(w http.ResponseWriter, r *http.
 
 	startTime := time.Now()
 
-	bodyBytes, err := io.ReadAll(r.Body)
+	bodyBytes, status, err := readRequestBody(r)
 	if err != nil {
-		log.Printf("OpenAI chat request: failed to read request body: %v", err)
-		h.logInvalidRequest(startTime, "", fmt.Sprintf("failed to read request body: %v", err))
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		log.Printf("OpenAI chat request: %v", err)
+		h.logInvalidRequest(r.Context(), startTime, "", status, err.Error())
+		writeOpenAIError(w, status, err.Error())
 		return
 	}
 
 	var req models.OpenAIChatRequest
-	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+	if err := decodeRequestBody(bodyBytes, &req); err != nil {
+		errMsg := fmt.Sprintf("invalid request body: %v", err)
 		log.Printf("OpenAI chat request: invalid request body: %v\nBody: %s", err, string(bodyBytes))
-		h.logInvalidRequest(startTime, string(bodyBytes), fmt.Sprintf("invalid request body: %v", err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, errMsg)
+		writeOpenAIError(w, http.StatusBadRequest, errMsg)
 		return
 	}
 	var rawReq map[string]json.RawMessage
 	if err := json.Unmarshal(bodyBytes, &rawReq); err != nil || rawReq == nil {
+		errMsg := fmt.Sprintf("invalid request body: %v", err)
 		log.Printf("OpenAI chat request: invalid request body: %v\nBody: %s", err, string(bodyBytes))
-		h.logInvalidRequest(startTime, string(bodyBytes), fmt.Sprintf("invalid request body: %v", err))
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, errMsg)
+		writeOpenAIError(w, http.StatusBadRequest, errMsg)
 		return
 	}
 
-	if h.config.Server.LogRawRequests {
+	if h.config.Current().Server.LogRawRequests {
 		reqJSON, err := json.MarshalIndent(req, "", "  ")
 		if err == nil {
 			log.Printf("=== Raw OpenAI Chat Request ===\n%s\n================================", string(reqJSON))
 		}
 	}
 
-	applyOpenAIChatRequestSanitization(&req, rawReq, h.config)
+	applyOpenAIChatRequestSanitization(&req, rawReq, h.config.Current())
 	clientWantsStream := req.Stream
-	req.Stream = resolveStream(clientWantsStream, h.config)
+	req.Stream = resolveStream(clientWantsStream, h.config.Current())
 
 	chatReq := models.ChatRequest{
 		Model:     req.Model,
@@ -80,6 +83,9 @@ func (h *OpenAIChatCompletionsHandler) ServeHTTP(w http.ResponseWriter, r *http.
 		Stream:    req.Stream,
 		Tools:     req.Tools,
 		OpenAIRaw: rawReq,
+
+		ToolChoice:        req.ToolChoice,
+		ParallelToolCalls: req.ParallelToolCalls,
 	}
 	if req.MaxTokens > 0 {
 		chatReq.Options = map[string]interface{}{
@@ -90,10 +96,16 @@ func (h *OpenAIChatCompletionsHandler) ServeHTTP(w http.ResponseWriter, r *http.
 	originalLastMessage := lastMessageContent(chatReq.Messages)
 	originalMessages := cloneMessages(chatReq.Messages)
 
-	applyChatFeatures(&chatReq, h.config)
+	applyChatFeatures(&chatReq, tenantConfig(r.Context(), h.config.Current()))
+	if err := applyChatTokenBudget(&chatReq, h.config.Current()); err != nil {
+		log.Printf("OpenAI chat request: %v", err)
+		h.logInvalidRequest(r.Context(), startTime, string(bodyBytes), http.StatusBadRequest, err.Error())
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	syncOpenAIRawChatRequest(&chatReq)
 
-	if h.config.Server.LogMessages {
+	if h.config.Current().Server.LogMessages {
 		log.Printf("=== OpenAI Chat Request ===")
 		log.Printf("Model: %s", chatReq.Model)
 		log.Printf("Messages:")
@@ -103,45 +115,58 @@ func (h *OpenAIChatCompletionsHandler) ServeHTTP(w http.ResponseWriter, r *http.
 		log.Printf("===========================")
 	}
 
+	// Record exactly what is sent to the client for the log
+	capture := captureResponse(w)
+
 	respChan, backendMeta, err := h.backend.Chat(r.Context(), chatReq)
 	if err != nil {
 		log.Printf("Backend error: %v", err)
-		h.logRequest(startTime, chatReq.Model, clientWantsStream, originalMessages, "", http.StatusInternalServerError, err.Error(), string(bodyBytes), "", backendMeta.RawRequest, backendMeta.RawResponse, backendMeta.URL, originalLastMessage)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := backendErrorStatus(err)
+		writeOpenAIError(capture, status, err.Error())
+		h.logRequest(r.Context(), startTime, chatReq.Model, clientWantsStream, originalMessages, "", status, err.Error(), string(bodyBytes), capture.String(), backendMeta, originalLastMessage, "", generationTiming{})
 		return
 	}
 
 	if clientWantsStream {
-		h.streamResponse(w, req.Model, respChan, startTime, chatReq, string(bodyBytes), backendMeta, originalMessages, originalLastMessage)
+		includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		h.streamResponse(r.Context(), capture, req.Model, respChan, startTime, chatReq, includeUsage, string(bodyBytes), backendMeta, originalMessages, originalLastMessage)
 		return
 	}
 
-	h.writeResponse(w, req.Model, respChan, startTime, chatReq, string(bodyBytes), backendMeta, originalMessages, originalLastMessage)
+	h.writeResponse(r.Context(), capture, req.Model, respChan, startTime, chatReq, string(bodyBytes), backendMeta, originalMessages, originalLastMessage)
 }
 
 // streamResponse writes the response to the client as an SSE stream. It is
 // driven entirely by what arrives on respChan, so it works whether or not
 // the backend call itself streamed (stream_override can force the backend
-// call to be non-streaming while the client still gets a stream).
-func (h *OpenAIChatCompletionsHandler) streamResponse(w http.ResponseWriter, model string, respChan <-chan models.ChatResponse, startTime time.Time, req models.ChatRequest, frontendReq string, backendMeta *backend.BackendMetadata, originalMessages []models.Message, originalLastMessage string) {
+// call to be non-streaming while the client still gets a stream). The usage
+// chunk is only sent if the client asked for it with
+// stream_options.include_usage.
+func (h *OpenAIChatCompletionsHandler) streamResponse(ctx context.Context, w *responseCapture, model string, respChan <-chan models.ChatResponse, startTime time.Time, req models.ChatRequest, includeUsage bool, frontendReq string, backendMeta *backend.BackendMetadata, originalMessages []models.Message, originalLastMessage string) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	flusher, _ := w.(http.Flusher)
 	created := time.Now().Unix()
 	var fullResponse string
-	var frontendResp strings.Builder
 	finishReason := "stop"
 	var usage *models.OpenAIUsage
+	var timing generationTiming
 
-	for resp := range respChan {
+	keepAlive := keepAliveInterval(h.config.Current())
+	for {
+		resp, ok := nextChunk(ctx, respChan, keepAlive, sseKeepAlive(w))
+		if !ok {
+			break
+		}
 		// A non-streaming backend call (e.g. forced by stream_override)
 		// delivers the full content and Done:true in the same chunk, so
 		// content must be flushed before checking Done, not skipped by it.
+		timing.observe(hasChatOutput(resp), resp.EvalDuration)
 		content := resp.Message.Content
+		thinking := resp.Message.Thinking
 		toolCalls := normalizeOpenAIToolCalls(resp.Message.ToolCalls, true)
-		if content != "" || len(toolCalls) > 0 {
+		if content != "" || thinking != "" || len(toolCalls) > 0 {
 			fullResponse += content
 			chunk := models.OpenAIChatResponse{
 				ID:      fmt.Sprintf("chatcmpl-%d", startTime.UnixNano()),
@@ -152,9 +177,10 @@ func (h *OpenAIChatCompletionsHandler) streamResponse(w http.ResponseWriter, mod
 					{
 						Index: 0,
 						Delta: &models.Message{
-							Role:      "assistant",
-							Content:   content,
-							ToolCalls: toolCalls,
+							Role:             "assistant",
+							Content:          content,
+							ReasoningContent: thinking,
+							ToolCalls:        toolCalls,
 						},
 					},
 				},
@@ -163,10 +189,8 @@ func (h *OpenAIChatCompletionsHandler) streamResponse(w http.ResponseWriter, mod
 			if err != nil {
 				log.Printf("Failed to marshal OpenAI stream chunk: %v", err)
 			} else {
-				writeSSE(w, &frontendResp, string(data))
-				if flusher != nil {
-					flusher.Flush()
-				}
+				writeSSE(w, string(data))
+				w.Flush()
 			}
 		}
 
@@ -179,6 +203,20 @@ func (h *OpenAIChatCompletionsHandler) streamResponse(w http.ResponseWriter, mod
 		}
 	}
 
+	// If the backend failed part way through, end with an error event, which
+	// OpenAI clients raise, instead of a finish_reason and [DONE]. A client
+	// that went away gets nothing more.
+	statusCode, errMsg := responseStatus(ctx, backendMeta)
+	if errMsg != "" {
+		if statusCode == statusClientClosedRequest {
+			discardRest(respChan)
+		} else {
+			writeOpenAIStreamError(w, errMsg)
+		}
+		h.logRequest(ctx, startTime, req.Model, true, originalMessages, fullResponse, statusCode, errMsg, frontendReq, w.String(), backendMeta, originalLastMessage, "", timing)
+		return
+	}
+
 	finalChunk := models.OpenAIChatResponse{
 		ID:      fmt.Sprintf("chatcmpl-%d", startTime.UnixNano()),
 		Object:  "chat.completion.chunk",
@@ -194,9 +232,9 @@ func (h *OpenAIChatCompletionsHandler) streamResponse(w http.ResponseWriter, mod
 	}
 	data, err := json.Marshal(finalChunk)
 	if err == nil {
-		writeSSE(w, &frontendResp, string(data))
+		writeSSE(w, string(data))
 	}
-	if usage != nil {
+	if includeUsage && usage != nil {
 		usageChunk := models.OpenAIChatResponse{
 			ID:      fmt.Sprintf("chatcmpl-%d", startTime.UnixNano()),
 			Object:  "chat.completion.chunk",
@@ -207,40 +245,44 @@ func (h *OpenAIChatCompletionsHandler) streamResponse(w http.ResponseWriter, mod
 		}
 		data, err := json.Marshal(usageChunk)
 		if err == nil {
-			writeSSE(w, &frontendResp, string(data))
+			writeSSE(w, string(data))
 		}
 	}
-	writeSSE(w, &frontendResp, "[DONE]")
-	if flusher != nil {
-		flusher.Flush()
-	}
+	writeSSE(w, "[DONE]")
+	w.Flush()
 
-	if h.config.Server.LogMessages {
+	if h.config.Current().Server.LogMessages {
 		log.Printf("=== OpenAI Chat Response Complete ===")
 		log.Printf("Full Response: %s", fullResponse)
 		log.Printf("=====================================")
 	}
-	if h.config.Server.LogRawResponses {
-		log.Printf("=== Raw OpenAI Chat Response ===\n%s\n================================", frontendResp.String())
+	if h.config.Current().Server.LogRawResponses {
+		log.Printf("=== Raw OpenAI Chat Response ===\n%s\n================================", w.String())
 	}
 
-	h.logRequest(startTime, req.Model, true, originalMessages, fullResponse, http.StatusOK, "", frontendReq, strings.TrimRight(frontendResp.String(), "\n"), backendMeta.RawRequest, backendMeta.RawResponse, backendMeta.URL, originalLastMessage)
+	h.logRequest(ctx, startTime, req.Model, true, originalMessages, fullResponse, http.StatusOK, "", frontendReq, w.String(), backendMeta, originalLastMessage, finishReason, timing)
 }
 
 // writeResponse aggregates whatever arrives on respChan into a single JSON
 // response. It works whether or not the backend call itself streamed
 // (stream_override can force the backend call to stream while the client
 // still gets one combined response).
-func (h *OpenAIChatCompletionsHandler) writeResponse(w http.ResponseWriter, model string, respChan <-chan models.ChatResponse, startTime time.Time, req models.ChatRequest, frontendReq string, backendMeta *backend.BackendMetadata, originalMessages []models.Message, originalLastMessage string) {
+func (h *OpenAIChatCompletionsHandler) writeResponse(ctx context.Context, w *responseCapture, model string, respChan <-chan models.ChatResponse, startTime time.Time, req models.ChatRequest, frontendReq string, backendMeta *backend.BackendMetadata, originalMessages []models.Message, originalLastMessage string) {
 	var fullResponse string
-	var toolCalls []interface{}
+	var timing generationTiming
+	var thinking strings.Builder
+	var combined models.Message
 	finishReason := "stop"
 	var usage *models.OpenAIUsage
-	for resp := range respChan {
-		fullResponse += resp.Message.Content
-		if len(resp.Message.ToolCalls) > 0 {
-			toolCalls = normalizeOpenAIToolCalls(resp.Message.ToolCalls, false)
+	for {
+		resp, ok := nextChunk(ctx, respChan, 0, nil)
+		if !ok {
+			break
 		}
+		fullResponse += resp.Message.Content
+		timing.observe(hasChatOutput(resp), resp.EvalDuration)
+		thinking.WriteString(resp.Message.Thinking)
+		combined.AddToolCalls(resp.Message.ToolCalls)
 		if resp.DoneReason != "" {
 			finishReason = resp.DoneReason
 		}
@@ -249,6 +291,16 @@ func (h *OpenAIChatCompletionsHandler) writeResponse(w http.ResponseWriter, mode
 		}
 	}
 
+	if statusCode, errMsg := responseStatus(ctx, backendMeta); errMsg != "" {
+		if statusCode == statusClientClosedRequest {
+			discardRest(respChan)
+		} else {
+			writeOpenAIError(w, statusCode, errMsg)
+		}
+		h.logRequest(ctx, startTime, req.Model, false, originalMessages, fullResponse, statusCode, errMsg, frontendReq, w.String(), backendMeta, originalLastMessage, "", timing)
+		return
+	}
+
 	response := models.OpenAIChatResponse{
 		ID:      fmt.Sprintf("chatcmpl-%d", startTime.UnixNano()),
 		Object:  "chat.completion",
@@ -258,9 +310,10 @@ func (h *OpenAIChatCompletionsHandler) writeResponse(w http.ResponseWriter, mode
 			{
 				Index: 0,
 				Message: &models.Message{
-					Role:      "assistant",
-					Content:   fullResponse,
-					ToolCalls: toolCalls,
+					Role:             "assistant",
+					Content:          fullResponse,
+					ReasoningContent: thinking.String(),
+					ToolCalls:        normalizeOpenAIToolCalls(combined.ToolCalls, false),
 				},
 				FinishReason: finishReason,
 			},
@@ -269,28 +322,49 @@ func (h *OpenAIChatCompletionsHandler) writeResponse(w http.ResponseWriter, mode
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	var frontendResp strings.Builder
-	if err := json.NewEncoder(io.MultiWriter(w, &frontendResp)).Encode(response); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("Failed to encode OpenAI response: %v", err)
 		return
 	}
 
-	if h.config.Server.LogMessages {
+	if h.config.Current().Server.LogMessages {
 		log.Printf("=== OpenAI Chat Response Complete ===")
 		log.Printf("Full Response: %s", fullResponse)
 		log.Printf("=====================================")
 	}
-	if h.config.Server.LogRawResponses {
-		log.Printf("=== Raw OpenAI Chat Response ===\n%s\n================================", frontendResp.String())
+	if h.config.Current().Server.LogRawResponses {
+		log.Printf("=== Raw OpenAI Chat Response ===\n%s\n================================", w.String())
 	}
 
-	h.logRequest(startTime, req.Model, false, originalMessages, fullResponse, http.StatusOK, "", frontendReq, strings.TrimRight(frontendResp.String(), "\n"), backendMeta.RawRequest, backendMeta.RawResponse, backendMeta.URL, originalLastMessage)
+	h.logRequest(ctx, startTime, req.Model, false, originalMessages, fullResponse, http.StatusOK, "", frontendReq, w.String(), backendMeta, originalLastMessage, finishReason, timing)
+}
+
+func writeSSE(w io.Writer, data string) {
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// writeOpenAIStreamError ends an SSE stream with an OpenAI error event
+func writeOpenAIStreamError(w http.ResponseWriter, message string) {
+	data, err := json.Marshal(models.OpenAIErrorResponse{Error: models.OpenAIError{Message: message, Type: "server_error"}})
+	if err != nil {
+		return
+	}
+	writeSSE(w, string(data))
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
-func writeSSE(w io.Writer, capture *strings.Builder, data string) {
-	line := fmt.Sprintf("data: %s\n\n", data)
-	fmt.Fprint(w, line)
-	capture.WriteString(line)
+// writeOpenAIError sends an OpenAI error response with the given status
+func writeOpenAIError(w http.ResponseWriter, statusCode int, message string) {
+	data, err := json.Marshal(models.OpenAIErrorResponse{Error: models.OpenAIError{Message: message, Type: "server_error"}})
+	if err != nil {
+		http.Error(w, message, statusCode)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(append(data, '\n'))
 }
 
 func syncOpenAIRawChatRequest(req *models.ChatRequest) {
@@ -316,6 +390,12 @@ func syncOpenAIRawChatRequest(req *models.ChatRequest) {
 	} else {
 		delete(req.OpenAIRaw, "tools")
 	}
+	if req.ToolChoice == nil {
+		delete(req.OpenAIRaw, "tool_choice")
+	}
+	if req.ParallelToolCalls == nil {
+		delete(req.OpenAIRaw, "parallel_tool_calls")
+	}
 	if req.Options != nil {
 		if maxTokens, ok := req.Options["num_predict"].(float64); ok {
 			setRawJSON(req.OpenAIRaw, "max_tokens", int(maxTokens))
@@ -389,7 +469,7 @@ func normalizeOpenAIToolCalls(toolCalls []interface{}, includeIndex bool) []inte
 	return normalized
 }
 
-func (h *OpenAIChatCompletionsHandler) logRequest(startTime time.Time, model string, stream bool, originalMessages []models.Message, response string, statusCode int, errMsg string, frontendReq string, frontendResp string, backendReq string, backendResp string, backendURL string, originalLastMessage string) {
+func (h *OpenAIChatCompletionsHandler) logRequest(ctx context.Context, startTime time.Time, model string, stream bool, originalMessages []models.Message, response string, statusCode int, errMsg string, frontendReq string, frontendResp string, backendMeta *backend.BackendMetadata, originalLastMessage string, finishReason string, timing generationTiming) {
 	var prompt strings.Builder
 	for _, msg := range originalMessages {
 		prompt.WriteString(msg.Role)
@@ -416,19 +496,32 @@ func (h *OpenAIChatCompletionsHandler) logRequest(startTime time.Time, model str
 		StatusCode:  statusCode,
 		LatencyMs:   time.Since(startTime).Milliseconds(),
 		Stream:      stream,
-		BackendType: h.config.Backend.Type,
+		BackendType: h.config.Current().Backend.Type,
 		Error:       errMsg,
 		FrontendURL: fmt.Sprintf("http://%s:%d/v1/chat/completions",
-			h.config.Server.Host,
-			h.config.Server.Port,
+			h.config.Current().Server.Host,
+			h.config.Current().Server.Port,
 		),
-		BackendURL:       backendURL,
+		BackendURL:       backendMeta.URL,
 		FrontendRequest:  frontendReq,
 		FrontendResponse: frontendResp,
-		BackendRequest:   backendReq,
-		BackendResponse:  backendResp,
+		BackendRequest:   backendMeta.RawRequest,
+		BackendResponse:  backendMeta.RawResponse,
 		LastMessage:      lastMessage,
-	}
+		CacheHit:         backendMeta.CacheHit,
+		Partial:          cutShort(errMsg, backendMeta),
+		TrimmedMessages:  backendMeta.TrimmedMessages,
+		BackendStatus:    backendMeta.StatusCode,
+		BackendRequestID: backendMeta.RequestID,
+		BackendRateLimit: backendMeta.RateLimit,
+		FinishReason:     finishReason,
+		ShadowKey:        backendMeta.ShadowKey,
+		RaceWinner:       backendMeta.RaceWinner,
+	}
+	entry.PromptTokens, entry.CachedTokens, entry.CompletionTokens = reportedTokens(backendMeta)
+	entry.Cost = reportedCost(backendMeta)
+	timing.apply(&entry)
+	attributeEntry(ctx, &entry)
 
 	if err := h.db.Log(entry); err != nil {
 		log.Printf("Failed to log OpenAI request: %v", err)
@@ -438,21 +531,22 @@ func (h *OpenAIChatCompletionsHandler) logRequest(startTime time.Time, model str
 // logInvalidRequest persists a request that was rejected before it could be parsed
 // into a ChatRequest (unreadable body or malformed JSON), so it's still visible in
 // the request log instead of vanishing silently.
-func (h *OpenAIChatCompletionsHandler) logInvalidRequest(startTime time.Time, frontendReq string, errMsg string) {
+func (h *OpenAIChatCompletionsHandler) logInvalidRequest(ctx context.Context, startTime time.Time, frontendReq string, statusCode int, errMsg string) {
 	entry := database.LogEntry{
 		Timestamp:   startTime,
 		Endpoint:    "/v1/chat/completions",
 		Method:      "POST",
-		StatusCode:  http.StatusBadRequest,
+		StatusCode:  statusCode,
 		LatencyMs:   time.Since(startTime).Milliseconds(),
-		BackendType: h.config.Backend.Type,
+		BackendType: h.config.Current().Backend.Type,
 		Error:       errMsg,
 		FrontendURL: fmt.Sprintf("http://%s:%d/v1/chat/completions",
-			h.config.Server.Host,
-			h.config.Server.Port,
+			h.config.Current().Server.Host,
+			h.config.Current().Server.Port,
 		),
 		FrontendRequest: frontendReq,
 	}
+	attributeEntry(ctx, &entry)
 
 	if err := h.db.Log(entry); err != nil {
 		log.Printf("Failed to log invalid OpenAI request: %v", err)
@@ -60,6 +60,10 @@ func (fakeChatBackend) ShowModel(context.Context, string) (models.ShowResponse,
 	return models.ShowResponse{}, nil
 }
 
+func (fakeChatBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 type recordingChatBackend struct {
 	lastReq models.ChatRequest
 }
@@ -90,6 +94,10 @@ func (b *recordingChatBackend) ShowModel(context.Context, string) (models.ShowRe
 	return models.ShowResponse{}, nil
 }
 
+func (b *recordingChatBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 type toolCallChatBackend struct {
 	stream bool
 }
@@ -140,6 +148,10 @@ func (b toolCallChatBackend) ShowModel(context.Context, string) (models.ShowResp
 	return models.ShowResponse{}, nil
 }
 
+func (b toolCallChatBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 type usageChatBackend struct{}
 
 func (usageChatBackend) Generate(context.Context, models.GenerateRequest) (<-chan models.GenerateResponse, *backend.BackendMetadata, error) {
@@ -181,6 +193,10 @@ func (usageChatBackend) ShowModel(context.Context, string) (models.ShowResponse,
 	return models.ShowResponse{}, nil
 }
 
+func (usageChatBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 func TestOpenAIChatCompletionsHandlerPreservesMultimodalContentInBackendRequest(t *testing.T) {
 	db, err := database.New(filepath.Join(t.TempDir(), "llm_proxy.db"))
 	if err != nil {
@@ -378,6 +394,30 @@ func TestOpenAIChatCompletionsHandlerStreamingOmitsEmptyUsage(t *testing.T) {
 	}
 }
 
+func TestOpenAIChatCompletionsHandlerStreamingOmitsUnrequestedUsage(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "llm_proxy.db"))
+	if err != nil {
+		t.Fatalf("database.New() error = %v", err)
+	}
+	defer db.Close()
+
+	cfg := &config.Config{}
+	handler := NewOpenAIChatCompletionsHandler(usageChatBackend{}, db, cfg)
+
+	body := `{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if bodyText := rec.Body.String(); strings.Contains(bodyText, `"usage"`) {
+		t.Fatalf("stream response includes usage the client didn't ask for: %s", bodyText)
+	}
+}
+
 func TestOpenAIChatCompletionsHandlerStreamingIncludesUsageWhenPresent(t *testing.T) {
 	db, err := database.New(filepath.Join(t.TempDir(), "llm_proxy.db"))
 	if err != nil {
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// PromptCacheStatsHandler reports how much of each model's prompts the
+// backend served from its prompt cache, from the cached token counts
+// recorded in the request log
+type PromptCacheStatsHandler struct {
+	db               *database.DB
+	forcePromptCache bool
+}
+
+// PromptCacheModelStats is the prompt cache effectiveness for one model
+type PromptCacheModelStats struct {
+	Model        string  `json:"model"`
+	Requests     int64   `json:"requests"`
+	PromptTokens int64   `json:"prompt_tokens"`
+	CachedTokens int64   `json:"cached_tokens"`
+	HitRate      float64 `json:"hit_rate"`
+}
+
+// PromptCacheStats is the /api/prompt_cache response
+type PromptCacheStats struct {
+	ForcePromptCache bool                    `json:"force_prompt_cache"`
+	PromptTokens     int64                   `json:"prompt_tokens"`
+	CachedTokens     int64                   `json:"cached_tokens"`
+	HitRate          float64                 `json:"hit_rate"`
+	Models           []PromptCacheModelStats `json:"models"`
+}
+
+// NewPromptCacheStatsHandler creates a prompt cache statistics handler
+func NewPromptCacheStatsHandler(db *database.DB, forcePromptCache bool) *PromptCacheStatsHandler {
+	return &PromptCacheStatsHandler{db: db, forcePromptCache: forcePromptCache}
+}
+
+// ServeHTTP serves the statistics as JSON
+func (h *PromptCacheStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := h.db.GetPromptCacheStats()
+	if err != nil {
+		log.Printf("Failed to get prompt cache stats: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	stats := PromptCacheStats{ForcePromptCache: h.forcePromptCache, Models: []PromptCacheModelStats{}}
+	for _, row := range rows {
+		stats.Models = append(stats.Models, PromptCacheModelStats{
+			Model:        row.Model,
+			Requests:     row.Requests,
+			PromptTokens: row.PromptTokens,
+			CachedTokens: row.CachedTokens,
+			HitRate:      cacheHitRate(row.CachedTokens, row.PromptTokens),
+		})
+		stats.PromptTokens += row.PromptTokens
+		stats.CachedTokens += row.CachedTokens
+	}
+	stats.HitRate = cacheHitRate(stats.CachedTokens, stats.PromptTokens)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Printf("Failed to encode prompt cache stats: %v", err)
+	}
+}
+
+func cacheHitRate(cached, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(cached) / float64(total)
+}
+
+// reportedTokens returns the prompt, cached prompt and completion token
+// counts the backend reported, or zeros if it reported none
+func reportedTokens(meta *backend.BackendMetadata) (prompt int, cached int, completion int) {
+	if meta == nil || meta.Usage == nil {
+		return 0, 0, 0
+	}
+	return meta.Usage.PromptTokens, meta.Usage.CachedTokens(), meta.Usage.CompletionTokens
+}
+
+// reportedCost returns the cost of the request the backend reported, or 0
+// if it reported none, in which case the price table is used
+func reportedCost(meta *backend.BackendMetadata) float64 {
+	if meta == nil || meta.Usage == nil {
+		return 0
+	}
+	return meta.Usage.Cost
+}
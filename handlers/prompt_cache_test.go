@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"llm_proxy/database"
+)
+
+func TestPromptCacheStatsHandler(t *testing.T) {
+	db := newEmbedTestDB(t)
+	for _, entry := range []database.LogEntry{
+		{Model: "a", PromptTokens: 100, CachedTokens: 75},
+		{Model: "b", PromptTokens: 100, CachedTokens: 25},
+	} {
+		entry.Timestamp = time.Now()
+		entry.Endpoint = "/v1/chat/completions"
+		entry.Method = "POST"
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewPromptCacheStatsHandler(db, true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/prompt_cache", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var stats PromptCacheStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !stats.ForcePromptCache || stats.HitRate != 0.5 || len(stats.Models) != 2 || stats.Models[0].HitRate != 0.75 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
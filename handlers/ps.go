@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/config"
+	"llm_proxy/database"
+	"llm_proxy/models"
+)
+
+// psKeepAlive is how long a model counts as loaded after its last request
+// when /api/ps is synthesized. It matches Ollama's default keep_alive.
+const psKeepAlive = 5 * time.Minute
+
+// PsHandler handles /api/ps requests. With an Ollama backend the backend's
+// own list of running models is passed through; other backends have no such
+// endpoint, so the list is synthesized from models that served a request
+// within the keep-alive window.
+type PsHandler struct {
+	backend backend.Backend
+	db      *database.DB
+	config  *config.Config
+	client  *http.Client
+}
+
+// NewPsHandler creates a new running-models handler
+func NewPsHandler(backend backend.Backend, db *database.DB, config *config.Config) *PsHandler {
+	return &PsHandler{
+		backend: backend,
+		db:      db,
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *PsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.config.Current().Backend.Type == "ollama" {
+		h.proxy(w, r)
+		return
+	}
+
+	resp, err := h.synthesize(r)
+	if err != nil {
+		log.Printf("Failed to build running models: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// proxy passes the Ollama backend's /api/ps response through unchanged
+func (h *PsHandler) proxy(w http.ResponseWriter, r *http.Request) {
+	httpReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, strings.TrimRight(h.config.Current().Backend.Endpoint, "/")+"/api/ps", nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for name, value := range h.config.Current().Backend.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		log.Printf("Failed to fetch running models: %v", err)
+		http.Error(w, "request failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("Failed to copy running models response: %v", err)
+	}
+}
+
+// synthesize lists models used within the keep-alive window, filling in
+// size and details from the backend's model list where available
+func (h *PsHandler) synthesize(r *http.Request) (models.PsResponse, error) {
+	resp := models.PsResponse{Models: []models.RunningModel{}}
+
+	activity, err := h.db.GetRecentModelActivity(time.Now().Add(-psKeepAlive))
+	if err != nil {
+		return resp, err
+	}
+	if len(activity) == 0 {
+		return resp, nil
+	}
+
+	known := make(map[string]models.ModelInfo)
+	if list, err := h.backend.ListModels(r.Context()); err == nil {
+		for _, model := range list.Models {
+			known[model.Name] = model
+		}
+	}
+
+	for _, item := range activity {
+		running := models.RunningModel{
+			Name:      item.Model,
+			Model:     item.Model,
+			ExpiresAt: item.LastUsed.Add(psKeepAlive),
+		}
+		if info, ok := known[item.Model]; ok {
+			running.Size = info.Size
+			running.SizeVRAM = info.Size
+			running.Digest = info.Digest
+			running.Details = info.Details
+		}
+		resp.Models = append(resp.Models, running)
+	}
+	return resp, nil
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"llm_proxy/database"
+	"llm_proxy/models"
+)
+
+type psModelsBackend struct {
+	spyChatBackend
+}
+
+func (*psModelsBackend) ListModels(context.Context) (models.ModelsResponse, error) {
+	return models.ModelsResponse{Models: []models.ModelInfo{
+		{Name: "qwen3", Model: "qwen3", Size: 4096, Details: models.ModelDetails{Family: "qwen3"}},
+	}}, nil
+}
+
+func TestPsHandlerSynthesizesFromRecentActivity(t *testing.T) {
+	db := newEmbedTestDB(t)
+	now := time.Now()
+	for _, entry := range []database.LogEntry{
+		{Timestamp: now.Add(-time.Minute), Endpoint: "/api/chat", Method: "POST", Model: "qwen3", StatusCode: 200},
+		{Timestamp: now.Add(-2 * time.Minute), Endpoint: "/api/chat", Method: "POST", Model: "qwen3", StatusCode: 200},
+		{Timestamp: now.Add(-30 * time.Second), Endpoint: "/api/chat", Method: "POST", Model: "broken", StatusCode: 500},
+		{Timestamp: now.Add(-time.Hour), Endpoint: "/api/chat", Method: "POST", Model: "stale", StatusCode: 200},
+	} {
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	cfg := embedTestConfig()
+	cfg.Backend.Type = "openai"
+	rec := httptest.NewRecorder()
+	NewPsHandler(&psModelsBackend{}, db, cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ps", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var resp models.PsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(resp.Models) != 1 {
+		t.Fatalf("models = %+v, want only qwen3", resp.Models)
+	}
+	got := resp.Models[0]
+	if got.Name != "qwen3" || got.Size != 4096 || got.Details.Family != "qwen3" {
+		t.Fatalf("model = %+v", got)
+	}
+	if wantExpiry := now.Add(-time.Minute).Add(psKeepAlive); got.ExpiresAt.Sub(wantExpiry).Abs() > time.Second {
+		t.Fatalf("ExpiresAt = %v, want %v", got.ExpiresAt, wantExpiry)
+	}
+}
+
+func TestPsHandlerProxiesOllama(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/ps" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[{"name":"llama3:8b","size_vram":5000}]}`))
+	}))
+	defer ollama.Close()
+
+	cfg := embedTestConfig()
+	cfg.Backend.Endpoint = ollama.URL
+	rec := httptest.NewRecorder()
+	NewPsHandler(&psModelsBackend{}, newEmbedTestDB(t), cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ps", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"models":[{"name":"llama3:8b","size_vram":5000}]}` {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// readRequestBody reads a request body. If it can't, it returns the status
+// to reply with: 413 Request Entity Too Large if the body is over
+// server.max_request_body_size, otherwise 400.
+func readRequestBody(r *http.Request) ([]byte, int, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than the %d MiB limit", tooLarge.Limit>>20)
+		}
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return body, http.StatusOK, nil
+}
+
+// decodeRequestBody parses a JSON object request body into v. Errors say
+// what is wrong and where, e.g. `"messages.0.content" must be a string, not
+// a number (line 3, column 20)`.
+func decodeRequestBody(body []byte, v any) error {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return errors.New("request body is empty")
+	}
+	if trimmed[0] != '{' {
+		return errors.New("request body must be a JSON object")
+	}
+
+	err := json.Unmarshal(body, v)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// Offset is just past the offending byte, unless the input ended
+		offset := syntaxErr.Offset
+		if offset < int64(len(body)) {
+			offset--
+		}
+		line, column := jsonPosition(body, offset)
+		return fmt.Errorf("invalid JSON at line %d, column %d: %v", line, column, syntaxErr)
+	case errors.As(err, &typeErr):
+		line, column := jsonPosition(body, typeErr.Offset)
+		if typeErr.Field == "" {
+			return fmt.Errorf("request body must be %s, not %s (line %d, column %d)", jsonKind(typeErr.Type), jsonArticle(typeErr.Value), line, column)
+		}
+		return fmt.Errorf("%q must be %s, not %s (line %d, column %d)", typeErr.Field, jsonKind(typeErr.Type), jsonArticle(typeErr.Value), line, column)
+	}
+	return err
+}
+
+// jsonPosition converts a byte offset into body to a 1-based line and column
+func jsonPosition(body []byte, offset int64) (line, column int) {
+	offset = min(max(offset, 0), int64(len(body)))
+	before := body[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// jsonKind describes the JSON value a Go type decodes from
+func jsonKind(t reflect.Type) string {
+	if t == nil {
+		return "a value"
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a " + t.String()
+}
+
+// jsonArticle prefixes the JSON value kind reported by encoding/json
+// ("string", "number 1.5", "array", ...) with an article
+func jsonArticle(value string) string {
+	switch {
+	case value == "":
+		return "a value"
+	case value == "null":
+		return value
+	case value[0] == 'a' || value[0] == 'o':
+		return "an " + value
+	}
+	return "a " + value
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestDecodeRequestBodyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "  ", "request body is empty"},
+		{"not an object", `["hello"]`, "request body must be a JSON object"},
+		{"syntax", "{\n  \"model\": \"m\",\n  \"stream\": tru\n}", "invalid JSON at line 3, column 16: invalid character"},
+		{"truncated", `{"model": "m"`, "invalid JSON at line 1, column 14: unexpected end of JSON input"},
+		{"wrong type", "{\n  \"model\": \"m\",\n  \"stream\": \"yes\"\n}", `"stream" must be a boolean, not a string (line 3, column 18)`},
+		{"object field", `{"model": "m", "options": "fast"}`, `"options" must be an object, not a string (line 1, column 33)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req models.ChatRequest
+			err := decodeRequestBody([]byte(tt.body), &req)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("decodeRequestBody() error = %v, want it to start with %q", err, tt.want)
+			}
+		})
+	}
+
+	var req models.ChatRequest
+	if err := decodeRequestBody([]byte(`{"model": "m", "stream": true}`), &req); err != nil || req.Model != "m" || !req.Stream {
+		t.Fatalf("decodeRequestBody() = %+v, error %v", req, err)
+	}
+}
+
+func TestChatRejectsOversizedBody(t *testing.T) {
+	spy, db, cfg := newStreamOverrideTest(t)
+	body := marshalStreamOverrideBody(t, models.ChatRequest{
+		Model:    "test-model",
+		Messages: []models.Message{{Role: "user", Content: strings.Repeat("x", 2<<20)}},
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body))
+	req.Body = http.MaxBytesReader(rec, req.Body, 1<<20)
+	NewChatHandler(spy, db, cfg).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+	if want := `{"error":"request body is larger than the 1 MiB limit"}`; strings.TrimSpace(rec.Body.String()) != want {
+		t.Fatalf("body = %s, want %s", rec.Body.String(), want)
+	}
+	if spy.lastChatReq.Model != "" {
+		t.Fatal("backend received the request, want it rejected")
+	}
+
+	entries, err := db.GetRecentEntries(1, 0)
+	if err != nil || len(entries) != 1 || entries[0].StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("GetRecentEntries() = %+v, error %v; want one entry logged with 413", entries, err)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"llm_proxy/backend"
+)
+
+// loggedRequestHeaders are the client request headers recorded with each
+// request, besides custom X- headers
+var loggedRequestHeaders = []string{"User-Agent", "Content-Type", "Accept", "Accept-Encoding"}
+
+// keyRequestHeaders carry the API key a client presents. They are recorded
+// with the key's name in place of the key.
+var keyRequestHeaders = []string{"Authorization", "X-Api-Key"}
+
+// secretHeaderWords mark X- headers whose values aren't recorded
+var secretHeaderWords = []string{"key", "token", "secret", "auth", "password", "signature", "cookie"}
+
+// frontendHeaders returns the headers of the client's request worth seeing
+// when debugging a client library, as a JSON object ("" = none). API keys
+// are replaced by the name of the key ("[key: user alice]"), or
+// "[redacted]" if the key has no name, as are other secret-looking headers.
+func frontendHeaders(info backend.RequestInfo) string {
+	headers := map[string]string{}
+	for _, name := range loggedRequestHeaders {
+		if values := info.Header.Values(name); len(values) > 0 {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+	for name, values := range info.Header {
+		name = http.CanonicalHeaderKey(name)
+		if !strings.HasPrefix(name, "X-") {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+		lower := strings.ToLower(name)
+		for _, word := range secretHeaderWords {
+			if strings.Contains(lower, word) {
+				headers[name] = "[redacted]"
+				break
+			}
+		}
+	}
+	for _, name := range keyRequestHeaders {
+		if info.Header.Get(name) == "" {
+			continue
+		}
+		headers[name] = "[redacted]"
+		if info.KeyName != "" {
+			headers[name] = "[key: " + info.KeyName + "]"
+		}
+	}
+	if len(headers) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// headerLines formats logged frontend headers as "Name: value" lines, sorted
+// by name
+func headerLines(headersJSON string) string {
+	if headersJSON == "" {
+		return ""
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(headersJSON), &headers); err != nil {
+		return headersJSON
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ": " + headers[name] + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
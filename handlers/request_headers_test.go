@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"llm_proxy/backend"
+)
+
+func TestFrontendHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("User-Agent", "openai-python/1.40.0")
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", "Bearer sk-secret")
+	header.Set("X-Stainless-Lang", "python")
+	header.Add("X-Trace", "a")
+	header.Add("X-Trace", "b")
+	header.Set("X-Session-Token", "hunter2")
+	header.Set("Cookie", "session=abc")
+
+	got := map[string]string{}
+	if err := json.Unmarshal([]byte(frontendHeaders(backend.RequestInfo{Header: header, KeyName: "user alice"})), &got); err != nil {
+		t.Fatalf("frontendHeaders() is not a JSON object: %v", err)
+	}
+	want := map[string]string{
+		"User-Agent":       "openai-python/1.40.0",
+		"Content-Type":     "application/json",
+		"Authorization":    "[key: user alice]",
+		"X-Stainless-Lang": "python",
+		"X-Trace":          "a, b",
+		"X-Session-Token":  "[redacted]",
+	}
+	if len(got) != len(want) {
+		t.Errorf("frontendHeaders() = %v, want %v", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("frontendHeaders()[%q] = %q, want %q", name, got[name], value)
+		}
+	}
+
+	header = http.Header{}
+	header.Set("X-Api-Key", "sk-unknown")
+	if got, want := frontendHeaders(backend.RequestInfo{Header: header}), `{"X-Api-Key":"[redacted]"}`; got != want {
+		t.Errorf("frontendHeaders() with an unnamed key = %s, want %s", got, want)
+	}
+	if got := frontendHeaders(backend.RequestInfo{}); got != "" {
+		t.Errorf("frontendHeaders() with no headers = %q, want \"\"", got)
+	}
+}
+
+func TestHeaderLines(t *testing.T) {
+	got := headerLines(`{"User-Agent":"curl/8.0","Content-Type":"application/json"}`)
+	want := "Content-Type: application/json\nUser-Agent: curl/8.0"
+	if got != want {
+		t.Errorf("headerLines() = %q, want %q", got, want)
+	}
+}
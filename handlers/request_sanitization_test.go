@@ -61,6 +61,10 @@ func (s *sanitizationSpyBackend) ShowModel(context.Context, string) (models.Show
 	return models.ShowResponse{}, nil
 }
 
+func (s *sanitizationSpyBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 func TestRequestSanitizationDropsExcessiveMaxTokens(t *testing.T) {
 	tests := []struct {
 		name     string
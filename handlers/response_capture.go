@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// responseCapture tees the response body written to the client, so the log
+// records the exact bytes sent (field order, keep-alives, SSE framing and
+// all) rather than a re-encoding of the chunks
+type responseCapture struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+// captureResponse wraps w to record what is written to it
+func captureResponse(w http.ResponseWriter) *responseCapture {
+	return &responseCapture{ResponseWriter: w}
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.body.Write(b[:n])
+	return n, err
+}
+
+// Flush implements http.Flusher so streams through the capture still reach
+// the client chunk by chunk
+func (c *responseCapture) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *responseCapture) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// String returns everything written so far
+func (c *responseCapture) String() string {
+	return c.body.String()
+}
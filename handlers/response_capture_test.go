@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestFrontendResponseIsWhatWasSent(t *testing.T) {
+	spy, db, cfg := newStreamOverrideTest(t)
+
+	for _, endpoint := range []string{"ollama_chat", "ollama_generate", "openai_chat"} {
+		for _, stream := range []bool{true, false} {
+			rec := serveStreamOverrideRequest(t, endpoint, spy, db, cfg, stream)
+
+			entries, err := db.GetRecentEntries(1, 0)
+			if err != nil {
+				t.Fatalf("GetRecentEntries() error = %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("%s (stream %v): len(entries) = %d, want 1", endpoint, stream, len(entries))
+			}
+			if got, want := entries[0].FrontendResponse, rec.Body.String(); got != want {
+				t.Errorf("%s (stream %v): FrontendResponse = %q, want the bytes sent %q", endpoint, stream, got, want)
+			}
+		}
+	}
+}
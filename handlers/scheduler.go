@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"llm_proxy/backend"
+)
+
+// SchedulerStatsHandler serves /api/scheduler with the backend scheduler's
+// queue depth and per-key wait-time metrics.
+type SchedulerStatsHandler struct {
+	scheduler *backend.Scheduler
+}
+
+// NewSchedulerStatsHandler creates a new scheduler stats handler
+func NewSchedulerStatsHandler(scheduler *backend.Scheduler) *SchedulerStatsHandler {
+	return &SchedulerStatsHandler{scheduler: scheduler}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *SchedulerStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.scheduler.Stats()); err != nil {
+		log.Printf("Failed to encode scheduler stats: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// NewShadowLogger returns a function that logs the results of requests
+// copied to the shadow backend, of type backendType. Each is logged as an
+// entry of its own, marked as a shadow and with the same shadow key as the
+// entry of the client request it copies.
+func NewShadowLogger(db *database.DB, backendType string) func(backend.ShadowResult) {
+	return func(result backend.ShadowResult) {
+		meta := result.Metadata
+		endpoint := result.Path
+		if endpoint == "" {
+			endpoint = "/api/" + result.Endpoint
+		}
+		statusCode := http.StatusOK
+		var errMsg string
+		if result.Err != nil {
+			statusCode = backendErrorStatus(result.Err)
+			errMsg = result.Err.Error()
+		}
+		lastMessage := result.LastMessage
+		if lastMessage == "" {
+			lastMessage = "unknown"
+		}
+
+		entry := database.LogEntry{
+			Timestamp:        result.Start,
+			Endpoint:         endpoint,
+			Method:           "POST",
+			Model:            result.Model,
+			Prompt:           result.Prompt,
+			Response:         result.Response,
+			StatusCode:       statusCode,
+			LatencyMs:        result.Duration.Milliseconds(),
+			Stream:           result.Stream,
+			BackendType:      backendType,
+			Error:            errMsg,
+			BackendURL:       meta.URL,
+			BackendRequest:   meta.RawRequest,
+			BackendResponse:  meta.RawResponse,
+			LastMessage:      lastMessage,
+			Partial:          meta.StreamErr != nil,
+			BackendStatus:    meta.StatusCode,
+			BackendRequestID: meta.RequestID,
+			BackendRateLimit: meta.RateLimit,
+			FinishReason:     result.DoneReason,
+			ShadowKey:        result.Key,
+			Shadow:           true,
+		}
+		entry.PromptTokens, entry.CachedTokens, entry.CompletionTokens = reportedTokens(meta)
+		entry.Cost = reportedCost(meta)
+
+		if err := db.Log(entry); err != nil {
+			log.Printf("Failed to log shadow request: %v", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+function toggleCollapse(id) {
+    const header = document.getElementById('header-' + id);
+    const content = document.getElementById('content-' + id);
+    header.classList.toggle('collapsed');
+    content.classList.toggle('hidden');
+}
+
+function toggleRawResponse() {
+    const rendered = document.getElementById('response-rendered');
+    const raw = document.getElementById('response-raw');
+    const showRaw = raw.classList.contains('hidden');
+    raw.classList.toggle('hidden', !showRaw);
+    rendered.classList.toggle('hidden', showRaw);
+    document.getElementById('response-toggle').textContent = showRaw ? 'Show rendered' : 'Show raw';
+}
+
+function formatJSON(jsonStr) {
+    if (!jsonStr) return '';
+    try {
+        return JSON.stringify(JSON.parse(jsonStr), null, 2);
+    } catch (e) {
+        return jsonStr;
+    }
+}
+
+window.addEventListener('DOMContentLoaded', function() {
+    // Format all JSON code blocks
+    document.querySelectorAll('.json-content').forEach(function(el) {
+        el.textContent = formatJSON(el.textContent);
+    });
+});
@@ -0,0 +1,99 @@
+(function() {
+    const maxRows = 200;
+    const tbody = document.getElementById('entries');
+    const connection = document.getElementById('connection');
+    const count = document.getElementById('count');
+    const pauseButton = document.getElementById('pause');
+    let paused = false;
+    let pending = [];
+    let received = 0;
+
+    function cell(className, text) {
+        const td = document.createElement('td');
+        if (className) td.className = className;
+        if (text !== undefined) td.textContent = text;
+        return td;
+    }
+
+    function badge(className, text) {
+        const span = document.createElement('span');
+        span.className = className;
+        span.textContent = text;
+        return span;
+    }
+
+    function pad(n) {
+        return String(n).padStart(2, '0');
+    }
+
+    function formatTime(value) {
+        const d = new Date(value);
+        return d.getFullYear() + '-' + pad(d.getMonth() + 1) + '-' + pad(d.getDate()) + ' ' +
+            pad(d.getHours()) + ':' + pad(d.getMinutes()) + ':' + pad(d.getSeconds());
+    }
+
+    function addRow(entry) {
+        const empty = document.getElementById('empty');
+        if (empty) empty.remove();
+
+        const tr = document.createElement('tr');
+        tr.className = 'new';
+        const idCell = cell();
+        const link = document.createElement('a');
+        link.href = '/logs/details?id=' + entry.id;
+        link.textContent = '#' + entry.id;
+        idCell.appendChild(link);
+        tr.appendChild(idCell);
+        tr.appendChild(cell('timestamp', formatTime(entry.timestamp)));
+        tr.appendChild(cell('endpoint', entry.endpoint));
+        tr.appendChild(cell('model', entry.model));
+        tr.appendChild(cell(entry.status_code === 200 ? 'status-ok' : 'status-error', entry.status_code));
+        tr.appendChild(cell('latency', entry.latency_ms + 'ms'));
+        const flags = cell();
+        if (entry.stream) flags.appendChild(badge('stream-badge', 'STREAM'));
+        if (entry.cache_hit) flags.appendChild(badge('cache-badge', 'CACHED'));
+        if (entry.partial) flags.appendChild(badge('partial-badge', 'PARTIAL'));
+        if (entry.error) {
+            const errorBadge = badge('error-badge', 'ERROR');
+            errorBadge.title = entry.error;
+            flags.appendChild(errorBadge);
+        }
+        tr.appendChild(flags);
+        tr.appendChild(cell('truncated', entry.preview));
+
+        tbody.insertBefore(tr, tbody.firstChild);
+        while (tbody.rows.length > maxRows) {
+            tbody.deleteRow(-1);
+        }
+    }
+
+    pauseButton.addEventListener('click', function() {
+        paused = !paused;
+        pauseButton.textContent = paused ? 'Resume' : 'Pause';
+        if (!paused) {
+            pending.forEach(addRow);
+            pending = [];
+        }
+    });
+
+    const source = new EventSource('/logs/live/events');
+    source.onopen = function() {
+        connection.textContent = '● Live';
+        connection.className = 'connection connected';
+    };
+    source.onerror = function() {
+        connection.textContent = 'Disconnected, reconnecting…';
+        connection.className = 'connection';
+    };
+    source.onmessage = function(event) {
+        const entry = JSON.parse(event.data);
+        received++;
+        count.textContent = received + ' received';
+        if (paused) {
+            pending.push(entry);
+            if (pending.length > maxRows) pending.shift();
+        } else {
+            addRow(entry);
+        }
+    };
+})();
@@ -0,0 +1,23 @@
+// Compare the two selected requests on the diff page, older on the left
+(function() {
+    const button = document.getElementById('compare');
+    const boxes = Array.from(document.querySelectorAll('.compare-select'));
+    function selected() {
+        return boxes.filter(function(box) { return box.checked; });
+    }
+    boxes.forEach(function(box) {
+        box.addEventListener('change', function() {
+            button.disabled = selected().length !== 2;
+        });
+    });
+    button.addEventListener('click', function() {
+        const pair = selected().sort(function(x, y) { return Number(x.value) - Number(y.value); });
+        if (pair.length !== 2) return;
+        const params = new URLSearchParams();
+        params.set('a', pair[0].value);
+        if (pair[0].dataset.source) params.set('source_a', pair[0].dataset.source);
+        params.set('b', pair[1].value);
+        if (pair[1].dataset.source) params.set('source_b', pair[1].dataset.source);
+        window.location = '/logs/diff?' + params.toString();
+    });
+})();
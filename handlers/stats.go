@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// statsRange is a period the stats page can show
+type statsRange struct {
+	Name       string // "range" query parameter value
+	Label      string
+	Length     time.Duration
+	BucketSize time.Duration // Period covered by each bar of the requests chart
+	Days       int           // Days the range reaches back, for /api/usage
+}
+
+var statsRanges = []statsRange{
+	{"24h", "Last 24 hours", 24 * time.Hour, time.Hour, 1},
+	{"7d", "Last 7 days", 7 * 24 * time.Hour, 24 * time.Hour, 7},
+	{"30d", "Last 30 days", 30 * 24 * time.Hour, 24 * time.Hour, 30},
+}
+
+// statsBar is one bar of the requests chart
+type statsBar struct {
+	Label         string
+	Requests      int64
+	Errors        int64
+	HeightPercent float64 // Bar height relative to the busiest bar
+	ErrorPercent  float64 // Share of the bar that failed
+}
+
+// statsTable is a per-group breakdown on the stats page
+type statsTable struct {
+	Title  string
+	Groups []database.GroupStats
+}
+
+// StatsHandler serves the statistics page for the local request log
+func (h *WebHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	selected := statsRanges[1]
+	for _, option := range statsRanges {
+		if option.Name == r.URL.Query().Get("range") {
+			selected = option
+		}
+	}
+
+	now := time.Now()
+	since := statsRangeStart(now, selected.Length, selected.BucketSize)
+	stats, err := h.db.GetRequestStats(since, now, selected.BucketSize, backend.TenantFromContext(r.Context()))
+	if err != nil {
+		log.Printf("Error getting request stats: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Ranges []statsRange
+		Range  statsRange
+		Stats  *database.RequestStats
+		Bars   []statsBar
+		Tables []statsTable
+	}{
+		Ranges: statsRanges,
+		Range:  selected,
+		Stats:  stats,
+		Bars:   statsBars(stats.Buckets, selected.BucketSize),
+		Tables: []statsTable{
+			{Title: "Models", Groups: stats.Models},
+			{Title: "Endpoints", Groups: stats.Endpoints},
+		},
+	}
+
+	renderTemplate(w, "stats.html", data)
+}
+
+// statsRangeStart returns the start of the bucket that begins length before
+// the bucket containing now. Daily buckets start at local midnight.
+func statsRangeStart(now time.Time, length, bucketSize time.Duration) time.Time {
+	start := now.Truncate(time.Hour)
+	if bucketSize >= 24*time.Hour {
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
+	return start.Add(bucketSize - length)
+}
+
+// statsBars scales the buckets for the chart
+func statsBars(buckets []database.TimeBucket, bucketSize time.Duration) []statsBar {
+	var busiest int64
+	for _, bucket := range buckets {
+		busiest = max(busiest, bucket.Requests)
+	}
+
+	layout := "Jan 2"
+	if bucketSize < 24*time.Hour {
+		layout = "15:04"
+	}
+	bars := make([]statsBar, 0, len(buckets))
+	for _, bucket := range buckets {
+		bar := statsBar{
+			Label:    bucket.Start.Format(layout),
+			Requests: bucket.Requests,
+			Errors:   bucket.Errors,
+		}
+		if busiest > 0 {
+			bar.HeightPercent = float64(bucket.Requests) * 100 / float64(busiest)
+		}
+		if bucket.Requests > 0 {
+			bar.ErrorPercent = float64(bucket.Errors) * 100 / float64(bucket.Requests)
+		}
+		bars = append(bars, bar)
+	}
+	return bars
+}
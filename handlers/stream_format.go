@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"llm_proxy/backend"
+	"llm_proxy/models"
+)
+
+// streamFormat is the framing used for chunks streamed from the Ollama-style
+// endpoints. Ollama clients read newline-delimited JSON; clients that send
+// "Accept: text/event-stream" get the same chunks as Server-Sent Events.
+type streamFormat int
+
+const (
+	ndjsonStream streamFormat = iota
+	sseStream
+)
+
+// negotiateStreamFormat picks the stream framing from the request's Accept
+// header. SSE is used only if text/event-stream is preferred at least as much
+// as any JSON type, so clients sending "*/*" or nothing keep NDJSON.
+func negotiateStreamFormat(r *http.Request) streamFormat {
+	var sseQ, jsonQ float64 = -1, -1
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "text/event-stream":
+			sseQ = max(sseQ, q)
+		case "application/x-ndjson", "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	if sseQ > 0 && sseQ >= jsonQ {
+		return sseStream
+	}
+	return ndjsonStream
+}
+
+// contentType returns the Content-Type of a streamed response
+func (f streamFormat) contentType() string {
+	if f == sseStream {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+// writeChunk writes v as one framed chunk and flushes it to the client
+func (f streamFormat) writeChunk(w http.ResponseWriter, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if f == sseStream {
+		_, err = io.WriteString(w, "data: "+string(data)+"\n\n")
+	} else {
+		_, err = w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		return err
+	}
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return nil
+}
+
+// keepAlive returns the ping sent while the stream is idle
+func (f streamFormat) keepAlive(w http.ResponseWriter) func() {
+	if f == sseStream {
+		return sseKeepAlive(w)
+	}
+	return ndjsonKeepAlive(w)
+}
+
+// statusClientClosedRequest is logged for requests whose client went away
+// before the response was complete (nginx's non-standard 499)
+const statusClientClosedRequest = 499
+
+// errClientCancelled is the error logged with statusClientClosedRequest
+const errClientCancelled = "client_cancelled"
+
+// responseStatus returns the status and error to log for a response that
+// may have been cut short: 499 and "client_cancelled" if the client went
+// away, 502 Bad Gateway and the read error if the backend failed part way
+// through, otherwise 200 and ""
+func responseStatus(ctx context.Context, meta *backend.BackendMetadata) (int, string) {
+	if ctx.Err() != nil {
+		return statusClientClosedRequest, errClientCancelled
+	}
+	if meta == nil || meta.StreamErr == nil {
+		return http.StatusOK, ""
+	}
+	return http.StatusBadGateway, meta.StreamErr.Error()
+}
+
+// backendErrorStatus returns the status for a backend call that failed
+// before responding: 503 Service Unavailable if the backend queue was full
+// or the request timed out waiting in it, the status a hook or routing rule
+// rejected the request with, otherwise 500
+func backendErrorStatus(err error) int {
+	if errors.Is(err, backend.ErrQueueFull) || errors.Is(err, backend.ErrQueueTimeout) {
+		return http.StatusServiceUnavailable
+	}
+	var rejected *backend.RejectedError
+	if errors.As(err, &rejected) {
+		return rejected.StatusCode()
+	}
+	return http.StatusInternalServerError
+}
+
+// writeOllamaError sends an Ollama-style JSON error with the given status
+func writeOllamaError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(models.ErrorResponse{Error: message}); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// discardRest drains ch once the client has gone away. The backend sees the
+// cancelled context and closes ch shortly after, by which time the raw
+// response it received so far is on its metadata, ready to log.
+func discardRest[T any](ch <-chan T) {
+	for range ch {
+	}
+}
+
+// cutShort reports whether a response logged with errMsg ended early, so
+// that what was received is logged as partial
+func cutShort(errMsg string, meta *backend.BackendMetadata) bool {
+	return errMsg == errClientCancelled || meta != nil && meta.StreamErr != nil
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/models"
+)
+
+func TestNegotiateStreamFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   streamFormat
+	}{
+		{"", ndjsonStream},
+		{"*/*", ndjsonStream},
+		{"application/x-ndjson", ndjsonStream},
+		{"text/event-stream", sseStream},
+		{"text/event-stream, application/json;q=0.5", sseStream},
+		{"application/json, text/event-stream;q=0.9", ndjsonStream},
+		{"text/event-stream;q=0", ndjsonStream},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if got := negotiateStreamFormat(req); got != tt.want {
+			t.Errorf("Accept %q: got format %d, want %d", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestOllamaChatStreamsSSEWhenRequested(t *testing.T) {
+	spy, db, cfg := newStreamOverrideTest(t)
+	body := marshalStreamOverrideBody(t, models.ChatRequest{
+		Model:    "test-model",
+		Messages: []models.Message{{Role: "user", Content: "hello"}},
+		Stream:   true,
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body))
+	req.Header.Set("Accept", "text/event-stream")
+	rec := httptest.NewRecorder()
+	NewChatHandler(spy, db, cfg).ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+	events := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n\n"), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %q", len(events), rec.Body.String())
+	}
+	for _, event := range events {
+		if !strings.HasPrefix(event, "data: {") {
+			t.Errorf("event %q is not an SSE data line", event)
+		}
+	}
+
+	// Without the Accept header the same request streams NDJSON
+	rec = serveStreamOverrideRequest(t, "ollama_chat", spy, db, cfg, true)
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if lines := nonEmptyLines(rec.Body.String()); len(lines) != 3 || !strings.HasPrefix(lines[0], "{") {
+		t.Errorf("expected 3 NDJSON lines, got %q", rec.Body.String())
+	}
+}
+
+// brokenStreamBackend sends one chunk and then fails, as a backend that dies
+// mid-stream does
+type brokenStreamBackend struct {
+	streamOverrideSpyBackend
+}
+
+func (b *brokenStreamBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *backend.BackendMetadata, error) {
+	ch := make(chan models.ChatResponse, 1)
+	ch <- models.ChatResponse{Model: req.Model, Message: models.Message{Role: "assistant", Content: "partial"}}
+	close(ch)
+	return ch, &backend.BackendMetadata{StreamErr: errors.New("connection reset")}, nil
+}
+
+func TestBackendStreamErrorReachesClient(t *testing.T) {
+	_, db, cfg := newStreamOverrideTest(t)
+	b := &brokenStreamBackend{}
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:    "ollama stream",
+			handler: NewChatHandler(b, db, cfg),
+			path:    "/api/chat",
+			body: marshalStreamOverrideBody(t, models.ChatRequest{
+				Model: "test-model", Messages: []models.Message{{Role: "user", Content: "hello"}}, Stream: true,
+			}),
+			wantStatus: http.StatusOK,
+			wantBody:   `{"error":"connection reset"}`,
+		},
+		{
+			name:    "ollama single response",
+			handler: NewChatHandler(b, db, cfg),
+			path:    "/api/chat",
+			body: marshalStreamOverrideBody(t, models.ChatRequest{
+				Model: "test-model", Messages: []models.Message{{Role: "user", Content: "hello"}},
+			}),
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `{"error":"connection reset"}`,
+		},
+		{
+			name:    "openai stream",
+			handler: NewOpenAIChatCompletionsHandler(b, db, cfg),
+			path:    "/v1/chat/completions",
+			body: marshalStreamOverrideBody(t, models.OpenAIChatRequest{
+				Model: "test-model", Messages: []models.Message{{Role: "user", Content: "hello"}}, Stream: true,
+			}),
+			wantStatus: http.StatusOK,
+			wantBody:   `data: {"error":{"message":"connection reset","type":"server_error"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			lines := nonEmptyLines(rec.Body.String())
+			if len(lines) == 0 || lines[len(lines)-1] != tt.wantBody {
+				t.Errorf("body = %q, want it to end with %s", rec.Body.String(), tt.wantBody)
+			}
+			if strings.Contains(rec.Body.String(), "[DONE]") || strings.Contains(rec.Body.String(), `"done":true`) {
+				t.Errorf("body = %q, want no successful finish", rec.Body.String())
+			}
+
+			entries, err := db.GetRecentEntries(1, 0)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("GetRecentEntries() = %d entries, error %v", len(entries), err)
+			}
+			if entries[0].StatusCode != http.StatusBadGateway || entries[0].Error != "connection reset" {
+				t.Errorf("logged status %d error %q, want 502 connection reset", entries[0].StatusCode, entries[0].Error)
+			}
+			if !entries[0].Partial || entries[0].Response != "partial" {
+				t.Errorf("logged partial %v response %q, want the partial response flagged", entries[0].Partial, entries[0].Response)
+			}
+		})
+	}
+}
+
+// stallingBackend sends one chunk and then waits for the request to be
+// cancelled, recording that it was
+type stallingBackend struct {
+	streamOverrideSpyBackend
+	cancelled chan struct{}
+}
+
+func (b *stallingBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *backend.BackendMetadata, error) {
+	ch := make(chan models.ChatResponse)
+	go func() {
+		defer close(ch)
+		select {
+		case ch <- models.ChatResponse{Model: req.Model, Message: models.Message{Role: "assistant", Content: "partial"}}:
+		case <-ctx.Done():
+		}
+		<-ctx.Done()
+		close(b.cancelled)
+	}()
+	return ch, &backend.BackendMetadata{}, nil
+}
+
+func TestClientDisconnectCancelsBackend(t *testing.T) {
+	_, db, cfg := newStreamOverrideTest(t)
+	b := &stallingBackend{cancelled: make(chan struct{})}
+	body := marshalStreamOverrideBody(t, models.ChatRequest{
+		Model:    "test-model",
+		Messages: []models.Message{{Role: "user", Content: "hello"}},
+		Stream:   true,
+	})
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		NewChatHandler(b, db, cfg).ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	disconnect()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return after the client disconnected")
+	}
+	select {
+	case <-b.cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend request was not cancelled")
+	}
+
+	entries, err := db.GetRecentEntries(1, 0)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("GetRecentEntries() = %d entries, error %v", len(entries), err)
+	}
+	if entries[0].StatusCode != statusClientClosedRequest || entries[0].Error != "client_cancelled" {
+		t.Errorf("logged status %d error %q, want 499 client_cancelled", entries[0].StatusCode, entries[0].Error)
+	}
+	if !entries[0].Partial || entries[0].Response != "partial" {
+		t.Errorf("logged partial %v response %q, want the partial response flagged", entries[0].Partial, entries[0].Response)
+	}
+}
+
+// queueFullBackend turns every chat request away as a full rate-limit queue would
+type queueFullBackend struct {
+	streamOverrideSpyBackend
+}
+
+func (b *queueFullBackend) Chat(ctx context.Context, req models.ChatRequest) (<-chan models.ChatResponse, *backend.BackendMetadata, error) {
+	ch := make(chan models.ChatResponse)
+	close(ch)
+	return ch, &backend.BackendMetadata{}, fmt.Errorf("waiting for backend: %w", backend.ErrQueueFull)
+}
+
+func TestBackendQueueFullReturns503(t *testing.T) {
+	_, db, cfg := newStreamOverrideTest(t)
+	b := &queueFullBackend{}
+	const errMsg = "waiting for backend: backend queue is full"
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		path     string
+		body     string
+		wantBody string
+	}{
+		{
+			name:    "ollama",
+			handler: NewChatHandler(b, db, cfg),
+			path:    "/api/chat",
+			body: marshalStreamOverrideBody(t, models.ChatRequest{
+				Model: "test-model", Messages: []models.Message{{Role: "user", Content: "hello"}}, Stream: true,
+			}),
+			wantBody: `{"error":"` + errMsg + `"}`,
+		},
+		{
+			name:    "openai",
+			handler: NewOpenAIChatCompletionsHandler(b, db, cfg),
+			path:    "/v1/chat/completions",
+			body: marshalStreamOverrideBody(t, models.OpenAIChatRequest{
+				Model: "test-model", Messages: []models.Message{{Role: "user", Content: "hello"}}, Stream: true,
+			}),
+			wantBody: `{"error":{"message":"` + errMsg + `","type":"server_error"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want 503", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+
+			entries, err := db.GetRecentEntries(1, 0)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("GetRecentEntries() = %d entries, error %v", len(entries), err)
+			}
+			if entries[0].StatusCode != http.StatusServiceUnavailable || entries[0].Error != errMsg {
+				t.Errorf("logged status %d error %q, want 503 %s", entries[0].StatusCode, entries[0].Error, errMsg)
+			}
+		})
+	}
+}
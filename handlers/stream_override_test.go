@@ -85,6 +85,10 @@ func (s *streamOverrideSpyBackend) ShowModel(context.Context, string) (models.Sh
 	return models.ShowResponse{}, nil
 }
 
+func (s *streamOverrideSpyBackend) Embed(context.Context, models.EmbedRequest) (models.EmbedResponse, *backend.BackendMetadata, error) {
+	return models.EmbedResponse{}, &backend.BackendMetadata{}, nil
+}
+
 func TestStreamOverrideForcesBackendRequestStream(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"llm_proxy/backend"
+	"llm_proxy/config"
+	"llm_proxy/database"
+)
+
+// tenantConfig returns cfg as it applies to the requests of the tenant on
+// ctx: with the tenant's chat text injection in place of the global one, if
+// it has one.
+func tenantConfig(ctx context.Context, cfg *config.Config) *config.Config {
+	tenant := cfg.Tenant(backend.TenantFromContext(ctx))
+	if tenant == nil || tenant.ChatTextInjection == nil {
+		return cfg
+	}
+	scoped := *cfg
+	scoped.ChatTextInjection = *tenant.ChatTextInjection
+	return &scoped
+}
+
+// attributeEntry records on entry who made the request it logs
+func attributeEntry(ctx context.Context, entry *database.LogEntry) {
+	entry.Tenant = backend.TenantFromContext(ctx)
+	info := backend.RequestInfoFromContext(ctx)
+	entry.ClientIP = info.ClientIP
+	entry.UserAgent = info.Header.Get("User-Agent")
+	entry.User = info.User
+	entry.FrontendHeaders = frontendHeaders(info)
+}
+
+// AdminAccess decides which requests to the web UI and admin APIs are an
+// administrator's, seeing and changing the state of every tenant. Requests
+// of a tenant never are. When tenants share the listener, a request of no
+// tenant must also present one of the admin API keys; otherwise a tenant's
+// client could leave out its key to be taken for an administrator.
+type AdminAccess struct {
+	keys        []string
+	keyRequired bool
+}
+
+// NewAdminAccess creates the admin check. keyRequired makes requests of no
+// tenant present one of keys.
+func NewAdminAccess(keys []string, keyRequired bool) *AdminAccess {
+	return &AdminAccess{keys: keys, keyRequired: keyRequired}
+}
+
+// Admin reports whether r is an administrator's
+func (a *AdminAccess) Admin(r *http.Request) bool {
+	if backend.TenantFromContext(r.Context()) != "" {
+		return false
+	}
+	return !a.keyRequired || slices.Contains(a.keys, backend.ClientKeyFromContext(r.Context()))
+}
+
+// Only refuses requests that aren't an administrator's with 403, for admin
+// endpoints that show or change state shared between tenants
+func (a *AdminAccess) Only(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Admin(r) {
+			http.Error(w, "Forbidden: admin API key required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Scoped lets through the requests of tenants, which the handler limits to
+// the tenant's own data, and of administrators. Others are refused with 403.
+func (a *AdminAccess) Scoped(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if backend.TenantFromContext(r.Context()) == "" && !a.Admin(r) {
+			http.Error(w, "Forbidden: tenant or admin API key required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
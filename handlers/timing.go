@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"time"
+
+	"llm_proxy/database"
+	"llm_proxy/models"
+)
+
+// generationTiming splits a response's latency into the wait for the first
+// generated token and the time spent generating the rest
+type generationTiming struct {
+	firstOutput  time.Time // When the first chunk with generated output arrived
+	evalDuration int64     // Generation time reported by the backend (Ollama eval_duration), in ns
+}
+
+// observe notes a response chunk, which has generated output (text,
+// thinking or tool calls) if hasOutput is set
+func (g *generationTiming) observe(hasOutput bool, evalDuration int64) {
+	if hasOutput && g.firstOutput.IsZero() {
+		g.firstOutput = time.Now()
+	}
+	if evalDuration > 0 {
+		g.evalDuration = evalDuration
+	}
+}
+
+// apply sets the timing columns of entry, whose Timestamp, LatencyMs and
+// CompletionTokens must already be set. Tokens per second come from the
+// backend's own generation time if it reported one, and otherwise from the
+// time between the first chunk and the end of the response, which is zero
+// unless the response was streamed from the backend.
+func (g generationTiming) apply(entry *database.LogEntry) {
+	if g.firstOutput.IsZero() {
+		return
+	}
+	entry.TTFTMs = g.firstOutput.Sub(entry.Timestamp).Milliseconds()
+	entry.GenerationMs = max(entry.LatencyMs-entry.TTFTMs, 0)
+
+	if entry.CompletionTokens <= 0 {
+		return
+	}
+	switch {
+	case g.evalDuration > 0:
+		entry.TokensPerSecond = float64(entry.CompletionTokens) / time.Duration(g.evalDuration).Seconds()
+	case entry.GenerationMs > 0:
+		entry.TokensPerSecond = float64(entry.CompletionTokens) * 1000 / float64(entry.GenerationMs)
+	}
+}
+
+// hasChatOutput reports whether a chat chunk carries generated output
+func hasChatOutput(resp models.ChatResponse) bool {
+	return resp.Message.Content != "" || resp.Message.Thinking != "" || len(resp.Message.ToolCalls) > 0
+}
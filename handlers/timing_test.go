@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"llm_proxy/database"
+)
+
+func TestGenerationTimingApply(t *testing.T) {
+	start := time.Now()
+	entry := database.LogEntry{Timestamp: start, LatencyMs: 2500, CompletionTokens: 100}
+	timing := generationTiming{firstOutput: start.Add(500 * time.Millisecond)}
+	timing.apply(&entry)
+	if entry.TTFTMs != 500 || entry.GenerationMs != 2000 || entry.TokensPerSecond != 50 {
+		t.Fatalf("got ttft %d, generation %d, %.1f tok/s; want 500, 2000, 50.0", entry.TTFTMs, entry.GenerationMs, entry.TokensPerSecond)
+	}
+
+	// The backend's own generation time wins
+	entry = database.LogEntry{Timestamp: start, LatencyMs: 2500, CompletionTokens: 100}
+	timing.evalDuration = int64(4 * time.Second)
+	timing.apply(&entry)
+	if entry.TokensPerSecond != 25 {
+		t.Fatalf("TokensPerSecond = %.1f, want 25.0 from eval_duration", entry.TokensPerSecond)
+	}
+
+	// Without any output there is nothing to time
+	entry = database.LogEntry{Timestamp: start, LatencyMs: 2500, CompletionTokens: 100}
+	generationTiming{}.apply(&entry)
+	if entry.TTFTMs != 0 || entry.GenerationMs != 0 || entry.TokensPerSecond != 0 {
+		t.Fatalf("got %+v, want no timing", entry)
+	}
+}
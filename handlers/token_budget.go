@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"llm_proxy/backend"
+	"llm_proxy/config"
+	"llm_proxy/models"
+)
+
+const (
+	tokenBudgetActionReject   = "reject"
+	tokenBudgetActionTruncate = "truncate"
+
+	tokenBudgetStrategyKeepFirst = "keep_first"
+)
+
+// applyChatTokenBudget caps num_predict and makes the messages fit in
+// token_budget.max_message_chars, dropping older messages if the action is
+// "truncate". It returns an error for the client if the request is too
+// large.
+func applyChatTokenBudget(req *models.ChatRequest, cfg *config.Config) error {
+	budget := cfg.TokenBudget
+	req.Options = capOptionsMaxTokens(req.Options, budget.MaxTokens, cfg)
+
+	if budget.MaxMessageChars <= 0 {
+		return nil
+	}
+	total := messagesChars(req.Messages)
+	if total <= budget.MaxMessageChars {
+		return nil
+	}
+	if budget.Action != tokenBudgetActionTruncate {
+		return fmt.Errorf("request messages are %d characters, over the limit of %d", total, budget.MaxMessageChars)
+	}
+
+	trimmed, dropped := backend.DropOldMessages(req.Messages, budget.MaxMessageChars, messageChars, budget.Strategy == tokenBudgetStrategyKeepFirst)
+	trimmedTotal := messagesChars(trimmed)
+	if trimmedTotal > budget.MaxMessageChars {
+		return fmt.Errorf("request messages are %d characters, over the limit of %d even after dropping %d older message(s)", trimmedTotal, budget.MaxMessageChars, len(dropped))
+	}
+	log.Printf("Token budget: dropped %d of %d message(s) to fit %d characters in %d", len(dropped), len(req.Messages), total, budget.MaxMessageChars)
+	req.Messages = trimmed
+	return nil
+}
+
+// applyGenerateTokenBudget caps num_predict and rejects prompts over
+// token_budget.max_message_chars. A prompt has no messages to drop, so it
+// is rejected whatever the action.
+func applyGenerateTokenBudget(req *models.GenerateRequest, cfg *config.Config) error {
+	budget := cfg.TokenBudget
+	req.Options = capOptionsMaxTokens(req.Options, budget.MaxTokens, cfg)
+
+	if budget.MaxMessageChars <= 0 {
+		return nil
+	}
+	total := utf8.RuneCountInString(req.System) + utf8.RuneCountInString(req.Prompt)
+	if total > budget.MaxMessageChars {
+		return fmt.Errorf("request prompt is %d characters, over the limit of %d", total, budget.MaxMessageChars)
+	}
+	return nil
+}
+
+// capOptionsMaxTokens lowers num_predict to limit, or sets it if the
+// request didn't ask for a limit of its own (limit 0 = no cap). It returns
+// the options, which are created if nil.
+func capOptionsMaxTokens(options map[string]interface{}, limit int, cfg *config.Config) map[string]interface{} {
+	if limit <= 0 {
+		return options
+	}
+	if value, ok := options["num_predict"]; ok {
+		maxTokens, ok := numericOptionValue(value)
+		if !ok || (maxTokens > 0 && maxTokens <= limit) {
+			return options
+		}
+		if cfg.Server.Verbose {
+			log.Printf("Capping num_predict: %d -> %d", maxTokens, limit)
+		}
+	}
+	if options == nil {
+		options = make(map[string]interface{})
+	}
+	options["num_predict"] = float64(limit)
+	return options
+}
+
+// messagesChars is the total size of the messages in characters
+func messagesChars(messages []models.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += messageChars(msg)
+	}
+	return total
+}
+
+// messageChars is the size of a message in characters: its content,
+// thinking and tool calls. Images are not counted.
+func messageChars(msg models.Message) int {
+	n := utf8.RuneCountInString(msg.Content) + utf8.RuneCountInString(msg.Thinking)
+	if len(msg.ToolCalls) > 0 {
+		if data, err := json.Marshal(msg.ToolCalls); err == nil {
+			n += utf8.RuneCount(data)
+		}
+	}
+	return n
+}
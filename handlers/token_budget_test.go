@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm_proxy/models"
+)
+
+func TestTokenBudgetCapsMaxTokens(t *testing.T) {
+	for _, endpoint := range []string{"openai_chat", "ollama_chat", "ollama_generate"} {
+		for _, tt := range []struct {
+			requested int
+			want      int
+		}{
+			{requested: 100, want: 50},
+			{requested: 20, want: 20},
+			{requested: 0, want: 50},
+		} {
+			backend, db, cfg := newSanitizationTest(t)
+			cfg.TokenBudget.MaxTokens = 50
+
+			rec := serveSanitizationRequest(t, endpoint, backend, db, cfg, tt.requested)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d, body = %s", endpoint, rec.Code, rec.Body.String())
+			}
+			assertNumPredict(t, forwardedOptions(endpoint, backend), tt.want)
+		}
+	}
+}
+
+func TestChatTokenBudgetRejectsOrTruncates(t *testing.T) {
+	body := marshalSanitizationBody(t, models.ChatRequest{
+		Model: "test-model",
+		Messages: []models.Message{
+			{Role: "user", Content: strings.Repeat("a", 40)},
+			{Role: "assistant", Content: strings.Repeat("b", 40)},
+			{Role: "user", Content: "latest"},
+		},
+	})
+
+	backend, db, cfg := newSanitizationTest(t)
+	cfg.TokenBudget.MaxMessageChars = 50
+	cfg.TokenBudget.Action = "reject"
+
+	rec := httptest.NewRecorder()
+	NewChatHandler(backend, db, cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "request messages are 86 characters, over the limit of 50") {
+		t.Fatalf("reject: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if backend.lastChatReq.Model != "" {
+		t.Fatal("rejected request reached the backend")
+	}
+
+	cfg.TokenBudget.Action = "truncate"
+	cfg.TokenBudget.Strategy = "drop_oldest"
+	rec = httptest.NewRecorder()
+	NewChatHandler(backend, db, cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("truncate: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := backend.lastChatReq.Messages; len(got) != 2 || got[0].Role != "assistant" || got[1].Content != "latest" {
+		t.Fatalf("forwarded messages = %+v, want the last two", got)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"llm_proxy/config"
+	"llm_proxy/models"
+)
+
+// transformChatTools runs the tools of a chat request through the tool
+// pipeline: the tool policy and blacklist remove tools, then the rewrites
+// in tools.rewrites change the descriptions of those left.
+func transformChatTools(req *models.ChatRequest, cfg *config.Config) {
+	filterChatTools(req, cfg)
+	if len(cfg.Tools.Rewrites) > 0 {
+		rewriteChatTools(req, cfg)
+	}
+}
+
+// toolPolicy returns the tool mode and tool names for model: its entry in
+// tools.models, or else tools.mode and tools.tools.
+func toolPolicy(model string, cfg *config.Config) (string, []string) {
+	if policy, ok := cfg.Tools.Models[model]; ok {
+		return policy.Mode, policy.Tools
+	}
+	return cfg.Tools.Mode, cfg.Tools.Tools
+}
+
+// filterChatTools removes the tools the model's tool policy and
+// backend.tool_blacklist don't allow. If none are left, tool_choice and
+// parallel_tool_calls are dropped too, since backends reject them without
+// tools.
+func filterChatTools(req *models.ChatRequest, cfg *config.Config) {
+	if len(req.Tools) == 0 {
+		return
+	}
+
+	mode, names := toolPolicy(req.Model, cfg)
+	listed := make(map[string]bool)
+	for _, toolName := range names {
+		listed[toolName] = true
+	}
+	blacklist := make(map[string]bool)
+	for _, toolName := range cfg.Backend.ToolBlacklist {
+		blacklist[toolName] = true
+	}
+
+	var filteredTools []interface{}
+	removed := make(map[string]bool)
+	for _, tool := range req.Tools {
+		toolName := chatToolName(tool)
+
+		var reason string
+		switch {
+		case mode == "strip":
+			reason = "tools are stripped for " + req.Model
+		case blacklist[toolName]:
+			reason = "blacklisted"
+		case mode == "whitelist" && !listed[toolName]:
+			reason = "not whitelisted"
+		case mode != "whitelist" && listed[toolName]:
+			reason = "blacklisted"
+		}
+		if reason == "" {
+			filteredTools = append(filteredTools, tool)
+			continue
+		}
+
+		removed[toolName] = true
+		if cfg.Server.Verbose {
+			log.Printf("[VERBOSE] Filtering out tool %q: %s", toolName, reason)
+		}
+	}
+
+	req.Tools = filteredTools
+	if len(req.Tools) == 0 {
+		req.ToolChoice = nil
+		req.ParallelToolCalls = nil
+	} else if name := chatToolName(req.ToolChoice); name != "" && removed[name] {
+		// The client forced a tool that was removed; let the model choose
+		req.ToolChoice = nil
+	}
+}
+
+// chatToolName returns a tool's function name, or "" if it has none
+func chatToolName(tool interface{}) string {
+	toolMap, ok := tool.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	funcField, ok := toolMap["function"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := funcField["name"].(string)
+	return name
+}
+
+// rewriteChatTools applies tools.rewrites to the request's tools. A tool that
+// is changed is copied first, so the client's request is left as it was.
+func rewriteChatTools(req *models.ChatRequest, cfg *config.Config) {
+	for i, tool := range req.Tools {
+		toolName := chatToolName(tool)
+		if toolName == "" {
+			continue
+		}
+		var rewritten map[string]interface{}
+		for _, rewrite := range cfg.Tools.Rewrites {
+			if rewrite.Name != toolName && rewrite.Name != "*" {
+				continue
+			}
+			if rewritten == nil {
+				if rewritten = cloneTool(tool); rewritten == nil {
+					break
+				}
+			}
+			applyToolRewrite(rewritten, rewrite)
+		}
+		if rewritten != nil {
+			if cfg.Server.Verbose {
+				log.Printf("[VERBOSE] Rewrote description of tool %q", toolName)
+			}
+			req.Tools[i] = rewritten
+		}
+	}
+}
+
+// applyToolRewrite changes the descriptions of a tool and its parameters
+func applyToolRewrite(tool map[string]interface{}, rewrite config.ToolRewriteConfig) {
+	function, ok := tool["function"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if rewrite.Description != "" || rewrite.AppendDescription != "" {
+		function["description"] = rewriteDescription(function["description"], rewrite.Description, rewrite.AppendDescription, "\n\n")
+	}
+
+	if len(rewrite.Parameters) == 0 && len(rewrite.AppendParameters) == 0 {
+		return
+	}
+	parameters, _ := function["parameters"].(map[string]interface{})
+	properties, _ := parameters["properties"].(map[string]interface{})
+	for name, property := range properties {
+		propertyMap, ok := property.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		replace, hasReplace := rewrite.Parameters[name]
+		appendText, hasAppend := rewrite.AppendParameters[name]
+		if hasReplace || hasAppend {
+			propertyMap["description"] = rewriteDescription(propertyMap["description"], replace, appendText, " ")
+		}
+	}
+}
+
+// rewriteDescription returns current replaced by replace (if set) with
+// appendText added after sep
+func rewriteDescription(current interface{}, replace string, appendText string, sep string) string {
+	description, _ := current.(string)
+	if replace != "" {
+		description = replace
+	}
+	if appendText != "" {
+		if description != "" {
+			description += sep
+		}
+		description += appendText
+	}
+	return description
+}
+
+// cloneTool deep-copies a tool definition, returning nil if it isn't a JSON
+// object
+func cloneTool(tool interface{}) map[string]interface{} {
+	data, err := json.Marshal(tool)
+	if err != nil {
+		return nil
+	}
+	var clone map[string]interface{}
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil
+	}
+	return clone
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"testing"
+
+	"llm_proxy/config"
+	"llm_proxy/models"
+)
+
+func TestChatToolRewrites(t *testing.T) {
+	readFile := map[string]interface{}{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        "read_file",
+			"description": "Read a file.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"path":  map[string]interface{}{"type": "string", "description": "File path."},
+					"limit": map[string]interface{}{"type": "integer"},
+				},
+			},
+		},
+	}
+	shell := map[string]interface{}{
+		"type":     "function",
+		"function": map[string]interface{}{"name": "shell"},
+	}
+	cfg := &config.Config{
+		Tools: config.ToolsConfig{
+			Mode: "blacklist",
+			Rewrites: []config.ToolRewriteConfig{
+				{
+					Name:              "read_file",
+					AppendDescription: "Prefer it over shell for reading.",
+					Parameters:        map[string]string{"path": "Absolute path of the file."},
+					AppendParameters:  map[string]string{"limit": "Lines to read, at most 2000."},
+				},
+				{Name: "*", AppendDescription: "Call one tool at a time."},
+				{Name: "shell", Description: "Run a command in bash."},
+			},
+		},
+	}
+
+	req := models.ChatRequest{Model: "m", Tools: []interface{}{readFile, shell}}
+	applyChatFeatures(&req, cfg)
+
+	function := req.Tools[0].(map[string]interface{})["function"].(map[string]interface{})
+	if got, want := function["description"], "Read a file.\n\nPrefer it over shell for reading.\n\nCall one tool at a time."; got != want {
+		t.Errorf("read_file description = %q, want %q", got, want)
+	}
+	properties := function["parameters"].(map[string]interface{})["properties"].(map[string]interface{})
+	if got := properties["path"].(map[string]interface{})["description"]; got != "Absolute path of the file." {
+		t.Errorf("path description = %q", got)
+	}
+	if got := properties["limit"].(map[string]interface{})["description"]; got != "Lines to read, at most 2000." {
+		t.Errorf("limit description = %q", got)
+	}
+
+	// Rewrites apply in order, so shell's description is replaced last
+	function = req.Tools[1].(map[string]interface{})["function"].(map[string]interface{})
+	if got := function["description"]; got != "Run a command in bash." {
+		t.Errorf("shell description = %q", got)
+	}
+
+	// The client's tool definitions are left unchanged
+	if got := readFile["function"].(map[string]interface{})["description"]; got != "Read a file." {
+		t.Errorf("original read_file description changed to %q", got)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+// UsageHandler reports token usage and estimated cost per model and per day,
+// from the token counts and costs recorded in the request log
+type UsageHandler struct {
+	db *database.DB
+}
+
+// UsageTotals is the token usage and estimated cost of a group of requests
+type UsageTotals struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageModel is the usage of one model
+type UsageModel struct {
+	Model string `json:"model"`
+	UsageTotals
+}
+
+// UsageDay is the usage of one model on one day
+type UsageDay struct {
+	Date  string `json:"date"` // YYYY-MM-DD in the proxy's time zone
+	Model string `json:"model"`
+	UsageTotals
+}
+
+// Usage is the /api/usage response
+type Usage struct {
+	Since  time.Time    `json:"since"`
+	Total  UsageTotals  `json:"total"`
+	Models []UsageModel `json:"models"`
+	Days   []UsageDay   `json:"days"`
+}
+
+// NewUsageHandler creates a usage handler
+func NewUsageHandler(db *database.DB) *UsageHandler {
+	return &UsageHandler{db: db}
+}
+
+// ServeHTTP serves the usage for the last "days" days (default 30, including
+// today) as JSON. A tenant's request only sees the tenant's own usage.
+func (h *UsageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := 30
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 366 {
+			http.Error(w, "days must be between 1 and 366", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	now := time.Now()
+	since := statsRangeStart(now, time.Duration(days)*24*time.Hour, 24*time.Hour)
+	stats, err := h.db.GetRequestStats(since, now, 24*time.Hour, backend.TenantFromContext(r.Context()))
+	if err != nil {
+		log.Printf("Failed to get usage: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	usage := Usage{
+		Since:  since,
+		Total:  usageTotals(stats.Totals),
+		Models: []UsageModel{},
+		Days:   []UsageDay{},
+	}
+	for _, model := range stats.Models {
+		usage.Models = append(usage.Models, UsageModel{Model: model.Name, UsageTotals: usageTotals(model)})
+	}
+	for _, day := range stats.Usage {
+		usage.Days = append(usage.Days, UsageDay{
+			Date:  day.Day.Format(time.DateOnly),
+			Model: day.Model,
+			UsageTotals: UsageTotals{
+				Requests:         day.Requests,
+				PromptTokens:     day.PromptTokens,
+				CompletionTokens: day.CompletionTokens,
+				Cost:             day.Cost,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		log.Printf("Failed to encode usage: %v", err)
+	}
+}
+
+func usageTotals(stats database.GroupStats) UsageTotals {
+	return UsageTotals{
+		Requests:         stats.Requests,
+		PromptTokens:     stats.PromptTokens,
+		CompletionTokens: stats.CompletionTokens,
+		Cost:             stats.Cost,
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+func TestUsageHandler(t *testing.T) {
+	db := newEmbedTestDB(t)
+	for _, entry := range []database.LogEntry{
+		{Model: "a", PromptTokens: 100, CompletionTokens: 10, Cost: 0.5},
+		{Model: "a", PromptTokens: 50, CompletionTokens: 5, Cost: 0.25},
+		{Model: "b", PromptTokens: 10, CompletionTokens: 1, Tenant: "team-a"},
+	} {
+		entry.Timestamp = time.Now()
+		entry.Endpoint = "/v1/chat/completions"
+		entry.Method = "POST"
+		if err := db.Log(entry); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewUsageHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/usage?days=7", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	var usage Usage
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := UsageTotals{Requests: 3, PromptTokens: 160, CompletionTokens: 16, Cost: 0.75}
+	if usage.Total != want {
+		t.Fatalf("Total = %+v, want %+v", usage.Total, want)
+	}
+	if len(usage.Models) != 2 || usage.Models[0].Model != "a" || usage.Models[0].Cost != 0.75 {
+		t.Fatalf("Models = %+v", usage.Models)
+	}
+	if len(usage.Days) != 2 || usage.Days[0].Date != time.Now().Format(time.DateOnly) {
+		t.Fatalf("Days = %+v", usage.Days)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/usage", nil)
+	NewUsageHandler(db).ServeHTTP(rec, req.WithContext(backend.WithTenant(req.Context(), "team-a")))
+	usage = Usage{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := (UsageTotals{Requests: 1, PromptTokens: 10, CompletionTokens: 1}); usage.Total != want {
+		t.Fatalf("tenant Total = %+v, want %+v", usage.Total, want)
+	}
+
+	rec = httptest.NewRecorder()
+	NewUsageHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/usage?days=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("days=0 status = %d, want 400", rec.Code)
+	}
+}
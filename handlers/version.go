@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"llm_proxy/config"
+)
+
+// defaultOllamaVersion is reported by /api/version when no version is
+// configured and none can be fetched from an Ollama backend. Clients mostly
+// use it to check for a minimum feature level, so it tracks a recent release.
+const defaultOllamaVersion = "0.12.0"
+
+// VersionHandler handles /api/version requests
+type VersionHandler struct {
+	config *config.Config
+	client *http.Client
+}
+
+// versionResponse matches Ollama's /api/version response
+type versionResponse struct {
+	Version string `json:"version"`
+}
+
+// NewVersionHandler creates a new version handler
+func NewVersionHandler(config *config.Config) *VersionHandler {
+	return &VersionHandler{
+		config: config,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *VersionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(versionResponse{Version: h.version(r)}); err != nil {
+		log.Printf("Failed to encode version response: %v", err)
+	}
+}
+
+// version returns the configured version, the Ollama backend's own version,
+// or the built-in default, in that order.
+func (h *VersionHandler) version(r *http.Request) string {
+	if h.config.Current().Server.OllamaVersion != "" {
+		return h.config.Current().Server.OllamaVersion
+	}
+	if h.config.Current().Backend.Type == "ollama" {
+		version, err := h.backendVersion(r)
+		if err == nil {
+			return version
+		}
+		log.Printf("Failed to fetch backend version, reporting %s: %v", defaultOllamaVersion, err)
+	}
+	return defaultOllamaVersion
+}
+
+func (h *VersionHandler) backendVersion(r *http.Request) (string, error) {
+	httpReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, strings.TrimRight(h.config.Current().Backend.Endpoint, "/")+"/api/version", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range h.config.Current().Backend.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var version versionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if version.Version == "" {
+		return "", fmt.Errorf("backend returned an empty version")
+	}
+	return version.Version, nil
+}
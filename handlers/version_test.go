@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVersionHandlerSources(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/version" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"version":"0.11.4"}`))
+	}))
+	defer ollama.Close()
+
+	cfg := embedTestConfig()
+	cfg.Backend.Endpoint = ollama.URL
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		NewVersionHandler(cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		return strings.TrimSpace(rec.Body.String())
+	}
+
+	if got := get(); got != `{"version":"0.11.4"}` {
+		t.Fatalf("passthrough body = %s", got)
+	}
+
+	cfg.Server.OllamaVersion = "0.5.7"
+	if got := get(); got != `{"version":"0.5.7"}` {
+		t.Fatalf("configured body = %s", got)
+	}
+
+	cfg.Server.OllamaVersion = ""
+	cfg.Backend.Type = "openai"
+	if got := get(); got != `{"version":"`+defaultOllamaVersion+`"}` {
+		t.Fatalf("default body = %s", got)
+	}
+}
@@ -1,44 +1,39 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
+	"llm_proxy/backend"
 	"llm_proxy/database"
 )
 
 // DownloadHandler serves a plain-text markdown file of a request log entry,
 // formatted for easy pasting into an LLM conversation for debugging.
 func (h *WebHandler) DownloadHandler(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Query().Get("id")
-	if idStr == "" {
-		http.Error(w, "Missing ID parameter", http.StatusBadRequest)
-		return
-	}
-
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid ID parameter", http.StatusBadRequest)
-		return
-	}
-
-	entry, err := h.db.GetEntryByID(id)
-	if err != nil {
-		log.Printf("Error getting entry: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	if entry == nil {
-		http.NotFound(w, r)
+	entry, source, ok := h.entryFromRequest(w, r)
+	if !ok {
 		return
 	}
 
 	content := formatLLMLog(entry)
-	filename := fmt.Sprintf("request-%d.md", id)
+	filename := fmt.Sprintf("request-%d.md", entry.ID)
+	if source != "" {
+		filename = fmt.Sprintf("request-%s-%d.md", source, entry.ID)
+	}
 
 	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
@@ -53,36 +48,131 @@ var staticFS embed.FS
 
 var templates *template.Template
 
+// staticVersions holds a short content hash of each static file, added to
+// asset URLs so that browsers fetch changed files despite the long cache
+// lifetime
+var staticVersions = make(map[string]string)
+
 const pageSize = 25
 
 func init() {
-	// Load templates with custom functions
+	files, err := fs.ReadDir(staticFS, "static")
+	if err != nil {
+		log.Fatalf("Failed to read static files: %v", err)
+	}
+	for _, file := range files {
+		data, err := staticFS.ReadFile("static/" + file.Name())
+		if err != nil {
+			log.Fatalf("Failed to read static file %s: %v", file.Name(), err)
+		}
+		sum := sha256.Sum256(data)
+		staticVersions[file.Name()] = hex.EncodeToString(sum[:5])
+	}
+
+	// Load templates (pages and the partials they share) once, with custom
+	// functions
 	funcMap := template.FuncMap{
 		"truncate":    truncateString,
 		"formatBytes": formatBytes,
+		"payloadSize": payloadSize,
+		"asset":       assetURL,
 	}
 
-	var err error
 	templates, err = template.New("").Funcs(funcMap).ParseFS(templateFS, "templates/*.html")
 	if err != nil {
 		log.Fatalf("Failed to parse templates: %v", err)
 	}
 }
 
+// assetURL returns the versioned URL of a file in static/
+func assetURL(name string) string {
+	return "/static/" + name + "?v=" + staticVersions[name]
+}
+
+// renderTemplate executes the named page template. The page is rendered in
+// full before anything is written, so a template error results in a clean
+// 500 rather than half a page.
+func renderTemplate(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
 // WebHandler handles the web UI for viewing logs
 type WebHandler struct {
-	db     *database.DB
-	config interface{} // Store config data for home page
+	db      *database.DB
+	config  func() interface{} // Returns the config data for the home page
+	sources []LogSource        // Read-only logs from other proxy instances
+	retry   http.Handler       // Serves retried requests (see SetRetryHandler); nil = off
 }
 
-// NewWebHandler creates a new web handler
-func NewWebHandler(db *database.DB, config interface{}) *WebHandler {
+// NewWebHandler creates a new web handler. Any additional log sources are
+// merged into the logs index alongside the local database.
+func NewWebHandler(db *database.DB, config func() interface{}, sources ...LogSource) *WebHandler {
 	return &WebHandler{
-		db:     db,
-		config: config,
+		db:      db,
+		config:  config,
+		sources: sources,
 	}
 }
 
+// entryFromRequest loads the entry named by the "id" and optional "source"
+// query parameters. It writes an error response and returns ok=false if the
+// entry cannot be served.
+func (h *WebHandler) entryFromRequest(w http.ResponseWriter, r *http.Request) (entry *database.LogEntry, source string, ok bool) {
+	return h.entryFromParams(w, r, "id", "source")
+}
+
+// entryFromParams is entryFromRequest with the names of the ID and source
+// query parameters given.
+func (h *WebHandler) entryFromParams(w http.ResponseWriter, r *http.Request, idParam, sourceParam string) (entry *database.LogEntry, source string, ok bool) {
+	idStr := r.URL.Query().Get(idParam)
+	if idStr == "" {
+		http.Error(w, "Missing "+idParam+" parameter", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid "+idParam+" parameter", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	// Federated entries aren't attributed to tenants, so a tenant can only
+	// see its own local entries
+	tenant := backend.TenantFromContext(r.Context())
+	source = r.URL.Query().Get(sourceParam)
+	if source != "" && tenant != "" {
+		http.NotFound(w, r)
+		return nil, "", false
+	}
+	if source == "" {
+		entry, err = h.db.GetEntryByID(id)
+	} else {
+		src := h.findLogSource(source)
+		if src == nil {
+			http.Error(w, "Unknown log source", http.StatusNotFound)
+			return nil, "", false
+		}
+		entry, err = src.EntryByID(id)
+	}
+	if err != nil {
+		log.Printf("Error getting entry: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if entry == nil || tenant != "" && entry.Tenant != tenant {
+		http.NotFound(w, r)
+		return nil, "", false
+	}
+	return entry, source, true
+}
+
 // truncateString truncates a string to a maximum length
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -102,14 +192,23 @@ func formatBytes(size int) string {
 	}
 }
 
+// payloadSize describes the size of a raw body: size is its size when it was
+// logged (0 for entries from before sizes were recorded) and stored the text
+// kept, which is shorter if the body was truncated
+func payloadSize(size int, stored string) string {
+	if size <= len(stored) {
+		return formatBytes(len(stored))
+	}
+	return fmt.Sprintf("%s (truncated to %s)", formatBytes(size), formatBytes(len(stored)))
+}
+
 // HomeHandler serves the home page with configuration info
 func (h *WebHandler) HomeHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := templates.ExecuteTemplate(w, "home.html", h.config); err != nil {
-		log.Printf("Error executing template: %v", err)
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
+	var data interface{}
+	if h.config != nil {
+		data = h.config()
 	}
+	renderTemplate(w, "home.html", data)
 }
 
 // IndexHandler serves the index page with paginated list
@@ -123,56 +222,276 @@ func (h *WebHandler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	offset := (page - 1) * pageSize
+	filter, filterQuery := logsPageFilter(r.URL.Query())
+	filtered := len(filterQuery) > 0
+	sort := logsPageSortFrom(r.URL.Query())
+	filter.SortBy = sort.By
+	filter.Order = sort.Order
+	pageQuery := sort.addTo(filterQuery)
+
+	// A tenant only sees its own requests
+	tenant := backend.TenantFromContext(r.Context())
+	if tenant != "" {
+		filter.Tenant = tenant
+	}
 
-	// Get total count for pagination
-	total, err := h.db.GetTotalCount()
+	options, err := h.db.GetLogFilterOptions(tenant)
 	if err != nil {
-		log.Printf("Error getting total count: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+		log.Printf("Error getting log filter options: %v", err)
 	}
 
-	// Get entries
-	entries, err := h.db.GetRecentEntries(pageSize, offset)
-	if err != nil {
-		log.Printf("Error getting entries: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
+	// Federated sources are only merged into the default view; filters,
+	// search and sorting cover the local database. They aren't attributed
+	// to tenants, so tenants don't see them.
+	federated := len(h.sources) > 0 && !filtered && sort == defaultLogsPageSort && tenant == ""
+
+	var viewEntries []logListEntry
+	var total int64
+	var unavailable []string
+	if federated {
+		var err error
+		viewEntries, total, unavailable, err = h.mergedLogPage(pageSize, offset)
+		if err != nil {
+			log.Printf("Error getting entries: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// Get total count for pagination
+		var err error
+		total, err = h.db.CountEntries(filter)
+		if err != nil {
+			log.Printf("Error getting total count: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		// Get entries
+		filter.Limit = pageSize
+		filter.Offset = offset
+		entries, err := h.db.GetEntries(filter)
+		if err != nil {
+			log.Printf("Error getting entries: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		viewEntries = make([]logListEntry, 0, len(entries))
+		for _, entry := range entries {
+			viewEntries = append(viewEntries, makeLogListEntry(entry))
+		}
 	}
 
 	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	returnQuery := sort.addTo(filterQuery)
+	if page > 1 {
+		returnQuery.Set("page", strconv.Itoa(page))
+	}
 
 	// Prepare template data
-	viewEntries := make([]logListEntry, 0, len(entries))
-	for _, entry := range entries {
-		viewEntries = append(viewEntries, makeLogListEntry(entry))
-	}
 	data := struct {
-		Entries     []logListEntry
-		CurrentPage int
-		TotalPages  int
-		TotalCount  int64
-		HasPrev     bool
-		HasNext     bool
-		PrevPage    int
-		NextPage    int
+		Entries            []logListEntry
+		Filter             logsPageFilterValues
+		FilterOptions      database.LogFilterOptions
+		Sort               logsPageSort
+		SortHeaders        map[string]logsSortHeader
+		PageQuery          template.URL
+		FilterQuery        template.URL
+		ReturnURL          string // This page, to come back to after deleting a row
+		Filtered           bool
+		Federated          bool
+		UnavailableSources []string
+		CurrentPage        int
+		TotalPages         int
+		TotalCount         int64
+		HasPrev            bool
+		HasNext            bool
+		PrevPage           int
+		NextPage           int
 	}{
-		Entries:     viewEntries,
-		CurrentPage: page,
-		TotalPages:  totalPages,
-		TotalCount:  total,
-		HasPrev:     page > 1,
-		HasNext:     page < totalPages,
-		PrevPage:    page - 1,
-		NextPage:    page + 1,
+		Entries:            viewEntries,
+		Filter:             logsPageFilterValuesFrom(r.URL.Query()),
+		FilterOptions:      options,
+		Sort:               sort,
+		SortHeaders:        logsSortHeaders(filterQuery, sort),
+		PageQuery:          template.URL(pageQuery.Encode()),
+		FilterQuery:        template.URL(filterQuery.Encode()),
+		ReturnURL:          "/logs?" + returnQuery.Encode(),
+		Filtered:           filtered,
+		Federated:          federated,
+		UnavailableSources: unavailable,
+		CurrentPage:        page,
+		TotalPages:         totalPages,
+		TotalCount:         total,
+		HasPrev:            page > 1,
+		HasNext:            page < totalPages,
+		PrevPage:           page - 1,
+		NextPage:           page + 1,
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := templates.ExecuteTemplate(w, "logs.html", data); err != nil {
-		log.Printf("Error executing template: %v", err)
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
+	renderTemplate(w, "logs.html", data)
+}
+
+// logsPageFilterValues holds the logs page filter form fields as submitted.
+type logsPageFilterValues struct {
+	Search      string
+	Endpoint    string
+	Model       string
+	BackendType string
+	Tenant      string
+	User        string
+	ClientIP    string
+	ErrorsOnly  bool
+	Stream      string // "", "yes" or "no"
+	From        string // YYYY-MM-DD
+	To          string // YYYY-MM-DD
+}
+
+func logsPageFilterValuesFrom(q url.Values) logsPageFilterValues {
+	return logsPageFilterValues{
+		Search:      strings.TrimSpace(q.Get("q")),
+		Endpoint:    q.Get("endpoint"),
+		Model:       q.Get("model"),
+		BackendType: q.Get("backend"),
+		Tenant:      q.Get("tenant"),
+		User:        q.Get("user"),
+		ClientIP:    q.Get("client_ip"),
+		ErrorsOnly:  q.Get("errors") == "1",
+		Stream:      q.Get("stream"),
+		From:        q.Get("from"),
+		To:          q.Get("to"),
+	}
+}
+
+// logsPageFilter builds the database filter from the logs page query
+// parameters. It also returns the parameters that were applied, for
+// pagination links; invalid values are ignored.
+func logsPageFilter(q url.Values) (database.LogFilter, url.Values) {
+	values := logsPageFilterValuesFrom(q)
+	var filter database.LogFilter
+	applied := url.Values{}
+
+	if values.Search != "" {
+		filter.Search = values.Search
+		applied.Set("q", values.Search)
+	}
+	if values.Endpoint != "" {
+		filter.Endpoint = values.Endpoint
+		applied.Set("endpoint", values.Endpoint)
+	}
+	if values.Model != "" {
+		filter.Model = values.Model
+		applied.Set("model", values.Model)
+	}
+	if values.BackendType != "" {
+		filter.BackendType = values.BackendType
+		applied.Set("backend", values.BackendType)
+	}
+	if values.Tenant != "" {
+		filter.Tenant = values.Tenant
+		applied.Set("tenant", values.Tenant)
 	}
+	if values.User != "" {
+		filter.User = values.User
+		applied.Set("user", values.User)
+	}
+	if values.ClientIP != "" {
+		filter.ClientIP = values.ClientIP
+		applied.Set("client_ip", values.ClientIP)
+	}
+	if values.ErrorsOnly {
+		filter.ErrorsOnly = true
+		applied.Set("errors", "1")
+	}
+	if values.Stream == "yes" || values.Stream == "no" {
+		stream := values.Stream == "yes"
+		filter.Stream = &stream
+		applied.Set("stream", values.Stream)
+	}
+	if from, err := time.ParseInLocation(time.DateOnly, values.From, time.Local); err == nil {
+		filter.Since = &from
+		applied.Set("from", values.From)
+	}
+	if to, err := time.ParseInLocation(time.DateOnly, values.To, time.Local); err == nil {
+		// Include the whole of the last day
+		until := to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		filter.Until = &until
+		applied.Set("to", values.To)
+	}
+	return filter, applied
+}
+
+// logsPageSort is the logs table ordering: By is a database.LogFilter.SortBy
+// value and Order is "asc" or "desc".
+type logsPageSort struct {
+	By    string
+	Order string
+}
+
+var defaultLogsPageSort = logsPageSort{By: "timestamp", Order: "desc"}
+
+// logsSortFirstOrder is the order a column sorts in when its header is first
+// clicked.
+var logsSortFirstOrder = map[string]string{
+	"timestamp": "desc",
+	"latency":   "desc",
+	"model":     "asc",
+	"status":    "desc",
+}
+
+// logsPageSortFrom reads the "sort" and "order" query parameters, falling
+// back to newest first.
+func logsPageSortFrom(q url.Values) logsPageSort {
+	sort := defaultLogsPageSort
+	if _, ok := logsSortFirstOrder[q.Get("sort")]; ok {
+		sort.By = q.Get("sort")
+	}
+	if order := q.Get("order"); order == "asc" || order == "desc" {
+		sort.Order = order
+	}
+	return sort
+}
+
+// addTo returns a copy of q with the sort parameters added, unless this is
+// the default sort.
+func (s logsPageSort) addTo(q url.Values) url.Values {
+	out := url.Values{}
+	for key, values := range q {
+		out[key] = values
+	}
+	if s != defaultLogsPageSort {
+		out.Set("sort", s.By)
+		out.Set("order", s.Order)
+	}
+	return out
+}
+
+// logsSortHeader is a sortable column header link.
+type logsSortHeader struct {
+	URL   template.URL // Query string to sort by this column
+	Arrow string       // Shown after the header when the table is sorted by it
+}
+
+// logsSortHeaders returns the header link for each sortable column. Clicking
+// the current sort column reverses it; filters are kept and the page resets.
+func logsSortHeaders(filterQuery url.Values, current logsPageSort) map[string]logsSortHeader {
+	headers := make(map[string]logsSortHeader, len(logsSortFirstOrder))
+	for column, firstOrder := range logsSortFirstOrder {
+		next := logsPageSort{By: column, Order: firstOrder}
+		var arrow string
+		if column == current.By {
+			arrow = " ▼"
+			next.Order = "asc"
+			if current.Order == "asc" {
+				arrow = " ▲"
+				next.Order = "desc"
+			}
+		}
+		headers[column] = logsSortHeader{
+			URL:   template.URL(next.addTo(filterQuery).Encode()),
+			Arrow: arrow,
+		}
+	}
+	return headers
 }
 
 // FaviconHandler serves the favicon
@@ -202,21 +521,13 @@ func (h *WebHandler) StaticHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Determine content type based on file extension
-	contentType := "application/octet-stream"
-	if len(filePath) > 4 {
-		switch filePath[len(filePath)-4:] {
-		case ".png":
-			contentType = "image/png"
-		case ".jpg", "jpeg":
-			contentType = "image/jpeg"
-		case ".gif":
-			contentType = "image/gif"
-		case ".svg":
-			contentType = "image/svg+xml"
-		case ".ico":
-			contentType = "image/x-icon"
-		}
+	// Determine content type based on file extension (.ico isn't in Go's
+	// built-in table)
+	contentType := mime.TypeByExtension(path.Ext(filePath))
+	if path.Ext(filePath) == ".ico" {
+		contentType = "image/x-icon"
+	} else if contentType == "" {
+		contentType = "application/octet-stream"
 	}
 
 	w.Header().Set("Content-Type", contentType)
@@ -226,64 +537,60 @@ func (h *WebHandler) StaticHandler(w http.ResponseWriter, r *http.Request) {
 
 // DetailsHandler serves the details page for a specific request
 func (h *WebHandler) DetailsHandler(w http.ResponseWriter, r *http.Request) {
-	// Get ID from query params
-	idStr := r.URL.Query().Get("id")
-	if idStr == "" {
-		http.Error(w, "Missing ID parameter", http.StatusBadRequest)
+	entry, source, ok := h.entryFromRequest(w, r)
+	if !ok {
 		return
 	}
 
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid ID parameter", http.StatusBadRequest)
-		return
-	}
-
-	// Get entry
-	entry, err := h.db.GetEntryByID(id)
-	if err != nil {
-		log.Printf("Error getting entry: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-
-	if entry == nil {
-		http.NotFound(w, r)
-		return
-	}
+	// Get next and previous entry IDs for navigation (local entries only;
+	// federated sources are browsed from the merged index)
+	var nextID, prevID *int64
+	var shadowPartnerID int64
+	if source == "" {
+		var err error
+		tenant := backend.TenantFromContext(r.Context())
+		nextID, err = h.db.GetNextEntryID(entry.ID, tenant)
+		if err != nil {
+			log.Printf("Error getting next entry ID: %v", err)
+		}
 
-	// Get next and previous entry IDs for navigation
-	nextID, err := h.db.GetNextEntryID(id)
-	if err != nil {
-		log.Printf("Error getting next entry ID: %v", err)
-	}
+		prevID, err = h.db.GetPreviousEntryID(entry.ID, tenant)
+		if err != nil {
+			log.Printf("Error getting previous entry ID: %v", err)
+		}
 
-	prevID, err := h.db.GetPreviousEntryID(id)
-	if err != nil {
-		log.Printf("Error getting previous entry ID: %v", err)
+		shadowPartnerID, err = h.db.GetShadowPartnerID(entry)
+		if err != nil {
+			log.Printf("Error getting shadow entry ID: %v", err)
+		}
 	}
 
 	// Prepare template data with navigation
 	data := struct {
 		*database.LogEntry
+		Source               string
 		NextID               *int64
 		PrevID               *int64
+		ShadowPartnerID      int64
+		RequestHeaders       string
 		PromptDisplay        string
+		Thinking             string
+		ResponseHTML         template.HTML
 		FrontendConversation []renderedLogMessage
 		BackendConversation  []renderedLogMessage
 	}{
 		LogEntry:             entry,
+		Source:               source,
 		NextID:               nextID,
 		PrevID:               prevID,
+		ShadowPartnerID:      shadowPartnerID,
+		RequestHeaders:       headerLines(entry.FrontendHeaders),
 		PromptDisplay:        promptDisplayForEntry(entry),
+		Thinking:             thinkingFromResponse(entry.FrontendResponse),
+		ResponseHTML:         renderMarkdown(entry.Response),
 		FrontendConversation: renderedMessagesFromRaw(entry.FrontendRequest),
 		BackendConversation:  renderedMessagesFromRaw(entry.BackendRequest),
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := templates.ExecuteTemplate(w, "details.html", data); err != nil {
-		log.Printf("Error executing template: %v", err)
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
-	}
+	renderTemplate(w, "details.html", data)
 }
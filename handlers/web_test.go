@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/database"
+)
+
+func TestIndexHandlerFilters(t *testing.T) {
+	handler := NewWebHandler(newLogsAPITestDB(t), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/logs?stream=no&errors=1&backend=ollama&q=bad", nil)
+	rec := httptest.NewRecorder()
+	handler.IndexHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "Matching Requests: 1 ") || !strings.Contains(body, `href="/logs/details?id=2"`) {
+		t.Fatalf("filtered page does not list only entry 2:\n%s", body)
+	}
+	if strings.Contains(body, `href="/logs/details?id=1"`) {
+		t.Fatal("filtered page lists entry 1")
+	}
+	if !strings.Contains(body, `<option value="ollama" selected>`) {
+		t.Fatal("backend filter not shown as selected")
+	}
+}
+
+func TestWebHandlerScopesTenants(t *testing.T) {
+	handler := NewWebHandler(newLogsAPITestDB(t), nil)
+	asTenant := func(target string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		return req.WithContext(backend.WithTenant(req.Context(), "team-a"))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.IndexHandler(rec, asTenant("/logs?tenant=other"))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, `href="/logs/details?id=2"`) || strings.Contains(body, `href="/logs/details?id=1"`) {
+		t.Fatalf("tenant index should list only its own entry 2, got %d:\n%s", rec.Code, body)
+	}
+
+	for target, want := range map[string]int{
+		"/logs/details?id=2":          http.StatusOK,
+		"/logs/details?id=1":          http.StatusNotFound,
+		"/logs/har?id=1":              http.StatusNotFound,
+		"/logs/diff?a=2&b=1":          http.StatusNotFound,
+		"/logs/details?id=2&source=x": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		switch {
+		case strings.HasPrefix(target, "/logs/har"):
+			handler.HARHandler(rec, asTenant(target))
+		case strings.HasPrefix(target, "/logs/diff"):
+			handler.DiffHandler(rec, asTenant(target))
+		default:
+			handler.DetailsHandler(rec, asTenant(target))
+		}
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", target, rec.Code, want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	NewAdminAccess(nil, false).Only(http.HandlerFunc(handler.AuditHandler)).ServeHTTP(rec, asTenant("/audit"))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("admin-only status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestLogsPageFilter(t *testing.T) {
+	filter, applied := logsPageFilter(url.Values{
+		"model":  {"gemma4-31b"},
+		"stream": {"maybe"},
+		"from":   {"2026-06-26"},
+		"to":     {"yesterday"},
+		"page":   {"3"},
+	})
+	if filter.Model != "gemma4-31b" || filter.Stream != nil || filter.Since == nil || filter.Until != nil {
+		t.Fatalf("filter = %+v", filter)
+	}
+	if got, want := applied.Encode(), "from=2026-06-26&model=gemma4-31b"; got != want {
+		t.Fatalf("applied = %q, want %q", got, want)
+	}
+}
+
+func TestIndexHandlerSort(t *testing.T) {
+	handler := NewWebHandler(newLogsAPITestDB(t), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/logs?sort=latency&order=asc&model=", nil)
+	rec := httptest.NewRecorder()
+	handler.IndexHandler(rec, req)
+
+	body := rec.Body.String()
+	fast := strings.Index(body, `href="/logs/details?id=2"`)
+	slow := strings.Index(body, `href="/logs/details?id=1"`)
+	if fast < 0 || slow < 0 || fast > slow {
+		t.Fatalf("entries not sorted by latency ascending:\n%s", body)
+	}
+	if !strings.Contains(body, `href="?order=desc&amp;sort=latency" title="Sort by latency">Latency ▲</a>`) {
+		t.Fatalf("latency header does not offer the reverse sort:\n%s", body)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	handler := NewWebHandler(newLogsAPITestDB(t), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/stats?range=30d", nil)
+	rec := httptest.NewRecorder()
+	handler.StatsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `<span class="current">Last 30 days</span>`) {
+		t.Fatalf("30 day range not selected:\n%s", body)
+	}
+}
+
+func TestLiveEventsHandler(t *testing.T) {
+	db := newEmbedTestDB(t)
+	server := httptest.NewServer(http.HandlerFunc(NewWebHandler(db, nil).LiveEventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || line != ": connected\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+
+	if err := db.Log(database.LogEntry{
+		Timestamp:   time.Now(),
+		Endpoint:    "/api/chat",
+		Model:       "test-model",
+		StatusCode:  200,
+		LastMessage: "hello there",
+	}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	var data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString() error = %v", err)
+		}
+		if rest, ok := strings.CutPrefix(line, "data: "); ok {
+			data = strings.TrimSpace(rest)
+			break
+		}
+	}
+	var event liveEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", data, err)
+	}
+	if event.ID != 1 || event.Model != "test-model" || event.Preview != "hello there" {
+		t.Fatalf("event = %+v", event)
+	}
+}
+
+func TestStaticAssets(t *testing.T) {
+	handler := NewWebHandler(newLogsAPITestDB(t), nil)
+
+	rec := httptest.NewRecorder()
+	handler.IndexHandler(rec, httptest.NewRequest(http.MethodGet, "/logs", nil))
+	styleURL := assetURL("style.css")
+	if !strings.Contains(rec.Body.String(), `<link rel="stylesheet" href="`+styleURL+`">`) {
+		t.Fatalf("logs page does not link %s:\n%s", styleURL, rec.Body.String())
+	}
+
+	for path, wantType := range map[string]string{
+		styleURL:            "text/css; charset=utf-8",
+		assetURL("logs.js"): "text/javascript; charset=utf-8",
+		"/static/llama.ico": "image/x-icon",
+	} {
+		rec := httptest.NewRecorder()
+		handler.StaticHandler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != wantType {
+			t.Fatalf("GET %s = %d %q, want 200 %q", path, rec.Code, rec.Header().Get("Content-Type"), wantType)
+		}
+	}
+}
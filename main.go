@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 
 	"llm_proxy/backend"
@@ -14,6 +22,7 @@ import (
 	"llm_proxy/database"
 	"llm_proxy/handlers"
 	"llm_proxy/middleware"
+	"llm_proxy/notify"
 	"time"
 )
 
@@ -45,10 +54,491 @@ func startCleanupTask(db *database.DB, maxRequests int, intervalMinutes int, don
 	}
 }
 
-func main() {
-	// Parse command line flags
-	configPath := flag.String("config", "config.toml", "Path to configuration file")
-	flag.Parse()
+// backendTransport builds the HTTP transport for connections to a backend
+// with the given TLS and proxy settings
+func backendTransport(cfg *config.Config, tlsCfg config.BackendTLSConfig, proxy string) (http.RoundTripper, error) {
+	return backend.NewTransport(backend.TransportOptions{
+		CACert:             tlsCfg.CACert,
+		ClientCert:         tlsCfg.ClientCert,
+		ClientKey:          tlsCfg.ClientKey,
+		InsecureSkipVerify: tlsCfg.InsecureSkipVerify,
+		Proxy:              proxy,
+
+		ConnectTimeout:        configSeconds(cfg.Backend.ConnectTimeout),
+		ResponseHeaderTimeout: configSeconds(cfg.Backend.ResponseHeaderTimeout),
+		StreamIdleTimeout:     configSeconds(cfg.Backend.StreamIdleTimeout),
+	})
+}
+
+// newBackend creates a backend of the given type. Type-specific settings come
+// from the shared [backend_openai], [backend_koboldcpp] and [backend_gemini]
+// sections.
+func newBackend(cfg *config.Config, backendType string, endpoint string, timeout int, headers map[string]string, tlsCfg config.BackendTLSConfig, proxy string) (backend.Backend, error) {
+	transport, err := backendTransport(cfg, tlsCfg, proxy)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", endpoint, err)
+	}
+
+	maxLineSize := cfg.Backend.MaxLineSize * 1024
+
+	switch backendType {
+	case "openai", "vllm", "textgen", "openrouter":
+		apiKey := cfg.BackendOpenAI.APIKey
+		if backendType == "openrouter" {
+			apiKey = cfg.BackendOpenRouter.APIKey
+			headers = openRouterHeaders(cfg.BackendOpenRouter, headers)
+		}
+		b := backend.NewOpenAIBackend(endpoint, timeout, apiKey, cfg.BackendOpenAI.ForcePromptCache, cfg.Gemma4Fix.Enabled, cfg.BackendOpenAI.PassUnknownOptions, headers)
+		b.SetTransport(transport)
+		b.SetMaxLineSize(maxLineSize)
+		switch backendType {
+		case "vllm":
+			b.SetVLLM(true)
+		case "textgen":
+			b.SetTextGen(true)
+		case "openrouter":
+			b.SetOpenRouter(backend.OpenRouterOptions{Provider: cfg.BackendOpenRouter.Provider, Route: cfg.BackendOpenRouter.Route})
+		}
+		if len(cfg.BackendOpenAI.Models) > 0 {
+			settings := make(map[string]backend.ModelSettings, len(cfg.BackendOpenAI.Models))
+			for name, model := range cfg.BackendOpenAI.Models {
+				settings[name] = backend.ModelSettings{
+					ContextLength:     model.ContextLength,
+					Capabilities:      model.Capabilities,
+					Family:            model.Family,
+					ParameterSize:     model.ParameterSize,
+					QuantizationLevel: model.QuantizationLevel,
+					Parameters:        model.Parameters,
+				}
+			}
+			b.SetModelSettings(settings)
+		}
+		b.SetStaticModels(cfg.BackendOpenAI.StaticModels)
+		if cfg.TokenCounting.Method == "off" && len(cfg.TokenCounting.Models) == 0 {
+			return b, nil
+		}
+		backend.SetTiktokenDir(cfg.TokenCounting.TiktokenDir)
+		counting := backend.NewTokenCountingBackend(b, endpoint, headers, backend.TokenCountPolicy{
+			Method: cfg.TokenCounting.Method,
+			Models: cfg.TokenCounting.Models,
+		})
+		counting.SetTransport(transport)
+		return counting, nil
+	case "ollama":
+		b := backend.NewOllamaBackend(endpoint, timeout, headers)
+		b.SetTransport(transport)
+		b.SetMaxLineSize(maxLineSize)
+		return b, nil
+	case "koboldcpp":
+		b := backend.NewKoboldBackend(endpoint, timeout, backend.KoboldChatTemplates[cfg.BackendKoboldCpp.ChatTemplate], cfg.BackendKoboldCpp.MaxLength, headers)
+		b.SetTransport(transport)
+		b.SetMaxLineSize(maxLineSize)
+		return b, nil
+	case "gemini":
+		safetySettings := make([]backend.GeminiSafetySetting, 0, len(cfg.BackendGemini.SafetySettings))
+		for _, setting := range cfg.BackendGemini.SafetySettings {
+			safetySettings = append(safetySettings, backend.GeminiSafetySetting{Category: setting.Category, Threshold: setting.Threshold})
+		}
+		if cfg.BackendGemini.APIKey == "" {
+			log.Printf("Gemini backend: no api_key configured; requests will likely be rejected")
+		}
+		b := backend.NewGeminiBackend(endpoint, timeout, cfg.BackendGemini.APIKey, safetySettings, headers)
+		b.SetTransport(transport)
+		b.SetMaxLineSize(maxLineSize)
+		return b, nil
+	case "mock":
+		scenarios := make([]backend.MockScenario, 0, len(cfg.BackendMock.Scenarios))
+		for _, scenario := range cfg.BackendMock.Scenarios {
+			toolCalls := make([]backend.MockToolCall, 0, len(scenario.ToolCalls))
+			for _, call := range scenario.ToolCalls {
+				toolCalls = append(toolCalls, backend.MockToolCall{Name: call.Name, Arguments: call.Arguments})
+			}
+			scenarios = append(scenarios, backend.MockScenario{
+				Match:     scenario.Match,
+				Reply:     scenario.Reply,
+				ToolCalls: toolCalls,
+				Status:    scenario.Status,
+				Error:     scenario.Error,
+				FailAfter: scenario.FailAfter,
+			})
+		}
+		return backend.NewMockBackend(backend.MockOptions{
+			Reply:           cfg.BackendMock.Reply,
+			Models:          cfg.BackendMock.Models,
+			Delay:           time.Duration(cfg.BackendMock.DelayMs) * time.Millisecond,
+			TokensPerSecond: cfg.BackendMock.TokensPerSecond,
+			ErrorRate:       cfg.BackendMock.ErrorRate,
+			ErrorStatus:     cfg.BackendMock.ErrorStatus,
+			Scenarios:       scenarios,
+		}), nil
+	default:
+		return nil, fmt.Errorf("Invalid backend type: %s", backendType)
+	}
+}
+
+// warmUp loads the models listed in [warmup] on the primary backend and logs
+// how long each took
+func warmUp(cfg *config.Config, b backend.Backend) {
+	modelNames := make([]string, 0, len(cfg.Warmup.Models))
+	for _, model := range cfg.Warmup.Models {
+		if target, ok := cfg.ModelAliases[model]; ok {
+			model = target
+		}
+		modelNames = append(modelNames, model)
+	}
+	var keepAlive interface{}
+	if cfg.Warmup.KeepAlive != "" {
+		keepAlive = cfg.Warmup.KeepAlive
+		if seconds, err := strconv.Atoi(cfg.Warmup.KeepAlive); err == nil {
+			keepAlive = seconds
+		}
+	}
+
+	log.Printf("Warm-up: loading %d model(s): %s", len(modelNames), strings.Join(modelNames, ", "))
+	results := backend.WarmUp(context.Background(), b, cfg.Backend.Type, modelNames, keepAlive, time.Duration(cfg.Warmup.Timeout)*time.Second)
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("Warm-up: failed to load %s after %s: %v", result.Model, result.Duration.Round(time.Millisecond), result.Err)
+		} else {
+			log.Printf("Warm-up: loaded %s in %s", result.Model, result.Duration.Round(time.Millisecond))
+		}
+	}
+}
+
+// configSeconds converts a timeout in seconds from the config, where -1
+// means none, to a duration (0 = none)
+func configSeconds(seconds int) time.Duration {
+	return time.Duration(max(seconds, 0)) * time.Second
+}
+
+// optionalRegexp compiles a validated pattern; "" gives nil, which matches
+// anything
+func optionalRegexp(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// wholeNameRegexps compiles validated patterns to match whole names
+func wholeNameRegexps(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled = append(compiled, regexp.MustCompile("^(?:"+pattern+")$"))
+	}
+	return compiled
+}
+
+// redactProxyURL hides the password of a proxy URL for logging.
+func redactProxyURL(proxy string) string {
+	if u, err := url.Parse(proxy); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return proxy
+}
+
+// homeData is the configuration shown on the web UI home page.
+func homeData(cfg *config.Config) map[string]interface{} {
+	return map[string]interface{}{
+		"BackendType":          cfg.Backend.Type,
+		"BackendEndpoint":      cfg.Backend.Endpoint,
+		"KoboldChatTemplate":   cfg.BackendKoboldCpp.ChatTemplate,
+		"ServerHost":           cfg.Server.Host,
+		"ServerPort":           cfg.Server.Port,
+		"Timeout":              cfg.Backend.Timeout,
+		"StreamIdleTimeout":    cfg.Backend.StreamIdleTimeout,
+		"DatabasePath":         cfg.Database.Path,
+		"EnableCORS":           cfg.Server.EnableCORS,
+		"ToolBlacklist":        cfg.Backend.ToolBlacklist,
+		"ToolMode":             cfg.Tools.Mode,
+		"ToolList":             cfg.Tools.Tools,
+		"ToolModelPolicies":    len(cfg.Tools.Models),
+		"PromptCacheEnabled":   cfg.BackendOpenAI.ForcePromptCache,
+		"MaxTokensPolicy":      cfg.RequestSanitization.MaxTokensPolicy,
+		"MaxTokensLimit":       cfg.RequestSanitization.MaxTokensLimit,
+		"BudgetMaxTokens":      cfg.TokenBudget.MaxTokens,
+		"BudgetMaxChars":       cfg.TokenBudget.MaxMessageChars,
+		"BudgetAction":         cfg.TokenBudget.Action,
+		"StreamOverrideMode":   cfg.StreamOverride.Mode,
+		"Gemma4FixEnabled":     cfg.Gemma4Fix.Enabled,
+		"TextInjectionEnabled": cfg.ChatTextInjection.Enabled,
+		"TextInjectionText":    cfg.ChatTextInjection.Text,
+		"TextInjectionMode":    cfg.ChatTextInjection.Mode,
+		"SystemPrompt":         cfg.ChatTextInjection.SystemPrompt,
+		"SystemPromptMode":     cfg.ChatTextInjection.SystemPromptMode,
+		"MaxConcurrent":        cfg.Scheduler.MaxConcurrentRequests,
+		"EmbeddingCache":       cfg.EmbeddingCache.Enabled,
+		"ResponseCache":        cfg.ResponseCache.Enabled,
+		"ModelCache":           modelCacheTTL(cfg),
+		"FailoverBackends":     len(cfg.Failover.Backends),
+	}
+}
+
+// openRouterHeaders returns headers with OpenRouter's app attribution
+// headers added, unless headers already sets them
+func openRouterHeaders(openRouter config.BackendOpenRouterConfig, headers map[string]string) map[string]string {
+	withApp := make(map[string]string, len(headers)+2)
+	for name, value := range headers {
+		withApp[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range map[string]string{"HTTP-Referer": openRouter.Referer, "X-Title": openRouter.Title} {
+		if _, ok := withApp[http.CanonicalHeaderKey(name)]; !ok && value != "" {
+			withApp[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	return withApp
+}
+
+// loadBalanceKeepAlive returns how long a model stays on its replica after
+// its last request (< 0 = forever)
+func loadBalanceKeepAlive(cfg *config.Config) time.Duration {
+	keepAlive, _ := time.ParseDuration(cfg.LoadBalance.KeepAlive)
+	if seconds, err := strconv.Atoi(cfg.LoadBalance.KeepAlive); err == nil {
+		keepAlive = time.Duration(seconds) * time.Second
+	}
+	if keepAlive < 0 {
+		return -1
+	}
+	return keepAlive
+}
+
+// modelCacheTTL returns the model cache TTL in seconds, or 0 if it is
+// disabled
+func modelCacheTTL(cfg *config.Config) int {
+	if !cfg.ModelCache.Enabled {
+		return 0
+	}
+	return cfg.ModelCache.TTL
+}
+
+// withMiddleware wraps a listener's mux in the configured middleware
+func withMiddleware(cfg *config.Config, db *database.DB, handler http.Handler) http.Handler {
+	// Attach the client API key (if any) to the request context for scheduling
+	handler = middleware.ClientKey(handler)
+
+	// Attach the request path, headers and user for routing rules and the log
+	userKeys := map[string]string{}
+	for name, keys := range cfg.Users.APIKeys {
+		for _, key := range keys {
+			userKeys[key] = name
+		}
+	}
+	keyNames := map[string]string{}
+	for _, tenant := range cfg.Tenants {
+		for _, key := range tenant.APIKeys {
+			keyNames[key] = "tenant " + tenant.Name
+		}
+	}
+	for key, name := range userKeys {
+		keyNames[key] = "user " + name
+	}
+	handler = middleware.RequestInfo(cfg.Users.Header, userKeys, keyNames)(handler)
+
+	// Recognize tenants. This removes their path prefixes, so it runs before
+	// the request path is recorded.
+	if len(cfg.Tenants) > 0 {
+		tenants := make([]middleware.TenantOptions, 0, len(cfg.Tenants))
+		for _, tenant := range cfg.Tenants {
+			tenants = append(tenants, middleware.TenantOptions{
+				Name:              tenant.Name,
+				APIKeys:           tenant.APIKeys,
+				PathPrefix:        tenant.PathPrefix,
+				RequestsPerMinute: tenant.RequestsPerMinute,
+			})
+		}
+		handler = middleware.Tenants(tenants)(handler)
+	}
+
+	// Reject oversized request bodies
+	handler = middleware.MaxRequestBody(func() int64 {
+		if size := cfg.Current().Server.MaxRequestBodySize; size >= 0 {
+			return int64(size) << 20
+		}
+		return -1
+	})(handler)
+
+	// Compress non-streamed responses if enabled
+	if cfg.Server.GzipResponses {
+		handler = middleware.Gzip(handler)
+	}
+
+	// Flush streamed responses at most every flush_interval_ms if asked to
+	if cfg.Server.FlushMode == "timed" {
+		handler = middleware.TimedFlush(time.Duration(cfg.Server.FlushIntervalMs) * time.Millisecond)(handler)
+	}
+
+	// Apply request logging middleware if verbose is enabled
+	handler = middleware.RequestLogging(func() bool { return cfg.Current().Server.Verbose })(handler)
+
+	// Apply CORS middleware if enabled
+	if cfg.Server.EnableCORS {
+		cors := cfg.Server.CORS
+		handler = middleware.CORS(middleware.CORSOptions{
+			AllowedOrigins:   cors.AllowedOrigins,
+			AllowedMethods:   cors.AllowedMethods,
+			AllowedHeaders:   cors.AllowedHeaders,
+			AllowCredentials: cors.AllowCredentials,
+			MaxAge:           cors.MaxAge,
+		})(handler)
+	}
+
+	// Refuse clients outside the access lists before anything else runs
+	if access := cfg.Server.Access; len(access.Allow) > 0 || len(access.Deny) > 0 {
+		accessList, err := middleware.NewAccessList(access.Allow, access.Deny)
+		if err != nil {
+			log.Fatalf("Invalid server.access: %v", err)
+		}
+		var denied func(*http.Request, netip.Addr)
+		if access.LogDenied {
+			denied = logDeniedRequest(cfg, db)
+		}
+		handler = middleware.Access(accessList, denied)(handler)
+	}
+	return handler
+}
+
+// logDeniedRequest returns a callback that records requests refused by the
+// access lists in the database
+func logDeniedRequest(cfg *config.Config, db *database.DB) func(*http.Request, netip.Addr) {
+	return func(r *http.Request, addr netip.Addr) {
+		client := r.RemoteAddr
+		if addr.IsValid() {
+			client = addr.String()
+		}
+		log.Printf("Access denied for %s: %s %s", client, r.Method, r.URL.Path)
+		entry := database.LogEntry{
+			Timestamp:   time.Now(),
+			Endpoint:    r.URL.Path,
+			Method:      r.Method,
+			StatusCode:  http.StatusForbidden,
+			BackendType: cfg.Backend.Type,
+			Error:       fmt.Sprintf("access denied for %s", client),
+			FrontendURL: fmt.Sprintf("http://%s:%d%s", cfg.Server.Host, cfg.Server.Port, r.URL.RequestURI()),
+			ClientIP:    client,
+			UserAgent:   r.UserAgent(),
+		}
+		if err := db.Log(entry); err != nil {
+			log.Printf("Failed to log denied request: %v", err)
+		}
+	}
+}
+
+// notificationLinkURL is the base URL notifications link request details
+// under: notifications.public_url, or else the listener serving the web UI
+func notificationLinkURL(cfg *config.Config) string {
+	if cfg.Notifications.PublicURL != "" {
+		return cfg.Notifications.PublicURL
+	}
+	host, port := cfg.Server.Host, cfg.Server.Port
+	if cfg.Server.AdminPort > 0 {
+		host, port = cfg.Server.AdminHost, cfg.Server.AdminPort
+	}
+	if addr, err := netip.ParseAddr(host); host == "" || (err == nil && addr.IsUnspecified()) {
+		host = "localhost"
+	}
+	scheme := "http"
+	if cfg.Server.TLSCert != "" || cfg.Server.TLSSelfSigned {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// setNoDelay returns a connection state hook that sets TCP_NODELAY on new
+// client connections, or clears it so that small writes are batched with
+// Nagle's algorithm
+func setNoDelay(noDelay bool) func(net.Conn, http.ConnState) {
+	return func(conn net.Conn, state http.ConnState) {
+		if state != http.StateNew {
+			return
+		}
+		if tlsConn, ok := conn.(interface{ NetConn() net.Conn }); ok {
+			conn = tlsConn.NetConn()
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetNoDelay(noDelay)
+		}
+	}
+}
+
+// serve runs server until it is shut down
+func serve(server *http.Server) {
+	var err error
+	if server.TLSConfig != nil {
+		// The certificate is already in TLSConfig
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+// drainServers shuts the servers down together, waiting up to timeout in
+// all for in-flight requests to complete. Another signal on interrupt, or the
+// timeout running out, closes the remaining connections immediately.
+func drainServers(servers []*http.Server, timeout time.Duration, interrupt <-chan os.Signal) {
+	if timeout > 0 {
+		log.Printf("Waiting up to %s for in-flight requests to finish (signal again to stop now)", timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), max(timeout, 0))
+	defer cancel()
+	go func() {
+		select {
+		case <-interrupt:
+			log.Println("Second signal received, stopping now")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				if timeout > 0 {
+					log.Printf("Closing connections still active on %s after draining: %v", server.Addr, err)
+				}
+				if err := server.Close(); err != nil {
+					log.Printf("Error closing server on %s: %v", server.Addr, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// withLimits wraps b in the per-backend rate limiter and retry policy, when
+// configured. Retries sit outside the rate limiter so a retry waiting out its
+// backoff does not hold a backend slot.
+func withLimits(cfg *config.Config, name string, b backend.Backend) backend.Backend {
+	if cfg.RateLimit.Enabled {
+		b = backend.NewRateLimitedBackend(b, name, backend.RateLimitPolicy{
+			MaxConcurrent: cfg.RateLimit.MaxConcurrent,
+			MaxQueueDepth: cfg.RateLimit.MaxQueueDepth,
+			MaxRetryAfter: time.Duration(cfg.RateLimit.MaxRetryAfter) * time.Second,
+			QueueTimeout:  time.Duration(cfg.RateLimit.QueueTimeout) * time.Second,
+		})
+	}
+	if cfg.Retry.MaxAttempts <= 1 {
+		return b
+	}
+	return backend.NewRetryBackend(b, backend.RetryPolicy{
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		InitialBackoff: time.Duration(cfg.Retry.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(cfg.Retry.MaxBackoffMs) * time.Millisecond,
+		RetryOnStatus:  cfg.Retry.RetryOnStatus,
+	})
+}
+
+// runServe runs the proxy server until it is interrupted
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := flags.String("config", "config.toml", "Path to configuration file")
+	flags.Parse(args)
+	started := time.Now()
 
 	// Load configuration
 	log.Printf("Loading configuration from %s", *configPath)
@@ -58,12 +548,93 @@ func main() {
 	}
 
 	// Initialize database
-	log.Printf("Initializing database at %s", cfg.Database.Path)
-	db, err := database.New(cfg.Database.Path)
+	if cfg.Database.Path == database.MemoryPath {
+		log.Printf("Initializing in-memory database; logged requests are lost on exit")
+	} else {
+		log.Printf("Initializing database at %s", cfg.Database.Path)
+	}
+	db, err := openDatabase(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
+	if cfg.Database.EncryptionKey != "" {
+		log.Printf("Encrypting logged prompts, responses and raw bodies")
+	}
+
+	// Write log entries from a background goroutine; the deferred Close
+	// flushes the queue on shutdown
+	if cfg.Database.WriteQueueSize > 0 {
+		db.StartWriter(cfg.Database.WriteQueueSize, cfg.Database.WriteBatchSize)
+	}
+
+	// Keep multi-megabyte raw bodies out of the request log
+	if cfg.Database.MaxPayloadSize > 0 {
+		if err := db.SetPayloadLimit(cfg.Database.MaxPayloadSize<<10, cfg.Database.PayloadSpillDir); err != nil {
+			log.Fatalf("Failed to set up payload truncation: %v", err)
+		}
+		if cfg.Database.PayloadSpillDir != "" {
+			log.Printf("Truncating logged raw bodies over %d KB; full bodies go to %s", cfg.Database.MaxPayloadSize, cfg.Database.PayloadSpillDir)
+		} else {
+			log.Printf("Truncating logged raw bodies over %d KB", cfg.Database.MaxPayloadSize)
+		}
+	}
+
+	// Store raw bodies compressed to keep the database small
+	if cfg.Database.CompressPayloads {
+		db.SetCompressPayloads(true)
+		log.Printf("Compressing logged raw bodies")
+	}
+
+	// Mask PII in logged requests and responses
+	if cfg.PIIRedaction.Enabled {
+		patterns := make([]database.RedactPattern, 0, len(cfg.PIIRedaction.Patterns))
+		for _, p := range cfg.PIIRedaction.Patterns {
+			patterns = append(patterns, database.RedactPattern{Name: p.Name, Pattern: p.Pattern})
+		}
+		redactor, err := database.NewRedactor(cfg.PIIRedaction.Types, patterns)
+		if err != nil {
+			log.Fatalf("Failed to set up PII redaction: %v", err)
+		}
+		db.SetRedactor(redactor)
+		log.Printf("PII redaction enabled for the request log: %s, %d custom pattern(s)",
+			strings.Join(cfg.PIIRedaction.Types, ", "), len(patterns))
+		if cfg.ResponseCache.Enabled && cfg.ResponseCache.Storage == "sqlite" {
+			log.Printf("Warning: the SQLite response cache stores responses without PII redaction")
+		}
+	}
+
+	// Estimate the cost of logged requests
+	if len(cfg.Pricing) > 0 {
+		prices := make(map[string]database.ModelPrice, len(cfg.Pricing))
+		for model, price := range cfg.Pricing {
+			cachedPrompt := price.Prompt
+			if price.CachedPrompt != nil {
+				cachedPrompt = *price.CachedPrompt
+			}
+			prices[model] = database.ModelPrice{Prompt: price.Prompt, CachedPrompt: cachedPrompt, Completion: price.Completion}
+		}
+		db.SetPricing(database.NewPricing(prices))
+		log.Printf("Estimating request costs for %d priced model(s)", len(prices))
+	}
+
+	// Post alerts about failed requests and crossed thresholds to a webhook
+	if n := cfg.Notifications; n.WebhookURL != "" {
+		linkURL := notificationLinkURL(cfg)
+		notifier := notify.NewNotifier(n.WebhookURL, linkURL, notify.Rules{
+			OnBackendFailure: n.OnBackendFailure,
+			ErrorRate:        n.ErrorRate,
+			Latency:          time.Duration(n.LatencyMs) * time.Millisecond,
+			Cost:             n.Cost,
+			Window:           time.Duration(n.Window) * time.Second,
+			MinRequests:      n.MinRequests,
+		})
+		notifier.SetTimeout(time.Duration(n.Timeout) * time.Second)
+		entries, _ := db.Subscribe(256)
+		go notifier.Run(entries)
+		log.Printf("Webhook notifications enabled: backend failures %t, error rate %g%%, latency %dms, cost %g over %d seconds (0 = off), linking to %s",
+			n.OnBackendFailure, n.ErrorRate, n.LatencyMs, n.Cost, n.Window, linkURL)
+	}
 
 	// Start background cleanup task
 	cleanupDone := make(chan struct{})
@@ -77,99 +648,458 @@ func main() {
 	}
 
 	// Create backend based on configuration
-	var backendInstance backend.Backend
 	log.Printf("Initializing %s backend at %s", cfg.Backend.Type, cfg.Backend.Endpoint)
-
-	switch cfg.Backend.Type {
-	case "openai":
-		backendInstance = backend.NewOpenAIBackend(cfg.Backend.Endpoint, cfg.Backend.Timeout, cfg.BackendOpenAI.ForcePromptCache, cfg.Gemma4Fix.Enabled)
+	backendInstance, err := newBackend(cfg, cfg.Backend.Type, cfg.Backend.Endpoint, cfg.Backend.Timeout, cfg.Backend.Headers, cfg.Backend.TLS, cfg.Backend.Proxy)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if cfg.Retry.MaxAttempts > 1 {
+		log.Printf("Backend retries enabled: up to %d attempt(s), backoff %d-%dms, retrying status %v",
+			cfg.Retry.MaxAttempts, cfg.Retry.InitialBackoffMs, cfg.Retry.MaxBackoffMs, cfg.Retry.RetryOnStatus)
+	}
+	if cfg.RateLimit.Enabled {
+		log.Printf("Backend rate limiting enabled: max %d concurrent and %d queued request(s) per backend (0 = unlimited), queue timeout %ds (0 = none), waiting up to %ds for Retry-After",
+			cfg.RateLimit.MaxConcurrent, cfg.RateLimit.MaxQueueDepth, cfg.RateLimit.QueueTimeout, cfg.RateLimit.MaxRetryAfter)
+	}
+	primaryBackend := backendInstance
+	backendInstance = withLimits(cfg, cfg.Backend.Endpoint, backendInstance)
+	if len(cfg.Backend.Headers) > 0 {
+		log.Printf("Backend: sending %d extra header(s)", len(cfg.Backend.Headers))
+	}
+	if cfg.Backend.Proxy != "" {
+		log.Printf("Backend: outbound proxy %s", redactProxyURL(cfg.Backend.Proxy))
+	}
+	if cfg.Backend.TLS.ClientCert != "" {
+		log.Printf("Backend: presenting client certificate %s", cfg.Backend.TLS.ClientCert)
+	}
+	if cfg.Backend.TLS.InsecureSkipVerify {
+		log.Printf("Backend: TLS certificate verification disabled (insecure_skip_verify)")
+	}
+	if cfg.Backend.Type == "openrouter" && cfg.BackendOpenRouter.APIKey == "" {
+		log.Printf("OpenRouter backend: no api_key configured; requests will likely be rejected")
+	}
+	if cfg.Backend.Type == "openai" || cfg.Backend.Type == "vllm" || cfg.Backend.Type == "textgen" {
+		if cfg.BackendOpenAI.APIKey != "" {
+			log.Printf("OpenAI backend: sending API key")
+		}
 		if cfg.BackendOpenAI.ForcePromptCache {
 			log.Printf("OpenAI backend: prompt caching enabled")
 		}
 		if cfg.Gemma4Fix.Enabled {
 			log.Printf("OpenAI backend: gemma_4_fix enabled (Gemma 4 streaming-corruption mitigation)")
 		}
-	case "ollama":
-		backendInstance = backend.NewOllamaBackend(cfg.Backend.Endpoint, cfg.Backend.Timeout)
-	default:
-		log.Fatalf("Invalid backend type: %s", cfg.Backend.Type)
 	}
 
-	// Set up HTTP handlers
+	// Chain fallback backends behind the primary if failover is configured.
+	// Routes can also pick the primary or a named fallback on its own.
+	var failover *backend.FailoverBackend
+	healthCheckDone := make(chan struct{})
+	namedBackends := map[string]backend.Backend{"primary": backendInstance}
+	if len(cfg.Failover.Backends) > 0 {
+		targets := []backend.FailoverTarget{{Name: cfg.Backend.Endpoint, Backend: backendInstance}}
+		for _, fb := range cfg.Failover.Backends {
+			fallback, err := newBackend(cfg, fb.Type, fb.Endpoint, fb.Timeout, fb.Headers, fb.TLS, fb.Proxy)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			fallback = withLimits(cfg, fb.Endpoint, fallback)
+			if fb.Name != "" {
+				namedBackends[fb.Name] = fallback
+			}
+			targets = append(targets, backend.FailoverTarget{Name: fb.Endpoint, Backend: fallback})
+			log.Printf("Failover: %s backend at %s", fb.Type, fb.Endpoint)
+			if fb.Proxy != "" && fb.Proxy != cfg.Backend.Proxy {
+				log.Printf("Failover: %s uses outbound proxy %s", fb.Endpoint, redactProxyURL(fb.Proxy))
+			}
+		}
+		failover = backend.NewFailoverBackend(targets)
+		backendInstance = failover
+		if cfg.Failover.HealthCheckInterval > 0 {
+			interval := time.Duration(cfg.Failover.HealthCheckInterval) * time.Second
+			log.Printf("Failover: health checks every %d seconds", cfg.Failover.HealthCheckInterval)
+			go failover.StartHealthChecks(interval, 10*time.Second, healthCheckDone)
+		}
+	}
+	defer close(healthCheckDone)
+
+	// Spread requests across replicas, keeping each model on the replica
+	// that has it loaded
+	var balancer *backend.LoadBalanceBackend
+	if cfg.LoadBalance.Enabled {
+		replicas := make([]backend.LoadBalanceReplica, 0, len(cfg.LoadBalance.Backends))
+		for _, name := range cfg.LoadBalance.Backends {
+			replicas = append(replicas, backend.LoadBalanceReplica{Name: name, Backend: namedBackends[name]})
+		}
+		balancer = backend.NewLoadBalanceBackend(backendInstance, replicas, loadBalanceKeepAlive(cfg))
+		backendInstance = balancer
+		log.Printf("Load balancing enabled: requests spread across %s (keep_alive %s)", strings.Join(cfg.LoadBalance.Backends, ", "), cfg.LoadBalance.KeepAlive)
+	}
+
+	// Race requests on several backends, answering with whichever responds
+	// first. The entrants are the backends themselves, not the failover
+	// chain, so that a failing primary doesn't hand its copy to the other
+	// entrant too.
+	if cfg.Race.Enabled {
+		entrants := make([]backend.RaceEntrant, 0, len(cfg.Race.Backends))
+		for _, name := range cfg.Race.Backends {
+			entrants = append(entrants, backend.RaceEntrant{Name: name, Backend: namedBackends[name]})
+		}
+		backendInstance = backend.NewRaceBackend(backendInstance, entrants, cfg.Race.Models)
+		log.Printf("Race enabled: requests go to %s, the first to respond wins", strings.Join(cfg.Race.Backends, ", "))
+	}
+
+	// List the models of every backend in /api/tags and send requests for
+	// them where they are listed. It is the router's default, so routing
+	// rules still come first. The primary lists through the failover chain.
+	if cfg.ModelAggregation.Enabled {
+		sources := make([]backend.AggregateSource, 0, len(cfg.ModelAggregation.Backends))
+		for _, name := range cfg.ModelAggregation.Backends {
+			source := backend.AggregateSource{Name: name, Prefix: cfg.ModelAggregation.Prefixes[name], Backend: namedBackends[name]}
+			if name == "primary" {
+				source.Backend = backendInstance
+			}
+			sources = append(sources, source)
+		}
+		backendInstance = backend.NewAggregateBackend(backendInstance, sources, cfg.ModelAggregation.OnConflict == "prefix")
+		log.Printf("Model aggregation enabled: listing the models of %s (on conflict: %s)",
+			strings.Join(cfg.ModelAggregation.Backends, ", "), cfg.ModelAggregation.OnConflict)
+	}
+
+	// Pick the backend, model or rejection of each request by routing rules
+	tenantBackends := make(map[string]string)
+	for _, tenant := range cfg.Tenants {
+		if tenant.Backend != "" {
+			tenantBackends[tenant.Name] = tenant.Backend
+		}
+	}
+	var router *backend.RouterBackend
+	if len(cfg.Routes) > 0 || cfg.BackendOverride.Enabled || len(tenantBackends) > 0 {
+		routes := make([]backend.Route, 0, len(cfg.Routes))
+		for _, route := range cfg.Routes {
+			headers := make(map[string]*regexp.Regexp, len(route.Headers))
+			for name, pattern := range route.Headers {
+				headers[name] = regexp.MustCompile(pattern)
+			}
+			routes = append(routes, backend.Route{
+				Name:          route.Name,
+				Model:         optionalRegexp(route.Model),
+				Path:          optionalRegexp(route.Path),
+				Headers:       headers,
+				Content:       optionalRegexp(route.Content),
+				Backend:       route.Backend,
+				SetModel:      route.SetModel,
+				Reject:        route.Reject,
+				RejectMessage: route.RejectMessage,
+			})
+		}
+		router = backend.NewRouterBackend(backendInstance, namedBackends, routes)
+		router.SetTenantBackends(tenantBackends)
+		if cfg.BackendOverride.Enabled {
+			router.SetOverrideHeader(cfg.BackendOverride.Header, cfg.BackendOverride.Backends)
+			log.Printf("Backend override enabled: clients can pick a backend with the %s header", cfg.BackendOverride.Header)
+		}
+		backendInstance = router
+		if len(routes) > 0 {
+			log.Printf("Routing enabled: %d route(s)", len(routes))
+		}
+	}
+
+	// Copy requests to the shadow backend, logging its responses for comparison
+	if cfg.Shadow.Enabled {
+		shadow, err := newBackend(cfg, cfg.Shadow.Type, cfg.Shadow.Endpoint, cfg.Shadow.Timeout, cfg.Shadow.Headers, cfg.Shadow.TLS, cfg.Shadow.Proxy)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		backendInstance = backend.NewShadowBackend(backendInstance, shadow, cfg.Shadow.Model, cfg.Shadow.SampleRate,
+			configSeconds(cfg.Shadow.Timeout), handlers.NewShadowLogger(db, cfg.Shadow.Type))
+		log.Printf("Shadow traffic: copying %g of requests to %s backend at %s", cfg.Shadow.SampleRate, cfg.Shadow.Type, cfg.Shadow.Endpoint)
+	}
+
+	// Load models in the background so the first requests don't wait for them
+	if len(cfg.Warmup.Models) > 0 {
+		go warmUp(cfg, primaryBackend)
+	}
+
+	// Set up HTTP handlers. The web UI and admin APIs go on a mux of their
+	// own when they are served on a separate port.
 	mux := http.NewServeMux()
+	adminMux := mux
+	if cfg.Server.AdminPort > 0 {
+		adminMux = http.NewServeMux()
+	}
+	// Tenants sharing the admin routes' listener can't be told from an
+	// administrator by the absence of their key, so administrators present
+	// one of their own
+	sharedWithTenants := len(cfg.Tenants) > 0 && cfg.Server.AdminPort == 0
+	admin := handlers.NewAdminAccess(cfg.Server.AdminAPIKeys, sharedWithTenants)
+	if sharedWithTenants && len(cfg.Server.AdminAPIKeys) == 0 {
+		log.Printf("Warning: tenants share the API port with the web UI and no server.admin_api_keys are set, so only tenants' scoped views of the logs are available")
+	}
+
+	// Wrap the backend in the concurrency scheduler if a limit is configured
+	if cfg.Scheduler.MaxConcurrentRequests > 0 {
+		scheduler := backend.NewScheduler(cfg.Scheduler.MaxConcurrentRequests, cfg.Scheduler.DefaultWeight, cfg.Scheduler.Weights)
+		scheduler.SetQueueLimits(cfg.Scheduler.MaxQueueDepth, time.Duration(cfg.Scheduler.QueueTimeout)*time.Second)
+		backendInstance = backend.NewScheduledBackend(backendInstance, scheduler)
+		adminMux.Handle("/api/scheduler", admin.Only(handlers.NewSchedulerStatsHandler(scheduler)))
+		log.Printf("Backend scheduler enabled: max %d concurrent request(s), %d weighted key(s)",
+			cfg.Scheduler.MaxConcurrentRequests, len(cfg.Scheduler.Weights))
+	}
+
+	// Serve repeated non-streaming requests from the response cache. It sits
+	// outside the scheduler so cache hits don't wait for a slot.
+	if cfg.ResponseCache.Enabled {
+		var store backend.ResponseCacheStore = backend.NewMemoryResponseCache()
+		if cfg.ResponseCache.Storage == "sqlite" {
+			store = db
+		}
+		cache := backend.NewCachingBackend(backendInstance, store, time.Duration(cfg.ResponseCache.TTL)*time.Second, cfg.ResponseCache.MaxEntries)
+		if router != nil {
+			cache.SetRouter(router)
+		}
+		backendInstance = cache
+		log.Printf("Response cache enabled: %s storage, keeping up to %d response(s) for %d seconds",
+			cfg.ResponseCache.Storage, cfg.ResponseCache.MaxEntries, cfg.ResponseCache.TTL)
+	}
+
+	// Rewrite generated text with the response transform pipeline
+	if len(cfg.ResponseTransforms) > 0 {
+		steps := make([]backend.ResponseTransformStep, 0, len(cfg.ResponseTransforms))
+		for _, transform := range cfg.ResponseTransforms {
+			step := backend.ResponseTransformStep{
+				Type:    transform.Type,
+				Replace: transform.Replace,
+				Words:   transform.Words,
+			}
+			if transform.Pattern != "" {
+				step.Pattern = regexp.MustCompile(transform.Pattern)
+			}
+			steps = append(steps, step)
+		}
+		backendInstance = backend.NewResponseTransformBackend(backendInstance, steps)
+		log.Printf("Response transforms enabled: %d step(s)", len(steps))
+	}
+
+	// Turn tool calls written as text into structured ones. Content filters
+	// sit outside so they see the repaired reply.
+	if cfg.ToolCallRepair.Enabled {
+		backendInstance = backend.NewToolCallRepairBackend(backendInstance, cfg.ToolCallRepair.Models)
+		if len(cfg.ToolCallRepair.Models) > 0 {
+			log.Printf("Tool call repair enabled for %d model(s)", len(cfg.ToolCallRepair.Models))
+		} else {
+			log.Printf("Tool call repair enabled for all models")
+		}
+	}
+
+	// Apply regex content filters. They sit outside the response cache so
+	// that cached responses are filtered with the current rules.
+	if len(cfg.ContentFilters) > 0 {
+		rules := make([]backend.ContentFilterRule, 0, len(cfg.ContentFilters))
+		for _, filter := range cfg.ContentFilters {
+			rules = append(rules, backend.ContentFilterRule{
+				Name:     filter.Name,
+				Pattern:  regexp.MustCompile(filter.Pattern),
+				Replace:  filter.Replace,
+				Request:  filter.Direction != "response",
+				Response: filter.Direction != "request",
+			})
+		}
+		backendInstance = backend.NewContentFilterBackend(backendInstance, rules)
+		log.Printf("Content filters enabled: %d rule(s)", len(rules))
+	}
+
+	// Run external hooks outside the content filters, so that they see the
+	// request as the client sent it and the reply as the client gets it
+	if len(cfg.Hooks) > 0 {
+		hooks := make([]backend.Hook, 0, len(cfg.Hooks))
+		for _, hook := range cfg.Hooks {
+			hooks = append(hooks, backend.Hook{
+				Name:     hook.Name,
+				Command:  hook.Command,
+				Request:  hook.Stage != "response",
+				Response: hook.Stage != "request",
+				Timeout:  configSeconds(hook.Timeout),
+				FailOpen: hook.OnError == "ignore",
+			})
+		}
+		backendInstance = backend.NewHookBackend(backendInstance, hooks)
+		log.Printf("Hooks enabled: %d hook(s)", len(hooks))
+	}
+
+	// Trim chat requests that don't fit in the model's context window. It
+	// sits inside the alias wrapper so context sizes are looked up by
+	// backend model name.
+	if cfg.ContextTrim.Enabled {
+		backendInstance = backend.NewContextTrimBackend(backendInstance, backend.ContextTrimPolicy{
+			ContextSizes:  cfg.ContextTrim.ContextSizes,
+			ReserveTokens: cfg.ContextTrim.ReserveTokens,
+			Mode:          cfg.ContextTrim.Mode,
+			KeepFirst:     cfg.ContextTrim.Strategy == "keep_first",
+			SummaryTokens: cfg.ContextTrim.SummaryTokens,
+		})
+		log.Printf("Context trim enabled: %s mode, %d context size(s), %d token(s) reserved",
+			cfg.ContextTrim.Mode, len(cfg.ContextTrim.ContextSizes), cfg.ContextTrim.ReserveTokens)
+	}
+
+	// Serve model lists and descriptions from a short-lived cache. It sits
+	// inside the access lists and aliases so that changing either takes
+	// effect straight away.
+	var modelCache *backend.ModelCacheBackend
+	if cfg.ModelCache.Enabled {
+		modelCache = backend.NewModelCacheBackend(backendInstance, time.Duration(cfg.ModelCache.TTL)*time.Second)
+		backendInstance = modelCache
+		log.Printf("Model cache enabled: keeping model lists and descriptions for %d seconds", cfg.ModelCache.TTL)
+	}
+
+	// Keep clients to the models they may use. It sits inside the alias
+	// wrapper so the access lists name backend models, and outside the
+	// router so models picked by routing rules are always allowed.
+	if len(cfg.ModelAccess.Allow) > 0 || len(cfg.ModelAccess.Deny) > 0 {
+		backendInstance = backend.NewModelAccessBackend(backendInstance, wholeNameRegexps(cfg.ModelAccess.Allow), wholeNameRegexps(cfg.ModelAccess.Deny))
+		log.Printf("Model access lists enabled: %d allowed, %d denied pattern(s)", len(cfg.ModelAccess.Allow), len(cfg.ModelAccess.Deny))
+	}
+
+	// Merge small streamed deltas into larger chunks, outside everything that
+	// rewrites the stream so clients get the final text
+	if cfg.StreamCoalesce.Enabled {
+		backendInstance = backend.NewCoalesceBackend(backendInstance, max(cfg.StreamCoalesce.MinChars, 0), time.Duration(max(cfg.StreamCoalesce.FlushIntervalMs, 0))*time.Millisecond)
+		log.Printf("Stream coalescing enabled: chunks of at least %d character(s) or every %d ms", cfg.StreamCoalesce.MinChars, cfg.StreamCoalesce.FlushIntervalMs)
+	}
+
+	// Rewrite model aliases outermost so the response cache and per-model
+	// backend settings see the backend model name. The alias table is
+	// always installed so a config reload can add aliases.
+	aliasBackend := backend.NewAliasBackend(backendInstance, cfg.ModelAliases)
+	backendInstance = aliasBackend
+	if len(cfg.ModelAliases) > 0 {
+		log.Printf("Model aliases enabled: %d alias(es)", len(cfg.ModelAliases))
+	}
 
 	generateHandler := handlers.NewGenerateHandler(backendInstance, db, cfg)
 	chatHandler := handlers.NewChatHandler(backendInstance, db, cfg)
 	modelsHandler := handlers.NewModelsHandler(backendInstance)
 	showHandler := handlers.NewShowHandler(backendInstance)
 	openAIChatHandler := handlers.NewOpenAIChatCompletionsHandler(backendInstance, db, cfg)
+	openAICompletionsHandler := handlers.NewOpenAICompletionsHandler(backendInstance, db, cfg)
 	openAIModelsHandler := handlers.NewOpenAIModelsHandler(backendInstance)
+	embeddingCache := handlers.NewEmbeddingCache(db, cfg.EmbeddingCache.Enabled, cfg.EmbeddingCache.MaxEntries)
+	embedHandler := handlers.NewEmbedHandler(backendInstance, db, cfg, embeddingCache)
+	openAIEmbeddingsHandler := handlers.NewOpenAIEmbeddingsHandler(backendInstance, db, cfg, embeddingCache)
+	if cfg.EmbeddingCache.Enabled {
+		log.Printf("Embedding cache enabled: keeping up to %d vector(s)", cfg.EmbeddingCache.MaxEntries)
+	}
 
-	// Prepare config data for web UI
-	homeData := map[string]interface{}{
-		"BackendType":          cfg.Backend.Type,
-		"BackendEndpoint":      cfg.Backend.Endpoint,
-		"ServerHost":           cfg.Server.Host,
-		"ServerPort":           cfg.Server.Port,
-		"Timeout":              cfg.Backend.Timeout,
-		"DatabasePath":         cfg.Database.Path,
-		"EnableCORS":           cfg.Server.EnableCORS,
-		"ToolBlacklist":        cfg.Backend.ToolBlacklist,
-		"PromptCacheEnabled":   cfg.BackendOpenAI.ForcePromptCache,
-		"MaxTokensPolicy":      cfg.RequestSanitization.MaxTokensPolicy,
-		"MaxTokensLimit":       cfg.RequestSanitization.MaxTokensLimit,
-		"StreamOverrideMode":   cfg.StreamOverride.Mode,
-		"Gemma4FixEnabled":     cfg.Gemma4Fix.Enabled,
-		"TextInjectionEnabled": cfg.ChatTextInjection.Enabled,
-		"TextInjectionText":    cfg.ChatTextInjection.Text,
-		"TextInjectionMode":    cfg.ChatTextInjection.Mode,
+	// Open additional read-only log sources for the web UI
+	var logSources []handlers.LogSource
+	for _, src := range cfg.Federation.Sources {
+		switch src.Type {
+		case "sqlite":
+			sourceDB, err := database.OpenReadOnly(src.Path)
+			if err != nil {
+				log.Fatalf("Failed to open log source %s: %v", src.Name, err)
+			}
+			defer sourceDB.Close()
+			logSources = append(logSources, handlers.NewDBLogSource(src.Name, sourceDB))
+			log.Printf("Log source %s: SQLite database at %s", src.Name, src.Path)
+		case "remote":
+			logSources = append(logSources, handlers.NewRemoteLogSource(src.Name, src.URL, src.Timeout))
+			log.Printf("Log source %s: remote proxy at %s", src.Name, src.URL)
+		}
 	}
 
-	webHandler := handlers.NewWebHandler(db, homeData)
+	webHandler := handlers.NewWebHandler(db, func() interface{} { return homeData(cfg.Current()) }, logSources...)
+	webHandler.SetRetryHandler(mux)
 	logsAPIHandler := handlers.NewLogsAPIHandler(db)
 
 	mux.Handle("/api/generate", generateHandler)
 	mux.Handle("/api/chat", chatHandler)
 	mux.Handle("/api/tags", modelsHandler)
 	mux.Handle("/api/show", showHandler)
+	mux.Handle("/api/version", handlers.NewVersionHandler(cfg))
+	mux.Handle("/api/ps", handlers.NewPsHandler(backendInstance, db, cfg))
+	// Model management goes straight to the primary backend, over the same
+	// kind of connection as its other requests
+	managementTransport, err := backendTransport(cfg, cfg.Backend.TLS, cfg.Backend.Proxy)
+	if err != nil {
+		log.Fatalf("backend %s: %v", cfg.Backend.Endpoint, err)
+	}
+	for _, route := range []struct{ path, method string }{
+		{"/api/pull", http.MethodPost},
+		{"/api/delete", http.MethodDelete},
+		{"/api/copy", http.MethodPost},
+	} {
+		management := handlers.NewModelManagementHandler(route.path, route.method, db, cfg)
+		management.SetTransport(managementTransport)
+		mux.Handle(route.path, management)
+	}
 	mux.Handle("/v1/chat/completions", openAIChatHandler)
+	mux.Handle("/v1/completions", openAICompletionsHandler)
 	mux.Handle("/v1/models", openAIModelsHandler)
+	mux.Handle("/api/embed", embedHandler)
+	mux.Handle("/v1/embeddings", openAIEmbeddingsHandler)
 
-	// Web UI endpoints
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// Web UI and admin endpoints. The request log views and usage are
+	// scoped to the tenant making the request; the rest are only for
+	// administrators.
+	adminMux.Handle("/api/embedding_cache", admin.Only(embeddingCache))
+	if balancer != nil {
+		adminMux.Handle("/api/load_balance", admin.Only(handlers.NewLoadBalanceHandler(balancer)))
+	}
+	if modelCache != nil {
+		adminMux.Handle("/api/model_cache", admin.Only(handlers.NewModelCacheHandler(modelCache, db)))
+	}
+	adminMux.Handle("/api/prompt_cache", admin.Only(handlers.NewPromptCacheStatsHandler(db, cfg.BackendOpenAI.ForcePromptCache)))
+	adminMux.Handle("/api/usage", admin.Scoped(handlers.NewUsageHandler(db)))
+	adminMux.Handle("/", admin.Only(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
 			http.NotFound(w, r)
 			return
 		}
 		webHandler.HomeHandler(w, r)
-	})
-	mux.HandleFunc("/logs", webHandler.IndexHandler)
-	mux.HandleFunc("/logs/details", webHandler.DetailsHandler)
-	mux.HandleFunc("/logs/download", webHandler.DownloadHandler)
-	mux.Handle("/api/logs", logsAPIHandler)
-	mux.Handle("/api/logs/", logsAPIHandler)
-	mux.HandleFunc("/favicon.ico", webHandler.FaviconHandler)
-	mux.HandleFunc("/static/", webHandler.StaticHandler)
-
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "OK")
-	})
+	})))
+	adminMux.Handle("/logs", admin.Scoped(http.HandlerFunc(webHandler.IndexHandler)))
+	adminMux.Handle("/logs/details", admin.Scoped(http.HandlerFunc(webHandler.DetailsHandler)))
+	adminMux.Handle("/logs/download", admin.Scoped(http.HandlerFunc(webHandler.DownloadHandler)))
+	adminMux.Handle("/logs/har", admin.Scoped(http.HandlerFunc(webHandler.HARHandler)))
+	adminMux.Handle("/logs/diff", admin.Scoped(http.HandlerFunc(webHandler.DiffHandler)))
+	adminMux.Handle("/logs/delete", admin.Only(http.HandlerFunc(webHandler.DeleteHandler)))
+	adminMux.Handle("/logs/errors", admin.Scoped(http.HandlerFunc(webHandler.ErrorsHandler)))
+	adminMux.Handle("/logs/retry", admin.Only(http.HandlerFunc(webHandler.RetryHandler)))
+	adminMux.Handle("/logs/live", admin.Scoped(http.HandlerFunc(webHandler.LiveHandler)))
+	adminMux.Handle("/logs/live/events", admin.Scoped(http.HandlerFunc(webHandler.LiveEventsHandler)))
+	adminMux.Handle("/stats", admin.Scoped(http.HandlerFunc(webHandler.StatsHandler)))
+	adminMux.Handle("/audit", admin.Only(http.HandlerFunc(webHandler.AuditHandler)))
+	adminMux.Handle("/api/logs", admin.Scoped(logsAPIHandler))
+	adminMux.Handle("/api/logs/", admin.Scoped(logsAPIHandler))
+	adminMux.HandleFunc("/favicon.ico", webHandler.FaviconHandler)
+	adminMux.HandleFunc("/static/", webHandler.StaticHandler)
+
+	// Health check endpoints, on both listeners
+	healthHandler := handlers.NewHealthHandler(failover)
+	livenessHandler := handlers.NewLivenessHandler(started)
+	readinessHandler := handlers.NewReadinessHandler(backendInstance, db, started)
+	mux.Handle("/health", healthHandler)
+	mux.Handle("/healthz", livenessHandler)
+	mux.Handle("/readyz", readinessHandler)
+	if adminMux != mux {
+		adminMux.Handle("/health", healthHandler)
+		adminMux.Handle("/healthz", livenessHandler)
+		adminMux.Handle("/readyz", readinessHandler)
+	}
 
 	// Start HTTP server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 
 	// Apply middlewares
-	var handler http.Handler = mux
-
-	// Apply request logging middleware if verbose is enabled
-	handler = middleware.RequestLogging(cfg.Server.Verbose)(handler)
-
-	// Apply CORS middleware if enabled
+	handler := withMiddleware(cfg, db, mux)
+	if cfg.Server.GzipResponses {
+		log.Printf("Gzip response compression enabled")
+	}
 	if cfg.Server.EnableCORS {
-		handler = middleware.CORS(handler)
-		log.Printf("CORS enabled")
+		log.Printf("CORS enabled for origins %s", strings.Join(cfg.Server.CORS.AllowedOrigins, ", "))
+	}
+	if access := cfg.Server.Access; len(access.Allow) > 0 || len(access.Deny) > 0 {
+		log.Printf("Access control enabled: %d allow and %d deny rule(s)", len(access.Allow), len(access.Deny))
 	}
 
 	if cfg.Server.Verbose {
@@ -185,28 +1115,78 @@ func main() {
 		log.Printf("Raw response logging enabled - raw JSON responses will be logged to stdout")
 	}
 
+	tlsConfig, err := serverTLSConfig(cfg.Server)
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
+	}
+	if cfg.Server.TLSSelfSigned {
+		log.Printf("Serving HTTPS with a self-signed certificate - for development only")
+	}
+
+	connState := setNoDelay(!cfg.Server.Nagle)
 	server := &http.Server{
-		Addr:    addr,
-		Handler: handler,
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+		ConnState: connState,
 	}
+	// The web UI gets a server of its own if it has a separate port
+	uiServer := server
+	if adminMux != mux {
+		uiServer = &http.Server{
+			Addr:      fmt.Sprintf("%s:%d", cfg.Server.AdminHost, cfg.Server.AdminPort),
+			Handler:   withMiddleware(cfg, db, adminMux),
+			TLSConfig: tlsConfig,
+			ConnState: connState,
+		}
+	}
+	// Live tail streams never finish on their own, so end them when draining
+	uiServer.RegisterOnShutdown(db.CloseSubscriptions)
 
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		log.Printf("Starting LLM proxy server on %s", addr)
+		scheme := "http"
+		if tlsConfig != nil {
+			scheme = "https"
+		}
+		log.Printf("Starting LLM proxy server on %s://%s", scheme, addr)
+		if uiServer != server {
+			log.Printf("Serving the web UI and admin endpoints on %s://%s", scheme, uiServer.Addr)
+		}
 		log.Printf("Backend: %s (%s)", cfg.Backend.Type, cfg.Backend.Endpoint)
 		log.Printf("Database: %s", cfg.Database.Path)
-
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
-		}
+		serve(server)
 	}()
+	if uiServer != server {
+		go serve(uiServer)
+	}
+
+	// Reload tunable settings on SIGHUP or when the config file changes
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	watchDone := make(chan struct{})
+	go watchConfig(*configPath, cfg, db, aliasBackend, reloadChan, watchDone)
+	if cfg.Server.ConfigWatchInterval > 0 {
+		log.Printf("Reloading configuration on SIGHUP or when %s changes", *configPath)
+	} else {
+		log.Printf("Reloading configuration on SIGHUP")
+	}
 
 	// Wait for interrupt signal
 	<-sigChan
 	log.Println("Shutting down server...")
+	close(watchDone)
+
+	// Stop accepting new requests and let in-flight ones, including streams,
+	// finish and be logged before the database is closed
+	servers := []*http.Server{server}
+	if uiServer != server {
+		servers = append(servers, uiServer)
+	}
+	drainServers(servers, time.Duration(cfg.Server.ShutdownTimeout)*time.Second, sigChan)
 
 	// Stop cleanup task
 	if cfg.Database.CleanupInterval > 0 && cfg.Database.MaxRequests > 0 {
@@ -214,9 +1194,5 @@ func main() {
 		<-cleanupDone
 	}
 
-	if err := server.Close(); err != nil {
-		log.Printf("Error closing server: %v", err)
-	}
-
 	log.Println("Server stopped")
 }
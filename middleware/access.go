@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// AccessList decides which client addresses may use the proxy. An address
+// on the deny list is always refused; otherwise it is allowed if the allow
+// list is empty or contains it.
+type AccessList struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewAccessList parses allow and deny rules, each a CIDR range such as
+// "192.168.1.0/24" or a single address.
+func NewAccessList(allow, deny []string) (*AccessList, error) {
+	list := &AccessList{}
+	var err error
+	if list.allow, err = parseAccessRules(allow); err != nil {
+		return nil, err
+	}
+	if list.deny, err = parseAccessRules(deny); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ParseAccessRule parses one allow or deny rule: a CIDR range or a single
+// IP address
+func ParseAccessRule(rule string) (netip.Prefix, error) {
+	rule = strings.TrimSpace(rule)
+	if strings.Contains(rule, "/") {
+		prefix, err := netip.ParsePrefix(rule)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%q is not a CIDR range or IP address", rule)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(rule)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not a CIDR range or IP address", rule)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseAccessRules(rules []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(rules))
+	for _, rule := range rules {
+		prefix, err := ParseAccessRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Allowed reports whether addr may use the proxy
+func (l *AccessList) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range l.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, prefix := range l.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Access middleware refuses requests from addresses the list doesn't allow
+// with 403 Forbidden, calling denied (if not nil) for each one. The address
+// is the connection's remote address; forwarding headers are not trusted.
+func Access(list *AccessList, denied func(r *http.Request, addr netip.Addr)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := remoteAddr(r)
+			if addr.IsValid() && list.Allowed(addr) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if denied != nil {
+				denied(r, addr)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"access denied"}` + "\n"))
+		})
+	}
+}
+
+// remoteAddr returns the client's IP address, or the zero Addr if
+// RemoteAddr can't be parsed
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	// Prefixes never contain an address with an IPv6 zone
+	return addr.WithZone("").Unmap()
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestAccessListAllowsAndDenies(t *testing.T) {
+	list, err := NewAccessList([]string{"192.168.1.0/24", "10.0.0.5", "fd00::/8"}, []string{"192.168.1.13"})
+	if err != nil {
+		t.Fatalf("NewAccessList() error = %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"192.168.1.20", true},
+		{"192.168.1.13", false},
+		{"192.168.2.1", false},
+		{"10.0.0.5", true},
+		{"10.0.0.6", false},
+		{"::ffff:192.168.1.20", true},
+		{"fd00::1", true},
+		{"fe80::1", false},
+	}
+	for _, tt := range tests {
+		if got := list.Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	// With no allow list, anything not denied is allowed
+	denyOnly, err := NewAccessList(nil, []string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatalf("NewAccessList() error = %v", err)
+	}
+	if !denyOnly.Allowed(netip.MustParseAddr("198.51.100.1")) || denyOnly.Allowed(netip.MustParseAddr("203.0.113.9")) {
+		t.Error("deny-only list did not allow everything except the denied range")
+	}
+
+	if _, err := NewAccessList([]string{"192.168.1.0/33"}, nil); err == nil {
+		t.Error("NewAccessList() with an invalid range error = nil")
+	}
+}
+
+func TestAccessRefusesDeniedClients(t *testing.T) {
+	list, err := NewAccessList([]string{"127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("NewAccessList() error = %v", err)
+	}
+	var denied []netip.Addr
+	handler := Access(list, func(r *http.Request, addr netip.Addr) {
+		denied = append(denied, addr)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	req.RemoteAddr = "127.0.0.1:51234"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("allowed client status = %d, want 204", rec.Code)
+	}
+
+	req.RemoteAddr = "192.168.1.50:51234"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || rec.Body.String() != "{\"error\":\"access denied\"}\n" {
+		t.Fatalf("denied client got %d %q, want 403 access denied", rec.Code, rec.Body.String())
+	}
+	if len(denied) != 1 || denied[0] != netip.MustParseAddr("192.168.1.50") {
+		t.Fatalf("denied callback got %v", denied)
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// MaxRequestBody middleware limits request bodies to the size returned by
+// limit, in bytes (negative = no limit). Reading past the limit fails with an
+// *http.MaxBytesError, which handlers report as 413 Request Entity Too Large.
+func MaxRequestBody(limit func() int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n := limit(); n >= 0 && r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
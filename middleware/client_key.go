@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"llm_proxy/backend"
+)
+
+// ClientKey middleware extracts the API key a client presented (either an
+// "Authorization: Bearer <key>" header or an "X-API-Key" header) and stores
+// it on the request context for backend scheduling.
+func ClientKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := clientKeyFromRequest(r); key != "" {
+			r = r.WithContext(backend.WithClientKey(r.Context(), key))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientKeyFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			return strings.TrimSpace(auth[len("Bearer "):])
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
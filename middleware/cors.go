@@ -2,24 +2,84 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-// CORS middleware adds CORS headers to allow cross-origin requests
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-
-		// Handle preflight OPTIONS request
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// CORSOptions control which cross-origin browser requests are allowed
+type CORSOptions struct {
+	AllowedOrigins   []string // "*" allows any origin; "https://*.example.com" any subdomain
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int // Seconds a preflight response may be cached; negative = not sent
+}
+
+// CORS middleware adds CORS headers to responses to requests from allowed
+// origins. Requests from other origins are served without them, so browsers
+// won't let the calling page read the response.
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	headers := strings.Join(opts.AllowedHeaders, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// The response depends on the origin unless every origin gets "*"
+			anyOrigin := !opts.AllowCredentials && originAllowed(opts.AllowedOrigins, "*")
+			if !anyOrigin {
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if origin != "" && originAllowed(opts.AllowedOrigins, origin) {
+				// Set CORS headers
+				if anyOrigin {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				if opts.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if preflight {
+					w.Header().Set("Access-Control-Allow-Methods", methods)
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+					if opts.MaxAge >= 0 {
+						w.Header().Set("Access-Control-Max-Age", strconv.Itoa(opts.MaxAge))
+					}
+				}
+			}
+
+			// Handle preflight OPTIONS request
+			if preflight {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 
-		// Call the next handler
-		next.ServeHTTP(w, r)
-	})
+			// Call the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// originAllowed reports whether origin matches one of allowed
+func originAllowed(allowed []string, origin string) bool {
+	for _, pattern := range allowed {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		// "https://*.example.com" matches any subdomain of example.com
+		scheme, host, ok := strings.Cut(pattern, "://*.")
+		if !ok {
+			continue
+		}
+		originScheme, originHost, ok := strings.Cut(origin, "://")
+		if ok && strings.EqualFold(scheme, originScheme) && len(originHost) > len(host)+1 &&
+			strings.HasSuffix(strings.ToLower(originHost), "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSAllowsConfiguredOrigins(t *testing.T) {
+	handler := CORS(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://chat.example.org", true},
+		{"https://example.org", false},
+		{"http://app.example.com", false},
+		{"https://evil.com", false},
+		{"https://app.example.com.evil.com", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodOptions, "/api/chat", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: preflight status = %d, want 200", tt.origin, rec.Code)
+		}
+		got := rec.Header().Get("Access-Control-Allow-Origin")
+		if tt.allowed {
+			if got != tt.origin || rec.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+				rec.Header().Get("Access-Control-Allow-Methods") != "GET, POST" || rec.Header().Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("%s: preflight headers = %v", tt.origin, rec.Header())
+			}
+		} else if got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want none", tt.origin, got)
+		}
+	}
+
+	// Actual requests reach the handler whether or not the origin is allowed
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTeapot || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("status = %d, headers = %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	handler := CORS(CORSOptions{AllowedOrigins: []string{"*"}, MaxAge: -1})(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodOptions, "/v1/models", nil)
+	req.Header.Set("Origin", "https://anywhere.test")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if _, ok := rec.Header()["Access-Control-Max-Age"]; ok {
+		t.Errorf("Access-Control-Max-Age sent with max_age -1")
+	}
+}
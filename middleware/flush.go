@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimedFlush limits how often streamed responses are flushed to the client.
+// A flush within interval of the previous one is put off until interval has
+// passed, so the chunks written in between go out together instead of one
+// small packet each.
+func TimedFlush(interval time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tw := &timedFlushWriter{ResponseWriter: w, interval: interval}
+			defer tw.finish()
+			next.ServeHTTP(tw, r)
+		})
+	}
+}
+
+// timedFlushWriter defers flushes that come too soon after the last. The
+// deferred flush runs on a timer, so writes are locked against it.
+type timedFlushWriter struct {
+	http.ResponseWriter
+	interval time.Duration
+
+	mu       sync.Mutex
+	last     time.Time   // When the response was last flushed
+	timer    *time.Timer // A deferred flush (nil = none)
+	finished bool        // The handler has returned
+}
+
+func (w *timedFlushWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timedFlushWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher. It flushes now if interval has passed since
+// the last flush, otherwise once it has.
+func (w *timedFlushWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		return
+	}
+	if wait := w.interval - time.Since(w.last); wait > 0 {
+		w.timer = time.AfterFunc(wait, w.deferredFlush)
+		return
+	}
+	w.flush()
+}
+
+func (w *timedFlushWriter) deferredFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if !w.finished {
+		w.flush()
+	}
+}
+
+func (w *timedFlushWriter) flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	w.last = time.Now()
+}
+
+// finish cancels a deferred flush once the handler returns; the server
+// sends the rest of the response itself
+func (w *timedFlushWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finished = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
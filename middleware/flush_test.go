@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushCounter records the body written before each flush
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushes []string
+}
+
+func (f *flushCounter) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flushes = append(f.flushes, f.Body.String())
+}
+
+func (f *flushCounter) flushed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.flushes...)
+}
+
+func TestTimedFlushBatchesChunks(t *testing.T) {
+	w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+	handler := TimedFlush(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, chunk := range []string{"a", "b", "c"} {
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+		}
+		time.Sleep(150 * time.Millisecond)
+		w.Write([]byte("d"))
+		w.(http.Flusher).Flush()
+	}))
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	want := []string{"a", "abc", "abcd"}
+	got := w.flushed()
+	if len(got) != len(want) {
+		t.Fatalf("flushes = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("flushes = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestTimedFlushDropsDeferredFlushAfterHandler(t *testing.T) {
+	w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+	handler := TimedFlush(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("b"))
+		w.(http.Flusher).Flush()
+	}))
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	time.Sleep(50 * time.Millisecond)
+
+	if got := w.flushed(); len(got) != 1 || w.Body.String() != "ab" {
+		t.Fatalf("flushes = %q, body = %q; want one flush and the whole body", got, w.Body.String())
+	}
+}
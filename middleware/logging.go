@@ -38,10 +38,11 @@ func (rw *responseWriter) Flush() {
 }
 
 // RequestLogging middleware logs every request and response status code
-func RequestLogging(verbose bool) func(http.Handler) http.Handler {
+// while verbose returns true
+func RequestLogging(verbose func() bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !verbose {
+			if !verbose() {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"llm_proxy/backend"
+)
+
+// RequestInfo middleware stores the request path, headers, client address
+// and user on the request context, for backend routing rules and the request
+// log. The user is the one userKeys (API key -> user name) gives for the key
+// the client presents, or else is named by the userHeader header: a client
+// can't name itself someone else when its key says who it is.
+// keyNames (API key -> name) names the key itself, so the log can say which
+// key was used without recording it.
+func RequestInfo(userHeader string, userKeys map[string]string, keyNames map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := backend.RequestInfo{
+				Path:     r.URL.Path,
+				Header:   r.Header,
+				ClientIP: r.RemoteAddr,
+			}
+			if addr := remoteAddr(r); addr.IsValid() {
+				info.ClientIP = addr.String()
+			}
+			if key := clientKeyFromRequest(r); key != "" {
+				info.User = userKeys[key]
+				info.KeyName = keyNames[key]
+			}
+			if info.User == "" && userHeader != "" {
+				info.User = strings.TrimSpace(r.Header.Get(userHeader))
+			}
+			next.ServeHTTP(w, r.WithContext(backend.WithRequestInfo(r.Context(), info)))
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"llm_proxy/backend"
+)
+
+func TestRequestInfoIdentifiesUsers(t *testing.T) {
+	var got backend.RequestInfo
+	handler := RequestInfo("X-User", map[string]string{"key-a": "alice"}, map[string]string{"key-a": "user alice"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = backend.RequestInfoFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		user, key, want string
+	}{
+		{"", "", ""},
+		{"bob", "", "bob"},
+		{"", "key-a", "alice"},
+		{"bob", "key-a", "alice"},
+		{"", "key-b", ""},
+		{"bob", "key-b", "bob"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		req.RemoteAddr = "[::ffff:10.0.0.7]:51234"
+		if tt.user != "" {
+			req.Header.Set("X-User", tt.user)
+		}
+		if tt.key != "" {
+			req.Header.Set("X-API-Key", tt.key)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got.User != tt.want || got.ClientIP != "10.0.0.7" || got.Path != "/api/chat" {
+			t.Errorf("user %q with key %q: got %+v, want user %q from 10.0.0.7", tt.user, tt.key, got, tt.want)
+		}
+		if wantKey := map[string]string{"key-a": "user alice"}[tt.key]; got.KeyName != wantKey {
+			t.Errorf("user %q with key %q: KeyName = %q, want %q", tt.user, tt.key, got.KeyName, wantKey)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"llm_proxy/backend"
+)
+
+// TenantOptions describes how the requests of one tenant are recognized and
+// limited
+type TenantOptions struct {
+	Name              string
+	APIKeys           []string // Keys the tenant's clients present
+	PathPrefix        string   // Path prefix, e.g. "/team-a", the tenant's clients send requests under
+	RequestsPerMinute int      // Requests the tenant may make per minute (0 = no limit)
+}
+
+// tenant is a TenantOptions with its request count for the current minute
+type tenant struct {
+	TenantOptions
+
+	mu     sync.Mutex
+	window time.Time // Start of the minute being counted
+	count  int
+}
+
+// Tenants middleware works out which tenant made a request, from the API key
+// it presents or the path prefix it was sent under, and stores the tenant on
+// the request context. The prefix is removed from the path, so
+// "/team-a/api/chat" is handled as "/api/chat". Requests presenting the key
+// of one tenant under the prefix of another are refused with 403, and
+// requests over the tenant's limit with 429. Requests of no tenant pass
+// through unchanged.
+func Tenants(options []TenantOptions) func(http.Handler) http.Handler {
+	tenants := make([]*tenant, 0, len(options))
+	for _, opts := range options {
+		tenants = append(tenants, &tenant{TenantOptions: opts})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var byKey, byPrefix *tenant
+			if key := clientKeyFromRequest(r); key != "" {
+				for _, t := range tenants {
+					if slices.Contains(t.APIKeys, key) {
+						byKey = t
+						break
+					}
+				}
+			}
+			for _, t := range tenants {
+				if t.PathPrefix != "" && (r.URL.Path == t.PathPrefix || strings.HasPrefix(r.URL.Path, t.PathPrefix+"/")) {
+					byPrefix = t
+					break
+				}
+			}
+			if byKey != nil && byPrefix != nil && byKey != byPrefix {
+				writeJSONError(w, http.StatusForbidden, fmt.Sprintf("API key does not belong to tenant %s", byPrefix.Name))
+				return
+			}
+
+			t := byKey
+			if byPrefix != nil {
+				t = byPrefix
+				u := *r.URL
+				u.Path = strings.TrimPrefix(u.Path, t.PathPrefix)
+				if u.Path == "" {
+					u.Path = "/"
+				}
+				u.RawPath = ""
+				r = r.Clone(r.Context())
+				r.URL = &u
+			}
+			if t == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if wait, ok := t.allow(time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+				writeJSONError(w, http.StatusTooManyRequests, fmt.Sprintf("tenant %s is over its limit of %d requests per minute", t.Name, t.RequestsPerMinute))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(backend.WithTenant(r.Context(), t.Name)))
+		})
+	}
+}
+
+// allow counts a request made at now and reports whether it is within the
+// tenant's limit. If not, it also returns how long until the next minute
+// starts.
+func (t *tenant) allow(now time.Time) (time.Duration, bool) {
+	if t.RequestsPerMinute <= 0 {
+		return 0, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.window) >= time.Minute {
+		t.window = now.Truncate(time.Minute)
+		t.count = 0
+	}
+	if t.count >= t.RequestsPerMinute {
+		return t.window.Add(time.Minute).Sub(now), false
+	}
+	t.count++
+	return 0, true
+}
+
+// writeJSONError sends a JSON error in the format of the proxy's API errors
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
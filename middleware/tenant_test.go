@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"llm_proxy/backend"
+)
+
+func TestTenantsIdentifiesTenants(t *testing.T) {
+	var gotTenant, gotPath string
+	handler := Tenants([]TenantOptions{
+		{Name: "team-a", APIKeys: []string{"key-a"}, PathPrefix: "/team-a"},
+		{Name: "team-b", APIKeys: []string{"key-b"}},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = backend.TenantFromContext(r.Context())
+		gotPath = r.URL.Path
+	}))
+
+	tests := []struct {
+		path, key          string
+		status             int
+		wantTenant, wantTo string
+	}{
+		{"/api/chat", "", http.StatusOK, "", "/api/chat"},
+		{"/api/chat", "key-b", http.StatusOK, "team-b", "/api/chat"},
+		{"/team-a/api/chat", "", http.StatusOK, "team-a", "/api/chat"},
+		{"/team-a/api/chat", "key-a", http.StatusOK, "team-a", "/api/chat"},
+		{"/team-ab/api/chat", "", http.StatusOK, "", "/team-ab/api/chat"},
+		{"/team-a/api/chat", "key-b", http.StatusForbidden, "", ""},
+	}
+	for _, tt := range tests {
+		gotTenant, gotPath = "", ""
+		req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		if tt.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status || gotTenant != tt.wantTenant || gotPath != tt.wantTo {
+			t.Errorf("%s with key %q: status %d, tenant %q, path %q; want %d, %q, %q",
+				tt.path, tt.key, rec.Code, gotTenant, gotPath, tt.status, tt.wantTenant, tt.wantTo)
+		}
+	}
+}
+
+func TestTenantLimitsRequestsPerMinute(t *testing.T) {
+	tenant := &tenant{TenantOptions: TenantOptions{Name: "team-a", RequestsPerMinute: 2}}
+	start := time.Date(2026, 10, 17, 12, 0, 10, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, ok := tenant.allow(start); !ok {
+			t.Fatalf("request %d refused, want allowed", i+1)
+		}
+	}
+	if wait, ok := tenant.allow(start); ok || wait != 50*time.Second {
+		t.Fatalf("third request: allowed %v, wait %v; want refused until the next minute", ok, wait)
+	}
+	if _, ok := tenant.allow(start.Add(50 * time.Second)); !ok {
+		t.Fatal("request in the next minute refused")
+	}
+}
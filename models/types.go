@@ -16,11 +16,14 @@ type GenerateRequest struct {
 	Stream    bool                   `json:"stream,omitempty"`
 	Options   map[string]interface{} `json:"options,omitempty"`
 	Context   []int                  `json:"context,omitempty"`
-	Format    string                 `json:"format,omitempty"`
+	Format    json.RawMessage        `json:"format,omitempty"` // "json" or a JSON schema object
 	System    string                 `json:"system,omitempty"`
 	Template  string                 `json:"template,omitempty"`
 	Raw       bool                   `json:"raw,omitempty"`
-	KeepAlive string                 `json:"keep_alive,omitempty"`
+	KeepAlive interface{}            `json:"keep_alive,omitempty"` // Duration string ("5m") or seconds
+	Images    []string               `json:"images,omitempty"`     // Base64-encoded images for vision models
+	Think     interface{}            `json:"think,omitempty"`      // true/false, or "low"/"medium"/"high"
+	Extra     map[string]interface{} `json:"extra,omitempty"`      // Backend-specific parameters, e.g. vLLM's guided_json
 }
 
 // GenerateResponse represents an Ollama generate response
@@ -28,6 +31,7 @@ type GenerateResponse struct {
 	Model              string    `json:"model"`
 	CreatedAt          time.Time `json:"created_at"`
 	Response           string    `json:"response"`
+	Thinking           string    `json:"thinking,omitempty"`
 	Done               bool      `json:"done"`
 	DoneReason         string    `json:"done_reason,omitempty"`
 	Context            []int     `json:"context,omitempty"`
@@ -37,6 +41,11 @@ type GenerateResponse struct {
 	PromptEvalDuration int64     `json:"prompt_eval_duration,omitempty"`
 	EvalCount          int       `json:"eval_count,omitempty"`
 	EvalDuration       int64     `json:"eval_duration,omitempty"`
+
+	// Usage is the token usage from an OpenAI-compatible backend, as
+	// reported or as counted by the proxy; nil means the counts above are
+	// placeholders
+	Usage *OpenAIUsage `json:"-"`
 }
 
 // ChatRequest represents an Ollama chat request
@@ -45,11 +54,23 @@ type ChatRequest struct {
 	Messages  []Message                  `json:"messages"`
 	Stream    bool                       `json:"stream,omitempty"`
 	Options   map[string]interface{}     `json:"options,omitempty"`
-	Format    string                     `json:"format,omitempty"`
+	Format    json.RawMessage            `json:"format,omitempty"` // "json" or a JSON schema object
 	Template  string                     `json:"template,omitempty"`
 	Tools     []interface{}              `json:"tools,omitempty"`
-	KeepAlive string                     `json:"keep_alive,omitempty"`
+	KeepAlive interface{}                `json:"keep_alive,omitempty"` // Duration string ("5m") or seconds
 	OpenAIRaw map[string]json.RawMessage `json:"-"`
+
+	// ToolChoice is "auto", "none", "required", or an OpenAI-style
+	// {"type":"function","function":{"name":...}} forcing one tool
+	ToolChoice        interface{} `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool       `json:"parallel_tool_calls,omitempty"`
+
+	// Think enables or disables reasoning: true/false, or "low"/"medium"/"high"
+	Think interface{} `json:"think,omitempty"`
+
+	// Extra holds backend-specific parameters with no Ollama equivalent,
+	// e.g. vLLM's guided_json. Backends that don't know them ignore them.
+	Extra map[string]interface{} `json:"extra,omitempty"`
 }
 
 // Message represents a chat message
@@ -59,6 +80,12 @@ type Message struct {
 	Thinking   string        `json:"thinking,omitempty"`
 	ToolCalls  []interface{} `json:"tool_calls,omitempty"`
 	ToolCallID string        `json:"tool_call_id,omitempty"`
+	Images     []string      `json:"images,omitempty"` // Base64-encoded images (Ollama format)
+
+	// ReasoningContent is Thinking in OpenAI format, set only on messages
+	// written to OpenAI clients. Incoming reasoning_content (or reasoning)
+	// is read into Thinking.
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 
 	// RawContent preserves the original JSON value for OpenAI multimodal
 	// content arrays. Content remains the flattened text view used by Ollama
@@ -67,6 +94,14 @@ type Message struct {
 	RawContent json.RawMessage `json:"-"`
 }
 
+// AddToolCalls adds the tool calls of one chunk of a streamed response to
+// the message combining them. Each chunk carries calls of its own (Ollama
+// sends one call per chunk; other backends send all of them in one), so
+// they are appended rather than replacing those of earlier chunks.
+func (m *Message) AddToolCalls(toolCalls []interface{}) {
+	m.ToolCalls = append(m.ToolCalls, toolCalls...)
+}
+
 // SetContent replaces the text content and clears any preserved raw OpenAI
 // content value, because the raw value no longer represents this message.
 func (m *Message) SetContent(content string) {
@@ -81,7 +116,8 @@ func (m *Message) SetContent(content string) {
 func (m *Message) UnmarshalJSON(data []byte) error {
 	type messageAlias Message
 	aux := struct {
-		Content json.RawMessage `json:"content"`
+		Content   json.RawMessage `json:"content"`
+		Reasoning string          `json:"reasoning"`
 		*messageAlias
 	}{
 		messageAlias: (*messageAlias)(m),
@@ -90,6 +126,14 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	if m.Thinking == "" {
+		m.Thinking = m.ReasoningContent
+	}
+	if m.Thinking == "" {
+		m.Thinking = aux.Reasoning
+	}
+	m.ReasoningContent = ""
+
 	m.RawContent = nil
 	if len(aux.Content) == 0 || string(aux.Content) == "null" {
 		m.Content = ""
@@ -143,12 +187,16 @@ func (m Message) MarshalJSON() ([]byte, error) {
 		Thinking   string          `json:"thinking,omitempty"`
 		ToolCalls  []interface{}   `json:"tool_calls,omitempty"`
 		ToolCallID string          `json:"tool_call_id,omitempty"`
+		Images     []string        `json:"images,omitempty"`
+		Reasoning  string          `json:"reasoning_content,omitempty"`
 	}{
 		Role:       m.Role,
 		Content:    content,
 		Thinking:   m.Thinking,
 		ToolCalls:  m.ToolCalls,
 		ToolCallID: m.ToolCallID,
+		Images:     m.Images,
+		Reasoning:  m.ReasoningContent,
 	}
 	return json.Marshal(aux)
 }
@@ -293,6 +341,61 @@ func modelDetailsEmpty(d ModelDetails) bool {
 		d.EmbeddingLength == 0
 }
 
+// EmbedInput is an embeddings input. On the wire it may be a single string or
+// an array of strings; internally it is always a slice.
+type EmbedInput []string
+
+// UnmarshalJSON accepts either a string or an array of strings. Token-array
+// inputs are not supported.
+func (in *EmbedInput) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*in = EmbedInput{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("input must be a string or an array of strings")
+	}
+	*in = many
+	return nil
+}
+
+// EmbedRequest represents an Ollama /api/embed request
+type EmbedRequest struct {
+	Model      string                 `json:"model"`
+	Input      EmbedInput             `json:"input"`
+	Truncate   *bool                  `json:"truncate,omitempty"`
+	Options    map[string]interface{} `json:"options,omitempty"`
+	KeepAlive  interface{}            `json:"keep_alive,omitempty"` // Duration string ("5m") or seconds
+	Dimensions int                    `json:"dimensions,omitempty"`
+}
+
+// EmbedResponse represents an Ollama /api/embed response
+type EmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration,omitempty"`
+	LoadDuration    int64       `json:"load_duration,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+// PsResponse represents an Ollama /api/ps response
+type PsResponse struct {
+	Models []RunningModel `json:"models"`
+}
+
+// RunningModel represents one loaded model in an /api/ps response
+type RunningModel struct {
+	Name      string       `json:"name"`
+	Model     string       `json:"model"`
+	Size      int64        `json:"size"`
+	Digest    string       `json:"digest"`
+	Details   ModelDetails `json:"details"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	SizeVRAM  int64        `json:"size_vram"`
+}
+
 // OpenAI API types
 
 // OpenAICompletionRequest represents an OpenAI completion request
@@ -306,22 +409,40 @@ type OpenAICompletionRequest struct {
 	Stop             interface{} `json:"stop,omitempty"`
 	FrequencyPenalty float64     `json:"frequency_penalty,omitempty"`
 	PresencePenalty  float64     `json:"presence_penalty,omitempty"`
+	ResponseFormat   interface{} `json:"response_format,omitempty"`
 	CachePrompt      bool        `json:"cache_prompt,omitempty"`
+
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+// OpenAIStreamOptions represents OpenAI stream_options
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // OpenAIChatRequest represents an OpenAI chat request
 type OpenAIChatRequest struct {
-	Model            string        `json:"model"`
-	Messages         []Message     `json:"messages"`
-	Stream           bool          `json:"stream,omitempty"`
-	MaxTokens        int           `json:"max_tokens,omitempty"`
-	Temperature      float64       `json:"temperature,omitempty"`
-	TopP             float64       `json:"top_p,omitempty"`
-	Stop             interface{}   `json:"stop,omitempty"`
-	FrequencyPenalty float64       `json:"frequency_penalty,omitempty"`
-	PresencePenalty  float64       `json:"presence_penalty,omitempty"`
-	Tools            []interface{} `json:"tools,omitempty"`
-	CachePrompt      bool          `json:"cache_prompt,omitempty"`
+	Model             string        `json:"model"`
+	Messages          []Message     `json:"messages"`
+	Stream            bool          `json:"stream,omitempty"`
+	MaxTokens         int           `json:"max_tokens,omitempty"`
+	Temperature       float64       `json:"temperature,omitempty"`
+	TopP              float64       `json:"top_p,omitempty"`
+	Stop              interface{}   `json:"stop,omitempty"`
+	FrequencyPenalty  float64       `json:"frequency_penalty,omitempty"`
+	PresencePenalty   float64       `json:"presence_penalty,omitempty"`
+	Tools             []interface{} `json:"tools,omitempty"`
+	ToolChoice        interface{}   `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool         `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat    interface{}   `json:"response_format,omitempty"`
+	ReasoningEffort   string        `json:"reasoning_effort,omitempty"`
+
+	// ChatTemplateKwargs is the llama.cpp/vLLM extension used to switch
+	// thinking on or off (enable_thinking) for hybrid reasoning models
+	ChatTemplateKwargs map[string]interface{} `json:"chat_template_kwargs,omitempty"`
+
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	CachePrompt   bool                 `json:"cache_prompt,omitempty"`
 }
 
 // OpenAICompletionResponse represents an OpenAI completion response
@@ -332,6 +453,7 @@ type OpenAICompletionResponse struct {
 	Model   string                   `json:"model"`
 	Choices []OpenAICompletionChoice `json:"choices"`
 	Usage   *OpenAIUsage             `json:"usage,omitempty"`
+	Timings *LlamaCppTimings         `json:"timings,omitempty"`
 }
 
 // OpenAICompletionChoice represents a completion choice
@@ -349,6 +471,7 @@ type OpenAIChatResponse struct {
 	Model   string             `json:"model"`
 	Choices []OpenAIChatChoice `json:"choices"`
 	Usage   *OpenAIUsage       `json:"usage,omitempty"`
+	Timings *LlamaCppTimings   `json:"timings,omitempty"`
 }
 
 // OpenAIChatChoice represents a chat choice
@@ -359,11 +482,68 @@ type OpenAIChatChoice struct {
 	FinishReason string   `json:"finish_reason,omitempty"`
 }
 
+// OpenAIEmbeddingRequest represents an OpenAI embeddings request
+type OpenAIEmbeddingRequest struct {
+	Model          string     `json:"model"`
+	Input          EmbedInput `json:"input"`
+	EncodingFormat string     `json:"encoding_format,omitempty"`
+	Dimensions     int        `json:"dimensions,omitempty"`
+	User           string     `json:"user,omitempty"`
+}
+
+// OpenAIEmbeddingResponse represents an OpenAI embeddings response
+type OpenAIEmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []OpenAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  *OpenAIUsage      `json:"usage,omitempty"`
+}
+
+// OpenAIEmbedding represents one vector in an OpenAI embeddings response
+type OpenAIEmbedding struct {
+	Object    string    `json:"object"`
+	Embedding []float64 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
 // OpenAIUsage represents token usage information
 type OpenAIUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                        `json:"prompt_tokens"`
+	CompletionTokens    int                        `json:"completion_tokens"`
+	TotalTokens         int                        `json:"total_tokens"`
+	PromptTokensDetails *OpenAIPromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+	Cost                float64                    `json:"cost,omitempty"` // What the request cost, reported by OpenRouter
+}
+
+// OpenAIPromptTokensDetails breaks down the prompt tokens in OpenAIUsage
+type OpenAIPromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"` // Prompt tokens served from the prompt cache
+}
+
+// CachedTokens returns the number of prompt tokens served from the
+// backend's prompt cache, or 0 if the backend didn't say
+func (u *OpenAIUsage) CachedTokens() int {
+	if u == nil || u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
+// OpenAIErrorResponse represents an OpenAI error response
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// OpenAIError describes what went wrong in an OpenAIErrorResponse
+type OpenAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// LlamaCppTimings is the timings block llama.cpp adds to OpenAI responses
+type LlamaCppTimings struct {
+	CacheN  int `json:"cache_n"`  // Prompt tokens reused from the cache
+	PromptN int `json:"prompt_n"` // Prompt tokens evaluated
 }
 
 // ErrorResponse represents an error response
@@ -82,3 +82,65 @@ func TestMessagePreservesImageURLContentPart(t *testing.T) {
 		t.Fatalf("marshaled content = %#v, want original image_url part", got.Content)
 	}
 }
+
+func TestMessageRoundTripsOllamaImages(t *testing.T) {
+	body := `{"role":"user","content":"what is this?","images":["iVBORw0KGgo="]}`
+
+	var msg Message
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(msg.Images) != 1 || msg.Images[0] != "iVBORw0KGgo=" {
+		t.Fatalf("images = %v", msg.Images)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if string(data) != body {
+		t.Fatalf("marshaled = %s, want %s", data, body)
+	}
+}
+
+func TestMessageReadsReasoningContentAsThinking(t *testing.T) {
+	for _, body := range []string{
+		`{"role":"assistant","content":"42","reasoning_content":"let me think"}`,
+		`{"role":"assistant","content":"42","reasoning":"let me think"}`,
+	} {
+		var msg Message
+		if err := json.Unmarshal([]byte(body), &msg); err != nil {
+			t.Fatalf("unmarshal failed: %v", err)
+		}
+		if msg.Thinking != "let me think" || msg.ReasoningContent != "" {
+			t.Fatalf("message from %s = %+v", body, msg)
+		}
+	}
+
+	data, err := json.Marshal(Message{Role: "assistant", Content: "42", ReasoningContent: "let me think"})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if want := `{"role":"assistant","content":"42","reasoning_content":"let me think"}`; string(data) != want {
+		t.Fatalf("marshaled = %s, want %s", data, want)
+	}
+}
+
+func TestKeepAliveAcceptsDurationOrSeconds(t *testing.T) {
+	for _, body := range []string{
+		`{"model":"m","prompt":"hi","keep_alive":"10m"}`,
+		`{"model":"m","prompt":"hi","keep_alive":300}`,
+	} {
+		var req GenerateRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatalf("unmarshal %s failed: %v", body, err)
+		}
+		data, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("marshal failed: %v", err)
+		}
+		if string(data) != body {
+			t.Fatalf("marshaled = %s, want %s", data, body)
+		}
+	}
+}
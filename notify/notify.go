@@ -0,0 +1,247 @@
+// Package notify posts alerts about logged requests to a Slack-compatible
+// webhook.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"llm_proxy/database"
+)
+
+// maxErrorLength is how much of a request's error is quoted in a message
+const maxErrorLength = 300
+
+// Rules say which requests trigger a notification
+type Rules struct {
+	OnBackendFailure bool          // Notify for every request that failed with a 5xx status
+	ErrorRate        float64       // Percentage of failed requests in the window (0 = off)
+	Latency          time.Duration // Average latency of the requests in the window (0 = off)
+	Cost             float64       // Estimated cost of the requests in the window (0 = off)
+	Window           time.Duration // How far back the thresholds look
+	MinRequests      int           // Requests needed in the window before the error rate and latency are checked
+}
+
+// Message is the JSON body posted to the webhook. Slack and compatible
+// services show Text and ignore the other fields.
+type Message struct {
+	Text       string `json:"text"`
+	Event      string `json:"event"` // "backend_failure", "error_rate", "latency" or "cost"
+	RequestID  int64  `json:"request_id"`
+	DetailsURL string `json:"details_url,omitempty"`
+}
+
+// sample is what the thresholds need to know about a request
+type sample struct {
+	at        time.Time
+	failed    bool
+	latencyMs int64
+	cost      float64
+}
+
+// Notifier watches logged requests and posts a Message when one fails or a
+// threshold is crossed. A threshold notifies once when it is crossed and
+// again only after the value has dropped back below it.
+type Notifier struct {
+	webhookURL string
+	detailsURL string
+	rules      Rules
+	client     *http.Client
+	now        func() time.Time
+
+	samples []sample
+	above   map[string]bool // Thresholds currently crossed, by event
+	pending sync.WaitGroup
+}
+
+// NewNotifier creates a notifier that posts to webhookURL. detailsURL is the
+// base URL of the web UI used to link to a request's details page (empty =
+// no links).
+func NewNotifier(webhookURL string, detailsURL string, rules Rules) *Notifier {
+	return &Notifier{
+		webhookURL: webhookURL,
+		detailsURL: strings.TrimSuffix(detailsURL, "/"),
+		rules:      rules,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+		above:      make(map[string]bool),
+	}
+}
+
+// SetTimeout sets how long to wait for the webhook to respond
+func (n *Notifier) SetTimeout(timeout time.Duration) {
+	n.client.Timeout = timeout
+}
+
+// Run observes entries until the channel is closed, then waits for the
+// notifications being sent.
+func (n *Notifier) Run(entries <-chan database.LogEntry) {
+	for entry := range entries {
+		n.Observe(entry)
+	}
+	n.Wait()
+}
+
+// Wait blocks until the notifications being sent have been delivered or
+// have failed
+func (n *Notifier) Wait() {
+	n.pending.Wait()
+}
+
+// Observe checks a logged request against the rules and sends any
+// notifications it triggers in the background. It must not be called
+// concurrently.
+func (n *Notifier) Observe(entry database.LogEntry) {
+	if n.rules.OnBackendFailure && entry.StatusCode >= 500 {
+		text := fmt.Sprintf("Backend failure: %s %s", entry.Method, entry.Endpoint)
+		if entry.Model != "" {
+			text += fmt.Sprintf(" (%s)", entry.Model)
+		}
+		text += fmt.Sprintf(" returned %d after %s", entry.StatusCode, formatMs(entry.LatencyMs))
+		if entry.Error != "" {
+			text += ": " + truncate(entry.Error, maxErrorLength)
+		}
+		n.send("backend_failure", text, entry.ID)
+	}
+
+	if n.rules.ErrorRate <= 0 && n.rules.Latency <= 0 && n.rules.Cost <= 0 {
+		return
+	}
+
+	// Slide the window
+	now := n.now()
+	n.samples = append(n.samples, sample{
+		at:        now,
+		failed:    entry.Error != "" || entry.StatusCode >= 400,
+		latencyMs: entry.LatencyMs,
+		cost:      entry.Cost,
+	})
+	cutoff := now.Add(-n.rules.Window)
+	expired := 0
+	for expired < len(n.samples) && n.samples[expired].at.Before(cutoff) {
+		expired++
+	}
+	n.samples = append(n.samples[:0], n.samples[expired:]...)
+
+	var failed int
+	var latencyMs int64
+	var cost float64
+	for _, s := range n.samples {
+		if s.failed {
+			failed++
+		}
+		latencyMs += s.latencyMs
+		cost += s.cost
+	}
+	total := len(n.samples)
+	enough := total >= n.rules.MinRequests
+	window := formatWindow(n.rules.Window)
+
+	if n.rules.ErrorRate > 0 {
+		rate := float64(failed) * 100 / float64(total)
+		n.check("error_rate", enough && rate > n.rules.ErrorRate, entry.ID, func() string {
+			return fmt.Sprintf("Error rate is %.1f%% (%d of %d requests in the last %s failed), above the %g%% threshold",
+				rate, failed, total, window, n.rules.ErrorRate)
+		})
+	}
+	if n.rules.Latency > 0 {
+		average := latencyMs / int64(total)
+		n.check("latency", enough && time.Duration(average)*time.Millisecond > n.rules.Latency, entry.ID, func() string {
+			return fmt.Sprintf("Average latency is %s over %d requests in the last %s, above the %s threshold",
+				formatMs(average), total, window, n.rules.Latency)
+		})
+	}
+	if n.rules.Cost > 0 {
+		n.check("cost", cost > n.rules.Cost, entry.ID, func() string {
+			return fmt.Sprintf("Estimated cost is %.4f for %d requests in the last %s, above the %g threshold",
+				cost, total, window, n.rules.Cost)
+		})
+	}
+}
+
+// check notifies when a threshold is first crossed
+func (n *Notifier) check(event string, crossed bool, requestID int64, text func() string) {
+	if crossed && !n.above[event] {
+		n.send(event, text(), requestID)
+	}
+	n.above[event] = crossed
+}
+
+// send posts a message in the background, logging failures
+func (n *Notifier) send(event string, text string, requestID int64) {
+	msg := Message{Event: event, RequestID: requestID}
+	if n.detailsURL != "" && requestID > 0 {
+		msg.DetailsURL = fmt.Sprintf("%s/logs/details?id=%d", n.detailsURL, requestID)
+		msg.Text = fmt.Sprintf("%s - <%s|request %d>", text, msg.DetailsURL, requestID)
+	} else {
+		msg.Text = fmt.Sprintf("%s - request %d", text, requestID)
+	}
+
+	n.pending.Add(1)
+	go func() {
+		defer n.pending.Done()
+		if err := n.post(msg); err != nil {
+			log.Printf("Failed to send %s notification: %v", event, err)
+		}
+	}()
+}
+
+// post sends a message to the webhook
+func (n *Notifier) post(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// formatMs formats a latency in milliseconds, e.g. "850ms" or "12.3s"
+func formatMs(ms int64) string {
+	if ms < 1000 {
+		return fmt.Sprintf("%dms", ms)
+	}
+	return fmt.Sprintf("%.1fs", float64(ms)/1000)
+}
+
+// formatWindow formats the window length, e.g. "5 minutes" or "90 seconds"
+func formatWindow(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return plural(int(d/time.Minute), "minute")
+	}
+	return plural(int(d/time.Second), "second")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// truncate shortens s to at most n bytes, marking the cut
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "…"
+}
@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"llm_proxy/database"
+)
+
+// webhookRecorder is a test webhook that records the messages posted to it
+type webhookRecorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func newWebhookRecorder(t *testing.T) (*webhookRecorder, string) {
+	t.Helper()
+	rec := &webhookRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		rec.mu.Lock()
+		rec.messages = append(rec.messages, msg)
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return rec, server.URL
+}
+
+// events returns the events posted so far, sorted since notifications are
+// sent concurrently
+func (rec *webhookRecorder) events() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	events := make([]string, 0, len(rec.messages))
+	for _, msg := range rec.messages {
+		events = append(events, msg.Event)
+	}
+	slices.Sort(events)
+	return events
+}
+
+func TestNotifierReportsBackendFailures(t *testing.T) {
+	rec, webhookURL := newWebhookRecorder(t)
+	n := NewNotifier(webhookURL, "http://proxy:8080/", Rules{OnBackendFailure: true})
+
+	n.Observe(database.LogEntry{ID: 1, Method: "POST", Endpoint: "/api/chat", Model: "llama3", StatusCode: 200})
+	n.Observe(database.LogEntry{ID: 2, Method: "POST", Endpoint: "/api/chat", Model: "llama3", StatusCode: 400, Error: "bad request"})
+	n.Observe(database.LogEntry{ID: 3, Method: "POST", Endpoint: "/api/chat", Model: "llama3", StatusCode: 503, LatencyMs: 1500, Error: "backend queue is full"})
+	n.Wait()
+
+	if len(rec.messages) != 1 {
+		t.Fatalf("got %d messages, want 1: %+v", len(rec.messages), rec.messages)
+	}
+	msg := rec.messages[0]
+	if msg.Event != "backend_failure" || msg.RequestID != 3 || msg.DetailsURL != "http://proxy:8080/logs/details?id=3" {
+		t.Fatalf("message = %+v", msg)
+	}
+	want := "Backend failure: POST /api/chat (llama3) returned 503 after 1.5s: backend queue is full - <http://proxy:8080/logs/details?id=3|request 3>"
+	if msg.Text != want {
+		t.Fatalf("text = %q, want %q", msg.Text, want)
+	}
+}
+
+func TestNotifierThresholdsNotifyOncePerCrossing(t *testing.T) {
+	rec, webhookURL := newWebhookRecorder(t)
+	n := NewNotifier(webhookURL, "", Rules{
+		ErrorRate:   50,
+		Latency:     time.Second,
+		Cost:        1,
+		Window:      time.Minute,
+		MinRequests: 2,
+	})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	observe := func(id int64, status int, latencyMs int64, cost float64) {
+		n.Observe(database.LogEntry{ID: id, StatusCode: status, LatencyMs: latencyMs, Cost: cost})
+		n.Wait()
+	}
+
+	// One failure isn't enough requests to judge the error rate
+	observe(1, 500, 100, 0.1)
+	if events := rec.events(); len(events) != 0 {
+		t.Fatalf("events = %v, want none below min_requests", events)
+	}
+	observe(2, 500, 100, 0.1)
+	observe(3, 500, 100, 0.1)
+	if events := rec.events(); strings.Join(events, ",") != "error_rate" {
+		t.Fatalf("events = %v, want one error_rate notification", events)
+	}
+
+	// Slow, expensive requests push the average latency and cost over
+	observe(4, 200, 5000, 0.8)
+	if events := rec.events(); strings.Join(events, ",") != "cost,error_rate,latency" {
+		t.Fatalf("events = %v, want latency and cost notifications", events)
+	}
+
+	// Once the window has moved on, the thresholds can be crossed again
+	now = now.Add(2 * time.Minute)
+	observe(5, 200, 100, 0)
+	observe(6, 500, 100, 0)
+	observe(7, 500, 100, 0)
+	if events := rec.events(); strings.Join(events, ",") != "cost,error_rate,error_rate,latency" {
+		t.Fatalf("events = %v, want a second error_rate notification", events)
+	}
+	if text := rec.messages[len(rec.messages)-1].Text; !strings.HasPrefix(text, "Error rate is 66.7% (2 of 3 requests in the last 1 minute failed)") || !strings.HasSuffix(text, " - request 7") {
+		t.Fatalf("text = %q", text)
+	}
+}
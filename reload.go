@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"llm_proxy/backend"
+	"llm_proxy/config"
+	"llm_proxy/database"
+)
+
+// watchConfig reloads the configuration when a signal arrives on reload and,
+// unless server.config_watch_interval is -1, when the config file's
+// modification time changes. Each reload is recorded in the audit log. It
+// returns when done is closed.
+func watchConfig(path string, cfg *config.Config, db *database.DB, aliases *backend.AliasBackend, reload <-chan os.Signal, done <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval := cfg.Server.ConfigWatchInterval; interval > 0 {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	lastModified := configModTime(path)
+	for {
+		select {
+		case <-reload:
+			log.Printf("Received SIGHUP, reloading configuration")
+			lastModified = configModTime(path)
+			reloadConfig(path, cfg, db, aliases, "SIGHUP")
+		case <-tick:
+			modified := configModTime(path)
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+			log.Printf("%s changed, reloading configuration", path)
+			reloadConfig(path, cfg, db, aliases, "file watch")
+		case <-done:
+			return
+		}
+	}
+}
+
+// reloadConfig applies the hot-reloadable settings from path. Invalid files
+// are reported and the running configuration is kept. trigger says what
+// asked for the reload, for the audit log.
+func reloadConfig(path string, cfg *config.Config, db *database.DB, aliases *backend.AliasBackend, trigger string) {
+	restartRequired, err := cfg.Reload(path)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the current settings: %v", err)
+		auditConfigReload(db, trigger, fmt.Sprintf("%s: failed: %v", path, err))
+		return
+	}
+	aliases.SetAliases(cfg.Current().ModelAliases)
+	log.Printf("Configuration reloaded")
+	detail := path + ": reloaded"
+	if len(restartRequired) > 0 {
+		log.Printf("Config changes to [%s] need a restart to take effect", strings.Join(restartRequired, "], ["))
+		detail += fmt.Sprintf("; [%s] need a restart", strings.Join(restartRequired, "], ["))
+	}
+	auditConfigReload(db, trigger, detail)
+}
+
+// auditConfigReload records a config reload in the audit log
+func auditConfigReload(db *database.DB, trigger, detail string) {
+	entry := database.AuditEntry{Actor: trigger, Action: "config_reload", Detail: detail}
+	if err := db.Audit(entry); err != nil {
+		log.Printf("Failed to record config reload in the audit log: %v", err)
+	}
+}
+
+// configModTime returns the config file's modification time, or the zero
+// time if it can't be read.
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}